@@ -0,0 +1,155 @@
+package cassette
+
+// Record and replay of script runner interactions.
+//
+// A Recorder wraps a transport and saves every rendered script with its args, raw output and exit
+// code, as well as every file operation and its result, to a cassette file. A Replayer serves a
+// cassette back without any Hyper-V host, so acceptance tests can run offline.
+//
+// Interactions are matched on their operation and request, the rendered script for scripts or the
+// paths for file operations. Identical requests are answered in the order they were recorded, which
+// keeps replays deterministic when Terraform runs resources in parallel.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+)
+
+const (
+	// EnvPath names the environment variable holding the path of the cassette file.
+	EnvPath = "HYPERV_CASSETTE"
+
+	// EnvMode names the environment variable holding ModeRecord or ModeReplay.
+	EnvMode = "HYPERV_CASSETTE_MODE"
+
+	// ModeRecord records the interactions with the configured transport.
+	ModeRecord = "record"
+
+	// ModeReplay serves the interactions of the cassette instead of connecting to a host.
+	ModeReplay = "replay"
+)
+
+// Operations recorded in a cassette.
+const (
+	OperationScript                = "script"
+	OperationUploadFile            = "upload_file"
+	OperationUploadDirectory       = "upload_directory"
+	OperationFileExists            = "file_exists"
+	OperationDirectoryExists       = "directory_exists"
+	OperationDeleteFileOrDirectory = "delete_file_or_directory"
+)
+
+// Runner is a script runner that exposes the raw output of scripts, as implemented by every transport.
+type Runner interface {
+	RunScript(ctx context.Context, command string) (stdout, stderr string, exitCode int, err error)
+	UploadFile(ctx context.Context, filePath string, remoteFilePath string) (resolvedRemoteFilePath string, err error)
+	UploadDirectory(ctx context.Context, rootPath string, excludeList []string) (remoteRootPath string, remoteAbsoluteFilePaths []string, err error)
+	FileExists(ctx context.Context, remoteFilePath string) (exists bool, err error)
+	DirectoryExists(ctx context.Context, remoteDirectoryPath string) (exists bool, err error)
+	DeleteFileOrDirectory(ctx context.Context, remotePath string) (err error)
+}
+
+// StreamingRunner is a Runner that also copies the output of a script to output while the script
+// runs, so that its records can be passed on, see commandresult.RecordWriter.
+type StreamingRunner interface {
+	RunScriptStreaming(ctx context.Context, command string, output io.Writer) (stdout, stderr string, exitCode int, err error)
+}
+
+// Interaction is a recorded request and its response.
+type Interaction struct {
+	Operation string `json:"operation"`
+
+	// Request
+	Script      string          `json:"script,omitempty"`
+	Args        json.RawMessage `json:"args,omitempty"` // Template args, for reference only
+	Path        string          `json:"path,omitempty"`
+	RemotePath  string          `json:"remote_path,omitempty"`
+	ExcludeList []string        `json:"exclude_list,omitempty"`
+
+	// Response
+	Stdout       string   `json:"stdout,omitempty"`
+	Stderr       string   `json:"stderr,omitempty"`
+	ExitCode     int      `json:"exit_code,omitempty"`
+	ResolvedPath string   `json:"resolved_path,omitempty"`
+	RemotePaths  []string `json:"remote_paths,omitempty"`
+	Exists       bool     `json:"exists,omitempty"`
+	Error        string   `json:"error,omitempty"`
+}
+
+// key identifies the request of the interaction.
+func (i *Interaction) key() string {
+	return strings.Join(append([]string{i.Operation, i.Script, i.Path, i.RemotePath}, i.ExcludeList...), "\x00")
+}
+
+// describe summarizes the request for error messages.
+func (i *Interaction) describe() string {
+	switch i.Operation {
+	case OperationScript:
+		script := strings.TrimSpace(i.Script)
+		if len(script) > 200 {
+			script = script[:200] + "..."
+		}
+		return fmt.Sprintf("script:\n%s", script)
+	case OperationUploadFile:
+		return fmt.Sprintf("%s %s to %s", i.Operation, i.Path, i.RemotePath)
+	default:
+		return fmt.Sprintf("%s %s", i.Operation, i.Path)
+	}
+}
+
+// Cassette is the content of a cassette file.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Load reads a cassette file.
+func Load(path string) (*Cassette, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	}
+
+	var cassette Cassette
+	if err := json.Unmarshal(content, &cassette); err != nil {
+		return nil, fmt.Errorf("failed to parse cassette %s: %w", path, err)
+	}
+
+	return &cassette, nil
+}
+
+// Save writes the cassette file.
+func (c *Cassette) Save(path string) error {
+	content, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode cassette: %w", err)
+	}
+
+	if err := os.WriteFile(path, append(content, '\n'), 0o600); err != nil {
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+
+	return nil
+}
+
+func renderScript(script *template.Template, args interface{}) (string, error) {
+	var scriptRendered bytes.Buffer
+	if err := script.Execute(&scriptRendered, args); err != nil {
+		return "", fmt.Errorf("failed to render script template: %w", err)
+	}
+
+	return scriptRendered.String(), nil
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	return err.Error()
+}
@@ -0,0 +1,299 @@
+package cassette
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"text/template"
+
+	"github.com/taliesins/terraform-provider-hyperv/api/commandresult"
+	"github.com/taliesins/terraform-provider-hyperv/api/redact"
+)
+
+// fakeRunner answers scripts with a counter so that repeated scripts get different results.
+type fakeRunner struct {
+	calls atomic.Int32
+}
+
+func (f *fakeRunner) RunScript(ctx context.Context, command string) (string, string, int, error) {
+	call := f.calls.Add(1)
+
+	switch {
+	case strings.HasPrefix(command, "fail "):
+		return "", strings.TrimPrefix(command, "fail "), 1, nil
+	case command == "disconnect":
+		return "", "", -1, errors.New("connection reset")
+	}
+
+	return fmt.Sprintf("{\"Name\":%q,\"Call\":%d}", command, call), "", 0, nil
+}
+
+func (f *fakeRunner) UploadFile(ctx context.Context, filePath string, remoteFilePath string) (string, error) {
+	return `C:\Temp\` + remoteFilePath, nil
+}
+
+func (f *fakeRunner) UploadDirectory(ctx context.Context, rootPath string, excludeList []string) (string, []string, error) {
+	return `C:\Temp\upload`, []string{`C:\Temp\upload\a.txt`, `C:\Temp\upload\b.txt`}, nil
+}
+
+func (f *fakeRunner) FileExists(ctx context.Context, remoteFilePath string) (bool, error) {
+	return remoteFilePath == `C:\exists.txt`, nil
+}
+
+func (f *fakeRunner) DirectoryExists(ctx context.Context, remoteDirectoryPath string) (bool, error) {
+	return false, errors.New("access denied")
+}
+
+func (f *fakeRunner) DeleteFileOrDirectory(ctx context.Context, remotePath string) error {
+	return nil
+}
+
+type scriptRunner interface {
+	RunFireAndForgetScript(ctx context.Context, script *template.Template, args interface{}) error
+	RunScriptWithResult(ctx context.Context, script *template.Template, args interface{}, result interface{}) error
+	UploadFile(ctx context.Context, filePath string, remoteFilePath string) (string, error)
+	UploadDirectory(ctx context.Context, rootPath string, excludeList []string) (string, []string, error)
+	FileExists(ctx context.Context, remoteFilePath string) (bool, error)
+	DirectoryExists(ctx context.Context, remoteDirectoryPath string) (bool, error)
+	DeleteFileOrDirectory(ctx context.Context, remotePath string) error
+}
+
+var nameTemplate = template.Must(template.New("name").Parse("{{.Name}}"))
+
+type vm struct {
+	Name string
+	Call int
+}
+
+// session runs the same operations against a recorder and a replayer and returns a transcript.
+func session(t *testing.T, runner scriptRunner) []string {
+	t.Helper()
+
+	ctx := context.Background()
+	var transcript []string
+	add := func(format string, a ...interface{}) {
+		transcript = append(transcript, fmt.Sprintf(format, a...))
+	}
+
+	for _, name := range []string{"web", "db", "web"} {
+		var result vm
+		err := runner.RunScriptWithResult(ctx, nameTemplate, map[string]string{"Name": name}, &result)
+		add("result %s: %+v %v", name, result, err)
+	}
+
+	err := runner.RunFireAndForgetScript(ctx, nameTemplate, map[string]string{"Name": "fail VM is running"})
+	add("fire and forget: %v", err)
+
+	err = runner.RunFireAndForgetScript(ctx, nameTemplate, map[string]string{"Name": "disconnect"})
+	add("disconnect: %v", err)
+
+	resolved, err := runner.UploadFile(ctx, "/tmp/a.iso", "a.iso")
+	add("upload file: %s %v", resolved, err)
+
+	root, paths, err := runner.UploadDirectory(ctx, "/tmp/upload", []string{"*.log"})
+	add("upload directory: %s %v %v", root, paths, err)
+
+	exists, err := runner.FileExists(ctx, `C:\exists.txt`)
+	add("file exists: %t %v", exists, err)
+
+	exists, err = runner.DirectoryExists(ctx, `C:\dir`)
+	add("directory exists: %t %v", exists, err)
+
+	err = runner.DeleteFileOrDirectory(ctx, `C:\exists.txt`)
+	add("delete: %v", err)
+
+	return transcript
+}
+
+func TestReplayServesRecordedInteractions(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	file, err := NewFile(path)
+	if err != nil {
+		t.Fatalf("failed to create cassette: %v", err)
+	}
+
+	recorded := session(t, NewRecorder(file, &fakeRunner{}, nil))
+
+	replayer, err := NewReplayer(path)
+	if err != nil {
+		t.Fatalf("failed to load cassette: %v", err)
+	}
+
+	replayed := session(t, replayer)
+
+	if strings.Join(replayed, "\n") != strings.Join(recorded, "\n") {
+		t.Fatalf("expected replay to match the recording\nrecorded:\n%s\nreplayed:\n%s", strings.Join(recorded, "\n"), strings.Join(replayed, "\n"))
+	}
+
+	if got := replayer.Remaining(); got != 0 {
+		t.Fatalf("expected every interaction to be replayed, %d left", got)
+	}
+}
+
+func TestRecordSavesScriptsAndArgs(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	file, err := NewFile(path)
+	if err != nil {
+		t.Fatalf("failed to create cassette: %v", err)
+	}
+
+	var result vm
+	if err := NewRecorder(file, &fakeRunner{}, nil).RunScriptWithResult(context.Background(), nameTemplate, map[string]string{"Name": "web"}, &result); err != nil {
+		t.Fatalf("expected script to succeed, got %v", err)
+	}
+
+	cassette, err := Load(path)
+	if err != nil {
+		t.Fatalf("failed to load cassette: %v", err)
+	}
+
+	if len(cassette.Interactions) != 1 {
+		t.Fatalf("expected one interaction, got %d", len(cassette.Interactions))
+	}
+
+	interaction := cassette.Interactions[0]
+	if interaction.Operation != OperationScript || interaction.Script != "web" || interaction.Stdout != `{"Name":"web","Call":1}` {
+		t.Fatalf("unexpected interaction %+v", interaction)
+	}
+
+	var args map[string]string
+	if err := json.Unmarshal(interaction.Args, &args); err != nil || args["Name"] != "web" {
+		t.Fatalf("expected the script args to be recorded, got %s (%v)", interaction.Args, err)
+	}
+}
+
+func TestRecordMasksSecrets(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	file, err := NewFile(path)
+	if err != nil {
+		t.Fatalf("failed to create cassette: %v", err)
+	}
+
+	const password = "Pa55w0rd!"
+	recorder := NewRecorder(file, &fakeRunner{}, redact.New(password))
+
+	err = recorder.RunFireAndForgetScript(context.Background(), nameTemplate, map[string]string{"Name": "fail logon failure for " + password})
+	if err == nil || strings.Contains(err.Error(), password) {
+		t.Fatalf("expected the failure with the password masked, got %v", err)
+	}
+
+	var result vm
+	err = recorder.RunScriptWithResult(context.Background(), nameTemplate, map[string]string{"Name": "fail logon failure for " + password}, &result)
+	if err == nil || strings.Contains(err.Error(), password) {
+		t.Fatalf("expected the failure with the password masked, got %v", err)
+	}
+
+	cassette, err := Load(path)
+	if err != nil {
+		t.Fatalf("failed to load cassette: %v", err)
+	}
+
+	if len(cassette.Interactions) != 2 {
+		t.Fatalf("expected two interactions, got %d", len(cassette.Interactions))
+	}
+
+	if interaction := cassette.Interactions[0]; interaction.Stderr != "logon failure for "+redact.Mask || interaction.ExitCode != 1 {
+		t.Fatalf("expected the failure to be recorded with the password masked, got %+v", interaction)
+	}
+
+	for _, interaction := range cassette.Interactions {
+		for _, recorded := range []string{string(interaction.Args), interaction.Stdout, interaction.Stderr, interaction.Error} {
+			if strings.Contains(recorded, password) {
+				t.Fatalf("expected the password to be masked in the recorded output, got %+v", interaction)
+			}
+		}
+	}
+}
+
+func TestReplayWithoutRecordedInteraction(t *testing.T) {
+	t.Parallel()
+
+	replayer := NewReplayerFromCassette(&Cassette{
+		Interactions: []Interaction{
+			{Operation: OperationScript, Script: "web", Stdout: `{"Name":"web"}`},
+		},
+	})
+
+	var result vm
+	err := replayer.RunScriptWithResult(context.Background(), nameTemplate, map[string]string{"Name": "db"}, &result)
+	if err == nil || !strings.Contains(err.Error(), "no recorded interaction") {
+		t.Fatalf("expected a missing interaction error, got %v", err)
+	}
+
+	if err := replayer.RunScriptWithResult(context.Background(), nameTemplate, map[string]string{"Name": "web"}, &result); err != nil {
+		t.Fatalf("expected the recorded interaction to be replayed, got %v", err)
+	}
+
+	err = replayer.RunScriptWithResult(context.Background(), nameTemplate, map[string]string{"Name": "web"}, &result)
+	if err == nil || !strings.Contains(err.Error(), "no recorded interaction left") {
+		t.Fatalf("expected an exhausted interaction error, got %v", err)
+	}
+
+	if _, err := replayer.FileExists(context.Background(), `C:\web.vhdx`); err == nil {
+		t.Fatal("expected a missing interaction error for file operations")
+	}
+}
+
+func TestReplayStreamsRecords(t *testing.T) {
+	t.Parallel()
+
+	replayer := NewReplayerFromCassette(&Cassette{
+		Interactions: []Interaction{
+			{
+				Operation: OperationScript,
+				Script:    "web",
+				Stdout: commandresult.RecordMarker + `{"Stream":"Information","Message":"Exported web"}` + "\n" +
+					commandresult.ResultBeginMarker + "\n" + `{"Name":"web"}` + "\n" + commandresult.ResultEndMarker,
+			},
+		},
+	})
+
+	var records []commandresult.Record
+	var result vm
+	err := replayer.RunStreamingScript(context.Background(), nameTemplate, map[string]string{"Name": "web"}, &result, func(record commandresult.Record) {
+		records = append(records, record)
+	})
+	if err != nil {
+		t.Fatalf("expected the recorded interaction to be replayed, got %v", err)
+	}
+
+	if result.Name != "web" || len(records) != 1 || records[0].Message != "Exported web" {
+		t.Fatalf("expected the recorded result and records, got %+v and %+v", result, records)
+	}
+}
+
+func TestReplayCanceled(t *testing.T) {
+	t.Parallel()
+
+	replayer := NewReplayerFromCassette(&Cassette{
+		Interactions: []Interaction{
+			{Operation: OperationScript, Script: "web", Stdout: `{"Name":"web"}`},
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := replayer.RunFireAndForgetScript(ctx, nameTemplate, map[string]string{"Name": "web"})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	if got := replayer.Remaining(); got != 1 {
+		t.Fatalf("expected a canceled replay to keep the interaction, %d left", got)
+	}
+}
@@ -0,0 +1,237 @@
+package cassette
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"text/template"
+
+	"github.com/taliesins/terraform-provider-hyperv/api/commandresult"
+	"github.com/taliesins/terraform-provider-hyperv/api/redact"
+)
+
+// File is a cassette file being recorded. The file is rewritten after each interaction so that it
+// is complete even if the process is killed.
+type File struct {
+	path string
+
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+// NewFile creates an empty cassette file at path, replacing any previous recording.
+func NewFile(path string) (*File, error) {
+	f := &File{
+		path: path,
+	}
+
+	if err := f.cassette.Save(path); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Append records an interaction.
+func (f *File) Append(interaction Interaction) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.cassette.Interactions = append(f.cassette.Interactions, interaction)
+	return f.cassette.Save(f.path)
+}
+
+// Recorder is a script runner that forwards every operation to a Runner and records it to a File.
+// Several recorders can share a File, the interactions are recorded in the order they complete.
+// Secrets are masked in the recorded output and in the errors returned, as by the transports.
+type Recorder struct {
+	runner   Runner
+	file     *File
+	redactor *redact.Redactor
+}
+
+// NewRecorder creates a recorder forwarding operations to runner, masking the secrets of redactor.
+func NewRecorder(file *File, runner Runner, redactor *redact.Redactor) *Recorder {
+	return &Recorder{
+		runner:   runner,
+		file:     file,
+		redactor: redactor,
+	}
+}
+
+// record masks the output and error of interaction with redactor and records it. The script is the key of the
+// interaction on replay and is recorded as it is.
+func (r *Recorder) record(redactor *redact.Redactor, interaction Interaction) error {
+	if interaction.Args != nil {
+		interaction.Args = json.RawMessage(redactor.String(string(interaction.Args)))
+	}
+	interaction.Stdout = redactor.String(interaction.Stdout)
+	interaction.Stderr = redactor.String(interaction.Stderr)
+	interaction.Error = redactor.String(interaction.Error)
+
+	return r.file.Append(interaction)
+}
+
+// Close closes the runner when it is an io.Closer, the interactions are saved as they are recorded.
+func (r *Recorder) Close() error {
+	if closer, ok := r.runner.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// runScript runs and records a script. Its stdout is copied to output, if any, while it runs when the runner supports
+// it, or else once it completes. Errors are masked with redactor.
+func (r *Recorder) runScript(ctx context.Context, redactor *redact.Redactor, script *template.Template, args interface{}, output io.Writer) (command string, stdout string, stderr string, exitCode int, err error) {
+	command, err = renderScript(script, args)
+	if err != nil {
+		return "", "", "", -1, err
+	}
+
+	argsJson, err := json.Marshal(args)
+	if err != nil {
+		log.Printf("[DEBUG] Failed to record script args: %v", err)
+		argsJson = nil
+	}
+
+	if streamingRunner, ok := r.runner.(StreamingRunner); ok && output != nil {
+		stdout, stderr, exitCode, err = streamingRunner.RunScriptStreaming(ctx, command, output)
+	} else {
+		stdout, stderr, exitCode, err = r.runner.RunScript(ctx, command)
+		if output != nil {
+			_, _ = io.WriteString(output, stdout)
+		}
+	}
+
+	if recordErr := r.record(redactor, Interaction{
+		Operation: OperationScript,
+		Script:    command,
+		Args:      argsJson,
+		Stdout:    stdout,
+		Stderr:    stderr,
+		ExitCode:  exitCode,
+		Error:     errorString(err),
+	}); recordErr != nil {
+		return command, stdout, stderr, exitCode, recordErr
+	}
+
+	return command, stdout, stderr, exitCode, redactor.Error(err)
+}
+
+// RunFireAndForgetScript executes and records a script without processing results
+func (r *Recorder) RunFireAndForgetScript(ctx context.Context, script *template.Template, args interface{}) error {
+	redactor := r.redactor.WithArgs(args)
+	_, stdout, stderr, exitCode, err := r.runScript(ctx, redactor, script, args, nil)
+	if err != nil {
+		return err
+	}
+
+	return commandresult.CheckExitCode(redactor, exitCode, stdout, stderr)
+}
+
+// RunScriptWithResult executes and records a script and unmarshals JSON output into result
+func (r *Recorder) RunScriptWithResult(ctx context.Context, script *template.Template, args interface{}, result interface{}) error {
+	redactor := r.redactor.WithArgs(args)
+	command, stdout, stderr, exitCode, err := r.runScript(ctx, redactor, script, args, nil)
+	if err != nil {
+		return err
+	}
+
+	return commandresult.DecodeJSON(redactor, exitCode, stdout, stderr, command, result)
+}
+
+// RunStreamingScript executes and records a script, passing its records to records, and unmarshals JSON output into
+// result unless result is nil
+func (r *Recorder) RunStreamingScript(ctx context.Context, script *template.Template, args interface{}, result interface{}, records commandresult.RecordHandler) error {
+	redactor := r.redactor.WithArgs(args)
+	command, stdout, stderr, exitCode, err := r.runScript(ctx, redactor, script, args, commandresult.NewRecordWriter(redactor, records))
+	if err != nil {
+		return err
+	}
+
+	if result == nil {
+		return commandresult.CheckExitCode(redactor, exitCode, stdout, stderr)
+	}
+
+	return commandresult.DecodeJSON(redactor, exitCode, stdout, stderr, command, result)
+}
+
+func (r *Recorder) UploadFile(ctx context.Context, filePath string, remoteFilePath string) (string, error) {
+	resolvedRemoteFilePath, err := r.runner.UploadFile(ctx, filePath, remoteFilePath)
+
+	if recordErr := r.record(r.redactor, Interaction{
+		Operation:    OperationUploadFile,
+		Path:         filePath,
+		RemotePath:   remoteFilePath,
+		ResolvedPath: resolvedRemoteFilePath,
+		Error:        errorString(err),
+	}); recordErr != nil {
+		return "", recordErr
+	}
+
+	return resolvedRemoteFilePath, r.redactor.Error(err)
+}
+
+func (r *Recorder) UploadDirectory(ctx context.Context, rootPath string, excludeList []string) (string, []string, error) {
+	remoteRootPath, remoteAbsoluteFilePaths, err := r.runner.UploadDirectory(ctx, rootPath, excludeList)
+
+	if recordErr := r.record(r.redactor, Interaction{
+		Operation:    OperationUploadDirectory,
+		Path:         rootPath,
+		ExcludeList:  excludeList,
+		ResolvedPath: remoteRootPath,
+		RemotePaths:  remoteAbsoluteFilePaths,
+		Error:        errorString(err),
+	}); recordErr != nil {
+		return "", nil, recordErr
+	}
+
+	return remoteRootPath, remoteAbsoluteFilePaths, r.redactor.Error(err)
+}
+
+func (r *Recorder) FileExists(ctx context.Context, remoteFilePath string) (bool, error) {
+	exists, err := r.runner.FileExists(ctx, remoteFilePath)
+
+	if recordErr := r.record(r.redactor, Interaction{
+		Operation: OperationFileExists,
+		Path:      remoteFilePath,
+		Exists:    exists,
+		Error:     errorString(err),
+	}); recordErr != nil {
+		return false, recordErr
+	}
+
+	return exists, r.redactor.Error(err)
+}
+
+func (r *Recorder) DirectoryExists(ctx context.Context, remoteDirectoryPath string) (bool, error) {
+	exists, err := r.runner.DirectoryExists(ctx, remoteDirectoryPath)
+
+	if recordErr := r.record(r.redactor, Interaction{
+		Operation: OperationDirectoryExists,
+		Path:      remoteDirectoryPath,
+		Exists:    exists,
+		Error:     errorString(err),
+	}); recordErr != nil {
+		return false, recordErr
+	}
+
+	return exists, r.redactor.Error(err)
+}
+
+func (r *Recorder) DeleteFileOrDirectory(ctx context.Context, remotePath string) error {
+	err := r.runner.DeleteFileOrDirectory(ctx, remotePath)
+
+	if recordErr := r.record(r.redactor, Interaction{
+		Operation: OperationDeleteFileOrDirectory,
+		Path:      remotePath,
+		Error:     errorString(err),
+	}); recordErr != nil {
+		return recordErr
+	}
+
+	return r.redactor.Error(err)
+}
@@ -0,0 +1,180 @@
+package cassette
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"text/template"
+
+	"github.com/taliesins/terraform-provider-hyperv/api/commandresult"
+)
+
+// Replayer is a script runner that answers every operation from a cassette.
+type Replayer struct {
+	mu           sync.Mutex
+	interactions map[string][]Interaction
+}
+
+// NewReplayer creates a replayer serving the cassette file at path.
+func NewReplayer(path string) (*Replayer, error) {
+	cassette, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewReplayerFromCassette(cassette), nil
+}
+
+// NewReplayerFromCassette creates a replayer serving cassette.
+func NewReplayerFromCassette(cassette *Cassette) *Replayer {
+	r := &Replayer{
+		interactions: map[string][]Interaction{},
+	}
+
+	for _, interaction := range cassette.Interactions {
+		key := interaction.key()
+		r.interactions[key] = append(r.interactions[key], interaction)
+	}
+
+	return r
+}
+
+// next returns the next recorded response to request.
+func (r *Replayer) next(ctx context.Context, request Interaction) (*Interaction, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := request.key()
+	queue := r.interactions[key]
+	if len(queue) == 0 {
+		return nil, fmt.Errorf("no recorded interaction left for %s", request.describe())
+	}
+
+	interaction := queue[0]
+	r.interactions[key] = queue[1:]
+
+	return &interaction, nil
+}
+
+// Remaining returns the number of recorded interactions that have not been replayed.
+func (r *Replayer) Remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	remaining := 0
+	for _, queue := range r.interactions {
+		remaining += len(queue)
+	}
+
+	return remaining
+}
+
+func (r *Replayer) runScript(ctx context.Context, script *template.Template, args interface{}) (*Interaction, error) {
+	command, err := renderScript(script, args)
+	if err != nil {
+		return nil, err
+	}
+
+	interaction, err := r.next(ctx, Interaction{Operation: OperationScript, Script: command})
+	if err != nil {
+		return nil, err
+	}
+
+	return interaction, interaction.err()
+}
+
+func (i *Interaction) err() error {
+	if i.Error == "" {
+		return nil
+	}
+
+	return errors.New(i.Error)
+}
+
+// RunFireAndForgetScript replays a script without processing results
+func (r *Replayer) RunFireAndForgetScript(ctx context.Context, script *template.Template, args interface{}) error {
+	interaction, err := r.runScript(ctx, script, args)
+	if err != nil {
+		return err
+	}
+
+	return commandresult.CheckExitCode(nil, interaction.ExitCode, interaction.Stdout, interaction.Stderr)
+}
+
+// RunScriptWithResult replays a script and unmarshals JSON output into result
+func (r *Replayer) RunScriptWithResult(ctx context.Context, script *template.Template, args interface{}, result interface{}) error {
+	interaction, err := r.runScript(ctx, script, args)
+	if err != nil {
+		return err
+	}
+
+	return commandresult.DecodeJSON(nil, interaction.ExitCode, interaction.Stdout, interaction.Stderr, interaction.Script, result)
+}
+
+// RunStreamingScript replays a script, passing the records in its output to records, and unmarshals JSON output into
+// result unless result is nil
+func (r *Replayer) RunStreamingScript(ctx context.Context, script *template.Template, args interface{}, result interface{}, records commandresult.RecordHandler) error {
+	interaction, err := r.runScript(ctx, script, args)
+	if err != nil {
+		return err
+	}
+
+	_, _ = io.WriteString(commandresult.NewRecordWriter(nil, records), interaction.Stdout+"\n")
+
+	if result == nil {
+		return commandresult.CheckExitCode(nil, interaction.ExitCode, interaction.Stdout, interaction.Stderr)
+	}
+
+	return commandresult.DecodeJSON(nil, interaction.ExitCode, interaction.Stdout, interaction.Stderr, interaction.Script, result)
+}
+
+func (r *Replayer) UploadFile(ctx context.Context, filePath string, remoteFilePath string) (string, error) {
+	interaction, err := r.next(ctx, Interaction{Operation: OperationUploadFile, Path: filePath, RemotePath: remoteFilePath})
+	if err != nil {
+		return "", err
+	}
+
+	return interaction.ResolvedPath, interaction.err()
+}
+
+func (r *Replayer) UploadDirectory(ctx context.Context, rootPath string, excludeList []string) (string, []string, error) {
+	interaction, err := r.next(ctx, Interaction{Operation: OperationUploadDirectory, Path: rootPath, ExcludeList: excludeList})
+	if err != nil {
+		return "", nil, err
+	}
+
+	return interaction.ResolvedPath, interaction.RemotePaths, interaction.err()
+}
+
+func (r *Replayer) FileExists(ctx context.Context, remoteFilePath string) (bool, error) {
+	interaction, err := r.next(ctx, Interaction{Operation: OperationFileExists, Path: remoteFilePath})
+	if err != nil {
+		return false, err
+	}
+
+	return interaction.Exists, interaction.err()
+}
+
+func (r *Replayer) DirectoryExists(ctx context.Context, remoteDirectoryPath string) (bool, error) {
+	interaction, err := r.next(ctx, Interaction{Operation: OperationDirectoryExists, Path: remoteDirectoryPath})
+	if err != nil {
+		return false, err
+	}
+
+	return interaction.Exists, interaction.err()
+}
+
+func (r *Replayer) DeleteFileOrDirectory(ctx context.Context, remotePath string) error {
+	interaction, err := r.next(ctx, Interaction{Operation: OperationDeleteFileOrDirectory, Path: remotePath})
+	if err != nil {
+		return err
+	}
+
+	return interaction.err()
+}
@@ -0,0 +1,37 @@
+// Package cim is a Hyper-V client reading the root\virtualization\v2 WMI namespace of the host directly over
+// WS-Management, with WinRM Enumerate and Invoke requests on classes such as Msvm_ComputerSystem and
+// Msvm_ImageManagementService. Neither PowerShell nor the Hyper-V PowerShell module run on the host, which makes reads
+// much cheaper than spawning a shell per operation.
+//
+// The read paths of VMs, VM status, switches and VHDs are implemented so far. Every other operation is passed to the
+// client embedded in ClientConfig, usually the PowerShell client of the hyperv package.
+package cim
+
+import (
+	"errors"
+
+	"github.com/taliesins/terraform-provider-hyperv/api"
+)
+
+var _ api.Client = (*ClientConfig)(nil)
+
+// New creates a client reading from the Hyper-V host with clientConfig.WSMan.
+func New(clientConfig *ClientConfig) (*api.Provider, error) {
+	if clientConfig.Client == nil {
+		return nil, errors.New("the cim client needs a client for the operations it does not implement")
+	}
+	if clientConfig.WSMan == nil {
+		return nil, errors.New("the cim client needs a WS-Management client")
+	}
+
+	return &api.Provider{
+		Client: clientConfig,
+	}, nil
+}
+
+type ClientConfig struct {
+	// Client serves the operations that are not implemented over WS-Management yet
+	api.Client
+
+	WSMan *WSMan
+}
@@ -0,0 +1,425 @@
+package cim
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/masterzen/winrm"
+	"github.com/taliesins/terraform-provider-hyperv/api"
+	"github.com/taliesins/terraform-provider-hyperv/api/fake"
+)
+
+const (
+	testUser     = "administrator"
+	testPassword = "Passw0rd!"
+	testVmId     = "3F2504E0-4F89-11D3-9A0C-0305E82C3301"
+	testSwitchId = "9C2E1A3B-5D4F-4E6A-8B7C-0D1E2F3A4B5C"
+)
+
+// testRequest is a WS-Management request received by the test WinRM service.
+type testRequest struct {
+	Action    string         `xml:"Header>Action"`
+	Selectors []testSelector `xml:"Header>SelectorSet>Selector"`
+	Body      struct {
+		Filter  string          `xml:"Enumerate>Filter"`
+		Context string          `xml:"Pull>EnumerationContext"`
+		Input   testMethodInput `xml:",any"`
+	} `xml:"Body"`
+	raw       string
+	selectors map[string]string
+}
+
+type testSelector struct {
+	Name  string `xml:"Name,attr"`
+	Value string `xml:",chardata"`
+}
+
+type testMethodInput struct {
+	XMLName    xml.Name
+	Parameters []struct {
+		XMLName xml.Name
+		Value   string `xml:",chardata"`
+	} `xml:",any"`
+}
+
+// key identifies the recorded response of a request: the query of an Enumerate, the context of a Pull or the method
+// of an Invoke.
+func (r *testRequest) key() string {
+	switch {
+	case r.Action == actionEnumerate:
+		return r.Body.Filter
+	case r.Action == actionPull:
+		return "Pull " + r.Body.Context
+	default:
+		return r.Action[strings.LastIndex(r.Action, "/")+1:]
+	}
+}
+
+// newTestClient returns a client of a WinRM service answering requests with the recorded SOAP responses in testdata,
+// by the key of the request. The requests received are returned too.
+func newTestClient(t *testing.T, responses map[string]string) (*ClientConfig, func() []*testRequest) {
+	t.Helper()
+
+	var mu sync.Mutex
+	var requests []*testRequest
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("error reading request: %v", err)
+			return
+		}
+
+		request := &testRequest{raw: string(body), selectors: map[string]string{}}
+		if err := xml.Unmarshal(body, request); err != nil {
+			t.Errorf("error decoding request %s: %v", body, err)
+		}
+		for _, selector := range request.Selectors {
+			request.selectors[selector.Name] = selector.Value
+		}
+
+		mu.Lock()
+		requests = append(requests, request)
+		mu.Unlock()
+
+		if user, password, ok := r.BasicAuth(); !ok || user != testUser || password != testPassword {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		status := http.StatusOK
+		fixture, ok := responses[request.key()]
+		if !ok {
+			t.Errorf("unexpected request %q", request.key())
+			fixture = "fault.xml"
+		}
+		if fixture == "fault.xml" {
+			status = http.StatusInternalServerError
+		}
+
+		response, err := os.ReadFile(filepath.Join("testdata", fixture))
+		if err != nil {
+			t.Errorf("error reading %s: %v", fixture, err)
+		}
+
+		w.Header().Set("Content-Type", "application/soap+xml;charset=UTF-8")
+		w.WriteHeader(status)
+		_, _ = w.Write(response)
+	}))
+	t.Cleanup(server.Close)
+
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	portNumber, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wsman, err := NewWSMan(&winrm.Endpoint{Host: host, Port: portNumber, Timeout: 10 * time.Second}, testUser, testPassword, winrm.NewParameters("PT60S", "en-US", 153600))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provider, err := New(&ClientConfig{Client: fake.New(), WSMan: wsman})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, ok := provider.Client.(*ClientConfig)
+	if !ok {
+		t.Fatalf("expected a *ClientConfig, got %T", provider.Client)
+	}
+
+	return client, func() []*testRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]*testRequest(nil), requests...)
+	}
+}
+
+func TestGetVm(t *testing.T) {
+	t.Parallel()
+
+	vmQuery := "SELECT * FROM Msvm_VirtualSystemSettingData WHERE ElementName = 'web' AND VirtualSystemType = 'Microsoft:Hyper-V:System:Realized'"
+
+	tests := []struct {
+		name      string
+		responses map[string]string
+		want      api.Vm
+	}{
+		{
+			name: "vm",
+			responses: map[string]string{
+				vmQuery: "vm_settings.xml",
+				`SELECT * FROM Msvm_MemorySettingData WHERE InstanceID LIKE 'Microsoft:` + testVmId + `\\%'`:    "vm_memory.xml",
+				`SELECT * FROM Msvm_ProcessorSettingData WHERE InstanceID LIKE 'Microsoft:` + testVmId + `\\%'`: "vm_processor.xml",
+			},
+			want: api.Vm{
+				Name:                                "web",
+				Path:                                `C:\ProgramData\Microsoft\Windows\Hyper-V`,
+				Generation:                          2,
+				AutomaticCriticalErrorAction:        api.CriticalErrorAction_Pause,
+				AutomaticCriticalErrorActionTimeout: 30,
+				AutomaticStartAction:                api.StartAction_StartIfRunning,
+				AutomaticStartDelay:                 90,
+				AutomaticStopAction:                 api.StopAction_ShutDown,
+				CheckpointType:                      api.CheckpointType_Production,
+				DynamicMemory:                       true,
+				GuestControlledCacheTypes:           true,
+				HighMemoryMappedIoSpace:             512 * 1024 * 1024,
+				LockOnDisconnect:                    api.OnOffState_On,
+				LowMemoryMappedIoSpace:              128 * 1024 * 1024,
+				MemoryMaximumBytes:                  1024 * 1024 * 1024 * 1024,
+				MemoryMinimumBytes:                  512 * 1024 * 1024,
+				MemoryStartupBytes:                  2048 * 1024 * 1024,
+				Notes:                               "web & api",
+				ProcessorCount:                      4,
+				SmartPagingFilePath:                 `D:\Paging`,
+				SnapshotFileLocation:                `D:\Snapshots`,
+				StaticMemory:                        false,
+			},
+		},
+		{
+			name:      "missing vm",
+			responses: map[string]string{vmQuery: "empty.xml"},
+			want:      api.Vm{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			client, _ := newTestClient(t, tt.responses)
+
+			got, err := client.GetVm(context.Background(), "web")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestGetVmStatus(t *testing.T) {
+	t.Parallel()
+
+	client, _ := newTestClient(t, map[string]string{
+		"SELECT * FROM Msvm_ComputerSystem WHERE ElementName = 'HV01'": "vm_systems.xml",
+	})
+
+	// The host is an Msvm_ComputerSystem named HV01 too, and is skipped
+	got, err := client.GetVmStatus(context.Background(), "HV01")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.State != api.VmState_FastSaved {
+		t.Fatalf("expected state %s, got %s", api.VmState_FastSaved, got.State)
+	}
+}
+
+func TestGetVMSwitch(t *testing.T) {
+	t.Parallel()
+
+	resources := func(class string) string {
+		return "SELECT * FROM " + class + " WHERE InstanceID LIKE 'Microsoft:" + testSwitchId + "%'"
+	}
+
+	client, requests := newTestClient(t, map[string]string{
+		"SELECT * FROM Msvm_VirtualEthernetSwitch WHERE ElementName = 'external'":                                     "switch.xml",
+		resources("Msvm_VirtualEthernetSwitchSettingData"):                                                            "switch_settings.xml",
+		resources("Msvm_EthernetSwitchBandwidthSettingData"):                                                          "switch_bandwidth.xml",
+		resources("Msvm_EthernetSwitchOffloadSettingData"):                                                            "switch_offload.xml",
+		resources("Msvm_EthernetSwitchTeamSettingData"):                                                               "empty.xml",
+		resources("Msvm_EthernetPortAllocationSettingData"):                                                           "switch_ports.xml",
+		"Pull uuid:2C4B8A31-9F5E-4D2A-B7C6-1E0D9F8A7B6C":                                                              "switch_ports_pull.xml",
+		"SELECT * FROM Msvm_ExternalEthernetPort WHERE DeviceID = 'Microsoft:{D7A8B9C0-1111-2222-3333-444455556666}'": "external_port.xml",
+		"SELECT * FROM MSFT_NetAdapter WHERE InterfaceDescription = 'Intel(R) Ethernet Connection I219-LM'":           "net_adapter.xml",
+	})
+
+	got, err := client.GetVMSwitch(context.Background(), "external")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := api.VmSwitch{
+		Name:                              "external",
+		Notes:                             "uplink",
+		AllowManagementOS:                 true,
+		BandwidthReservationMode:          api.VMSwitchBandwidthMode_Weight,
+		SwitchType:                        api.VMSwitchType_External,
+		NetAdapterNames:                   []string{"Ethernet"},
+		DefaultFlowMinimumBandwidthWeight: 10,
+		DefaultQueueVmmqEnabled:           true,
+		DefaultQueueVmmqQueuePairs:        16,
+		DefaultQueueVrssEnabled:           true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+
+	for _, request := range requests() {
+		if strings.Contains(request.Body.Filter, "MSFT_NetAdapter") && !strings.Contains(request.raw, "<w:ResourceURI mustUnderstand=\"true\">"+NamespaceStandardCimV2+"*</w:ResourceURI>") {
+			t.Fatalf("expected the net adapters to be enumerated in root/standardcimv2, got %s", request.raw)
+		}
+	}
+}
+
+func TestGetVhd(t *testing.T) {
+	t.Parallel()
+
+	fileQuery := `SELECT * FROM CIM_DataFile WHERE Name = 'D:\\VHDs\\web.vhdx'`
+
+	tests := []struct {
+		name      string
+		responses map[string]string
+		want      api.Vhd
+		wantErr   string
+	}{
+		{
+			name: "vhd",
+			responses: map[string]string{
+				fileQuery: "data_file.xml",
+				"SELECT * FROM Msvm_ImageManagementService": "image_management_service.xml",
+				"GetVirtualHardDiskSettingData":             "vhd_setting_data.xml",
+				"GetVirtualHardDiskState":                   "vhd_state.xml",
+			},
+			want: api.Vhd{
+				Path:                    `D:\VHDs\web.vhdx`,
+				BlockSize:               33554432,
+				LogicalSectorSize:       512,
+				PhysicalSectorSize:      4096,
+				FileSize:                4194304,
+				Size:                    42949672960,
+				MinimumSize:             3145728,
+				Attached:                true,
+				FragmentationPercentage: 7,
+				Alignment:               1,
+				DiskIdentifier:          "8F3E2D1C-0B9A-4877-A665-544332211000",
+				VhdType:                 api.VhdType_Dynamic,
+				VhdFormat:               api.VhdFormat_VHDX,
+			},
+		},
+		{
+			name:      "missing vhd",
+			responses: map[string]string{fileQuery: "empty.xml"},
+			want:      api.Vhd{},
+		},
+		{
+			name: "failed method",
+			responses: map[string]string{
+				fileQuery: "data_file.xml",
+				"SELECT * FROM Msvm_ImageManagementService": "image_management_service.xml",
+				"GetVirtualHardDiskSettingData":             "vhd_setting_data.xml",
+				"GetVirtualHardDiskState":                   "vhd_failed.xml",
+			},
+			wantErr: "error invoking Msvm_ImageManagementService.GetVirtualHardDiskState: returned 32768",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			client, requests := newTestClient(t, tt.responses)
+
+			got, err := client.GetVhd(context.Background(), `D:\VHDs\web.vhdx`)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected %+v, got %+v", tt.want, got)
+			}
+
+			for _, request := range requests() {
+				if request.Action != actionEnumerate {
+					wantSelectors := map[string]string{
+						"CreationClassName":       "Msvm_ImageManagementService",
+						"Name":                    "vhdsvc",
+						"SystemCreationClassName": "Msvm_ComputerSystem",
+						"SystemName":              "HV01",
+					}
+					if !reflect.DeepEqual(request.selectors, wantSelectors) {
+						t.Fatalf("expected selectors %v, got %v", wantSelectors, request.selectors)
+					}
+					if request.Body.Input.XMLName.Local != request.key()+"_INPUT" || len(request.Body.Input.Parameters) != 1 || request.Body.Input.Parameters[0].Value != `D:\VHDs\web.vhdx` {
+						t.Fatalf("expected the path as input of %s, got %s", request.key(), request.raw)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestWSManFault(t *testing.T) {
+	t.Parallel()
+
+	query := "SELECT * FROM Msvm_ComputerSystem WHERE ElementName = 'web'"
+	client, _ := newTestClient(t, map[string]string{query: "fault.xml"})
+
+	_, err := client.GetVmStatus(context.Background(), "web")
+
+	want := "error enumerating " + query + ": WS-Management fault w:CannotProcessFilter: Invalid query"
+	if err == nil || err.Error() != want {
+		t.Fatalf("expected error %q, got %v", want, err)
+	}
+}
+
+func TestWSManEscapesRequests(t *testing.T) {
+	t.Parallel()
+
+	query := `SELECT * FROM Msvm_VirtualSystemSettingData WHERE ElementName = 'r&d <web> \'01\'' AND VirtualSystemType = 'Microsoft:Hyper-V:System:Realized'`
+	client, requests := newTestClient(t, map[string]string{query: "empty.xml"})
+
+	if _, err := client.GetVm(context.Background(), `r&d <web> '01'`); err != nil {
+		t.Fatal(err)
+	}
+
+	// The request decoded, so the query was escaped
+	if got := requests(); len(got) != 1 || got[0].Body.Filter != query {
+		t.Fatalf("expected a single enumeration of %q, got %+v", query, got)
+	}
+}
+
+func TestClientFallsBack(t *testing.T) {
+	t.Parallel()
+
+	client, requests := newTestClient(t, map[string]string{})
+
+	if err := client.CreateOrUpdateVhd(context.Background(), `D:\VHDs\web.vhdx`, "", "", 0, api.VhdType_Dynamic, "", 1024*1024*1024, 0, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.VhdExists(context.Background(), `D:\VHDs\web.vhdx`); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := requests(); len(got) != 0 {
+		t.Fatalf("expected operations that are not implemented to use the embedded client, got %d requests", len(got))
+	}
+}
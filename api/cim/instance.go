@@ -0,0 +1,212 @@
+package cim
+
+import (
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Instance is a WMI instance, or the output parameters of a method, with the values of its properties as text.
+// Properties that are null are left out, array properties have a value per element.
+type Instance struct {
+	Class      string
+	Properties map[string][]string
+}
+
+// String returns the value of property, or "" when it is null.
+func (i Instance) String(property string) string {
+	values := i.Properties[property]
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+// Strings returns the values of an array property.
+func (i Instance) Strings(property string) []string {
+	return i.Properties[property]
+}
+
+// EmbeddedInstance decodes a property holding an embedded instance, such as the SettingData returned by
+// Msvm_ImageManagementService.GetVirtualHardDiskSettingData, which WMI encodes as CIM-XML.
+func (i Instance) EmbeddedInstance(property string) (Instance, error) {
+	value := i.String(property)
+	if value == "" {
+		return Instance{}, fmt.Errorf("%s of %s is null", property, i.Class)
+	}
+
+	var embedded struct {
+		ClassName  string `xml:"CLASSNAME,attr"`
+		Properties []struct {
+			Name  string  `xml:"NAME,attr"`
+			Value *string `xml:"VALUE"`
+		} `xml:"PROPERTY"`
+		Arrays []struct {
+			Name   string   `xml:"NAME,attr"`
+			Values []string `xml:"VALUE.ARRAY>VALUE"`
+		} `xml:"PROPERTY.ARRAY"`
+	}
+	if err := xml.Unmarshal([]byte(value), &embedded); err != nil {
+		return Instance{}, fmt.Errorf("error decoding %s of %s: %w", property, i.Class, err)
+	}
+
+	instance := Instance{
+		Class:      embedded.ClassName,
+		Properties: map[string][]string{},
+	}
+	for _, property := range embedded.Properties {
+		if property.Value != nil {
+			instance.Properties[property.Name] = []string{*property.Value}
+		}
+	}
+	for _, array := range embedded.Arrays {
+		if array.Values != nil {
+			instance.Properties[array.Name] = array.Values
+		}
+	}
+
+	return instance, nil
+}
+
+// Key returns the value of key in an object path, such as the HostResource of a resource allocation.
+func Key(path string, key string) (string, bool) {
+	match := regexp.MustCompile(`(?:^|[.,])` + regexp.QuoteMeta(key) + `="((?:[^"\\]|\\.)*)"`).FindStringSubmatch(path)
+	if match == nil {
+		return "", false
+	}
+
+	return strings.NewReplacer(`\\`, `\`, `\"`, `"`).Replace(match[1]), true
+}
+
+// Quote returns text as a WQL string literal.
+func Quote(text string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(text) + "'"
+}
+
+// propertyReader converts the properties of an instance, keeping the first error so that a mapping reads every
+// property before checking it.
+type propertyReader struct {
+	instance Instance
+	err      error
+}
+
+func (r *propertyReader) string(property string) string {
+	return r.instance.String(property)
+}
+
+func (r *propertyReader) bool(property string) bool {
+	value := r.instance.String(property)
+	if value == "" {
+		return false
+	}
+
+	result, err := strconv.ParseBool(value)
+	r.fail(property, err)
+	return result
+}
+
+func (r *propertyReader) int64(property string) int64 {
+	value := r.instance.String(property)
+	if value == "" {
+		return 0
+	}
+
+	result, err := strconv.ParseInt(value, 10, 64)
+	r.fail(property, err)
+	return result
+}
+
+func (r *propertyReader) uint64(property string) uint64 {
+	value := r.instance.String(property)
+	if value == "" {
+		return 0
+	}
+
+	result, err := strconv.ParseUint(value, 10, 64)
+	r.fail(property, err)
+	return result
+}
+
+// interval reads a CIM interval, an xs:duration over WS-Management or ddddddddhhmmss.mmmmmm:000 in an embedded
+// instance.
+func (r *propertyReader) interval(property string) time.Duration {
+	value := r.instance.String(property)
+	if value == "" {
+		return 0
+	}
+
+	result, err := parseInterval(value)
+	r.fail(property, err)
+	return result
+}
+
+func (r *propertyReader) fail(property string, err error) {
+	if err != nil && r.err == nil {
+		r.err = fmt.Errorf("error reading %s of %s: %w", property, r.instance.Class, err)
+	}
+}
+
+var durationPattern = regexp.MustCompile(`^P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+func parseInterval(value string) (time.Duration, error) {
+	if match := durationPattern.FindStringSubmatch(value); match != nil {
+		var duration time.Duration
+		for i, unit := range []time.Duration{24 * time.Hour, time.Hour, time.Minute} {
+			if match[i+1] != "" {
+				number, err := strconv.ParseUint(match[i+1], 10, 32)
+				if err != nil {
+					return 0, fmt.Errorf("%q is not an interval: %w", value, err)
+				}
+				duration += time.Duration(number) * unit
+			}
+		}
+		if match[4] != "" {
+			seconds, err := strconv.ParseFloat(match[4], 64)
+			if err != nil {
+				return 0, fmt.Errorf("%q is not an interval: %w", value, err)
+			}
+			duration += time.Duration(seconds * float64(time.Second))
+		}
+
+		return duration, nil
+	}
+
+	if len(value) != 25 || value[14] != '.' || value[21] != ':' {
+		return 0, fmt.Errorf("%q is not an interval", value)
+	}
+
+	var duration time.Duration
+	for _, field := range []struct {
+		text string
+		unit time.Duration
+	}{
+		{value[0:8], 24 * time.Hour},
+		{value[8:10], time.Hour},
+		{value[10:12], time.Minute},
+		{value[12:14], time.Second},
+		{value[15:21], time.Microsecond},
+	} {
+		number, err := strconv.ParseUint(field.text, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not an interval: %w", value, err)
+		}
+		duration += time.Duration(number) * field.unit
+	}
+
+	return duration, nil
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,74 @@
+package cim
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseInterval(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "PT30M", want: 30 * time.Minute},
+		{value: "P1DT2H3M4.5S", want: 26*time.Hour + 3*time.Minute + 4500*time.Millisecond},
+		{value: "PT0S", want: 0},
+		{value: "00000001020304.500000:000", want: 26*time.Hour + 3*time.Minute + 4500*time.Millisecond},
+		{value: "00000000000130.000000:000", want: 90 * time.Second},
+		{value: "30 minutes", wantErr: true},
+		{value: "0000000102030x.500000:000", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parseInterval(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %t, got %v", tt.wantErr, err)
+			}
+
+			if got != tt.want {
+				t.Fatalf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestKey(t *testing.T) {
+	t.Parallel()
+
+	path := `\\HV01\root\virtualization\v2:Msvm_ExternalEthernetPort.CreationClassName="Msvm_ExternalEthernetPort",DeviceID="Microsoft:{D7A8B9C0}\\0 \"a\"",SystemName="HV01"`
+
+	tests := []struct {
+		key    string
+		want   string
+		wantOk bool
+	}{
+		{key: "CreationClassName", want: "Msvm_ExternalEthernetPort", wantOk: true},
+		{key: "DeviceID", want: `Microsoft:{D7A8B9C0}\0 "a"`, wantOk: true},
+		{key: "Name", wantOk: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			t.Parallel()
+
+			got, ok := Key(path, tt.key)
+			if ok != tt.wantOk || got != tt.want {
+				t.Fatalf("expected %q %t, got %q %t", tt.want, tt.wantOk, got, ok)
+			}
+		})
+	}
+}
+
+func TestQuote(t *testing.T) {
+	t.Parallel()
+
+	if got, want := Quote(`D:\VHDs\o'brien.vhdx`), `'D:\\VHDs\\o\'brien.vhdx'`; got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,82 @@
+package cim
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/taliesins/terraform-provider-hyperv/api"
+)
+
+// GetVhd reads the VHD at path with Msvm_ImageManagementService.GetVirtualHardDiskSettingData and
+// GetVirtualHardDiskState. A path that does not exist returns an empty api.Vhd, as with the PowerShell client.
+// DiskNumber and Number are only set by Get-VHD for a VHD mounted on the host, and are left unset.
+func (c *ClientConfig) GetVhd(ctx context.Context, path string) (result api.Vhd, err error) {
+	files, err := c.WSMan.Enumerate(ctx, NamespaceCimV2, "SELECT * FROM CIM_DataFile WHERE Name = "+Quote(path))
+	if err != nil || len(files) == 0 {
+		return result, err
+	}
+
+	services, err := c.WSMan.Enumerate(ctx, NamespaceVirtualization, "SELECT * FROM Msvm_ImageManagementService")
+	if err != nil {
+		return result, err
+	}
+	if len(services) == 0 {
+		return result, fmt.Errorf("error getting VHD %q: Msvm_ImageManagementService not found", path)
+	}
+
+	selectors := map[string]string{}
+	for _, key := range []string{"CreationClassName", "Name", "SystemCreationClassName", "SystemName"} {
+		selectors[key] = services[0].String(key)
+	}
+
+	output, err := c.WSMan.Invoke(ctx, NamespaceVirtualization, "Msvm_ImageManagementService", selectors, "GetVirtualHardDiskSettingData", Parameter{Name: "Path", Value: path})
+	if err != nil {
+		return result, err
+	}
+	settings, err := output.EmbeddedInstance("SettingData")
+	if err != nil {
+		return result, err
+	}
+
+	output, err = c.WSMan.Invoke(ctx, NamespaceVirtualization, "Msvm_ImageManagementService", selectors, "GetVirtualHardDiskState", Parameter{Name: "Path", Value: path})
+	if err != nil {
+		return result, err
+	}
+	state, err := output.EmbeddedInstance("State")
+	if err != nil {
+		return result, err
+	}
+
+	return toVhd(settings, state)
+}
+
+func toVhd(settings Instance, state Instance) (api.Vhd, error) {
+	settingsReader := &propertyReader{instance: settings}
+	stateReader := &propertyReader{instance: state}
+
+	vhd := api.Vhd{
+		Path:                    settingsReader.string("Path"),
+		BlockSize:               uint32(settingsReader.uint64("BlockSize")),
+		LogicalSectorSize:       uint32(settingsReader.uint64("LogicalSectorSize")),
+		PhysicalSectorSize:      uint32(settingsReader.uint64("PhysicalSectorSize")),
+		ParentPath:              settingsReader.string("ParentPath"),
+		FileSize:                stateReader.uint64("FileSize"),
+		Size:                    settingsReader.uint64("MaxInternalSize"),
+		MinimumSize:             stateReader.uint64("MinInternalSize"),
+		Attached:                stateReader.bool("InUse"),
+		FragmentationPercentage: int(stateReader.int64("FragmentationPercentage")),
+		Alignment:               int(stateReader.int64("Alignment")),
+		DiskIdentifier:          strings.ToUpper(strings.Trim(settingsReader.string("VirtualDiskId"), "{}")),
+		VhdType:                 api.VhdType(settingsReader.int64("Type")),
+		VhdFormat:               api.VhdFormat(settingsReader.int64("Format")),
+	}
+
+	for _, reader := range []*propertyReader{settingsReader, stateReader} {
+		if reader.err != nil {
+			return api.Vhd{}, reader.err
+		}
+	}
+
+	return vhd, nil
+}
@@ -0,0 +1,94 @@
+package cim
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/taliesins/terraform-provider-hyperv/api"
+)
+
+// virtualSystemTypeRealized is the VirtualSystemType of the settings of a VM, as opposed to those of its checkpoints.
+const virtualSystemTypeRealized = "Microsoft:Hyper-V:System:Realized"
+
+const megabyte = 1024 * 1024
+
+// GetVm reads the settings of the VM named name from Msvm_VirtualSystemSettingData and its memory and processor
+// settings. The settings of a VM have the InstanceID Microsoft:<VM id>, those of its resources start with it.
+func (c *ClientConfig) GetVm(ctx context.Context, name string) (result api.Vm, err error) {
+	settings, err := c.WSMan.Enumerate(ctx, NamespaceVirtualization, "SELECT * FROM Msvm_VirtualSystemSettingData WHERE ElementName = "+Quote(name)+" AND VirtualSystemType = "+Quote(virtualSystemTypeRealized))
+	if err != nil || len(settings) == 0 {
+		return result, err
+	}
+
+	instanceID := settings[0].String("InstanceID")
+	memory, err := c.WSMan.Enumerate(ctx, NamespaceVirtualization, "SELECT * FROM Msvm_MemorySettingData WHERE InstanceID LIKE "+Quote(instanceID+`\%`))
+	if err != nil {
+		return result, err
+	}
+
+	processors, err := c.WSMan.Enumerate(ctx, NamespaceVirtualization, "SELECT * FROM Msvm_ProcessorSettingData WHERE InstanceID LIKE "+Quote(instanceID+`\%`))
+	if err != nil {
+		return result, err
+	}
+
+	return toVm(settings[0], first(memory), first(processors))
+}
+
+func toVm(settings Instance, memory Instance, processor Instance) (api.Vm, error) {
+	vmReader := &propertyReader{instance: settings}
+	memoryReader := &propertyReader{instance: memory}
+	processorReader := &propertyReader{instance: processor}
+
+	generation, _ := strconv.Atoi(strings.TrimPrefix(vmReader.string("VirtualSystemSubType"), "Microsoft:Hyper-V:SubType:"))
+
+	lockOnDisconnect := api.OnOffState_Off
+	if vmReader.bool("LockOnDisconnect") {
+		lockOnDisconnect = api.OnOffState_On
+	}
+
+	dynamicMemory := memoryReader.bool("DynamicMemoryEnabled")
+
+	vm := api.Vm{
+		Name:                                vmReader.string("ElementName"),
+		Path:                                vmReader.string("ConfigurationDataRoot"),
+		Generation:                          generation,
+		AutomaticCriticalErrorAction:        api.CriticalErrorAction(vmReader.int64("AutomaticCriticalErrorAction")),
+		AutomaticCriticalErrorActionTimeout: int32(vmReader.interval("AutomaticCriticalErrorActionTimeout") / time.Minute),
+		AutomaticStartAction:                api.StartAction(vmReader.int64("AutomaticStartupAction")),
+		AutomaticStartDelay:                 int32(vmReader.interval("AutomaticStartupActionDelay") / time.Second),
+		AutomaticStopAction:                 api.StopAction(vmReader.int64("AutomaticShutdownAction")),
+		CheckpointType:                      api.CheckpointType(vmReader.int64("UserSnapshotType")),
+		DynamicMemory:                       dynamicMemory,
+		GuestControlledCacheTypes:           vmReader.bool("GuestControlledCacheTypes"),
+		HighMemoryMappedIoSpace:             vmReader.uint64("HighMmioGapSize") * megabyte,
+		LockOnDisconnect:                    lockOnDisconnect,
+		LowMemoryMappedIoSpace:              uint32(vmReader.uint64("LowMmioGapSize") * megabyte),
+		MemoryMaximumBytes:                  memoryReader.int64("Limit") * megabyte,
+		MemoryMinimumBytes:                  memoryReader.int64("Reservation") * megabyte,
+		MemoryStartupBytes:                  memoryReader.int64("VirtualQuantity") * megabyte,
+		Notes:                               strings.Join(settings.Strings("Notes"), "\n"),
+		ProcessorCount:                      processorReader.int64("VirtualQuantity"),
+		SmartPagingFilePath:                 vmReader.string("SwapFileDataRoot"),
+		SnapshotFileLocation:                vmReader.string("SnapshotDataRoot"),
+		StaticMemory:                        !dynamicMemory,
+	}
+
+	for _, reader := range []*propertyReader{vmReader, memoryReader, processorReader} {
+		if reader.err != nil {
+			return api.Vm{}, reader.err
+		}
+	}
+
+	return vm, nil
+}
+
+// first returns the first of instances, or an instance without properties.
+func first(instances []Instance) Instance {
+	if len(instances) == 0 {
+		return Instance{}
+	}
+
+	return instances[0]
+}
@@ -0,0 +1,32 @@
+package cim
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/taliesins/terraform-provider-hyperv/api"
+)
+
+// vmIdPattern matches the Name of the Msvm_ComputerSystem of a VM, which is its id. The host is an
+// Msvm_ComputerSystem too, named after the computer.
+var vmIdPattern = regexp.MustCompile(`^[0-9A-Fa-f]{8}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{12}$`)
+
+// GetVmStatus reads the EnabledState of the VM named vmName, the values of api.VmState are those of EnabledState.
+func (c *ClientConfig) GetVmStatus(ctx context.Context, vmName string) (result api.VmStatus, err error) {
+	systems, err := c.WSMan.Enumerate(ctx, NamespaceVirtualization, "SELECT * FROM Msvm_ComputerSystem WHERE ElementName = "+Quote(vmName))
+	if err != nil {
+		return result, err
+	}
+
+	for _, system := range systems {
+		if !vmIdPattern.MatchString(system.String("Name")) {
+			continue
+		}
+
+		reader := &propertyReader{instance: system}
+		result.State = api.VmState(reader.int64("EnabledState"))
+		return result, reader.err
+	}
+
+	return result, nil
+}
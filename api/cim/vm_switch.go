@@ -0,0 +1,116 @@
+package cim
+
+import (
+	"context"
+	"strings"
+
+	"github.com/taliesins/terraform-provider-hyperv/api"
+)
+
+// GetVMSwitch reads the switch named name from Msvm_VirtualEthernetSwitch and its settings. The type of the switch
+// follows from its ports: an external switch has a port connected to a physical adapter, and a switch shared with
+// the management OS has a port connected to the host.
+func (c *ClientConfig) GetVMSwitch(ctx context.Context, name string) (result api.VmSwitch, err error) {
+	switches, err := c.WSMan.Enumerate(ctx, NamespaceVirtualization, "SELECT * FROM Msvm_VirtualEthernetSwitch WHERE ElementName = "+Quote(name))
+	if err != nil || len(switches) == 0 {
+		return result, err
+	}
+
+	switchId := switches[0].String("Name")
+	resources := map[string][]Instance{}
+	for _, class := range []string{
+		"Msvm_VirtualEthernetSwitchSettingData",
+		"Msvm_EthernetSwitchBandwidthSettingData",
+		"Msvm_EthernetSwitchOffloadSettingData",
+		"Msvm_EthernetSwitchTeamSettingData",
+		"Msvm_EthernetPortAllocationSettingData",
+	} {
+		resources[class], err = c.WSMan.Enumerate(ctx, NamespaceVirtualization, "SELECT * FROM "+class+" WHERE InstanceID LIKE "+Quote("Microsoft:"+switchId+"%"))
+		if err != nil {
+			return result, err
+		}
+	}
+
+	settingsReader := &propertyReader{instance: first(resources["Msvm_VirtualEthernetSwitchSettingData"])}
+	bandwidthReader := &propertyReader{instance: first(resources["Msvm_EthernetSwitchBandwidthSettingData"])}
+	offloadReader := &propertyReader{instance: first(resources["Msvm_EthernetSwitchOffloadSettingData"])}
+
+	var adapterIds []string
+	allowManagementOS := false
+	for _, port := range resources["Msvm_EthernetPortAllocationSettingData"] {
+		for _, hostResource := range port.Strings("HostResource") {
+			switch {
+			case strings.Contains(hostResource, ":Msvm_ExternalEthernetPort."):
+				if deviceId, ok := Key(hostResource, "DeviceID"); ok {
+					adapterIds = append(adapterIds, deviceId)
+				}
+			case strings.Contains(hostResource, ":Msvm_ComputerSystem."):
+				allowManagementOS = true
+			}
+		}
+	}
+
+	switchType := api.VMSwitchType_Private
+	switch {
+	case len(adapterIds) > 0:
+		switchType = api.VMSwitchType_External
+	case allowManagementOS:
+		switchType = api.VMSwitchType_Internal
+	}
+
+	netAdapterNames, err := c.getNetAdapterNames(ctx, adapterIds)
+	if err != nil {
+		return result, err
+	}
+
+	result = api.VmSwitch{
+		Name:                                switches[0].String("ElementName"),
+		Notes:                               strings.Join(settingsReader.instance.Strings("Notes"), "\n"),
+		AllowManagementOS:                   allowManagementOS,
+		EmbeddedTeamingEnabled:              len(resources["Msvm_EthernetSwitchTeamSettingData"]) > 0,
+		IovEnabled:                          settingsReader.bool("IOVPreferred"),
+		PacketDirectEnabled:                 settingsReader.bool("PacketDirectEnabled"),
+		BandwidthReservationMode:            api.VMSwitchBandwidthMode(settingsReader.int64("BandwidthReservationMode")),
+		SwitchType:                          switchType,
+		NetAdapterNames:                     netAdapterNames,
+		DefaultFlowMinimumBandwidthAbsolute: bandwidthReader.int64("DefaultFlowReservation"),
+		DefaultFlowMinimumBandwidthWeight:   bandwidthReader.int64("DefaultFlowWeight"),
+		DefaultQueueVmmqEnabled:             offloadReader.bool("DefaultQueueVmmqEnabled"),
+		DefaultQueueVmmqQueuePairs:          int32(offloadReader.int64("DefaultQueueVmmqQueuePairs")),
+		DefaultQueueVrssEnabled:             offloadReader.bool("DefaultQueueVrssEnabled"),
+	}
+
+	for _, reader := range []*propertyReader{settingsReader, bandwidthReader, offloadReader} {
+		if reader.err != nil {
+			return api.VmSwitch{}, reader.err
+		}
+	}
+
+	return result, nil
+}
+
+// getNetAdapterNames returns the names of the physical adapters of an external switch, as Get-NetAdapter does. Hyper-V
+// only knows the description of an adapter, which is the ElementName of its Msvm_ExternalEthernetPort.
+func (c *ClientConfig) getNetAdapterNames(ctx context.Context, deviceIds []string) ([]string, error) {
+	names := []string{}
+	for _, deviceId := range deviceIds {
+		ports, err := c.WSMan.Enumerate(ctx, NamespaceVirtualization, "SELECT * FROM Msvm_ExternalEthernetPort WHERE DeviceID = "+Quote(deviceId))
+		if err != nil {
+			return nil, err
+		}
+		if len(ports) == 0 {
+			continue
+		}
+
+		adapters, err := c.WSMan.Enumerate(ctx, NamespaceStandardCimV2, "SELECT * FROM MSFT_NetAdapter WHERE InterfaceDescription = "+Quote(ports[0].String("ElementName")))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, adapter := range adapters {
+			names = append(names, adapter.String("Name"))
+		}
+	}
+
+	return names, nil
+}
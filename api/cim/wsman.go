@@ -0,0 +1,371 @@
+package cim
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/masterzen/simplexml/dom"
+	"github.com/masterzen/winrm"
+	"github.com/masterzen/winrm/soap"
+)
+
+// Resource URIs of the WMI namespaces, a class name is appended to address a class. Enumerations filtered with WQL
+// address every class of a namespace with the * wildcard.
+const (
+	NamespaceVirtualization = "http://schemas.microsoft.com/wbem/wsman/1/wmi/root/virtualization/v2/"
+	NamespaceCimV2          = "http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/"
+	NamespaceStandardCimV2  = "http://schemas.microsoft.com/wbem/wsman/1/wmi/root/standardcimv2/"
+)
+
+const (
+	actionEnumerate  = "http://schemas.xmlsoap.org/ws/2004/09/enumeration/Enumerate"
+	actionPull       = "http://schemas.xmlsoap.org/ws/2004/09/enumeration/Pull"
+	dialectWQL       = "http://schemas.microsoft.com/wbem/wsman/1/WQL"
+	addressAnonymous = "http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous"
+
+	// maxElements is the number of instances requested per Enumerate or Pull, WinRM returns fewer when they do not
+	// fit in the maximum envelope size.
+	maxElements = 100
+)
+
+// WSMan sends WS-Management requests to the WinRM service of a Hyper-V host. It reuses the transports of the winrm
+// package, so it authenticates exactly like the WinRM transport running scripts.
+type WSMan struct {
+	client      *winrm.Client
+	transporter winrm.Transporter
+	url         string
+	parameters  winrm.Parameters
+}
+
+// NewWSMan returns a WS-Management client for the WinRM service at endpoint. The transport of parameters is used when
+// it has a TransportDecorator, basic authentication otherwise.
+func NewWSMan(endpoint *winrm.Endpoint, user string, password string, parameters *winrm.Parameters) (*WSMan, error) {
+	client, err := winrm.NewClientWithParameters(endpoint, user, password, parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	var transporter winrm.Transporter = winrm.NewClientWithDial(parameters.Dial)
+	if parameters.TransportDecorator != nil {
+		transporter = parameters.TransportDecorator()
+	}
+
+	if err := transporter.Transport(endpoint); err != nil {
+		return nil, fmt.Errorf("can't parse this key and certs: %w", err)
+	}
+
+	scheme := "http"
+	if endpoint.HTTPS {
+		scheme = "https"
+	}
+
+	return &WSMan{
+		client:      client,
+		transporter: transporter,
+		url:         fmt.Sprintf("%s://%s:%d/wsman", scheme, endpoint.Host, endpoint.Port),
+		parameters:  *parameters,
+	}, nil
+}
+
+// Enumerate returns the instances of namespace matching the WQL query, pulling until the enumeration ends.
+func (w *WSMan) Enumerate(ctx context.Context, namespace string, query string) ([]Instance, error) {
+	log.Printf("[DEBUG] Enumerating %s in %s", query, namespace)
+
+	message, body := w.newMessage(actionEnumerate, namespace+"*", nil)
+	enumerate := message.CreateElement(body, "Enumerate", soap.DOM_NS_ENUM)
+	message.CreateElement(enumerate, "OptimizeEnumeration", soap.DOM_NS_WSMAN_DMTF)
+	message.CreateElement(enumerate, "MaxElements", soap.DOM_NS_WSMAN_DMTF).SetContent(strconv.Itoa(maxElements))
+	filter := message.CreateElement(enumerate, "Filter", soap.DOM_NS_WSMAN_DMTF)
+	filter.SetAttr("Dialect", dialectWQL)
+	filter.SetContent(escape(query))
+
+	response, err := w.post(ctx, message)
+	if err != nil {
+		return nil, fmt.Errorf("error enumerating %s: %w", query, err)
+	}
+
+	enumeration := response.Body.EnumerateResponse
+	if enumeration == nil {
+		return nil, fmt.Errorf("error enumerating %s: response has no EnumerateResponse", query)
+	}
+
+	var instances []Instance
+	for {
+		instances = append(instances, enumeration.instances()...)
+		if enumeration.ended() {
+			return instances, nil
+		}
+
+		message, body := w.newMessage(actionPull, namespace+"*", nil)
+		pull := message.CreateElement(body, "Pull", soap.DOM_NS_ENUM)
+		message.CreateElement(pull, "EnumerationContext", soap.DOM_NS_ENUM).SetContent(escape(enumeration.EnumerationContext))
+		message.CreateElement(pull, "MaxElements", soap.DOM_NS_ENUM).SetContent(strconv.Itoa(maxElements))
+
+		response, err := w.post(ctx, message)
+		if err != nil {
+			return nil, fmt.Errorf("error pulling %s: %w", query, err)
+		}
+
+		enumeration = response.Body.PullResponse
+		if enumeration == nil {
+			return nil, fmt.Errorf("error pulling %s: response has no PullResponse", query)
+		}
+	}
+}
+
+// Parameter is an input parameter of a method invoked with Invoke.
+type Parameter struct {
+	Name  string
+	Value string
+}
+
+// Invoke calls method on the instance of class in namespace identified by selectors, usually the key properties of
+// the instance, and returns the output parameters. A method returning a non zero ReturnValue fails.
+func (w *WSMan) Invoke(ctx context.Context, namespace string, class string, selectors map[string]string, method string, parameters ...Parameter) (Instance, error) {
+	log.Printf("[DEBUG] Invoking %s.%s in %s", class, method, namespace)
+
+	resourceURI := namespace + class
+	message, body := w.newMessage(resourceURI+"/"+method, resourceURI, selectors)
+	input := message.CreateElement(body, method+"_INPUT", dom.Namespace{Prefix: "p", Uri: resourceURI})
+	for _, parameter := range parameters {
+		message.CreateElement(input, parameter.Name, dom.Namespace{Prefix: "p", Uri: resourceURI}).SetContent(escape(parameter.Value))
+	}
+
+	response, err := w.post(ctx, message)
+	if err != nil {
+		return Instance{}, fmt.Errorf("error invoking %s.%s: %w", class, method, err)
+	}
+
+	if response.Body.Output == nil {
+		return Instance{}, fmt.Errorf("error invoking %s.%s: response has no output", class, method)
+	}
+
+	output := response.Body.Output.instance()
+	if returnValue := output.String("ReturnValue"); returnValue != "" && returnValue != "0" {
+		return Instance{}, fmt.Errorf("error invoking %s.%s: returned %s", class, method, returnValue)
+	}
+
+	return output, nil
+}
+
+// newMessage returns a message with the WS-Management headers of action on resourceURI, and its body.
+func (w *WSMan) newMessage(action string, resourceURI string, selectors map[string]string) (*soap.SoapMessage, *dom.Element) {
+	message := soap.NewMessage()
+
+	root := dom.CreateElement("Envelope")
+	for _, namespace := range []dom.Namespace{soap.DOM_NS_SOAP_ENV, soap.DOM_NS_ADDRESSING, soap.DOM_NS_WSMAN_DMTF, soap.DOM_NS_WSMAN_MSFT, soap.DOM_NS_ENUM} {
+		root.DeclareNamespace(namespace)
+	}
+	soap.DOM_NS_SOAP_ENV.SetTo(root)
+	message.Doc().SetRoot(root)
+
+	header := message.CreateElement(root, "Header", soap.DOM_NS_SOAP_ENV)
+	message.CreateElement(header, "To", soap.DOM_NS_ADDRESSING).SetContent(escape(w.url))
+	replyTo := message.CreateElement(header, "ReplyTo", soap.DOM_NS_ADDRESSING)
+	message.CreateElement(replyTo, "Address", soap.DOM_NS_ADDRESSING).SetAttr("mustUnderstand", "true").SetContent(addressAnonymous)
+	message.CreateElement(header, "Action", soap.DOM_NS_ADDRESSING).SetAttr("mustUnderstand", "true").SetContent(escape(action))
+	message.CreateElement(header, "MessageID", soap.DOM_NS_ADDRESSING).SetContent(newMessageID())
+	message.CreateElement(header, "ResourceURI", soap.DOM_NS_WSMAN_DMTF).SetAttr("mustUnderstand", "true").SetContent(escape(resourceURI))
+	message.CreateElement(header, "MaxEnvelopeSize", soap.DOM_NS_WSMAN_DMTF).SetAttr("mustUnderstand", "true").SetContent(strconv.Itoa(w.parameters.EnvelopeSize))
+	message.CreateElement(header, "OperationTimeout", soap.DOM_NS_WSMAN_DMTF).SetContent(escape(w.parameters.Timeout))
+	message.CreateElement(header, "Locale", soap.DOM_NS_WSMAN_DMTF).SetAttr("mustUnderstand", "false").SetAttr("xml:lang", escape(w.parameters.Locale))
+
+	if len(selectors) > 0 {
+		selectorSet := message.CreateElement(header, "SelectorSet", soap.DOM_NS_WSMAN_DMTF)
+		for _, name := range sortedKeys(selectors) {
+			message.CreateElement(selectorSet, "Selector", soap.DOM_NS_WSMAN_DMTF).SetAttr("Name", escape(name)).SetContent(escape(selectors[name]))
+		}
+	}
+
+	return message, message.CreateElement(root, "Body", soap.DOM_NS_SOAP_ENV)
+}
+
+// post sends message and decodes the response. WinRM answers a failed request with a SOAP fault, which is returned as
+// a *Fault.
+func (w *WSMan) post(ctx context.Context, message *soap.SoapMessage) (*envelope, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	output, err := w.transporter.Post(w.client, message)
+	if err != nil {
+		if fault, ok := parseFault(err.Error()); ok {
+			return nil, fault
+		}
+
+		return nil, err
+	}
+
+	var response envelope
+	if err := xml.Unmarshal([]byte(output), &response); err != nil {
+		return nil, fmt.Errorf("error decoding response: %w", err)
+	}
+
+	if response.Body.Fault != nil {
+		return nil, response.Body.Fault.error()
+	}
+
+	return &response, nil
+}
+
+// Fault is a SOAP fault returned by WinRM, for example for an invalid query or a method that is not found.
+type Fault struct {
+	Code    string
+	Reason  string
+	Message string
+}
+
+func (f *Fault) Error() string {
+	message := strings.TrimSpace(f.Message)
+	if message == "" {
+		message = strings.TrimSpace(f.Reason)
+	}
+
+	return fmt.Sprintf("WS-Management fault %s: %s", f.Code, message)
+}
+
+// parseFault returns the SOAP fault in the error of a transport, which holds the body of the failed response.
+func parseFault(text string) (*Fault, bool) {
+	start := strings.Index(text, "<")
+	if start < 0 {
+		return nil, false
+	}
+
+	var response envelope
+	if err := xml.Unmarshal([]byte(text[start:]), &response); err != nil || response.Body.Fault == nil {
+		return nil, false
+	}
+
+	return response.Body.Fault.error(), true
+}
+
+type envelope struct {
+	Body struct {
+		EnumerateResponse *enumeration   `xml:"http://schemas.xmlsoap.org/ws/2004/09/enumeration EnumerateResponse"`
+		PullResponse      *enumeration   `xml:"http://schemas.xmlsoap.org/ws/2004/09/enumeration PullResponse"`
+		Fault             *faultElement  `xml:"http://www.w3.org/2003/05/soap-envelope Fault"`
+		Output            *elementValues `xml:",any"`
+	} `xml:"http://www.w3.org/2003/05/soap-envelope Body"`
+}
+
+type enumeration struct {
+	EnumerationContext string `xml:"http://schemas.xmlsoap.org/ws/2004/09/enumeration EnumerationContext"`
+
+	// An optimized Enumerate returns its items in the WS-Management namespace, a Pull in the enumeration namespace
+	OptimizedItems     *items    `xml:"http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd Items"`
+	PullItems          *items    `xml:"http://schemas.xmlsoap.org/ws/2004/09/enumeration Items"`
+	EndOfSequence      *struct{} `xml:"http://schemas.xmlsoap.org/ws/2004/09/enumeration EndOfSequence"`
+	WSManEndOfSequence *struct{} `xml:"http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd EndOfSequence"`
+}
+
+type items struct {
+	Instances []elementValues `xml:",any"`
+}
+
+func (e *enumeration) instances() []Instance {
+	var instances []Instance
+	for _, items := range []*items{e.OptimizedItems, e.PullItems} {
+		if items == nil {
+			continue
+		}
+
+		for _, element := range items.Instances {
+			instances = append(instances, element.instance())
+		}
+	}
+
+	return instances
+}
+
+func (e *enumeration) ended() bool {
+	return e.EndOfSequence != nil || e.WSManEndOfSequence != nil || e.EnumerationContext == ""
+}
+
+// elementValues is an instance or the output of a method, with a child element per property value. Intervals and
+// dates are wrapped in an element of the CIM binding, as xs:duration and xs:dateTime.
+type elementValues struct {
+	XMLName    xml.Name
+	Properties []struct {
+		XMLName  xml.Name
+		Nil      bool   `xml:"http://www.w3.org/2001/XMLSchema-instance nil,attr"`
+		Value    string `xml:",chardata"`
+		Interval string `xml:"http://schemas.dmtf.org/wbem/wscim/1/common Interval"`
+		Datetime string `xml:"http://schemas.dmtf.org/wbem/wscim/1/common Datetime"`
+	} `xml:",any"`
+}
+
+func (e *elementValues) instance() Instance {
+	instance := Instance{
+		Class:      e.XMLName.Local,
+		Properties: map[string][]string{},
+	}
+
+	for _, property := range e.Properties {
+		if property.Nil {
+			continue
+		}
+
+		value := property.Value
+		switch {
+		case property.Interval != "":
+			value = property.Interval
+		case property.Datetime != "":
+			value = property.Datetime
+		}
+
+		instance.Properties[property.XMLName.Local] = append(instance.Properties[property.XMLName.Local], value)
+	}
+
+	return instance
+}
+
+type faultElement struct {
+	Code struct {
+		Value   string `xml:"Value"`
+		Subcode struct {
+			Value string `xml:"Value"`
+		} `xml:"Subcode"`
+	} `xml:"Code"`
+	Reason struct {
+		Text string `xml:"Text"`
+	} `xml:"Reason"`
+	Detail struct {
+		Message string `xml:"http://schemas.microsoft.com/wbem/wsman/1/wsmanfault WSManFault>Message"`
+	} `xml:"Detail"`
+}
+
+func (f *faultElement) error() *Fault {
+	code := f.Code.Subcode.Value
+	if code == "" {
+		code = f.Code.Value
+	}
+
+	return &Fault{
+		Code:    code,
+		Reason:  f.Reason.Text,
+		Message: f.Detail.Message,
+	}
+}
+
+// escape escapes text for the content of an element or an attribute, which the dom package writes as is.
+func escape(text string) string {
+	var buffer bytes.Buffer
+	_ = xml.EscapeText(&buffer, []byte(text))
+	return buffer.String()
+}
+
+// newMessageID returns a random UUID for the MessageID header.
+func newMessageID() string {
+	var id [16]byte
+	_, _ = rand.Read(id[:])
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+
+	return fmt.Sprintf("uuid:%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16])
+}
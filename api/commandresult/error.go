@@ -0,0 +1,219 @@
+package commandresult
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/taliesins/terraform-provider-hyperv/api"
+	"github.com/taliesins/terraform-provider-hyperv/api/redact"
+)
+
+// ErrorMarker prefixes the line a wrapped script writes to stdout when it fails with a terminating error.
+const ErrorMarker = "#terraform-hyperv-error#"
+
+// scriptWrapperHeader and scriptWrapperFooter surround every script sent to a Hyper-V host. Terminating errors are
+// caught and written to stdout as a JSON envelope after ErrorMarker, followed by exit code 1. Messages are localized
+// on the host, so the envelope also carries the exception type, HResult, FullyQualifiedErrorId and error category
+// which do not change with the language of Windows, and the state of the target object, such as the VM a cmdlet
+// failed on.
+const scriptWrapperHeader = "try {\n"
+
+const scriptWrapperFooter = `
+} catch {
+	$errorRecord = $_
+	$exception = $errorRecord.Exception
+	while ($exception.InnerException -and $exception -is [System.Management.Automation.MethodInvocationException]) {
+		$exception = $exception.InnerException
+	}
+	$targetObject = $errorRecord.TargetObject
+	$targetState = $null
+	if ($null -ne $targetObject) {
+		if ($targetObject.PSObject.Properties['State']) {
+			$targetState = [string]$targetObject.State
+		}
+		$targetObject = [string]$targetObject
+	}
+	$envelope = [ordered]@{
+		ExceptionType = $exception.GetType().FullName
+		HResult = $exception.HResult
+		FullyQualifiedErrorId = $errorRecord.FullyQualifiedErrorId
+		Category = [string]$errorRecord.CategoryInfo.Category
+		Reason = $errorRecord.CategoryInfo.Reason
+		TargetName = $errorRecord.CategoryInfo.TargetName
+		TargetType = $errorRecord.CategoryInfo.TargetType
+		TargetObject = $targetObject
+		TargetState = $targetState
+		Message = $exception.Message
+	}
+	Write-Output ('` + ErrorMarker + `' + (ConvertTo-Json -InputObject $envelope -Compress))
+	exit 1
+}
+`
+
+// WrapScript surrounds a script with the error handling that reports terminating errors as a ScriptError. The script
+// writes its result with Write-Result, which DecodeJSON reads back.
+func WrapScript(script string) string {
+	return scriptPrelude + scriptWrapperHeader + script + scriptWrapperFooter
+}
+
+// ScriptError is a terminating error raised by a script on a Hyper-V host. Match it against the api.Err* errors
+// with errors.Is.
+type ScriptError struct {
+	ExceptionType         string
+	HResult               int32
+	FullyQualifiedErrorId string
+	Category              string
+	Reason                string
+	TargetName            string
+	TargetType            string
+	TargetObject          string
+	TargetState           string
+	Message               string
+
+	ExitCode int    `json:"-"`
+	Stderr   string `json:"-"`
+}
+
+func (e *ScriptError) Error() string {
+	message := e.Message
+	if message == "" {
+		message = e.ExceptionType
+	}
+
+	if e.FullyQualifiedErrorId == "" {
+		return message
+	}
+
+	return fmt.Sprintf("%s (%s)", message, e.FullyQualifiedErrorId)
+}
+
+// Unwrap returns the api.Err* error the script error is classified as, if any.
+func (e *ScriptError) Unwrap() error {
+	err := e.kind()
+	if err == api.ErrInvalidState && transitionStates[e.TargetState] {
+		return api.ErrTransitionState
+	}
+
+	return err
+}
+
+func (e *ScriptError) kind() error {
+	errorId, _, _ := strings.Cut(e.FullyQualifiedErrorId, ",")
+	if err, ok := errorIds[errorId]; ok {
+		return err
+	}
+
+	if err, ok := categories[e.Category]; ok {
+		return err
+	}
+
+	if err, ok := hResults[uint32(e.HResult)]; ok {
+		return err
+	}
+
+	if err, ok := exceptionTypes[e.ExceptionType]; ok {
+		return err
+	}
+
+	return nil
+}
+
+// errorIds classifies the first part of a FullyQualifiedErrorId, which Hyper-V cmdlets set to the kind of failure.
+var errorIds = map[string]error{
+	"ObjectNotFound":     api.ErrNotFound,
+	"AlreadyExists":      api.ErrAlreadyExists,
+	"ObjectExists":       api.ErrAlreadyExists,
+	"ObjectInUse":        api.ErrResourceBusy,
+	"ResourceBusy":       api.ErrResourceBusy,
+	"AccessDenied":       api.ErrAccessDenied,
+	"UnauthorizedAccess": api.ErrAccessDenied,
+	"InvalidState":       api.ErrInvalidState,
+	"TransitionState":    api.ErrTransitionState,
+}
+
+// transitionStates are the states a VM passes through while it starts, stops, saves, pauses or resumes.
+var transitionStates = map[string]bool{
+	"Starting":           true,
+	"Stopping":           true,
+	"Saving":             true,
+	"Pausing":            true,
+	"Resuming":           true,
+	"Reset":              true,
+	"FastSaving":         true,
+	"ForceShutdown":      true,
+	"ForceReboot":        true,
+	"ComponentServicing": true,
+}
+
+// categories classifies the ErrorCategory of an error record.
+var categories = map[string]error{
+	"ObjectNotFound":   api.ErrNotFound,
+	"ResourceExists":   api.ErrAlreadyExists,
+	"ResourceBusy":     api.ErrResourceBusy,
+	"PermissionDenied": api.ErrAccessDenied,
+}
+
+// hResults classifies the HResult of an exception, mostly Win32 errors raised through the file system.
+var hResults = map[uint32]error{
+	0x80070002: api.ErrNotFound,      // ERROR_FILE_NOT_FOUND
+	0x80070003: api.ErrNotFound,      // ERROR_PATH_NOT_FOUND
+	0x80070005: api.ErrAccessDenied,  // ERROR_ACCESS_DENIED
+	0x80070020: api.ErrResourceBusy,  // ERROR_SHARING_VIOLATION
+	0x80070021: api.ErrResourceBusy,  // ERROR_LOCK_VIOLATION
+	0x800700AA: api.ErrResourceBusy,  // ERROR_BUSY
+	0x80070050: api.ErrAlreadyExists, // ERROR_FILE_EXISTS
+	0x800700B7: api.ErrAlreadyExists, // ERROR_ALREADY_EXISTS
+	0x8007139F: api.ErrInvalidState,  // ERROR_INVALID_STATE
+}
+
+// exceptionTypes classifies exceptions that are raised without a more specific error id or HResult.
+var exceptionTypes = map[string]error{
+	"System.Management.Automation.ItemNotFoundException": api.ErrNotFound,
+	"System.IO.FileNotFoundException":                    api.ErrNotFound,
+	"System.IO.DirectoryNotFoundException":               api.ErrNotFound,
+	"System.UnauthorizedAccessException":                 api.ErrAccessDenied,
+}
+
+// parseScriptError returns the error reported by a wrapped script in its stdout, with secrets masked by redactor.
+func parseScriptError(redactor *redact.Redactor, exitCode int, stdout string, stderr string) (*ScriptError, bool) {
+	var envelope string
+	scanner := bufio.NewScanner(strings.NewReader(stdout))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(stdout)+1)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, ErrorMarker) {
+			envelope = strings.TrimPrefix(line, ErrorMarker)
+		}
+	}
+
+	if envelope == "" {
+		return nil, false
+	}
+
+	scriptError := &ScriptError{}
+	if err := json.Unmarshal([]byte(envelope), scriptError); err != nil {
+		return nil, false
+	}
+
+	scriptError.Message = redactor.String(scriptError.Message)
+	scriptError.TargetObject = redactor.String(scriptError.TargetObject)
+	scriptError.ExitCode = exitCode
+	scriptError.Stderr = redactor.String(stderr)
+	return scriptError, true
+}
+
+// CheckExitCode returns an error when a script did not exit successfully. Failures reported by a wrapped script are
+// returned as a *ScriptError. Secrets are masked with redactor.
+func CheckExitCode(redactor *redact.Redactor, exitCode int, stdout string, stderr string) error {
+	if exitCode == 0 {
+		return nil
+	}
+
+	if scriptError, ok := parseScriptError(redactor, exitCode, stdout, stderr); ok {
+		return scriptError
+	}
+
+	return fmt.Errorf("command failed with exit code %d: %s", exitCode, redactor.String(stderr))
+}
@@ -0,0 +1,175 @@
+package commandresult
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/taliesins/terraform-provider-hyperv/api"
+)
+
+func TestWrapScript(t *testing.T) {
+	t.Parallel()
+
+	wrapped := WrapScript("Get-VM -Name 'web'")
+
+	if !strings.HasPrefix(wrapped, scriptPrelude+"try {\nGet-VM -Name 'web'\n} catch {") {
+		t.Fatalf("expected script to run inside try, got %q", wrapped)
+	}
+
+	if !strings.Contains(wrapped, "Write-Output ('"+ErrorMarker+"' + (ConvertTo-Json") {
+		t.Fatalf("expected error envelope to be written after the marker, got %q", wrapped)
+	}
+
+	if !strings.HasSuffix(wrapped, "\texit 1\n}\n") {
+		t.Fatalf("expected wrapped script to exit with 1 on error, got %q", wrapped)
+	}
+}
+
+func TestDecodeJSONScriptError(t *testing.T) {
+	t.Parallel()
+
+	var result map[string]interface{}
+
+	stdout := `{"partial":true}
+` + ErrorMarker + `{"ExceptionType":"Microsoft.HyperV.PowerShell.VirtualizationException","HResult":-2146233087,"FullyQualifiedErrorId":"ObjectInUse,Microsoft.Vhd.PowerShell.Cmdlets.GetVHD","Category":"ResourceBusy","Reason":"VirtualizationException","TargetName":"","TargetType":"","TargetObject":null,"Message":"Der Vorgang kann nicht ausgeführt werden, während das Objekt verwendet wird."}
+`
+
+	err := DecodeJSON(nil, 1, stdout, "", "test-command", &result)
+
+	var scriptError *ScriptError
+	if !errors.As(err, &scriptError) {
+		t.Fatalf("expected a script error, got %v", err)
+	}
+
+	if scriptError.ExitCode != 1 {
+		t.Fatalf("expected exit code 1, got %d", scriptError.ExitCode)
+	}
+
+	if want := "Der Vorgang kann nicht ausgeführt werden, während das Objekt verwendet wird. (ObjectInUse,Microsoft.Vhd.PowerShell.Cmdlets.GetVHD)"; err.Error() != want {
+		t.Fatalf("expected error %q, got %q", want, err.Error())
+	}
+
+	if !errors.Is(err, api.ErrResourceBusy) {
+		t.Fatalf("expected %v to be a resource busy error", err)
+	}
+}
+
+func TestCheckExitCode(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		exitCode int
+		stdout   string
+		stderr   string
+		wantErr  string
+	}{
+		{
+			name:     "success",
+			exitCode: 0,
+			stdout:   ErrorMarker + `{"Message":"ignored"}`,
+		},
+		{
+			name:     "unwrapped failure",
+			exitCode: 3,
+			stderr:   "VM does not exist",
+			wantErr:  "command failed with exit code 3: VM does not exist",
+		},
+		{
+			name:     "wrapped failure",
+			exitCode: 1,
+			stdout:   "\r\n" + ErrorMarker + `{"FullyQualifiedErrorId":"ObjectNotFound","Category":"ObjectNotFound","Message":"VM does not exist - web"}` + "\r\n",
+			wantErr:  "VM does not exist - web (ObjectNotFound)",
+		},
+		{
+			name:     "malformed envelope",
+			exitCode: 1,
+			stdout:   ErrorMarker + `{"Message":`,
+			stderr:   "failed",
+			wantErr:  "command failed with exit code 1: failed",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := CheckExitCode(nil, tc.exitCode, tc.stdout, tc.stderr)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+
+			if err == nil || err.Error() != tc.wantErr {
+				t.Fatalf("expected error %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestScriptErrorIs(t *testing.T) {
+	t.Parallel()
+
+	sentinels := []error{api.ErrNotFound, api.ErrAlreadyExists, api.ErrResourceBusy, api.ErrAccessDenied, api.ErrInvalidState}
+
+	tests := []struct {
+		name string
+		err  *ScriptError
+		want error
+	}{
+		{
+			name: "error id of a cmdlet",
+			err:  &ScriptError{FullyQualifiedErrorId: "InvalidState,Microsoft.HyperV.PowerShell.Commands.SetVMProcessor", Category: "InvalidOperation"},
+			want: api.ErrInvalidState,
+		},
+		{
+			name: "error id of Write-Error",
+			err:  &ScriptError{FullyQualifiedErrorId: "AlreadyExists", Category: "ResourceExists"},
+			want: api.ErrAlreadyExists,
+		},
+		{
+			name: "category",
+			err:  &ScriptError{FullyQualifiedErrorId: "Microsoft.PowerShell.Commands.WriteErrorException", Category: "ObjectNotFound"},
+			want: api.ErrNotFound,
+		},
+		{
+			name: "permission denied category",
+			err:  &ScriptError{FullyQualifiedErrorId: "System.Management.Automation.RuntimeException", Category: "PermissionDenied"},
+			want: api.ErrAccessDenied,
+		},
+		{
+			name: "access denied hresult",
+			err:  &ScriptError{ExceptionType: "System.ComponentModel.Win32Exception", HResult: -2147024891, Category: "NotSpecified"},
+			want: api.ErrAccessDenied,
+		},
+		{
+			name: "already exists hresult",
+			err:  &ScriptError{ExceptionType: "System.IO.IOException", HResult: -2147024713},
+			want: api.ErrAlreadyExists,
+		},
+		{
+			name: "exception type",
+			err:  &ScriptError{ExceptionType: "System.Management.Automation.ItemNotFoundException", HResult: -2146233087, FullyQualifiedErrorId: "PathNotFound,Microsoft.PowerShell.Commands.GetItemCommand"},
+			want: api.ErrNotFound,
+		},
+		{
+			name: "unclassified",
+			err:  &ScriptError{ExceptionType: "System.Management.Automation.RuntimeException", HResult: -2146233087, FullyQualifiedErrorId: "7z.exe needed", Category: "OperationStopped"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			for _, sentinel := range sentinels {
+				if got := errors.Is(tc.err, sentinel); got != (sentinel == tc.want) {
+					t.Fatalf("errors.Is(%v, %v) = %v", tc.err, sentinel, got)
+				}
+			}
+		})
+	}
+}
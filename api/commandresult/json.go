@@ -3,23 +3,42 @@ package commandresult
 import (
 	"encoding/json"
 	"fmt"
+	"log"
 	"strings"
+
+	"github.com/taliesins/terraform-provider-hyperv/api/redact"
 )
 
-// DecodeJSON validates command execution output and decodes JSON stdout into result.
-func DecodeJSON(exitStatus int, stdout, stderr, command string, result interface{}) error {
+// DecodeJSON validates command execution output and decodes JSON stdout into result. When the script wrote its result
+// with Write-Result only that result is decoded, and the other output is logged. Records of the script are skipped.
+// Failures reported by a wrapped script are returned as a *ScriptError. Secrets are masked with redactor before
+// anything is logged or returned in an error.
+func DecodeJSON(redactor *redact.Redactor, exitStatus int, stdout, stderr, command string, result interface{}) error {
 	stdout = strings.TrimSpace(stdout)
 
 	if exitStatus != 0 {
-		return fmt.Errorf("exitStatus:%d\nstdOut:%s\nstdErr:%s\ncommand:%s", exitStatus, stdout, stderr, command)
+		if scriptError, ok := parseScriptError(redactor, exitStatus, stdout, stderr); ok {
+			return scriptError
+		}
+
+		return fmt.Errorf("exitStatus:%d\nstdOut:%s\nstdErr:%s\ncommand:%s", exitStatus, redactor.String(stdout), redactor.String(stderr), redactor.String(command))
+	}
+
+	if payload, output, ok := extractResult(stdout); ok {
+		if output != "" {
+			log.Printf("[DEBUG] Output of script besides its result:\n%s\n", redactor.String(output))
+		}
+		stdout = payload
+	} else {
+		stdout = output
 	}
 
 	if stdout == "" {
-		return fmt.Errorf("empty stdout from remote command - exitStatus:%d\nstdOut:%s\nstdErr:%s\ncommand:%s", exitStatus, stdout, stderr, command)
+		return fmt.Errorf("empty stdout from remote command - exitStatus:%d\nstdOut:%s\nstdErr:%s\ncommand:%s", exitStatus, stdout, redactor.String(stderr), redactor.String(command))
 	}
 
 	if err := json.Unmarshal([]byte(stdout), result); err != nil {
-		return fmt.Errorf("failed to unmarshal JSON result - exitStatus:%d\nstdOut:%s\nstdErr:%s\nerr:%s\ncommand:%s", exitStatus, stdout, stderr, err, command)
+		return fmt.Errorf("failed to unmarshal JSON result - exitStatus:%d\nstdOut:%s\nstdErr:%s\nerr:%s\ncommand:%s", exitStatus, redactor.String(stdout), redactor.String(stderr), redactor.Error(err), redactor.String(command))
 	}
 
 	return nil
@@ -1,8 +1,11 @@
 package commandresult
 
 import (
+	"errors"
 	"strings"
 	"testing"
+
+	"github.com/taliesins/terraform-provider-hyperv/api/redact"
 )
 
 func TestDecodeJSONSuccess(t *testing.T) {
@@ -12,7 +15,7 @@ func TestDecodeJSONSuccess(t *testing.T) {
 		Value string `json:"value"`
 	}
 
-	err := DecodeJSON(0, `{"value":"ok"}`, "", "test-command", &result)
+	err := DecodeJSON(nil, 0, `{"value":"ok"}`, "", "test-command", &result)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
@@ -27,7 +30,7 @@ func TestDecodeJSONEmptyStdout(t *testing.T) {
 
 	var result map[string]interface{}
 
-	err := DecodeJSON(0, "", "", "test-command", &result)
+	err := DecodeJSON(nil, 0, "", "", "test-command", &result)
 	if err == nil {
 		t.Fatal("expected error for empty stdout")
 	}
@@ -42,7 +45,7 @@ func TestDecodeJSONWhitespaceOnlyStdout(t *testing.T) {
 
 	var result map[string]interface{}
 
-	err := DecodeJSON(0, "   \n\t  ", "", "test-command", &result)
+	err := DecodeJSON(nil, 0, "   \n\t  ", "", "test-command", &result)
 	if err == nil {
 		t.Fatal("expected error for whitespace-only stdout")
 	}
@@ -57,7 +60,7 @@ func TestDecodeJSONExitStatusError(t *testing.T) {
 
 	var result map[string]interface{}
 
-	err := DecodeJSON(1, `{"value":"ok"}`, "failed", "test-command", &result)
+	err := DecodeJSON(nil, 1, `{"value":"ok"}`, "failed", "test-command", &result)
 	if err == nil {
 		t.Fatal("expected error for non-zero exit status")
 	}
@@ -72,7 +75,7 @@ func TestDecodeJSONInvalidJSON(t *testing.T) {
 
 	var result map[string]interface{}
 
-	err := DecodeJSON(0, "not-json", "", "test-command", &result)
+	err := DecodeJSON(nil, 0, "not-json", "", "test-command", &result)
 	if err == nil {
 		t.Fatal("expected error for invalid json")
 	}
@@ -81,3 +84,28 @@ func TestDecodeJSONInvalidJSON(t *testing.T) {
 		t.Fatalf("expected unmarshal error, got %q", err.Error())
 	}
 }
+
+func TestDecodeJSONRedactsSecrets(t *testing.T) {
+	t.Parallel()
+
+	redactor := redact.New("hunter2")
+
+	var result map[string]interface{}
+
+	err := DecodeJSON(redactor, 1, "", "Access denied for hunter2", "Set-Secret -Password hunter2", &result)
+	if err == nil || strings.Contains(err.Error(), "hunter2") {
+		t.Fatalf("expected an error without the secret, got %v", err)
+	}
+
+	stdout := ErrorMarker + `{"ExceptionType":"System.Exception","Message":"Invalid password hunter2","TargetObject":"hunter2"}`
+	err = DecodeJSON(redactor, 1, stdout, "hunter2", "test-command", &result)
+
+	var scriptError *ScriptError
+	if !errors.As(err, &scriptError) {
+		t.Fatalf("expected a script error, got %v", err)
+	}
+
+	if scriptError.Message != "Invalid password ***" || scriptError.TargetObject != "***" || scriptError.Stderr != "***" {
+		t.Fatalf("expected the script error to be masked, got %+v", scriptError)
+	}
+}
@@ -0,0 +1,127 @@
+package commandresult
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/taliesins/terraform-provider-hyperv/api/redact"
+)
+
+// RecordMarker prefixes the lines a wrapped script writes to stdout for its progress, verbose and information records,
+// see Write-Record. The lines are written to the console as they happen, so that they can be forwarded while the
+// script runs.
+const RecordMarker = "#terraform-hyperv-record#"
+
+// Streams of the records written by a wrapped script.
+const (
+	RecordProgress    = "Progress"
+	RecordVerbose     = "Verbose"
+	RecordInformation = "Information"
+)
+
+// Record is a progress, verbose or information record written by a wrapped script with Write-Progress,
+// Write-Verbose or Write-Information.
+type Record struct {
+	Stream           string
+	Activity         string
+	Status           string
+	CurrentOperation string
+	PercentComplete  int // -1 when unknown
+	Completed        bool
+	Message          string
+}
+
+// RecordHandler is called with the records of a script while it runs.
+type RecordHandler func(record Record)
+
+// recordFunctions replace Write-Progress, Write-Verbose and Write-Information in a wrapped script. Records are written
+// to the console rather than to the output of the script, so that they neither end up in variables nor wait for the
+// script to complete. The functions are global, so that they also replace the commands called by functions of modules.
+const recordFunctions = `function global:Write-Record($Record) {
+	[Console]::Out.WriteLine('` + RecordMarker + `' + (ConvertTo-Json -InputObject $Record -Compress))
+	[Console]::Out.Flush()
+}
+function global:Write-Progress {
+	[CmdletBinding()]
+	param([Parameter(Position = 0)][string]$Activity, [Parameter(Position = 1)][string]$Status, [Parameter(Position = 2)][int]$Id, [int]$PercentComplete = -1, [int]$SecondsRemaining = -1, [string]$CurrentOperation, [int]$ParentId = -1, [switch]$Completed, [int]$SourceId)
+	Write-Record @{ Stream = '` + RecordProgress + `'; Activity = $Activity; Status = $Status; CurrentOperation = $CurrentOperation; PercentComplete = $PercentComplete; Completed = [bool]$Completed }
+}
+function global:Write-Verbose {
+	[CmdletBinding()]
+	param([Parameter(Position = 0, Mandatory = $true, ValueFromPipeline = $true)][AllowEmptyString()][string]$Message)
+	process { Write-Record @{ Stream = '` + RecordVerbose + `'; Message = $Message } }
+}
+function global:Write-Information {
+	[CmdletBinding()]
+	param([Parameter(Position = 0, Mandatory = $true)][AllowNull()][object]$MessageData, [Parameter(Position = 1)][string[]]$Tags)
+	Write-Record @{ Stream = '` + RecordInformation + `'; Message = [string]$MessageData }
+}
+`
+
+// ParseRecord returns the record written on a line of the output of a wrapped script.
+func ParseRecord(line string) (Record, bool) {
+	payload, ok := strings.CutPrefix(strings.Trim(line, " \t\r\ufeff"), RecordMarker)
+	if !ok {
+		return Record{}, false
+	}
+
+	record := Record{PercentComplete: -1}
+	if err := json.Unmarshal([]byte(payload), &record); err != nil {
+		return Record{}, false
+	}
+
+	return record, true
+}
+
+// RecordWriter passes the records in the output written to it to a RecordHandler, line by line, with secrets masked
+// by a redactor. Other output is ignored.
+type RecordWriter struct {
+	handler  RecordHandler
+	redactor *redact.Redactor
+	line     []byte
+}
+
+// NewRecordWriter returns a writer passing records to handler. Records are discarded when handler is nil.
+func NewRecordWriter(redactor *redact.Redactor, handler RecordHandler) *RecordWriter {
+	return &RecordWriter{
+		handler:  handler,
+		redactor: redactor,
+	}
+}
+
+func (w *RecordWriter) Write(p []byte) (int, error) {
+	if w.handler == nil {
+		return len(p), nil
+	}
+
+	w.line = append(w.line, p...)
+	for {
+		i := bytes.IndexByte(w.line, '\n')
+		if i < 0 {
+			break
+		}
+
+		w.handleLine(string(w.line[:i]))
+		w.line = w.line[i+1:]
+	}
+
+	if len(w.line) == 0 {
+		w.line = nil
+	}
+
+	return len(p), nil
+}
+
+func (w *RecordWriter) handleLine(line string) {
+	record, ok := ParseRecord(line)
+	if !ok {
+		return
+	}
+
+	record.Activity = w.redactor.String(record.Activity)
+	record.Status = w.redactor.String(record.Status)
+	record.CurrentOperation = w.redactor.String(record.CurrentOperation)
+	record.Message = w.redactor.String(record.Message)
+	w.handler(record)
+}
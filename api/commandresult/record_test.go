@@ -0,0 +1,86 @@
+package commandresult
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/taliesins/terraform-provider-hyperv/api/redact"
+)
+
+func TestParseRecord(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		line   string
+		want   Record
+		wantOk bool
+	}{
+		{
+			name:   "progress",
+			line:   RecordMarker + `{"Stream":"Progress","Activity":"Downloading","Status":"1 of 2 MB","CurrentOperation":"","PercentComplete":50,"Completed":false}`,
+			want:   Record{Stream: RecordProgress, Activity: "Downloading", Status: "1 of 2 MB", PercentComplete: 50},
+			wantOk: true,
+		},
+		{
+			name:   "progress completed",
+			line:   RecordMarker + `{"Stream":"Progress","Activity":"Downloading","Status":"Done","Completed":true}` + "\r",
+			want:   Record{Stream: RecordProgress, Activity: "Downloading", Status: "Done", PercentComplete: -1, Completed: true},
+			wantOk: true,
+		},
+		{
+			name:   "verbose",
+			line:   "\ufeff" + RecordMarker + `{"Stream":"Verbose","Message":"Größe"}`,
+			want:   Record{Stream: RecordVerbose, PercentComplete: -1, Message: "Größe"},
+			wantOk: true,
+		},
+		{name: "output", line: `{"Stream":"Verbose","Message":"not a record"}`},
+		{name: "truncated", line: RecordMarker + `{"Stream":"Verbose",`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, ok := ParseRecord(tt.line)
+			if ok != tt.wantOk || got != tt.want {
+				t.Fatalf("expected %+v (%v), got %+v (%v)", tt.want, tt.wantOk, got, ok)
+			}
+		})
+	}
+}
+
+func TestRecordWriter(t *testing.T) {
+	t.Parallel()
+
+	var records []Record
+	writer := NewRecordWriter(redact.New("hunter2"), func(record Record) {
+		records = append(records, record)
+	})
+
+	output := "Loading personal and system profiles took 812ms.\r\n" +
+		RecordMarker + `{"Stream":"Information","Message":"Connecting as hunter2"}` + "\r\n" +
+		ResultBeginMarker + "\r\n" +
+		RecordMarker + `{"Stream":"Progress","Activity":"Exporting VM web","Status":"Copying","PercentComplete":30}` + "\n" +
+		RecordMarker + `{"Stream":"Verbose","Message":"incomplete"}`
+
+	// Records are split across writes, as they are when read from a connection
+	for i := 0; i < len(output); i += 7 {
+		end := min(i+7, len(output))
+		if n, err := writer.Write([]byte(output[i:end])); err != nil || n != end-i {
+			t.Fatalf("expected %d bytes to be written, got %d: %v", end-i, n, err)
+		}
+	}
+
+	want := []Record{
+		{Stream: RecordInformation, PercentComplete: -1, Message: "Connecting as ***"},
+		{Stream: RecordProgress, Activity: "Exporting VM web", Status: "Copying", PercentComplete: 30},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Fatalf("expected records %+v, got %+v", want, records)
+	}
+
+	if _, err := NewRecordWriter(nil, nil).Write([]byte(output)); err != nil {
+		t.Fatalf("expected records without a handler to be discarded, got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package commandresult
+
+import (
+	"bufio"
+	"strings"
+)
+
+// ResultBeginMarker and ResultEndMarker are written by Write-Result on their own lines around the JSON result of a
+// wrapped script. Only the output between the markers is decoded, so that output of user profiles, module loading or
+// Write-Host around the result does not corrupt it.
+const (
+	ResultBeginMarker = "#terraform-hyperv-result-begin#"
+	ResultEndMarker   = "#terraform-hyperv-result-end#"
+)
+
+// scriptPrelude forces UTF-8 output on both Windows PowerShell 5.1 and PowerShell 7, which otherwise write the OEM
+// code page when stdout is redirected, and defines Write-Result. Setting the console encoding fails when the script is
+// run without a console, in which case output is already passed on as strings. Results are serialized with an
+// explicit depth, as ConvertTo-Json defaults to a depth of 2 and silently truncates nested objects, such as the boot
+// order of a VM, to their type name. Progress, verbose and information records are written as they happen, see
+// recordFunctions.
+const scriptPrelude = `$OutputEncoding = New-Object System.Text.UTF8Encoding $false
+try { [Console]::OutputEncoding = $OutputEncoding } catch {}
+function Write-Result($InputObject) {
+	Write-Output '` + ResultBeginMarker + `'
+	Write-Output (ConvertTo-Json -InputObject $InputObject -Depth 32 -Compress)
+	Write-Output '` + ResultEndMarker + `'
+}
+` + recordFunctions
+
+// extractResult returns the result written between the result markers of stdout, and the remaining output of the
+// script without its records. Line breaks inserted into the result by the host are removed, compressed JSON does not
+// contain any.
+func extractResult(stdout string) (result string, output string, ok bool) {
+	var resultLines, outputLines []string
+	inResult := false
+
+	scanner := bufio.NewScanner(strings.NewReader(stdout))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(stdout)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch trimmed := strings.Trim(line, " \t\r\ufeff"); {
+		case trimmed == ResultBeginMarker:
+			inResult = true
+			resultLines = resultLines[:0]
+		case trimmed == ResultEndMarker && inResult:
+			inResult = false
+			ok = true
+			result = strings.Join(resultLines, "")
+		case strings.HasPrefix(trimmed, RecordMarker):
+		case inResult:
+			resultLines = append(resultLines, strings.TrimRight(line, "\r"))
+		default:
+			outputLines = append(outputLines, line)
+		}
+	}
+
+	return result, strings.TrimSpace(strings.Join(outputLines, "\n")), ok
+}
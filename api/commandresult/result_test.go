@@ -0,0 +1,148 @@
+package commandresult
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScriptPrelude(t *testing.T) {
+	t.Parallel()
+
+	for _, want := range []string{
+		"[Console]::OutputEncoding = $OutputEncoding",
+		"Write-Output '" + ResultBeginMarker + "'",
+		"ConvertTo-Json -InputObject $InputObject -Depth 32 -Compress",
+		"Write-Output '" + ResultEndMarker + "'",
+		"[Console]::Out.WriteLine('" + RecordMarker + "'",
+		"function global:Write-Progress {",
+		"function global:Write-Verbose {",
+		"function global:Write-Information {",
+	} {
+		if !strings.Contains(scriptPrelude, want) {
+			t.Fatalf("expected the prelude to contain %q, got %q", want, scriptPrelude)
+		}
+	}
+}
+
+func TestDecodeJSONResult(t *testing.T) {
+	t.Parallel()
+
+	type bootEntry struct {
+		Device string
+	}
+
+	type result struct {
+		Name      string
+		BootOrder []bootEntry
+	}
+
+	tests := []struct {
+		name   string
+		stdout string
+		want   result
+	}{
+		{
+			name: "result only",
+			stdout: ResultBeginMarker + "\r\n" +
+				`{"Name":"web","BootOrder":[{"Device":"dvd"}]}` + "\r\n" +
+				ResultEndMarker + "\r\n",
+			want: result{Name: "web", BootOrder: []bootEntry{{Device: "dvd"}}},
+		},
+		{
+			name: "output around result",
+			stdout: "Loading personal and system profiles took 812ms.\n" +
+				"VERBOSE: Importing cmdlet 'Get-VM'.\n" +
+				"{\"Name\":\"profile\"}\n" +
+				ResultBeginMarker + "\n" +
+				`{"Name":"web","BootOrder":[]}` + "\n" +
+				ResultEndMarker + "\n" +
+				"Write-Host after the result\n",
+			want: result{Name: "web", BootOrder: []bootEntry{}},
+		},
+		{
+			name: "result wrapped by the host",
+			stdout: ResultBeginMarker + "\n" +
+				`{"Name":"web","Boot` + "\r\n" +
+				`Order":[{"Device":"hdd"}]}` + "\n" +
+				ResultEndMarker + "\n",
+			want: result{Name: "web", BootOrder: []bootEntry{{Device: "hdd"}}},
+		},
+		{
+			name: "markers in values",
+			stdout: ResultBeginMarker + "\n" +
+				`{"Name":"` + ResultEndMarker + `\n` + ResultBeginMarker + `"}` + "\n" +
+				ResultEndMarker + "\n",
+			want: result{Name: ResultEndMarker + "\n" + ResultBeginMarker},
+		},
+		{
+			name: "non-ASCII name",
+			stdout: "\ufeff" + ResultBeginMarker + "\n" +
+				`{"Name":"Größe-日本語-ção"}` + "\n" +
+				ResultEndMarker + "\n",
+			want: result{Name: "Größe-日本語-ção"},
+		},
+		{
+			name: "records around and inside result",
+			stdout: RecordMarker + `{"Stream":"Progress","Activity":"Exporting VM web","PercentComplete":50}` + "\n" +
+				ResultBeginMarker + "\n" +
+				RecordMarker + `{"Stream":"Verbose","Message":"Exported"}` + "\n" +
+				`{"Name":"web","BootOrder":[]}` + "\n" +
+				ResultEndMarker + "\n",
+			want: result{Name: "web", BootOrder: []bootEntry{}},
+		},
+		{
+			name:   "without markers",
+			stdout: `{"Name":"web"}`,
+			want:   result{Name: "web"},
+		},
+		{
+			name:   "records without markers",
+			stdout: RecordMarker + `{"Stream":"Information","Message":"Downloaded"}` + "\n" + `{"Name":"web"}`,
+			want:   result{Name: "web"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var got result
+			if err := DecodeJSON(nil, 0, tt.stdout, "", "test-command", &got); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if got.Name != tt.want.Name || len(got.BootOrder) != len(tt.want.BootOrder) {
+				t.Fatalf("expected %+v, got %+v", tt.want, got)
+			}
+
+			for i := range got.BootOrder {
+				if got.BootOrder[i] != tt.want.BootOrder[i] {
+					t.Fatalf("expected %+v, got %+v", tt.want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractResult(t *testing.T) {
+	t.Parallel()
+
+	stdout := "before\n" + ResultBeginMarker + "\n[1,2]\n" + ResultEndMarker + "\nafter"
+
+	result, output, ok := extractResult(stdout)
+	if !ok {
+		t.Fatal("expected a result")
+	}
+
+	if result != "[1,2]" {
+		t.Fatalf("expected result %q, got %q", "[1,2]", result)
+	}
+
+	if output != "before\nafter" {
+		t.Fatalf("expected output %q, got %q", "before\nafter", output)
+	}
+
+	if _, _, ok := extractResult("before\n" + ResultBeginMarker + "\n[1,"); ok {
+		t.Fatal("expected no result without an end marker")
+	}
+}
@@ -0,0 +1,124 @@
+package credential_helper
+
+// Credential helpers supply the passwords and keys of the provider from an external program, such as the CLI of a
+// password vault, the way git and docker credential helpers do.
+//
+// The helper is run with the argument get. It reads a Request as JSON from stdin and writes Credentials as JSON to
+// stdout, then exits with 0. Anything it writes to stderr is returned in the error when it fails.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// RefreshBefore is how long before they expire credentials are requested again, so that a connection is not
+	// opened with credentials that expire while it authenticates.
+	RefreshBefore = time.Minute
+
+	// processWaitDelay bounds how long output is awaited once an interrupted helper was killed.
+	processWaitDelay = 5 * time.Second
+)
+
+// Request describes the connection credentials are requested for.
+type Request struct {
+	Host      string `json:"host"`
+	User      string `json:"user"`
+	Transport string `json:"transport"`
+}
+
+// Credentials are the credentials returned by a helper. Empty fields keep the value configured in the provider.
+// Credentials without ExpiresAt are kept for the lifetime of the provider, others are requested again before they
+// expire.
+type Credentials struct {
+	Username             string    `json:"username"`
+	Password             string    `json:"password"`
+	PrivateKey           string    `json:"private_key"`
+	PrivateKeyPassphrase string    `json:"private_key_passphrase"`
+	ExpiresAt            time.Time `json:"expires_at"`
+}
+
+// Helper runs a credential helper and caches the credentials it returns.
+type Helper struct {
+	command string
+	request Request
+
+	// now returns the current time, it is replaced by tests
+	now func() time.Time
+
+	mu          sync.Mutex
+	credentials *Credentials
+}
+
+// New returns a helper running command to request the credentials of request.
+func New(command string, request Request) *Helper {
+	return &Helper{
+		command: command,
+		request: request,
+		now:     time.Now,
+	}
+}
+
+// Get returns the credentials of the helper, running it when no credentials were returned yet or when they are about
+// to expire.
+func (h *Helper) Get(ctx context.Context) (*Credentials, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.credentials != nil && (h.credentials.ExpiresAt.IsZero() || h.now().Add(RefreshBefore).Before(h.credentials.ExpiresAt)) {
+		return h.credentials, nil
+	}
+
+	log.Printf("[DEBUG] Requesting credentials for %s@%s over %s from %s", h.request.User, h.request.Host, h.request.Transport, h.command)
+
+	credentials, err := h.run(ctx)
+	if err != nil {
+		return nil, err
+	}
+	h.credentials = credentials
+
+	return credentials, nil
+}
+
+func (h *Helper) run(ctx context.Context) (*Credentials, error) {
+	request, err := json.Marshal(h.request)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, h.command, "get")
+	cmd.Stdin = bytes.NewReader(request)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.WaitDelay = processWaitDelay
+
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("credential helper %s failed: %w: %s", h.command, err, message)
+		}
+		return nil, fmt.Errorf("credential helper %s failed: %w", h.command, err)
+	}
+
+	// The output is not included in errors, it holds the credentials
+	var credentials Credentials
+	if err := json.Unmarshal(stdout.Bytes(), &credentials); err != nil {
+		return nil, fmt.Errorf("credential helper %s returned invalid credentials, expected a JSON object", h.command)
+	}
+	if credentials.Password == "" && credentials.PrivateKey == "" {
+		return nil, fmt.Errorf("credential helper %s returned neither a password nor a private key", h.command)
+	}
+
+	if !credentials.ExpiresAt.IsZero() && !h.now().Before(credentials.ExpiresAt) {
+		return nil, fmt.Errorf("credential helper %s returned credentials that expired at %s", h.command, credentials.ExpiresAt.Format(time.RFC3339))
+	}
+
+	return &credentials, nil
+}
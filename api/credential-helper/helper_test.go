@@ -0,0 +1,168 @@
+package credential_helper
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubHelper records its arguments and request next to itself, counts its runs and writes the content of the
+// response file, or fails with the content of the error file.
+const stubHelper = `#!/bin/sh
+dir="$(dirname "$0")"
+printf '%s\n' "$*" > "$dir/args"
+cat > "$dir/request"
+echo run >> "$dir/runs"
+if [ -f "$dir/error" ]; then
+	cat "$dir/error" >&2
+	exit 3
+fi
+cat "$dir/response"
+`
+
+func newStubHelper(t *testing.T, response string) (*Helper, string) {
+	t.Helper()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("the stub credential helper is a shell script")
+	}
+
+	dir := t.TempDir()
+	command := filepath.Join(dir, "hyperv-credential-helper")
+	if err := os.WriteFile(command, []byte(stubHelper), 0o700); err != nil {
+		t.Fatalf("failed to write stub helper: %v", err)
+	}
+	writeFile(t, filepath.Join(dir, "response"), response)
+
+	return New(command, Request{Host: "hv01", User: "Administrator", Transport: "ssh"}), dir
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func runs(t *testing.T, dir string) int {
+	t.Helper()
+
+	content, err := os.ReadFile(filepath.Join(dir, "runs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.Count(string(content), "run")
+}
+
+func TestHelperGet(t *testing.T) {
+	t.Parallel()
+
+	helper, dir := newStubHelper(t, `{"username":"svc-hyperv","password":"P@ssw0rd"}`)
+
+	for i := 0; i < 3; i++ {
+		credentials, err := helper.Get(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if credentials.Username != "svc-hyperv" || credentials.Password != "P@ssw0rd" {
+			t.Fatalf("unexpected credentials %+v", credentials)
+		}
+	}
+
+	if got := runs(t, dir); got != 1 {
+		t.Fatalf("expected the credentials to be cached, got %d runs", got)
+	}
+
+	args, err := os.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(args) != "get\n" {
+		t.Fatalf("expected the helper to be run with get, got %q", args)
+	}
+
+	var request Request
+	content, err := os.ReadFile(filepath.Join(dir, "request"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(content, &request); err != nil {
+		t.Fatal(err)
+	}
+	if request != (Request{Host: "hv01", User: "Administrator", Transport: "ssh"}) {
+		t.Fatalf("unexpected request %s", content)
+	}
+}
+
+func TestHelperRotation(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	helper, dir := newStubHelper(t, `{"password":"first","expires_at":"2026-10-17T12:10:00Z"}`)
+	helper.now = func() time.Time { return now }
+
+	credentials, err := helper.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credentials.Password != "first" {
+		t.Fatalf("expected the first password, got %q", credentials.Password)
+	}
+
+	// Credentials are kept until they are about to expire
+	writeFile(t, filepath.Join(dir, "response"), `{"password":"second","expires_at":"2026-10-17T12:20:00Z"}`)
+	now = now.Add(8 * time.Minute)
+	if credentials, err = helper.Get(context.Background()); err != nil || credentials.Password != "first" {
+		t.Fatalf("expected the first password to be kept, got %+v (%v)", credentials, err)
+	}
+
+	now = now.Add(90 * time.Second)
+	if credentials, err = helper.Get(context.Background()); err != nil || credentials.Password != "second" {
+		t.Fatalf("expected the rotated password, got %+v (%v)", credentials, err)
+	}
+	if got := runs(t, dir); got != 2 {
+		t.Fatalf("expected 2 runs, got %d", got)
+	}
+}
+
+func TestHelperErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		response string
+		stderr   string
+		wantErr  string
+	}{
+		{name: "failed", stderr: "vault is sealed", wantErr: "vault is sealed"},
+		{name: "invalid json", response: "P@ssw0rd", wantErr: "invalid credentials"},
+		{name: "no secret", response: `{"username":"svc-hyperv"}`, wantErr: "neither a password nor a private key"},
+		{name: "expired", response: `{"password":"P@ssw0rd","expires_at":"2000-01-01T00:00:00Z"}`, wantErr: "expired"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			helper, dir := newStubHelper(t, tt.response)
+			if tt.stderr != "" {
+				writeFile(t, filepath.Join(dir, "error"), tt.stderr)
+			}
+
+			_, err := helper.Get(context.Background())
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+			}
+			if strings.Contains(err.Error(), "P@ssw0rd") {
+				t.Fatalf("expected the output of the helper not to be returned, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors reported by Hyper-V hosts. They are classified from the exception type, HResult, FullyQualifiedErrorId
+// and error category of the failure rather than from its message, which is localized, so match them with errors.Is.
+var (
+	// ErrNotFound is returned when a VM, switch, file or other object does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrAlreadyExists is returned when an object with the same name or path already exists.
+	ErrAlreadyExists = errors.New("already exists")
+	// ErrResourceBusy is returned when an object is in use, for example a VHD attached to a running VM.
+	ErrResourceBusy = errors.New("resource busy")
+	// ErrAccessDenied is returned when the account used to connect lacks the rights for an operation.
+	ErrAccessDenied = errors.New("access denied")
+	// ErrInvalidState is returned when an operation is not possible in the current state of an object, for example
+	// changing the processors of a running VM.
+	ErrInvalidState = errors.New("invalid state")
+	// ErrTransitionState is an ErrInvalidState returned while a VM is changing state, for example starting or
+	// saving. Unlike other invalid states it goes away once the VM reaches its final state.
+	ErrTransitionState = fmt.Errorf("in transition: %w", ErrInvalidState)
+)
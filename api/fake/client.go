@@ -0,0 +1,193 @@
+// Package fake is an in-memory Hyper-V host implementing api.Client, for tests that exercise resources end to end
+// without a Windows machine.
+//
+// The host state lives in memory and follows the rules Hyper-V enforces: VM and switch names are unique,
+// a controller slot holds a single drive, power state changes go through api.VmState, settings that need a
+// stopped VM are refused while it runs and VHDs attached to a running VM are in use.
+//
+// The CreateOrUpdate* methods reconcile through the exported primitives, exactly like the hyperv client,
+// so failures can be injected part way through a reconciliation to reproduce partial failures.
+package fake
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/taliesins/terraform-provider-hyperv/api"
+)
+
+// DefaultVmPath is where VMs are stored when no path is given, as on a default Hyper-V installation.
+const DefaultVmPath = `C:\ProgramData\Microsoft\Windows\Hyper-V`
+
+var _ api.Client = (*Client)(nil)
+
+type failure struct {
+	err  error
+	once bool
+}
+
+// Client is an in-memory Hyper-V host. The zero value is not usable, create one with New.
+type Client struct {
+	mu sync.Mutex
+
+	vms      map[string]*vm
+	vmOrder  []string
+	switches map[string]*api.VmSwitch
+	vhds     map[string]*api.Vhd
+	files    map[string]string
+
+	nextIp int
+
+	failures map[string][]failure
+	calls    map[string]int
+}
+
+type vm struct {
+	api.Vm
+
+	state               api.VmState
+	processor           api.VmProcessor
+	firmware            api.VmFirmware
+	integrationServices []api.VmIntegrationService
+	networkAdapters     []api.VmNetworkAdapter
+	dvdDrives           []api.VmDvdDrive
+	hardDiskDrives      []api.VmHardDiskDrive
+}
+
+// New creates an empty Hyper-V host.
+func New() *Client {
+	return &Client{
+		vms:      map[string]*vm{},
+		switches: map[string]*api.VmSwitch{},
+		vhds:     map[string]*api.Vhd{},
+		files:    map[string]string{},
+		failures: map[string][]failure{},
+		calls:    map[string]int{},
+	}
+}
+
+// FailNext makes the next call to method, named after the api.Client method, fail with err before it
+// changes anything.
+func (c *Client) FailNext(method string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.failures[method] = append(c.failures[method], failure{err: err, once: true})
+}
+
+// Fail makes every call to method fail with err until ClearFailures is called.
+func (c *Client) Fail(method string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.failures[method] = append(c.failures[method], failure{err: err})
+}
+
+// ClearFailures removes every injected failure.
+func (c *Client) ClearFailures() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.failures = map[string][]failure{}
+}
+
+// Calls returns the number of calls made to method.
+func (c *Client) Calls(method string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.calls[method]
+}
+
+// enter records a call to method and returns the error it has to fail with. c.mu must be held.
+func (c *Client) enter(ctx context.Context, method string) error {
+	c.calls[method]++
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	failures := c.failures[method]
+	if len(failures) == 0 {
+		return nil
+	}
+
+	if failures[0].once {
+		c.failures[method] = failures[1:]
+	}
+
+	return failures[0].err
+}
+
+// AddFile creates a file on the host, for example a VHD or ISO to attach.
+func (c *Client) AddFile(path string, content string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.files[pathKey(path)] = content
+}
+
+// SetVmState forces the state of a VM, for example to simulate a critical state or a guest shutdown.
+func (c *Client) SetVmState(name string, state api.VmState) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	vm, err := c.vm(name)
+	if err != nil {
+		return err
+	}
+
+	c.setState(vm, state)
+	return nil
+}
+
+// VmNames returns the names of the VMs in creation order.
+func (c *Client) VmNames() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string{}, c.vmOrder...)
+}
+
+// vm returns the VM named name. c.mu must be held.
+func (c *Client) vm(name string) (*vm, error) {
+	vm, ok := c.vms[name]
+	if !ok {
+		return nil, newHostError(api.ErrNotFound, "VM does not exist - %s", name)
+	}
+
+	return vm, nil
+}
+
+// requireOff refuses changes that Hyper-V only accepts while the VM is stopped.
+func requireOff(vm *vm, change string) error {
+	if vm.state != api.VmState_Off {
+		return newHostError(api.ErrInvalidState, "failed to %s of VM %s: the operation cannot be performed while the virtual machine is in its current state (%s)", change, vm.Name, vm.state)
+	}
+
+	return nil
+}
+
+// pathKey identifies a path on the host, which is case insensitive and accepts either separator.
+func pathKey(path string) string {
+	return strings.ToLower(api.ToWindowsPath(path))
+}
+
+// fileExists reports whether path is a file on the host. c.mu must be held.
+func (c *Client) fileExists(path string) bool {
+	_, ok := c.files[pathKey(path)]
+	return ok
+}
+
+// directoryExists reports whether a file on the host is under path. c.mu must be held.
+func (c *Client) directoryExists(path string) bool {
+	prefix := strings.TrimSuffix(pathKey(path), `\`) + `\`
+	for key := range c.files {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,501 @@
+package fake
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/taliesins/terraform-provider-hyperv/api"
+)
+
+func createVm(t *testing.T, c *Client, name string, generation int) {
+	t.Helper()
+
+	err := c.CreateVm(context.Background(), name, "", generation, api.CriticalErrorAction_Pause, 30, api.StartAction_StartIfRunning, 0, api.StopAction_Save, api.CheckpointType_Production, false, false, 536870912, api.OnOffState_Off, 134217728, 1073741824, 536870912, 536870912, "", 1, "", "", true)
+	if err != nil {
+		t.Fatalf("unable to create VM %s: %s", name, err)
+	}
+}
+
+func createVhd(t *testing.T, c *Client, path string) {
+	t.Helper()
+
+	err := c.CreateOrUpdateVhd(context.Background(), path, "", "", 0, api.VhdType_Dynamic, "", 10737418240, 0, 0, 0)
+	if err != nil {
+		t.Fatalf("unable to create VHD %s: %s", path, err)
+	}
+}
+
+func createSwitch(t *testing.T, c *Client, name string) {
+	t.Helper()
+
+	err := c.CreateVMSwitch(context.Background(), name, "", true, false, false, false, api.VMSwitchBandwidthMode_None, api.VMSwitchType_Internal, nil, 0, 0, false, 0, false)
+	if err != nil {
+		t.Fatalf("unable to create switch %s: %s", name, err)
+	}
+}
+
+func requireError(t *testing.T, err error, contains string) {
+	t.Helper()
+
+	if err == nil || !strings.Contains(err.Error(), contains) {
+		t.Fatalf("expected an error containing %q, got %v", contains, err)
+	}
+}
+
+func TestCreateVm(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	c := New()
+	createVm(t, c, "web", 2)
+
+	vm, err := c.GetVm(ctx, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vm.Name != "web" || vm.Generation != 2 || vm.Path != DefaultVmPath || !vm.StaticMemory || vm.DynamicMemory {
+		t.Fatalf("unexpected VM %+v", vm)
+	}
+
+	status, err := c.GetVmStatus(ctx, "web")
+	if err != nil || status.State != api.VmState_Off {
+		t.Fatalf("expected a new VM to be off, got %v: %v", status.State, err)
+	}
+
+	integrationServices, err := c.GetVmIntegrationServices(ctx, "web")
+	if err != nil || len(integrationServices) == 0 {
+		t.Fatalf("expected default integration services, got %v: %v", integrationServices, err)
+	}
+
+	err = c.CreateVm(ctx, "web", "", 2, api.CriticalErrorAction_Pause, 30, api.StartAction_StartIfRunning, 0, api.StopAction_Save, api.CheckpointType_Production, false, false, 0, api.OnOffState_Off, 0, 0, 0, 536870912, "", 1, "", "", true)
+	requireError(t, err, "VM already exists - web")
+
+	missing, err := c.GetVm(ctx, "missing")
+	if err != nil || missing.Name != "" {
+		t.Fatalf("expected an empty VM for a missing VM, got %+v: %v", missing, err)
+	}
+}
+
+func TestCreateVmValidation(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name               string
+		generation         int
+		processorCount     int64
+		memoryStartupBytes int64
+		expected           string
+	}{
+		{name: "generation", generation: 3, processorCount: 1, memoryStartupBytes: 536870912, expected: "invalid generation 3"},
+		{name: "processors", generation: 2, processorCount: 0, memoryStartupBytes: 536870912, expected: "invalid processor count 0"},
+		{name: "memory", generation: 2, processorCount: 1, memoryStartupBytes: 536870913, expected: "not a multiple of 2 MB"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := New().CreateVm(context.Background(), "web", "", tc.generation, api.CriticalErrorAction_Pause, 30, api.StartAction_StartIfRunning, 0, api.StopAction_Save, api.CheckpointType_Production, false, false, 0, api.OnOffState_Off, 0, 0, 0, tc.memoryStartupBytes, "", tc.processorCount, "", "", true)
+			requireError(t, err, tc.expected)
+		})
+	}
+}
+
+func TestUpdateVmStatus(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		from     api.VmState
+		to       api.VmState
+		expected string
+	}{
+		{name: "start", from: api.VmState_Off, to: api.VmState_Running},
+		{name: "stop", from: api.VmState_Running, to: api.VmState_Off},
+		{name: "pause", from: api.VmState_Running, to: api.VmState_Paused},
+		{name: "resume", from: api.VmState_Paused, to: api.VmState_Running},
+		{name: "unchanged", from: api.VmState_Off, to: api.VmState_Off},
+		{name: "pause stopped", from: api.VmState_Off, to: api.VmState_Paused, expected: "Unable to change VM web state Off to Paused state"},
+		{name: "critical", from: api.VmState_RunningCritical, to: api.VmState_Off, expected: "requires manual intervention"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			c := New()
+			createVm(t, c, "web", 2)
+			if err := c.SetVmState("web", tc.from); err != nil {
+				t.Fatal(err)
+			}
+
+			err := c.UpdateVmStatus(ctx, "web", 30, 1, tc.to)
+			if tc.expected != "" {
+				requireError(t, err, tc.expected)
+				if !errors.Is(err, api.ErrInvalidState) {
+					t.Fatalf("expected an invalid state error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			status, err := c.GetVmStatus(ctx, "web")
+			if err != nil || status.State != tc.to {
+				t.Fatalf("expected state %v, got %v: %v", tc.to, status.State, err)
+			}
+		})
+	}
+
+	t.Run("missing", func(t *testing.T) {
+		t.Parallel()
+
+		err := New().UpdateVmStatus(context.Background(), "missing", 30, 1, api.VmState_Off)
+		requireError(t, err, "VM does not exist - missing")
+		if !errors.Is(err, api.ErrNotFound) {
+			t.Fatalf("expected a not found error, got %v", err)
+		}
+	})
+}
+
+func TestUpdateVmRequiresOff(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	c := New()
+	createVm(t, c, "web", 2)
+	if err := c.UpdateVmStatus(ctx, "web", 30, 1, api.VmState_Running); err != nil {
+		t.Fatal(err)
+	}
+
+	update := func(processorCount int64, notes string) error {
+		return c.UpdateVm(ctx, "web", api.CriticalErrorAction_Pause, 30, api.StartAction_StartIfRunning, 0, api.StopAction_Save, api.CheckpointType_Production, false, false, 536870912, api.OnOffState_Off, 134217728, 1073741824, 536870912, 536870912, notes, processorCount, "", "", true)
+	}
+
+	if err := update(1, "running notes"); err != nil {
+		t.Fatalf("expected notes to change while running: %s", err)
+	}
+
+	requireError(t, update(2, "running notes"), "cannot be performed while the virtual machine is in its current state (Running)")
+
+	if err := c.UpdateVmStatus(ctx, "web", 30, 1, api.VmState_Off); err != nil {
+		t.Fatal(err)
+	}
+	if err := update(2, "running notes"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDriveSlots(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	c := New()
+	createVm(t, c, "gen1", 1)
+	createVm(t, c, "gen2", 2)
+	createVhd(t, c, `C:\vhds\gen1.vhdx`)
+	createVhd(t, c, `C:\vhds\gen2.vhdx`)
+	c.AddFile(`C:\isos\install.iso`, "iso")
+
+	if err := c.CreateVmHardDiskDrive(ctx, "gen1", api.ControllerType_Ide, 0, 0, `C:\vhds\gen1.vhdx`, 0, "", false, 0, 0, "", api.CacheAttributes_Default); err != nil {
+		t.Fatal(err)
+	}
+
+	err := c.CreateVmDvdDrive(ctx, "gen1", 0, 0, `C:\isos\install.iso`, "")
+	requireError(t, err, "already has a drive attached at Ide controller 0 location 0")
+
+	if err := c.CreateVmDvdDrive(ctx, "gen1", 1, 0, `C:\isos\install.iso`, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.CreateVmHardDiskDrive(ctx, "gen2", api.ControllerType_Ide, 0, 0, `C:\vhds\gen2.vhdx`, 0, "", false, 0, 0, "", api.CacheAttributes_Default)
+	requireError(t, err, "has no IDE controller")
+
+	err = c.CreateVmHardDiskDrive(ctx, "gen2", api.ControllerType_Scsi, 0, 64, `C:\vhds\gen2.vhdx`, 0, "", false, 0, 0, "", api.CacheAttributes_Default)
+	requireError(t, err, "has no Scsi controller 0 location 64")
+
+	err = c.CreateVmHardDiskDrive(ctx, "gen2", api.ControllerType_Scsi, 0, 0, `C:\vhds\gen1.vhdx`, 0, "", false, 0, 0, "", api.CacheAttributes_Default)
+	requireError(t, err, "object is in use by VM gen1")
+
+	err = c.CreateVmHardDiskDrive(ctx, "gen2", api.ControllerType_Scsi, 0, 0, `C:\vhds\missing.vhdx`, 0, "", false, 0, 0, "", api.CacheAttributes_Default)
+	requireError(t, err, "cannot find the file specified")
+
+	if err := c.CreateVmDvdDrive(ctx, "gen2", 0, 1, `c:/isos/install.iso`, ""); err != nil {
+		t.Fatalf("expected ISOs to be attachable to several VMs: %s", err)
+	}
+
+	if err := c.UpdateVmStatus(ctx, "gen1", 30, 1, api.VmState_Running); err != nil {
+		t.Fatal(err)
+	}
+	err = c.DeleteVmDvdDrive(ctx, "gen1", 1, 0)
+	requireError(t, err, "change the drives on the IDE controller")
+}
+
+func TestVhdInUse(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	c := New()
+	createVm(t, c, "web", 2)
+	createVhd(t, c, `C:\vhds\web.vhdx`)
+
+	vhd, err := c.GetVhd(ctx, `C:\vhds\web.vhdx`)
+	if err != nil || vhd.Size != 10737418240 || vhd.VhdFormat != api.VhdFormat_VHDX || vhd.Attached {
+		t.Fatalf("unexpected VHD %+v: %v", vhd, err)
+	}
+
+	if err := c.CreateVmHardDiskDrive(ctx, "web", api.ControllerType_Scsi, 0, 0, `C:\vhds\web.vhdx`, 0, "", false, 0, 0, "", api.CacheAttributes_Default); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.UpdateVmStatus(ctx, "web", 30, 1, api.VmState_Running); err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.ResizeVhd(ctx, `C:\vhds\web.vhdx`, 21474836480)
+	requireError(t, err, "object is in use")
+	if !errors.Is(err, api.ErrResourceBusy) {
+		t.Fatalf("expected a resource busy error, got %v", err)
+	}
+
+	if err := c.DeleteVhd(ctx, `C:\vhds\web.vhdx`); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := c.VhdExists(ctx, `C:\vhds\web.vhdx`); err != nil || !exists.Exists {
+		t.Fatalf("expected an in use VHD to survive deletion: %v", err)
+	}
+
+	if err := c.UpdateVmStatus(ctx, "web", 30, 1, api.VmState_Off); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ResizeVhd(ctx, `C:\vhds\web.vhdx`, 21474836480); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.DeleteVm(ctx, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteVhd(ctx, `C:\vhds\web.vhdx`); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := c.VhdExists(ctx, `C:\vhds\web.vhdx`); err != nil || exists.Exists {
+		t.Fatalf("expected VHD to be deleted: %v", err)
+	}
+}
+
+func TestCreateVhdSize(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	c := New()
+
+	err := c.CreateOrUpdateVhd(ctx, `C:\vhds\empty.vhdx`, "", "", 0, api.VhdType_Dynamic, "", 0, 0, 0, 0)
+	requireError(t, err, `Vhd Size must be specified for - C:\vhds\empty.vhdx`)
+
+	if err := c.CreateOrUpdateVhd(ctx, `C:\vhds\odd.vhd`, "", "", 0, api.VhdType_Fixed, "", 1000, 0, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	vhd, err := c.GetVhd(ctx, `C:\vhds\odd.vhd`)
+	if err != nil || vhd.Size != 1024 || vhd.VhdFormat != api.VhdFormat_VHD {
+		t.Fatalf("expected the size to be rounded up to the logical sector size, got %+v: %v", vhd, err)
+	}
+
+	err = c.CreateOrUpdateVhd(ctx, `C:\vhds\child.vhdx`, "", "", 0, api.VhdType_Differencing, `C:\vhds\missing.vhdx`, 0, 0, 0, 0)
+	requireError(t, err, "parent VHD")
+}
+
+func TestNetworkAdapterIps(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	c := New()
+	createVm(t, c, "web", 2)
+	createSwitch(t, c, "lan")
+
+	err := c.CreateOrUpdateVmNetworkAdapters(ctx, "web", []api.VmNetworkAdapter{{Name: "wan", SwitchName: "missing"}})
+	requireError(t, err, "Switch does not exist - missing")
+
+	err = c.CreateOrUpdateVmNetworkAdapters(ctx, "web", []api.VmNetworkAdapter{{Name: "lan", SwitchName: "lan"}, {Name: "isolated"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitForIps := []api.VmNetworkAdapterWaitForIp{{Name: "lan", WaitForIps: true}, {Name: "isolated", WaitForIps: true}}
+	if err := c.WaitForVmNetworkAdaptersIps(ctx, "web", 30, 1, waitForIps); err != nil {
+		t.Fatalf("expected a stopped VM not to wait for addresses: %s", err)
+	}
+
+	if err := c.UpdateVmStatus(ctx, "web", 30, 1, api.VmState_Running); err != nil {
+		t.Fatal(err)
+	}
+
+	networkAdapters, err := c.GetVmNetworkAdapters(ctx, "web", waitForIps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(networkAdapters) != 2 || len(networkAdapters[0].IpAddresses) != 1 || len(networkAdapters[1].IpAddresses) != 0 || !networkAdapters[0].WaitForIps {
+		t.Fatalf("unexpected network adapters %+v", networkAdapters)
+	}
+
+	err = c.WaitForVmNetworkAdaptersIps(ctx, "web", 30, 1, waitForIps)
+	requireError(t, err, "waiting for network adapter isolated")
+
+	if err := c.DeleteVMSwitch(ctx, "lan"); err != nil {
+		t.Fatal(err)
+	}
+	networkAdapters, err = c.GetVmNetworkAdapters(ctx, "web", nil)
+	if err != nil || networkAdapters[0].SwitchName != "" || len(networkAdapters[0].IpAddresses) != 0 {
+		t.Fatalf("expected the adapter to be disconnected, got %+v: %v", networkAdapters, err)
+	}
+}
+
+func TestSwitches(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	c := New()
+	createSwitch(t, c, "lan")
+	createSwitch(t, c, "wan")
+
+	err := c.CreateVMSwitch(ctx, "lan", "", true, false, false, false, api.VMSwitchBandwidthMode_None, api.VMSwitchType_Internal, nil, 0, 0, false, 0, false)
+	requireError(t, err, "Switch already exists - lan")
+	if !errors.Is(err, api.ErrAlreadyExists) {
+		t.Fatalf("expected an already exists error, got %v", err)
+	}
+
+	err = c.UpdateVMSwitch(ctx, "lan", "wan", "", true, api.VMSwitchType_Internal, nil, 0, 0, false, 0, false)
+	requireError(t, err, "Switch already exists - wan")
+
+	err = c.UpdateVMSwitch(ctx, "missing", "other", "", true, api.VMSwitchType_Internal, nil, 0, 0, false, 0, false)
+	requireError(t, err, "Switch does not exist - missing")
+
+	if err := c.UpdateVMSwitch(ctx, "lan", "external", "", true, api.VMSwitchType_Internal, []string{"Ethernet"}, 0, 0, false, 0, false); err != nil {
+		t.Fatal(err)
+	}
+
+	vmSwitch, err := c.GetVMSwitch(ctx, "external")
+	if err != nil || vmSwitch.SwitchType != api.VMSwitchType_External {
+		t.Fatalf("expected a switch bound to an adapter to be external, got %+v: %v", vmSwitch, err)
+	}
+	if exists, err := c.VMSwitchExists(ctx, "lan"); err != nil || exists.Exists {
+		t.Fatalf("expected the old switch name to be gone: %v", err)
+	}
+}
+
+func TestFirmware(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	c := New()
+	createVm(t, c, "gen1", 1)
+	createVm(t, c, "gen2", 2)
+
+	err := c.CreateOrUpdateVmFirmwares(ctx, "gen1", []api.VmFirmware{{}})
+	requireError(t, err, "generation 1 virtual machine")
+
+	err = c.CreateOrUpdateVmFirmwares(ctx, "gen2", []api.VmFirmware{{}, {}})
+	requireError(t, err, "only 1 vm firmware setting allowed per a vm")
+
+	firmware := api.VmFirmware{EnableSecureBoot: api.OnOffState_Off, ConsoleMode: api.ConsoleModeType_Default}
+	if err := c.CreateOrUpdateVmFirmwares(ctx, "gen2", []api.VmFirmware{firmware}); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := c.GetVmFirmware(ctx, "gen2")
+	if err != nil || result.VmName != "gen2" || result.EnableSecureBoot != api.OnOffState_Off {
+		t.Fatalf("unexpected firmware %+v: %v", result, err)
+	}
+}
+
+func TestIsoImages(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	c := New()
+
+	localPath := filepath.Join(t.TempDir(), "files.zip")
+	if err := os.WriteFile(localPath, []byte("zip"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.RemoteFileUpload(ctx, localPath, `C:\isos\files.zip`); err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := c.RemoteFileHash(ctx, `c:/isos/files.zip`)
+	if err != nil || hash != "4a70fe9aa6436e02c2dea340fbd1e352e4ef2d8ce6ca52ad25d4b95471fc8bf2" {
+		t.Fatalf("unexpected hash %q: %v", hash, err)
+	}
+
+	if exists, err := c.RemoteDirectoryExists(ctx, `C:\isos`); err != nil || !exists {
+		t.Fatalf("expected the upload directory to exist: %v", err)
+	}
+
+	_, err = c.RemoteFileHash(ctx, `C:\isos\missing.zip`)
+	requireError(t, err, `File not found: C:\isos\missing.zip`)
+
+	if err := c.CreateOrUpdateIsoImage(ctx, "", "", "files.zip", hash, "", "", "", `C:\isos\files.zip`, "", api.IsoMediaType_DVDPLUSRW_DUALLAYER, api.IsoFileSystemType_Unknown, "FILES", `C:\isos\files.iso`, `C:\isos\files.zip`, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	isoImage, err := c.GetIsoImage(ctx, `C:\isos\files.iso`)
+	if err != nil || isoImage.ResolveDestinationIsoFilePath != `C:\isos\files.iso` {
+		t.Fatalf("unexpected iso image %+v: %v", isoImage, err)
+	}
+
+	if err := c.RemoteFileDelete(ctx, `C:\isos`); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := c.RemoteFileExists(ctx, `C:\isos\files.iso`); err != nil || exists {
+		t.Fatalf("expected the iso to be deleted: %v", err)
+	}
+}
+
+func TestFailureInjection(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	c := New()
+	createVm(t, c, "web", 2)
+	createSwitch(t, c, "lan")
+
+	injected := errors.New("injected")
+	c.FailNext("CreateVmNetworkAdapter", injected)
+
+	// The reconciliation stops part way through, leaving the first adapter behind
+	err := c.CreateOrUpdateVmNetworkAdapters(ctx, "web", []api.VmNetworkAdapter{{Name: "one", SwitchName: "lan"}, {Name: "two", SwitchName: "lan"}})
+	if !errors.Is(err, injected) {
+		t.Fatalf("expected the injected error, got %v", err)
+	}
+
+	networkAdapters, err := c.GetVmNetworkAdapters(ctx, "web", nil)
+	if err != nil || len(networkAdapters) != 0 {
+		t.Fatalf("expected the failed call not to change anything, got %+v: %v", networkAdapters, err)
+	}
+
+	c.Fail("GetVm", injected)
+	for i := 0; i < 2; i++ {
+		if _, err := c.GetVm(ctx, "web"); !errors.Is(err, injected) {
+			t.Fatalf("expected every call to fail, got %v", err)
+		}
+	}
+
+	c.ClearFailures()
+	if _, err := c.GetVm(ctx, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if calls := c.Calls("GetVm"); calls != 3 {
+		t.Fatalf("expected 3 calls to GetVm, got %d", calls)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := c.VmExists(canceled, "web"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a canceled context to fail the call, got %v", err)
+	}
+}
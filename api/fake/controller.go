@@ -0,0 +1,158 @@
+package fake
+
+import (
+	"fmt"
+
+	"github.com/taliesins/terraform-provider-hyperv/api"
+)
+
+// slot is a position on a drive controller of a VM.
+type slot struct {
+	controllerType     api.ControllerType
+	controllerNumber   int32
+	controllerLocation int32
+}
+
+func (s slot) String() string {
+	return fmt.Sprintf("%s controller %d location %d", s.controllerType, s.controllerNumber, s.controllerLocation)
+}
+
+// dvdDriveSlot returns the slot of a dvd drive, which sits on the IDE controller of generation 1 VMs and the
+// SCSI controller of generation 2 VMs.
+func dvdDriveSlot(vm *vm, controllerNumber int, controllerLocation int) slot {
+	controllerType := api.ControllerType_Ide
+	if vm.Generation > 1 {
+		controllerType = api.ControllerType_Scsi
+	}
+
+	return slot{
+		controllerType:     controllerType,
+		controllerNumber:   int32(controllerNumber),
+		controllerLocation: int32(controllerLocation),
+	}
+}
+
+func hardDiskDriveSlot(controllerType api.ControllerType, controllerNumber int32, controllerLocation int32) slot {
+	return slot{
+		controllerType:     controllerType,
+		controllerNumber:   controllerNumber,
+		controllerLocation: controllerLocation,
+	}
+}
+
+// validateSlot checks the slot exists on the controllers of the VM.
+func validateSlot(vm *vm, s slot) error {
+	switch s.controllerType {
+	case api.ControllerType_Ide:
+		if vm.Generation > 1 {
+			return fmt.Errorf("VM %s is a generation 2 virtual machine which has no IDE controller", vm.Name)
+		}
+		if s.controllerNumber < 0 || s.controllerNumber > 1 || s.controllerLocation < 0 || s.controllerLocation > 1 {
+			return fmt.Errorf("VM %s has no %s", vm.Name, s)
+		}
+	case api.ControllerType_Scsi:
+		if s.controllerNumber < 0 || s.controllerNumber > 3 || s.controllerLocation < 0 || s.controllerLocation > 63 {
+			return fmt.Errorf("VM %s has no %s", vm.Name, s)
+		}
+	default:
+		return fmt.Errorf("VM %s has no %s", vm.Name, s)
+	}
+
+	return nil
+}
+
+// slotInUse reports whether a drive other than the one at except occupies s.
+func slotInUse(vm *vm, s slot, except *slot) bool {
+	if except != nil && *except == s {
+		return false
+	}
+
+	for _, dvdDrive := range vm.dvdDrives {
+		if dvdDriveSlot(vm, dvdDrive.ControllerNumber, dvdDrive.ControllerLocation) == s {
+			return true
+		}
+	}
+
+	for _, hardDiskDrive := range vm.hardDiskDrives {
+		if hardDiskDriveSlot(hardDiskDrive.ControllerType, hardDiskDrive.ControllerNumber, hardDiskDrive.ControllerLocation) == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// claimSlot checks a drive can be placed at s, moving it from the slot at from when it is already attached.
+func claimSlot(vm *vm, s slot, from *slot) error {
+	if err := validateSlot(vm, s); err != nil {
+		return err
+	}
+
+	if slotInUse(vm, s, from) {
+		return newHostError(api.ErrAlreadyExists, "VM %s already has a drive attached at %s", vm.Name, s)
+	}
+
+	// Drives on the IDE controller can only be added, moved or removed while the VM is stopped
+	if s.controllerType == api.ControllerType_Ide && (from == nil || *from != s) {
+		if err := requireOff(vm, "change the drives on the IDE controller"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// releaseSlot checks a drive can be removed from s.
+func releaseSlot(vm *vm, s slot) error {
+	if s.controllerType == api.ControllerType_Ide {
+		return requireOff(vm, "change the drives on the IDE controller")
+	}
+
+	return nil
+}
+
+// attachedTo returns the VM and slot a file is attached to. c.mu must be held.
+func (c *Client) attachedTo(path string) (attachedVm *vm, attachedSlot slot, ok bool) {
+	key := pathKey(path)
+
+	for _, name := range c.vmOrder {
+		vm := c.vms[name]
+
+		for _, dvdDrive := range vm.dvdDrives {
+			if dvdDrive.Path != "" && pathKey(dvdDrive.Path) == key {
+				return vm, dvdDriveSlot(vm, dvdDrive.ControllerNumber, dvdDrive.ControllerLocation), true
+			}
+		}
+
+		for _, hardDiskDrive := range vm.hardDiskDrives {
+			if hardDiskDrive.Path != "" && pathKey(hardDiskDrive.Path) == key {
+				return vm, hardDiskDriveSlot(hardDiskDrive.ControllerType, hardDiskDrive.ControllerNumber, hardDiskDrive.ControllerLocation), true
+			}
+		}
+	}
+
+	return nil, slot{}, false
+}
+
+// checkAttachable checks a file can be attached to a drive of vm, currently at from when it is already
+// attached. Files can't be attached twice, except for ISOs which are opened read only. c.mu must be held.
+func (c *Client) checkAttachable(vm *vm, from *slot, path string, readOnly bool) error {
+	if path == "" {
+		return nil
+	}
+
+	if !c.fileExists(path) {
+		return newHostError(api.ErrNotFound, "failed to attach %s to VM %s: the system cannot find the file specified", path, vm.Name)
+	}
+
+	if readOnly {
+		return nil
+	}
+
+	attachedVm, attachedSlot, attached := c.attachedTo(path)
+	if attached && (attachedVm != vm || from == nil || attachedSlot != *from) {
+		return newHostError(api.ErrResourceBusy, "failed to attach %s to VM %s: the process cannot access the file because it is being used by another process (object is in use by VM %s)", path, vm.Name, attachedVm.Name)
+	}
+
+	return nil
+}
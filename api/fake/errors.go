@@ -0,0 +1,26 @@
+package fake
+
+import "fmt"
+
+// hostError is an error raised where a Hyper-V host would fail, classified as one of the api.Err* errors like the
+// errors returned by the hyperv client.
+type hostError struct {
+	kind    error
+	message string
+}
+
+func (e *hostError) Error() string {
+	return e.message
+}
+
+func (e *hostError) Unwrap() error {
+	return e.kind
+}
+
+// newHostError returns an error with the message a Hyper-V host would report which matches kind with errors.Is.
+func newHostError(kind error, format string, args ...interface{}) error {
+	return &hostError{
+		kind:    kind,
+		message: fmt.Sprintf(format, args...),
+	}
+}
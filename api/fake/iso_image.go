@@ -0,0 +1,156 @@
+package fake
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/taliesins/terraform-provider-hyperv/api"
+)
+
+func (c *Client) RemoteFileExists(ctx context.Context, path string) (exists bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "RemoteFileExists"); err != nil {
+		return false, err
+	}
+
+	return c.fileExists(path), nil
+}
+
+func (c *Client) RemoteDirectoryExists(ctx context.Context, path string) (exists bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "RemoteDirectoryExists"); err != nil {
+		return false, err
+	}
+
+	return c.directoryExists(path), nil
+}
+
+func (c *Client) RemoteFileDelete(ctx context.Context, path string) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "RemoteFileDelete"); err != nil {
+		return err
+	}
+
+	key := pathKey(path)
+	prefix := strings.TrimSuffix(key, `\`) + `\`
+	for file := range c.files {
+		if file != key && !strings.HasPrefix(file, prefix) {
+			continue
+		}
+
+		if err := c.checkNotInUse(file); err != nil {
+			return err
+		}
+
+		delete(c.files, file)
+		delete(c.vhds, file)
+	}
+
+	return nil
+}
+
+func (c *Client) RemoteFileUpload(ctx context.Context, filePath string, remoteFilePath string) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "RemoteFileUpload"); err != nil {
+		return err
+	}
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	if err := c.checkNotInUse(remoteFilePath); err != nil {
+		return err
+	}
+
+	c.files[pathKey(remoteFilePath)] = string(content)
+	return nil
+}
+
+func (c *Client) RemoteFileHash(ctx context.Context, path string) (hash string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "RemoteFileHash"); err != nil {
+		return "", err
+	}
+
+	content, ok := c.files[pathKey(path)]
+	if !ok {
+		return "", newHostError(api.ErrNotFound, "File not found: %s", path)
+	}
+
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (c *Client) CreateOrUpdateIsoImage(ctx context.Context, sourceIsoFilePath string, sourceIsoFilePathHash string, sourceZipFilePath string, sourceZipFilePathHash string, sourceBootFilePath string, sourceBootFilePathHash string, destinationIsoFilePath string, destinationZipFilePath string, destinationBootFilePath string, media api.IsoMediaType, fileSystem api.IsoFileSystemType, volumeName string, resolveDestinationIsoFilePath string, resolveDestinationZipFilePath string, resolveDestinationBootFilePath string) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "CreateOrUpdateIsoImage"); err != nil {
+		return err
+	}
+
+	if resolveDestinationIsoFilePath == "" {
+		return fmt.Errorf("must specify a value for ResolveDestinationIsoFilePath")
+	}
+
+	// Uploaded ISOs are used as is, otherwise the ISO is built from the uploaded zip file
+	if c.fileExists(resolveDestinationIsoFilePath) || sourceIsoFilePath != "" {
+		return nil
+	}
+
+	if resolveDestinationZipFilePath == "" {
+		return fmt.Errorf("must specify a value for ResolveDestinationZipFilePath if no SourceIsoFilePath is provided")
+	}
+
+	zipContent, ok := c.files[pathKey(resolveDestinationZipFilePath)]
+	if !ok {
+		return fmt.Errorf("Could not find %s for specified SourceZipFilePath=%s", resolveDestinationZipFilePath, sourceZipFilePath)
+	}
+
+	bootContent := ""
+	if sourceBootFilePath != "" {
+		if media == api.IsoMediaType_BDR || media == api.IsoMediaType_BDRE {
+			return fmt.Errorf("Selected boot image may not work with BDR/BDRE media types.")
+		}
+
+		bootContent, ok = c.files[pathKey(resolveDestinationBootFilePath)]
+		if !ok {
+			return fmt.Errorf("Could not find %s for specified SourceBootFilePath=%s", resolveDestinationBootFilePath, sourceBootFilePath)
+		}
+	}
+
+	c.files[pathKey(resolveDestinationIsoFilePath)] = fmt.Sprintf("%s:%s:%s", volumeName, bootContent, zipContent)
+	return nil
+}
+
+func (c *Client) GetIsoImage(ctx context.Context, resolveDestinationIsoFilePath string) (result api.IsoImage, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "GetIsoImage"); err != nil {
+		return result, err
+	}
+
+	// Like the hyperv client, only the presence of the ISO is reported, the rest of its settings live in state
+	if c.fileExists(resolveDestinationIsoFilePath) {
+		result.ResolveDestinationIsoFilePath = resolveDestinationIsoFilePath
+	}
+
+	return result, nil
+}
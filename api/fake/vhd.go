@@ -0,0 +1,182 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/taliesins/terraform-provider-hyperv/api"
+)
+
+// defaultLogicalSectorSize is the logical sector size of VHDs created without one.
+const defaultLogicalSectorSize = 512
+
+func (c *Client) VhdExists(ctx context.Context, path string) (result api.VhdExists, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "VhdExists"); err != nil {
+		return result, err
+	}
+
+	result.Exists = c.fileExists(path)
+	return result, nil
+}
+
+// vhdFormat returns the format of a VHD from the extension of its path.
+func vhdFormat(path string) api.VhdFormat {
+	switch strings.ToLower(filepath.Ext(api.ToWindowsPath(path))) {
+	case ".vhd":
+		return api.VhdFormat_VHD
+	case ".vhdx":
+		return api.VhdFormat_VHDX
+	case ".vhds":
+		return api.VhdFormat_VHDSet
+	default:
+		return api.VhdFormat_Unknown
+	}
+}
+
+func (c *Client) CreateOrUpdateVhd(ctx context.Context, path string, source string, sourceVm string, sourceDisk int, vhdType api.VhdType, parentPath string, size uint64, blockSize uint32, logicalSectorSize uint32, physicalSectorSize uint32) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "CreateOrUpdateVhd"); err != nil {
+		return err
+	}
+
+	// Like the hyperv client, an existing VHD is left untouched
+	if c.fileExists(path) {
+		return nil
+	}
+
+	if logicalSectorSize == 0 {
+		logicalSectorSize = defaultLogicalSectorSize
+	}
+
+	vhd := &api.Vhd{
+		Path:               path,
+		BlockSize:          blockSize,
+		LogicalSectorSize:  logicalSectorSize,
+		PhysicalSectorSize: physicalSectorSize,
+		VhdType:            vhdType,
+		VhdFormat:          vhdFormat(path),
+	}
+
+	switch {
+	case sourceVm != "":
+		if _, err := c.vm(sourceVm); err != nil {
+			return err
+		}
+		vhd.Size = size
+	case source != "" || sourceDisk != 0:
+		vhd.Size = size
+	case vhdType == api.VhdType_Differencing:
+		parent, ok := c.vhds[pathKey(parentPath)]
+		if !ok {
+			return newHostError(api.ErrNotFound, "failed to create differencing VHD %s: parent VHD %s does not exist", path, parentPath)
+		}
+		vhd.ParentPath = parentPath
+		vhd.Size = parent.Size
+		if size > 0 {
+			vhd.Size = size
+		}
+	default:
+		if size == 0 {
+			return fmt.Errorf("Vhd Size must be specified for - %s", path)
+		}
+		vhd.Size = (size + uint64(logicalSectorSize) - 1) / uint64(logicalSectorSize) * uint64(logicalSectorSize)
+	}
+
+	vhd.FileSize = vhd.Size
+	if vhdType != api.VhdType_Fixed {
+		vhd.FileSize = 4 * 1024 * 1024
+	}
+
+	c.vhds[pathKey(path)] = vhd
+	c.files[pathKey(path)] = ""
+
+	return nil
+}
+
+// checkNotInUse refuses changes to a VHD attached to a VM that is not stopped. c.mu must be held.
+func (c *Client) checkNotInUse(path string) error {
+	attachedVm, _, attached := c.attachedTo(path)
+	if attached && attachedVm.state != api.VmState_Off {
+		return newHostError(api.ErrResourceBusy, "the process cannot access the file %s because the object is in use by VM %s", path, attachedVm.Name)
+	}
+
+	return nil
+}
+
+func (c *Client) ResizeVhd(ctx context.Context, path string, size uint64) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "ResizeVhd"); err != nil {
+		return err
+	}
+
+	vhd, ok := c.vhds[pathKey(path)]
+	if !ok {
+		return newHostError(api.ErrNotFound, "VHD does not exist - %s", path)
+	}
+
+	if vhd.Size == size {
+		return nil
+	}
+
+	if err := c.checkNotInUse(path); err != nil {
+		return err
+	}
+
+	if size < vhd.MinimumSize {
+		return fmt.Errorf("failed to resize VHD %s: %d is smaller than the minimum size %d", path, size, vhd.MinimumSize)
+	}
+
+	vhd.Size = size
+	if vhd.VhdType == api.VhdType_Fixed {
+		vhd.FileSize = size
+	}
+
+	return nil
+}
+
+func (c *Client) GetVhd(ctx context.Context, path string) (result api.Vhd, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "GetVhd"); err != nil {
+		return result, err
+	}
+
+	vhd, ok := c.vhds[pathKey(path)]
+	if !ok {
+		return result, nil
+	}
+
+	result = *vhd
+	_, _, result.Attached = c.attachedTo(path)
+	return result, nil
+}
+
+func (c *Client) DeleteVhd(ctx context.Context, path string) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "DeleteVhd"); err != nil {
+		return err
+	}
+
+	// Like the hyperv client, files that are in use are left behind with a warning rather than an error
+	attachedVm, _, attached := c.attachedTo(path)
+	if attached && attachedVm.state != api.VmState_Off {
+		return nil
+	}
+
+	delete(c.vhds, pathKey(path))
+	delete(c.files, pathKey(path))
+
+	return nil
+}
@@ -0,0 +1,270 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/taliesins/terraform-provider-hyperv/api"
+)
+
+// memoryAlignment is the granularity of VM memory sizes.
+const memoryAlignment = 2 * 1024 * 1024
+
+func (c *Client) VmExists(ctx context.Context, name string) (result api.VmExists, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "VmExists"); err != nil {
+		return result, err
+	}
+
+	_, result.Exists = c.vms[name]
+	return result, nil
+}
+
+func validateVm(settings api.Vm) error {
+	if settings.ProcessorCount < 1 {
+		return fmt.Errorf("invalid processor count %d for VM %s", settings.ProcessorCount, settings.Name)
+	}
+
+	if settings.MemoryStartupBytes%memoryAlignment != 0 {
+		return fmt.Errorf("invalid startup memory for VM %s: %d is not a multiple of 2 MB", settings.Name, settings.MemoryStartupBytes)
+	}
+
+	if settings.DynamicMemory && !settings.StaticMemory {
+		if settings.MemoryMinimumBytes > settings.MemoryStartupBytes || settings.MemoryStartupBytes > settings.MemoryMaximumBytes {
+			return fmt.Errorf("invalid dynamic memory for VM %s: the minimum %d, startup %d and maximum %d must be in increasing order", settings.Name, settings.MemoryMinimumBytes, settings.MemoryStartupBytes, settings.MemoryMaximumBytes)
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) CreateVm(
+	ctx context.Context,
+	name string,
+	path string,
+	generation int,
+	automaticCriticalErrorAction api.CriticalErrorAction,
+	automaticCriticalErrorActionTimeout int32,
+	automaticStartAction api.StartAction,
+	automaticStartDelay int32,
+	automaticStopAction api.StopAction,
+	checkpointType api.CheckpointType,
+	dynamicMemory bool,
+	guestControlledCacheTypes bool,
+	highMemoryMappedIoSpace uint64,
+	lockOnDisconnect api.OnOffState,
+	lowMemoryMappedIoSpace uint32,
+	memoryMaximumBytes int64,
+	memoryMinimumBytes int64,
+	memoryStartupBytes int64,
+	notes string,
+	processorCount int64,
+	smartPagingFilePath string,
+	snapshotFileLocation string,
+	staticMemory bool,
+) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "CreateVm"); err != nil {
+		return err
+	}
+
+	if _, ok := c.vms[name]; ok {
+		return newHostError(api.ErrAlreadyExists, "VM already exists - %s", name)
+	}
+
+	if generation != 1 && generation != 2 {
+		return fmt.Errorf("invalid generation %d for VM %s", generation, name)
+	}
+
+	if path == "" {
+		path = DefaultVmPath
+	}
+
+	settings := api.Vm{
+		Name:                                name,
+		Path:                                path,
+		Generation:                          generation,
+		AutomaticCriticalErrorAction:        automaticCriticalErrorAction,
+		AutomaticCriticalErrorActionTimeout: automaticCriticalErrorActionTimeout,
+		AutomaticStartAction:                automaticStartAction,
+		AutomaticStartDelay:                 automaticStartDelay,
+		AutomaticStopAction:                 automaticStopAction,
+		CheckpointType:                      checkpointType,
+		DynamicMemory:                       dynamicMemory && !staticMemory,
+		GuestControlledCacheTypes:           guestControlledCacheTypes,
+		HighMemoryMappedIoSpace:             highMemoryMappedIoSpace,
+		LockOnDisconnect:                    lockOnDisconnect,
+		LowMemoryMappedIoSpace:              lowMemoryMappedIoSpace,
+		MemoryMaximumBytes:                  memoryMaximumBytes,
+		MemoryMinimumBytes:                  memoryMinimumBytes,
+		MemoryStartupBytes:                  memoryStartupBytes,
+		Notes:                               notes,
+		ProcessorCount:                      processorCount,
+		SmartPagingFilePath:                 smartPagingFilePath,
+		SnapshotFileLocation:                snapshotFileLocation,
+	}
+	settings.StaticMemory = !settings.DynamicMemory
+
+	if err := validateVm(settings); err != nil {
+		return err
+	}
+
+	newVm := &vm{
+		Vm:    settings,
+		state: api.VmState_Off,
+	}
+
+	if defaultProcessors, err := api.DefaultVmProcessors(); err == nil {
+		if processors, ok := defaultProcessors.([]api.VmProcessor); ok && len(processors) > 0 {
+			newVm.processor = processors[0]
+		}
+	}
+
+	if defaultIntegrationServices, err := api.DefaultVmIntegrationServices(); err == nil {
+		if integrationServices, ok := defaultIntegrationServices.(map[string]interface{}); ok {
+			for serviceName, value := range integrationServices {
+				enabled, _ := value.(bool)
+				newVm.integrationServices = append(newVm.integrationServices, api.VmIntegrationService{
+					Name:    serviceName,
+					Enabled: enabled,
+				})
+			}
+		}
+	}
+	sort.Slice(newVm.integrationServices, func(i, j int) bool {
+		return newVm.integrationServices[i].Name < newVm.integrationServices[j].Name
+	})
+
+	if generation > 1 {
+		if defaultFirmwares, err := api.DefaultVmFirmwares(); err == nil {
+			if firmwares, ok := defaultFirmwares.([]api.VmFirmware); ok && len(firmwares) > 0 {
+				newVm.firmware = firmwares[0]
+			}
+		}
+	}
+
+	c.vms[name] = newVm
+	c.vmOrder = append(c.vmOrder, name)
+
+	return nil
+}
+
+func (c *Client) GetVm(ctx context.Context, name string) (result api.Vm, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "GetVm"); err != nil {
+		return result, err
+	}
+
+	vm, ok := c.vms[name]
+	if !ok {
+		return result, nil
+	}
+
+	return vm.Vm, nil
+}
+
+func (c *Client) UpdateVm(
+	ctx context.Context,
+	name string,
+	automaticCriticalErrorAction api.CriticalErrorAction,
+	automaticCriticalErrorActionTimeout int32,
+	automaticStartAction api.StartAction,
+	automaticStartDelay int32,
+	automaticStopAction api.StopAction,
+	checkpointType api.CheckpointType,
+	dynamicMemory bool,
+	guestControlledCacheTypes bool,
+	highMemoryMappedIoSpace uint64,
+	lockOnDisconnect api.OnOffState,
+	lowMemoryMappedIoSpace uint32,
+	memoryMaximumBytes int64,
+	memoryMinimumBytes int64,
+	memoryStartupBytes int64,
+	notes string,
+	processorCount int64,
+	smartPagingFilePath string,
+	snapshotFileLocation string,
+	staticMemory bool,
+) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "UpdateVm"); err != nil {
+		return err
+	}
+
+	vm, err := c.vm(name)
+	if err != nil {
+		return err
+	}
+
+	settings := vm.Vm
+	settings.AutomaticCriticalErrorAction = automaticCriticalErrorAction
+	settings.AutomaticCriticalErrorActionTimeout = automaticCriticalErrorActionTimeout
+	settings.AutomaticStartAction = automaticStartAction
+	settings.AutomaticStartDelay = automaticStartDelay
+	settings.AutomaticStopAction = automaticStopAction
+	settings.CheckpointType = checkpointType
+	settings.DynamicMemory = dynamicMemory && !staticMemory
+	settings.StaticMemory = !settings.DynamicMemory
+	settings.GuestControlledCacheTypes = guestControlledCacheTypes
+	settings.HighMemoryMappedIoSpace = highMemoryMappedIoSpace
+	settings.LockOnDisconnect = lockOnDisconnect
+	settings.LowMemoryMappedIoSpace = lowMemoryMappedIoSpace
+	settings.MemoryMaximumBytes = memoryMaximumBytes
+	settings.MemoryMinimumBytes = memoryMinimumBytes
+	settings.MemoryStartupBytes = memoryStartupBytes
+	settings.Notes = notes
+	settings.ProcessorCount = processorCount
+	settings.SmartPagingFilePath = smartPagingFilePath
+	settings.SnapshotFileLocation = snapshotFileLocation
+
+	if err := validateVm(settings); err != nil {
+		return err
+	}
+
+	if settings.ProcessorCount != vm.ProcessorCount ||
+		settings.DynamicMemory != vm.DynamicMemory ||
+		settings.MemoryStartupBytes != vm.MemoryStartupBytes ||
+		settings.GuestControlledCacheTypes != vm.GuestControlledCacheTypes ||
+		settings.HighMemoryMappedIoSpace != vm.HighMemoryMappedIoSpace ||
+		settings.LowMemoryMappedIoSpace != vm.LowMemoryMappedIoSpace {
+		if err := requireOff(vm, "change the processors or memory"); err != nil {
+			return err
+		}
+	}
+
+	vm.Vm = settings
+	return nil
+}
+
+func (c *Client) DeleteVm(ctx context.Context, name string) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "DeleteVm"); err != nil {
+		return err
+	}
+
+	if _, ok := c.vms[name]; !ok {
+		return nil
+	}
+
+	// Removing a VM leaves its virtual hard disks on the host
+	delete(c.vms, name)
+	for i, vmName := range c.vmOrder {
+		if vmName == name {
+			c.vmOrder = append(c.vmOrder[:i], c.vmOrder[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,205 @@
+package fake
+
+import (
+	"context"
+
+	"github.com/taliesins/terraform-provider-hyperv/api"
+)
+
+func (c *Client) CreateVmDvdDrive(
+	ctx context.Context,
+	vmName string,
+	controllerNumber int,
+	controllerLocation int,
+	path string,
+	resourcePoolName string,
+) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "CreateVmDvdDrive"); err != nil {
+		return err
+	}
+
+	vm, err := c.vm(vmName)
+	if err != nil {
+		return err
+	}
+
+	if err := claimSlot(vm, dvdDriveSlot(vm, controllerNumber, controllerLocation), nil); err != nil {
+		return err
+	}
+
+	if err := c.checkAttachable(vm, nil, path, true); err != nil {
+		return err
+	}
+
+	vm.dvdDrives = append(vm.dvdDrives, api.VmDvdDrive{
+		VmName:             vmName,
+		ControllerNumber:   controllerNumber,
+		ControllerLocation: controllerLocation,
+		Path:               path,
+		ResourcePoolName:   resourcePoolName,
+	})
+
+	return nil
+}
+
+func (c *Client) GetVmDvdDrives(ctx context.Context, vmName string) (result []api.VmDvdDrive, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "GetVmDvdDrives"); err != nil {
+		return result, err
+	}
+
+	vm, ok := c.vms[vmName]
+	if !ok {
+		return result, nil
+	}
+
+	return append(result, vm.dvdDrives...), nil
+}
+
+// dvdDrive returns the index of the dvd drive of vm at the slot. c.mu must be held.
+func dvdDrive(vm *vm, controllerNumber int, controllerLocation int) (int, error) {
+	for i, dvdDrive := range vm.dvdDrives {
+		if dvdDrive.ControllerNumber == controllerNumber && dvdDrive.ControllerLocation == controllerLocation {
+			return i, nil
+		}
+	}
+
+	return -1, newHostError(api.ErrNotFound, "VM %s has no dvd drive at %s", vm.Name, dvdDriveSlot(vm, controllerNumber, controllerLocation))
+}
+
+func (c *Client) UpdateVmDvdDrive(
+	ctx context.Context,
+	vmName string,
+	controllerNumber int,
+	controllerLocation int,
+	toControllerNumber int,
+	toControllerLocation int,
+	path string,
+	resourcePoolName string,
+) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "UpdateVmDvdDrive"); err != nil {
+		return err
+	}
+
+	vm, err := c.vm(vmName)
+	if err != nil {
+		return err
+	}
+
+	i, err := dvdDrive(vm, controllerNumber, controllerLocation)
+	if err != nil {
+		return err
+	}
+
+	from := dvdDriveSlot(vm, controllerNumber, controllerLocation)
+	if err := claimSlot(vm, dvdDriveSlot(vm, toControllerNumber, toControllerLocation), &from); err != nil {
+		return err
+	}
+
+	if err := c.checkAttachable(vm, &from, path, true); err != nil {
+		return err
+	}
+
+	vm.dvdDrives[i] = api.VmDvdDrive{
+		VmName:             vmName,
+		ControllerNumber:   toControllerNumber,
+		ControllerLocation: toControllerLocation,
+		Path:               path,
+		ResourcePoolName:   resourcePoolName,
+	}
+
+	return nil
+}
+
+func (c *Client) DeleteVmDvdDrive(ctx context.Context, vmName string, controllerNumber int, controllerLocation int) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "DeleteVmDvdDrive"); err != nil {
+		return err
+	}
+
+	vm, err := c.vm(vmName)
+	if err != nil {
+		return err
+	}
+
+	i, err := dvdDrive(vm, controllerNumber, controllerLocation)
+	if err != nil {
+		return err
+	}
+
+	if err := releaseSlot(vm, dvdDriveSlot(vm, controllerNumber, controllerLocation)); err != nil {
+		return err
+	}
+
+	vm.dvdDrives = append(vm.dvdDrives[:i], vm.dvdDrives[i+1:]...)
+	return nil
+}
+
+func (c *Client) CreateOrUpdateVmDvdDrives(ctx context.Context, vmName string, dvdDrives []api.VmDvdDrive) (err error) {
+	currentDvdDrives, err := c.GetVmDvdDrives(ctx, vmName)
+	if err != nil {
+		return err
+	}
+
+	currentDvdDrivesLength := len(currentDvdDrives)
+	desiredDvdDrivesLength := len(dvdDrives)
+
+	for i := currentDvdDrivesLength - 1; i > desiredDvdDrivesLength-1; i-- {
+		currentDvdDrive := currentDvdDrives[i]
+		err = c.DeleteVmDvdDrive(ctx, vmName, currentDvdDrive.ControllerNumber, currentDvdDrive.ControllerLocation)
+		if err != nil {
+			return err
+		}
+	}
+
+	if currentDvdDrivesLength > desiredDvdDrivesLength {
+		currentDvdDrivesLength = desiredDvdDrivesLength
+	}
+
+	for i := 0; i <= currentDvdDrivesLength-1; i++ {
+		currentDvdDrive := currentDvdDrives[i]
+		dvdDrive := dvdDrives[i]
+
+		err = c.UpdateVmDvdDrive(
+			ctx,
+			vmName,
+			currentDvdDrive.ControllerNumber,
+			currentDvdDrive.ControllerLocation,
+			dvdDrive.ControllerNumber,
+			dvdDrive.ControllerLocation,
+			dvdDrive.Path,
+			dvdDrive.ResourcePoolName,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := currentDvdDrivesLength - 1 + 1; i <= desiredDvdDrivesLength-1; i++ {
+		dvdDrive := dvdDrives[i]
+		err = c.CreateVmDvdDrive(
+			ctx,
+			vmName,
+			dvdDrive.ControllerNumber,
+			dvdDrive.ControllerLocation,
+			dvdDrive.Path,
+			dvdDrive.ResourcePoolName,
+		)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/taliesins/terraform-provider-hyperv/api"
+)
+
+func (c *Client) CreateOrUpdateVmFirmware(
+	ctx context.Context,
+	vmName string,
+	bootOrders []api.Gen2BootOrder,
+	enableSecureBoot api.OnOffState,
+	secureBootTemplate string,
+	preferredNetworkBootProtocol api.IPProtocolPreference,
+	consoleMode api.ConsoleModeType,
+	pauseAfterBootFailure api.OnOffState,
+) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "CreateOrUpdateVmFirmware"); err != nil {
+		return err
+	}
+
+	vm, err := c.vm(vmName)
+	if err != nil {
+		return err
+	}
+
+	if vm.Generation < 2 {
+		return fmt.Errorf("VM %s is a generation 1 virtual machine which has no firmware settings", vmName)
+	}
+
+	if err := requireOff(vm, "change the firmware"); err != nil {
+		return err
+	}
+
+	vm.firmware = api.VmFirmware{
+		VmName:                       vmName,
+		BootOrders:                   append([]api.Gen2BootOrder{}, bootOrders...),
+		EnableSecureBoot:             enableSecureBoot,
+		SecureBootTemplate:           secureBootTemplate,
+		PreferredNetworkBootProtocol: preferredNetworkBootProtocol,
+		ConsoleMode:                  consoleMode,
+		PauseAfterBootFailure:        pauseAfterBootFailure,
+	}
+
+	return nil
+}
+
+func (c *Client) GetVmFirmware(ctx context.Context, vmName string) (result api.VmFirmware, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "GetVmFirmware"); err != nil {
+		return result, err
+	}
+
+	vm, ok := c.vms[vmName]
+	if !ok {
+		return result, nil
+	}
+
+	if vm.Generation < 2 {
+		return result, fmt.Errorf("VM %s is a generation 1 virtual machine which has no firmware settings", vmName)
+	}
+
+	result = vm.firmware
+	result.VmName = vmName
+	result.BootOrders = append([]api.Gen2BootOrder{}, vm.firmware.BootOrders...)
+	return result, nil
+}
+
+func (c *Client) GetNoVmFirmwares(ctx context.Context) (result []api.VmFirmware) {
+	result = make([]api.VmFirmware, 0)
+	return result
+}
+
+func (c *Client) GetVmFirmwares(ctx context.Context, vmName string) (result []api.VmFirmware, err error) {
+	result = make([]api.VmFirmware, 0)
+	vmFirmware, err := c.GetVmFirmware(ctx, vmName)
+	if err != nil {
+		return result, err
+	}
+	result = append(result, vmFirmware)
+	return result, err
+}
+
+func (c *Client) CreateOrUpdateVmFirmwares(ctx context.Context, vmName string, vmFirmwares []api.VmFirmware) (err error) {
+	if len(vmFirmwares) == 0 {
+		return nil
+	}
+	if len(vmFirmwares) > 1 {
+		return fmt.Errorf("only 1 vm firmware setting allowed per a vm")
+	}
+
+	vmFirmware := vmFirmwares[0]
+
+	return c.CreateOrUpdateVmFirmware(ctx, vmName,
+		vmFirmware.BootOrders,
+		vmFirmware.EnableSecureBoot,
+		vmFirmware.SecureBootTemplate,
+		vmFirmware.PreferredNetworkBootProtocol,
+		vmFirmware.ConsoleMode,
+		vmFirmware.PauseAfterBootFailure,
+	)
+}
@@ -0,0 +1,263 @@
+package fake
+
+import (
+	"context"
+
+	"github.com/taliesins/terraform-provider-hyperv/api"
+)
+
+func (c *Client) CreateVmHardDiskDrive(
+	ctx context.Context,
+	vmName string,
+	controllerType api.ControllerType,
+	controllerNumber int32,
+	controllerLocation int32,
+	path string,
+	diskNumber uint32,
+	resourcePoolName string,
+	supportPersistentReservations bool,
+	maximumIops uint64,
+	minimumIops uint64,
+	qosPolicyId string,
+	overrideCacheAttributes api.CacheAttributes,
+) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "CreateVmHardDiskDrive"); err != nil {
+		return err
+	}
+
+	vm, err := c.vm(vmName)
+	if err != nil {
+		return err
+	}
+
+	if err := claimSlot(vm, hardDiskDriveSlot(controllerType, controllerNumber, controllerLocation), nil); err != nil {
+		return err
+	}
+
+	if err := c.checkAttachable(vm, nil, path, false); err != nil {
+		return err
+	}
+
+	vm.hardDiskDrives = append(vm.hardDiskDrives, api.VmHardDiskDrive{
+		VmName:                        vmName,
+		ControllerType:                controllerType,
+		ControllerNumber:              controllerNumber,
+		ControllerLocation:            controllerLocation,
+		Path:                          path,
+		DiskNumber:                    diskNumber,
+		ResourcePoolName:              resourcePoolName,
+		SupportPersistentReservations: supportPersistentReservations,
+		MaximumIops:                   maximumIops,
+		MinimumIops:                   minimumIops,
+		QosPolicyId:                   qosPolicyId,
+		OverrideCacheAttributes:       overrideCacheAttributes,
+	})
+
+	return nil
+}
+
+func (c *Client) GetVmHardDiskDrives(ctx context.Context, vmName string) (result []api.VmHardDiskDrive, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "GetVmHardDiskDrives"); err != nil {
+		return result, err
+	}
+
+	vm, ok := c.vms[vmName]
+	if !ok {
+		return result, nil
+	}
+
+	return append(result, vm.hardDiskDrives...), nil
+}
+
+// hardDiskDrive returns the index of the hard disk drive of vm at the slot. c.mu must be held.
+func hardDiskDrive(vm *vm, s slot) (int, error) {
+	for i, hardDiskDrive := range vm.hardDiskDrives {
+		if hardDiskDriveSlot(hardDiskDrive.ControllerType, hardDiskDrive.ControllerNumber, hardDiskDrive.ControllerLocation) == s {
+			return i, nil
+		}
+	}
+
+	return -1, newHostError(api.ErrNotFound, "VM %s has no hard disk drive at %s", vm.Name, s)
+}
+
+func (c *Client) UpdateVmHardDiskDrive(
+	ctx context.Context,
+	vmName string,
+	controllerNumber int32,
+	controllerLocation int32,
+	controllerType api.ControllerType,
+	toControllerNumber int32,
+	toControllerLocation int32,
+	path string,
+	diskNumber uint32,
+	resourcePoolName string,
+	supportPersistentReservations bool,
+	maximumIops uint64,
+	minimumIops uint64,
+	qosPolicyId string,
+	overrideCacheAttributes api.CacheAttributes,
+) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "UpdateVmHardDiskDrive"); err != nil {
+		return err
+	}
+
+	vm, err := c.vm(vmName)
+	if err != nil {
+		return err
+	}
+
+	from := hardDiskDriveSlot(controllerType, controllerNumber, controllerLocation)
+	i, err := hardDiskDrive(vm, from)
+	if err != nil {
+		return err
+	}
+
+	if err := claimSlot(vm, hardDiskDriveSlot(controllerType, toControllerNumber, toControllerLocation), &from); err != nil {
+		return err
+	}
+
+	if err := c.checkAttachable(vm, &from, path, false); err != nil {
+		return err
+	}
+
+	vm.hardDiskDrives[i] = api.VmHardDiskDrive{
+		VmName:                        vmName,
+		ControllerType:                controllerType,
+		ControllerNumber:              toControllerNumber,
+		ControllerLocation:            toControllerLocation,
+		Path:                          path,
+		DiskNumber:                    diskNumber,
+		ResourcePoolName:              resourcePoolName,
+		SupportPersistentReservations: supportPersistentReservations,
+		MaximumIops:                   maximumIops,
+		MinimumIops:                   minimumIops,
+		QosPolicyId:                   qosPolicyId,
+		OverrideCacheAttributes:       overrideCacheAttributes,
+	}
+
+	return nil
+}
+
+func (c *Client) DeleteVmHardDiskDrive(ctx context.Context, vmName string, controllerNumber int32, controllerLocation int32, controllerType api.ControllerType) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "DeleteVmHardDiskDrive"); err != nil {
+		return err
+	}
+
+	vm, err := c.vm(vmName)
+	if err != nil {
+		return err
+	}
+
+	s := hardDiskDriveSlot(controllerType, controllerNumber, controllerLocation)
+	i, err := hardDiskDrive(vm, s)
+	if err != nil {
+		return err
+	}
+
+	if err := releaseSlot(vm, s); err != nil {
+		return err
+	}
+
+	vm.hardDiskDrives = append(vm.hardDiskDrives[:i], vm.hardDiskDrives[i+1:]...)
+	return nil
+}
+
+func (c *Client) CreateOrUpdateVmHardDiskDrives(ctx context.Context, vmName string, hardDiskDrives []api.VmHardDiskDrive) (err error) {
+	currentHardDiskDrives, err := c.GetVmHardDiskDrives(ctx, vmName)
+	if err != nil {
+		return err
+	}
+
+	currentHardDiskDrivesLength := len(currentHardDiskDrives)
+	desiredHardDiskDrivesLength := len(hardDiskDrives)
+
+	for i := currentHardDiskDrivesLength - 1; i > desiredHardDiskDrivesLength-1; i-- {
+		currentHardDiskDrive := currentHardDiskDrives[i]
+		err = c.DeleteVmHardDiskDrive(ctx, vmName, currentHardDiskDrive.ControllerNumber, currentHardDiskDrive.ControllerLocation, currentHardDiskDrive.ControllerType)
+		if err != nil {
+			return err
+		}
+	}
+
+	limit := currentHardDiskDrivesLength
+	if desiredHardDiskDrivesLength < limit {
+		limit = desiredHardDiskDrivesLength
+	}
+
+	indicesToCreate := make(map[int]bool)
+
+	for i := 0; i < limit; i++ {
+		currentHardDiskDrive := currentHardDiskDrives[i]
+		hardDiskDrive := hardDiskDrives[i]
+
+		if currentHardDiskDrive.ControllerType != hardDiskDrive.ControllerType ||
+			currentHardDiskDrive.ControllerNumber != hardDiskDrive.ControllerNumber ||
+			currentHardDiskDrive.ControllerLocation != hardDiskDrive.ControllerLocation {
+			err = c.DeleteVmHardDiskDrive(ctx, vmName, currentHardDiskDrive.ControllerNumber, currentHardDiskDrive.ControllerLocation, currentHardDiskDrive.ControllerType)
+			if err != nil {
+				return err
+			}
+			indicesToCreate[i] = true
+		} else {
+			err = c.UpdateVmHardDiskDrive(
+				ctx,
+				vmName,
+				currentHardDiskDrive.ControllerNumber,
+				currentHardDiskDrive.ControllerLocation,
+				currentHardDiskDrive.ControllerType,
+				hardDiskDrive.ControllerNumber,
+				hardDiskDrive.ControllerLocation,
+				hardDiskDrive.Path,
+				hardDiskDrive.DiskNumber,
+				hardDiskDrive.ResourcePoolName,
+				hardDiskDrive.SupportPersistentReservations,
+				hardDiskDrive.MaximumIops,
+				hardDiskDrive.MinimumIops,
+				hardDiskDrive.QosPolicyId,
+				hardDiskDrive.OverrideCacheAttributes,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for i := 0; i < desiredHardDiskDrivesLength; i++ {
+		if i >= limit || indicesToCreate[i] {
+			hardDiskDrive := hardDiskDrives[i]
+			err = c.CreateVmHardDiskDrive(
+				ctx,
+				vmName,
+				hardDiskDrive.ControllerType,
+				hardDiskDrive.ControllerNumber,
+				hardDiskDrive.ControllerLocation,
+				hardDiskDrive.Path,
+				hardDiskDrive.DiskNumber,
+				hardDiskDrive.ResourcePoolName,
+				hardDiskDrive.SupportPersistentReservations,
+				hardDiskDrive.MaximumIops,
+				hardDiskDrive.MinimumIops,
+				hardDiskDrive.QosPolicyId,
+				hardDiskDrive.OverrideCacheAttributes,
+			)
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
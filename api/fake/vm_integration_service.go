@@ -0,0 +1,70 @@
+package fake
+
+import (
+	"context"
+
+	"github.com/taliesins/terraform-provider-hyperv/api"
+)
+
+func (c *Client) GetVmIntegrationServices(ctx context.Context, vmName string) (result []api.VmIntegrationService, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "GetVmIntegrationServices"); err != nil {
+		return result, err
+	}
+
+	vm, ok := c.vms[vmName]
+	if !ok {
+		return result, nil
+	}
+
+	return append(result, vm.integrationServices...), nil
+}
+
+func (c *Client) setVmIntegrationService(ctx context.Context, method string, vmName string, name string, enabled bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, method); err != nil {
+		return err
+	}
+
+	vm, err := c.vm(vmName)
+	if err != nil {
+		return err
+	}
+
+	for i := range vm.integrationServices {
+		if vm.integrationServices[i].Name == name {
+			vm.integrationServices[i].Enabled = enabled
+			return nil
+		}
+	}
+
+	return newHostError(api.ErrNotFound, "integration service %s does not exist on VM %s", name, vmName)
+}
+
+func (c *Client) EnableVmIntegrationService(ctx context.Context, vmName string, name string) (err error) {
+	return c.setVmIntegrationService(ctx, "EnableVmIntegrationService", vmName, name, true)
+}
+
+func (c *Client) DisableVmIntegrationService(ctx context.Context, vmName string, name string) (err error) {
+	return c.setVmIntegrationService(ctx, "DisableVmIntegrationService", vmName, name, false)
+}
+
+func (c *Client) CreateOrUpdateVmIntegrationServices(ctx context.Context, vmName string, integrationServices []api.VmIntegrationService) (err error) {
+	for _, integrationService := range integrationServices {
+		if integrationService.Enabled {
+			err = c.EnableVmIntegrationService(ctx, vmName, integrationService.Name)
+		} else {
+			err = c.DisableVmIntegrationService(ctx, vmName, integrationService.Name)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,500 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/taliesins/terraform-provider-hyperv/api"
+)
+
+func (c *Client) CreateVmNetworkAdapter(
+	ctx context.Context,
+	vmName string,
+	name string,
+	switchName string,
+	managementOs bool,
+	isLegacy bool,
+	dynamicMacAddress bool,
+	staticMacAddress string,
+	macAddressSpoofing api.OnOffState,
+	dhcpGuard api.OnOffState,
+	routerGuard api.OnOffState,
+	portMirroring api.PortMirroring,
+	ieeePriorityTag api.OnOffState,
+	vmqWeight int,
+	iovQueuePairsRequested int,
+	iovInterruptModeration api.IovInterruptModerationValue,
+	iovWeight int,
+	ipsecOffloadMaximumSecurityAssociation int,
+	maximumBandwidth int,
+	minimumBandwidthAbsolute int,
+	minimumBandwidthWeight int,
+	mandatoryFeatureId []string,
+	resourcePoolName string,
+	testReplicaPoolName string,
+	testReplicaSwitchName string,
+	virtualSubnetId int,
+	allowTeaming api.OnOffState,
+	notMonitoredInCluster bool,
+	stormLimit int,
+	dynamicIpAddressLimit int,
+	deviceNaming api.OnOffState,
+	fixSpeed10G api.OnOffState,
+	packetDirectNumProcs int,
+	packetDirectModerationCount int,
+	packetDirectModerationInterval int,
+	vrssEnabled bool,
+	vmmqEnabled bool,
+	vmmqQueuePairs int,
+	vlanAccess bool,
+	vlanId int,
+) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "CreateVmNetworkAdapter"); err != nil {
+		return err
+	}
+
+	vm, err := c.vm(vmName)
+	if err != nil {
+		return err
+	}
+
+	index := len(vm.networkAdapters)
+	networkAdapter := api.VmNetworkAdapter{
+		VmName:                                 vmName,
+		Index:                                  index,
+		Name:                                   name,
+		SwitchName:                             switchName,
+		ManagementOs:                           managementOs,
+		IsLegacy:                               isLegacy,
+		DynamicMacAddress:                      dynamicMacAddress,
+		StaticMacAddress:                       staticMacAddress,
+		MacAddressSpoofing:                     macAddressSpoofing,
+		DhcpGuard:                              dhcpGuard,
+		RouterGuard:                            routerGuard,
+		PortMirroring:                          portMirroring,
+		IeeePriorityTag:                        ieeePriorityTag,
+		VmqWeight:                              vmqWeight,
+		IovQueuePairsRequested:                 iovQueuePairsRequested,
+		IovInterruptModeration:                 iovInterruptModeration,
+		IovWeight:                              iovWeight,
+		IpsecOffloadMaximumSecurityAssociation: ipsecOffloadMaximumSecurityAssociation,
+		MaximumBandwidth:                       maximumBandwidth,
+		MinimumBandwidthAbsolute:               minimumBandwidthAbsolute,
+		MinimumBandwidthWeight:                 minimumBandwidthWeight,
+		MandatoryFeatureId:                     append([]string{}, mandatoryFeatureId...),
+		ResourcePoolName:                       resourcePoolName,
+		TestReplicaPoolName:                    testReplicaPoolName,
+		TestReplicaSwitchName:                  testReplicaSwitchName,
+		VirtualSubnetId:                        virtualSubnetId,
+		AllowTeaming:                           allowTeaming,
+		NotMonitoredInCluster:                  notMonitoredInCluster,
+		StormLimit:                             stormLimit,
+		DynamicIpAddressLimit:                  dynamicIpAddressLimit,
+		DeviceNaming:                           deviceNaming,
+		FixSpeed10G:                            fixSpeed10G,
+		PacketDirectNumProcs:                   packetDirectNumProcs,
+		PacketDirectModerationCount:            packetDirectModerationCount,
+		PacketDirectModerationInterval:         packetDirectModerationInterval,
+		VrssEnabled:                            vrssEnabled,
+		VmmqEnabled:                            vmmqEnabled,
+		VmmqQueuePairs:                         vmmqQueuePairs,
+		VlanAccess:                             vlanAccess,
+		VlanId:                                 vlanId,
+	}
+
+	if err := c.validateNetworkAdapter(vm, networkAdapter, nil); err != nil {
+		return err
+	}
+
+	vm.networkAdapters = append(vm.networkAdapters, networkAdapter)
+	c.setState(vm, vm.state)
+
+	return nil
+}
+
+// validateNetworkAdapter checks a network adapter can be connected to vm, replacing current when it is
+// already connected. c.mu must be held.
+func (c *Client) validateNetworkAdapter(vm *vm, networkAdapter api.VmNetworkAdapter, current *api.VmNetworkAdapter) error {
+	if networkAdapter.Name == "" {
+		return fmt.Errorf("network adapter name must be specified for VM %s", vm.Name)
+	}
+
+	for _, other := range vm.networkAdapters {
+		if other.Index != networkAdapter.Index && other.Name == networkAdapter.Name {
+			return newHostError(api.ErrAlreadyExists, "VM %s already has a network adapter named %s", vm.Name, networkAdapter.Name)
+		}
+	}
+
+	if networkAdapter.SwitchName != "" {
+		if _, ok := c.switches[networkAdapter.SwitchName]; !ok {
+			return newHostError(api.ErrNotFound, "Switch does not exist - %s", networkAdapter.SwitchName)
+		}
+	}
+
+	if networkAdapter.IsLegacy && vm.Generation > 1 {
+		return fmt.Errorf("VM %s is a generation 2 virtual machine which does not support legacy network adapters", vm.Name)
+	}
+
+	// Legacy network adapters are emulated devices which can't be added, changed or removed while the VM runs
+	if networkAdapter.IsLegacy || (current != nil && current.IsLegacy) {
+		if current == nil || current.IsLegacy != networkAdapter.IsLegacy || current.Name != networkAdapter.Name {
+			if err := requireOff(vm, "change the legacy network adapters"); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) WaitForVmNetworkAdaptersIps(
+	ctx context.Context,
+	vmName string,
+	timeout uint32,
+	pollPeriod uint32,
+	vmNetworkAdaptersWaitForIps []api.VmNetworkAdapterWaitForIp,
+) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "WaitForVmNetworkAdaptersIps"); err != nil {
+		return err
+	}
+
+	vm, err := c.vm(vmName)
+	if err != nil {
+		return err
+	}
+
+	// Addresses are handed out as soon as a VM starts, so only adapters that will never get one can time out
+	if vm.state != api.VmState_Running {
+		return nil
+	}
+
+	for _, networkAdapterWaitForIps := range vmNetworkAdaptersWaitForIps {
+		if !networkAdapterWaitForIps.WaitForIps {
+			continue
+		}
+
+		for _, networkAdapter := range vm.networkAdapters {
+			if networkAdapter.Name == networkAdapterWaitForIps.Name && len(networkAdapter.IpAddresses) == 0 {
+				return fmt.Errorf("timed out after %s waiting for network adapter %s of VM %s to get an ip address", time.Duration(timeout)*time.Second, networkAdapter.Name, vmName)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) GetVmNetworkAdapters(ctx context.Context, vmName string, networkAdaptersWaitForIps []api.VmNetworkAdapterWaitForIp) (result []api.VmNetworkAdapter, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result = make([]api.VmNetworkAdapter, 0)
+
+	if err := c.enter(ctx, "GetVmNetworkAdapters"); err != nil {
+		return result, err
+	}
+
+	vm, ok := c.vms[vmName]
+	if !ok {
+		return result, nil
+	}
+
+	for _, networkAdapter := range vm.networkAdapters {
+		networkAdapter.MandatoryFeatureId = append([]string{}, networkAdapter.MandatoryFeatureId...)
+		networkAdapter.IpAddresses = append([]string{}, networkAdapter.IpAddresses...)
+		result = append(result, networkAdapter)
+	}
+
+	// Enrich network adapter with config settings that are not stored in hyperv
+	for _, networkAdapterWaitForIps := range networkAdaptersWaitForIps {
+		for networkAdapterIndex, networkAdapter := range result {
+			if networkAdapterWaitForIps.Name == networkAdapter.Name {
+				result[networkAdapterIndex].WaitForIps = networkAdapterWaitForIps.WaitForIps
+				break
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func (c *Client) UpdateVmNetworkAdapter(
+	ctx context.Context,
+	vmName string,
+	index int,
+	name string,
+	switchName string,
+	managementOs bool,
+	isLegacy bool,
+	dynamicMacAddress bool,
+	staticMacAddress string,
+	macAddressSpoofing api.OnOffState,
+	dhcpGuard api.OnOffState,
+	routerGuard api.OnOffState,
+	portMirroring api.PortMirroring,
+	ieeePriorityTag api.OnOffState,
+	vmqWeight int,
+	iovQueuePairsRequested int,
+	iovInterruptModeration api.IovInterruptModerationValue,
+	iovWeight int,
+	ipsecOffloadMaximumSecurityAssociation int,
+	maximumBandwidth int,
+	minimumBandwidthAbsolute int,
+	minimumBandwidthWeight int,
+	mandatoryFeatureId []string,
+	resourcePoolName string,
+	testReplicaPoolName string,
+	testReplicaSwitchName string,
+	virtualSubnetId int,
+	allowTeaming api.OnOffState,
+	notMonitoredInCluster bool,
+	stormLimit int,
+	dynamicIpAddressLimit int,
+	deviceNaming api.OnOffState,
+	fixSpeed10G api.OnOffState,
+	packetDirectNumProcs int,
+	packetDirectModerationCount int,
+	packetDirectModerationInterval int,
+	vrssEnabled bool,
+	vmmqEnabled bool,
+	vmmqQueuePairs int,
+	vlanAccess bool,
+	vlanId int,
+) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "UpdateVmNetworkAdapter"); err != nil {
+		return err
+	}
+
+	vm, err := c.vm(vmName)
+	if err != nil {
+		return err
+	}
+
+	if index < 0 || index >= len(vm.networkAdapters) {
+		return newHostError(api.ErrNotFound, "VM %s has no network adapter with index %d", vmName, index)
+	}
+
+	networkAdapter := api.VmNetworkAdapter{
+		VmName:                                 vmName,
+		Index:                                  index,
+		Name:                                   name,
+		SwitchName:                             switchName,
+		ManagementOs:                           managementOs,
+		IsLegacy:                               isLegacy,
+		DynamicMacAddress:                      dynamicMacAddress,
+		StaticMacAddress:                       staticMacAddress,
+		MacAddressSpoofing:                     macAddressSpoofing,
+		DhcpGuard:                              dhcpGuard,
+		RouterGuard:                            routerGuard,
+		PortMirroring:                          portMirroring,
+		IeeePriorityTag:                        ieeePriorityTag,
+		VmqWeight:                              vmqWeight,
+		IovQueuePairsRequested:                 iovQueuePairsRequested,
+		IovInterruptModeration:                 iovInterruptModeration,
+		IovWeight:                              iovWeight,
+		IpsecOffloadMaximumSecurityAssociation: ipsecOffloadMaximumSecurityAssociation,
+		MaximumBandwidth:                       maximumBandwidth,
+		MinimumBandwidthAbsolute:               minimumBandwidthAbsolute,
+		MinimumBandwidthWeight:                 minimumBandwidthWeight,
+		MandatoryFeatureId:                     append([]string{}, mandatoryFeatureId...),
+		ResourcePoolName:                       resourcePoolName,
+		TestReplicaPoolName:                    testReplicaPoolName,
+		TestReplicaSwitchName:                  testReplicaSwitchName,
+		VirtualSubnetId:                        virtualSubnetId,
+		AllowTeaming:                           allowTeaming,
+		NotMonitoredInCluster:                  notMonitoredInCluster,
+		StormLimit:                             stormLimit,
+		DynamicIpAddressLimit:                  dynamicIpAddressLimit,
+		DeviceNaming:                           deviceNaming,
+		FixSpeed10G:                            fixSpeed10G,
+		PacketDirectNumProcs:                   packetDirectNumProcs,
+		PacketDirectModerationCount:            packetDirectModerationCount,
+		PacketDirectModerationInterval:         packetDirectModerationInterval,
+		VrssEnabled:                            vrssEnabled,
+		VmmqEnabled:                            vmmqEnabled,
+		VmmqQueuePairs:                         vmmqQueuePairs,
+		VlanAccess:                             vlanAccess,
+		VlanId:                                 vlanId,
+	}
+
+	if err := c.validateNetworkAdapter(vm, networkAdapter, &vm.networkAdapters[index]); err != nil {
+		return err
+	}
+
+	if networkAdapter.SwitchName == vm.networkAdapters[index].SwitchName {
+		networkAdapter.IpAddresses = vm.networkAdapters[index].IpAddresses
+	}
+
+	vm.networkAdapters[index] = networkAdapter
+	c.setState(vm, vm.state)
+
+	return nil
+}
+
+func (c *Client) DeleteVmNetworkAdapter(ctx context.Context, vmName string, index int) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "DeleteVmNetworkAdapter"); err != nil {
+		return err
+	}
+
+	vm, err := c.vm(vmName)
+	if err != nil {
+		return err
+	}
+
+	if index < 0 || index >= len(vm.networkAdapters) {
+		return newHostError(api.ErrNotFound, "VM %s has no network adapter with index %d", vmName, index)
+	}
+
+	if vm.networkAdapters[index].IsLegacy {
+		if err := requireOff(vm, "change the legacy network adapters"); err != nil {
+			return err
+		}
+	}
+
+	vm.networkAdapters = append(vm.networkAdapters[:index], vm.networkAdapters[index+1:]...)
+	for i := range vm.networkAdapters {
+		vm.networkAdapters[i].Index = i
+	}
+
+	return nil
+}
+
+func (c *Client) CreateOrUpdateVmNetworkAdapters(ctx context.Context, vmName string, networkAdapters []api.VmNetworkAdapter) (err error) {
+	networkAdaptersWaitForIps := make([]api.VmNetworkAdapterWaitForIp, 0)
+
+	// Empty networkAdaptersWaitForIps is ok as we aren't using the results anywhere
+	currentNetworkAdapters, err := c.GetVmNetworkAdapters(ctx, vmName, networkAdaptersWaitForIps)
+	if err != nil {
+		return err
+	}
+
+	currentNetworkAdaptersLength := len(currentNetworkAdapters)
+	desiredNetworkAdaptersLength := len(networkAdapters)
+
+	for i := currentNetworkAdaptersLength - 1; i > desiredNetworkAdaptersLength-1; i-- {
+		currentNetworkAdapter := currentNetworkAdapters[i]
+		err = c.DeleteVmNetworkAdapter(ctx, vmName, currentNetworkAdapter.Index)
+		if err != nil {
+			return err
+		}
+	}
+
+	if currentNetworkAdaptersLength > desiredNetworkAdaptersLength {
+		currentNetworkAdaptersLength = desiredNetworkAdaptersLength
+	}
+
+	for i := 0; i <= currentNetworkAdaptersLength-1; i++ {
+		currentNetworkAdapter := currentNetworkAdapters[i]
+		networkAdapter := networkAdapters[i]
+		err = c.UpdateVmNetworkAdapter(
+			ctx,
+			vmName,
+			currentNetworkAdapter.Index,
+			networkAdapter.Name,
+			networkAdapter.SwitchName,
+			networkAdapter.ManagementOs,
+			networkAdapter.IsLegacy,
+			networkAdapter.DynamicMacAddress,
+			networkAdapter.StaticMacAddress,
+			networkAdapter.MacAddressSpoofing,
+			networkAdapter.DhcpGuard,
+			networkAdapter.RouterGuard,
+			networkAdapter.PortMirroring,
+			networkAdapter.IeeePriorityTag,
+			networkAdapter.VmqWeight,
+			networkAdapter.IovQueuePairsRequested,
+			networkAdapter.IovInterruptModeration,
+			networkAdapter.IovWeight,
+			networkAdapter.IpsecOffloadMaximumSecurityAssociation,
+			networkAdapter.MaximumBandwidth,
+			networkAdapter.MinimumBandwidthAbsolute,
+			networkAdapter.MinimumBandwidthWeight,
+			networkAdapter.MandatoryFeatureId,
+			networkAdapter.ResourcePoolName,
+			networkAdapter.TestReplicaPoolName,
+			networkAdapter.TestReplicaSwitchName,
+			networkAdapter.VirtualSubnetId,
+			networkAdapter.AllowTeaming,
+			networkAdapter.NotMonitoredInCluster,
+			networkAdapter.StormLimit,
+			networkAdapter.DynamicIpAddressLimit,
+			networkAdapter.DeviceNaming,
+			networkAdapter.FixSpeed10G,
+			networkAdapter.PacketDirectNumProcs,
+			networkAdapter.PacketDirectModerationCount,
+			networkAdapter.PacketDirectModerationInterval,
+			networkAdapter.VrssEnabled,
+			networkAdapter.VmmqEnabled,
+			networkAdapter.VmmqQueuePairs,
+			networkAdapter.VlanAccess,
+			networkAdapter.VlanId,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := currentNetworkAdaptersLength - 1 + 1; i <= desiredNetworkAdaptersLength-1; i++ {
+		networkAdapter := networkAdapters[i]
+		err = c.CreateVmNetworkAdapter(
+			ctx,
+			vmName,
+			networkAdapter.Name,
+			networkAdapter.SwitchName,
+			networkAdapter.ManagementOs,
+			networkAdapter.IsLegacy,
+			networkAdapter.DynamicMacAddress,
+			networkAdapter.StaticMacAddress,
+			networkAdapter.MacAddressSpoofing,
+			networkAdapter.DhcpGuard,
+			networkAdapter.RouterGuard,
+			networkAdapter.PortMirroring,
+			networkAdapter.IeeePriorityTag,
+			networkAdapter.VmqWeight,
+			networkAdapter.IovQueuePairsRequested,
+			networkAdapter.IovInterruptModeration,
+			networkAdapter.IovWeight,
+			networkAdapter.IpsecOffloadMaximumSecurityAssociation,
+			networkAdapter.MaximumBandwidth,
+			networkAdapter.MinimumBandwidthAbsolute,
+			networkAdapter.MinimumBandwidthWeight,
+			networkAdapter.MandatoryFeatureId,
+			networkAdapter.ResourcePoolName,
+			networkAdapter.TestReplicaPoolName,
+			networkAdapter.TestReplicaSwitchName,
+			networkAdapter.VirtualSubnetId,
+			networkAdapter.AllowTeaming,
+			networkAdapter.NotMonitoredInCluster,
+			networkAdapter.StormLimit,
+			networkAdapter.DynamicIpAddressLimit,
+			networkAdapter.DeviceNaming,
+			networkAdapter.FixSpeed10G,
+			networkAdapter.PacketDirectNumProcs,
+			networkAdapter.PacketDirectModerationCount,
+			networkAdapter.PacketDirectModerationInterval,
+			networkAdapter.VrssEnabled,
+			networkAdapter.VmmqEnabled,
+			networkAdapter.VmmqQueuePairs,
+			networkAdapter.VlanAccess,
+			networkAdapter.VlanId,
+		)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/taliesins/terraform-provider-hyperv/api"
+)
+
+func (c *Client) CreateOrUpdateVmProcessor(
+	ctx context.Context,
+	vmName string,
+	compatibilityForMigrationEnabled bool,
+	compatibilityForOlderOperatingSystemsEnabled bool,
+	hwThreadCountPerCore int64,
+	maximum int64,
+	reserve int64,
+	relativeWeight int32,
+	maximumCountPerNumaNode int32,
+	maximumCountPerNumaSocket int32,
+	enableHostResourceProtection bool,
+	exposeVirtualizationExtensions bool,
+) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "CreateOrUpdateVmProcessor"); err != nil {
+		return err
+	}
+
+	vm, err := c.vm(vmName)
+	if err != nil {
+		return err
+	}
+
+	if maximum < 0 || maximum > 100 || reserve < 0 || reserve > 100 {
+		return fmt.Errorf("invalid processor limits for VM %s: maximum %d and reserve %d must be percentages", vmName, maximum, reserve)
+	}
+
+	processor := api.VmProcessor{
+		VmName:                           vmName,
+		CompatibilityForMigrationEnabled: compatibilityForMigrationEnabled,
+		CompatibilityForOlderOperatingSystemsEnabled: compatibilityForOlderOperatingSystemsEnabled,
+		HwThreadCountPerCore:                         hwThreadCountPerCore,
+		Maximum:                                      maximum,
+		Reserve:                                      reserve,
+		RelativeWeight:                               relativeWeight,
+		MaximumCountPerNumaNode:                      maximumCountPerNumaNode,
+		MaximumCountPerNumaSocket:                    maximumCountPerNumaSocket,
+		EnableHostResourceProtection:                 enableHostResourceProtection,
+		ExposeVirtualizationExtensions:               exposeVirtualizationExtensions,
+	}
+
+	current := vm.processor
+	current.VmName = vmName
+	if processor.CompatibilityForMigrationEnabled != current.CompatibilityForMigrationEnabled ||
+		processor.CompatibilityForOlderOperatingSystemsEnabled != current.CompatibilityForOlderOperatingSystemsEnabled ||
+		processor.HwThreadCountPerCore != current.HwThreadCountPerCore ||
+		processor.MaximumCountPerNumaNode != current.MaximumCountPerNumaNode ||
+		processor.MaximumCountPerNumaSocket != current.MaximumCountPerNumaSocket ||
+		processor.ExposeVirtualizationExtensions != current.ExposeVirtualizationExtensions {
+		if err := requireOff(vm, "change the processor settings"); err != nil {
+			return err
+		}
+	}
+
+	vm.processor = processor
+	return nil
+}
+
+func (c *Client) GetVmProcessors(ctx context.Context, vmName string) (result []api.VmProcessor, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result = make([]api.VmProcessor, 0)
+
+	if err := c.enter(ctx, "GetVmProcessors"); err != nil {
+		return result, err
+	}
+
+	vm, ok := c.vms[vmName]
+	if !ok {
+		return append(result, api.VmProcessor{}), nil
+	}
+
+	processor := vm.processor
+	processor.VmName = vmName
+	return append(result, processor), nil
+}
+
+func (c *Client) CreateOrUpdateVmProcessors(ctx context.Context, vmName string, vmProcessors []api.VmProcessor) (err error) {
+	if len(vmProcessors) == 0 {
+		return nil
+	}
+	if len(vmProcessors) > 1 {
+		return fmt.Errorf("only 1 vm processor setting allowed per a vm")
+	}
+
+	vmProcessor := vmProcessors[0]
+
+	return c.CreateOrUpdateVmProcessor(ctx, vmName,
+		vmProcessor.CompatibilityForMigrationEnabled,
+		vmProcessor.CompatibilityForOlderOperatingSystemsEnabled,
+		vmProcessor.HwThreadCountPerCore,
+		vmProcessor.Maximum,
+		vmProcessor.Reserve,
+		vmProcessor.RelativeWeight,
+		vmProcessor.MaximumCountPerNumaNode,
+		vmProcessor.MaximumCountPerNumaSocket,
+		vmProcessor.EnableHostResourceProtection,
+		vmProcessor.ExposeVirtualizationExtensions)
+}
@@ -0,0 +1,110 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/taliesins/terraform-provider-hyperv/api"
+)
+
+// requiresManualIntervention lists the states a VM can't be moved out of by a state change.
+var requiresManualIntervention = map[api.VmState]bool{
+	api.VmState_Other:              true,
+	api.VmState_RunningCritical:    true,
+	api.VmState_OffCritical:        true,
+	api.VmState_StoppingCritical:   true,
+	api.VmState_SavedCritical:      true,
+	api.VmState_PausedCritical:     true,
+	api.VmState_StartingCritical:   true,
+	api.VmState_ResetCritical:      true,
+	api.VmState_SavingCritical:     true,
+	api.VmState_PausingCritical:    true,
+	api.VmState_ResumingCritical:   true,
+	api.VmState_FastSavedCritical:  true,
+	api.VmState_FastSavingCritical: true,
+}
+
+// transitions lists the states a VM can be moved to from each state, transitions complete immediately.
+var transitions = map[api.VmState]map[api.VmState]bool{
+	api.VmState_Off: {
+		api.VmState_Running: true,
+	},
+	api.VmState_Running: {
+		api.VmState_Off:    true,
+		api.VmState_Paused: true,
+	},
+	api.VmState_Paused: {
+		api.VmState_Running: true,
+		api.VmState_Off:     true,
+	},
+}
+
+func (c *Client) GetVmStatus(ctx context.Context, vmName string) (result api.VmStatus, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "GetVmStatus"); err != nil {
+		return result, err
+	}
+
+	vm, ok := c.vms[vmName]
+	if !ok {
+		return result, nil
+	}
+
+	result.State = vm.state
+	return result, nil
+}
+
+func (c *Client) UpdateVmStatus(
+	ctx context.Context,
+	vmName string,
+	timeout uint32,
+	pollPeriod uint32,
+	state api.VmState,
+) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "UpdateVmStatus"); err != nil {
+		return err
+	}
+
+	vm, err := c.vm(vmName)
+	if err != nil {
+		return err
+	}
+
+	if vm.state == state {
+		return nil
+	}
+
+	if requiresManualIntervention[vm.state] {
+		return newHostError(api.ErrInvalidState, "VM %s requires manual intervention as it is in state %s", vmName, vm.state)
+	}
+
+	if !transitions[vm.state][state] {
+		return newHostError(api.ErrInvalidState, "Unable to change VM %s state %s to %s state", vmName, vm.state, state)
+	}
+
+	c.setState(vm, state)
+	return nil
+}
+
+// setState changes the state of a VM. Running VMs report an address for each network adapter connected
+// to a switch. c.mu must be held.
+func (c *Client) setState(vm *vm, state api.VmState) {
+	vm.state = state
+
+	for i := range vm.networkAdapters {
+		networkAdapter := &vm.networkAdapters[i]
+
+		switch {
+		case state != api.VmState_Running && state != api.VmState_Paused:
+			networkAdapter.IpAddresses = nil
+		case networkAdapter.SwitchName != "" && len(networkAdapter.IpAddresses) == 0:
+			c.nextIp++
+			networkAdapter.IpAddresses = []string{fmt.Sprintf("192.168.%d.%d", c.nextIp/250, c.nextIp%250+2)}
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/taliesins/terraform-provider-hyperv/api"
+)
+
+func (c *Client) VMSwitchExists(ctx context.Context, name string) (result api.VmSwitchExists, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "VMSwitchExists"); err != nil {
+		return result, err
+	}
+
+	_, result.Exists = c.switches[name]
+	return result, nil
+}
+
+// switchTypeFor returns the type of a switch, which is external whenever it is bound to a physical adapter.
+func switchTypeFor(switchType api.VMSwitchType, netAdapterNames []string) api.VMSwitchType {
+	if len(netAdapterNames) > 0 {
+		return api.VMSwitchType_External
+	}
+
+	return switchType
+}
+
+func (c *Client) CreateVMSwitch(
+	ctx context.Context,
+	name string,
+	notes string,
+	allowManagementOS bool,
+	embeddedTeamingEnabled bool,
+	iovEnabled bool,
+	packetDirectEnabled bool,
+	bandwidthReservationMode api.VMSwitchBandwidthMode,
+	switchType api.VMSwitchType,
+	netAdapterNames []string,
+	defaultFlowMinimumBandwidthAbsolute int64,
+	defaultFlowMinimumBandwidthWeight int64,
+	defaultQueueVmmqEnabled bool,
+	defaultQueueVmmqQueuePairs int32,
+	defaultQueueVrssEnabled bool,
+) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "CreateVMSwitch"); err != nil {
+		return err
+	}
+
+	if _, ok := c.switches[name]; ok {
+		return newHostError(api.ErrAlreadyExists, "Switch already exists - %s", name)
+	}
+
+	if len(netAdapterNames) > 1 && !embeddedTeamingEnabled {
+		return fmt.Errorf("switch %s can only be bound to multiple network adapters when embedded teaming is enabled", name)
+	}
+
+	c.switches[name] = &api.VmSwitch{
+		Name:                                name,
+		Notes:                               notes,
+		AllowManagementOS:                   allowManagementOS,
+		EmbeddedTeamingEnabled:              embeddedTeamingEnabled,
+		IovEnabled:                          iovEnabled,
+		PacketDirectEnabled:                 packetDirectEnabled,
+		BandwidthReservationMode:            bandwidthReservationMode,
+		SwitchType:                          switchTypeFor(switchType, netAdapterNames),
+		NetAdapterNames:                     append([]string{}, netAdapterNames...),
+		DefaultFlowMinimumBandwidthAbsolute: defaultFlowMinimumBandwidthAbsolute,
+		DefaultFlowMinimumBandwidthWeight:   defaultFlowMinimumBandwidthWeight,
+		DefaultQueueVmmqEnabled:             defaultQueueVmmqEnabled,
+		DefaultQueueVmmqQueuePairs:          defaultQueueVmmqQueuePairs,
+		DefaultQueueVrssEnabled:             defaultQueueVrssEnabled,
+	}
+
+	return nil
+}
+
+func (c *Client) GetVMSwitch(ctx context.Context, name string) (result api.VmSwitch, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "GetVMSwitch"); err != nil {
+		return result, err
+	}
+
+	vmSwitch, ok := c.switches[name]
+	if !ok {
+		return result, nil
+	}
+
+	result = *vmSwitch
+	result.NetAdapterNames = append([]string{}, vmSwitch.NetAdapterNames...)
+	return result, nil
+}
+
+func (c *Client) UpdateVMSwitch(
+	ctx context.Context,
+	oldName string,
+	name string,
+	notes string,
+	allowManagementOS bool,
+	switchType api.VMSwitchType,
+	netAdapterNames []string,
+	defaultFlowMinimumBandwidthAbsolute int64,
+	defaultFlowMinimumBandwidthWeight int64,
+	defaultQueueVmmqEnabled bool,
+	defaultQueueVmmqQueuePairs int32,
+	defaultQueueVrssEnabled bool,
+) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "UpdateVMSwitch"); err != nil {
+		return err
+	}
+
+	vmSwitch, ok := c.switches[oldName]
+	if !ok {
+		return newHostError(api.ErrNotFound, "Switch does not exist - %s", oldName)
+	}
+
+	if oldName != name {
+		if _, ok := c.switches[name]; ok {
+			return newHostError(api.ErrAlreadyExists, "Switch already exists - %s", name)
+		}
+	}
+
+	if len(netAdapterNames) > 1 && !vmSwitch.EmbeddedTeamingEnabled {
+		return fmt.Errorf("switch %s can only be bound to multiple network adapters when embedded teaming is enabled", name)
+	}
+
+	vmSwitch.Name = name
+	vmSwitch.Notes = notes
+	vmSwitch.AllowManagementOS = allowManagementOS
+	vmSwitch.SwitchType = switchTypeFor(switchType, netAdapterNames)
+	vmSwitch.NetAdapterNames = append([]string{}, netAdapterNames...)
+	vmSwitch.DefaultFlowMinimumBandwidthAbsolute = defaultFlowMinimumBandwidthAbsolute
+	vmSwitch.DefaultFlowMinimumBandwidthWeight = defaultFlowMinimumBandwidthWeight
+	vmSwitch.DefaultQueueVmmqEnabled = defaultQueueVmmqEnabled
+	vmSwitch.DefaultQueueVmmqQueuePairs = defaultQueueVmmqQueuePairs
+	vmSwitch.DefaultQueueVrssEnabled = defaultQueueVrssEnabled
+
+	if oldName != name {
+		delete(c.switches, oldName)
+		c.switches[name] = vmSwitch
+
+		// Network adapters follow the switch they are connected to
+		for _, vm := range c.vms {
+			for i := range vm.networkAdapters {
+				if vm.networkAdapters[i].SwitchName == oldName {
+					vm.networkAdapters[i].SwitchName = name
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) DeleteVMSwitch(ctx context.Context, name string) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enter(ctx, "DeleteVMSwitch"); err != nil {
+		return err
+	}
+
+	if _, ok := c.switches[name]; !ok {
+		return nil
+	}
+
+	delete(c.switches, name)
+
+	// Network adapters connected to the switch are left disconnected
+	for _, vm := range c.vms {
+		for i := range vm.networkAdapters {
+			if vm.networkAdapters[i].SwitchName == name {
+				vm.networkAdapters[i].SwitchName = ""
+				vm.networkAdapters[i].IpAddresses = nil
+			}
+		}
+	}
+
+	return nil
+}
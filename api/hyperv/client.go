@@ -2,12 +2,25 @@ package hyperv
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
 	"text/template"
+	"time"
 
+	"github.com/hashicorp/terraform-plugin-log/tflog"
 	"github.com/taliesins/terraform-provider-hyperv/api"
+	"github.com/taliesins/terraform-provider-hyperv/api/commandresult"
+	"github.com/taliesins/terraform-provider-hyperv/api/retry"
 )
 
+// New creates a client running its scripts with clientConfig.ScriptRunner, which is wrapped to install the helper
+// module on the Hyper-V host before the first script.
 func New(clientConfig *ClientConfig) (*api.Provider, error) {
+	clientConfig.ScriptRunner = newModuleScriptRunner(clientConfig.ScriptRunner)
+
 	return &api.Provider{
 		Client: clientConfig,
 	}, nil
@@ -28,3 +41,125 @@ type ScriptRunner interface {
 	DirectoryExists(ctx context.Context, remoteDirectoryPath string) (exists bool, err error)
 	DeleteFileOrDirectory(ctx context.Context, remotePath string) (err error)
 }
+
+// StreamingScriptRunner is implemented by script runners that pass the progress, verbose and information records of a
+// script to records while it runs, rather than only returning its output once it completes. The result is decoded as
+// by RunScriptWithResult, a nil result runs the script without processing results.
+type StreamingScriptRunner interface {
+	RunStreamingScript(ctx context.Context, script *template.Template, args interface{}, result interface{}, records commandresult.RecordHandler) (err error)
+}
+
+// scriptParamsHeader decodes the arguments of a script into $params. The arguments are passed as base64 encoded JSON,
+// which only contains characters that are safe inside a single quoted PowerShell string, so no value is ever parsed
+// as PowerShell source.
+const scriptParamsHeader = `$params = [System.Text.Encoding]::UTF8.GetString([System.Convert]::FromBase64String('{{params .}}')) | ConvertFrom-Json
+`
+
+var scriptFuncs = template.FuncMap{
+	"params": encodeScriptParams,
+}
+
+// encodeScriptParams encodes the arguments of a script as base64 encoded JSON.
+func encodeScriptParams(args interface{}) (string, error) {
+	argsJson, err := json.Marshal(args)
+	if err != nil {
+		return "", fmt.Errorf("error converting script arguments to json: %w", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(argsJson), nil
+}
+
+// newScriptTemplate parses a script run on the Hyper-V host. The script reads its arguments from $params, never from
+// template actions, looks objects up by exact name or literal path, and calls the functions of the helper module,
+// which it imports. The script is wrapped so that terminating errors are returned as a *commandresult.ScriptError,
+// which callers match against the api.Err* errors with errors.Is.
+func newScriptTemplate(name string, text string) *template.Template {
+	return parseScriptTemplate(name, moduleImportHeader+scriptParamsHeader, text)
+}
+
+// newIdempotentScriptTemplate parses a script like newScriptTemplate that can run again after it failed part way, such
+// as a script only reading objects. Transient failures of these scripts are retried, other scripts are only retried
+// when they failed before they started.
+func newIdempotentScriptTemplate(name string, text string) *template.Template {
+	return retry.Idempotent(newScriptTemplate(name, text))
+}
+
+// newModuleScriptTemplate parses a script managing the helper module, which cannot import it.
+func newModuleScriptTemplate(name string, text string) *template.Template {
+	return parseScriptTemplate(name, scriptParamsHeader, text)
+}
+
+func parseScriptTemplate(name string, header string, text string) *template.Template {
+	if strings.Contains(text, "{{") {
+		panic(fmt.Sprintf("script %s must read its arguments from $params instead of template actions", name))
+	}
+
+	return template.Must(template.New(name).Funcs(scriptFuncs).Parse(commandresult.WrapScript(header + text)))
+}
+
+// runStreamingScript runs a long running script, logging its records to the Terraform logs with the name of the script
+// as the operation and fields describing its target. Script runners that cannot stream run the script as before.
+func (c *ClientConfig) runStreamingScript(ctx context.Context, script *template.Template, args interface{}, result interface{}, fields map[string]interface{}) error {
+	runner, ok := c.ScriptRunner.(StreamingScriptRunner)
+	if !ok {
+		if result == nil {
+			return c.ScriptRunner.RunFireAndForgetScript(ctx, script, args)
+		}
+
+		return c.ScriptRunner.RunScriptWithResult(ctx, script, args, result)
+	}
+
+	ctx = tflog.SetField(ctx, "operation", script.Name())
+	for key, value := range fields {
+		ctx = tflog.SetField(ctx, key, value)
+	}
+
+	return runner.RunStreamingScript(ctx, script, args, result, func(record commandresult.Record) {
+		logRecord(ctx, record)
+	})
+}
+
+// logRecord logs a record of a script: progress with its activity and percentage, verbose messages at debug level and
+// information messages at info level.
+func logRecord(ctx context.Context, record commandresult.Record) {
+	switch record.Stream {
+	case commandresult.RecordProgress:
+		fields := map[string]interface{}{
+			"activity":  record.Activity,
+			"status":    record.Status,
+			"completed": record.Completed,
+		}
+		if record.CurrentOperation != "" {
+			fields["current_operation"] = record.CurrentOperation
+		}
+		if record.PercentComplete >= 0 {
+			fields["percent"] = record.PercentComplete
+		}
+
+		tflog.Info(ctx, fmt.Sprintf("%s: %s", record.Activity, record.Status), fields)
+	case commandresult.RecordVerbose:
+		tflog.Debug(ctx, record.Message)
+	default:
+		tflog.Info(ctx, record.Message)
+	}
+}
+
+// remainingTimeoutSeconds bounds a script side timeout, in seconds, by the time left before the
+// deadline of ctx so that remote polling loops give up before Terraform abandons the operation.
+func remainingTimeoutSeconds(ctx context.Context, timeout uint32) uint32 {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return timeout
+	}
+
+	remaining := math.Ceil(time.Until(deadline).Seconds())
+	if remaining < 1 {
+		return 1
+	}
+
+	if remaining < float64(timeout) {
+		return uint32(remaining)
+	}
+
+	return timeout
+}
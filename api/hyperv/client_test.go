@@ -0,0 +1,152 @@
+package hyperv
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-log/tflogtest"
+	"github.com/taliesins/terraform-provider-hyperv/api"
+	"github.com/taliesins/terraform-provider-hyperv/api/commandresult"
+	"github.com/taliesins/terraform-provider-hyperv/api/retry"
+)
+
+func TestRemainingTimeoutSeconds(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		deadline time.Duration
+		timeout  uint32
+		want     uint32
+	}{
+		{name: "no deadline", timeout: 300, want: 300},
+		{name: "deadline after timeout", deadline: time.Hour, timeout: 300, want: 300},
+		{name: "deadline before timeout", deadline: 90 * time.Second, timeout: 300, want: 90},
+		{name: "deadline passed", deadline: -time.Second, timeout: 300, want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			if tt.deadline != 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.deadline)
+				defer cancel()
+			}
+
+			if got := remainingTimeoutSeconds(ctx, tt.timeout); got != tt.want {
+				t.Fatalf("expected %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+// scriptRunner records the scripts run through it. Other operations are not supported.
+type scriptRunner struct {
+	ScriptRunner
+	scripts []string
+}
+
+func (r *scriptRunner) RunFireAndForgetScript(ctx context.Context, script *template.Template, args interface{}) error {
+	r.scripts = append(r.scripts, script.Name())
+	return nil
+}
+
+// streamingScriptRunner records the scripts run through it and passes a progress record to every streaming script.
+type streamingScriptRunner struct {
+	scriptRunner
+}
+
+func (r *streamingScriptRunner) RunStreamingScript(ctx context.Context, script *template.Template, args interface{}, result interface{}, records commandresult.RecordHandler) error {
+	r.scripts = append(r.scripts, "streaming "+script.Name())
+	records(commandresult.Record{Stream: commandresult.RecordProgress, Activity: "Exporting VM web", Status: "Copying", PercentComplete: 30})
+	records(commandresult.Record{Stream: commandresult.RecordVerbose, PercentComplete: -1, Message: "Exported"})
+	return nil
+}
+
+func TestRunStreamingScript(t *testing.T) {
+	t.Parallel()
+
+	var output bytes.Buffer
+	ctx := tflogtest.RootLogger(context.Background(), &output)
+
+	runner := &streamingScriptRunner{}
+	client := &ClientConfig{ScriptRunner: runner}
+	if err := client.CreateOrUpdateVhd(ctx, `C:\vhds\web.vhdx`, "", "web", 0, api.VhdType_Unknown, "", 0, 0, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(runner.scripts) != 1 || runner.scripts[0] != "streaming CreateOrUpdateVhd" {
+		t.Fatalf("expected the script to be streamed, got %v", runner.scripts)
+	}
+
+	entries, err := tflogtest.MultilineJSONDecode(&output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("expected a log entry for each record, got %v", entries)
+	}
+
+	progress := entries[0]
+	for key, want := range map[string]interface{}{
+		"@level":    "info",
+		"@message":  "Exporting VM web: Copying",
+		"operation": "CreateOrUpdateVhd",
+		"vm_name":   "web",
+		"path":      `C:\vhds\web.vhdx`,
+		"percent":   float64(30),
+	} {
+		if progress[key] != want {
+			t.Fatalf("expected %s to be %v, got %v", key, want, progress)
+		}
+	}
+
+	if verbose := entries[1]; verbose["@level"] != "debug" || verbose["@message"] != "Exported" || verbose["operation"] != "CreateOrUpdateVhd" {
+		t.Fatalf("expected the verbose record at debug level, got %v", verbose)
+	}
+
+	fallback := &scriptRunner{}
+	client = &ClientConfig{ScriptRunner: fallback}
+	if err := client.CreateOrUpdateVhd(ctx, `C:\vhds\web.vhdx`, "", "web", 0, api.VhdType_Unknown, "", 0, 0, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fallback.scripts) != 1 || fallback.scripts[0] != "CreateOrUpdateVhd" {
+		t.Fatalf("expected the script to run without streaming, got %v", fallback.scripts)
+	}
+}
+
+func TestIdempotentScripts(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		script *template.Template
+		want   bool
+	}{
+		{script: getVmTemplate, want: true},
+		{script: getVhdTemplate, want: true},
+		{script: resizeVhdTemplate, want: true},
+		{script: getModuleHashTemplate, want: true},
+		{script: createVmTemplate, want: false},
+		{script: createVMSwitchTemplate, want: false},
+		{script: createOrUpdateVhdTemplate, want: false},
+		{script: deleteVmTemplate, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.script.Name(), func(t *testing.T) {
+			t.Parallel()
+
+			if got := retry.IsIdempotent(tt.script); got != tt.want {
+				t.Fatalf("expected %s to be idempotent %v, got %v", tt.script.Name(), tt.want, got)
+			}
+		})
+	}
+}
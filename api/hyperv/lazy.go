@@ -0,0 +1,192 @@
+package hyperv
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/taliesins/terraform-provider-hyperv/api/commandresult"
+)
+
+// LazyScriptRunner connects to the Hyper-V host on its first call rather than when it is created, so that a provider
+// can be configured before the host is reachable, or even exists. The connected script runner is kept for later calls
+// until it expires, a failed connection is attempted again on the next call. An expired script runner implementing
+// io.Closer is closed once the calls still using it return.
+type LazyScriptRunner struct {
+	connect func(ctx context.Context) (ScriptRunner, time.Time, error)
+
+	// now returns the current time, it is replaced by tests
+	now func() time.Time
+
+	mu        sync.Mutex
+	lease     *runnerLease
+	expiresAt time.Time
+}
+
+// runnerLease counts the calls using a connected script runner, so that it is closed only once they all returned
+type runnerLease struct {
+	runner  ScriptRunner
+	calls   int
+	expired bool
+}
+
+// NewLazyScriptRunner returns a script runner calling connect on its first call, and passing every call to the script
+// runner it returns. Connect also returns when the script runner expires, because the credentials it connected with
+// are rotated for example, after which connect is called again. A zero time never expires.
+func NewLazyScriptRunner(connect func(ctx context.Context) (ScriptRunner, time.Time, error)) *LazyScriptRunner {
+	return &LazyScriptRunner{
+		connect: connect,
+		now:     time.Now,
+	}
+}
+
+// scriptRunner returns the connected script runner, connecting again when it expired. Release must be called once
+// the script runner is no longer used.
+func (r *LazyScriptRunner) scriptRunner(ctx context.Context) (runner ScriptRunner, release func(), err error) {
+	// The expired script runner is closed after the lock is released, closing connections may take a while
+	var idle ScriptRunner
+	defer func() {
+		if idle != nil {
+			closeScriptRunner(idle)
+		}
+	}()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.lease == nil || (!r.expiresAt.IsZero() && !r.now().Before(r.expiresAt)) {
+		runner, expiresAt, err := r.connect(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if expired := r.lease; expired != nil {
+			expired.expired = true
+			if expired.calls == 0 {
+				idle = expired.runner
+			}
+		}
+
+		r.lease = &runnerLease{runner: runner}
+		r.expiresAt = expiresAt
+	}
+
+	lease := r.lease
+	lease.calls++
+
+	return lease.runner, func() { r.release(lease) }, nil
+}
+
+func (r *LazyScriptRunner) release(lease *runnerLease) {
+	r.mu.Lock()
+	lease.calls--
+	closing := lease.expired && lease.calls == 0
+	r.mu.Unlock()
+
+	if closing {
+		closeScriptRunner(lease.runner)
+	}
+}
+
+// closeScriptRunner closes the connections of an expired script runner, when it has any
+func closeScriptRunner(runner ScriptRunner) {
+	closer, ok := runner.(io.Closer)
+	if !ok {
+		return
+	}
+
+	if err := closer.Close(); err != nil {
+		log.Printf("[WARN] Failed to close expired script runner: %v", err)
+	}
+}
+
+func (r *LazyScriptRunner) RunFireAndForgetScript(ctx context.Context, script *template.Template, args interface{}) error {
+	runner, release, err := r.scriptRunner(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return runner.RunFireAndForgetScript(ctx, script, args)
+}
+
+func (r *LazyScriptRunner) RunScriptWithResult(ctx context.Context, script *template.Template, args interface{}, result interface{}) error {
+	runner, release, err := r.scriptRunner(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return runner.RunScriptWithResult(ctx, script, args, result)
+}
+
+func (r *LazyScriptRunner) RunStreamingScript(ctx context.Context, script *template.Template, args interface{}, result interface{}, records commandresult.RecordHandler) error {
+	runner, release, err := r.scriptRunner(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if streamingRunner, ok := runner.(StreamingScriptRunner); ok {
+		return streamingRunner.RunStreamingScript(ctx, script, args, result, records)
+	}
+
+	if result == nil {
+		return runner.RunFireAndForgetScript(ctx, script, args)
+	}
+
+	return runner.RunScriptWithResult(ctx, script, args, result)
+}
+
+func (r *LazyScriptRunner) UploadFile(ctx context.Context, filePath string, remoteFilePath string) (string, error) {
+	runner, release, err := r.scriptRunner(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	return runner.UploadFile(ctx, filePath, remoteFilePath)
+}
+
+func (r *LazyScriptRunner) UploadDirectory(ctx context.Context, rootPath string, excludeList []string) (string, []string, error) {
+	runner, release, err := r.scriptRunner(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	defer release()
+
+	return runner.UploadDirectory(ctx, rootPath, excludeList)
+}
+
+func (r *LazyScriptRunner) FileExists(ctx context.Context, remoteFilePath string) (bool, error) {
+	runner, release, err := r.scriptRunner(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
+	return runner.FileExists(ctx, remoteFilePath)
+}
+
+func (r *LazyScriptRunner) DirectoryExists(ctx context.Context, remoteDirectoryPath string) (bool, error) {
+	runner, release, err := r.scriptRunner(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
+	return runner.DirectoryExists(ctx, remoteDirectoryPath)
+}
+
+func (r *LazyScriptRunner) DeleteFileOrDirectory(ctx context.Context, remotePath string) error {
+	runner, release, err := r.scriptRunner(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return runner.DeleteFileOrDirectory(ctx, remotePath)
+}
@@ -0,0 +1,205 @@
+package hyperv
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/taliesins/terraform-provider-hyperv/api/commandresult"
+)
+
+// streamingRunner records the scripts run through it and whether they were streamed.
+type streamingRunner struct {
+	ScriptRunner
+
+	mu      sync.Mutex
+	scripts []string
+}
+
+func (r *streamingRunner) RunFireAndForgetScript(ctx context.Context, script *template.Template, args interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.scripts = append(r.scripts, script.Name())
+	return nil
+}
+
+func (r *streamingRunner) RunStreamingScript(ctx context.Context, script *template.Template, args interface{}, result interface{}, records commandresult.RecordHandler) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.scripts = append(r.scripts, "streamed "+script.Name())
+	return nil
+}
+
+func TestLazyScriptRunner(t *testing.T) {
+	t.Parallel()
+
+	runner := &streamingRunner{}
+	var connects int
+	var connectErr error
+	lazy := NewLazyScriptRunner(func(ctx context.Context) (ScriptRunner, time.Time, error) {
+		connects++
+		if connectErr != nil {
+			return nil, time.Time{}, connectErr
+		}
+		return runner, time.Time{}, nil
+	})
+
+	if connects != 0 {
+		t.Fatalf("expected no connection before the first call, got %d", connects)
+	}
+
+	connectErr = errors.New("host unreachable")
+	if err := lazy.RunFireAndForgetScript(context.Background(), updateVmStatusTemplate, updateVmStatusArgs{}); !errors.Is(err, connectErr) {
+		t.Fatalf("expected the connection error, got %v", err)
+	}
+
+	// The failed connection is not kept
+	connectErr = nil
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := lazy.RunFireAndForgetScript(context.Background(), updateVmStatusTemplate, updateVmStatusArgs{}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := lazy.RunStreamingScript(context.Background(), updateVmStatusTemplate, updateVmStatusArgs{}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if connects != 2 {
+		t.Fatalf("expected the connection to be made once more and kept, got %d connections", connects)
+	}
+	want := []string{"UpdateVmStatus", "UpdateVmStatus", "UpdateVmStatus", "UpdateVmStatus", "streamed UpdateVmStatus"}
+	if !reflect.DeepEqual(runner.scripts, want) {
+		t.Fatalf("expected scripts %v, got %v", want, runner.scripts)
+	}
+}
+
+func TestLazyScriptRunnerExpires(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	var runners []*streamingRunner
+	lazy := NewLazyScriptRunner(func(ctx context.Context) (ScriptRunner, time.Time, error) {
+		runner := &streamingRunner{}
+		runners = append(runners, runner)
+		return runner, now.Add(10 * time.Minute), nil
+	})
+	lazy.now = func() time.Time { return now }
+
+	for _, elapsed := range []time.Duration{0, 9 * time.Minute, 2 * time.Minute} {
+		now = now.Add(elapsed)
+		if err := lazy.RunFireAndForgetScript(context.Background(), updateVmStatusTemplate, updateVmStatusArgs{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(runners) != 2 || len(runners[0].scripts) != 2 || len(runners[1].scripts) != 1 {
+		t.Fatalf("expected to connect again once the script runner expired, got %d connections", len(runners))
+	}
+}
+
+// closingRunner is a script runner recording whether it was closed, its scripts wait for proceed when it is set.
+type closingRunner struct {
+	ScriptRunner
+
+	started chan struct{}
+	proceed chan struct{}
+
+	mu     sync.Mutex
+	closed int
+}
+
+func (r *closingRunner) RunFireAndForgetScript(ctx context.Context, script *template.Template, args interface{}) error {
+	if r.proceed != nil {
+		close(r.started)
+		<-r.proceed
+	}
+	return nil
+}
+
+func (r *closingRunner) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closed++
+	return nil
+}
+
+func (r *closingRunner) closeCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.closed
+}
+
+func TestLazyScriptRunnerClosesExpiredRunner(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	var mu sync.Mutex
+	runners := []*closingRunner{
+		{started: make(chan struct{}), proceed: make(chan struct{})},
+		{},
+		{},
+	}
+	var connects int
+	lazy := NewLazyScriptRunner(func(ctx context.Context) (ScriptRunner, time.Time, error) {
+		runner := runners[connects]
+		connects++
+		return runner, now.Add(10 * time.Minute), nil
+	})
+	lazy.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	advance := func(elapsed time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(elapsed)
+	}
+
+	// The first script runner is still running a script when the credentials are rotated
+	done := make(chan error)
+	go func() {
+		done <- lazy.RunFireAndForgetScript(context.Background(), updateVmStatusTemplate, updateVmStatusArgs{})
+	}()
+	<-runners[0].started
+
+	advance(11 * time.Minute)
+	if err := lazy.RunFireAndForgetScript(context.Background(), updateVmStatusTemplate, updateVmStatusArgs{}); err != nil {
+		t.Fatal(err)
+	}
+	if closed := runners[0].closeCount(); closed != 0 {
+		t.Fatalf("expected the expired script runner to stay open while a script runs, closed %d times", closed)
+	}
+
+	close(runners[0].proceed)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if closed := runners[0].closeCount(); closed != 1 {
+		t.Fatalf("expected the expired script runner to be closed once its script returned, closed %d times", closed)
+	}
+
+	// An idle script runner is closed as soon as it is replaced
+	advance(11 * time.Minute)
+	if err := lazy.RunFireAndForgetScript(context.Background(), updateVmStatusTemplate, updateVmStatusArgs{}); err != nil {
+		t.Fatal(err)
+	}
+	if runners[1].closeCount() != 1 || runners[2].closeCount() != 0 || runners[0].closeCount() != 1 {
+		t.Fatalf("expected only the replaced script runner to be closed, got %d, %d and %d", runners[0].closeCount(), runners[1].closeCount(), runners[2].closeCount())
+	}
+}
@@ -0,0 +1,143 @@
+package hyperv
+
+import (
+	"context"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+	"text/template"
+
+	"github.com/taliesins/terraform-provider-hyperv/api/commandresult"
+	"github.com/taliesins/terraform-provider-hyperv/api/retry"
+)
+
+// ModuleVersion is the version of the helper module installed on Hyper-V hosts. Versions are installed side by side,
+// so that providers of different versions can manage the same host.
+const ModuleVersion = "1.0.0"
+
+// moduleContent is the helper module holding the PowerShell functions shared by the scripts.
+//
+//go:embed module/TerraformHyperv.psm1
+var moduleContent []byte
+
+// moduleHash is the SHA256 hash of moduleContent. An installed module whose hash differs, because it was modified or
+// installed by a development build of the same version, is replaced.
+var moduleHash = func() string {
+	hash := sha256.Sum256(moduleContent)
+	return hex.EncodeToString(hash[:])
+}()
+
+// modulePath is where the helper module is installed on the Hyper-V host, in a form PowerShell expands in a double
+// quoted string. It is readable by every user, including the elevated user of WinRM.
+const modulePath = `$env:ProgramData\TerraformHyperv\Modules\TerraformHyperv\` + ModuleVersion + `\TerraformHyperv.psm1`
+
+// moduleImportHeader imports the helper module at the start of every script.
+const moduleImportHeader = `Import-Module -Name "` + modulePath + `"
+`
+
+var getModuleHashTemplate = retry.Idempotent(newModuleScriptTemplate("GetModuleHash", `
+$ErrorActionPreference = 'Stop'
+$path = "`+modulePath+`"
+
+$hash = ''
+if (Test-Path -LiteralPath $path) {
+	$hash = (Get-FileHash -LiteralPath $path -Algorithm SHA256).Hash.ToLower()
+}
+
+Write-Result $hash
+`))
+
+type installModuleArgs struct {
+	Content []byte
+}
+
+// installModuleTemplate writes the module to a temporary file first and moves it into place, so that a script of
+// another provider importing the module never reads it half written.
+var installModuleTemplate = retry.Idempotent(newModuleScriptTemplate("InstallModule", `
+$ErrorActionPreference = 'Stop'
+$path = "`+modulePath+`"
+
+$directory = [System.IO.Path]::GetDirectoryName($path)
+if (!(Test-Path -LiteralPath $directory)) {
+	New-Item -ItemType Directory -Force -Path $directory | Out-Null
+}
+
+$temporaryPath = "$path.$PID.tmp"
+[System.IO.File]::WriteAllBytes($temporaryPath, [System.Convert]::FromBase64String($params.Content))
+Move-Item -LiteralPath $temporaryPath -Destination $path -Force
+`))
+
+// moduleScriptRunner makes sure the helper module is installed on the Hyper-V host before the first script runs.
+// The installed module is checked once per runner, and only replaced when its hash differs.
+type moduleScriptRunner struct {
+	ScriptRunner
+
+	mu        sync.Mutex
+	installed bool
+}
+
+func newModuleScriptRunner(runner ScriptRunner) *moduleScriptRunner {
+	return &moduleScriptRunner{
+		ScriptRunner: runner,
+	}
+}
+
+func (r *moduleScriptRunner) ensureModule(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.installed {
+		return nil
+	}
+
+	var hash string
+	if err := r.ScriptRunner.RunScriptWithResult(ctx, getModuleHashTemplate, nil, &hash); err != nil {
+		return fmt.Errorf("error checking helper module %s: %w", ModuleVersion, err)
+	}
+
+	if hash != moduleHash {
+		log.Printf("[DEBUG] Installing helper module %s with hash %s, installed hash was %q", ModuleVersion, moduleHash, hash)
+
+		if err := r.ScriptRunner.RunFireAndForgetScript(ctx, installModuleTemplate, installModuleArgs{Content: moduleContent}); err != nil {
+			return fmt.Errorf("error installing helper module %s: %w", ModuleVersion, err)
+		}
+	}
+
+	r.installed = true
+	return nil
+}
+
+func (r *moduleScriptRunner) RunFireAndForgetScript(ctx context.Context, script *template.Template, args interface{}) error {
+	if err := r.ensureModule(ctx); err != nil {
+		return err
+	}
+
+	return r.ScriptRunner.RunFireAndForgetScript(ctx, script, args)
+}
+
+func (r *moduleScriptRunner) RunScriptWithResult(ctx context.Context, script *template.Template, args interface{}, result interface{}) error {
+	if err := r.ensureModule(ctx); err != nil {
+		return err
+	}
+
+	return r.ScriptRunner.RunScriptWithResult(ctx, script, args, result)
+}
+
+func (r *moduleScriptRunner) RunStreamingScript(ctx context.Context, script *template.Template, args interface{}, result interface{}, records commandresult.RecordHandler) error {
+	if err := r.ensureModule(ctx); err != nil {
+		return err
+	}
+
+	if runner, ok := r.ScriptRunner.(StreamingScriptRunner); ok {
+		return runner.RunStreamingScript(ctx, script, args, result, records)
+	}
+
+	if result == nil {
+		return r.ScriptRunner.RunFireAndForgetScript(ctx, script, args)
+	}
+
+	return r.ScriptRunner.RunScriptWithResult(ctx, script, args, result)
+}
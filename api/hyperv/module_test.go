@@ -0,0 +1,138 @@
+package hyperv
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+var functionPattern = regexp.MustCompile(`(?im)^\s*function\s+([\w-]+)`)
+
+func TestModule(t *testing.T) {
+	t.Parallel()
+
+	for i, b := range moduleContent {
+		if b >= 0x80 || b == '\r' {
+			t.Fatalf("expected the module to be ASCII with LF line endings, got %q at offset %d", b, i)
+		}
+	}
+
+	if hash := sha256.Sum256(moduleContent); moduleHash != hex.EncodeToString(hash[:]) {
+		t.Fatalf("unexpected module hash %s", moduleHash)
+	}
+
+	moduleFunctions := map[string]bool{}
+	for _, match := range functionPattern.FindAllStringSubmatch(string(moduleContent), -1) {
+		name := strings.ToLower(match[1])
+		if moduleFunctions[name] {
+			t.Fatalf("expected %s to be defined once by the module", match[1])
+		}
+		moduleFunctions[name] = true
+	}
+
+	for _, tc := range scriptTemplates {
+		script := renderScript(t, tc.script, tc.args)
+
+		imports := strings.Contains(script, moduleImportHeader)
+		if managesModule := tc.script == getModuleHashTemplate || tc.script == installModuleTemplate; imports == managesModule {
+			t.Fatalf("expected %s to import the module: %v", tc.script.Name(), !managesModule)
+		}
+
+		for _, match := range functionPattern.FindAllStringSubmatch(script, -1) {
+			if moduleFunctions[strings.ToLower(match[1])] {
+				t.Fatalf("expected %s to call %s of the module instead of defining it", tc.script.Name(), match[1])
+			}
+		}
+	}
+}
+
+// moduleRunner records the scripts run through it and answers GetModuleHash with hash.
+type moduleRunner struct {
+	ScriptRunner
+	hash    string
+	hashErr error
+	scripts []string
+}
+
+func (r *moduleRunner) RunFireAndForgetScript(ctx context.Context, script *template.Template, args interface{}) error {
+	r.scripts = append(r.scripts, script.Name())
+	if script == installModuleTemplate {
+		if installArgs, ok := args.(installModuleArgs); !ok || !bytes.Equal(installArgs.Content, moduleContent) {
+			return errors.New("unexpected module content")
+		}
+		r.hash = moduleHash
+	}
+
+	return nil
+}
+
+func (r *moduleRunner) RunScriptWithResult(ctx context.Context, script *template.Template, args interface{}, result interface{}) error {
+	r.scripts = append(r.scripts, script.Name())
+	if script != getModuleHashTemplate {
+		return json.Unmarshal([]byte(`{}`), result)
+	}
+
+	if r.hashErr != nil {
+		err := r.hashErr
+		r.hashErr = nil
+		return err
+	}
+
+	return json.Unmarshal([]byte(`"`+r.hash+`"`), result)
+}
+
+func TestModuleScriptRunner(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		hash    string
+		hashErr error
+		want    []string
+		wantErr bool
+	}{
+		{name: "installed", hash: moduleHash, want: []string{"GetModuleHash", "GetVm", "UpdateVmStatus"}},
+		{name: "not installed", hash: "", want: []string{"GetModuleHash", "InstallModule", "GetVm", "UpdateVmStatus"}},
+		{name: "modified", hash: strings.Repeat("0", 64), want: []string{"GetModuleHash", "InstallModule", "GetVm", "UpdateVmStatus"}},
+		{name: "check failed", hash: moduleHash, hashErr: errors.New("connection reset"), want: []string{"GetModuleHash", "GetModuleHash", "UpdateVmStatus"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			runner := &moduleRunner{hash: tt.hash, hashErr: tt.hashErr}
+			provider, err := New(&ClientConfig{ScriptRunner: runner})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			client, ok := provider.Client.(*ClientConfig)
+			if !ok {
+				t.Fatalf("unexpected client %T", provider.Client)
+			}
+
+			var result map[string]interface{}
+			err = client.ScriptRunner.RunScriptWithResult(context.Background(), getVmTemplate, getVmArgs{}, &result)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+
+			if err := client.ScriptRunner.RunFireAndForgetScript(context.Background(), updateVmStatusTemplate, updateVmStatusArgs{}); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(runner.scripts, tt.want) {
+				t.Fatalf("expected scripts %v, got %v", tt.want, runner.scripts)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package hyperv
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"text/template"
+	"unicode/utf8"
+
+	"github.com/taliesins/terraform-provider-hyperv/api"
+)
+
+// scriptTemplates pairs every script run on the Hyper-V host with the type of its arguments.
+var scriptTemplates = []struct {
+	script *template.Template
+	args   interface{}
+}{
+	{remoteFileHashTemplate, RemoteFileHashArgs{}},
+	{createOrUpdateIsoImageTemplate, createOrUpdateIsoImageArgs{}},
+	{getIsoImageTemplate, getIsoImageArgs{}},
+	{existsVhdTemplate, existsVhdArgs{}},
+	{createOrUpdateVhdTemplate, createOrUpdateVhdArgs{}},
+	{resizeVhdTemplate, resizeVhdArgs{}},
+	{getVhdTemplate, getVhdArgs{}},
+	{deleteVhdTemplate, deleteVhdArgs{}},
+	{existsVmTemplate, existsVmArgs{}},
+	{createVmTemplate, createVmArgs{}},
+	{getVmTemplate, getVmArgs{}},
+	{updateVmTemplate, updateVmArgs{}},
+	{deleteVmTemplate, deleteVmArgs{}},
+	{createVmDvdDriveTemplate, createVmDvdDriveArgs{}},
+	{getVmDvdDrivesTemplate, getVmDvdDrivesArgs{}},
+	{updateVmDvdDriveTemplate, updateVmDvdDriveArgs{}},
+	{deleteVmDvdDriveTemplate, deleteVmDvdDriveArgs{}},
+	{createOrUpdateVmFirmwareTemplate, createOrUpdateVmFirmwareArgs{}},
+	{getVmFirmwareTemplate, getVmFirmwareArgs{}},
+	{createVmHardDiskDriveTemplate, createVmHardDiskDriveArgs{}},
+	{getVmHardDiskDrivesTemplate, getVmHardDiskDrivesArgs{}},
+	{updateVmHardDiskDriveTemplate, updateVmHardDiskDriveArgs{}},
+	{deleteVmHardDiskDriveTemplate, deleteVmHardDiskDriveArgs{}},
+	{getVmIntegrationServicesTemplate, getVmIntegrationServicesArgs{}},
+	{enableVmIntegrationServiceTemplate, enableVmIntegrationServiceArgs{}},
+	{disableVmIntegrationServiceTemplate, disableVmIntegrationServiceArgs{}},
+	{createVmNetworkAdapterTemplate, createVmNetworkAdapterArgs{}},
+	{getVmNetworkAdaptersTemplate, getVmNetworkAdaptersArgs{}},
+	{waitForVmNetworkAdaptersIpsTemplate, waitForVmNetworkAdaptersIpsArgs{}},
+	{updateVmNetworkAdapterTemplate, updateVmNetworkAdapterArgs{}},
+	{deleteVmNetworkAdapterTemplate, deleteVmNetworkAdapterArgs{}},
+	{createOrUpdateVmProcessorTemplate, createOrUpdateVmProcessorArgs{}},
+	{getVmProcessorTemplate, getVmProcessorArgs{}},
+	{getVmStatusTemplate, getVmStatusArgs{}},
+	{updateVmStatusTemplate, updateVmStatusArgs{}},
+	{existsVMSwitchTemplate, existsVMSwitchArgs{}},
+	{createVMSwitchTemplate, createVMSwitchArgs{}},
+	{getVMSwitchTemplate, getVMSwitchArgs{}},
+	{updateVMSwitchTemplate, updateVMSwitchArgs{}},
+	{deleteVMSwitchTemplate, deleteVMSwitchArgs{}},
+	{getModuleHashTemplate, struct{}{}},
+	{installModuleTemplate, installModuleArgs{}},
+}
+
+var scriptParamsPattern = regexp.MustCompile(`FromBase64String\('([A-Za-z0-9+/=]*)'\)`)
+
+// fillStrings sets every string field of v, including the fields of nested structs and of a single element added to
+// every slice, to value.
+func fillStrings(v reflect.Value, value string) {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				fillStrings(v.Field(i), value)
+			}
+		}
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 1, 1))
+		fillStrings(v.Index(0), value)
+	case reflect.Map:
+		if v.Type().Key().Kind() == reflect.String && v.Type().Elem().Kind() == reflect.String {
+			v.Set(reflect.MakeMap(v.Type()))
+			v.SetMapIndex(reflect.ValueOf(value).Convert(v.Type().Key()), reflect.ValueOf(value).Convert(v.Type().Elem()))
+		}
+	}
+}
+
+func renderScript(t *testing.T, script *template.Template, args interface{}) string {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	if err := script.Execute(&buffer, args); err != nil {
+		t.Fatalf("error rendering %s: %v", script.Name(), err)
+	}
+
+	return buffer.String()
+}
+
+func FuzzScriptTemplates(f *testing.F) {
+	for _, seed := range []string{
+		"web",
+		`'`,
+		`"`,
+		"`",
+		"[web]*?",
+		`$(Remove-Item -Recurse C:\)`,
+		"'@\n\"@\nRemove-Item C:\\",
+		`web'; Remove-Item C:\; '`,
+		"{{.}}",
+		`$env:TEMP\web.iso`,
+		"\u2018web\u2019 \u201cweb\u201d",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, value string) {
+		if !utf8.ValidString(value) {
+			t.Skip("arguments are always valid UTF-8")
+		}
+
+		for _, tc := range scriptTemplates {
+			argsType := reflect.TypeOf(tc.args)
+			args := reflect.New(argsType).Elem()
+			fillStrings(args, value)
+
+			script := renderScript(t, tc.script, args.Interface())
+
+			match := scriptParamsPattern.FindStringSubmatch(script)
+			if match == nil {
+				t.Fatalf("%s does not pass its arguments as base64 encoded JSON", tc.script.Name())
+			}
+
+			if want := renderScript(t, tc.script, tc.args); scriptParamsPattern.ReplaceAllString(script, "") != scriptParamsPattern.ReplaceAllString(want, "") {
+				t.Fatalf("%s changed outside of its arguments for %q", tc.script.Name(), value)
+			}
+
+			argsJson, err := base64.StdEncoding.DecodeString(match[1])
+			if err != nil {
+				t.Fatalf("%s arguments are not base64 encoded: %v", tc.script.Name(), err)
+			}
+
+			decoded := reflect.New(argsType)
+			if err := json.Unmarshal(argsJson, decoded.Interface()); err != nil {
+				t.Fatalf("%s arguments are not valid JSON: %v", tc.script.Name(), err)
+			}
+
+			if !reflect.DeepEqual(decoded.Elem().Interface(), args.Interface()) {
+				t.Fatalf("%s arguments did not round trip, expected %+v, got %+v", tc.script.Name(), args.Interface(), decoded.Elem().Interface())
+			}
+		}
+	})
+}
+
+var vhdCmdletPathPattern = regexp.MustCompile(`(?i)\b\w+-VHD\b[^\n]*?-Path\s+(\S+)`)
+
+func TestScriptTemplatesEscapeVhdPaths(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range scriptTemplates {
+		script := renderScript(t, tc.script, tc.args)
+		for _, match := range vhdCmdletPathPattern.FindAllStringSubmatch(script, -1) {
+			if !strings.HasPrefix(match[1], "([WildcardPattern]::Escape(") {
+				t.Errorf("%s passes %s to a VHD cmdlet as a wildcard pattern: %s", tc.script.Name(), match[1], match[0])
+			}
+		}
+	}
+}
+
+func TestEncodeScriptParams(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		args interface{}
+		want string
+	}{
+		{name: "nil", args: nil, want: "null"},
+		{name: "name", args: getVmArgs{Name: `web'; Remove-Item C:\; '`}, want: `{"Name":"web'; Remove-Item C:\\; '"}`},
+		{name: "object", args: resizeVhdArgs{Path: `C:\vhds\[web].vhdx`, Size: 1024}, want: `{"Path":"C:\\vhds\\[web].vhdx","Size":1024}`},
+		{
+			name: "slice",
+			args: waitForVmNetworkAdaptersIpsArgs{VmName: "web", VmNetworkAdaptersWaitForIps: []api.VmNetworkAdapterWaitForIp{{Name: "lan", WaitForIps: true}}},
+			want: `{"VmName":"web","Timeout":0,"PollPeriod":0,"VmNetworkAdaptersWaitForIps":[{"Name":"lan","WaitForIps":true}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := encodeScriptParams(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoded, err := base64.StdEncoding.DecodeString(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(decoded) != tt.want {
+				t.Fatalf("expected %s, got %s", tt.want, decoded)
+			}
+		})
+	}
+
+	if _, err := encodeScriptParams(make(chan int)); err == nil {
+		t.Fatal("expected an error for arguments that cannot be converted to json")
+	}
+}
@@ -2,37 +2,24 @@ package hyperv
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
-	"log"
-	"strings"
-	"text/template"
-	"time"
 
 	"github.com/taliesins/terraform-provider-hyperv/api"
 )
 
-const (
-	vhdBusyRetryInterval = 10 * time.Second
-	vhdBusyRetryTimeout  = 5 * time.Minute
-)
-
 type existsVhdArgs struct {
 	Path string
 }
 
-var existsVhdTemplate = template.Must(template.New("ExistsVhd").Parse(`
+var existsVhdTemplate = newIdempotentScriptTemplate("ExistsVhd", `
 $ErrorActionPreference = 'Stop'
-$path='{{.Path}}'
+$path = $params.Path
 
-if (Test-Path $path) {
-	$exists = ConvertTo-Json -InputObject @{Exists=$true}
-	$exists
+if (Test-Path -LiteralPath $path) {
+	Write-Result @{Exists=$true}
 } else {
-	$exists = ConvertTo-Json -InputObject @{Exists=$false}
-	$exists
+	Write-Result @{Exists=$false}
 }
-`))
+`)
 
 func (c *ClientConfig) VhdExists(ctx context.Context, path string) (result api.VhdExists, err error) {
 	err = c.ScriptRunner.RunScriptWithResult(ctx, existsVhdTemplate, existsVhdArgs{
@@ -46,206 +33,61 @@ type createOrUpdateVhdArgs struct {
 	Source     string
 	SourceVm   string
 	SourceDisk int
-	VhdJson    string
+	Vhd        api.Vhd
 }
 
-var createOrUpdateVhdTemplate = template.Must(template.New("CreateOrUpdateVhd").Parse(`
+var createOrUpdateVhdTemplate = newScriptTemplate("CreateOrUpdateVhd", `
 $ErrorActionPreference = 'Stop'
 
 Import-Module Hyper-V
-$source='{{.Source}}'
-$sourceVm='{{.SourceVm}}'
-$sourceDisk={{.SourceDisk}}
-$vhd = '{{.VhdJson}}' | ConvertFrom-Json
+$source = $params.Source
+$sourceVm = $params.SourceVm
+$sourceDisk = $params.SourceDisk
+$vhd = $params.Vhd
 $vhdType = [Microsoft.Vhd.PowerShell.VhdType]$vhd.VhdType
 
-function Get-TarPath {
-	if (Get-Command "tar" -ErrorAction SilentlyContinue) {
-		return "tar"
-	} elseif (Test-Path "$env:SystemRoot\system32\tar.exe") {
-		return "$env:SystemRoot\system32\tar.exe"
-	} else {
-		return ""
-	}
-}
-
-function Get-7ZipPath {
-	if (Get-Command "7z" -ErrorAction SilentlyContinue) {
-		return "7z"
-	} elseif (Test-Path "$env:ProgramFiles\7-Zip\7z.exe") {
-		return "$env:ProgramFiles\7-Zip\7z.exe"
-	} elseif (Test-Path "${env:ProgramFiles(x86)}\7-Zip\7z.exe") {
-		return "${env:ProgramFiles(x86)}\7-Zip\7z.exe"
-	} else {
-		return ""
-	}
-}
-
-function Expand-Downloads {
-    param(
-        [Parameter(Mandatory = $true, Position = 0)]
-        [string]
-        [Alias('Folder')]
-        $FolderPath
-    )
-    process {
-		Push-Location $FolderPath
-
-        get-item *.zip | % {
-			$tempPath = join-path $FolderPath "temp"
-
-			$7zPath = Get-7ZipPath
-			if ($7zPath) {
-				$command = """$7zPath"" x ""$($_.FullName)"" -o""$tempPath""" 
-				& cmd.exe /C $command
-			} else {
-				Add-Type -AssemblyName System.IO.Compression.FileSystem
-    			if (!(Test-Path $tempPath)) {
-        			New-Item -ItemType Directory -Force -Path $tempPath
-    			}
-            	[System.IO.Compression.ZipFile]::ExtractToDirectory($_.FullName, $tempPath)
-			}
-
-			$vhdPath = Get-ChildItem $tempPath *"Virtual Hard Disks"* -Recurse -Directory
-
-            if ($vhdPath -and (Test-Path $vhdPath.FullName)) {
-        		Move-Item "$($vhdPath.FullName)\*.*" $FolderPath
-			} else {
-				Move-Item "$tempPath\*.*" $FolderPath
-			}
-
-			Remove-Item $tempPath -Force -Recurse
-			Remove-Item $_.FullName -Force
-        }
-
-        get-item *.7z | % {
-			$7zPath = Get-7ZipPath
-			if (-not $7zPath) {
- 				throw "7z.exe needed"
-			}
-			$tempPath = join-path $FolderPath "temp"
-			$command = """$7zPath"" x ""$($_.FullName)"" -o""$tempPath""" 
-			& cmd.exe /C $command
-
-			$vhdPath = Get-ChildItem $tempPath *"Virtual Hard Disks"* -Recurse -Directory
-
-            if ($vhdPath -and (Test-Path $vhdPath.FullName)) {
-        		Move-Item "$($vhdPath.FullName)\*.*" $FolderPath
-			} else {
-				Move-Item "$tempPath\*.*" $FolderPath
-			}
-
-			Remove-Item $tempPath -Force -Recurse
-			Remove-Item $_.FullName -Force
-        }
-
-        get-item *.box | % {
-			$tarPath = Get-TarPath
-			if (-not $tarPath) {
-				throw "tar.exe needed"
-			}
-			$tempPath = join-path $FolderPath "temp"
-
-			if (!(Test-Path $tempPath)) {
-				New-Item -ItemType Directory -Force -Path $tempPath
-			}
-			$command = """$tarPath"" -C ""$tempPath"" -x -f ""$($_.FullName)"""
-			& cmd.exe /C $command
-
-			$vhdPath = Get-ChildItem $tempPath *"Virtual Hard Disks"* -Recurse -Directory
-
-            if ($vhdPath -and (Test-Path $vhdPath.FullName)) {
-        		Move-Item "$($vhdPath.FullName)\*.*" $FolderPath
-			} else {
-				Move-Item "$tempPath\*.*" $FolderPath
-			}
-
-			Remove-Item $tempPath -Force -Recurse
-			Remove-Item $_.FullName -Force
-        }
-
-		Pop-Location
-    }
-}
-
-function Get-FileFromUri {
-    param(
-        [Parameter(Mandatory = $true, Position = 0, ValueFromPipeline = $true, ValueFromPipelineByPropertyName = $true)]
-        [string]
-        [Alias('Uri')]
-        $Url,
-        [Parameter(Mandatory = $false, Position = 1)]
-        [string]
-        [Alias('Folder')]
-        $FolderPath
-    )
-    process {
-        $req = [System.Net.HttpWebRequest]::Create($Url)
-        $req.Method = "HEAD"
-        $response = $req.GetResponse()
-        $fUri = $response.ResponseUri
-        $filename = [System.IO.Path]::GetFileName($fUri.LocalPath)
-        $response.Close()
-
-        $origExt = [System.IO.Path]::GetExtension($Url)
-        $newExt = [System.IO.Path]::GetExtension($filename)
-        if ($newExt -ne $origExt) {
-            $filename += $origExt
-        }
-
-        $destination = (Get-Item -Path ".\" -Verbose).FullName
-        if ($FolderPath) { $destination = $FolderPath }
-        if ($destination.EndsWith('\')) {
-            $destination += $filename
-        }
-        else {
-            $destination += '\' + $filename
-        }
-        $webclient = New-Object System.Net.WebClient
-        $webclient.DownloadFile($fUri.AbsoluteUri, $destination)
-    }
-}
-
-function Test-Uri {
-    param(
-        [Parameter(Mandatory = $true, Position = 0, ValueFromPipeline = $true, ValueFromPipelineByPropertyName = $true)]
-        [string]
-        [Alias('Uri')]
-        $Url
-    )
-    process {
-        $testUri = $Url -as [System.URI]
-        $null -ne $testUri.AbsoluteURI -and $testUri.Scheme -match '[http|https]' -and ($testUri.ToString().ToLower().StartsWith("http://") -or $testUri.ToString().ToLower().StartsWith("https://"))
-    }
-}
-
-if ($vhd -and !(Test-Path $vhd.Path)) {
+if ($vhd -and !(Test-Path -LiteralPath $vhd.Path)) {
     $pathDirectory = [System.IO.Path]::GetDirectoryName($vhd.Path)
     $pathFilename = [System.IO.Path]::GetFileName($vhd.Path)
 
-    if (!(Test-Path $pathDirectory)) {
+    if (!(Test-Path -LiteralPath $pathDirectory)) {
         New-Item -ItemType Directory -Force -Path $pathDirectory
     }
 
     if ($sourceVm) {
-        Export-VM -Name $sourceVm -Path $pathDirectory
+        $sourceVmObject = Get-VM | Where-Object { $_.Name -eq $sourceVm }
+        if (!$sourceVmObject) {
+            Write-Error -Message "VM does not exist - $($sourceVm)" -Category ObjectNotFound -ErrorId ObjectNotFound -ErrorAction Stop
+        }
+
+        $progressActivity = "Exporting VM $($sourceVm)"
+        $exportJob = Export-VM -VM $sourceVmObject -Path $pathDirectory -AsJob
+        while ($exportJob.State -eq 'Running' -or $exportJob.State -eq 'NotStarted') {
+            $exportProgress = $exportJob.ChildJobs | ForEach-Object { $_.Progress } | Select-Object -Last 1
+            if ($exportProgress) {
+                Write-Progress -Activity $progressActivity -Status $exportProgress.StatusDescription -PercentComplete $exportProgress.PercentComplete
+            }
+            Wait-Job -Job $exportJob -Timeout 5 | Out-Null
+        }
+        Receive-Job -Job $exportJob -Wait -AutoRemoveJob -ErrorAction Stop | Out-Null
+        Write-Progress -Activity $progressActivity -Status "Done" -Completed
         $targetName = (split-path $vhd.Path -Leaf)
         $targetName = $targetName.Substring(0,$targetName.LastIndexOf('.')).split('\')[-1]
-        Get-ChildItem -Path "$pathDirectory\$sourceVm\Virtual Hard Disks" |?{$_.BaseName.StartsWith($sourceVm)} | %{
+        Get-ChildItem -LiteralPath "$pathDirectory\$sourceVm\Virtual Hard Disks" |?{$_.BaseName.StartsWith($sourceVm)} | %{
             $targetNamePath = "$($pathDirectory)\$($_.Name.Replace($sourceVm, $targetName))"
-            Move-Item $_.FullName $targetNamePath
+            Move-Item -LiteralPath $_.FullName -Destination $targetNamePath
         }
 
-        Remove-Item "$pathDirectory\$sourceVm" -Force -Recurse
-        Get-VHD -path $vhd.Path
+        Remove-Item -LiteralPath "$pathDirectory\$sourceVm" -Force -Recurse
+        Get-VHD -Path ([WildcardPattern]::Escape($vhd.Path))
     } elseif ($source) {
-        Push-Location $pathDirectory
+        Push-Location -LiteralPath $pathDirectory
         
         if (Test-Uri -Url $source) {
             Get-FileFromUri -Url $source -FolderPath $pathDirectory
         }
         else {
-            Copy-Item $source "$pathDirectory\$pathFilename" -Force
+            Copy-Item -LiteralPath $source -Destination "$pathDirectory\$pathFilename" -Force
         }
 
         Expand-Downloads -FolderPath $pathDirectory
@@ -298,28 +140,25 @@ if ($vhd -and !(Test-Path $vhd.Path)) {
         New-VHD @NewVhdArgs
     }
 }
-`))
+`)
 
 func (c *ClientConfig) CreateOrUpdateVhd(ctx context.Context, path string, source string, sourceVm string, sourceDisk int, vhdType api.VhdType, parentPath string, size uint64, blockSize uint32, logicalSectorSize uint32, physicalSectorSize uint32) (err error) {
-	vhdJson, err := json.Marshal(api.Vhd{
-		Path:               path,
-		VhdType:            vhdType,
-		ParentPath:         parentPath,
-		Size:               size,
-		BlockSize:          blockSize,
-		LogicalSectorSize:  logicalSectorSize,
-		PhysicalSectorSize: physicalSectorSize,
-	})
-
-	if err != nil {
-		return err
-	}
-
-	err = c.ScriptRunner.RunFireAndForgetScript(ctx, createOrUpdateVhdTemplate, createOrUpdateVhdArgs{
+	err = c.runStreamingScript(ctx, createOrUpdateVhdTemplate, createOrUpdateVhdArgs{
 		Source:     source,
 		SourceVm:   sourceVm,
 		SourceDisk: sourceDisk,
-		VhdJson:    string(vhdJson),
+		Vhd: api.Vhd{
+			Path:               path,
+			VhdType:            vhdType,
+			ParentPath:         parentPath,
+			Size:               size,
+			BlockSize:          blockSize,
+			LogicalSectorSize:  logicalSectorSize,
+			PhysicalSectorSize: physicalSectorSize,
+		},
+	}, nil, map[string]interface{}{
+		"path":    path,
+		"vm_name": sourceVm,
 	})
 
 	return err
@@ -330,20 +169,20 @@ type resizeVhdArgs struct {
 	Size uint64
 }
 
-var resizeVhdTemplate = template.Must(template.New("ResizeVhd").Parse(`
+var resizeVhdTemplate = newIdempotentScriptTemplate("ResizeVhd", `
 $ErrorActionPreference = 'Stop'
-$vhd = Get-VHD -Path '{{.Path}}'
-if ($vhd.Size -ne {{.Size}}){
-	Resize-VHD -Path '{{.Path}}' -SizeBytes {{.Size}}
+# The Hyper-V cmdlets take wildcards, a path such as C:\vhds\[web].vhdx must be escaped
+$path = $params.Path
+$vhd = Get-VHD -Path ([WildcardPattern]::Escape($path))
+if ($vhd.Size -ne $params.Size){
+	Resize-VHD -Path ([WildcardPattern]::Escape($path)) -SizeBytes $params.Size
 }
-`))
+`)
 
 func (c *ClientConfig) ResizeVhd(ctx context.Context, path string, size uint64) (err error) {
-	err = runVhdOperationWithRetry(ctx, path, "ResizeVhd", vhdBusyRetryInterval, vhdBusyRetryTimeout, func() error {
-		return c.ScriptRunner.RunFireAndForgetScript(ctx, resizeVhdTemplate, resizeVhdArgs{
-			Path: path,
-			Size: size,
-		})
+	err = c.ScriptRunner.RunFireAndForgetScript(ctx, resizeVhdTemplate, resizeVhdArgs{
+		Path: path,
+		Size: size,
 	})
 
 	return err
@@ -353,13 +192,13 @@ type getVhdArgs struct {
 	Path string
 }
 
-var getVhdTemplate = template.Must(template.New("GetVhd").Parse(`
+var getVhdTemplate = newIdempotentScriptTemplate("GetVhd", `
 $ErrorActionPreference = 'Stop'
-$path='{{.Path}}'
+$path = $params.Path
 
 $vhdObject = $null
-if (Test-Path $path) {
-	$vhdObject = Get-VHD -path $path | %{ @{
+if (Test-Path -LiteralPath $path) {
+	$vhdObject = Get-VHD -Path ([WildcardPattern]::Escape($path)) | %{ @{
 		Path=$_.Path;
 		BlockSize=$_.BlockSize;
 		LogicalSectorSize=$_.LogicalSectorSize;
@@ -380,80 +219,30 @@ if (Test-Path $path) {
 }
 
 if ($vhdObject){
-	$vhd = ConvertTo-Json -InputObject $vhdObject
-	$vhd
+	Write-Result $vhdObject
 } else {
-	"{}"
+	Write-Result @{}
 }
-`))
+`)
 
 func (c *ClientConfig) GetVhd(ctx context.Context, path string) (result api.Vhd, err error) {
-	err = runVhdOperationWithRetry(ctx, path, "GetVhd", vhdBusyRetryInterval, vhdBusyRetryTimeout, func() error {
-		return c.ScriptRunner.RunScriptWithResult(ctx, getVhdTemplate, getVhdArgs{
-			Path: path,
-		}, &result)
-	})
+	err = c.ScriptRunner.RunScriptWithResult(ctx, getVhdTemplate, getVhdArgs{
+		Path: path,
+	}, &result)
 
 	return result, err
 }
 
-func runVhdOperationWithRetry(ctx context.Context, path string, operationName string, retryInterval time.Duration, timeout time.Duration, run func() error) error {
-	deadline := time.Now().Add(timeout)
-	attempt := 1
-
-	for {
-		if err := ctx.Err(); err != nil {
-			return fmt.Errorf("%s canceled before retrying VHD operation for path %q: %w", operationName, path, err)
-		}
-
-		err := run()
-		if err == nil {
-			return nil
-		}
-
-		if !isVhdResourceBusyError(err) {
-			return err
-		}
-
-		if time.Now().After(deadline) {
-			return fmt.Errorf("%s timed out waiting for VHD path %q to be ready after %s: %w", operationName, path, timeout, err)
-		}
-
-		log.Printf("[WARN][hyperv][vhd] %s retrying for VHD path %q after transient lock error (attempt %d): %s", operationName, path, attempt, err)
-		attempt++
-
-		timer := time.NewTimer(retryInterval)
-		select {
-		case <-ctx.Done():
-			timer.Stop()
-			return fmt.Errorf("%s canceled while waiting for VHD path %q to be ready: %w", operationName, path, ctx.Err())
-		case <-timer.C:
-		}
-	}
-}
-
-func isVhdResourceBusyError(err error) bool {
-	if err == nil {
-		return false
-	}
-
-	errMessage := strings.ToLower(err.Error())
-
-	return strings.Contains(errMessage, "objectinuse") ||
-		strings.Contains(errMessage, "resourcebusy") ||
-		strings.Contains(errMessage, "object is in use")
-}
-
 type deleteVhdArgs struct {
 	Path string
 }
 
-var deleteVhdTemplate = template.Must(template.New("DeleteVhd").Parse(`
+var deleteVhdTemplate = newScriptTemplate("DeleteVhd", `
 $ErrorActionPreference = 'Stop'
 
-$path = '{{.Path}}'
-$targetDirectory = Split-Path $path -Parent
-$targetLeaf = Split-Path $path -Leaf
+$path = $params.Path
+$targetDirectory = [System.IO.Path]::GetDirectoryName($path)
+$targetLeaf = [System.IO.Path]::GetFileName($path)
 $targetBaseName = [System.IO.Path]::GetFileNameWithoutExtension($targetLeaf)
 
 if (Test-Path -LiteralPath $targetDirectory) {
@@ -469,7 +258,7 @@ if (Test-Path -LiteralPath $targetDirectory) {
         }
     }
 }
-`))
+`)
 
 func (c *ClientConfig) DeleteVhd(ctx context.Context, path string) (err error) {
 	// Convert to Windows path for PowerShell
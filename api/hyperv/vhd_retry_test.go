@@ -3,146 +3,68 @@ package hyperv
 import (
 	"context"
 	"errors"
-	"strings"
 	"testing"
+	"text/template"
 	"time"
-)
-
-func TestIsVhdResourceBusyError(t *testing.T) {
-	t.Parallel()
-
-	tests := []struct {
-		name string
-		err  error
-		want bool
-	}{
-		{
-			name: "nil error",
-			err:  nil,
-			want: false,
-		},
-		{
-			name: "object in use message",
-			err:  errors.New("Get-VHD failed: The operation cannot be performed while the object is in use."),
-			want: true,
-		},
-		{
-			name: "object in use code",
-			err:  errors.New("FullyQualifiedErrorId : ObjectInUse,Microsoft.Vhd.PowerShell.Cmdlets.GetVHD"),
-			want: true,
-		},
-		{
-			name: "resource busy",
-			err:  errors.New("CategoryInfo : ResourceBusy: (:) [Get-VHD], VirtualizationException"),
-			want: true,
-		},
-		{
-			name: "other error",
-			err:  errors.New("Access denied"),
-			want: false,
-		},
-	}
 
-	for _, tc := range tests {
-		t.Run(tc.name, func(t *testing.T) {
-			t.Parallel()
+	"github.com/taliesins/terraform-provider-hyperv/api"
+	"github.com/taliesins/terraform-provider-hyperv/api/commandresult"
+	"github.com/taliesins/terraform-provider-hyperv/api/retry"
+)
 
-			got := isVhdResourceBusyError(tc.err)
-			if got != tc.want {
-				t.Fatalf("isVhdResourceBusyError() = %v, want %v", got, tc.want)
-			}
-		})
-	}
+var busyError = &commandresult.ScriptError{
+	ExceptionType:         "Microsoft.HyperV.PowerShell.VirtualizationException",
+	FullyQualifiedErrorId: "ObjectInUse,Microsoft.Vhd.PowerShell.Cmdlets.GetVHD",
+	Category:              "ResourceBusy",
+	Message:               "L'opération ne peut pas être effectuée tant que l'objet est en cours d'utilisation.",
 }
 
-func TestRunVhdOperationWithRetrySucceedsAfterTransientBusyError(t *testing.T) {
-	t.Parallel()
-
-	ctx := context.Background()
-	attempts := 0
-
-	err := runVhdOperationWithRetry(ctx, `C:\temp\disk.vhdx`, "GetVhd", time.Millisecond, 100*time.Millisecond, func() error {
-		attempts++
-		if attempts < 3 {
-			return errors.New("ObjectInUse")
-		}
-		return nil
-	})
+// lockedVhdRunner fails the first scripts run through it with busyError, as for a VHD locked by a merge.
+type lockedVhdRunner struct {
+	ScriptRunner
+	locked  int
+	scripts []string
+}
 
-	if err != nil {
-		t.Fatalf("expected retry to eventually succeed, got error: %v", err)
+func (r *lockedVhdRunner) run(script *template.Template) error {
+	r.scripts = append(r.scripts, script.Name())
+	if len(r.scripts) <= r.locked {
+		return busyError
 	}
 
-	if attempts != 3 {
-		t.Fatalf("expected 3 attempts, got %d", attempts)
-	}
+	return nil
 }
 
-func TestRunVhdOperationWithRetryReturnsNonTransientErrorImmediately(t *testing.T) {
-	t.Parallel()
-
-	ctx := context.Background()
-	attempts := 0
-
-	err := runVhdOperationWithRetry(ctx, `C:\temp\disk.vhdx`, "GetVhd", time.Millisecond, 100*time.Millisecond, func() error {
-		attempts++
-		return errors.New("access denied")
-	})
-
-	if err == nil {
-		t.Fatal("expected error")
-	}
+func (r *lockedVhdRunner) RunFireAndForgetScript(ctx context.Context, script *template.Template, args interface{}) error {
+	return r.run(script)
+}
 
-	if attempts != 1 {
-		t.Fatalf("expected one attempt for non-transient error, got %d", attempts)
-	}
+func (r *lockedVhdRunner) RunScriptWithResult(ctx context.Context, script *template.Template, args interface{}, result interface{}) error {
+	return r.run(script)
 }
 
-func TestRunVhdOperationWithRetryTimesOutOnPersistentBusyError(t *testing.T) {
+func TestVhdOperationsWaitForLockedVhd(t *testing.T) {
 	t.Parallel()
 
 	ctx := context.Background()
-	attempts := 0
-
-	err := runVhdOperationWithRetry(ctx, `C:\temp\disk.vhdx`, "GetVhd", time.Millisecond, 5*time.Millisecond, func() error {
-		attempts++
-		return errors.New("The operation cannot be performed while the object is in use")
-	})
-
-	if err == nil {
-		t.Fatal("expected timeout error")
-	}
-
-	if !strings.Contains(err.Error(), "timed out") {
-		t.Fatalf("expected timeout error message, got: %v", err)
-	}
+	policy := retry.Policy{MaxAttempts: 1, InitialInterval: time.Millisecond, BusyInterval: time.Millisecond, BusyBudget: time.Second}
 
-	if attempts < 2 {
-		t.Fatalf("expected retry attempts, got %d", attempts)
+	runner := &lockedVhdRunner{locked: 3}
+	client := &ClientConfig{ScriptRunner: retry.NewRunner(runner, policy)}
+	if err := client.ResizeVhd(ctx, `C:\temp\disk.vhdx`, 1024); err != nil {
+		t.Fatalf("expected the resize to wait for the VHD, got %v", err)
 	}
-}
-
-func TestRunVhdOperationWithRetryHonorsContextCancellation(t *testing.T) {
-	t.Parallel()
-
-	ctx, cancel := context.WithCancel(context.Background())
-	attempts := 0
-
-	err := runVhdOperationWithRetry(ctx, `C:\temp\disk.vhdx`, "GetVhd", 50*time.Millisecond, time.Second, func() error {
-		attempts++
-		cancel()
-		return errors.New("ObjectInUse")
-	})
-
-	if err == nil {
-		t.Fatal("expected cancellation error")
+	if len(runner.scripts) != 4 {
+		t.Fatalf("expected 4 attempts, got %v", runner.scripts)
 	}
 
-	if !strings.Contains(err.Error(), "canceled") {
-		t.Fatalf("expected cancellation error message, got: %v", err)
+	runner = &lockedVhdRunner{locked: 1000}
+	policy.BusyBudget = 10 * time.Millisecond
+	client = &ClientConfig{ScriptRunner: retry.NewRunner(runner, policy)}
+	if _, err := client.GetVhd(ctx, `C:\temp\disk.vhdx`); !errors.Is(err, api.ErrResourceBusy) {
+		t.Fatalf("expected the VHD to stay locked, got %v", err)
 	}
-
-	if attempts != 1 {
-		t.Fatalf("expected one attempt before cancellation, got %d", attempts)
+	if len(runner.scripts) < 2 {
+		t.Fatalf("expected the VHD to be waited for, got %v", runner.scripts)
 	}
 }
@@ -2,8 +2,6 @@ package hyperv
 
 import (
 	"context"
-	"encoding/json"
-	"text/template"
 
 	"github.com/taliesins/terraform-provider-hyperv/api"
 )
@@ -12,18 +10,16 @@ type existsVmArgs struct {
 	Name string
 }
 
-var existsVmTemplate = template.Must(template.New("ExistsVm").Parse(`
+var existsVmTemplate = newIdempotentScriptTemplate("ExistsVm", `
 $ErrorActionPreference = 'Stop'
-$vmObject = Get-VM -Name '{{.Name}}*' | ?{$_.Name -eq '{{.Name}}' }
+$vmObject = Get-VM | Where-Object { $_.Name -eq $params.Name }
 
 if ($vmObject){
-	$exists = ConvertTo-Json -InputObject @{Exists=$true}
-	$exists
+	Write-Result @{Exists=$true}
 } else {
-	$exists = ConvertTo-Json -InputObject @{Exists=$false}
-	$exists
+	Write-Result @{Exists=$false}
 }
-`))
+`)
 
 func (c *ClientConfig) VmExists(ctx context.Context, name string) (result api.VmExists, err error) {
 	err = c.ScriptRunner.RunScriptWithResult(ctx, existsVmTemplate, existsVmArgs{
@@ -34,13 +30,13 @@ func (c *ClientConfig) VmExists(ctx context.Context, name string) (result api.Vm
 }
 
 type createVmArgs struct {
-	VmJson string
+	Vm api.Vm
 }
 
-var createVmTemplate = template.Must(template.New("CreateVm").Parse(`
+var createVmTemplate = newScriptTemplate("CreateVm", `
 $ErrorActionPreference = 'Stop'
 Import-Module Hyper-V
-$vm = '{{.VmJson}}' | ConvertFrom-Json
+$vm = $params.Vm
 $automaticCriticalErrorAction = [Microsoft.HyperV.PowerShell.CriticalErrorAction]$vm.AutomaticCriticalErrorAction
 $automaticStartAction = [Microsoft.HyperV.PowerShell.StartAction]$vm.AutomaticStartAction
 $automaticStopAction = [Microsoft.HyperV.PowerShell.StopAction]$vm.AutomaticStopAction
@@ -48,10 +44,10 @@ $checkpointType = [Microsoft.HyperV.PowerShell.CheckpointType]$vm.CheckpointType
 $lockOnDisconnect = [Microsoft.HyperV.PowerShell.OnOffState]$vm.LockOnDisconnect
 $allowUnverifiedPaths = $true #Not a property set on the vm object, skips validation when changing path
 
-$vmObject = Get-VM -Name "$($vm.Name)*" | ?{$_.Name -eq $vm.Name}
+$vmObject = Get-VM | Where-Object { $_.Name -eq $vm.Name }
 
 if ($vmObject){
-	throw "VM already exists - $($vm.Name)"
+	Write-Error -Message "VM already exists - $($vm.Name)" -Category ResourceExists -ErrorId AlreadyExists -ErrorAction Stop
 }
 
 $NewVmArgs = @{
@@ -65,30 +61,30 @@ if ($vm.Path) {
 	$NewVmArgs.Path = $vm.Path
 }
 
-New-Vm @NewVmArgs
+$vmObject = New-Vm @NewVmArgs
 
 #Delete any auto-generated network adapter
-Get-VMNetworkAdapter -VmName $vm.Name | Remove-VMNetworkAdapter
+$vmObject | Get-VMNetworkAdapter | Remove-VMNetworkAdapter
 
 #Delete any auto-generated dvd drive
-Get-VMDvdDrive -VmName $vm.Name | Remove-VMDvdDrive
+$vmObject | Get-VMDvdDrive | Remove-VMDvdDrive
 
 #Set static and dynamic properties can't be set at the same time, but we need the values to match terraforms state
 $SetVmArgs = @{}
-$SetVmArgs.Name=$vm.Name
+$SetVmArgs.VM=$vmObject
 $SetVmArgs.StaticMemory=$true
 $SetVmArgs.MemoryStartupBytes=$vm.MemoryStartupBytes
 Set-Vm @SetVmArgs
 
 $SetVmArgs = @{}
-$SetVmArgs.Name=$vm.Name
+$SetVmArgs.VM=$vmObject
 $SetVmArgs.DynamicMemory=$true
 $SetVmArgs.MemoryMinimumBytes=$vm.MemoryMinimumBytes
 $SetVmArgs.MemoryMaximumBytes=$vm.MemoryMaximumBytes
 Set-Vm @SetVmArgs
 
 $SetVmArgs = @{}
-$SetVmArgs.Name=$vm.Name
+$SetVmArgs.VM=$vmObject
 $SetVmArgs.GuestControlledCacheTypes=$vm.GuestControlledCacheTypes
 $SetVmArgs.LowMemoryMappedIoSpace=$vm.LowMemoryMappedIoSpace
 $SetVmArgs.HighMemoryMappedIoSpace=$vm.HighMemoryMappedIoSpace
@@ -112,7 +108,7 @@ if ($vm.StaticMemory) {
 
 Set-Vm @SetVmArgs
 
-`))
+`)
 
 func (c *ClientConfig) CreateVm(
 	ctx context.Context,
@@ -139,37 +135,31 @@ func (c *ClientConfig) CreateVm(
 	snapshotFileLocation string,
 	staticMemory bool,
 ) (err error) {
-	vmJson, err := json.Marshal(api.Vm{
-		Name:                                name,
-		Path:                                path,
-		Generation:                          generation,
-		AutomaticCriticalErrorAction:        automaticCriticalErrorAction,
-		AutomaticCriticalErrorActionTimeout: automaticCriticalErrorActionTimeout,
-		AutomaticStartAction:                automaticStartAction,
-		AutomaticStartDelay:                 automaticStartDelay,
-		AutomaticStopAction:                 automaticStopAction,
-		CheckpointType:                      checkpointType,
-		DynamicMemory:                       dynamicMemory,
-		GuestControlledCacheTypes:           guestControlledCacheTypes,
-		HighMemoryMappedIoSpace:             highMemoryMappedIoSpace,
-		LockOnDisconnect:                    lockOnDisconnect,
-		LowMemoryMappedIoSpace:              lowMemoryMappedIoSpace,
-		MemoryMaximumBytes:                  memoryMaximumBytes,
-		MemoryMinimumBytes:                  memoryMinimumBytes,
-		MemoryStartupBytes:                  memoryStartupBytes,
-		Notes:                               notes,
-		ProcessorCount:                      processorCount,
-		SmartPagingFilePath:                 smartPagingFilePath,
-		SnapshotFileLocation:                snapshotFileLocation,
-		StaticMemory:                        staticMemory,
-	})
-
-	if err != nil {
-		return err
-	}
-
 	err = c.ScriptRunner.RunFireAndForgetScript(ctx, createVmTemplate, createVmArgs{
-		VmJson: string(vmJson),
+		Vm: api.Vm{
+			Name:                                name,
+			Path:                                path,
+			Generation:                          generation,
+			AutomaticCriticalErrorAction:        automaticCriticalErrorAction,
+			AutomaticCriticalErrorActionTimeout: automaticCriticalErrorActionTimeout,
+			AutomaticStartAction:                automaticStartAction,
+			AutomaticStartDelay:                 automaticStartDelay,
+			AutomaticStopAction:                 automaticStopAction,
+			CheckpointType:                      checkpointType,
+			DynamicMemory:                       dynamicMemory,
+			GuestControlledCacheTypes:           guestControlledCacheTypes,
+			HighMemoryMappedIoSpace:             highMemoryMappedIoSpace,
+			LockOnDisconnect:                    lockOnDisconnect,
+			LowMemoryMappedIoSpace:              lowMemoryMappedIoSpace,
+			MemoryMaximumBytes:                  memoryMaximumBytes,
+			MemoryMinimumBytes:                  memoryMinimumBytes,
+			MemoryStartupBytes:                  memoryStartupBytes,
+			Notes:                               notes,
+			ProcessorCount:                      processorCount,
+			SmartPagingFilePath:                 smartPagingFilePath,
+			SnapshotFileLocation:                snapshotFileLocation,
+			StaticMemory:                        staticMemory,
+		},
 	})
 
 	return err
@@ -179,9 +169,9 @@ type getVmArgs struct {
 	Name string
 }
 
-var getVmTemplate = template.Must(template.New("GetVm").Parse(`
+var getVmTemplate = newIdempotentScriptTemplate("GetVm", `
 $ErrorActionPreference = 'Stop'
-$vmObject = Get-VM -Name '{{.Name}}*' -ErrorAction SilentlyContinue | ?{$_.Name -eq '{{.Name}}' } | %{ @{
+$vmObject = Get-VM -ErrorAction SilentlyContinue | Where-Object { $_.Name -eq $params.Name } | %{ @{
 	Name=$_.Name;
 	Path=$_.Path;
 	Generation=$_.Generation;
@@ -207,12 +197,11 @@ $vmObject = Get-VM -Name '{{.Name}}*' -ErrorAction SilentlyContinue | ?{$_.Name
 }}
 
 if ($vmObject) {
-	$vm = ConvertTo-Json -InputObject $vmObject
-	$vm
+	Write-Result $vmObject
 } else {
-	"{}"
+	Write-Result @{}
 }
-`))
+`)
 
 func (c *ClientConfig) GetVm(ctx context.Context, name string) (result api.Vm, err error) {
 	err = c.ScriptRunner.RunScriptWithResult(ctx, getVmTemplate, getVmArgs{
@@ -223,41 +212,41 @@ func (c *ClientConfig) GetVm(ctx context.Context, name string) (result api.Vm, e
 }
 
 type updateVmArgs struct {
-	VmJson string
+	Vm api.Vm
 }
 
-var updateVmTemplate = template.Must(template.New("UpdateVm").Parse(`
+var updateVmTemplate = newScriptTemplate("UpdateVm", `
 $ErrorActionPreference = 'Stop'
 Import-Module Hyper-V
-$vm = '{{.VmJson}}' | ConvertFrom-Json
+$vm = $params.Vm
 $automaticCriticalErrorAction = [Microsoft.HyperV.PowerShell.CriticalErrorAction]$vm.AutomaticCriticalErrorAction
 $automaticStartAction = [Microsoft.HyperV.PowerShell.StartAction]$vm.AutomaticStartAction
 $automaticStopAction = [Microsoft.HyperV.PowerShell.StopAction]$vm.AutomaticStopAction
 $checkpointType = [Microsoft.HyperV.PowerShell.CheckpointType]$vm.CheckpointType
 $lockOnDisconnect = [Microsoft.HyperV.PowerShell.OnOffState]$vm.LockOnDisconnect
 $allowUnverifiedPaths = $true #Not a property set on the vm object, skips validation when changing path
-$vmObject = Get-VM -Name "$($vm.Name)*" | ?{$_.Name -eq $vm.Name}
+$vmObject = Get-VM | Where-Object { $_.Name -eq $vm.Name }
 
 if (!$vmObject){
-	throw "VM does not exist - $($vm.Name)"
+	Write-Error -Message "VM does not exist - $($vm.Name)" -Category ObjectNotFound -ErrorId ObjectNotFound -ErrorAction Stop
 }
 
 #Set static and dynamic properties can't be set at the same time, but we need the values to match terraforms state
 $SetVmArgs = @{}
-$SetVmArgs.Name=$vm.Name
+$SetVmArgs.VM=$vmObject
 $SetVmArgs.StaticMemory=$true
 $SetVmArgs.MemoryStartupBytes=$vm.MemoryStartupBytes
 Set-Vm @SetVmArgs
 
 $SetVmArgs = @{}
-$SetVmArgs.Name=$vm.Name
+$SetVmArgs.VM=$vmObject
 $SetVmArgs.DynamicMemory=$true
 $SetVmArgs.MemoryMinimumBytes=$vm.MemoryMinimumBytes
 $SetVmArgs.MemoryMaximumBytes=$vm.MemoryMaximumBytes
 Set-Vm @SetVmArgs
 
 $SetVmArgs = @{}
-$SetVmArgs.Name=$vm.Name
+$SetVmArgs.VM=$vmObject
 $SetVmArgs.GuestControlledCacheTypes=$vm.GuestControlledCacheTypes
 $SetVmArgs.LowMemoryMappedIoSpace=$vm.LowMemoryMappedIoSpace
 $SetVmArgs.HighMemoryMappedIoSpace=$vm.HighMemoryMappedIoSpace
@@ -280,7 +269,7 @@ if ($vm.StaticMemory) {
 }
 
 Set-Vm @SetVmArgs
-`))
+`)
 
 func (c *ClientConfig) UpdateVm(
 	ctx context.Context,
@@ -306,36 +295,30 @@ func (c *ClientConfig) UpdateVm(
 	snapshotFileLocation string,
 	staticMemory bool,
 ) (err error) {
-	vmJson, err := json.Marshal(api.Vm{
-		Name: name,
-		//Generation:generation,
-		AutomaticCriticalErrorAction:        automaticCriticalErrorAction,
-		AutomaticCriticalErrorActionTimeout: automaticCriticalErrorActionTimeout,
-		AutomaticStartAction:                automaticStartAction,
-		AutomaticStartDelay:                 automaticStartDelay,
-		AutomaticStopAction:                 automaticStopAction,
-		CheckpointType:                      checkpointType,
-		DynamicMemory:                       dynamicMemory,
-		GuestControlledCacheTypes:           guestControlledCacheTypes,
-		HighMemoryMappedIoSpace:             highMemoryMappedIoSpace,
-		LockOnDisconnect:                    lockOnDisconnect,
-		LowMemoryMappedIoSpace:              lowMemoryMappedIoSpace,
-		MemoryMaximumBytes:                  memoryMaximumBytes,
-		MemoryMinimumBytes:                  memoryMinimumBytes,
-		MemoryStartupBytes:                  memoryStartupBytes,
-		Notes:                               notes,
-		ProcessorCount:                      processorCount,
-		SmartPagingFilePath:                 smartPagingFilePath,
-		SnapshotFileLocation:                snapshotFileLocation,
-		StaticMemory:                        staticMemory,
-	})
-
-	if err != nil {
-		return err
-	}
-
 	err = c.ScriptRunner.RunFireAndForgetScript(ctx, updateVmTemplate, updateVmArgs{
-		VmJson: string(vmJson),
+		Vm: api.Vm{
+			Name: name,
+			//Generation:generation,
+			AutomaticCriticalErrorAction:        automaticCriticalErrorAction,
+			AutomaticCriticalErrorActionTimeout: automaticCriticalErrorActionTimeout,
+			AutomaticStartAction:                automaticStartAction,
+			AutomaticStartDelay:                 automaticStartDelay,
+			AutomaticStopAction:                 automaticStopAction,
+			CheckpointType:                      checkpointType,
+			DynamicMemory:                       dynamicMemory,
+			GuestControlledCacheTypes:           guestControlledCacheTypes,
+			HighMemoryMappedIoSpace:             highMemoryMappedIoSpace,
+			LockOnDisconnect:                    lockOnDisconnect,
+			LowMemoryMappedIoSpace:              lowMemoryMappedIoSpace,
+			MemoryMaximumBytes:                  memoryMaximumBytes,
+			MemoryMinimumBytes:                  memoryMinimumBytes,
+			MemoryStartupBytes:                  memoryStartupBytes,
+			Notes:                               notes,
+			ProcessorCount:                      processorCount,
+			SmartPagingFilePath:                 smartPagingFilePath,
+			SnapshotFileLocation:                snapshotFileLocation,
+			StaticMemory:                        staticMemory,
+		},
 	})
 
 	return err
@@ -345,10 +328,10 @@ type deleteVmArgs struct {
 	Name string
 }
 
-var deleteVmTemplate = template.Must(template.New("DeleteVm").Parse(`
+var deleteVmTemplate = newScriptTemplate("DeleteVm", `
 $ErrorActionPreference = 'Stop'
-Get-VM -Name '{{.Name}}*' | ?{$_.Name -eq '{{.Name}}'} | Remove-VM -force
-`))
+Get-VM | Where-Object { $_.Name -eq $params.Name } | Remove-VM -force
+`)
 
 func (c *ClientConfig) DeleteVm(ctx context.Context, name string) (err error) {
 	err = c.ScriptRunner.RunFireAndForgetScript(ctx, deleteVmTemplate, deleteVmArgs{
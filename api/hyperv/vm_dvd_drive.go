@@ -2,25 +2,29 @@ package hyperv
 
 import (
 	"context"
-	"encoding/json"
-	"text/template"
 
 	"github.com/taliesins/terraform-provider-hyperv/api"
 )
 
 type createVmDvdDriveArgs struct {
-	VmDvdDriveJson string
+	VmDvdDrive api.VmDvdDrive
 }
 
-var createVmDvdDriveTemplate = template.Must(template.New("CreateVmDvdDrive").Parse(`
+var createVmDvdDriveTemplate = newScriptTemplate("CreateVmDvdDrive", `
 $ErrorActionPreference = 'Stop'
 Import-Module Hyper-V
-$vmDvdDrive = '{{.VmDvdDriveJson}}' | ConvertFrom-Json
+$vmDvdDrive = $params.VmDvdDrive
 if (!$vmDvdDrive.Path){
 	$vmDvdDrive.Path = $null
 }
+
+$vmObject = Get-VM | Where-Object { $_.Name -eq $vmDvdDrive.VmName }
+if (!$vmObject){
+	Write-Error -Message "VM does not exist - $($vmDvdDrive.VmName)" -Category ObjectNotFound -ErrorId ObjectNotFound -ErrorAction Stop
+}
+
 $NewVmDvdDriveArgs = @{
-	VmName=$vmDvdDrive.VmName
+	VM=$vmObject
 	ControllerNumber=$vmDvdDrive.ControllerNumber
 	ControllerLocation=$vmDvdDrive.ControllerLocation
 	Path=$vmDvdDrive.Path
@@ -29,7 +33,7 @@ $NewVmDvdDriveArgs = @{
 }
 
 Add-VmDvdDrive @NewVmDvdDriveArgs
-`))
+`)
 
 func (c *ClientConfig) CreateVmDvdDrive(
 	ctx context.Context,
@@ -39,20 +43,14 @@ func (c *ClientConfig) CreateVmDvdDrive(
 	path string,
 	resourcePoolName string,
 ) (err error) {
-	vmDvdDriveJson, err := json.Marshal(api.VmDvdDrive{
-		VmName:             vmName,
-		ControllerNumber:   controllerNumber,
-		ControllerLocation: controllerLocation,
-		Path:               path,
-		ResourcePoolName:   resourcePoolName,
-	})
-
-	if err != nil {
-		return err
-	}
-
 	err = c.ScriptRunner.RunFireAndForgetScript(ctx, createVmDvdDriveTemplate, createVmDvdDriveArgs{
-		VmDvdDriveJson: string(vmDvdDriveJson),
+		VmDvdDrive: api.VmDvdDrive{
+			VmName:             vmName,
+			ControllerNumber:   controllerNumber,
+			ControllerLocation: controllerLocation,
+			Path:               path,
+			ResourcePoolName:   resourcePoolName,
+		},
 	})
 
 	return err
@@ -62,9 +60,9 @@ type getVmDvdDrivesArgs struct {
 	VmName string
 }
 
-var getVmDvdDrivesTemplate = template.Must(template.New("GetVmDvdDrives").Parse(`
+var getVmDvdDrivesTemplate = newIdempotentScriptTemplate("GetVmDvdDrives", `
 $ErrorActionPreference = 'Stop'
-$vmDvdDrivesObject = @(Get-VM -Name '{{.VmName}}*' | ?{$_.Name -eq '{{.VmName}}' } | Get-VMDvdDrive | %{ @{
+$vmDvdDrivesObject = @(Get-VM | Where-Object { $_.Name -eq $params.VmName } | Get-VMDvdDrive | %{ @{
 	ControllerNumber=$_.ControllerNumber;
 	ControllerLocation=$_.ControllerLocation;
 	Path=$_.Path;
@@ -74,12 +72,11 @@ $vmDvdDrivesObject = @(Get-VM -Name '{{.VmName}}*' | ?{$_.Name -eq '{{.VmName}}'
 }})
 
 if ($vmDvdDrivesObject) {
-	$vmDvdDrives = ConvertTo-Json -InputObject $vmDvdDrivesObject
-	$vmDvdDrives
+	Write-Result $vmDvdDrivesObject
 } else {
-	"[]"
+	Write-Result @()
 }
-`))
+`)
 
 func (c *ClientConfig) GetVmDvdDrives(ctx context.Context, vmName string) (result []api.VmDvdDrive, err error) {
 	result = make([]api.VmDvdDrive, 0)
@@ -95,24 +92,22 @@ type updateVmDvdDriveArgs struct {
 	VmName             string
 	ControllerNumber   int
 	ControllerLocation int
-	VmDvdDriveJson     string
+	VmDvdDrive         api.VmDvdDrive
 }
 
-var updateVmDvdDriveTemplate = template.Must(template.New("UpdateVmDvdDrive").Parse(`
+var updateVmDvdDriveTemplate = newScriptTemplate("UpdateVmDvdDrive", `
 $ErrorActionPreference = 'Stop'
 Import-Module Hyper-V
-$vmDvdDrive = '{{.VmDvdDriveJson}}' | ConvertFrom-Json
+$vmDvdDrive = $params.VmDvdDrive
 
-$vmDvdDrivesObject = @(Get-VM -Name '{{.VmName}}*' | ?{$_.Name -eq '{{.VmName}}' } | Get-VMDvdDrive -ControllerLocation {{.ControllerLocation}} -ControllerNumber {{.ControllerNumber}} )
+$vmDvdDrivesObject = @(Get-VM | Where-Object { $_.Name -eq $params.VmName } | Get-VMDvdDrive -ControllerLocation $params.ControllerLocation -ControllerNumber $params.ControllerNumber )
 
 if (!$vmDvdDrivesObject){
-	throw "VM dvd drive does not exist - {{.ControllerLocation}} {{.ControllerNumber}}"
+	Write-Error -Message "VM dvd drive does not exist - $($params.ControllerLocation) $($params.ControllerNumber)" -Category ObjectNotFound -ErrorId ObjectNotFound -ErrorAction Stop
 }
 
 $SetVmDvdDriveArgs = @{}
-$SetVmDvdDriveArgs.VmName=$vmDvdDrivesObject.VmName
-$SetVmDvdDriveArgs.ControllerLocation=$vmDvdDrivesObject.ControllerLocation
-$SetVmDvdDriveArgs.ControllerNumber=$vmDvdDrivesObject.ControllerNumber
+$SetVmDvdDriveArgs.VMDvdDrive=$vmDvdDrivesObject
 $SetVmDvdDriveArgs.ToControllerLocation=$vmDvdDrive.ControllerLocation
 $SetVmDvdDriveArgs.ToControllerNumber=$vmDvdDrive.ControllerNumber
 
@@ -144,7 +139,7 @@ if (!$SetVmDvdDriveArgs.Path){
 
 Set-VMDvdDrive @SetVmDvdDriveArgs
 
-`))
+`)
 
 func (c *ClientConfig) UpdateVmDvdDrive(
 	ctx context.Context,
@@ -156,23 +151,17 @@ func (c *ClientConfig) UpdateVmDvdDrive(
 	path string,
 	resourcePoolName string,
 ) (err error) {
-	vmDvdDriveJson, err := json.Marshal(api.VmDvdDrive{
-		VmName:             vmName,
-		ControllerNumber:   toControllerNumber,
-		ControllerLocation: toControllerLocation,
-		Path:               path,
-		ResourcePoolName:   resourcePoolName,
-	})
-
-	if err != nil {
-		return err
-	}
-
 	err = c.ScriptRunner.RunFireAndForgetScript(ctx, updateVmDvdDriveTemplate, updateVmDvdDriveArgs{
 		VmName:             vmName,
 		ControllerNumber:   controllerNumber,
 		ControllerLocation: controllerLocation,
-		VmDvdDriveJson:     string(vmDvdDriveJson),
+		VmDvdDrive: api.VmDvdDrive{
+			VmName:             vmName,
+			ControllerNumber:   toControllerNumber,
+			ControllerLocation: toControllerLocation,
+			Path:               path,
+			ResourcePoolName:   resourcePoolName,
+		},
 	})
 
 	return err
@@ -184,11 +173,11 @@ type deleteVmDvdDriveArgs struct {
 	ControllerLocation int
 }
 
-var deleteVmDvdDriveTemplate = template.Must(template.New("DeleteVmDvdDrive").Parse(`
+var deleteVmDvdDriveTemplate = newScriptTemplate("DeleteVmDvdDrive", `
 $ErrorActionPreference = 'Stop'
 
-@(Get-VM -Name '{{.VmName}}*' | ?{$_.Name -eq '{{.VmName}}' } | Get-VMDvdDrive -ControllerNumber {{.ControllerNumber}} -ControllerLocation {{.ControllerLocation}}) | Remove-VMDvdDrive
-`))
+@(Get-VM | Where-Object { $_.Name -eq $params.VmName } | Get-VMDvdDrive -ControllerNumber $params.ControllerNumber -ControllerLocation $params.ControllerLocation) | Remove-VMDvdDrive
+`)
 
 func (c *ClientConfig) DeleteVmDvdDrive(ctx context.Context, vmName string, controllerNumber int, controllerLocation int) (err error) {
 	err = c.ScriptRunner.RunFireAndForgetScript(ctx, deleteVmDvdDriveTemplate, deleteVmDvdDriveArgs{
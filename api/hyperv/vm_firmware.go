@@ -2,26 +2,29 @@ package hyperv
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"text/template"
 
 	"github.com/taliesins/terraform-provider-hyperv/api"
 )
 
 type createOrUpdateVmFirmwareArgs struct {
-	VmFirmwareJson string
+	VmFirmware api.VmFirmware
 }
 
-var createOrUpdateVmFirmwareTemplate = template.Must(template.New("CreateOrUpdateVmFirmware").Parse(`
+var createOrUpdateVmFirmwareTemplate = newScriptTemplate("CreateOrUpdateVmFirmware", `
 $ErrorActionPreference = 'Stop'
 Import-Module Hyper-V
-$vmFirmware = '{{.VmFirmwareJson}}' | ConvertFrom-Json
+$vmFirmware = $params.VmFirmware
+
+$vmObject = Get-VM | Where-Object { $_.Name -eq $vmFirmware.VmName }
+if (!$vmObject){
+	Write-Error -Message "VM does not exist - $($vmFirmware.VmName)" -Category ObjectNotFound -ErrorId ObjectNotFound -ErrorAction Stop
+}
 
 $bootOrders = @($vmFirmware.BootOrders | %{
 	$bootOrder = $_
 	if ($bootOrder.Type -eq 'NetworkAdapter') {
-		$networkAdapter = Get-VM -Name "$($vmFirmware.VmName)*" | ?{$_.Name -eq $vmFirmware.VmName } | Get-VMNetworkAdapter
+		$networkAdapter = $vmObject | Get-VMNetworkAdapter
 		if ($bootOrder.NetworkAdapterName) {
 			$networkAdapter = $networkAdapter | ?{$_.Name -eq $bootOrder.NetworkAdapterName}
 		}
@@ -36,7 +39,7 @@ $bootOrders = @($vmFirmware.BootOrders | %{
 
 		$networkAdapter
 	} elseif ($bootOrder.Type -eq 'HardDiskDrive') {
-		$hardDiskDrive = Get-VM -Name "$($vmFirmware.VmName)*" | ?{$_.Name -eq $vmFirmware.VmName } | Get-VMHardDiskDrive
+		$hardDiskDrive = $vmObject | Get-VMHardDiskDrive
 
 		if ($bootOrder.ControllerNumber -gt -1) {
 			$hardDiskDrive = $hardDiskDrive | ?{$_.ControllerNumber -eq $bootOrder.ControllerNumber}
@@ -53,7 +56,7 @@ $bootOrders = @($vmFirmware.BootOrders | %{
 		$hardDiskDrive | Select-Object -First 1
 
 	} elseif ($bootOrder.Type -eq 'DvdDrive') {
-		$dvdDrive = Get-VM -Name "$($vmFirmware.VmName)*" | ?{$_.Name -eq $vmFirmware.VmName } | Get-VMDvdDrive
+		$dvdDrive = $vmObject | Get-VMDvdDrive
 
 		if ($bootOrder.ControllerNumber -gt -1) {
 			$dvdDrive = $dvdDrive | ?{$_.ControllerNumber -eq $bootOrder.ControllerNumber}
@@ -72,7 +75,7 @@ $bootOrders = @($vmFirmware.BootOrders | %{
 } | Where-Object { $_ -ne $null })
 
 $SetVMFirmwareArgs = @{}
-$SetVMFirmwareArgs.VMName=$vmFirmware.VmName
+$SetVMFirmwareArgs.VM=$vmObject
 $SetVMFirmwareArgs.BootOrder=$bootOrders
 $SetVMFirmwareArgs.EnableSecureBoot=$vmFirmware.EnableSecureBoot
 $SetVMFirmwareArgs.SecureBootTemplate=$vmFirmware.SecureBootTemplate
@@ -81,7 +84,7 @@ $SetVMFirmwareArgs.ConsoleMode=$vmFirmware.ConsoleMode
 $SetVMFirmwareArgs.PauseAfterBootFailure=$vmFirmware.PauseAfterBootFailure
 
 Set-VMFirmware @SetVMFirmwareArgs
-`))
+`)
 
 func (c *ClientConfig) CreateOrUpdateVmFirmware(
 	ctx context.Context,
@@ -93,22 +96,16 @@ func (c *ClientConfig) CreateOrUpdateVmFirmware(
 	consoleMode api.ConsoleModeType,
 	pauseAfterBootFailure api.OnOffState,
 ) (err error) {
-	vmFirmwareJson, err := json.Marshal(api.VmFirmware{
-		VmName:                       vmName,
-		BootOrders:                   bootOrders,
-		EnableSecureBoot:             enableSecureBoot,
-		SecureBootTemplate:           secureBootTemplate,
-		PreferredNetworkBootProtocol: preferredNetworkBootProtocol,
-		ConsoleMode:                  consoleMode,
-		PauseAfterBootFailure:        pauseAfterBootFailure,
-	})
-
-	if err != nil {
-		return err
-	}
-
 	err = c.ScriptRunner.RunFireAndForgetScript(ctx, createOrUpdateVmFirmwareTemplate, createOrUpdateVmFirmwareArgs{
-		VmFirmwareJson: string(vmFirmwareJson),
+		VmFirmware: api.VmFirmware{
+			VmName:                       vmName,
+			BootOrders:                   bootOrders,
+			EnableSecureBoot:             enableSecureBoot,
+			SecureBootTemplate:           secureBootTemplate,
+			PreferredNetworkBootProtocol: preferredNetworkBootProtocol,
+			ConsoleMode:                  consoleMode,
+			PauseAfterBootFailure:        pauseAfterBootFailure,
+		},
 	})
 
 	return err
@@ -118,10 +115,10 @@ type getVmFirmwareArgs struct {
 	VmName string
 }
 
-var getVmFirmwareTemplate = template.Must(template.New("GetVmFirmware").Parse(`
+var getVmFirmwareTemplate = newIdempotentScriptTemplate("GetVmFirmware", `
 $ErrorActionPreference = 'Stop'
 
-$vmFirmwareObject = Get-VM -Name '{{.VmName}}*' | ?{$_.Name -eq '{{.VmName}}' } | Get-VMFirmware | %{ @{
+$vmFirmwareObject = Get-VM | Where-Object { $_.Name -eq $params.VmName } | Get-VMFirmware | %{ @{
 	BootOrders= @($_.BootOrder | %{
 		if ($_.BootType -eq 'Network') {
 			@{Type='NetworkAdapter';NetworkAdapterName=$_.Device.Name;SwitchName=$_.Device.SwitchName;MacAddress=$_.Device.MacAddress;Path='';ControllerNumber=-1;ControllerLocation=-1;}
@@ -138,12 +135,11 @@ $vmFirmwareObject = Get-VM -Name '{{.VmName}}*' | ?{$_.Name -eq '{{.VmName}}' }
 }}
 
 if ($vmFirmwareObject) {
-	$vmFirmware = ConvertTo-Json -InputObject $vmFirmwareObject
-	$vmFirmware
+	Write-Result $vmFirmwareObject
 } else {
-	"{}"
+	Write-Result @{}
 }
-`))
+`)
 
 func (c *ClientConfig) GetVmFirmware(ctx context.Context, vmName string) (result api.VmFirmware, err error) {
 	err = c.ScriptRunner.RunScriptWithResult(ctx, getVmFirmwareTemplate, getVmFirmwareArgs{
@@ -2,23 +2,26 @@ package hyperv
 
 import (
 	"context"
-	"encoding/json"
-	"text/template"
 
 	"github.com/taliesins/terraform-provider-hyperv/api"
 )
 
 type createVmHardDiskDriveArgs struct {
-	VmHardDiskDriveJson string
+	VmHardDiskDrive api.VmHardDiskDrive
 }
 
-var createVmHardDiskDriveTemplate = template.Must(template.New("CreateVmHardDiskDrive").Parse(`
+var createVmHardDiskDriveTemplate = newScriptTemplate("CreateVmHardDiskDrive", `
 $ErrorActionPreference = 'Stop'
 Import-Module Hyper-V
-$vmHardDiskDrive = '{{.VmHardDiskDriveJson}}' | ConvertFrom-Json
+$vmHardDiskDrive = $params.VmHardDiskDrive
+
+$vmObject = Get-VM | Where-Object { $_.Name -eq $vmHardDiskDrive.VmName }
+if (!$vmObject){
+	Write-Error -Message "VM does not exist - $($vmHardDiskDrive.VmName)" -Category ObjectNotFound -ErrorId ObjectNotFound -ErrorAction Stop
+}
 
 $NewVmHardDiskDriveArgs = @{
-	VmName=$vmHardDiskDrive.VmName
+	VM=$vmObject
 	ControllerType=$vmHardDiskDrive.ControllerType
 	ControllerNumber=$vmHardDiskDrive.ControllerNumber
 	ControllerLocation=$vmHardDiskDrive.ControllerLocation
@@ -37,7 +40,7 @@ if ($vmHardDiskDrive.DiskNumber -lt 4294967295){
 }
 
 Add-VmHardDiskDrive @NewVmHardDiskDriveArgs
-`))
+`)
 
 func (c *ClientConfig) CreateVmHardDiskDrive(
 	ctx context.Context,
@@ -55,27 +58,21 @@ func (c *ClientConfig) CreateVmHardDiskDrive(
 	overrideCacheAttributes api.CacheAttributes,
 
 ) (err error) {
-	vmHardDiskDriveJson, err := json.Marshal(api.VmHardDiskDrive{
-		VmName:                        vmName,
-		ControllerType:                controllerType,
-		ControllerNumber:              controllerNumber,
-		ControllerLocation:            controllerLocation,
-		Path:                          path,
-		DiskNumber:                    diskNumber,
-		ResourcePoolName:              resourcePoolName,
-		SupportPersistentReservations: supportPersistentReservations,
-		MaximumIops:                   maximumIops,
-		MinimumIops:                   minimumIops,
-		QosPolicyId:                   qosPolicyId,
-		OverrideCacheAttributes:       overrideCacheAttributes,
-	})
-
-	if err != nil {
-		return err
-	}
-
 	err = c.ScriptRunner.RunFireAndForgetScript(ctx, createVmHardDiskDriveTemplate, createVmHardDiskDriveArgs{
-		VmHardDiskDriveJson: string(vmHardDiskDriveJson),
+		VmHardDiskDrive: api.VmHardDiskDrive{
+			VmName:                        vmName,
+			ControllerType:                controllerType,
+			ControllerNumber:              controllerNumber,
+			ControllerLocation:            controllerLocation,
+			Path:                          path,
+			DiskNumber:                    diskNumber,
+			ResourcePoolName:              resourcePoolName,
+			SupportPersistentReservations: supportPersistentReservations,
+			MaximumIops:                   maximumIops,
+			MinimumIops:                   minimumIops,
+			QosPolicyId:                   qosPolicyId,
+			OverrideCacheAttributes:       overrideCacheAttributes,
+		},
 	})
 
 	return err
@@ -85,9 +82,9 @@ type getVmHardDiskDrivesArgs struct {
 	VmName string
 }
 
-var getVmHardDiskDrivesTemplate = template.Must(template.New("GetVmHardDiskDrives").Parse(`
+var getVmHardDiskDrivesTemplate = newIdempotentScriptTemplate("GetVmHardDiskDrives", `
 $ErrorActionPreference = 'Stop'
-$vmHardDiskDrivesObject = @(Get-VM -Name '{{.VmName}}*' | ?{$_.Name -eq '{{.VmName}}' } | Get-VMHardDiskDrive | %{ @{
+$vmHardDiskDrivesObject = @(Get-VM | Where-Object { $_.Name -eq $params.VmName } | Get-VMHardDiskDrive | %{ @{
 	ControllerType=$_.ControllerType;
 	ControllerNumber=$_.ControllerNumber;
 	ControllerLocation=$_.ControllerLocation;
@@ -102,12 +99,11 @@ $vmHardDiskDrivesObject = @(Get-VM -Name '{{.VmName}}*' | ?{$_.Name -eq '{{.VmNa
 }})
 
 if ($vmHardDiskDrivesObject) {
-	$vmHardDiskDrives = ConvertTo-Json -InputObject $vmHardDiskDrivesObject
-	$vmHardDiskDrives
+	Write-Result $vmHardDiskDrivesObject
 } else {
-	"[]"
+	Write-Result @()
 }
-`))
+`)
 
 func (c *ClientConfig) GetVmHardDiskDrives(ctx context.Context, vmName string) (result []api.VmHardDiskDrive, err error) {
 	result = make([]api.VmHardDiskDrive, 0)
@@ -120,29 +116,27 @@ func (c *ClientConfig) GetVmHardDiskDrives(ctx context.Context, vmName string) (
 }
 
 type updateVmHardDiskDriveArgs struct {
-	VmName              string
-	ControllerNumber    int32
-	ControllerLocation  int32
-	ControllerType      api.ControllerType
-	VmHardDiskDriveJson string
+	VmName             string
+	ControllerNumber   int32
+	ControllerLocation int32
+	ControllerType     api.ControllerType
+	VmHardDiskDrive    api.VmHardDiskDrive
 }
 
-var updateVmHardDiskDriveTemplate = template.Must(template.New("UpdateVmHardDiskDrive").Parse(`
+var updateVmHardDiskDriveTemplate = newScriptTemplate("UpdateVmHardDiskDrive", `
 $ErrorActionPreference = 'Stop'
 Import-Module Hyper-V
-$vmHardDiskDrive = '{{.VmHardDiskDriveJson}}' | ConvertFrom-Json
+$vmHardDiskDrive = $params.VmHardDiskDrive
+$controllerType = [Microsoft.HyperV.PowerShell.ControllerType]$params.ControllerType
 
-$vmHardDiskDrivesObject = @(Get-VM -Name '{{.VmName}}*' | ?{$_.Name -eq '{{.VmName}}' } | Get-VMHardDiskDrive -ControllerLocation {{.ControllerLocation}} -ControllerNumber {{.ControllerNumber}} -ControllerType {{.ControllerType}})
+$vmHardDiskDrivesObject = @(Get-VM | Where-Object { $_.Name -eq $params.VmName } | Get-VMHardDiskDrive -ControllerLocation $params.ControllerLocation -ControllerNumber $params.ControllerNumber -ControllerType $controllerType)
 
 if (!$vmHardDiskDrivesObject){
-	throw "VM hard disk drive does not exist - {{.ControllerLocation}} {{.ControllerNumber}} {{.ControllerType}}"
+	Write-Error -Message "VM hard disk drive does not exist - $($params.ControllerLocation) $($params.ControllerNumber) $($controllerType)" -Category ObjectNotFound -ErrorId ObjectNotFound -ErrorAction Stop
 }
 
 $SetVmHardDiskDriveArgs = @{}
-$SetVmHardDiskDriveArgs.VmName=$vmHardDiskDrivesObject.VmName
-$SetVmHardDiskDriveArgs.ControllerType=$vmHardDiskDrivesObject.ControllerType
-$SetVmHardDiskDriveArgs.ControllerLocation=$vmHardDiskDrivesObject.ControllerLocation
-$SetVmHardDiskDriveArgs.ControllerNumber=$vmHardDiskDrivesObject.ControllerNumber
+$SetVmHardDiskDriveArgs.VMHardDiskDrive=$vmHardDiskDrivesObject
 $SetVmHardDiskDriveArgs.ToControllerLocation=$vmHardDiskDrive.ControllerLocation
 $SetVmHardDiskDriveArgs.ToControllerNumber=$vmHardDiskDrive.ControllerNumber
 $SetVmHardDiskDriveArgs.Path=$vmHardDiskDrive.Path
@@ -165,7 +159,7 @@ $SetVmHardDiskDriveArgs.AllowUnverifiedPaths=$true
 
 Set-VMHardDiskDrive @SetVmHardDiskDriveArgs
 
-`))
+`)
 
 func (c *ClientConfig) UpdateVmHardDiskDrive(
 	ctx context.Context,
@@ -184,31 +178,25 @@ func (c *ClientConfig) UpdateVmHardDiskDrive(
 	qosPolicyId string,
 	overrideCacheAttributes api.CacheAttributes,
 ) (err error) {
-	vmHardDiskDriveJson, err := json.Marshal(api.VmHardDiskDrive{
-		VmName:                        vmName,
-		ControllerType:                controllerType,
-		ControllerNumber:              toControllerNumber,
-		ControllerLocation:            toControllerLocation,
-		Path:                          path,
-		DiskNumber:                    diskNumber,
-		ResourcePoolName:              resourcePoolName,
-		SupportPersistentReservations: supportPersistentReservations,
-		MaximumIops:                   maximumIops,
-		MinimumIops:                   minimumIops,
-		QosPolicyId:                   qosPolicyId,
-		OverrideCacheAttributes:       overrideCacheAttributes,
-	})
-
-	if err != nil {
-		return err
-	}
-
 	err = c.ScriptRunner.RunFireAndForgetScript(ctx, updateVmHardDiskDriveTemplate, updateVmHardDiskDriveArgs{
-		VmName:              vmName,
-		ControllerNumber:    controllerNumber,
-		ControllerLocation:  controllerLocation,
-		ControllerType:      controllerType,
-		VmHardDiskDriveJson: string(vmHardDiskDriveJson),
+		VmName:             vmName,
+		ControllerNumber:   controllerNumber,
+		ControllerLocation: controllerLocation,
+		ControllerType:     controllerType,
+		VmHardDiskDrive: api.VmHardDiskDrive{
+			VmName:                        vmName,
+			ControllerType:                controllerType,
+			ControllerNumber:              toControllerNumber,
+			ControllerLocation:            toControllerLocation,
+			Path:                          path,
+			DiskNumber:                    diskNumber,
+			ResourcePoolName:              resourcePoolName,
+			SupportPersistentReservations: supportPersistentReservations,
+			MaximumIops:                   maximumIops,
+			MinimumIops:                   minimumIops,
+			QosPolicyId:                   qosPolicyId,
+			OverrideCacheAttributes:       overrideCacheAttributes,
+		},
 	})
 
 	return err
@@ -221,11 +209,13 @@ type deleteVmHardDiskDriveArgs struct {
 	ControllerType     api.ControllerType
 }
 
-var deleteVmHardDiskDriveTemplate = template.Must(template.New("DeleteVmHardDiskDrive").Parse(`
+var deleteVmHardDiskDriveTemplate = newScriptTemplate("DeleteVmHardDiskDrive", `
 $ErrorActionPreference = 'Stop'
 
-@(Get-VMHardDiskDrive -VmName '{{.VmName}}' -ControllerNumber {{.ControllerNumber}} -ControllerLocation {{.ControllerLocation}} -ControllerType {{.ControllerType}}) | Remove-VMHardDiskDrive
-`))
+$controllerType = [Microsoft.HyperV.PowerShell.ControllerType]$params.ControllerType
+
+@(Get-VM | Where-Object { $_.Name -eq $params.VmName } | Get-VMHardDiskDrive -ControllerNumber $params.ControllerNumber -ControllerLocation $params.ControllerLocation -ControllerType $controllerType) | Remove-VMHardDiskDrive
+`)
 
 func (c *ClientConfig) DeleteVmHardDiskDrive(ctx context.Context, vmname string, controllerNumber int32, controllerLocation int32, controllerType api.ControllerType) (err error) {
 	err = c.ScriptRunner.RunFireAndForgetScript(ctx, deleteVmHardDiskDriveTemplate, deleteVmHardDiskDriveArgs{
@@ -2,7 +2,6 @@ package hyperv
 
 import (
 	"context"
-	"text/template"
 
 	"github.com/taliesins/terraform-provider-hyperv/api"
 )
@@ -11,20 +10,19 @@ type getVmIntegrationServicesArgs struct {
 	VmName string
 }
 
-var getVmIntegrationServicesTemplate = template.Must(template.New("GetVmIntegrationServices").Parse(`
+var getVmIntegrationServicesTemplate = newIdempotentScriptTemplate("GetVmIntegrationServices", `
 $ErrorActionPreference = 'Stop'
-$vmIntegrationServicesObject = @(Get-VM -Name '{{.VmName}}*' | ?{$_.Name -eq '{{.VmName}}' } | Get-VMIntegrationService | %{ @{
+$vmIntegrationServicesObject = @(Get-VM | Where-Object { $_.Name -eq $params.VmName } | Get-VMIntegrationService | %{ @{
 	Name=$_.Name;
 	Enabled=$_.Enabled;
 }})
 
 if ($vmIntegrationServicesObject) {
-	$vmIntegrationServices = ConvertTo-Json -InputObject $vmIntegrationServicesObject
-	$vmIntegrationServices
+	Write-Result $vmIntegrationServicesObject
 } else {
-	"[]"
+	Write-Result @()
 }
-`))
+`)
 
 func (c *ClientConfig) GetVmIntegrationServices(ctx context.Context, vmName string) (result []api.VmIntegrationService, err error) {
 	err = c.ScriptRunner.RunScriptWithResult(ctx, getVmIntegrationServicesTemplate, getVmIntegrationServicesArgs{
@@ -39,11 +37,17 @@ type enableVmIntegrationServiceArgs struct {
 	Name   string
 }
 
-var enableVmIntegrationServiceTemplate = template.Must(template.New("EnableVmIntegrationService").Parse(`
+var enableVmIntegrationServiceTemplate = newScriptTemplate("EnableVmIntegrationService", `
 $ErrorActionPreference = 'Stop'
 
-Get-VM -Name '{{.VmName}}*' | ?{$_.Name -eq '{{.VmName}}' } | Enable-VMIntegrationService -Name '{{.Name}}'
-`))
+$vmIntegrationService = Get-VM | Where-Object { $_.Name -eq $params.VmName } | Get-VMIntegrationService | Where-Object { $_.Name -eq $params.Name }
+
+if (!$vmIntegrationService){
+	Write-Error -Message "VM integration service does not exist - $($params.Name)" -Category ObjectNotFound -ErrorId ObjectNotFound -ErrorAction Stop
+}
+
+$vmIntegrationService | Enable-VMIntegrationService
+`)
 
 func (c *ClientConfig) EnableVmIntegrationService(ctx context.Context, vmName string, name string) (err error) {
 	err = c.ScriptRunner.RunFireAndForgetScript(ctx, enableVmIntegrationServiceTemplate, enableVmIntegrationServiceArgs{
@@ -59,11 +63,17 @@ type disableVmIntegrationServiceArgs struct {
 	Name   string
 }
 
-var disableVmIntegrationServiceTemplate = template.Must(template.New("DisableVmIntegrationService").Parse(`
+var disableVmIntegrationServiceTemplate = newScriptTemplate("DisableVmIntegrationService", `
 $ErrorActionPreference = 'Stop'
 
-Get-VM -Name '{{.VmName}}*' | ?{$_.Name -eq '{{.VmName}}' } | Disable-VMIntegrationService -Name '{{.Name}}'
-`))
+$vmIntegrationService = Get-VM | Where-Object { $_.Name -eq $params.VmName } | Get-VMIntegrationService | Where-Object { $_.Name -eq $params.Name }
+
+if (!$vmIntegrationService){
+	Write-Error -Message "VM integration service does not exist - $($params.Name)" -Category ObjectNotFound -ErrorId ObjectNotFound -ErrorAction Stop
+}
+
+$vmIntegrationService | Disable-VMIntegrationService
+`)
 
 func (c *ClientConfig) DisableVmIntegrationService(ctx context.Context, vmName string, name string) (err error) {
 	err = c.ScriptRunner.RunFireAndForgetScript(ctx, disableVmIntegrationServiceTemplate, disableVmIntegrationServiceArgs{
@@ -2,20 +2,18 @@ package hyperv
 
 import (
 	"context"
-	"encoding/json"
-	"text/template"
 
 	"github.com/taliesins/terraform-provider-hyperv/api"
 )
 
 type createVmNetworkAdapterArgs struct {
-	VmNetworkAdapterJson string
+	VmNetworkAdapter api.VmNetworkAdapter
 }
 
-var createVmNetworkAdapterTemplate = template.Must(template.New("CreateVmNetworkAdapter").Parse(`
+var createVmNetworkAdapterTemplate = newScriptTemplate("CreateVmNetworkAdapter", `
 $ErrorActionPreference = 'Stop'
 Import-Module Hyper-V
-$vmNetworkAdapter = '{{.VmNetworkAdapterJson}}' | ConvertFrom-Json
+$vmNetworkAdapter = $params.VmNetworkAdapter
 
 $dhcpGuard = [Microsoft.HyperV.PowerShell.OnOffState]$vmNetworkAdapter.DhcpGuard
 $routerGuard = [Microsoft.HyperV.PowerShell.OnOffState]$vmNetworkAdapter.RouterGuard
@@ -27,27 +25,36 @@ $deviceNaming = [Microsoft.HyperV.PowerShell.OnOffState]$vmNetworkAdapter.Device
 $fixSpeed10G = [Microsoft.HyperV.PowerShell.OnOffState]$vmNetworkAdapter.FixSpeed10G
 $macAddressSpoofing = [Microsoft.HyperV.PowerShell.OnOffState]$vmNetworkAdapter.MacAddressSpoofing
 
+$vmObject = Get-VM | Where-Object { $_.Name -eq $vmNetworkAdapter.VmName }
+if (!$vmObject){
+	Write-Error -Message "VM does not exist - $($vmNetworkAdapter.VmName)" -Category ObjectNotFound -ErrorId ObjectNotFound -ErrorAction Stop
+}
+
+$minimumBandwidthMode = [Microsoft.HyperV.PowerShell.VMSwitchBandwidthMode]::None
+$vmSwitch = $null
+if ($vmNetworkAdapter.SwitchName) {
+	$vmSwitch = Get-VMSwitch | Where-Object { $_.Name -eq $vmNetworkAdapter.SwitchName }
+	if (!$vmSwitch){
+		Write-Error -Message "Switch does not exist - $($vmNetworkAdapter.SwitchName)" -Category ObjectNotFound -ErrorId ObjectNotFound -ErrorAction Stop
+	}
+	$minimumBandwidthMode = $vmSwitch.BandwidthReservationMode
+}
+
 $NewVmNetworkAdapterArgs = @{
-	VmName=$vmNetworkAdapter.VmName
+	VM=$vmObject
 	Name=$vmNetworkAdapter.Name
 	IsLegacy=$vmNetworkAdapter.IsLegacy
-	SwitchName=$vmNetworkAdapter.SwitchName
+	Passthru=$true
 }
 
-Add-VmNetworkAdapter @NewVmNetworkAdapterArgs
-
-$minimumBandwidthMode = [Microsoft.HyperV.PowerShell.VMSwitchBandwidthMode]::None
+$vmNetworkAdaptersObject = Add-VmNetworkAdapter @NewVmNetworkAdapterArgs
 
-if ($vmNetworkAdapter.SwitchName) {
-	$vmSwitch = Get-VMSwitch -Name $vmNetworkAdapter.SwitchName
-	if ($vmSwitch) {
-		$minimumBandwidthMode = $vmSwitch.BandwidthReservationMode
-	}
+if ($vmSwitch) {
+	Connect-VMNetworkAdapter -VMNetworkAdapter $vmNetworkAdaptersObject -VMSwitch $vmSwitch
 }
 
 $SetVmNetworkAdapterArgs = @{}
-$SetVmNetworkAdapterArgs.VmName=$vmNetworkAdapter.VmName
-$SetVmNetworkAdapterArgs.Name=$vmNetworkAdapter.Name
+$SetVmNetworkAdapterArgs.VMNetworkAdapter=$vmNetworkAdaptersObject
 if ($vmNetworkAdapter.DynamicMacAddress) {
 	$SetVmNetworkAdapterArgs.DynamicMacAddress=$vmNetworkAdapter.DynamicMacAddress
 } elseif ($vmNetworkAdapter.StaticMacAddress) {
@@ -95,15 +102,14 @@ Set-VmNetworkAdapter @SetVmNetworkAdapterArgs
 if ($vmNetworkAdapter.VlanAccess -and $vmNetworkAdapter.VlanId) {
 	$SetVmNetworkAdapterVlanArgs = @{}
 
-	$SetVmNetworkAdapterVlanArgs.VMName  = $vmNetworkAdapter.VmName
-	$SetVmNetworkAdapterVlanArgs.VMNetworkAdapterName   = $vmNetworkAdapter.Name
+	$SetVmNetworkAdapterVlanArgs.VMNetworkAdapter = $vmNetworkAdaptersObject
 	$SetVmNetworkAdapterVlanArgs.Access = $true
 	$SetVmNetworkAdapterVlanArgs.VlanId = $vmNetworkAdapter.VlanId
 
 	Set-VmNetworkAdapterVlan @SetVmNetworkAdapterVlanArgs
 }
 
-`))
+`)
 
 func (c *ClientConfig) CreateVmNetworkAdapter(
 	ctx context.Context,
@@ -147,54 +153,48 @@ func (c *ClientConfig) CreateVmNetworkAdapter(
 	vlanAccess bool,
 	vlanId int,
 ) (err error) {
-	vmNetworkAdapterJson, err := json.Marshal(api.VmNetworkAdapter{
-		VmName:                                 vmName,
-		Name:                                   name,
-		SwitchName:                             switchName,
-		ManagementOs:                           managementOs,
-		IsLegacy:                               isLegacy,
-		DynamicMacAddress:                      dynamicMacAddress,
-		StaticMacAddress:                       staticMacAddress,
-		MacAddressSpoofing:                     macAddressSpoofing,
-		DhcpGuard:                              dhcpGuard,
-		RouterGuard:                            routerGuard,
-		PortMirroring:                          portMirroring,
-		IeeePriorityTag:                        ieeePriorityTag,
-		VmqWeight:                              vmqWeight,
-		IovQueuePairsRequested:                 iovQueuePairsRequested,
-		IovInterruptModeration:                 iovInterruptModeration,
-		IovWeight:                              iovWeight,
-		IpsecOffloadMaximumSecurityAssociation: ipsecOffloadMaximumSecurityAssociation,
-		MaximumBandwidth:                       maximumBandwidth,
-		MinimumBandwidthAbsolute:               minimumBandwidthAbsolute,
-		MinimumBandwidthWeight:                 minimumBandwidthWeight,
-		MandatoryFeatureId:                     mandatoryFeatureId,
-		ResourcePoolName:                       resourcePoolName,
-		TestReplicaPoolName:                    testReplicaPoolName,
-		TestReplicaSwitchName:                  testReplicaSwitchName,
-		VirtualSubnetId:                        virtualSubnetId,
-		AllowTeaming:                           allowTeaming,
-		NotMonitoredInCluster:                  notMonitoredInCluster,
-		StormLimit:                             stormLimit,
-		DynamicIpAddressLimit:                  dynamicIpAddressLimit,
-		DeviceNaming:                           deviceNaming,
-		FixSpeed10G:                            fixSpeed10G,
-		PacketDirectNumProcs:                   packetDirectNumProcs,
-		PacketDirectModerationCount:            packetDirectModerationCount,
-		PacketDirectModerationInterval:         packetDirectModerationInterval,
-		VrssEnabled:                            vrssEnabled,
-		VmmqEnabled:                            vmmqEnabled,
-		VmmqQueuePairs:                         vmmqQueuePairs,
-		VlanAccess:                             vlanAccess,
-		VlanId:                                 vlanId,
-	})
-
-	if err != nil {
-		return err
-	}
-
 	err = c.ScriptRunner.RunFireAndForgetScript(ctx, createVmNetworkAdapterTemplate, createVmNetworkAdapterArgs{
-		VmNetworkAdapterJson: string(vmNetworkAdapterJson),
+		VmNetworkAdapter: api.VmNetworkAdapter{
+			VmName:                                 vmName,
+			Name:                                   name,
+			SwitchName:                             switchName,
+			ManagementOs:                           managementOs,
+			IsLegacy:                               isLegacy,
+			DynamicMacAddress:                      dynamicMacAddress,
+			StaticMacAddress:                       staticMacAddress,
+			MacAddressSpoofing:                     macAddressSpoofing,
+			DhcpGuard:                              dhcpGuard,
+			RouterGuard:                            routerGuard,
+			PortMirroring:                          portMirroring,
+			IeeePriorityTag:                        ieeePriorityTag,
+			VmqWeight:                              vmqWeight,
+			IovQueuePairsRequested:                 iovQueuePairsRequested,
+			IovInterruptModeration:                 iovInterruptModeration,
+			IovWeight:                              iovWeight,
+			IpsecOffloadMaximumSecurityAssociation: ipsecOffloadMaximumSecurityAssociation,
+			MaximumBandwidth:                       maximumBandwidth,
+			MinimumBandwidthAbsolute:               minimumBandwidthAbsolute,
+			MinimumBandwidthWeight:                 minimumBandwidthWeight,
+			MandatoryFeatureId:                     mandatoryFeatureId,
+			ResourcePoolName:                       resourcePoolName,
+			TestReplicaPoolName:                    testReplicaPoolName,
+			TestReplicaSwitchName:                  testReplicaSwitchName,
+			VirtualSubnetId:                        virtualSubnetId,
+			AllowTeaming:                           allowTeaming,
+			NotMonitoredInCluster:                  notMonitoredInCluster,
+			StormLimit:                             stormLimit,
+			DynamicIpAddressLimit:                  dynamicIpAddressLimit,
+			DeviceNaming:                           deviceNaming,
+			FixSpeed10G:                            fixSpeed10G,
+			PacketDirectNumProcs:                   packetDirectNumProcs,
+			PacketDirectModerationCount:            packetDirectModerationCount,
+			PacketDirectModerationInterval:         packetDirectModerationInterval,
+			VrssEnabled:                            vrssEnabled,
+			VmmqEnabled:                            vmmqEnabled,
+			VmmqQueuePairs:                         vmmqQueuePairs,
+			VlanAccess:                             vlanAccess,
+			VlanId:                                 vlanId,
+		},
 	})
 
 	return err
@@ -204,14 +204,14 @@ type getVmNetworkAdaptersArgs struct {
 	VmName string
 }
 
-var getVmNetworkAdaptersTemplate = template.Must(template.New("GetVmNetworkAdapters").Parse(`
+var getVmNetworkAdaptersTemplate = newIdempotentScriptTemplate("GetVmNetworkAdapters", `
 $ErrorActionPreference = 'Stop'
 #First 3 requests fails to get ip address
-Get-VMNetworkAdapter -VmName '{{.VmName}}' | Out-Null
-Get-VMNetworkAdapter -VmName '{{.VmName}}' | Out-Null
-Get-VMNetworkAdapter -VmName '{{.VmName}}' | Out-Null
+Get-VM | Where-Object { $_.Name -eq $params.VmName } | Get-VMNetworkAdapter | Out-Null
+Get-VM | Where-Object { $_.Name -eq $params.VmName } | Get-VMNetworkAdapter | Out-Null
+Get-VM | Where-Object { $_.Name -eq $params.VmName } | Get-VMNetworkAdapter | Out-Null
 
-$vmNetworkAdaptersObject = @(Get-VM -Name '{{.VmName}}*' | ?{$_.Name -eq '{{.VmName}}' } | Get-VMNetworkAdapter | %{ @{
+$vmNetworkAdaptersObject = @(Get-VM | Where-Object { $_.Name -eq $params.VmName } | Get-VMNetworkAdapter | %{ @{
      Name=$_.Name;
      SwitchName=$_.SwitchName;
      ManagementOs=$_.IsManagementOs;
@@ -254,12 +254,11 @@ $vmNetworkAdaptersObject = @(Get-VM -Name '{{.VmName}}*' | ?{$_.Name -eq '{{.VmN
 }})
 
 if ($vmNetworkAdaptersObject) {
-	$vmNetworkAdapters = ConvertTo-Json -InputObject $vmNetworkAdaptersObject
-	$vmNetworkAdapters
+	Write-Result $vmNetworkAdaptersObject
 } else {
-	"[]"
+	Write-Result @()
 }
-`))
+`)
 
 func (c *ClientConfig) GetVmNetworkAdapters(ctx context.Context, vmName string, networkAdaptersWaitForIps []api.VmNetworkAdapterWaitForIp) (result []api.VmNetworkAdapter, err error) {
 	result = make([]api.VmNetworkAdapter, 0)
@@ -282,110 +281,29 @@ func (c *ClientConfig) GetVmNetworkAdapters(ctx context.Context, vmName string,
 }
 
 type waitForVmNetworkAdaptersIpsArgs struct {
-	VmName                          string
-	Timeout                         uint32
-	PollPeriod                      uint32
-	VmNetworkAdaptersWaitForIpsJson string
+	VmName                      string
+	Timeout                     uint32
+	PollPeriod                  uint32
+	VmNetworkAdaptersWaitForIps []api.VmNetworkAdapterWaitForIp
 }
 
-var waitForVmNetworkAdaptersIpsTemplate = template.Must(template.New("WaitForVmNetworkAdaptersIps").Parse(`
+var waitForVmNetworkAdaptersIpsTemplate = newIdempotentScriptTemplate("WaitForVmNetworkAdaptersIps", `
 $ErrorActionPreference = 'Stop'
 
-function Test-CanGetIpsForState($State){
-	$states = @([Microsoft.HyperV.PowerShell.VMState]::Running,
-			[Microsoft.HyperV.PowerShell.VMState]::RunningCritical
-        )
-    return $states -contains $state 
-}
-
-function Test-CanNotGetIpsForState($State){
-    $states = @([Microsoft.HyperV.PowerShell.VMState]::Stopping,
-			[Microsoft.HyperV.PowerShell.VMState]::StoppingCritical,
-			[Microsoft.HyperV.PowerShell.VMState]::ForceShutdown,
-			[Microsoft.HyperV.PowerShell.VMState]::Off,
-			[Microsoft.HyperV.PowerShell.VMState]::OffCritical,
-			[Microsoft.HyperV.PowerShell.VMState]::Paused,
-			[Microsoft.HyperV.PowerShell.VMState]::PausedCritical
-        )
-    return $states -contains $state 
-}
-
-function Test-IsNotInFinalTransitionState($State){
-    $states = @([Microsoft.HyperV.PowerShell.VMState]::Other,
-		[Microsoft.HyperV.PowerShell.VMState]::Stopping,
-		[Microsoft.HyperV.PowerShell.VMState]::Saved,
-		[Microsoft.HyperV.PowerShell.VMState]::Starting,
-		[Microsoft.HyperV.PowerShell.VMState]::Reset,
-		[Microsoft.HyperV.PowerShell.VMState]::Saving,
-		[Microsoft.HyperV.PowerShell.VMState]::Pausing,
-		[Microsoft.HyperV.PowerShell.VMState]::Resuming,
-		[Microsoft.HyperV.PowerShell.VMState]::FastSaved,
-		[Microsoft.HyperV.PowerShell.VMState]::FastSaving,
-		[Microsoft.HyperV.PowerShell.VMState]::ForceShutdown,
-		[Microsoft.HyperV.PowerShell.VMState]::ForceReboot,
-        [Microsoft.HyperV.PowerShell.VMState]::StoppingCritical,
-        [Microsoft.HyperV.PowerShell.VMState]::SavedCritical,
-        [Microsoft.HyperV.PowerShell.VMState]::StartingCritical,
-        [Microsoft.HyperV.PowerShell.VMState]::ResetCritical,
-        [Microsoft.HyperV.PowerShell.VMState]::SavingCritical,
-        [Microsoft.HyperV.PowerShell.VMState]::PausingCritical,
-        [Microsoft.HyperV.PowerShell.VMState]::ResumingCritical,
-        [Microsoft.HyperV.PowerShell.VMState]::FastSavedCritical,
-        [Microsoft.HyperV.PowerShell.VMState]::FastSavingCritical
-        )
-	   
-    return $states -contains $State 
-}
-
-function Wait-ForNetworkAdapterIps($Name, $Timeout, $PollPeriod, $VmNetworkAdaptersToWaitForIps){
-	$timer = [Diagnostics.Stopwatch]::StartNew()
-	while ($timer.Elapsed.TotalSeconds -lt $Timeout) {
-        $vmObject = Get-VM -Name "$($vmName)*" | ?{$_.Name -eq $vmName}
-
-        if (!(Test-IsNotInFinalTransitionState $vmObject.state)){
-            if (Test-CanGetIpsForState $vmObject.state) {
-                $waitForIp = $false
-
-                $VmNetworkAdaptersToWaitForIps | ?{$_.WaitForIps} | %{
-                    $name = $_.Name
-                    $ipAddresses = @($vmObject.NetworkAdapters | ?{$_.Name -eq $name} | %{$_.IPAddresses} |?{$_})
-
-                    if ((!($ipAddresses)) -or ($ipAddresses -contains '0.0.0.0')){
-                        $waitForIp = $true
-                    } 
-                }
-
-                if (!$waitForIp){
-                    break
-                }
-           	} elseif (Test-CanNotGetIpsForState $vmObject.state) {
-               	break
-           	}
-       	}
-
-        Start-Sleep -Seconds $PollPeriod
-	}
-	$timer.Stop()
-
-	if ($timer.Elapsed.TotalSeconds -gt $Timeout) {
-		throw 'Timeout while waiting for vm $($Name) to read network adapter ips'
-	} 
-}
-
 Import-Module Hyper-V
-$vmNetworkAdaptersToWaitForIps = '{{.VmNetworkAdaptersWaitForIpsJson}}' | ConvertFrom-Json
-$vmName = '{{.VmName}}'
-$vmObject = Get-VM -Name "$($vmName)*" | ?{$_.Name -eq $vmName}
-$timeout = {{.Timeout}}
-$pollPeriod = {{.PollPeriod}}
+$vmNetworkAdaptersToWaitForIps = $params.VmNetworkAdaptersWaitForIps
+$vmName = $params.VmName
+$vmObject = Get-VM | Where-Object { $_.Name -eq $vmName }
+$timeout = $params.Timeout
+$pollPeriod = $params.PollPeriod
 
 if (!$vmObject){
-	throw "VM does not exist - $($vmName)"
+	Write-Error -Message "VM does not exist - $($vmName)" -Category ObjectNotFound -ErrorId ObjectNotFound -ErrorAction Stop
 }
 
-Wait-ForNetworkAdapterIps -Name $vmName -Timeout $timeout -PollPeriod $pollPeriod -VmNetworkAdaptersToWaitForIps $vmNetworkAdaptersToWaitForIps
+Wait-ForNetworkAdapterIps -Vm $vmObject -Timeout $timeout -PollPeriod $pollPeriod -VmNetworkAdaptersToWaitForIps $vmNetworkAdaptersToWaitForIps
 
-`))
+`)
 
 func (c *ClientConfig) WaitForVmNetworkAdaptersIps(
 	ctx context.Context,
@@ -394,36 +312,30 @@ func (c *ClientConfig) WaitForVmNetworkAdaptersIps(
 	pollPeriod uint32,
 	vmNetworkAdaptersWaitForIps []api.VmNetworkAdapterWaitForIp,
 ) (err error) {
-	vmNetworkAdaptersWaitForIpsJson, err := json.Marshal(vmNetworkAdaptersWaitForIps)
-
-	if err != nil {
-		return err
-	}
-
 	err = c.ScriptRunner.RunFireAndForgetScript(ctx, waitForVmNetworkAdaptersIpsTemplate, waitForVmNetworkAdaptersIpsArgs{
-		VmName:                          vmName,
-		Timeout:                         timeout,
-		PollPeriod:                      pollPeriod,
-		VmNetworkAdaptersWaitForIpsJson: string(vmNetworkAdaptersWaitForIpsJson),
+		VmName:                      vmName,
+		Timeout:                     remainingTimeoutSeconds(ctx, timeout),
+		PollPeriod:                  pollPeriod,
+		VmNetworkAdaptersWaitForIps: vmNetworkAdaptersWaitForIps,
 	})
 
 	return err
 }
 
 type updateVmNetworkAdapterArgs struct {
-	VmName               string
-	Index                int
-	VmNetworkAdapterJson string
+	VmName           string
+	Index            int
+	VmNetworkAdapter api.VmNetworkAdapter
 }
 
-var updateVmNetworkAdapterTemplate = template.Must(template.New("UpdateVmNetworkAdapter").Parse(`
+var updateVmNetworkAdapterTemplate = newScriptTemplate("UpdateVmNetworkAdapter", `
 $ErrorActionPreference = 'Stop'
 #First 3 requests fails to get ip address
-Get-VMNetworkAdapter -VmName '{{.VmName}}' | Out-Null
-Get-VMNetworkAdapter -VmName '{{.VmName}}' | Out-Null
-Get-VMNetworkAdapter -VmName '{{.VmName}}' | Out-Null
+Get-VM | Where-Object { $_.Name -eq $params.VmName } | Get-VMNetworkAdapter | Out-Null
+Get-VM | Where-Object { $_.Name -eq $params.VmName } | Get-VMNetworkAdapter | Out-Null
+Get-VM | Where-Object { $_.Name -eq $params.VmName } | Get-VMNetworkAdapter | Out-Null
 
-$vmNetworkAdapter = '{{.VmNetworkAdapterJson}}' | ConvertFrom-Json
+$vmNetworkAdapter = $params.VmNetworkAdapter
 
 $dhcpGuard = [Microsoft.HyperV.PowerShell.OnOffState]$vmNetworkAdapter.DhcpGuard
 $routerGuard = [Microsoft.HyperV.PowerShell.OnOffState]$vmNetworkAdapter.RouterGuard
@@ -435,34 +347,35 @@ $deviceNaming = [Microsoft.HyperV.PowerShell.OnOffState]$vmNetworkAdapter.Device
 $fixSpeed10G = [Microsoft.HyperV.PowerShell.OnOffState]$vmNetworkAdapter.FixSpeed10G
 $macAddressSpoofing = [Microsoft.HyperV.PowerShell.OnOffState]$vmNetworkAdapter.MacAddressSpoofing
 
-$vmNetworkAdaptersObject = @(Get-VM -Name '{{.VmName}}*' | ?{$_.Name -eq '{{.VmName}}' } | Get-VMNetworkAdapter)[{{.Index}}]
+$vmNetworkAdaptersObject = @(Get-VM | Where-Object { $_.Name -eq $params.VmName } | Get-VMNetworkAdapter)[$params.Index]
 
 if (!$vmNetworkAdaptersObject){
-	throw "VM network adapter does not exist - {{.Index}}"
+	Write-Error -Message "VM network adapter does not exist - $($params.Index)" -Category ObjectNotFound -ErrorId ObjectNotFound -ErrorAction Stop
 }
 
+$vmSwitch = $null
 if ($vmNetworkAdapter.SwitchName) {
-	$vmSwitch = Get-VMSwitch -Name $vmNetworkAdapter.SwitchName
-	if ($vmSwitch) {
-		$minimumBandwidthMode = $vmSwitch.BandwidthReservationMode
+	$vmSwitch = Get-VMSwitch | Where-Object { $_.Name -eq $vmNetworkAdapter.SwitchName }
+	if (!$vmSwitch){
+		Write-Error -Message "Switch does not exist - $($vmNetworkAdapter.SwitchName)" -Category ObjectNotFound -ErrorId ObjectNotFound -ErrorAction Stop
 	}
+	$minimumBandwidthMode = $vmSwitch.BandwidthReservationMode
 }
 
 if ($vmNetworkAdaptersObject.SwitchName -ne $vmNetworkAdapter.SwitchName) {
 	if ($vmNetworkAdapter.SwitchName) {
-		$null = $vmNetworkAdaptersObject | Connect-VMNetworkAdapter -SwitchName $vmNetworkAdapter.SwitchName
+		$null = Connect-VMNetworkAdapter -VMNetworkAdapter $vmNetworkAdaptersObject -VMSwitch $vmSwitch
 	} else {
 		$null = $vmNetworkAdaptersObject | Disconnect-VMNetworkAdapter
 	}
 }
 
 if ($vmNetworkAdaptersObject.Name -ne $vmNetworkAdapter.Name) {
-	$null = $vmNetworkAdaptersObject | Rename-VMNetworkAdapter -NewName $vmNetworkAdapter.Name
+	$vmNetworkAdaptersObject = $vmNetworkAdaptersObject | Rename-VMNetworkAdapter -NewName $vmNetworkAdapter.Name -Passthru
 }
 
 $SetVmNetworkAdapterArgs = @{}
-$SetVmNetworkAdapterArgs.VmName=$vmNetworkAdapter.VmName
-$SetVmNetworkAdapterArgs.Name=$vmNetworkAdapter.Name
+$SetVmNetworkAdapterArgs.VMNetworkAdapter=$vmNetworkAdaptersObject
 if ($vmNetworkAdapter.DynamicMacAddress) {
 	$SetVmNetworkAdapterArgs.DynamicMacAddress=$vmNetworkAdapter.DynamicMacAddress
 } elseif ($vmNetworkAdapter.StaticMacAddress) {
@@ -528,15 +441,14 @@ Set-VmNetworkAdapter @SetVmNetworkAdapterArgs
 if ($vmNetworkAdapter.VlanAccess -and $vmNetworkAdapter.VlanId) {
 	$SetVmNetworkAdapterVlanArgs = @{}
 
-	$SetVmNetworkAdapterVlanArgs.VMName = $vmNetworkAdapter.VmName
-	$SetVmNetworkAdapterVlanArgs.VMNetworkAdapterName = $vmNetworkAdapter.Name
+	$SetVmNetworkAdapterVlanArgs.VMNetworkAdapter = $vmNetworkAdaptersObject
 	$SetVmNetworkAdapterVlanArgs.Access = $true
 	$SetVmNetworkAdapterVlanArgs.VlanId = $vmNetworkAdapter.VlanId
 
 	Set-VmNetworkAdapterVlan @SetVmNetworkAdapterVlanArgs
 }
 
-`))
+`)
 
 func (c *ClientConfig) UpdateVmNetworkAdapter(
 	ctx context.Context,
@@ -581,57 +493,51 @@ func (c *ClientConfig) UpdateVmNetworkAdapter(
 	vlanAccess bool,
 	vlanId int,
 ) (err error) {
-	vmNetworkAdapterJson, err := json.Marshal(api.VmNetworkAdapter{
-		VmName:                                 vmName,
-		Index:                                  index,
-		Name:                                   name,
-		SwitchName:                             switchName,
-		ManagementOs:                           managementOs,
-		IsLegacy:                               isLegacy,
-		DynamicMacAddress:                      dynamicMacAddress,
-		StaticMacAddress:                       staticMacAddress,
-		MacAddressSpoofing:                     macAddressSpoofing,
-		DhcpGuard:                              dhcpGuard,
-		RouterGuard:                            routerGuard,
-		PortMirroring:                          portMirroring,
-		IeeePriorityTag:                        ieeePriorityTag,
-		VmqWeight:                              vmqWeight,
-		IovQueuePairsRequested:                 iovQueuePairsRequested,
-		IovInterruptModeration:                 iovInterruptModeration,
-		IovWeight:                              iovWeight,
-		IpsecOffloadMaximumSecurityAssociation: ipsecOffloadMaximumSecurityAssociation,
-		MaximumBandwidth:                       maximumBandwidth,
-		MinimumBandwidthAbsolute:               minimumBandwidthAbsolute,
-		MinimumBandwidthWeight:                 minimumBandwidthWeight,
-		MandatoryFeatureId:                     mandatoryFeatureId,
-		ResourcePoolName:                       resourcePoolName,
-		TestReplicaPoolName:                    testReplicaPoolName,
-		TestReplicaSwitchName:                  testReplicaSwitchName,
-		VirtualSubnetId:                        virtualSubnetId,
-		AllowTeaming:                           allowTeaming,
-		NotMonitoredInCluster:                  notMonitoredInCluster,
-		StormLimit:                             stormLimit,
-		DynamicIpAddressLimit:                  dynamicIpAddressLimit,
-		DeviceNaming:                           deviceNaming,
-		FixSpeed10G:                            fixSpeed10G,
-		PacketDirectNumProcs:                   packetDirectNumProcs,
-		PacketDirectModerationCount:            packetDirectModerationCount,
-		PacketDirectModerationInterval:         packetDirectModerationInterval,
-		VrssEnabled:                            vrssEnabled,
-		VmmqEnabled:                            vmmqEnabled,
-		VmmqQueuePairs:                         vmmqQueuePairs,
-		VlanAccess:                             vlanAccess,
-		VlanId:                                 vlanId,
-	})
-
-	if err != nil {
-		return err
-	}
-
 	err = c.ScriptRunner.RunFireAndForgetScript(ctx, updateVmNetworkAdapterTemplate, updateVmNetworkAdapterArgs{
-		VmName:               vmName,
-		Index:                index,
-		VmNetworkAdapterJson: string(vmNetworkAdapterJson),
+		VmName: vmName,
+		Index:  index,
+		VmNetworkAdapter: api.VmNetworkAdapter{
+			VmName:                                 vmName,
+			Index:                                  index,
+			Name:                                   name,
+			SwitchName:                             switchName,
+			ManagementOs:                           managementOs,
+			IsLegacy:                               isLegacy,
+			DynamicMacAddress:                      dynamicMacAddress,
+			StaticMacAddress:                       staticMacAddress,
+			MacAddressSpoofing:                     macAddressSpoofing,
+			DhcpGuard:                              dhcpGuard,
+			RouterGuard:                            routerGuard,
+			PortMirroring:                          portMirroring,
+			IeeePriorityTag:                        ieeePriorityTag,
+			VmqWeight:                              vmqWeight,
+			IovQueuePairsRequested:                 iovQueuePairsRequested,
+			IovInterruptModeration:                 iovInterruptModeration,
+			IovWeight:                              iovWeight,
+			IpsecOffloadMaximumSecurityAssociation: ipsecOffloadMaximumSecurityAssociation,
+			MaximumBandwidth:                       maximumBandwidth,
+			MinimumBandwidthAbsolute:               minimumBandwidthAbsolute,
+			MinimumBandwidthWeight:                 minimumBandwidthWeight,
+			MandatoryFeatureId:                     mandatoryFeatureId,
+			ResourcePoolName:                       resourcePoolName,
+			TestReplicaPoolName:                    testReplicaPoolName,
+			TestReplicaSwitchName:                  testReplicaSwitchName,
+			VirtualSubnetId:                        virtualSubnetId,
+			AllowTeaming:                           allowTeaming,
+			NotMonitoredInCluster:                  notMonitoredInCluster,
+			StormLimit:                             stormLimit,
+			DynamicIpAddressLimit:                  dynamicIpAddressLimit,
+			DeviceNaming:                           deviceNaming,
+			FixSpeed10G:                            fixSpeed10G,
+			PacketDirectNumProcs:                   packetDirectNumProcs,
+			PacketDirectModerationCount:            packetDirectModerationCount,
+			PacketDirectModerationInterval:         packetDirectModerationInterval,
+			VrssEnabled:                            vrssEnabled,
+			VmmqEnabled:                            vmmqEnabled,
+			VmmqQueuePairs:                         vmmqQueuePairs,
+			VlanAccess:                             vlanAccess,
+			VlanId:                                 vlanId,
+		},
 	})
 
 	return err
@@ -642,11 +548,11 @@ type deleteVmNetworkAdapterArgs struct {
 	Index  int
 }
 
-var deleteVmNetworkAdapterTemplate = template.Must(template.New("DeleteVmNetworkAdapter").Parse(`
+var deleteVmNetworkAdapterTemplate = newScriptTemplate("DeleteVmNetworkAdapter", `
 $ErrorActionPreference = 'Stop'
 
-@(Get-VM -Name '{{.VmName}}*' | ?{$_.Name -eq '{{.VmName}}' } | Get-VMNetworkAdapter)[{{.Index}}] | Remove-VMNetworkAdapter
-`))
+@(Get-VM | Where-Object { $_.Name -eq $params.VmName } | Get-VMNetworkAdapter)[$params.Index] | Remove-VMNetworkAdapter
+`)
 
 func (c *ClientConfig) DeleteVmNetworkAdapter(ctx context.Context, vmName string, index int) (err error) {
 	err = c.ScriptRunner.RunFireAndForgetScript(ctx, deleteVmNetworkAdapterTemplate, deleteVmNetworkAdapterArgs{
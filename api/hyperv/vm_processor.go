@@ -2,24 +2,27 @@ package hyperv
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"text/template"
 
 	"github.com/taliesins/terraform-provider-hyperv/api"
 )
 
 type createOrUpdateVmProcessorArgs struct {
-	VmProcessorJson string
+	VmProcessor api.VmProcessor
 }
 
-var createOrUpdateVmProcessorTemplate = template.Must(template.New("CreateOrUpdateVmProcessor").Parse(`
+var createOrUpdateVmProcessorTemplate = newScriptTemplate("CreateOrUpdateVmProcessor", `
 $ErrorActionPreference = 'Stop'
 Import-Module Hyper-V
-$vmProcessor = '{{.VmProcessorJson}}' | ConvertFrom-Json
+$vmProcessor = $params.VmProcessor
+
+$vmObject = Get-VM | Where-Object { $_.Name -eq $vmProcessor.VmName }
+if (!$vmObject){
+	Write-Error -Message "VM does not exist - $($vmProcessor.VmName)" -Category ObjectNotFound -ErrorId ObjectNotFound -ErrorAction Stop
+}
 
 $SetVMProcessorArgs = @{}
-$SetVMProcessorArgs.VMName=$vmProcessor.VmName
+$SetVMProcessorArgs.VM=$vmObject
 #$SetVMProcessorArgs.Count=$vmProcessor.ProcessorCount
 $SetVMProcessorArgs.CompatibilityForMigrationEnabled=$vmProcessor.CompatibilityForMigrationEnabled
 $SetVMProcessorArgs.CompatibilityForOlderOperatingSystemsEnabled=$vmProcessor.CompatibilityForOlderOperatingSystemsEnabled
@@ -39,7 +42,7 @@ $SetVMProcessorArgs.EnableHostResourceProtection=$vmProcessor.EnableHostResource
 $SetVMProcessorArgs.ExposeVirtualizationExtensions=$vmProcessor.ExposeVirtualizationExtensions
 
 Set-VMProcessor @SetVMProcessorArgs
-`))
+`)
 
 func (c *ClientConfig) CreateOrUpdateVmProcessor(
 	ctx context.Context,
@@ -55,26 +58,20 @@ func (c *ClientConfig) CreateOrUpdateVmProcessor(
 	enableHostResourceProtection bool,
 	exposeVirtualizationExtensions bool,
 ) (err error) {
-	vmProcessorJson, err := json.Marshal(api.VmProcessor{
-		VmName:                           vmName,
-		CompatibilityForMigrationEnabled: compatibilityForMigrationEnabled,
-		CompatibilityForOlderOperatingSystemsEnabled: compatibilityForOlderOperatingSystemsEnabled,
-		HwThreadCountPerCore:                         hwThreadCountPerCore,
-		Maximum:                                      maximum,
-		Reserve:                                      reserve,
-		RelativeWeight:                               relativeWeight,
-		MaximumCountPerNumaNode:                      maximumCountPerNumaNode,
-		MaximumCountPerNumaSocket:                    maximumCountPerNumaSocket,
-		EnableHostResourceProtection:                 enableHostResourceProtection,
-		ExposeVirtualizationExtensions:               exposeVirtualizationExtensions,
-	})
-
-	if err != nil {
-		return err
-	}
-
 	err = c.ScriptRunner.RunFireAndForgetScript(ctx, createOrUpdateVmProcessorTemplate, createOrUpdateVmProcessorArgs{
-		VmProcessorJson: string(vmProcessorJson),
+		VmProcessor: api.VmProcessor{
+			VmName:                           vmName,
+			CompatibilityForMigrationEnabled: compatibilityForMigrationEnabled,
+			CompatibilityForOlderOperatingSystemsEnabled: compatibilityForOlderOperatingSystemsEnabled,
+			HwThreadCountPerCore:                         hwThreadCountPerCore,
+			Maximum:                                      maximum,
+			Reserve:                                      reserve,
+			RelativeWeight:                               relativeWeight,
+			MaximumCountPerNumaNode:                      maximumCountPerNumaNode,
+			MaximumCountPerNumaSocket:                    maximumCountPerNumaSocket,
+			EnableHostResourceProtection:                 enableHostResourceProtection,
+			ExposeVirtualizationExtensions:               exposeVirtualizationExtensions,
+		},
 	})
 
 	return err
@@ -84,10 +81,10 @@ type getVmProcessorArgs struct {
 	VmName string
 }
 
-var getVmProcessorTemplate = template.Must(template.New("GetVmProcessor").Parse(`
+var getVmProcessorTemplate = newIdempotentScriptTemplate("GetVmProcessor", `
 $ErrorActionPreference = 'Stop'
 
-$vmProcessorObject = Get-VM -Name '{{.VmName}}*' | ?{$_.Name -eq '{{.VmName}}' } | Get-VMProcessor | %{ @{
+$vmProcessorObject = Get-VM | Where-Object { $_.Name -eq $params.VmName } | Get-VMProcessor | %{ @{
 	CompatibilityForMigrationEnabled=$_.CompatibilityForMigrationEnabled
 	CompatibilityForOlderOperatingSystemsEnabled=$_.CompatibilityForOlderOperatingSystemsEnabled
 	HwThreadCountPerCore=$_.HwThreadCountPerCore
@@ -101,12 +98,11 @@ $vmProcessorObject = Get-VM -Name '{{.VmName}}*' | ?{$_.Name -eq '{{.VmName}}' }
 }}
 
 if ($vmProcessorObject) {
-	$vmProcessor = ConvertTo-Json -InputObject $vmProcessorObject
-	$vmProcessor
+	Write-Result $vmProcessorObject
 } else {
-	"{}"
+	Write-Result @{}
 }
-`))
+`)
 
 func (c *ClientConfig) GetVmProcessor(ctx context.Context, vmName string) (result api.VmProcessor, err error) {
 	err = c.ScriptRunner.RunScriptWithResult(ctx, getVmProcessorTemplate, getVmProcessorArgs{
@@ -2,8 +2,6 @@ package hyperv
 
 import (
 	"context"
-	"encoding/json"
-	"text/template"
 
 	"github.com/taliesins/terraform-provider-hyperv/api"
 )
@@ -12,21 +10,19 @@ type getVmStatusArgs struct {
 	VmName string
 }
 
-var getVmStatusTemplate = template.Must(template.New("GetVmStatus").Parse(`
+var getVmStatusTemplate = newIdempotentScriptTemplate("GetVmStatus", `
 $ErrorActionPreference = 'Stop'
-$vmName = '{{.VmName}}'
 
-$vmStateObject = Get-VM -Name "$($vmName)*" | ?{$_.Name -eq $vmName } | %{ @{
+$vmStateObject = Get-VM | Where-Object { $_.Name -eq $params.VmName } | %{ @{
 	State=$_.State;
 }}
 
 if ($vmStateObject) {
-	$vmState = ConvertTo-Json -InputObject $vmStateObject
-	$vmState
+	Write-Result $vmStateObject
 } else {
-	"{}"
+	Write-Result @{}
 }
-`))
+`)
 
 func (c *ClientConfig) GetVmStatus(ctx context.Context, vmName string) (result api.VmStatus, err error) {
 	err = c.ScriptRunner.RunScriptWithResult(ctx, getVmStatusTemplate, getVmStatusArgs{
@@ -37,126 +33,68 @@ func (c *ClientConfig) GetVmStatus(ctx context.Context, vmName string) (result a
 }
 
 type updateVmStatusArgs struct {
-	VmName       string
-	Timeout      uint32
-	PollPeriod   uint32
-	VmStatusJson string
+	VmName     string
+	Timeout    uint32
+	PollPeriod uint32
+	VmStatus   api.VmStatus
 }
 
-var updateVmStatusTemplate = template.Must(template.New("UpdateVmStatus").Parse(`
+var updateVmStatusTemplate = newScriptTemplate("UpdateVmStatus", `
 $ErrorActionPreference = 'Stop'
 
-function Test-VmStateRequiresManualIntervention($state){
-    $states = @([Microsoft.HyperV.PowerShell.VMState]::Other, 
-        [Microsoft.HyperV.PowerShell.VMState]::RunningCritical,
-        [Microsoft.HyperV.PowerShell.VMState]::OffCritical, 
-        [Microsoft.HyperV.PowerShell.VMState]::StoppingCritical,
-        [Microsoft.HyperV.PowerShell.VMState]::SavedCritical,
-        [Microsoft.HyperV.PowerShell.VMState]::PausedCritical,
-        [Microsoft.HyperV.PowerShell.VMState]::StartingCritical,
-        [Microsoft.HyperV.PowerShell.VMState]::ResetCritical,
-        [Microsoft.HyperV.PowerShell.VMState]::SavingCritical,
-        [Microsoft.HyperV.PowerShell.VMState]::PausingCritical,
-        [Microsoft.HyperV.PowerShell.VMState]::ResumingCritical,
-        [Microsoft.HyperV.PowerShell.VMState]::FastSavedCritical,
-        [Microsoft.HyperV.PowerShell.VMState]::FastSavingCritical
-        )
-	   
-    return $states -contains $state 
-}
-
-function Test-IsNotInFinalTransitionState($State){
-    $states = @([Microsoft.HyperV.PowerShell.VMState]::Other,
-		[Microsoft.HyperV.PowerShell.VMState]::Stopping,
-		[Microsoft.HyperV.PowerShell.VMState]::Saved,
-		[Microsoft.HyperV.PowerShell.VMState]::Starting,
-		[Microsoft.HyperV.PowerShell.VMState]::Reset,
-		[Microsoft.HyperV.PowerShell.VMState]::Saving,
-		[Microsoft.HyperV.PowerShell.VMState]::Pausing,
-		[Microsoft.HyperV.PowerShell.VMState]::Resuming,
-		[Microsoft.HyperV.PowerShell.VMState]::FastSaved,
-		[Microsoft.HyperV.PowerShell.VMState]::FastSaving,
-		[Microsoft.HyperV.PowerShell.VMState]::ForceShutdown,
-		[Microsoft.HyperV.PowerShell.VMState]::ForceReboot,
-        [Microsoft.HyperV.PowerShell.VMState]::StoppingCritical,
-        [Microsoft.HyperV.PowerShell.VMState]::SavedCritical,
-        [Microsoft.HyperV.PowerShell.VMState]::StartingCritical,
-        [Microsoft.HyperV.PowerShell.VMState]::ResetCritical,
-        [Microsoft.HyperV.PowerShell.VMState]::SavingCritical,
-        [Microsoft.HyperV.PowerShell.VMState]::PausingCritical,
-        [Microsoft.HyperV.PowerShell.VMState]::ResumingCritical,
-        [Microsoft.HyperV.PowerShell.VMState]::FastSavedCritical,
-        [Microsoft.HyperV.PowerShell.VMState]::FastSavingCritical
-        )
-	   
-    return $states -contains $State 
-}
-
-function Wait-IsInFinalTransitionState($Name, $Timeout, $PollPeriod){
-	$timer = [Diagnostics.Stopwatch]::StartNew()
-	while (($timer.Elapsed.TotalSeconds -lt $Timeout) -and (Test-IsNotInFinalTransitionState (Get-VM -name $Name).state)) { 
-		Start-Sleep -Seconds $PollPeriod
-	}
-	$timer.Stop()
-
-	if ($timer.Elapsed.TotalSeconds -gt $Timeout) {
-		throw 'Timeout while waiting for vm $($Name) to reach final transition state'
-	} 
-}
-
 Import-Module Hyper-V
-$vm = '{{.VmStatusJson}}' | ConvertFrom-Json
-$vmName = '{{.VmName}}'
+$vm = $params.VmStatus
+$vmName = $params.VmName
 $state = [Microsoft.HyperV.PowerShell.VMState]$vm.State
-$vmObject = Get-VM -Name "$($vmName)*" | ?{$_.Name -eq $vmName}
-$timeout = {{.Timeout}}
-$pollPeriod = {{.PollPeriod}}
+$vmObject = Get-VM | Where-Object { $_.Name -eq $vmName }
+$timeout = $params.Timeout
+$pollPeriod = $params.PollPeriod
 
 if (!$vmObject){
-	throw "VM does not exist - $($vmName)"
+	Write-Error -Message "VM does not exist - $($vmName)" -Category ObjectNotFound -ErrorId ObjectNotFound -ErrorAction Stop
 }
 
 if ($vmObject.State -ne $state) {
     if (Test-VmStateRequiresManualIntervention -State $vmObject.State) {
-        throw "VM $($vmName) requires manual intervention as it is in state $($vmObject.State)"
+        Write-Error -Message "VM $($vmName) requires manual intervention as it is in state $($vmObject.State)" -Category InvalidOperation -ErrorId InvalidState -ErrorAction Stop
     }
 
-    Wait-IsInFinalTransitionState -Name $vmName -Timeout $timeout -PollPeriod $pollPeriod
+    Wait-IsInFinalTransitionState -Vm $vmObject -Timeout $timeout -PollPeriod $pollPeriod
 
-    $vmObject = Get-VM -Name "$($vmName)*" | ?{$_.Name -eq $vmName}
+    $vmObject = Get-VM -Id $vmObject.Id
 
     if ($vmObject.State -eq $state) {
     } elseif ($state -eq [Microsoft.HyperV.PowerShell.VMState]::Running) {
         if ($vmObject.State -eq [Microsoft.HyperV.PowerShell.VMState]::Off) { 
-            Start-VM -Name $vmName
+            Start-VM -VM $vmObject
             Start-Sleep -Seconds $pollPeriod
-            Wait-IsInFinalTransitionState -Name $vmName -Timeout $timeout -PollPeriod $pollPeriod
+            Wait-IsInFinalTransitionState -Vm $vmObject -Timeout $timeout -PollPeriod $pollPeriod
         } elseif ($vmObject.State -eq [Microsoft.HyperV.PowerShell.VMState]::Off) { 
-            Resume-VM -Name $vmName
+            Resume-VM -VM $vmObject
             Start-Sleep -Seconds $pollPeriod
-            Wait-IsInFinalTransitionState -Name $vmName -Timeout $timeout -PollPeriod $pollPeriod
+            Wait-IsInFinalTransitionState -Vm $vmObject -Timeout $timeout -PollPeriod $pollPeriod
         } else {
-            throw "Unable to change VM $($vmName) state $($vmObject.State) to Running state"
+            Write-Error -Message "Unable to change VM $($vmName) state $($vmObject.State) to Running state" -Category InvalidOperation -ErrorId InvalidState -ErrorAction Stop
         }
     } elseif ($state -eq [Microsoft.HyperV.PowerShell.VMState]::Off) { 
         if ($vmObject.State -eq [Microsoft.HyperV.PowerShell.VMState]::Running -or $vmObject.State -eq [Microsoft.HyperV.PowerShell.VMState]::Paused) { 
-            Stop-VM -Name $vmName -force
+            Stop-VM -VM $vmObject -force
             Start-Sleep -Seconds $pollPeriod
-            Wait-IsInFinalTransitionState -Name $vmName -Timeout $timeout -PollPeriod $pollPeriod
+            Wait-IsInFinalTransitionState -Vm $vmObject -Timeout $timeout -PollPeriod $pollPeriod
         } else {
-            throw "Unable to change VM $($vmName) state $($vmObject.State) to Off state"
+            Write-Error -Message "Unable to change VM $($vmName) state $($vmObject.State) to Off state" -Category InvalidOperation -ErrorId InvalidState -ErrorAction Stop
         }
     } elseif ($state -eq [Microsoft.HyperV.PowerShell.VMState]::Paused) {
         if ($vmObject.State -eq [Microsoft.HyperV.PowerShell.VMState]::Running) { 
-            Suspend-VM -Name $vmName
+            Suspend-VM -VM $vmObject
             Start-Sleep -Seconds $pollPeriod
-            Wait-IsInFinalTransitionState -Name $vmName -Timeout $timeout -PollPeriod $pollPeriod
+            Wait-IsInFinalTransitionState -Vm $vmObject -Timeout $timeout -PollPeriod $pollPeriod
         } else {
-            throw "Unable to change VM $($vmName) state $($vmObject.State) to Paused state"
+            Write-Error -Message "Unable to change VM $($vmName) state $($vmObject.State) to Paused state" -Category InvalidOperation -ErrorId InvalidState -ErrorAction Stop
         }	
     }
 }
-`))
+`)
 
 func (c *ClientConfig) UpdateVmStatus(
 	ctx context.Context,
@@ -165,19 +103,13 @@ func (c *ClientConfig) UpdateVmStatus(
 	pollPeriod uint32,
 	state api.VmState,
 ) (err error) {
-	vmStatusJson, err := json.Marshal(api.VmStatus{
-		State: state,
-	})
-
-	if err != nil {
-		return err
-	}
-
 	err = c.ScriptRunner.RunFireAndForgetScript(ctx, updateVmStatusTemplate, updateVmStatusArgs{
-		VmName:       vmName,
-		Timeout:      timeout,
-		PollPeriod:   pollPeriod,
-		VmStatusJson: string(vmStatusJson),
+		VmName:     vmName,
+		Timeout:    remainingTimeoutSeconds(ctx, timeout),
+		PollPeriod: pollPeriod,
+		VmStatus: api.VmStatus{
+			State: state,
+		},
 	})
 
 	return err
@@ -2,8 +2,6 @@ package hyperv
 
 import (
 	"context"
-	"encoding/json"
-	"text/template"
 
 	"github.com/taliesins/terraform-provider-hyperv/api"
 )
@@ -12,18 +10,16 @@ type existsVMSwitchArgs struct {
 	Name string
 }
 
-var existsVMSwitchTemplate = template.Must(template.New("ExistsVMSwitch").Parse(`
+var existsVMSwitchTemplate = newIdempotentScriptTemplate("ExistsVMSwitch", `
 $ErrorActionPreference = 'Stop'
-$vmSwitchObject = Get-VMSwitch -Name '{{.Name}}*' | ?{$_.Name -eq '{{.Name}}' }
+$vmSwitchObject = Get-VMSwitch | Where-Object { $_.Name -eq $params.Name }
 
 if ($vmSwitchObject){
-	$exists = ConvertTo-Json -InputObject @{Exists=$true}
-	$exists
+	Write-Result @{Exists=$true}
 } else {
-	$exists = ConvertTo-Json -InputObject @{Exists=$false}
-	$exists
+	Write-Result @{Exists=$false}
 }
-`))
+`)
 
 func (c *ClientConfig) VMSwitchExists(ctx context.Context, name string) (result api.VmSwitchExists, err error) {
 	err = c.ScriptRunner.RunScriptWithResult(ctx, existsVMSwitchTemplate, existsVMSwitchArgs{
@@ -34,22 +30,22 @@ func (c *ClientConfig) VMSwitchExists(ctx context.Context, name string) (result
 }
 
 type createVMSwitchArgs struct {
-	VmSwitchJson string
+	VmSwitch api.VmSwitch
 }
 
-var createVMSwitchTemplate = template.Must(template.New("CreateVMSwitch").Parse(`
+var createVMSwitchTemplate = newScriptTemplate("CreateVMSwitch", `
 $ErrorActionPreference = 'Stop'
 Import-Module Hyper-V
-$vmSwitch = '{{.VmSwitchJson}}' | ConvertFrom-Json
+$vmSwitch = $params.VmSwitch
 $minimumBandwidthMode = [Microsoft.HyperV.PowerShell.VMSwitchBandwidthMode]$vmSwitch.BandwidthReservationMode
 $switchType = [Microsoft.HyperV.PowerShell.VMSwitchType]$vmSwitch.SwitchType
 $NetAdapterNames = @($vmSwitch.NetAdapterNames)
 #when EnablePacketDirect=true it seems to throw an exception if EnableIov=true or EnableEmbeddedTeaming=true
 
-$switchObject = Get-VMSwitch -Name "$($vmSwitch.Name)*" | ?{$_.Name -eq $vmSwitch.Name}
+$switchObject = Get-VMSwitch | Where-Object { $_.Name -eq $vmSwitch.Name }
 
 if ($switchObject){
-	throw "Switch already exists - $($vmSwitch.Name)"
+	Write-Error -Message "Switch already exists - $($vmSwitch.Name)" -Category ResourceExists -ErrorId AlreadyExists -ErrorAction Stop
 }
 
 $NewVmSwitchArgs = @{}
@@ -69,14 +65,14 @@ if ($NetAdapterNames) {
 }
 New-VMSwitch @NewVmSwitchArgs
 
-$switchObject = Get-VMSwitch -Name "$($vmSwitch.Name)" | ?{$_.Name -eq $vmSwitch.Name}
+$switchObject = Get-VMSwitch | Where-Object { $_.Name -eq $vmSwitch.Name }
 
 if (!$switchObject){
-	throw "Switch does not exist - $($vmSwitch.Name)"
+	Write-Error -Message "Switch does not exist - $($vmSwitch.Name)" -Category ObjectNotFound -ErrorId ObjectNotFound -ErrorAction Stop
 }
 
 $SetVmSwitchArgs = @{}
-$SetVmSwitchArgs.Name=$vmSwitch.Name
+$SetVmSwitchArgs.VMSwitch=$switchObject
 $SetVmSwitchArgs.Notes=$vmSwitch.Notes
 if (($minimumBandwidthMode -eq [Microsoft.HyperV.PowerShell.VMSwitchBandwidthMode]::Absolute) -and $switchObject.DefaultFlowMinimumBandwidthAbsolute -ne $vmSwitch.DefaultFlowMinimumBandwidthAbsolute) {
 	$SetVmSwitchArgs.DefaultFlowMinimumBandwidthAbsolute=$vmSwitch.DefaultFlowMinimumBandwidthAbsolute
@@ -90,7 +86,7 @@ $SetVmSwitchArgs.DefaultQueueVrssEnabled=$vmSwitch.DefaultQueueVrssEnabled
 
 Set-VMSwitch @SetVmSwitchArgs
 
-`))
+`)
 
 func (c *ClientConfig) CreateVMSwitch(
 	ctx context.Context,
@@ -109,29 +105,23 @@ func (c *ClientConfig) CreateVMSwitch(
 	defaultQueueVmmqQueuePairs int32,
 	defaultQueueVrssEnabled bool,
 ) (err error) {
-	vmSwitchJson, err := json.Marshal(api.VmSwitch{
-		Name:                                name,
-		Notes:                               notes,
-		AllowManagementOS:                   allowManagementOS,
-		EmbeddedTeamingEnabled:              embeddedTeamingEnabled,
-		IovEnabled:                          iovEnabled,
-		PacketDirectEnabled:                 packetDirectEnabled,
-		BandwidthReservationMode:            bandwidthReservationMode,
-		SwitchType:                          switchType,
-		NetAdapterNames:                     netAdapterNames,
-		DefaultFlowMinimumBandwidthAbsolute: defaultFlowMinimumBandwidthAbsolute,
-		DefaultFlowMinimumBandwidthWeight:   defaultFlowMinimumBandwidthWeight,
-		DefaultQueueVmmqEnabled:             defaultQueueVmmqEnabled,
-		DefaultQueueVmmqQueuePairs:          defaultQueueVmmqQueuePairs,
-		DefaultQueueVrssEnabled:             defaultQueueVrssEnabled,
-	})
-
-	if err != nil {
-		return err
-	}
-
 	err = c.ScriptRunner.RunFireAndForgetScript(ctx, createVMSwitchTemplate, createVMSwitchArgs{
-		VmSwitchJson: string(vmSwitchJson),
+		VmSwitch: api.VmSwitch{
+			Name:                                name,
+			Notes:                               notes,
+			AllowManagementOS:                   allowManagementOS,
+			EmbeddedTeamingEnabled:              embeddedTeamingEnabled,
+			IovEnabled:                          iovEnabled,
+			PacketDirectEnabled:                 packetDirectEnabled,
+			BandwidthReservationMode:            bandwidthReservationMode,
+			SwitchType:                          switchType,
+			NetAdapterNames:                     netAdapterNames,
+			DefaultFlowMinimumBandwidthAbsolute: defaultFlowMinimumBandwidthAbsolute,
+			DefaultFlowMinimumBandwidthWeight:   defaultFlowMinimumBandwidthWeight,
+			DefaultQueueVmmqEnabled:             defaultQueueVmmqEnabled,
+			DefaultQueueVmmqQueuePairs:          defaultQueueVmmqQueuePairs,
+			DefaultQueueVrssEnabled:             defaultQueueVrssEnabled,
+		},
 	})
 
 	return err
@@ -141,9 +131,9 @@ type getVMSwitchArgs struct {
 	Name string
 }
 
-var getVMSwitchTemplate = template.Must(template.New("GetVMSwitch").Parse(`
+var getVMSwitchTemplate = newIdempotentScriptTemplate("GetVMSwitch", `
 $ErrorActionPreference = 'Stop'
-$vmSwitchObject = Get-VMSwitch -Name '{{.Name}}*' | ?{$_.Name -eq '{{.Name}}' } | %{ @{
+$vmSwitchObject = Get-VMSwitch | Where-Object { $_.Name -eq $params.Name } | %{ @{
 	Name=$_.Name;
 	Notes=$_.Notes;
 	AllowManagementOS=$_.AllowManagementOS;
@@ -161,12 +151,11 @@ $vmSwitchObject = Get-VMSwitch -Name '{{.Name}}*' | ?{$_.Name -eq '{{.Name}}' }
 }}
 
 if ($vmSwitchObject){
-	$vmSwitch = ConvertTo-Json -InputObject $vmSwitchObject
-	$vmSwitch
+	Write-Result $vmSwitchObject
 } else {
-	"{}"
+	Write-Result @{}
 }
-`))
+`)
 
 func (c *ClientConfig) GetVMSwitch(ctx context.Context, name string) (result api.VmSwitch, err error) {
 	err = c.ScriptRunner.RunScriptWithResult(ctx, getVMSwitchTemplate, getVMSwitchArgs{
@@ -177,33 +166,33 @@ func (c *ClientConfig) GetVMSwitch(ctx context.Context, name string) (result api
 }
 
 type updateVMSwitchArgs struct {
-	OldName      string
-	VmSwitchJson string
+	OldName  string
+	VmSwitch api.VmSwitch
 }
 
-var updateVMSwitchTemplate = template.Must(template.New("UpdateVMSwitch").Parse(`
+var updateVMSwitchTemplate = newScriptTemplate("UpdateVMSwitch", `
 $ErrorActionPreference = 'Stop'
 Import-Module Hyper-V
-$oldName = '{{.OldName}}'
-$vmSwitch = '{{.VmSwitchJson}}' | ConvertFrom-Json
+$oldName = $params.OldName
+$vmSwitch = $params.VmSwitch
 $minimumBandwidthMode = [Microsoft.HyperV.PowerShell.VMSwitchBandwidthMode]$vmSwitch.BandwidthReservationMode
 $switchType = [Microsoft.HyperV.PowerShell.VMSwitchType]$vmSwitch.SwitchType
 $NetAdapterNames = @($vmSwitch.NetAdapterNames)
 
 #when EnablePacketDirect=true it seems to throw an exception if EnableIov=true or EnableEmbeddedTeaming=true
 
-$switchObject = Get-VMSwitch -Name "$($oldName)*" | ?{$_.Name -eq $oldName}
+$switchObject = Get-VMSwitch | Where-Object { $_.Name -eq $oldName }
 
 if (!$switchObject){
-	throw "Switch does not exist - $($oldName)"
+	Write-Error -Message "Switch does not exist - $($oldName)" -Category ObjectNotFound -ErrorId ObjectNotFound -ErrorAction Stop
 }
 
 if ($oldName -ne $vmSwitch.Name) {
-	Rename-VMSwitch -Name $oldName -NewName $vmSwitch.Name
+	$switchObject = Rename-VMSwitch -VMSwitch $switchObject -NewName $vmSwitch.Name -Passthru
 }
 
 $SetVmSwitchArgs = @{}
-$SetVmSwitchArgs.Name=$vmSwitch.Name
+$SetVmSwitchArgs.VMSwitch=$switchObject
 $SetVmSwitchArgs.Notes=$vmSwitch.Notes
 if ($NetAdapterNames) {
 	$SetVmSwitchArgs.AllowManagementOS=$vmSwitch.AllowManagementOS
@@ -244,7 +233,7 @@ $SetVmSwitchArgs.DefaultQueueVmmqQueuePairs=$vmSwitch.DefaultQueueVmmqQueuePairs
 $SetVmSwitchArgs.DefaultQueueVrssEnabled=$vmSwitch.DefaultQueueVrssEnabled
 
 Set-VMSwitch @SetVmSwitchArgs
-`))
+`)
 
 func (c *ClientConfig) UpdateVMSwitch(
 	ctx context.Context,
@@ -264,30 +253,24 @@ func (c *ClientConfig) UpdateVMSwitch(
 	defaultQueueVmmqQueuePairs int32,
 	defaultQueueVrssEnabled bool,
 ) (err error) {
-	vmSwitchJson, err := json.Marshal(api.VmSwitch{
-		Name:              name,
-		Notes:             notes,
-		AllowManagementOS: allowManagementOS,
-		//EmbeddedTeamingEnabled:embeddedTeamingEnabled,
-		//IovEnabled:iovEnabled,
-		//PacketDirectEnabled:packetDirectEnabled,
-		//BandwidthReservationMode:bandwidthReservationMode,
-		SwitchType:                          switchType,
-		NetAdapterNames:                     netAdapterNames,
-		DefaultFlowMinimumBandwidthAbsolute: defaultFlowMinimumBandwidthAbsolute,
-		DefaultFlowMinimumBandwidthWeight:   defaultFlowMinimumBandwidthWeight,
-		DefaultQueueVmmqEnabled:             defaultQueueVmmqEnabled,
-		DefaultQueueVmmqQueuePairs:          defaultQueueVmmqQueuePairs,
-		DefaultQueueVrssEnabled:             defaultQueueVrssEnabled,
-	})
-
-	if err != nil {
-		return err
-	}
-
 	err = c.ScriptRunner.RunFireAndForgetScript(ctx, updateVMSwitchTemplate, updateVMSwitchArgs{
-		OldName:      oldName,
-		VmSwitchJson: string(vmSwitchJson),
+		OldName: oldName,
+		VmSwitch: api.VmSwitch{
+			Name:              name,
+			Notes:             notes,
+			AllowManagementOS: allowManagementOS,
+			//EmbeddedTeamingEnabled:embeddedTeamingEnabled,
+			//IovEnabled:iovEnabled,
+			//PacketDirectEnabled:packetDirectEnabled,
+			//BandwidthReservationMode:bandwidthReservationMode,
+			SwitchType:                          switchType,
+			NetAdapterNames:                     netAdapterNames,
+			DefaultFlowMinimumBandwidthAbsolute: defaultFlowMinimumBandwidthAbsolute,
+			DefaultFlowMinimumBandwidthWeight:   defaultFlowMinimumBandwidthWeight,
+			DefaultQueueVmmqEnabled:             defaultQueueVmmqEnabled,
+			DefaultQueueVmmqQueuePairs:          defaultQueueVmmqQueuePairs,
+			DefaultQueueVrssEnabled:             defaultQueueVrssEnabled,
+		},
 	})
 
 	return err
@@ -297,10 +280,10 @@ type deleteVMSwitchArgs struct {
 	Name string
 }
 
-var deleteVMSwitchTemplate = template.Must(template.New("DeleteVMSwitch").Parse(`
+var deleteVMSwitchTemplate = newScriptTemplate("DeleteVMSwitch", `
 $ErrorActionPreference = 'Stop'
-Get-VMSwitch -Name '{{.Name}}*' | ?{$_.Name -eq '{{.Name}}'} | Remove-VMSwitch -Force
-`))
+Get-VMSwitch | Where-Object { $_.Name -eq $params.Name } | Remove-VMSwitch -Force
+`)
 
 func (c *ClientConfig) DeleteVMSwitch(ctx context.Context, name string) (err error) {
 	err = c.ScriptRunner.RunFireAndForgetScript(ctx, deleteVMSwitchTemplate, deleteVMSwitchArgs{
@@ -0,0 +1,212 @@
+package local_helper
+
+// Local helper for running the provider directly on the Hyper-V host.
+//
+// Scripts are written to a temporary file and run with the configured PowerShell executable
+// through os/exec. File operations use the local file system, so uploads become local copies.
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"text/template"
+	"time"
+
+	"github.com/taliesins/terraform-provider-hyperv/api/commandresult"
+	"github.com/taliesins/terraform-provider-hyperv/api/redact"
+)
+
+const (
+	// DefaultPowerShell is the PowerShell executable used when none is configured.
+	DefaultPowerShell = "powershell"
+
+	// processWaitDelay bounds how long output is awaited once an interrupted process was killed.
+	processWaitDelay = 5 * time.Second
+)
+
+// utf8BOM makes Windows PowerShell 5.1 read script files as UTF-8 rather than the ANSI code page.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// New creates a new local provider
+func New(clientConfig *ClientConfig) (*Provider, error) {
+	return &Provider{
+		Client: clientConfig,
+	}, nil
+}
+
+type ClientConfig struct {
+	PowerShell string // Optional: PowerShell executable name or path (default: DefaultPowerShell)
+	ScriptDir  string // Optional: directory for temporary script files (default: os.TempDir())
+	Vars       string // Environment variables to set
+
+	Redactor *redact.Redactor // Optional: masks secrets in logged scripts and in errors
+}
+
+func (c *ClientConfig) powerShellExecutable() string {
+	if c.PowerShell == "" {
+		return DefaultPowerShell
+	}
+
+	return c.PowerShell
+}
+
+// runCommand runs a PowerShell script and returns its output. Stdout is also copied to output, if any, while the
+// script runs. When ctx is done the process tree is killed and the error wraps ctx.Err().
+func (c *ClientConfig) runCommand(ctx context.Context, command string, output io.Writer) (stdout, stderr string, exitCode int, err error) {
+	if err := ctx.Err(); err != nil {
+		return "", "", -1, err
+	}
+
+	scriptPath, err := c.writeScriptFile(command)
+	if err != nil {
+		return "", "", -1, err
+	}
+	defer func() {
+		if removeErr := os.Remove(scriptPath); removeErr != nil {
+			log.Printf("[WARN] Failed to remove %s: %v", scriptPath, removeErr)
+		}
+	}()
+
+	cmd := exec.CommandContext(ctx, c.powerShellExecutable(), "-NoProfile", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-File", scriptPath)
+	configureProcessTree(cmd)
+	cmd.WaitDelay = processWaitDelay
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+	cmd.Stdout = &stdoutBuf
+	if output != nil {
+		cmd.Stdout = io.MultiWriter(&stdoutBuf, output)
+	}
+	cmd.Stderr = &stderrBuf
+
+	log.Printf("[DEBUG] Executing local command: %s", c.Redactor.String(cmd.String()))
+	runErr := cmd.Run()
+	stdout = stdoutBuf.String()
+	stderr = stderrBuf.String()
+
+	if ctx.Err() != nil {
+		return stdout, stderr, -1, fmt.Errorf("command interrupted: %w", ctx.Err())
+	}
+
+	if runErr != nil {
+		var exitErr *exec.ExitError
+		if errors.As(runErr, &exitErr) {
+			return stdout, stderr, exitErr.ExitCode(), nil
+		}
+		return stdout, stderr, -1, fmt.Errorf("command execution failed: %w", runErr)
+	}
+
+	return stdout, stderr, 0, nil
+}
+
+// writeScriptFile writes the script to a temporary .ps1 file, which avoids command line length limits.
+func (c *ClientConfig) writeScriptFile(command string) (string, error) {
+	f, err := os.CreateTemp(c.ScriptDir, "terraform-hyperv-*.ps1")
+	if err != nil {
+		return "", fmt.Errorf("failed to create script file: %w", err)
+	}
+
+	content := append(append([]byte{}, utf8BOM...), c.prepareScript(command)...)
+	_, err = f.Write(content)
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write script file: %w", err)
+	}
+
+	return f.Name(), nil
+}
+
+// prepareScript disables progress records, which are otherwise serialized to stderr, and prepends
+// the configured environment variables.
+func (c *ClientConfig) prepareScript(command string) string {
+	prepared := "$ProgressPreference = 'SilentlyContinue'\n"
+	if c.Vars != "" {
+		prepared += c.Vars + "\n"
+	}
+
+	return prepared + command
+}
+
+// RunScript runs a rendered script and returns its raw output and exit code
+func (c *ClientConfig) RunScript(ctx context.Context, command string) (stdout, stderr string, exitCode int, err error) {
+	return c.runCommand(ctx, command, nil)
+}
+
+// RunScriptStreaming runs a rendered script like RunScript, copying its stdout to output while it runs
+func (c *ClientConfig) RunScriptStreaming(ctx context.Context, command string, output io.Writer) (stdout, stderr string, exitCode int, err error) {
+	return c.runCommand(ctx, command, output)
+}
+
+// RunFireAndForgetScript executes a script without waiting for or processing results
+func (c *ClientConfig) RunFireAndForgetScript(ctx context.Context, script *template.Template, args interface{}) error {
+	var scriptRendered bytes.Buffer
+	err := script.Execute(&scriptRendered, args)
+	if err != nil {
+		return fmt.Errorf("failed to render script template: %w", err)
+	}
+
+	command := scriptRendered.String()
+	redactor := c.Redactor.WithArgs(args)
+	log.Printf("[DEBUG] Running fire and forget script:\n%s\n", redactor.String(command))
+
+	stdout, stderr, exitCode, err := c.runCommand(ctx, command, nil)
+	if err != nil {
+		return redactor.Error(err)
+	}
+
+	return commandresult.CheckExitCode(redactor, exitCode, stdout, stderr)
+}
+
+// RunScriptWithResult executes a script and unmarshals JSON output into result
+func (c *ClientConfig) RunScriptWithResult(ctx context.Context, script *template.Template, args interface{}, result interface{}) error {
+	var scriptRendered bytes.Buffer
+	err := script.Execute(&scriptRendered, args)
+	if err != nil {
+		return fmt.Errorf("failed to render script template: %w", err)
+	}
+
+	command := scriptRendered.String()
+	redactor := c.Redactor.WithArgs(args)
+	log.Printf("[DEBUG] Running script with result:\n%s\n", redactor.String(command))
+
+	stdout, stderr, exitCode, err := c.runCommand(ctx, command, nil)
+	if err != nil {
+		return redactor.Error(err)
+	}
+
+	return commandresult.DecodeJSON(redactor, exitCode, stdout, stderr, command, result)
+}
+
+// RunStreamingScript executes a script, passing its records to records while it runs, and unmarshals JSON output into
+// result unless result is nil
+func (c *ClientConfig) RunStreamingScript(ctx context.Context, script *template.Template, args interface{}, result interface{}, records commandresult.RecordHandler) error {
+	var scriptRendered bytes.Buffer
+	err := script.Execute(&scriptRendered, args)
+	if err != nil {
+		return fmt.Errorf("failed to render script template: %w", err)
+	}
+
+	command := scriptRendered.String()
+	redactor := c.Redactor.WithArgs(args)
+	log.Printf("[DEBUG] Running streaming script:\n%s\n", redactor.String(command))
+
+	stdout, stderr, exitCode, err := c.runCommand(ctx, command, commandresult.NewRecordWriter(redactor, records))
+	if err != nil {
+		return redactor.Error(err)
+	}
+
+	if result == nil {
+		return commandresult.CheckExitCode(redactor, exitCode, stdout, stderr)
+	}
+
+	return commandresult.DecodeJSON(redactor, exitCode, stdout, stderr, command, result)
+}
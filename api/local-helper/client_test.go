@@ -0,0 +1,190 @@
+package local_helper
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/taliesins/terraform-provider-hyperv/api/commandresult"
+	"github.com/taliesins/terraform-provider-hyperv/api/redact"
+)
+
+// stubPowerShell records its arguments next to itself and runs the script file, minus the BOM and
+// the line added by prepareScript, with sh. Tests write their scripts in sh accordingly.
+const stubPowerShell = `#!/bin/sh
+for last; do :; done
+printf '%s\n' "$*" > "$(dirname "$0")/args"
+tail -n +2 "$last" | sh
+`
+
+func newStubConfig(t *testing.T) *ClientConfig {
+	t.Helper()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("the stub PowerShell executable is a shell script")
+	}
+
+	dir := t.TempDir()
+	executable := filepath.Join(dir, "pwsh")
+	if err := os.WriteFile(executable, []byte(stubPowerShell), 0o700); err != nil {
+		t.Fatalf("failed to write stub executable: %v", err)
+	}
+
+	scriptDir := filepath.Join(dir, "scripts")
+	if err := os.Mkdir(scriptDir, 0o700); err != nil {
+		t.Fatalf("failed to create script directory: %v", err)
+	}
+
+	return &ClientConfig{PowerShell: executable, ScriptDir: scriptDir}
+}
+
+func TestRunScriptWithResult(t *testing.T) {
+	t.Parallel()
+
+	config := newStubConfig(t)
+	script := template.Must(template.New("script").Parse(`echo '{"Name":"{{.Name}}","State":2}'`))
+
+	var result struct {
+		Name  string
+		State int
+	}
+	if err := config.RunScriptWithResult(context.Background(), script, struct{ Name string }{Name: "web"}, &result); err != nil {
+		t.Fatalf("expected script to succeed, got %v", err)
+	}
+
+	if result.Name != "web" || result.State != 2 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	args, err := os.ReadFile(filepath.Join(filepath.Dir(config.PowerShell), "args"))
+	if err != nil {
+		t.Fatalf("expected the stub to record its arguments: %v", err)
+	}
+
+	if !strings.HasPrefix(string(args), "-NoProfile -NonInteractive -ExecutionPolicy Bypass -File "+config.ScriptDir) {
+		t.Fatalf("unexpected arguments %q", args)
+	}
+
+	entries, err := os.ReadDir(config.ScriptDir)
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("expected the script file to be removed, got %v (%v)", entries, err)
+	}
+}
+
+func TestRunStreamingScript(t *testing.T) {
+	t.Parallel()
+
+	config := newStubConfig(t)
+	config.Redactor = redact.New("hunter2")
+
+	// The script only completes once the handler received its first record, so records must arrive while it runs
+	received := filepath.Join(t.TempDir(), "received")
+	script := template.Must(template.New("script").Parse(`echo '` + commandresult.RecordMarker + `{"Stream":"Progress","Activity":"Downloading","Status":"1 of 2 MB as hunter2","PercentComplete":50}'
+i=0; while [ ! -f '{{.Received}}' ] && [ $i -lt 100 ]; do sleep 0.1; i=$((i+1)); done
+[ -f '{{.Received}}' ] || exit 4
+echo '` + commandresult.RecordMarker + `{"Stream":"Verbose","Message":"Exported"}'
+echo '` + commandresult.ResultBeginMarker + `'
+echo '{"Name":"web"}'
+echo '` + commandresult.ResultEndMarker + `'`))
+
+	var records []commandresult.Record
+	var result struct{ Name string }
+	err := config.RunStreamingScript(context.Background(), script, struct{ Received string }{Received: received}, &result, func(record commandresult.Record) {
+		if len(records) == 0 {
+			if err := os.WriteFile(received, nil, 0o600); err != nil {
+				t.Errorf("failed to signal the script: %v", err)
+			}
+		}
+		records = append(records, record)
+	})
+	if err != nil {
+		t.Fatalf("expected script to succeed, got %v", err)
+	}
+
+	if result.Name != "web" {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	want := []commandresult.Record{
+		{Stream: commandresult.RecordProgress, Activity: "Downloading", Status: "1 of 2 MB as ***", PercentComplete: 50},
+		{Stream: commandresult.RecordVerbose, PercentComplete: -1, Message: "Exported"},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Fatalf("expected records %+v, got %+v", want, records)
+	}
+}
+
+func TestWriteScriptFile(t *testing.T) {
+	t.Parallel()
+
+	config := &ClientConfig{ScriptDir: t.TempDir()}
+
+	path, err := config.writeScriptFile("Write-Output 'ünïcode'")
+	if err != nil {
+		t.Fatalf("expected script file to be written, got %v", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read script file: %v", err)
+	}
+
+	expected := "\xEF\xBB\xBF$ProgressPreference = 'SilentlyContinue'\nWrite-Output 'ünïcode'"
+	if string(content) != expected || filepath.Ext(path) != ".ps1" {
+		t.Fatalf("unexpected script file %s with content %q", path, content)
+	}
+}
+
+func TestRunScriptFailure(t *testing.T) {
+	t.Parallel()
+
+	config := newStubConfig(t)
+
+	err := config.RunFireAndForgetScript(context.Background(), template.Must(template.New("script").Parse("echo 'VM does not exist' >&2; exit 3")), nil)
+	if err == nil || !strings.Contains(err.Error(), "exit code 3: VM does not exist") {
+		t.Fatalf("expected the exit code and stderr to be reported, got %v", err)
+	}
+
+	var result map[string]interface{}
+	err = config.RunScriptWithResult(context.Background(), template.Must(template.New("script").Parse("echo 'not json'")), nil, &result)
+	if err == nil || !strings.Contains(err.Error(), "failed to unmarshal JSON result") {
+		t.Fatalf("expected invalid JSON to be reported, got %v", err)
+	}
+}
+
+func TestRunScriptCanceled(t *testing.T) {
+	t.Parallel()
+
+	config := newStubConfig(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+	err := config.RunFireAndForgetScript(ctx, template.Must(template.New("script").Parse("sleep 30")), nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	if elapsed := time.Since(started); elapsed > 10*time.Second {
+		t.Fatalf("expected the script to be killed promptly, took %s", elapsed)
+	}
+}
+
+func TestRunScriptMissingExecutable(t *testing.T) {
+	t.Parallel()
+
+	config := &ClientConfig{PowerShell: filepath.Join(t.TempDir(), "missing"), ScriptDir: t.TempDir()}
+
+	err := config.RunFireAndForgetScript(context.Background(), template.Must(template.New("script").Parse("Get-VM")), nil)
+	if err == nil || !strings.Contains(err.Error(), "command execution failed") {
+		t.Fatalf("expected a missing executable to be reported, got %v", err)
+	}
+}
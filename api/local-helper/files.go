@@ -0,0 +1,172 @@
+package local_helper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// UploadFile copies a local file to remoteFilePath. When remoteFilePath is empty or ends with a
+// path separator the file name is kept, in the temporary directory or in that directory respectively.
+func (c *ClientConfig) UploadFile(ctx context.Context, filePath string, remoteFilePath string) (resolvedRemoteFilePath string, err error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	if remoteFilePath == "" {
+		remoteFilePath = os.TempDir() + string(os.PathSeparator)
+	}
+
+	if strings.HasSuffix(remoteFilePath, "/") || strings.HasSuffix(remoteFilePath, string(os.PathSeparator)) {
+		remoteFilePath = filepath.Join(remoteFilePath, filepath.Base(filePath))
+	}
+
+	log.Printf("[INFO] Copying file %s to %s", filePath, remoteFilePath)
+
+	if err := copyFile(filePath, remoteFilePath); err != nil {
+		return "", err
+	}
+
+	return remoteFilePath, nil
+}
+
+// UploadDirectory copies the files below rootPath to a new temporary directory. Files whose path
+// relative to rootPath matches a pattern of excludeList are skipped.
+func (c *ClientConfig) UploadDirectory(ctx context.Context, rootPath string, excludeList []string) (remoteRootPath string, remoteAbsoluteFilePaths []string, err error) {
+	log.Printf("[DEBUG] Copying directory %s", rootPath)
+
+	remoteRootPath, err = os.MkdirTemp("", "hyperv-upload-")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	remoteAbsoluteFilePaths = []string{}
+	err = filepath.WalkDir(rootPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(rootPath, path)
+		if err != nil {
+			return err
+		}
+
+		for _, exclude := range excludeList {
+			if matched, _ := filepath.Match(exclude, relPath); matched {
+				log.Printf("[DEBUG] Skipping excluded file: %s", relPath)
+				return nil
+			}
+		}
+
+		remotePath := filepath.Join(remoteRootPath, relPath)
+		if err := copyFile(path, remotePath); err != nil {
+			return err
+		}
+
+		remoteAbsoluteFilePaths = append(remoteAbsoluteFilePaths, remotePath)
+		return nil
+	})
+
+	if err != nil {
+		_ = os.RemoveAll(remoteRootPath)
+		return "", nil, fmt.Errorf("failed to copy directory: %w", err)
+	}
+
+	log.Printf("[DEBUG] Copied directory to %s with %d files", remoteRootPath, len(remoteAbsoluteFilePaths))
+	return remoteRootPath, remoteAbsoluteFilePaths, nil
+}
+
+func copyFile(source string, destination string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return fmt.Errorf("failed to open local file: %w", err)
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(destination), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", destination, err)
+	}
+
+	out, err := os.Create(destination)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", destination, err)
+	}
+
+	_, err = io.Copy(out, in)
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", source, destination, err)
+	}
+
+	return nil
+}
+
+// FileExists checks if a file exists on the local system
+func (c *ClientConfig) FileExists(ctx context.Context, remoteFilePath string) (bool, error) {
+	info, exists, err := stat(ctx, remoteFilePath)
+	if err != nil || !exists {
+		return false, err
+	}
+
+	return !info.IsDir(), nil
+}
+
+// DirectoryExists checks if a directory exists on the local system
+func (c *ClientConfig) DirectoryExists(ctx context.Context, remoteDirectoryPath string) (bool, error) {
+	info, exists, err := stat(ctx, remoteDirectoryPath)
+	if err != nil || !exists {
+		return false, err
+	}
+
+	return info.IsDir(), nil
+}
+
+func stat(ctx context.Context, path string) (info fs.FileInfo, exists bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+
+	info, err = os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	}
+
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to check %s: %w", path, err)
+	}
+
+	return info, true, nil
+}
+
+// DeleteFileOrDirectory removes a file or directory from the local system
+func (c *ClientConfig) DeleteFileOrDirectory(ctx context.Context, remotePath string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	log.Printf("[DEBUG] Deleting file or directory: %s", remotePath)
+
+	if err := os.RemoveAll(remotePath); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", remotePath, err)
+	}
+
+	return nil
+}
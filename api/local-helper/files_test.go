@@ -0,0 +1,129 @@
+package local_helper
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, path string, content string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+}
+
+func TestUploadFile(t *testing.T) {
+	t.Parallel()
+
+	config := &ClientConfig{}
+	source := filepath.Join(t.TempDir(), "disk.vhdx")
+	writeTestFile(t, source, "disk")
+
+	destinationDir := t.TempDir()
+
+	tests := []struct {
+		name     string
+		remote   string
+		expected string
+	}{
+		{name: "file path", remote: filepath.Join(destinationDir, "nested", "copy.vhdx"), expected: filepath.Join(destinationDir, "nested", "copy.vhdx")},
+		{name: "directory path", remote: destinationDir + string(os.PathSeparator), expected: filepath.Join(destinationDir, "disk.vhdx")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			resolved, err := config.UploadFile(context.Background(), source, tt.remote)
+			if err != nil {
+				t.Fatalf("expected copy to succeed, got %v", err)
+			}
+
+			if resolved != tt.expected {
+				t.Fatalf("expected %s, got %s", tt.expected, resolved)
+			}
+
+			content, err := os.ReadFile(resolved)
+			if err != nil || string(content) != "disk" {
+				t.Fatalf("unexpected copied content %q (%v)", content, err)
+			}
+		})
+	}
+}
+
+func TestUploadDirectory(t *testing.T) {
+	t.Parallel()
+
+	config := &ClientConfig{}
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "autounattend.xml"), "answer")
+	writeTestFile(t, filepath.Join(root, "scripts", "setup.ps1"), "setup")
+	writeTestFile(t, filepath.Join(root, "notes.tmp"), "skip")
+
+	remoteRoot, remotePaths, err := config.UploadDirectory(context.Background(), root, []string{"*.tmp"})
+	if err != nil {
+		t.Fatalf("expected directory copy to succeed, got %v", err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(remoteRoot) })
+
+	sort.Strings(remotePaths)
+	expected := []string{filepath.Join(remoteRoot, "autounattend.xml"), filepath.Join(remoteRoot, "scripts", "setup.ps1")}
+	if len(remotePaths) != len(expected) || remotePaths[0] != expected[0] || remotePaths[1] != expected[1] {
+		t.Fatalf("expected %v, got %v", expected, remotePaths)
+	}
+
+	content, err := os.ReadFile(expected[1])
+	if err != nil || string(content) != "setup" {
+		t.Fatalf("unexpected copied content %q (%v)", content, err)
+	}
+}
+
+func TestExistsAndDelete(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	config := &ClientConfig{}
+	dir := t.TempDir()
+	file := filepath.Join(dir, "sub", "file.txt")
+	writeTestFile(t, file, "content")
+
+	checks := []struct {
+		name  string
+		check func(context.Context, string) (bool, error)
+		path  string
+		want  bool
+	}{
+		{name: "file exists", check: config.FileExists, path: file, want: true},
+		{name: "directory is not a file", check: config.FileExists, path: dir, want: false},
+		{name: "missing file", check: config.FileExists, path: filepath.Join(dir, "missing"), want: false},
+		{name: "directory exists", check: config.DirectoryExists, path: filepath.Join(dir, "sub"), want: true},
+		{name: "file is not a directory", check: config.DirectoryExists, path: file, want: false},
+	}
+
+	for _, tt := range checks {
+		got, err := tt.check(ctx, tt.path)
+		if err != nil || got != tt.want {
+			t.Fatalf("%s: expected %t, got %t (%v)", tt.name, tt.want, got, err)
+		}
+	}
+
+	if err := config.DeleteFileOrDirectory(ctx, filepath.Join(dir, "sub")); err != nil {
+		t.Fatalf("expected delete to succeed, got %v", err)
+	}
+
+	if exists, err := config.DirectoryExists(ctx, filepath.Join(dir, "sub")); err != nil || exists {
+		t.Fatalf("expected directory to be deleted, got %t (%v)", exists, err)
+	}
+
+	if err := config.DeleteFileOrDirectory(ctx, filepath.Join(dir, "missing")); err != nil {
+		t.Fatalf("expected deleting a missing path to succeed, got %v", err)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package local_helper
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// configureProcessTree starts the command in its own process group and kills the whole group when
+// the command is cancelled, so programs started by the script do not outlive it.
+func configureProcessTree(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build windows
+
+package local_helper
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+// configureProcessTree kills the whole process tree when the command is cancelled, so programs
+// started by the script do not outlive it.
+func configureProcessTree(cmd *exec.Cmd) {
+	cmd.Cancel = func() error {
+		return exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run()
+	}
+}
@@ -0,0 +1,284 @@
+// Package proxy opens the connections of the transports through an HTTP CONNECT or SOCKS5 proxy, set explicitly or
+// by the ALL_PROXY, HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables.
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+	xproxy "golang.org/x/net/proxy"
+)
+
+const (
+	SchemeHTTP    = "http"
+	SchemeHTTPS   = "https"
+	SchemeSOCKS5  = "socks5"
+	SchemeSOCKS5H = "socks5h"
+)
+
+// Schemes lists the proxies connections can go through
+var Schemes = map[string]string{
+	SchemeHTTP:    "HTTP proxy, connections are tunnelled with CONNECT",
+	SchemeHTTPS:   "HTTP proxy reached over TLS, connections are tunnelled with CONNECT",
+	SchemeSOCKS5:  "SOCKS5 proxy, host names are resolved by the proxy",
+	SchemeSOCKS5H: "SOCKS5 proxy, host names are resolved by the proxy",
+}
+
+// defaultPorts are the ports of proxies whose URL has none
+var defaultPorts = map[string]string{
+	SchemeHTTP:    "80",
+	SchemeHTTPS:   "443",
+	SchemeSOCKS5:  "1080",
+	SchemeSOCKS5H: "1080",
+}
+
+// Proxy opens connections through the proxy of their destination, if any.
+type Proxy struct {
+	proxyFunc func(target *url.URL) (*url.URL, error)
+	dialer    *net.Dialer
+}
+
+// New returns a proxy sending every connection through the proxy at rawURL. When rawURL is empty the proxy is taken
+// from the environment instead, see FromEnvironment.
+func New(rawURL string) (*Proxy, error) {
+	if rawURL == "" {
+		return FromEnvironment(), nil
+	}
+
+	proxyURL, err := Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Proxy{
+		proxyFunc: func(target *url.URL) (*url.URL, error) {
+			return proxyURL, nil
+		},
+		dialer: newDialer(),
+	}, nil
+}
+
+// FromEnvironment returns a proxy sending connections through the proxy set by ALL_PROXY, otherwise by HTTPS_PROXY,
+// or HTTP_PROXY for WinRM requests over HTTP. Connections to the hosts listed by NO_PROXY and to the loopback
+// interface are opened directly. Upper case variables take precedence over lower case ones.
+func FromEnvironment() *Proxy {
+	return fromEnvironment(os.Getenv)
+}
+
+func fromEnvironment(getenv func(string) string) *Proxy {
+	lookup := func(names ...string) string {
+		for _, name := range names {
+			if value := getenv(name); value != "" {
+				return value
+			}
+		}
+		return ""
+	}
+
+	allProxy := lookup("ALL_PROXY", "all_proxy")
+	config := &httpproxy.Config{
+		HTTPProxy:  lookup("HTTP_PROXY", "http_proxy"),
+		HTTPSProxy: lookup("HTTPS_PROXY", "https_proxy"),
+		NoProxy:    lookup("NO_PROXY", "no_proxy"),
+	}
+	if allProxy != "" {
+		config.HTTPProxy = allProxy
+		config.HTTPSProxy = allProxy
+	}
+	proxyFunc := config.ProxyFunc()
+
+	return &Proxy{
+		proxyFunc: func(target *url.URL) (*url.URL, error) {
+			proxyURL, err := proxyFunc(target)
+			if err != nil || proxyURL == nil {
+				return nil, err
+			}
+			if _, ok := Schemes[proxyURL.Scheme]; !ok {
+				return nil, fmt.Errorf("unsupported proxy scheme %q in the environment, expected http, https, socks5 or socks5h", proxyURL.Scheme)
+			}
+			return proxyURL, nil
+		},
+		dialer: newDialer(),
+	}
+}
+
+func newDialer() *net.Dialer {
+	return &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+}
+
+// Parse parses the URL of a proxy, one of the Schemes with a host and optionally a user and password. Errors do not
+// include the password.
+func Parse(rawURL string) (*url.URL, error) {
+	proxyURL, err := url.Parse(rawURL)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+
+	if _, ok := Schemes[proxyURL.Scheme]; !ok {
+		return nil, fmt.Errorf("invalid proxy URL %s: expected the scheme to be http, https, socks5 or socks5h", proxyURL.Redacted())
+	}
+	if proxyURL.Hostname() == "" {
+		return nil, fmt.Errorf("invalid proxy URL %s: no host", proxyURL.Redacted())
+	}
+
+	return proxyURL, nil
+}
+
+// HTTPProxy returns the proxy of req, it is meant for http.Transport.Proxy. Requests without a proxy return nil.
+func (p *Proxy) HTTPProxy(req *http.Request) (*url.URL, error) {
+	return p.proxyFunc(req.URL)
+}
+
+// Dial opens a connection to addr like DialContext, without a context.
+func (p *Proxy) Dial(network string, addr string) (net.Conn, error) {
+	return p.DialContext(context.Background(), network, addr)
+}
+
+// DialContext opens a TCP connection to addr through its proxy, or directly when it has none.
+func (p *Proxy) DialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	proxyURL, err := p.proxyFunc(&url.URL{Scheme: SchemeHTTPS, Host: addr})
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL == nil {
+		return p.dialer.DialContext(ctx, network, addr)
+	}
+
+	log.Printf("[DEBUG] Connecting to %s through proxy %s", addr, proxyURL.Redacted())
+
+	var conn net.Conn
+	switch proxyURL.Scheme {
+	case SchemeSOCKS5, SchemeSOCKS5H:
+		conn, err = p.dialSOCKS5(ctx, proxyURL, network, addr)
+	default:
+		conn, err = p.dialConnect(ctx, proxyURL, addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s through proxy %s: %w", addr, proxyURL.Redacted(), err)
+	}
+
+	return conn, nil
+}
+
+func (p *Proxy) dialSOCKS5(ctx context.Context, proxyURL *url.URL, network string, addr string) (net.Conn, error) {
+	var auth *xproxy.Auth
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		auth = &xproxy.Auth{User: proxyURL.User.Username(), Password: password}
+	}
+
+	dialer, err := xproxy.SOCKS5("tcp", proxyAddress(proxyURL), auth, p.dialer)
+	if err != nil {
+		return nil, err
+	}
+
+	contextDialer, ok := dialer.(xproxy.ContextDialer)
+	if !ok {
+		return dialer.Dial(network, addr)
+	}
+
+	return contextDialer.DialContext(ctx, network, addr)
+}
+
+// dialConnect opens a tunnel to addr with an HTTP CONNECT request, authenticated with the user and password of the
+// proxy URL when it has them
+func (p *Proxy) dialConnect(ctx context.Context, proxyURL *url.URL, addr string) (net.Conn, error) {
+	conn, err := p.dialer.DialContext(ctx, "tcp", proxyAddress(proxyURL))
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if proxyURL.Scheme == SchemeHTTPS {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname(), MinVersion: tls.VersionTLS12})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxyURL.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	if err := req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusProxyAuthRequired:
+		_ = conn.Close()
+		return nil, fmt.Errorf("proxy authentication failed (%s), check the user and password of the proxy URL", resp.Status)
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		_ = conn.Close()
+		return nil, fmt.Errorf("proxy refused the connection: %s", resp.Status)
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+
+	if reader.Buffered() > 0 {
+		// The server may speak first, as SSH servers do, its first bytes can be read with the response
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+
+	return conn, nil
+}
+
+// proxyAddress returns the host and port of the proxy, with the default port of its scheme when it has none
+func proxyAddress(proxyURL *url.URL) string {
+	port := proxyURL.Port()
+	if port == "" {
+		port = defaultPorts[proxyURL.Scheme]
+	}
+
+	return net.JoinHostPort(proxyURL.Hostname(), port)
+}
+
+// bufferedConn reads what was buffered while reading the response of the proxy before reading from the connection
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
@@ -0,0 +1,295 @@
+package pssession_helper
+
+// Persistent PowerShell session helper.
+//
+// Scripts are sent to long-lived PowerShell host processes instead of starting a new interpreter
+// for every script, which saves the interpreter startup and the Hyper-V module import on each
+// call. Host processes are started by the transport through Start, kept in a pool and replaced
+// when they die. File operations are delegated to Files.
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"text/template"
+	"time"
+
+	pool "github.com/jolestar/go-commons-pool/v2"
+	"github.com/taliesins/terraform-provider-hyperv/api/commandresult"
+	"github.com/taliesins/terraform-provider-hyperv/api/redact"
+)
+
+const (
+	// DefaultMaxSessions is the maximum number of PowerShell host processes kept per host.
+	DefaultMaxSessions = 5
+
+	// DefaultStartTimeout is how long a PowerShell host process may take to become ready.
+	DefaultStartTimeout = 2 * time.Minute
+
+	// DefaultSessionIdleTimeout is how long a PowerShell host process may stay idle before it is stopped.
+	DefaultSessionIdleTimeout = 10 * time.Minute
+
+	sessionEvictionInterval = 30 * time.Second
+
+	pingTimeout = 30 * time.Second
+)
+
+// New creates a new persistent session provider
+func New(clientConfig *ClientConfig) (*Provider, error) {
+	if clientConfig.Start == nil {
+		return nil, fmt.Errorf("a start function for the PowerShell host process is required")
+	}
+
+	if clientConfig.Files == nil {
+		return nil, fmt.Errorf("a file transfer client is required")
+	}
+
+	clientConfig.sessionPool = newSessionPool(context.Background(), clientConfig)
+
+	return &Provider{
+		Client: clientConfig,
+	}, nil
+}
+
+// FileTransfer performs the file operations of the client, typically with the transport the
+// PowerShell host processes are started on.
+type FileTransfer interface {
+	UploadFile(ctx context.Context, filePath string, remoteFilePath string) (resolvedRemoteFilePath string, err error)
+	UploadDirectory(ctx context.Context, rootPath string, excludeList []string) (remoteRootPath string, remoteAbsoluteFilePaths []string, err error)
+	FileExists(ctx context.Context, remoteFilePath string) (exists bool, err error)
+	DirectoryExists(ctx context.Context, remoteDirectoryPath string) (exists bool, err error)
+	DeleteFileOrDirectory(ctx context.Context, remotePath string) (err error)
+}
+
+type ClientConfig struct {
+	Start        StartFunc     // Starts a PowerShell host process, e.g. ssh_helper.ClientConfig.StartPowerShell
+	Files        FileTransfer  // Performs file operations
+	MaxSessions  int           // Optional: number of PowerShell host processes (default: DefaultMaxSessions)
+	StartTimeout time.Duration // Optional: time allowed for a host process to start (default: DefaultStartTimeout)
+
+	Redactor *redact.Redactor // Optional: masks secrets in logged scripts and in errors
+
+	sessionPool *pool.ObjectPool
+}
+
+func (c *ClientConfig) maxSessions() int {
+	if c.MaxSessions <= 0 {
+		return DefaultMaxSessions
+	}
+
+	return c.MaxSessions
+}
+
+func (c *ClientConfig) startTimeout() time.Duration {
+	if c.StartTimeout <= 0 {
+		return DefaultStartTimeout
+	}
+
+	return c.StartTimeout
+}
+
+// newSessionPool creates the pool of PowerShell host processes. Idle processes are kept warm up to
+// MaxSessions, stopped after DefaultSessionIdleTimeout and pinged before they are handed out.
+func newSessionPool(ctx context.Context, c *ClientConfig) *pool.ObjectPool {
+	factory := pool.NewPooledObjectFactory(
+		func(ctx context.Context) (interface{}, error) {
+			ctx, cancel := context.WithTimeout(ctx, c.startTimeout())
+			defer cancel()
+
+			s, err := startSession(ctx, c.Start, c.Redactor)
+			if err != nil {
+				return nil, err
+			}
+
+			log.Printf("[DEBUG] Started PowerShell host process")
+			return s, nil
+		},
+		func(ctx context.Context, object *pool.PooledObject) error {
+			s, ok := object.Object.(*session)
+			if !ok {
+				return fmt.Errorf("failed to cast pooled object to *session")
+			}
+
+			log.Printf("[DEBUG] Stopping PowerShell host process")
+			return s.close()
+		},
+		func(ctx context.Context, object *pool.PooledObject) bool {
+			s, ok := object.Object.(*session)
+			if !ok || s.broken {
+				return false
+			}
+
+			ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+			defer cancel()
+
+			_, _, exitCode, err := s.run(ctx, "", nil)
+			return err == nil && exitCode == 0
+		},
+		nil,
+		nil,
+	)
+
+	config := pool.NewDefaultPoolConfig()
+	config.BlockWhenExhausted = true
+	config.MinIdle = 0
+	config.MaxIdle = c.maxSessions()
+	config.MaxTotal = c.maxSessions()
+	config.TestOnBorrow = true
+	config.MinEvictableIdleTime = DefaultSessionIdleTimeout
+	config.TimeBetweenEvictionRuns = sessionEvictionInterval
+	config.EvictionContext = ctx
+
+	return pool.NewObjectPool(ctx, factory, config)
+}
+
+// Close stops every PowerShell host process, then closes Files when it is an io.Closer.
+func (c *ClientConfig) Close() error {
+	if c.sessionPool != nil {
+		c.sessionPool.Close(context.Background())
+	}
+
+	if closer, ok := c.Files.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// RunScript runs a rendered script on a pooled PowerShell host process. Processes that died or were
+// interrupted are discarded, and the script is retried once on a new process if it could not be
+// sent to the previous one.
+func (c *ClientConfig) RunScript(ctx context.Context, script string) (stdout, stderr string, exitCode int, err error) {
+	return c.RunScriptStreaming(ctx, script, nil)
+}
+
+// RunScriptStreaming runs a rendered script like RunScript, copying the records it writes to output while it runs
+func (c *ClientConfig) RunScriptStreaming(ctx context.Context, script string, output io.Writer) (stdout, stderr string, exitCode int, err error) {
+	if err := ctx.Err(); err != nil {
+		return "", "", -1, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		object, err := c.sessionPool.BorrowObject(ctx)
+		if err != nil {
+			return "", "", -1, err
+		}
+
+		s, ok := object.(*session)
+		if !ok {
+			if returnErr := c.sessionPool.ReturnObject(ctx, object); returnErr != nil {
+				return "", "", -1, fmt.Errorf("failed to cast pooled object to *session: additionally failed returning session to pool: %w", returnErr)
+			}
+			return "", "", -1, fmt.Errorf("failed to cast pooled object to *session")
+		}
+
+		stdout, stderr, exitCode, err = s.run(ctx, script, output)
+
+		if s.broken {
+			if invalidateErr := c.sessionPool.InvalidateObject(context.Background(), object); invalidateErr != nil {
+				log.Printf("[DEBUG] Failed to stop broken PowerShell host process: %v", invalidateErr)
+			}
+
+			var writeErr *requestWriteError
+			if attempt == 1 && errors.As(err, &writeErr) {
+				log.Printf("[DEBUG] PowerShell host process died, restarting: %v", err)
+				continue
+			}
+
+			return stdout, stderr, exitCode, err
+		}
+
+		if returnErr := c.sessionPool.ReturnObject(ctx, object); returnErr != nil && err == nil {
+			return stdout, stderr, exitCode, returnErr
+		}
+
+		return stdout, stderr, exitCode, err
+	}
+}
+
+// RunFireAndForgetScript executes a script without waiting for or processing results
+func (c *ClientConfig) RunFireAndForgetScript(ctx context.Context, script *template.Template, args interface{}) error {
+	var scriptRendered bytes.Buffer
+	err := script.Execute(&scriptRendered, args)
+	if err != nil {
+		return fmt.Errorf("failed to render script template: %w", err)
+	}
+
+	command := scriptRendered.String()
+	redactor := c.Redactor.WithArgs(args)
+	log.Printf("[DEBUG] Running fire and forget script:\n%s\n", redactor.String(command))
+
+	stdout, stderr, exitCode, err := c.RunScript(ctx, command)
+	if err != nil {
+		return redactor.Error(err)
+	}
+
+	return commandresult.CheckExitCode(redactor, exitCode, stdout, stderr)
+}
+
+// RunScriptWithResult executes a script and unmarshals JSON output into result
+func (c *ClientConfig) RunScriptWithResult(ctx context.Context, script *template.Template, args interface{}, result interface{}) error {
+	var scriptRendered bytes.Buffer
+	err := script.Execute(&scriptRendered, args)
+	if err != nil {
+		return fmt.Errorf("failed to render script template: %w", err)
+	}
+
+	command := scriptRendered.String()
+	redactor := c.Redactor.WithArgs(args)
+	log.Printf("[DEBUG] Running script with result:\n%s\n", redactor.String(command))
+
+	stdout, stderr, exitCode, err := c.RunScript(ctx, command)
+	if err != nil {
+		return redactor.Error(err)
+	}
+
+	return commandresult.DecodeJSON(redactor, exitCode, stdout, stderr, command, result)
+}
+
+// RunStreamingScript executes a script, passing its records to records while it runs, and unmarshals JSON output into
+// result unless result is nil
+func (c *ClientConfig) RunStreamingScript(ctx context.Context, script *template.Template, args interface{}, result interface{}, records commandresult.RecordHandler) error {
+	var scriptRendered bytes.Buffer
+	err := script.Execute(&scriptRendered, args)
+	if err != nil {
+		return fmt.Errorf("failed to render script template: %w", err)
+	}
+
+	command := scriptRendered.String()
+	redactor := c.Redactor.WithArgs(args)
+	log.Printf("[DEBUG] Running streaming script:\n%s\n", redactor.String(command))
+
+	stdout, stderr, exitCode, err := c.RunScriptStreaming(ctx, command, commandresult.NewRecordWriter(redactor, records))
+	if err != nil {
+		return redactor.Error(err)
+	}
+
+	if result == nil {
+		return commandresult.CheckExitCode(redactor, exitCode, stdout, stderr)
+	}
+
+	return commandresult.DecodeJSON(redactor, exitCode, stdout, stderr, command, result)
+}
+
+func (c *ClientConfig) UploadFile(ctx context.Context, filePath string, remoteFilePath string) (resolvedRemoteFilePath string, err error) {
+	return c.Files.UploadFile(ctx, filePath, remoteFilePath)
+}
+
+func (c *ClientConfig) UploadDirectory(ctx context.Context, rootPath string, excludeList []string) (remoteRootPath string, remoteAbsoluteFilePaths []string, err error) {
+	return c.Files.UploadDirectory(ctx, rootPath, excludeList)
+}
+
+func (c *ClientConfig) FileExists(ctx context.Context, remoteFilePath string) (exists bool, err error) {
+	return c.Files.FileExists(ctx, remoteFilePath)
+}
+
+func (c *ClientConfig) DirectoryExists(ctx context.Context, remoteDirectoryPath string) (exists bool, err error) {
+	return c.Files.DirectoryExists(ctx, remoteDirectoryPath)
+}
+
+func (c *ClientConfig) DeleteFileOrDirectory(ctx context.Context, remotePath string) (err error) {
+	return c.Files.DeleteFileOrDirectory(ctx, remotePath)
+}
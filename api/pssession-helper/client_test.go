@@ -0,0 +1,338 @@
+package pssession_helper
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/taliesins/terraform-provider-hyperv/api/commandresult"
+)
+
+// fakeHost emulates PowerShell host processes speaking the session protocol. Scripts are answered
+// by handler, except for a few commands that control the process itself:
+//
+//	die       the process exits without answering
+//	hang      the process never answers
+//	noise     unframed output is written before the answer
+//	progress  a record is written before the answer
+type fakeHost struct {
+	t       *testing.T
+	handler func(script string) (stdout string, stderr string, exitCode int)
+
+	starts    atomic.Int32
+	startErr  error
+	startDies bool
+
+	mu  sync.Mutex
+	ids []uint64
+}
+
+type fakeProcess struct {
+	stdinReader  *io.PipeReader
+	stdinWriter  *io.PipeWriter
+	stdoutReader *io.PipeReader
+	stdoutWriter *io.PipeWriter
+}
+
+func (p *fakeProcess) Read(b []byte) (int, error) {
+	return p.stdoutReader.Read(b)
+}
+
+func (p *fakeProcess) Write(b []byte) (int, error) {
+	return p.stdinWriter.Write(b)
+}
+
+func (p *fakeProcess) Close() error {
+	_ = p.stdinReader.Close()
+	_ = p.stdoutWriter.Close()
+	return nil
+}
+
+func (h *fakeHost) start(ctx context.Context, script string, stderr io.Writer) (io.ReadWriteCloser, error) {
+	if !strings.Contains(script, frameMarker) {
+		h.t.Errorf("expected the host script to be started, got %q", script)
+	}
+
+	if h.startErr != nil {
+		return nil, h.startErr
+	}
+
+	h.starts.Add(1)
+
+	process := &fakeProcess{}
+	process.stdinReader, process.stdinWriter = io.Pipe()
+	process.stdoutReader, process.stdoutWriter = io.Pipe()
+
+	if h.startDies {
+		_, _ = io.WriteString(stderr, "The term 'pwsh' is not recognized\r\n")
+		_ = process.Close()
+		return process, nil
+	}
+
+	go h.serve(process)
+
+	return process, nil
+}
+
+func (h *fakeHost) serve(process *fakeProcess) {
+	defer process.Close()
+
+	writeFrame := func(result response) {
+		frame, _ := json.Marshal(result)
+		_, _ = fmt.Fprintf(process.stdoutWriter, "%s%s\r\n", frameMarker, frame)
+	}
+
+	writeFrame(response{ID: 0})
+
+	scanner := bufio.NewScanner(process.stdinReader)
+	for scanner.Scan() {
+		var req request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			return
+		}
+
+		h.mu.Lock()
+		h.ids = append(h.ids, req.ID)
+		h.mu.Unlock()
+
+		switch req.Script {
+		case "":
+			writeFrame(response{ID: req.ID})
+			continue
+		case "die":
+			return
+		case "hang":
+			_, _ = io.Copy(io.Discard, process.stdinReader)
+			return
+		case "noise":
+			_, _ = io.WriteString(process.stdoutWriter, "WARNING: written by Write-Host\r\n")
+		case "progress":
+			_, _ = io.WriteString(process.stdoutWriter, commandresult.RecordMarker+`{"Stream":"Progress","Activity":"Exporting VM web","Status":"Copying","PercentComplete":30}`+"\r\n")
+		}
+
+		stdout, stderr, exitCode := h.handler(req.Script)
+		writeFrame(response{ID: req.ID, ExitCode: exitCode, Stdout: stdout, Stderr: stderr})
+	}
+}
+
+func jsonHandler(script string) (string, string, int) {
+	if strings.HasPrefix(script, "throw ") {
+		return "", strings.TrimPrefix(script, "throw "), 1
+	}
+
+	return fmt.Sprintf("{\"script\":%q}", script), "", 0
+}
+
+func newTestClient(t *testing.T, host *fakeHost) *ClientConfig {
+	t.Helper()
+
+	provider, err := New(&ClientConfig{
+		Start:        host.start,
+		Files:        noFiles{},
+		StartTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	client, ok := provider.Client.(*ClientConfig)
+	if !ok {
+		t.Fatalf("unexpected client type %T", provider.Client)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+
+	return client
+}
+
+type noFiles struct{}
+
+func (noFiles) UploadFile(ctx context.Context, filePath string, remoteFilePath string) (string, error) {
+	return "", errors.New("not supported")
+}
+
+func (noFiles) UploadDirectory(ctx context.Context, rootPath string, excludeList []string) (string, []string, error) {
+	return "", nil, errors.New("not supported")
+}
+
+func (noFiles) FileExists(ctx context.Context, remoteFilePath string) (bool, error) {
+	return false, errors.New("not supported")
+}
+
+func (noFiles) DirectoryExists(ctx context.Context, remoteDirectoryPath string) (bool, error) {
+	return false, errors.New("not supported")
+}
+
+func (noFiles) DeleteFileOrDirectory(ctx context.Context, remotePath string) error {
+	return errors.New("not supported")
+}
+
+func runScript(t *testing.T, client *ClientConfig, script string) (string, error) {
+	t.Helper()
+
+	var result struct{ Script string }
+	err := client.RunScriptWithResult(context.Background(), template.Must(template.New("script").Parse(script)), nil, &result)
+
+	return result.Script, err
+}
+
+func TestRunScriptWithResultReusesHostProcess(t *testing.T) {
+	t.Parallel()
+
+	host := &fakeHost{t: t, handler: jsonHandler}
+	client := newTestClient(t, host)
+
+	for _, script := range []string{"Get-VM", "Get-VMSwitch", "noise"} {
+		got, err := runScript(t, client, script)
+		if err != nil {
+			t.Fatalf("expected %q to succeed, got %v", script, err)
+		}
+
+		if got != script {
+			t.Fatalf("expected result for %q, got %q", script, got)
+		}
+	}
+
+	if got := host.starts.Load(); got != 1 {
+		t.Fatalf("expected a single host process, got %d", got)
+	}
+
+	host.mu.Lock()
+	defer host.mu.Unlock()
+	for i := 1; i < len(host.ids); i++ {
+		if host.ids[i] <= host.ids[i-1] {
+			t.Fatalf("expected increasing request ids, got %v", host.ids)
+		}
+	}
+}
+
+func TestRunStreamingScript(t *testing.T) {
+	t.Parallel()
+
+	host := &fakeHost{t: t, handler: jsonHandler}
+	client := newTestClient(t, host)
+
+	var records []commandresult.Record
+	var result struct{ Script string }
+	err := client.RunStreamingScript(context.Background(), template.Must(template.New("script").Parse("progress")), nil, &result, func(record commandresult.Record) {
+		records = append(records, record)
+	})
+	if err != nil {
+		t.Fatalf("expected the script to succeed, got %v", err)
+	}
+
+	if result.Script != "progress" {
+		t.Fatalf("expected the result of the script, got %+v", result)
+	}
+
+	want := []commandresult.Record{{Stream: commandresult.RecordProgress, Activity: "Exporting VM web", Status: "Copying", PercentComplete: 30}}
+	if !reflect.DeepEqual(records, want) {
+		t.Fatalf("expected records %+v, got %+v", want, records)
+	}
+}
+
+func TestRunScriptReportsScriptErrors(t *testing.T) {
+	t.Parallel()
+
+	host := &fakeHost{t: t, handler: jsonHandler}
+	client := newTestClient(t, host)
+
+	_, err := runScript(t, client, "throw VM does not exist - web")
+	if err == nil || !strings.Contains(err.Error(), "VM does not exist - web") {
+		t.Fatalf("expected the script error to be reported, got %v", err)
+	}
+
+	err = client.RunFireAndForgetScript(context.Background(), template.Must(template.New("script").Parse("throw access denied")), nil)
+	if err == nil || !strings.Contains(err.Error(), "exit code 1: access denied") {
+		t.Fatalf("expected the script error to be reported, got %v", err)
+	}
+
+	if _, err := runScript(t, client, "Get-VM"); err != nil {
+		t.Fatalf("expected the host process to keep serving requests after a script error, got %v", err)
+	}
+
+	if got := host.starts.Load(); got != 1 {
+		t.Fatalf("expected script errors to keep the host process, got %d starts", got)
+	}
+}
+
+func TestRunScriptRestartsDeadHostProcess(t *testing.T) {
+	t.Parallel()
+
+	host := &fakeHost{t: t, handler: jsonHandler}
+	client := newTestClient(t, host)
+
+	if _, err := runScript(t, client, "die"); err == nil || !strings.Contains(err.Error(), "exited") {
+		t.Fatalf("expected the host process exit to be reported, got %v", err)
+	}
+
+	got, err := runScript(t, client, "Get-VM")
+	if err != nil {
+		t.Fatalf("expected a new host process to serve the request, got %v", err)
+	}
+
+	if got != "Get-VM" {
+		t.Fatalf("unexpected result %q", got)
+	}
+
+	if got := host.starts.Load(); got != 2 {
+		t.Fatalf("expected the host process to be restarted, got %d starts", got)
+	}
+}
+
+func TestRunScriptCanceled(t *testing.T) {
+	t.Parallel()
+
+	host := &fakeHost{t: t, handler: jsonHandler}
+	client := newTestClient(t, host)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	_, _, _, err := client.RunScript(ctx, "hang")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	if _, err := runScript(t, client, "Get-VM"); err != nil {
+		t.Fatalf("expected a new host process after an interrupted request, got %v", err)
+	}
+
+	if got := host.starts.Load(); got != 2 {
+		t.Fatalf("expected the interrupted host process to be replaced, got %d starts", got)
+	}
+}
+
+func TestRunScriptHostStartFailure(t *testing.T) {
+	t.Parallel()
+
+	host := &fakeHost{t: t, handler: jsonHandler, startDies: true}
+	client := newTestClient(t, host)
+
+	_, err := runScript(t, client, "Get-VM")
+	if err == nil || !strings.Contains(err.Error(), "did not start") || !strings.Contains(err.Error(), "'pwsh' is not recognized") {
+		t.Fatalf("expected the start failure and host stderr to be reported, got %v", err)
+	}
+}
+
+func TestNewRequiresStartAndFiles(t *testing.T) {
+	t.Parallel()
+
+	if _, err := New(&ClientConfig{Files: noFiles{}}); err == nil {
+		t.Fatal("expected an error without a start function")
+	}
+
+	host := &fakeHost{t: t, handler: jsonHandler}
+	if _, err := New(&ClientConfig{Start: host.start}); err == nil {
+		t.Fatal("expected an error without a file transfer client")
+	}
+}
@@ -0,0 +1,82 @@
+package pssession_helper
+
+// Protocol spoken with the PowerShell host process.
+//
+// Requests are written to the standard input of the host, one JSON document per line:
+//
+//	{"id":1,"script":"Get-VM | ConvertTo-Json"}
+//
+// The host runs each script in a fresh pipeline on a runspace that lives as long as the process,
+// so modules such as Hyper-V are only imported once. Local scope is used for every script, so
+// variables and functions do not leak from one request to the next. The result is written to
+// standard output as a single line prefixed with frameMarker:
+//
+//	#terraform-hyperv#{"id":1,"exitCode":0,"stdout":"...","stderr":""}
+//
+// Lines without the marker, for example from Write-Host, are logged, except for the records that
+// wrapped scripts write while they run, see commandresult.RecordMarker, which are passed on. The
+// host announces that it is ready with a frame whose id is 0, and exits once its standard input is
+// closed.
+
+const frameMarker = "#terraform-hyperv#"
+
+type request struct {
+	ID     uint64 `json:"id"`
+	Script string `json:"script"`
+}
+
+type response struct {
+	ID       uint64 `json:"id"`
+	ExitCode int    `json:"exitCode"`
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+}
+
+// HostScript is the PowerShell script run by the host process. Terminating errors are reported as
+// exit code 1 with the error message on stderr, and exit codes set with exit are passed through
+// together with the output written before the script exited.
+const HostScript = `$ErrorActionPreference = 'Stop'
+$utf8 = New-Object System.Text.UTF8Encoding $false
+$reader = New-Object System.IO.StreamReader([Console]::OpenStandardInput(), $utf8)
+$writer = New-Object System.IO.StreamWriter([Console]::OpenStandardOutput(), $utf8)
+$writer.AutoFlush = $true
+$runspace = [runspacefactory]::CreateRunspace()
+$runspace.Open()
+function Invoke-Request($Script) {
+	$response = @{ exitCode = 0; stdout = ''; stderr = '' }
+	$ps = [powershell]::Create()
+	$ps.Runspace = $runspace
+	$inputData = New-Object 'System.Management.Automation.PSDataCollection[psobject]'
+	$inputData.Complete()
+	$output = New-Object 'System.Management.Automation.PSDataCollection[psobject]'
+	try {
+		$null = $ps.AddScript($Script, $true)
+		$null = $ps.EndInvoke($ps.BeginInvoke($inputData, $output))
+		$response.stderr = ($ps.Streams.Error | ForEach-Object { "$_" }) -join "` + "`" + `n"
+	} catch {
+		$exception = $_.Exception
+		while ($exception.InnerException -and $exception -is [System.Management.Automation.MethodInvocationException]) {
+			$exception = $exception.InnerException
+		}
+		if ($exception -is [System.Management.Automation.ExitException]) {
+			$response.exitCode = [int]$exception.Argument
+		} else {
+			$response.exitCode = 1
+			$response.stderr = $exception.Message
+		}
+	} finally {
+		$response.stdout = ($output | ForEach-Object { "$_" }) -join "` + "`" + `n"
+		$ps.Dispose()
+	}
+	$response
+}
+$null = Invoke-Request "` + "`" + `$global:ProgressPreference = 'SilentlyContinue'; Import-Module Hyper-V -ErrorAction SilentlyContinue"
+$writer.WriteLine('` + frameMarker + `{"id":0}')
+while ($null -ne ($line = $reader.ReadLine())) {
+	if (-not $line) { continue }
+	$request = ConvertFrom-Json $line
+	$response = Invoke-Request $request.script
+	$response.id = $request.id
+	$writer.WriteLine('` + frameMarker + `' + (ConvertTo-Json $response -Compress))
+}
+`
@@ -0,0 +1,203 @@
+package pssession_helper
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/taliesins/terraform-provider-hyperv/api/commandresult"
+	"github.com/taliesins/terraform-provider-hyperv/api/redact"
+)
+
+// maxStderrTail is how much of the standard error of a host process is kept for error messages.
+const maxStderrTail = 4 * 1024
+
+// StartFunc starts a long-lived PowerShell process running script. Writes go to the standard input
+// of the process, reads come from its standard output and its standard error is copied to stderr.
+// Closing the process must terminate it.
+type StartFunc func(ctx context.Context, script string, stderr io.Writer) (io.ReadWriteCloser, error)
+
+// session is a PowerShell host process serving one request at a time.
+type session struct {
+	process io.ReadWriteCloser
+	stdout  *bufio.Reader
+	stderr  *stderrTail
+	nextID  uint64
+	broken  bool
+
+	redactor *redact.Redactor
+}
+
+// requestWriteError marks a request that could not be written to the host. The script has not
+// been started, so the request is safe to retry on a new session.
+type requestWriteError struct {
+	err error
+}
+
+func (e *requestWriteError) Error() string {
+	return e.err.Error()
+}
+
+func (e *requestWriteError) Unwrap() error {
+	return e.err
+}
+
+// startSession starts a host process and waits until it is ready to serve requests.
+func startSession(ctx context.Context, start StartFunc, redactor *redact.Redactor) (*session, error) {
+	stderr := &stderrTail{}
+
+	process, err := start(ctx, HostScript, stderr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start PowerShell host: %w", err)
+	}
+
+	s := &session{
+		process: process,
+		stdout:  bufio.NewReader(process),
+		stderr:  stderr,
+
+		redactor: redactor,
+	}
+
+	if _, err := s.readResponse(ctx, 0, nil); err != nil {
+		_ = s.close()
+		return nil, fmt.Errorf("PowerShell host did not start: %w", err)
+	}
+
+	return s, nil
+}
+
+// run sends script to the host and waits for its result. Records the script writes while it runs
+// are copied to records, if any. When ctx is done the host process is killed, as it is the only way
+// to stop the script, and the error wraps ctx.Err().
+func (s *session) run(ctx context.Context, script string, records io.Writer) (stdout, stderr string, exitCode int, err error) {
+	if err := ctx.Err(); err != nil {
+		return "", "", -1, err
+	}
+
+	s.nextID++
+	id := s.nextID
+
+	frame, err := json.Marshal(request{ID: id, Script: script})
+	if err != nil {
+		return "", "", -1, fmt.Errorf("failed to encode request %d: %w", id, err)
+	}
+
+	if _, err := s.process.Write(append(frame, '\n')); err != nil {
+		s.broken = true
+		return "", "", -1, &requestWriteError{err: fmt.Errorf("failed to send request %d to PowerShell host: %w", id, err)}
+	}
+
+	result, err := s.readResponse(ctx, id, records)
+	if err != nil {
+		return "", "", -1, err
+	}
+
+	return result.Stdout, result.Stderr, result.ExitCode, nil
+}
+
+// readResponse reads frames until the response to request id arrives. Records that are not framed
+// are copied to records, other output that is not framed is logged, and responses to earlier
+// requests that were abandoned are skipped.
+func (s *session) readResponse(ctx context.Context, id uint64, records io.Writer) (*response, error) {
+	stop := context.AfterFunc(ctx, func() {
+		_ = s.process.Close()
+	})
+
+	result, err := s.readFrame(id, records)
+
+	if !stop() {
+		s.broken = true
+		return nil, fmt.Errorf("PowerShell request %d interrupted: %w", id, ctx.Err())
+	}
+
+	if err != nil {
+		s.broken = true
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (s *session) readFrame(id uint64, records io.Writer) (*response, error) {
+	for {
+		line, err := s.stdout.ReadString('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, fmt.Errorf("PowerShell host exited while waiting for request %d%s", id, s.stderr.suffix())
+			}
+			return nil, fmt.Errorf("failed to read response to request %d: %w%s", id, err, s.stderr.suffix())
+		}
+
+		line = strings.TrimRight(strings.TrimPrefix(line, "\ufeff"), "\r\n")
+		payload, ok := strings.CutPrefix(line, frameMarker)
+		if !ok {
+			if strings.HasPrefix(line, commandresult.RecordMarker) {
+				if records != nil {
+					_, _ = io.WriteString(records, line+"\n")
+				}
+			} else if line != "" {
+				log.Printf("[DEBUG] PowerShell host output: %s", s.redactor.String(line))
+			}
+			continue
+		}
+
+		var result response
+		if err := json.Unmarshal([]byte(payload), &result); err != nil {
+			return nil, fmt.Errorf("failed to decode response to request %d: %w", id, err)
+		}
+
+		if result.ID < id {
+			log.Printf("[DEBUG] Skipping response to abandoned PowerShell request %d", result.ID)
+			continue
+		}
+
+		if result.ID != id {
+			return nil, fmt.Errorf("received response to request %d while waiting for request %d", result.ID, id)
+		}
+
+		return &result, nil
+	}
+}
+
+func (s *session) close() error {
+	s.broken = true
+	return s.process.Close()
+}
+
+// stderrTail keeps the end of the standard error of a host process.
+type stderrTail struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (t *stderrTail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > maxStderrTail {
+		t.buf = t.buf[len(t.buf)-maxStderrTail:]
+	}
+
+	return len(p), nil
+}
+
+// suffix formats the captured standard error for appending to an error message.
+func (t *stderrTail) suffix() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stderr := strings.TrimSpace(string(t.buf))
+	if stderr == "" {
+		return ""
+	}
+
+	return ": " + stderr
+}
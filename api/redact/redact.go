@@ -0,0 +1,223 @@
+// Package redact masks secrets in the scripts, commands and output of the transports before they are logged or
+// returned in an error.
+package redact
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// Mask replaces every secret that is redacted.
+const Mask = "***"
+
+// Redactor masks known secrets, such as the passwords and key material of the provider configuration, and values
+// that look like secrets, such as the argument of -Password. A nil Redactor only masks the values that look like
+// secrets.
+type Redactor struct {
+	secrets []string
+}
+
+// New returns a redactor masking secrets. Empty secrets are ignored.
+func New(secrets ...string) *Redactor {
+	return (*Redactor)(nil).With(secrets...)
+}
+
+// With returns a redactor masking the secrets of r and secrets. A secret is also masked where it is escaped in JSON
+// or in a single quoted PowerShell string.
+func (r *Redactor) With(secrets ...string) *Redactor {
+	redactor := &Redactor{}
+	if r != nil {
+		redactor.secrets = append(redactor.secrets, r.secrets...)
+	}
+
+	for _, secret := range secrets {
+		if strings.TrimSpace(secret) == "" {
+			continue
+		}
+
+		redactor.secrets = append(redactor.secrets, secret, strings.ReplaceAll(secret, "'", "''"))
+		if secretJson, err := json.Marshal(secret); err == nil {
+			redactor.secrets = append(redactor.secrets, string(secretJson[1:len(secretJson)-1]))
+		}
+	}
+
+	// Longer secrets first, so that a secret containing another one is masked as a whole
+	sort.SliceStable(redactor.secrets, func(i, j int) bool {
+		return len(redactor.secrets[i]) > len(redactor.secrets[j])
+	})
+
+	return redactor
+}
+
+// WithArgs returns a redactor that also masks the string fields of the script arguments args tagged
+// `sensitive:"true"`, including the fields of nested structs, slices and maps.
+func (r *Redactor) WithArgs(args interface{}) *Redactor {
+	var secrets []string
+	collectSensitive(reflect.ValueOf(args), false, &secrets)
+	if len(secrets) == 0 {
+		return r
+	}
+
+	return r.With(secrets...)
+}
+
+func collectSensitive(v reflect.Value, sensitive bool, secrets *[]string) {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if !v.IsNil() {
+			collectSensitive(v.Elem(), sensitive, secrets)
+		}
+	case reflect.String:
+		if sensitive {
+			*secrets = append(*secrets, v.String())
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.IsExported() {
+				collectSensitive(v.Field(i), sensitive || field.Tag.Get("sensitive") == "true", secrets)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			collectSensitive(v.Index(i), sensitive, secrets)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			collectSensitive(iter.Value(), sensitive, secrets)
+		}
+	}
+}
+
+// quotedValue matches a PowerShell argument or assigned value: a single or double quoted string, or a bare word.
+const quotedValue = `('(?:[^']|'')*'|"(?:[^"` + "`" + `]|` + "`" + `.)*"|[^\s;|)]+)`
+
+// patterns match values that look like secrets wherever they appear. The first group is kept, the second group
+// is masked.
+var patterns = []*regexp.Regexp{
+	// Arguments such as -Password 'secret' or -Passphrase:secret
+	regexp.MustCompile(`(?i)(-\w*(?:password|passphrase|secret|token)\w*(?:\s+|:))` + quotedValue),
+	// ConvertTo-SecureString 'secret' -AsPlainText
+	regexp.MustCompile(`(?i)(ConvertTo-SecureString\s+(?:-String\s+)?)` + quotedValue),
+	// Assignments such as $password = 'secret'
+	regexp.MustCompile(`(?i)(\$\w*(?:password|passphrase|secret|token)\w*\s*=\s*)('(?:[^']|'')*'|"(?:[^"` + "`" + `]|` + "`" + `.)*")`),
+	// JSON properties such as "Password":"secret"
+	regexp.MustCompile(`(?i)("\w*(?:password|passphrase|secret|token)\w*"\s*:\s*)("(?:[^"\\]|\\.)*")`),
+	// Private keys in PEM format
+	regexp.MustCompile(`()(-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z0-9 ]*PRIVATE KEY-----)`),
+}
+
+// scriptParams matches the base64 encoded JSON arguments of a script, see hyperv.newScriptTemplate.
+var scriptParams = regexp.MustCompile(`(FromBase64String\(')([A-Za-z0-9+/=]*)('\))`)
+
+// encodedCommand matches the base64 encoded UTF-16 script of a PowerShell command line.
+var encodedCommand = regexp.MustCompile(`(?i)(-EncodedCommand\s+)([A-Za-z0-9+/=]+)`)
+
+// String masks the secrets in s. Base64 encoded script arguments and PowerShell encoded commands are decoded,
+// masked and encoded again.
+func (r *Redactor) String(s string) string {
+	s = scriptParams.ReplaceAllStringFunc(s, func(match string) string {
+		groups := scriptParams.FindStringSubmatch(match)
+		decoded, err := base64.StdEncoding.DecodeString(groups[2])
+		if err != nil {
+			return match
+		}
+
+		masked := r.mask(string(decoded))
+		if masked == string(decoded) {
+			return match
+		}
+
+		return groups[1] + base64.StdEncoding.EncodeToString([]byte(masked)) + groups[3]
+	})
+
+	s = encodedCommand.ReplaceAllStringFunc(s, func(match string) string {
+		groups := encodedCommand.FindStringSubmatch(match)
+		decoded, ok := decodeUTF16(groups[2])
+		if !ok {
+			return match
+		}
+
+		masked := r.String(decoded)
+		if masked == decoded {
+			return match
+		}
+
+		return groups[1] + encodeUTF16(masked)
+	})
+
+	return r.mask(s)
+}
+
+func (r *Redactor) mask(s string) string {
+	if r != nil {
+		for _, secret := range r.secrets {
+			s = strings.ReplaceAll(s, secret, Mask)
+		}
+	}
+
+	for _, pattern := range patterns {
+		s = pattern.ReplaceAllString(s, "${1}"+Mask)
+	}
+
+	return s
+}
+
+func decodeUTF16(encoded string) (string, bool) {
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(decoded)%2 != 0 {
+		return "", false
+	}
+
+	units := make([]uint16, len(decoded)/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(decoded[i*2:])
+	}
+
+	return string(utf16.Decode(units)), true
+}
+
+func encodeUTF16(s string) string {
+	units := utf16.Encode([]rune(s))
+	encoded := make([]byte, len(units)*2)
+	for i, unit := range units {
+		binary.LittleEndian.PutUint16(encoded[i*2:], unit)
+	}
+
+	return base64.StdEncoding.EncodeToString(encoded)
+}
+
+// Error returns err with its message masked. The masked error wraps err, so it still matches with errors.Is and
+// errors.As.
+func (r *Redactor) Error(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	message := err.Error()
+	if masked := r.String(message); masked != message {
+		return &redactedError{message: masked, err: err}
+	}
+
+	return err
+}
+
+type redactedError struct {
+	message string
+	err     error
+}
+
+func (e *redactedError) Error() string {
+	return e.message
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,78 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"regexp"
+	"syscall"
+
+	"github.com/taliesins/terraform-provider-hyperv/api"
+	"golang.org/x/crypto/ssh"
+)
+
+// winrmServerErrorRegexp matches the errors of the WinRM client for HTTP 5xx responses, which WinRM returns when
+// the service is restarting or out of shells. The transports do not always wrap errors with %w, so the message
+// is matched wherever it appears.
+var winrmServerErrorRegexp = regexp.MustCompile(`\bhttp (response )?error:? 5\d\d\b`)
+
+// IsTransient reports whether err is likely to go away when the operation is retried: a dropped SSH or WinRM
+// connection, a WinRM server error, a VM that is changing state or an object that is in use, such as a VHD locked by a
+// merge or a file opened by another process. Cancellation and other errors raised by scripts are not transient.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	return errors.Is(err, api.ErrTransitionState) || errors.Is(err, api.ErrResourceBusy) || IsNotStarted(err) || IsConnectionError(err) || IsServerError(err)
+}
+
+// IsNotStarted reports whether err happened before a script was started on the host, so that running the script
+// again cannot apply its changes twice: the connection to the host could not be opened, or the transport reported the
+// failure as happening before the script started with a ScriptNotStarted method.
+func IsNotStarted(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var notStarted interface{ ScriptNotStarted() bool }
+	if errors.As(err, &notStarted) && notStarted.ScriptNotStarted() {
+		return true
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr)
+}
+
+// IsConnectionError reports whether err is a connection to the host that was dropped or could not be opened.
+func IsConnectionError(err error) bool {
+	if errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	// The connection was lost while a remote command was running
+	var exitMissingErr *ssh.ExitMissingError
+	if errors.As(err, &exitMissingErr) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// IsServerError reports whether err is an HTTP 5xx response of the WinRM service.
+func IsServerError(err error) bool {
+	return winrmServerErrorRegexp.MatchString(err.Error())
+}
@@ -0,0 +1,122 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/taliesins/terraform-provider-hyperv/api"
+	"github.com/taliesins/terraform-provider-hyperv/api/commandresult"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestIsTransient(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "dropped connection", err: fmt.Errorf("failed to create session: %w", io.EOF), want: true},
+		{name: "connection reset", err: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, want: true},
+		{name: "connection refused", err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, want: true},
+		{name: "ssh exit missing", err: fmt.Errorf("failed to run command: %w", &ssh.ExitMissingError{}), want: true},
+		{name: "winrm server error", err: errors.New("couldn't create shell: http error 500: <s:Fault>"), want: true},
+		{name: "winrm unauthorized", err: errors.New("http error 401: "), want: false},
+		{
+			name: "file in use",
+			err:  &commandresult.ScriptError{FullyQualifiedErrorId: "ObjectInUse,Microsoft.Vhd.PowerShell.Cmdlets.ResizeVHD", Category: "ResourceBusy"},
+			want: true,
+		},
+		{
+			name: "resource busy category",
+			err:  &commandresult.ScriptError{ExceptionType: "Microsoft.HyperV.PowerShell.VirtualizationException", Category: "ResourceBusy"},
+			want: true,
+		},
+		{
+			name: "localized sharing violation",
+			err: &commandresult.ScriptError{
+				ExceptionType: "System.IO.IOException",
+				HResult:       -2147024864,
+				Message:       "Der Prozess kann nicht auf die Datei zugreifen, da sie von einem anderen Prozess verwendet wird.",
+			},
+			want: true,
+		},
+		{name: "untyped object in use", err: errors.New("The operation cannot be performed while the object is in use."), want: false},
+		{name: "sharing violation", err: fmt.Errorf("failed to remove C:\\isos\\web.iso: %w", api.ErrResourceBusy), want: true},
+		{
+			name: "vm in transition",
+			err: &commandresult.ScriptError{
+				FullyQualifiedErrorId: "InvalidState,Microsoft.HyperV.PowerShell.Commands.StopVM",
+				Category:              "InvalidOperation",
+				TargetState:           "Starting",
+			},
+			want: true,
+		},
+		{
+			name: "vm running",
+			err: &commandresult.ScriptError{
+				FullyQualifiedErrorId: "InvalidState,Microsoft.HyperV.PowerShell.Commands.SetVMProcessor",
+				Category:              "InvalidOperation",
+				TargetState:           "Running",
+			},
+			want: false,
+		},
+		{name: "not found", err: fmt.Errorf("VM does not exist - web: %w", api.ErrNotFound), want: false},
+		{name: "canceled", err: fmt.Errorf("command interrupted: %w", context.Canceled), want: false},
+		{name: "deadline exceeded", err: context.DeadlineExceeded, want: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := IsTransient(tc.err); got != tc.want {
+				t.Fatalf("IsTransient(%v) = %v, want %v", tc.err, got, tc.want)
+			}
+		})
+	}
+}
+
+// notStartedError is a failure a transport reports as happening before the script started.
+type notStartedError struct{}
+
+func (notStartedError) Error() string { return "failed to upload script" }
+
+func (notStartedError) ScriptNotStarted() bool { return true }
+
+func TestIsNotStarted(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "connection refused", err: fmt.Errorf("failed to dial SSH: %w", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}), want: true},
+		{name: "unknown host", err: &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "hv", IsNotFound: true}}, want: true},
+		{name: "marked by the transport", err: fmt.Errorf("script CreateVm: %w", notStartedError{}), want: true},
+		{name: "connection reset while running", err: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, want: false},
+		{name: "dropped connection", err: fmt.Errorf("failed to run script: %w", io.EOF), want: false},
+		{name: "ssh exit missing", err: fmt.Errorf("failed to run command: %w", &ssh.ExitMissingError{}), want: false},
+		{name: "winrm server error", err: errors.New("http error 500: <s:Fault>"), want: false},
+		{name: "canceled", err: fmt.Errorf("%w: %w", notStartedError{}, context.Canceled), want: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := IsNotStarted(tc.err); got != tc.want {
+				t.Fatalf("IsNotStarted(%v) = %v, want %v", tc.err, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+// Package retry retries operations on a Hyper-V host that failed with a transient error, such as a dropped
+// connection or a VHD that is briefly locked, with exponential backoff and jitter.
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"time"
+
+	"github.com/taliesins/terraform-provider-hyperv/api"
+)
+
+// Defaults of the policy used for every operation of a transport.
+const (
+	DefaultMaxAttempts     = 3
+	DefaultInitialInterval = time.Second
+	DefaultMaxInterval     = 30 * time.Second
+	DefaultBudget          = 2 * time.Minute
+
+	// A VHD can stay locked by a merge or a backup for minutes.
+	DefaultBusyInterval = 10 * time.Second
+	DefaultBusyBudget   = 5 * time.Minute
+)
+
+// Classifier reports whether a failed operation can be retried.
+type Classifier func(err error) bool
+
+// Policy describes how often and for how long an operation is retried.
+type Policy struct {
+	// MaxAttempts is the number of attempts including the first one, 0 for no limit other than Budget.
+	MaxAttempts int
+	// InitialInterval is the wait before the first retry, it doubles with every retry up to MaxInterval.
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	// Budget bounds the time spent on all attempts and the waits between them, 0 for no limit other than the context.
+	Budget time.Duration
+	// BusyInterval is the wait between retries of objects that are in use, such as a locked VHD or file, which are
+	// not counted against MaxAttempts. 0 retries them like any other transient error.
+	BusyInterval time.Duration
+	// BusyBudget bounds the time spent on all attempts while objects are in use, instead of Budget.
+	BusyBudget time.Duration
+	// Retryable classifies errors, IsTransient is used when it is nil.
+	Retryable Classifier
+}
+
+// DefaultPolicy returns the policy used for transports when the provider is not configured otherwise.
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxAttempts:     DefaultMaxAttempts,
+		InitialInterval: DefaultInitialInterval,
+		MaxInterval:     DefaultMaxInterval,
+		Budget:          DefaultBudget,
+		BusyInterval:    DefaultBusyInterval,
+		BusyBudget:      DefaultBusyBudget,
+	}
+}
+
+// backoff returns the wait before retry number attempt, starting at 1. Half of the wait is random, so clients
+// failing together do not retry together.
+func (p Policy) backoff(attempt int) time.Duration {
+	interval := p.InitialInterval
+	for i := 1; i < attempt && (p.MaxInterval <= 0 || interval < p.MaxInterval); i++ {
+		interval *= 2
+	}
+
+	if p.MaxInterval > 0 && interval > p.MaxInterval {
+		interval = p.MaxInterval
+	}
+
+	if interval <= 1 {
+		return interval
+	}
+
+	half := interval / 2
+	return half + rand.N(interval-half)
+}
+
+// Do runs fn until it succeeds, fails with an error that is not retryable, or the attempts or budget of the policy
+// are used up. Retryable errors of objects in use are retried every BusyInterval within BusyBudget. The last error is
+// returned wrapped, so it can still be matched with errors.Is.
+func (p Policy) Do(ctx context.Context, operation string, fn func() error) error {
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = IsTransient
+	}
+
+	start := time.Now()
+	retries := 0
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("%s canceled before attempt %d: %w", operation, attempt, err)
+		}
+
+		err := fn()
+		if err == nil {
+			return nil
+		}
+
+		if !retryable(err) {
+			return err
+		}
+
+		wait, budget := p.BusyInterval, p.BusyBudget
+		if p.BusyInterval <= 0 || !errors.Is(err, api.ErrResourceBusy) {
+			retries++
+			if p.MaxAttempts > 0 && retries >= p.MaxAttempts {
+				return fmt.Errorf("%s failed after %d attempts: %w", operation, attempt, err)
+			}
+
+			wait, budget = p.backoff(retries), p.Budget
+		}
+
+		if budget > 0 && time.Since(start)+wait > budget {
+			return fmt.Errorf("%s timed out after %d attempts in %s: %w", operation, attempt, time.Since(start).Round(time.Millisecond), err)
+		}
+
+		log.Printf("[WARN][hyperv] %s failed with a transient error, retrying in %s (attempt %d): %s", operation, wait, attempt, err)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%s canceled while waiting to retry: %w", operation, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/taliesins/terraform-provider-hyperv/api"
+)
+
+func TestPolicyDo(t *testing.T) {
+	t.Parallel()
+
+	transient := fmt.Errorf("failed to run script: %w", io.EOF)
+	permanent := errors.New("Switch does not exist - lan")
+	busy := fmt.Errorf("GetVhd failed: %w", api.ErrResourceBusy)
+
+	tests := []struct {
+		name         string
+		policy       Policy
+		errs         []error
+		wantAttempts int
+		wantErr      string
+	}{
+		{
+			name:         "success",
+			policy:       Policy{MaxAttempts: 3},
+			wantAttempts: 1,
+		},
+		{
+			name:         "succeeds after transient errors",
+			policy:       Policy{MaxAttempts: 3, InitialInterval: time.Millisecond},
+			errs:         []error{transient, transient},
+			wantAttempts: 3,
+		},
+		{
+			name:         "fails fast on permanent error",
+			policy:       Policy{MaxAttempts: 3, InitialInterval: time.Millisecond},
+			errs:         []error{permanent, nil},
+			wantAttempts: 1,
+			wantErr:      "Switch does not exist - lan",
+		},
+		{
+			name:         "gives up after max attempts",
+			policy:       Policy{MaxAttempts: 2, InitialInterval: time.Millisecond},
+			errs:         []error{transient, transient, nil},
+			wantAttempts: 2,
+			wantErr:      "test failed after 2 attempts: failed to run script: EOF",
+		},
+		{
+			name:         "gives up when the budget is used",
+			policy:       Policy{InitialInterval: 50 * time.Millisecond, Budget: 10 * time.Millisecond},
+			errs:         []error{transient, nil},
+			wantAttempts: 1,
+			wantErr:      "test timed out after 1 attempts",
+		},
+		{
+			name:         "waits for objects in use beyond max attempts",
+			policy:       Policy{MaxAttempts: 2, InitialInterval: time.Millisecond, BusyInterval: time.Millisecond, BusyBudget: time.Second},
+			errs:         []error{busy, busy, busy, transient},
+			wantAttempts: 5,
+		},
+		{
+			name:         "counts transient errors between objects in use",
+			policy:       Policy{MaxAttempts: 2, InitialInterval: time.Millisecond, BusyInterval: time.Millisecond, BusyBudget: time.Second},
+			errs:         []error{transient, busy, transient, nil},
+			wantAttempts: 3,
+			wantErr:      "test failed after 3 attempts: failed to run script: EOF",
+		},
+		{
+			name:         "gives up when the busy budget is used",
+			policy:       Policy{MaxAttempts: 5, InitialInterval: time.Millisecond, Budget: time.Minute, BusyInterval: 50 * time.Millisecond, BusyBudget: 10 * time.Millisecond},
+			errs:         []error{busy, nil},
+			wantAttempts: 1,
+			wantErr:      "test timed out after 1 attempts",
+		},
+		{
+			name:         "retries objects in use as transient errors without a busy interval",
+			policy:       Policy{MaxAttempts: 2, InitialInterval: time.Millisecond},
+			errs:         []error{busy, busy, nil},
+			wantAttempts: 2,
+			wantErr:      "test failed after 2 attempts: GetVhd failed: resource busy",
+		},
+		{
+			name:         "custom classifier",
+			policy:       Policy{MaxAttempts: 3, InitialInterval: time.Millisecond, Retryable: func(err error) bool { return errors.Is(err, permanent) }},
+			errs:         []error{permanent, transient},
+			wantAttempts: 2,
+			wantErr:      "failed to run script: EOF",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			attempts := 0
+			err := tc.policy.Do(context.Background(), "test", func() error {
+				attempts++
+				if attempts > len(tc.errs) {
+					return nil
+				}
+				return tc.errs[attempts-1]
+			})
+
+			if attempts != tc.wantAttempts {
+				t.Fatalf("expected %d attempts, got %d", tc.wantAttempts, attempts)
+			}
+
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestPolicyDoKeepsLastError(t *testing.T) {
+	t.Parallel()
+
+	policy := Policy{MaxAttempts: 2, InitialInterval: time.Millisecond}
+	err := policy.Do(context.Background(), "test", func() error {
+		return fmt.Errorf("GetVhd failed: %w", api.ErrResourceBusy)
+	})
+
+	if !errors.Is(err, api.ErrResourceBusy) {
+		t.Fatalf("expected the last error to be wrapped, got %v", err)
+	}
+}
+
+func TestPolicyDoHonorsContextCancellation(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+
+	policy := Policy{MaxAttempts: 5, InitialInterval: time.Second}
+	err := policy.Do(ctx, "test", func() error {
+		attempts++
+		cancel()
+		return io.EOF
+	})
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a cancellation error, got %v", err)
+	}
+
+	if attempts != 1 {
+		t.Fatalf("expected one attempt before cancellation, got %d", attempts)
+	}
+}
+
+func TestPolicyBackoff(t *testing.T) {
+	t.Parallel()
+
+	policy := Policy{InitialInterval: time.Second, MaxInterval: 5 * time.Second}
+
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{attempt: 1, want: time.Second},
+		{attempt: 2, want: 2 * time.Second},
+		{attempt: 3, want: 4 * time.Second},
+		{attempt: 4, want: 5 * time.Second},
+		{attempt: 40, want: 5 * time.Second},
+	}
+
+	for _, tc := range tests {
+		t.Run(fmt.Sprintf("attempt %d", tc.attempt), func(t *testing.T) {
+			t.Parallel()
+
+			for range 20 {
+				got := policy.backoff(tc.attempt)
+				if got < tc.want/2 || got > tc.want {
+					t.Fatalf("expected a wait between %s and %s, got %s", tc.want/2, tc.want, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package retry
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"text/template"
+
+	"github.com/taliesins/terraform-provider-hyperv/api/commandresult"
+)
+
+// ScriptRunner is the script runner of a transport, see hyperv.ScriptRunner.
+type ScriptRunner interface {
+	RunFireAndForgetScript(ctx context.Context, script *template.Template, args interface{}) error
+	RunScriptWithResult(ctx context.Context, script *template.Template, args interface{}, result interface{}) (err error)
+	UploadFile(ctx context.Context, filePath string, remoteFilePath string) (resolvedRemoteFilePath string, err error)
+	UploadDirectory(ctx context.Context, rootPath string, excludeList []string) (remoteRootPath string, remoteAbsoluteFilePaths []string, err error)
+	FileExists(ctx context.Context, remoteFilePath string) (exists bool, err error)
+	DirectoryExists(ctx context.Context, remoteDirectoryPath string) (exists bool, err error)
+	DeleteFileOrDirectory(ctx context.Context, remotePath string) (err error)
+}
+
+// StreamingScriptRunner is a script runner passing the records of a script on while it runs, see
+// hyperv.StreamingScriptRunner.
+type StreamingScriptRunner interface {
+	RunStreamingScript(ctx context.Context, script *template.Template, args interface{}, result interface{}, records commandresult.RecordHandler) (err error)
+}
+
+// idempotentScripts holds the scripts marked with Idempotent.
+var idempotentScripts sync.Map
+
+// Idempotent marks script as safe to run again after it failed part way, for example because the connection dropped
+// while it ran, and returns it. Scripts that are not marked are only retried when they failed before they started.
+func Idempotent(script *template.Template) *template.Template {
+	idempotentScripts.Store(script, true)
+	return script
+}
+
+// IsIdempotent reports whether script was marked with Idempotent.
+func IsIdempotent(script *template.Template) bool {
+	_, ok := idempotentScripts.Load(script)
+	return ok
+}
+
+// Runner retries the scripts and file operations of a ScriptRunner that fail with a transient error. Scripts that are
+// not idempotent are only retried when they failed before they started, as they may otherwise apply their changes
+// twice. File operations are always retried, they can be repeated.
+type Runner struct {
+	runner ScriptRunner
+	policy Policy
+}
+
+// NewRunner returns a script runner retrying the operations of runner as described by policy.
+func NewRunner(runner ScriptRunner, policy Policy) *Runner {
+	return &Runner{
+		runner: runner,
+		policy: policy,
+	}
+}
+
+// scriptPolicy returns the policy of script, which only retries failures before the script started unless the script
+// is idempotent.
+func (r *Runner) scriptPolicy(script *template.Template) Policy {
+	if IsIdempotent(script) {
+		return r.policy
+	}
+
+	policy := r.policy
+	policy.Retryable = IsNotStarted
+	return policy
+}
+
+// RunFireAndForgetScript runs a script without processing results, retrying transient failures
+func (r *Runner) RunFireAndForgetScript(ctx context.Context, script *template.Template, args interface{}) error {
+	return r.scriptPolicy(script).Do(ctx, fmt.Sprintf("script %s", script.Name()), func() error {
+		return r.runner.RunFireAndForgetScript(ctx, script, args)
+	})
+}
+
+// RunScriptWithResult runs a script and unmarshals JSON output into result, retrying transient failures
+func (r *Runner) RunScriptWithResult(ctx context.Context, script *template.Template, args interface{}, result interface{}) error {
+	return r.scriptPolicy(script).Do(ctx, fmt.Sprintf("script %s", script.Name()), func() error {
+		return r.runner.RunScriptWithResult(ctx, script, args, result)
+	})
+}
+
+// RunStreamingScript runs a script passing its records to records, retrying transient failures. Scripts are run
+// without passing their records on when the runner cannot stream.
+func (r *Runner) RunStreamingScript(ctx context.Context, script *template.Template, args interface{}, result interface{}, records commandresult.RecordHandler) error {
+	streamingRunner, ok := r.runner.(StreamingScriptRunner)
+
+	return r.scriptPolicy(script).Do(ctx, fmt.Sprintf("script %s", script.Name()), func() error {
+		switch {
+		case ok:
+			return streamingRunner.RunStreamingScript(ctx, script, args, result, records)
+		case result == nil:
+			return r.runner.RunFireAndForgetScript(ctx, script, args)
+		default:
+			return r.runner.RunScriptWithResult(ctx, script, args, result)
+		}
+	})
+}
+
+func (r *Runner) UploadFile(ctx context.Context, filePath string, remoteFilePath string) (resolvedRemoteFilePath string, err error) {
+	err = r.policy.Do(ctx, fmt.Sprintf("upload of %s", filePath), func() error {
+		resolvedRemoteFilePath, err = r.runner.UploadFile(ctx, filePath, remoteFilePath)
+		return err
+	})
+
+	return resolvedRemoteFilePath, err
+}
+
+func (r *Runner) UploadDirectory(ctx context.Context, rootPath string, excludeList []string) (remoteRootPath string, remoteAbsoluteFilePaths []string, err error) {
+	err = r.policy.Do(ctx, fmt.Sprintf("upload of %s", rootPath), func() error {
+		remoteRootPath, remoteAbsoluteFilePaths, err = r.runner.UploadDirectory(ctx, rootPath, excludeList)
+		return err
+	})
+
+	return remoteRootPath, remoteAbsoluteFilePaths, err
+}
+
+func (r *Runner) FileExists(ctx context.Context, remoteFilePath string) (exists bool, err error) {
+	err = r.policy.Do(ctx, fmt.Sprintf("check of file %s", remoteFilePath), func() error {
+		exists, err = r.runner.FileExists(ctx, remoteFilePath)
+		return err
+	})
+
+	return exists, err
+}
+
+func (r *Runner) DirectoryExists(ctx context.Context, remoteDirectoryPath string) (exists bool, err error) {
+	err = r.policy.Do(ctx, fmt.Sprintf("check of directory %s", remoteDirectoryPath), func() error {
+		exists, err = r.runner.DirectoryExists(ctx, remoteDirectoryPath)
+		return err
+	})
+
+	return exists, err
+}
+
+func (r *Runner) DeleteFileOrDirectory(ctx context.Context, remotePath string) (err error) {
+	return r.policy.Do(ctx, fmt.Sprintf("delete of %s", remotePath), func() error {
+		return r.runner.DeleteFileOrDirectory(ctx, remotePath)
+	})
+}
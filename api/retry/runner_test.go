@@ -0,0 +1,202 @@
+package retry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/taliesins/terraform-provider-hyperv/api"
+	"github.com/taliesins/terraform-provider-hyperv/api/commandresult"
+)
+
+// flakyRunner fails the first calls of every operation with err, a dropped connection when it is nil.
+type flakyRunner struct {
+	failures int
+	calls    map[string]int
+	err      error
+}
+
+func (r *flakyRunner) call(operation string) error {
+	r.calls[operation]++
+	if r.calls[operation] <= r.failures {
+		if r.err != nil {
+			return fmt.Errorf("%s: %w", operation, r.err)
+		}
+		return fmt.Errorf("%s: %w", operation, io.EOF)
+	}
+
+	return nil
+}
+
+func (r *flakyRunner) RunFireAndForgetScript(ctx context.Context, script *template.Template, args interface{}) error {
+	return r.call("RunFireAndForgetScript")
+}
+
+func (r *flakyRunner) RunScriptWithResult(ctx context.Context, script *template.Template, args interface{}, result interface{}) error {
+	if err := r.call("RunScriptWithResult"); err != nil {
+		return err
+	}
+
+	return json.Unmarshal([]byte(`{"Name":"web"}`), result)
+}
+
+func (r *flakyRunner) UploadFile(ctx context.Context, filePath string, remoteFilePath string) (string, error) {
+	return remoteFilePath, r.call("UploadFile")
+}
+
+func (r *flakyRunner) UploadDirectory(ctx context.Context, rootPath string, excludeList []string) (string, []string, error) {
+	return rootPath, nil, r.call("UploadDirectory")
+}
+
+func (r *flakyRunner) FileExists(ctx context.Context, remoteFilePath string) (bool, error) {
+	return true, r.call("FileExists")
+}
+
+func (r *flakyRunner) DirectoryExists(ctx context.Context, remoteDirectoryPath string) (bool, error) {
+	return true, r.call("DirectoryExists")
+}
+
+func (r *flakyRunner) DeleteFileOrDirectory(ctx context.Context, remotePath string) error {
+	return r.call("DeleteFileOrDirectory")
+}
+
+func TestRunner(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	flaky := &flakyRunner{failures: 2, calls: map[string]int{}}
+	runner := NewRunner(flaky, Policy{MaxAttempts: 3, InitialInterval: time.Millisecond})
+	script := Idempotent(template.Must(template.New("GetVm").Parse(`Get-VM`)))
+
+	if err := runner.RunFireAndForgetScript(ctx, script, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var vm struct{ Name string }
+	if err := runner.RunScriptWithResult(ctx, script, nil, &vm); err != nil || vm.Name != "web" {
+		t.Fatalf("expected the result of the last attempt, got %+v: %v", vm, err)
+	}
+
+	if path, err := runner.UploadFile(ctx, "web.iso", `C:\isos\web.iso`); err != nil || path != `C:\isos\web.iso` {
+		t.Fatalf("expected the resolved path of the last attempt, got %q: %v", path, err)
+	}
+
+	if exists, err := runner.FileExists(ctx, `C:\isos\web.iso`); err != nil || !exists {
+		t.Fatalf("expected the file to exist, got %v: %v", exists, err)
+	}
+
+	if err := runner.DeleteFileOrDirectory(ctx, `C:\isos\web.iso`); err != nil {
+		t.Fatal(err)
+	}
+
+	for operation, calls := range flaky.calls {
+		if calls != 3 {
+			t.Fatalf("expected 3 calls of %s, got %d", operation, calls)
+		}
+	}
+
+	exhausted := NewRunner(&flakyRunner{failures: 3, calls: map[string]int{}}, Policy{MaxAttempts: 3, InitialInterval: time.Millisecond})
+	if err := exhausted.RunFireAndForgetScript(ctx, script, nil); err == nil || err.Error() != "script GetVm failed after 3 attempts: RunFireAndForgetScript: EOF" {
+		t.Fatalf("expected the attempts to be used up, got %v", err)
+	}
+}
+
+func TestRunnerRetriesScriptsThatAreNotIdempotentBeforeTheyStart(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	script := template.Must(template.New("CreateVm").Parse(`New-VM`))
+	policy := Policy{MaxAttempts: 3, InitialInterval: time.Millisecond}
+
+	dropped := &flakyRunner{failures: 1, calls: map[string]int{}}
+	if err := NewRunner(dropped, policy).RunFireAndForgetScript(ctx, script, nil); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected the dropped connection to be returned, got %v", err)
+	}
+	if dropped.calls["RunFireAndForgetScript"] != 1 {
+		t.Fatalf("expected a script that may have run not to be run again, got %d calls", dropped.calls["RunFireAndForgetScript"])
+	}
+
+	refused := &flakyRunner{failures: 2, calls: map[string]int{}, err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}}
+	if err := NewRunner(refused, policy).RunFireAndForgetScript(ctx, script, nil); err != nil {
+		t.Fatal(err)
+	}
+	if refused.calls["RunFireAndForgetScript"] != 3 {
+		t.Fatalf("expected a script that did not start to be retried, got %d calls", refused.calls["RunFireAndForgetScript"])
+	}
+}
+
+func TestRunnerWaitsForObjectsInUse(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	policy := Policy{MaxAttempts: 2, InitialInterval: time.Millisecond, BusyInterval: time.Millisecond, BusyBudget: time.Second}
+
+	locked := &flakyRunner{failures: 4, calls: map[string]int{}, err: api.ErrResourceBusy}
+	runner := NewRunner(locked, policy)
+	if err := runner.RunFireAndForgetScript(ctx, Idempotent(template.Must(template.New("ResizeVhd").Parse(`Resize-VHD`))), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.DeleteFileOrDirectory(ctx, `C:\isos\web.iso`); err != nil {
+		t.Fatal(err)
+	}
+	if locked.calls["RunFireAndForgetScript"] != 5 || locked.calls["DeleteFileOrDirectory"] != 5 {
+		t.Fatalf("expected objects in use to be waited for beyond the attempts, got %v", locked.calls)
+	}
+
+	notIdempotent := &flakyRunner{failures: 1, calls: map[string]int{}, err: api.ErrResourceBusy}
+	err := NewRunner(notIdempotent, policy).RunFireAndForgetScript(ctx, template.Must(template.New("DeleteVm").Parse(`Remove-VM`)), nil)
+	if !errors.Is(err, api.ErrResourceBusy) || notIdempotent.calls["RunFireAndForgetScript"] != 1 {
+		t.Fatalf("expected a script that may have run not to be run again, got %d calls: %v", notIdempotent.calls["RunFireAndForgetScript"], err)
+	}
+}
+
+// streamingRunner is a flakyRunner that also streams scripts.
+type streamingRunner struct {
+	flakyRunner
+}
+
+func (r *streamingRunner) RunStreamingScript(ctx context.Context, script *template.Template, args interface{}, result interface{}, records commandresult.RecordHandler) error {
+	if err := r.call("RunStreamingScript"); err != nil {
+		return err
+	}
+
+	records(commandresult.Record{Stream: commandresult.RecordVerbose, PercentComplete: -1, Message: "attempt"})
+	return nil
+}
+
+func TestRunnerStreaming(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	script := Idempotent(template.Must(template.New("ExportVm").Parse(`Export-VM`)))
+	policy := Policy{MaxAttempts: 3, InitialInterval: time.Millisecond}
+
+	streaming := &streamingRunner{flakyRunner{failures: 2, calls: map[string]int{}}}
+	var records []commandresult.Record
+	if err := NewRunner(streaming, policy).RunStreamingScript(ctx, script, nil, nil, func(record commandresult.Record) {
+		records = append(records, record)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if streaming.calls["RunStreamingScript"] != 3 || len(records) != 1 {
+		t.Fatalf("expected the script to be streamed on the last attempt, got %v calls and records %+v", streaming.calls, records)
+	}
+
+	flaky := &flakyRunner{failures: 2, calls: map[string]int{}}
+	var vm struct{ Name string }
+	if err := NewRunner(flaky, policy).RunStreamingScript(ctx, script, nil, &vm, nil); err != nil || vm.Name != "web" {
+		t.Fatalf("expected the result of the last attempt, got %+v: %v", vm, err)
+	}
+
+	if flaky.calls["RunScriptWithResult"] != 3 {
+		t.Fatalf("expected runners that cannot stream to run the script with result, got %v", flaky.calls)
+	}
+}
@@ -0,0 +1,252 @@
+package ssh_helper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// AuthenticationError is returned when the SSH server rejects every configured authentication method.
+type AuthenticationError struct {
+	User    string
+	Address string
+	Methods []string
+	Err     error
+}
+
+func (e *AuthenticationError) Error() string {
+	return fmt.Sprintf("SSH authentication failed for user %q on %s: the server rejected all configured methods (%s): %v", e.User, e.Address, strings.Join(e.Methods, ", "), e.Err)
+}
+
+func (e *AuthenticationError) Unwrap() error {
+	return e.Err
+}
+
+// authMethods builds the SSH authentication methods from the configuration. It returns the
+// methods, a description of each method for error messages and a cleanup function that must be
+// called once the handshake has completed.
+func (c *ClientConfig) authMethods() ([]ssh.AuthMethod, []string, func(), error) {
+	var methods []ssh.AuthMethod
+	var descriptions []string
+	cleanup := func() {}
+
+	certificate, err := c.certificate()
+	if err != nil {
+		return nil, nil, cleanup, err
+	}
+
+	signer, err := c.privateKeySigner()
+	if err != nil {
+		return nil, nil, cleanup, err
+	}
+
+	if signer != nil {
+		if certificate != nil {
+			signer, err = newCertSigner(certificate, signer)
+			if err != nil {
+				return nil, nil, cleanup, err
+			}
+			descriptions = append(descriptions, "certificate")
+		} else {
+			descriptions = append(descriptions, "private key")
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+
+	if c.UseAgent {
+		agentClient, conn, err := c.agentClient()
+		if err != nil {
+			return nil, nil, cleanup, err
+		}
+		cleanup = func() {
+			_ = conn.Close()
+		}
+
+		methods = append(methods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			return agentSigners(agentClient, certificate)
+		}))
+		descriptions = append(descriptions, "ssh-agent")
+	} else if certificate != nil && signer == nil {
+		return nil, nil, cleanup, fmt.Errorf("an SSH certificate requires a private key or ssh-agent to sign with")
+	}
+
+	if c.Password != "" {
+		methods = append(methods, ssh.Password(c.Password), ssh.KeyboardInteractive(c.keyboardInteractiveChallenge))
+		descriptions = append(descriptions, "password", "keyboard-interactive")
+	}
+
+	if len(methods) == 0 {
+		return nil, nil, cleanup, fmt.Errorf("no authentication method provided (password, private key or ssh-agent required)")
+	}
+
+	return methods, descriptions, cleanup, nil
+}
+
+// privateKeySigner parses the configured private key, decrypting it with PrivateKeyPassphrase
+// when the key is passphrase protected. It returns nil when no private key is configured.
+func (c *ClientConfig) privateKeySigner() (ssh.Signer, error) {
+	var keyBytes []byte
+	source := "private key"
+
+	if c.PrivateKey != "" {
+		keyBytes = []byte(c.PrivateKey)
+	} else if c.PrivateKeyPath != "" {
+		// Expand ~ to home directory
+		keyPath, err := expandHomePath(c.PrivateKeyPath)
+		if err != nil {
+			return nil, err
+		}
+
+		keyBytes, err = os.ReadFile(keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read private key file: %w", err)
+		}
+		source = "private key from file"
+	} else {
+		return nil, nil
+	}
+
+	if c.PrivateKeyPassphrase != "" {
+		signer, err := ssh.ParsePrivateKeyWithPassphrase(keyBytes, []byte(c.PrivateKeyPassphrase))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s with passphrase: %w", source, err)
+		}
+		return signer, nil
+	}
+
+	signer, err := ssh.ParsePrivateKey(keyBytes)
+	if err != nil {
+		var missingErr *ssh.PassphraseMissingError
+		if errors.As(err, &missingErr) {
+			return nil, fmt.Errorf("failed to parse %s: the key is passphrase protected but no passphrase was provided", source)
+		}
+		return nil, fmt.Errorf("failed to parse %s: %w", source, err)
+	}
+
+	return signer, nil
+}
+
+// certificate parses the configured OpenSSH user certificate. It returns nil when no certificate is configured.
+func (c *ClientConfig) certificate() (*ssh.Certificate, error) {
+	var certBytes []byte
+
+	if c.Certificate != "" {
+		certBytes = []byte(c.Certificate)
+	} else if c.CertificatePath != "" {
+		certPath, err := expandHomePath(c.CertificatePath)
+		if err != nil {
+			return nil, err
+		}
+
+		certBytes, err = os.ReadFile(certPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read certificate file: %w", err)
+		}
+	} else {
+		return nil, nil
+	}
+
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey(certBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+
+	certificate, ok := publicKey.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("failed to parse certificate: %s is a public key, not an OpenSSH certificate", publicKey.Type())
+	}
+
+	if certificate.CertType != ssh.UserCert {
+		return nil, fmt.Errorf("failed to parse certificate: not a user certificate")
+	}
+
+	return certificate, nil
+}
+
+func newCertSigner(certificate *ssh.Certificate, signer ssh.Signer) (ssh.Signer, error) {
+	certSigner, err := ssh.NewCertSigner(certificate, signer)
+	if err != nil {
+		return nil, fmt.Errorf("certificate does not match the private key: %w", err)
+	}
+
+	return certSigner, nil
+}
+
+// agentClient connects to the ssh-agent listening on AgentSocket or SSH_AUTH_SOCK.
+func (c *ClientConfig) agentClient() (agent.ExtendedAgent, net.Conn, error) {
+	socket := c.AgentSocket
+	if socket == "" {
+		socket = os.Getenv("SSH_AUTH_SOCK")
+	}
+
+	if socket == "" {
+		return nil, nil, fmt.Errorf("ssh-agent authentication requested but SSH_AUTH_SOCK is not set")
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to ssh-agent at %s: %w", socket, err)
+	}
+
+	return agent.NewClient(conn), conn, nil
+}
+
+// agentSigners returns the signers held by the agent. When a certificate is configured the
+// agent key matching the certificate is additionally offered with the certificate attached.
+func agentSigners(agentClient agent.ExtendedAgent, certificate *ssh.Certificate) ([]ssh.Signer, error) {
+	signers, err := agentClient.Signers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ssh-agent keys: %w", err)
+	}
+
+	if certificate == nil {
+		return signers, nil
+	}
+
+	certKey := certificate.Key.Marshal()
+	for _, signer := range signers {
+		if bytes.Equal(signer.PublicKey().Marshal(), certKey) {
+			certSigner, err := newCertSigner(certificate, signer)
+			if err != nil {
+				return nil, err
+			}
+			return append([]ssh.Signer{certSigner}, signers...), nil
+		}
+	}
+
+	log.Printf("[DEBUG] None of the %d ssh-agent keys match the configured certificate", len(signers))
+	return signers, nil
+}
+
+// keyboardInteractiveChallenge answers password prompts with the configured password. Servers
+// that only allow keyboard-interactive typically send a single "Password:" prompt.
+func (c *ClientConfig) keyboardInteractiveChallenge(name, instruction string, questions []string, echos []bool) ([]string, error) {
+	answers := make([]string, len(questions))
+	for i, question := range questions {
+		if !strings.Contains(strings.ToLower(question), "password") {
+			return nil, fmt.Errorf("unsupported keyboard-interactive prompt %q", question)
+		}
+		answers[i] = c.Password
+	}
+
+	return answers, nil
+}
+
+// isAuthenticationError reports whether err is the error returned by the SSH handshake once every
+// authentication method has been rejected.
+// Errors already reported for a bastion are not attributed to the target host.
+func isAuthenticationError(err error) bool {
+	var authErr *AuthenticationError
+	if errors.As(err, &authErr) {
+		return false
+	}
+
+	return err != nil && strings.Contains(err.Error(), "unable to authenticate")
+}
@@ -0,0 +1,294 @@
+package ssh_helper
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func newTestUserKey(t *testing.T) (ed25519.PrivateKey, ssh.Signer) {
+	t.Helper()
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+
+	return private, signer
+}
+
+func marshalTestPrivateKey(t *testing.T, key ed25519.PrivateKey, passphrase string) string {
+	t.Helper()
+
+	var block *pem.Block
+	var err error
+	if passphrase == "" {
+		block, err = ssh.MarshalPrivateKey(key, "")
+	} else {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(key, "", []byte(passphrase))
+	}
+	if err != nil {
+		t.Fatalf("failed to marshal private key: %v", err)
+	}
+
+	return string(pem.EncodeToMemory(block))
+}
+
+func newTestUserCertificate(t *testing.T, userKey ssh.PublicKey, authority ssh.Signer) *ssh.Certificate {
+	t.Helper()
+
+	certificate := &ssh.Certificate{
+		Key:             userKey,
+		CertType:        ssh.UserCert,
+		KeyId:           "test",
+		ValidPrincipals: []string{testSSHUser},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+
+	if err := certificate.SignCert(rand.Reader, authority); err != nil {
+		t.Fatalf("failed to sign certificate: %v", err)
+	}
+
+	return certificate
+}
+
+// publicKeyAuth accepts the given keys as authorized keys.
+func publicKeyAuth(keys ...ssh.PublicKey) func(config *ssh.ServerConfig) {
+	return func(config *ssh.ServerConfig) {
+		config.PublicKeyCallback = func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			for _, authorized := range keys {
+				if bytes.Equal(authorized.Marshal(), key.Marshal()) {
+					return nil, nil
+				}
+			}
+			return nil, fmt.Errorf("unknown public key")
+		}
+	}
+}
+
+// certificateAuth only accepts user certificates signed by authority.
+func certificateAuth(authority ssh.PublicKey) func(config *ssh.ServerConfig) {
+	return func(config *ssh.ServerConfig) {
+		checker := &ssh.CertChecker{
+			IsUserAuthority: func(auth ssh.PublicKey) bool {
+				return bytes.Equal(auth.Marshal(), authority.Marshal())
+			},
+		}
+		config.PublicKeyCallback = checker.Authenticate
+	}
+}
+
+func startTestAgent(t *testing.T, keys ...ed25519.PrivateKey) string {
+	t.Helper()
+
+	keyring := agent.NewKeyring()
+	for _, key := range keys {
+		if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+			t.Fatalf("failed to add key to agent: %v", err)
+		}
+	}
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("failed to listen on agent socket: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_ = agent.ServeAgent(keyring, conn)
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	return socket
+}
+
+func assertCommandSucceeds(t *testing.T, config *ClientConfig) {
+	t.Helper()
+
+	stdout, _, exitCode, err := config.runCommand(context.Background(), "whoami")
+	if err != nil {
+		t.Fatalf("expected command to succeed, got %v", err)
+	}
+
+	if exitCode != 0 || stdout != "whoami" {
+		t.Fatalf("unexpected result %q (exit %d)", stdout, exitCode)
+	}
+}
+
+func TestPassphraseProtectedPrivateKey(t *testing.T) {
+	t.Parallel()
+
+	private, signer := newTestUserKey(t)
+	server := newTestSSHServerWithAuth(t, echoHandler, publicKeyAuth(signer.PublicKey()))
+
+	config := server.clientConfig()
+	config.Password = ""
+	config.PrivateKey = marshalTestPrivateKey(t, private, "hunter2")
+
+	_, err := config.getSSHClient()
+	if err == nil || !strings.Contains(err.Error(), "passphrase protected") {
+		t.Fatalf("expected missing passphrase error, got %v", err)
+	}
+
+	config.PrivateKeyPassphrase = "wrong"
+	if _, err := config.getSSHClient(); err == nil {
+		t.Fatal("expected wrong passphrase to fail")
+	}
+
+	config.PrivateKeyPassphrase = "hunter2"
+	assertCommandSucceeds(t, config)
+}
+
+func TestCertificateAuthentication(t *testing.T) {
+	t.Parallel()
+
+	_, authority := newTestUserKey(t)
+	private, signer := newTestUserKey(t)
+	certificate := newTestUserCertificate(t, signer.PublicKey(), authority)
+
+	server := newTestSSHServerWithAuth(t, echoHandler, certificateAuth(authority.PublicKey()))
+
+	config := server.clientConfig()
+	config.Password = ""
+	config.PrivateKey = marshalTestPrivateKey(t, private, "")
+
+	if _, err := config.getSSHClient(); err == nil {
+		t.Fatal("expected plain key to be rejected by a certificate-only server")
+	}
+
+	config.Certificate = string(ssh.MarshalAuthorizedKey(certificate))
+	assertCommandSucceeds(t, config)
+}
+
+func TestCertificateMustMatchPrivateKey(t *testing.T) {
+	t.Parallel()
+
+	_, authority := newTestUserKey(t)
+	_, signer := newTestUserKey(t)
+	otherPrivate, _ := newTestUserKey(t)
+	certificate := newTestUserCertificate(t, signer.PublicKey(), authority)
+
+	config := &ClientConfig{
+		PrivateKey:  marshalTestPrivateKey(t, otherPrivate, ""),
+		Certificate: string(ssh.MarshalAuthorizedKey(certificate)),
+	}
+
+	if _, _, _, err := config.authMethods(); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("expected certificate mismatch error, got %v", err)
+	}
+}
+
+func TestAgentAuthentication(t *testing.T) {
+	t.Parallel()
+
+	private, signer := newTestUserKey(t)
+	server := newTestSSHServerWithAuth(t, echoHandler, publicKeyAuth(signer.PublicKey()))
+
+	config := server.clientConfig()
+	config.Password = ""
+	config.UseAgent = true
+	config.AgentSocket = startTestAgent(t, private)
+
+	assertCommandSucceeds(t, config)
+}
+
+func TestAgentCertificateAuthentication(t *testing.T) {
+	t.Parallel()
+
+	_, authority := newTestUserKey(t)
+	private, signer := newTestUserKey(t)
+	certificate := newTestUserCertificate(t, signer.PublicKey(), authority)
+
+	server := newTestSSHServerWithAuth(t, echoHandler, certificateAuth(authority.PublicKey()))
+
+	config := server.clientConfig()
+	config.Password = ""
+	config.UseAgent = true
+	config.AgentSocket = startTestAgent(t, private)
+	config.Certificate = string(ssh.MarshalAuthorizedKey(certificate))
+
+	assertCommandSucceeds(t, config)
+}
+
+func TestKeyboardInteractiveAuthentication(t *testing.T) {
+	t.Parallel()
+
+	server := newTestSSHServerWithAuth(t, echoHandler, func(config *ssh.ServerConfig) {
+		config.KeyboardInteractiveCallback = func(conn ssh.ConnMetadata, challenge ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			answers, err := challenge("", "", []string{"Password: "}, []bool{false})
+			if err != nil {
+				return nil, err
+			}
+			if len(answers) != 1 || answers[0] != testSSHPassword {
+				return nil, fmt.Errorf("invalid credentials")
+			}
+			return nil, nil
+		}
+	})
+
+	assertCommandSucceeds(t, server.clientConfig())
+}
+
+func TestAuthenticationErrorListsMethods(t *testing.T) {
+	t.Parallel()
+
+	server := newTestSSHServer(t, echoHandler)
+
+	config := server.clientConfig()
+	config.Password = "wrong"
+
+	_, err := config.getSSHClient()
+	var authErr *AuthenticationError
+	if !errors.As(err, &authErr) {
+		t.Fatalf("expected AuthenticationError, got %v", err)
+	}
+
+	if got := strings.Join(authErr.Methods, ","); got != "password,keyboard-interactive" {
+		t.Fatalf("unexpected methods %q", got)
+	}
+
+	if !strings.Contains(err.Error(), testSSHUser) {
+		t.Fatalf("expected error to name the user, got %v", err)
+	}
+}
+
+func TestNoAuthenticationMethod(t *testing.T) {
+	t.Parallel()
+
+	config := &ClientConfig{}
+	if _, _, _, err := config.authMethods(); err == nil || !strings.Contains(err.Error(), "no authentication method") {
+		t.Fatalf("expected no authentication method error, got %v", err)
+	}
+
+	_, authority := newTestUserKey(t)
+	_, signer := newTestUserKey(t)
+	config.Certificate = string(ssh.MarshalAuthorizedKey(newTestUserCertificate(t, signer.PublicKey(), authority)))
+	if _, _, _, err := config.authMethods(); err == nil || !strings.Contains(err.Error(), "requires a private key") {
+		t.Fatalf("expected certificate without key error, got %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package ssh_helper
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// dialThroughBastion opens a connection to addr tunnelled through the configured bastion. The
+// bastion connection is closed once the returned client is closed.
+func (c *ClientConfig) dialThroughBastion(addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	bastionAddr := fmt.Sprintf("%s:%d", c.Bastion.Host, c.Bastion.Port)
+
+	bastionClient, err := c.Bastion.getSSHClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to bastion %s: %w", bastionAddr, err)
+	}
+
+	ctx := context.Background()
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	conn, err := bastionClient.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		_ = bastionClient.Close()
+		return nil, fmt.Errorf("failed to reach %s through bastion %s: %w", addr, bastionAddr, err)
+	}
+
+	clientConn, channels, requests, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		_ = conn.Close()
+		_ = bastionClient.Close()
+		return nil, err
+	}
+
+	client := ssh.NewClient(clientConn, channels, requests)
+	log.Printf("[DEBUG] Connected to %s through bastion %s", addr, bastionAddr)
+
+	go func() {
+		_ = client.Wait()
+		_ = bastionClient.Close()
+	}()
+
+	return client, nil
+}
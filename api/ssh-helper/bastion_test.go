@@ -0,0 +1,150 @@
+package ssh_helper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunCommandThroughBastion(t *testing.T) {
+	t.Parallel()
+
+	target := newTestSSHServer(t, echoHandler)
+	bastion := newTestSSHServer(t, echoHandler)
+
+	config := target.clientConfig()
+	config.Bastion = bastion.clientConfig()
+
+	assertCommandSucceeds(t, config)
+
+	if got := bastion.forwards.Load(); got != 1 {
+		t.Fatalf("expected the connection to be forwarded by the bastion, got %d forwards", got)
+	}
+
+	if got := bastion.commands.Load(); got != 0 {
+		t.Fatalf("expected no commands to run on the bastion, got %d", got)
+	}
+
+	if got := target.commands.Load(); got != 1 {
+		t.Fatalf("expected the command to run on the target, got %d", got)
+	}
+}
+
+func TestRunCommandThroughChainedBastions(t *testing.T) {
+	t.Parallel()
+
+	target := newTestSSHServer(t, echoHandler)
+	inner := newTestSSHServer(t, echoHandler)
+	outer := newTestSSHServer(t, echoHandler)
+
+	innerConfig := inner.clientConfig()
+	innerConfig.Bastion = outer.clientConfig()
+
+	config := target.clientConfig()
+	config.Bastion = innerConfig
+
+	assertCommandSucceeds(t, config)
+
+	if outer.forwards.Load() != 1 || inner.forwards.Load() != 1 {
+		t.Fatalf("expected one forward per hop, got outer=%d inner=%d", outer.forwards.Load(), inner.forwards.Load())
+	}
+}
+
+func TestUploadFileThroughBastion(t *testing.T) {
+	t.Parallel()
+
+	target := newTestSSHServer(t, echoHandler)
+	bastion := newTestSSHServer(t, echoHandler)
+
+	config := target.clientConfig()
+	config.Bastion = bastion.clientConfig()
+
+	localPath := filepath.Join(t.TempDir(), "payload.txt")
+	if err := os.WriteFile(localPath, []byte("through the jump host"), 0o600); err != nil {
+		t.Fatalf("failed to write local file: %v", err)
+	}
+
+	remotePath := filepath.Join(t.TempDir(), "uploaded", "payload.txt")
+	if _, err := config.UploadFile(context.Background(), localPath, remotePath); err != nil {
+		t.Fatalf("upload through bastion failed: %v", err)
+	}
+
+	content, err := os.ReadFile(remotePath)
+	if err != nil {
+		t.Fatalf("expected uploaded file: %v", err)
+	}
+
+	if string(content) != "through the jump host" {
+		t.Fatalf("unexpected uploaded content %q", content)
+	}
+
+	if got := bastion.forwards.Load(); got != 1 {
+		t.Fatalf("expected the upload to be forwarded by the bastion, got %d forwards", got)
+	}
+}
+
+func TestBastionAuthenticationFailure(t *testing.T) {
+	t.Parallel()
+
+	target := newTestSSHServer(t, echoHandler)
+	bastion := newTestSSHServer(t, echoHandler)
+
+	config := target.clientConfig()
+	config.Bastion = bastion.clientConfig()
+	config.Bastion.Password = "wrong"
+
+	_, err := config.getSSHClient()
+	var authErr *AuthenticationError
+	if !errors.As(err, &authErr) {
+		t.Fatalf("expected AuthenticationError from the bastion, got %v", err)
+	}
+
+	if authErr.Address != fmt.Sprintf("%s:%d", config.Bastion.Host, config.Bastion.Port) {
+		t.Fatalf("expected the bastion address in the error, got %q", authErr.Address)
+	}
+
+	if got := target.connections.Load(); got != 0 {
+		t.Fatalf("expected no connection to the target, got %d", got)
+	}
+}
+
+func TestDialOpensFirstConnection(t *testing.T) {
+	t.Parallel()
+
+	target := newTestSSHServer(t, echoHandler)
+	bastion := newTestSSHServer(t, echoHandler)
+
+	var dialed []string
+	dial := func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	config := target.clientConfig()
+	config.Dial = dial
+	assertCommandSucceeds(t, config)
+
+	// Through a bastion only the bastion is dialled, the target is reached through it
+	config = target.clientConfig()
+	config.Bastion = bastion.clientConfig()
+	config.Bastion.Dial = dial
+	assertCommandSucceeds(t, config)
+
+	want := []string{target.listener.Addr().String(), bastion.listener.Addr().String()}
+	if fmt.Sprint(dialed) != fmt.Sprint(want) {
+		t.Fatalf("expected %v to be dialled, got %v", want, dialed)
+	}
+
+	config.Bastion.Dial = func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		return nil, errors.New("proxy unreachable")
+	}
+	if _, _, _, err := config.runCommand(context.Background(), "whoami"); err == nil || !strings.Contains(err.Error(), "proxy unreachable") {
+		t.Fatalf("expected the error of the dial, got %v", err)
+	}
+}
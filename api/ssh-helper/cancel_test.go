@@ -0,0 +1,101 @@
+package ssh_helper
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// hangingHandler blocks "hang" commands until the test finishes, emulating a remote process that
+// never ends on its own.
+func hangingHandler(t *testing.T) func(command string) (string, string, int) {
+	t.Helper()
+
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+
+	return func(command string) (string, string, int) {
+		if command == "hang" {
+			<-release
+			return "", "", 0
+		}
+
+		return echoHandler(command)
+	}
+}
+
+func waitForCount(t *testing.T, name string, count func() int32) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for count() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", name)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRunCommandDeadlineExceeded(t *testing.T) {
+	t.Parallel()
+
+	server := newTestSSHServer(t, hangingHandler(t))
+	config := server.clientConfig()
+	config.ClientPool = NewClientPool(context.Background(), config)
+	t.Cleanup(func() { config.ClientPool.Close(context.Background()) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+	_, _, _, err := config.runCommand(ctx, "hang")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Fatalf("expected the command to be interrupted promptly, took %s", elapsed)
+	}
+
+	waitForCount(t, "the remote process to be signalled", server.signals.Load)
+
+	// The interrupted connection is discarded and the next command uses a fresh one.
+	assertCommandSucceeds(t, config)
+}
+
+func TestRunCommandCanceled(t *testing.T) {
+	t.Parallel()
+
+	server := newTestSSHServer(t, hangingHandler(t))
+	config := server.clientConfig()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		waitForCount(t, "the command to start", server.commands.Load)
+		cancel()
+	}()
+
+	_, _, _, err := config.runCommand(ctx, "hang")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestRunCommandAlreadyCanceled(t *testing.T) {
+	t.Parallel()
+
+	server := newTestSSHServer(t, echoHandler)
+	config := server.clientConfig()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, _, _, err := config.runCommand(ctx, "hello"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	if got := server.connections.Load(); got != 0 {
+		t.Fatalf("expected no connection for a canceled context, got %d", got)
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -27,8 +28,10 @@ import (
 	"time"
 	"unicode/utf16"
 
+	pool "github.com/jolestar/go-commons-pool/v2"
 	"github.com/pkg/sftp"
 	"github.com/taliesins/terraform-provider-hyperv/api/commandresult"
+	"github.com/taliesins/terraform-provider-hyperv/api/redact"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -54,123 +57,206 @@ type ClientConfig struct {
 	ElevatedCommand string // Command to use for privilege escalation (e.g., "sudo", "doas")
 	Vars            string // Environment variables to set
 	IsWindows       bool   // True if remote host is Windows (uses PowerShell instead of bash)
+	PowerShell      string // Optional: PowerShell executable used on Windows hosts, PowerShellDesktop (default) or PowerShellCore
 	Concurrency     int    // Optional: number of concurrent uploads for directories (default: GOMAXPROCS)
+
+	// Scripts whose command line would exceed MaxCommandLength are uploaded to RemoteScriptDir and
+	// run with -File. Both are optional, see DefaultMaxCommandLength and DefaultRemoteScriptDir.
+	MaxCommandLength int
+	RemoteScriptDir  string
+
+	// Additional authentication options. Keyboard-interactive authentication is offered with
+	// Password whenever a password is configured.
+	PrivateKeyPassphrase string // Passphrase for an encrypted PrivateKey or PrivateKeyPath
+	Certificate          string // OpenSSH user certificate content, signed with the private key or an agent key
+	CertificatePath      string // Path to an OpenSSH user certificate
+	UseAgent             bool   // Authenticate with the keys held by ssh-agent
+	AgentSocket          string // Optional: ssh-agent socket (default: SSH_AUTH_SOCK)
+
+	// Dial opens the TCP connection to Host, or to the first bastion, for example through a proxy. Optional,
+	// connections are opened directly when nil.
+	Dial func(ctx context.Context, network string, addr string) (net.Conn, error)
+
+	// Bastion is the jump host used to reach Host. Bastions can be chained by setting
+	// Bastion on the bastion configuration.
+	Bastion *ClientConfig
+
+	// ClientPool holds reusable SSH connections, see NewClientPool. When nil every operation
+	// opens its own connection.
+	ClientPool *pool.ObjectPool
+
+	// Host key verification. When none of these are set the default known_hosts file is used.
+	KnownHostsPath      string   // Path to a known_hosts file
+	KnownHosts          string   // Inline known_hosts content
+	HostKeyFingerprints []string // Pinned SHA256 host key fingerprints
+	TrustOnFirstUse     bool     // Record unknown host keys in KnownHostsPath instead of rejecting them, unless fingerprints are pinned
+
+	// Redactor masks secrets in logged scripts and commands and in errors. Optional.
+	Redactor *redact.Redactor
 }
 
 // getSSHClient creates and returns an SSH client connection
 func (c *ClientConfig) getSSHClient() (*ssh.Client, error) {
-	var authMethods []ssh.AuthMethod
-
-	// Add password authentication if provided
-	if c.Password != "" {
-		authMethods = append(authMethods, ssh.Password(c.Password))
-	}
-
-	// Add private key authentication if provided
-	if c.PrivateKey != "" {
-		signer, err := ssh.ParsePrivateKey([]byte(c.PrivateKey))
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse private key: %w", err)
-		}
-		authMethods = append(authMethods, ssh.PublicKeys(signer))
-	} else if c.PrivateKeyPath != "" {
-		// Expand ~ to home directory
-		keyPath := c.PrivateKeyPath
-		if strings.HasPrefix(keyPath, "~/") {
-			homeDir, err := os.UserHomeDir()
-			if err != nil {
-				return nil, fmt.Errorf("failed to get home directory: %w", err)
-			}
-			keyPath = filepath.Join(homeDir, keyPath[2:])
-		}
-
-		keyBytes, err := os.ReadFile(keyPath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read private key file: %w", err)
-		}
-		signer, err := ssh.ParsePrivateKey(keyBytes)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse private key from file: %w", err)
-		}
-		authMethods = append(authMethods, ssh.PublicKeys(signer))
+	authMethods, authDescriptions, cleanup, err := c.authMethods()
+	defer cleanup()
+	if err != nil {
+		return nil, err
 	}
 
-	if len(authMethods) == 0 {
-		return nil, fmt.Errorf("no authentication method provided (password or private key required)")
+	hostKeyCallback, err := c.hostKeyCallback()
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure host key verification: %w", err)
 	}
 
 	config := &ssh.ClientConfig{
-		User: c.User,
-		Auth: authMethods,
-		// nosemgrep: go.lang.security.audit.crypto.insecure_ssh.avoid-ssh-insecure-ignore-host-key
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // TODO: Make this configurable for production
+		User:            c.User,
+		Auth:            authMethods,
+		HostKeyCallback: hostKeyCallback,
 		Timeout:         c.Timeout,
 	}
 
 	addr := fmt.Sprintf("%s:%d", c.Host, c.Port)
-	client, err := ssh.Dial("tcp", addr, config)
+
+	var client *ssh.Client
+	if c.Bastion != nil {
+		client, err = c.dialThroughBastion(addr, config)
+	} else {
+		client, err = c.dial(addr, config)
+	}
 	if err != nil {
+		if isAuthenticationError(err) {
+			return nil, &AuthenticationError{User: c.User, Address: addr, Methods: authDescriptions, Err: err}
+		}
 		return nil, fmt.Errorf("failed to dial SSH: %w", err)
 	}
 
 	return client, nil
 }
 
-// runCommand executes a command over SSH and returns the output
+// dial opens a connection to addr with Dial, or directly when it is not set
+func (c *ClientConfig) dial(addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	if c.Dial == nil {
+		return ssh.Dial("tcp", addr, config)
+	}
+
+	ctx := context.Background()
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	conn, err := c.Dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	clientConn, channels, requests, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return ssh.NewClient(clientConn, channels, requests), nil
+}
+
+// runCommand executes a command over SSH and returns the output. PowerShell scripts whose encoded
+// command line would exceed MaxCommandLength are uploaded and run as a file instead.
 func (c *ClientConfig) runCommand(ctx context.Context, command string) (stdout, stderr string, exitCode int, err error) {
+	return c.runCommandStreaming(ctx, command, nil)
+}
+
+// runCommandStreaming executes a command over SSH like runCommand, copying its stdout to output, if any, while it runs
+func (c *ClientConfig) runCommandStreaming(ctx context.Context, command string, output io.Writer) (stdout, stderr string, exitCode int, err error) {
+	commandToRun := c.prepareCommand(command)
+
+	if c.IsWindows && len(commandToRun) > c.maxCommandLength() {
+		if script := c.withVars(command); !isPowerShellCommandInvocation(script) {
+			return c.runScriptFile(ctx, script, output)
+		}
+	}
+
+	return c.runRawCommand(ctx, commandToRun, output)
+}
+
+// runRawCommand executes a command over SSH as is, leaving its interpretation to the remote default shell
+func (c *ClientConfig) runRawCommand(ctx context.Context, commandToRun string, output io.Writer) (stdout, stderr string, exitCode int, err error) {
 	if err := ctx.Err(); err != nil {
 		return "", "", -1, err
 	}
 
-	client, err := c.getSSHClient()
+	log.Printf("[DEBUG] Executing SSH command: %s", c.Redactor.String(commandToRun))
+
+	err = c.withClient(ctx, func(client *ssh.Client) error {
+		stdout, stderr, exitCode, err = runSession(ctx, client, commandToRun, output)
+		return err
+	})
 	if err != nil {
-		return "", "", -1, err
+		return stdout, stderr, -1, err
 	}
-	defer client.Close()
 
-	session, err := client.NewSession()
+	return stdout, stderr, exitCode, nil
+}
+
+// runSession runs a command in a new session on an existing SSH connection, copying its stdout to
+// output, if any. A non-zero exit status is returned as exitCode rather than as an error. When ctx
+// is done the remote process is sent a KILL signal and the session is closed.
+func runSession(ctx context.Context, client *ssh.Client, commandToRun string, output io.Writer) (stdout, stderr string, exitCode int, err error) {
+	session, err := newSession(client)
 	if err != nil {
-		return "", "", -1, fmt.Errorf("failed to create session: %w", err)
+		return "", "", -1, err
 	}
 	defer session.Close()
 
 	var stdoutBuf, stderrBuf bytes.Buffer
 	session.Stdout = &stdoutBuf
+	if output != nil {
+		session.Stdout = io.MultiWriter(&stdoutBuf, output)
+	}
 	session.Stderr = &stderrBuf
 
-	commandToRun := c.prepareCommand(command)
-
-	log.Printf("[DEBUG] Executing SSH command: %s", commandToRun)
+	stop := context.AfterFunc(ctx, func() {
+		_ = session.Signal(ssh.SIGKILL)
+		_ = session.Close()
+	})
 
-	err = session.Run(commandToRun)
+	runErr := session.Run(commandToRun)
 	stdout = stdoutBuf.String()
 	stderr = stderrBuf.String()
 
-	if err != nil {
-		if exitErr, ok := err.(*ssh.ExitError); ok {
-			exitCode = exitErr.ExitStatus()
-		} else {
-			return stdout, stderr, -1, fmt.Errorf("command execution failed: %w", err)
+	if !stop() {
+		return stdout, stderr, -1, fmt.Errorf("command interrupted: %w", ctx.Err())
+	}
+
+	if runErr != nil {
+		if exitErr, ok := runErr.(*ssh.ExitError); ok {
+			return stdout, stderr, exitErr.ExitStatus(), nil
 		}
+		return stdout, stderr, -1, fmt.Errorf("command execution failed: %w", runErr)
 	}
 
-	return stdout, stderr, exitCode, nil
+	return stdout, stderr, 0, nil
 }
 
-func (c *ClientConfig) prepareCommand(command string) string {
-	prepared := command
+// withVars prepends the configured environment variables to the command
+func (c *ClientConfig) withVars(command string) string {
+	if c.Vars == "" {
+		return command
+	}
 
-	if c.Vars != "" {
-		if c.IsWindows {
-			prepared = fmt.Sprintf("%s\n%s", c.Vars, prepared)
-		} else {
-			prepared = fmt.Sprintf("%s; %s", c.Vars, prepared)
-		}
+	if c.IsWindows {
+		return fmt.Sprintf("%s\n%s", c.Vars, command)
 	}
 
+	return fmt.Sprintf("%s; %s", c.Vars, command)
+}
+
+func (c *ClientConfig) prepareCommand(command string) string {
+	prepared := c.withVars(command)
+
 	if c.IsWindows {
 		if !isPowerShellCommandInvocation(prepared) {
-			prepared = wrapPowerShellEncodedCommand(prepared)
+			prepared = wrapPowerShellEncodedCommand(c.powerShellExecutable(), prepared)
 		}
 
 		return prepared
@@ -259,13 +345,10 @@ func extractLeadingCommandToken(command string) (string, bool, bool) {
 	return token, true, false
 }
 
-func wrapPowerShellEncodedCommand(command string) string {
-	prepend := "if (Test-Path variable:global:ProgressPreference) { $ProgressPreference = 'SilentlyContinue' }; "
-
-	trimmed := strings.TrimSpace(command)
-	if !strings.Contains(strings.ToLower(trimmed), "$progresspreference") {
-		command = prepend + command
-	}
+// wrapPowerShellEncodedCommand invokes the given PowerShell executable explicitly so the command runs
+// the same way whatever the remote default shell is.
+func wrapPowerShellEncodedCommand(executable string, command string) string {
+	command = silenceProgress(command)
 
 	utf16Command := utf16.Encode([]rune(command))
 	bytesCommand := make([]byte, len(utf16Command)*2)
@@ -276,7 +359,30 @@ func wrapPowerShellEncodedCommand(command string) string {
 
 	encoded := base64.StdEncoding.EncodeToString(bytesCommand)
 
-	return fmt.Sprintf("powershell -NoProfile -NonInteractive -ExecutionPolicy Bypass -EncodedCommand %s", encoded)
+	return fmt.Sprintf("%s -NoProfile -NonInteractive -ExecutionPolicy Bypass -EncodedCommand %s", executable, encoded)
+}
+
+// silenceProgress disables progress records, which are otherwise serialized to stderr over SSH,
+// unless the script sets $ProgressPreference itself.
+func silenceProgress(command string) string {
+	prepend := "if (Test-Path variable:global:ProgressPreference) { $ProgressPreference = 'SilentlyContinue' }; "
+
+	trimmed := strings.TrimSpace(command)
+	if !strings.Contains(strings.ToLower(trimmed), "$progresspreference") {
+		return prepend + command
+	}
+
+	return command
+}
+
+// RunScript runs a rendered script and returns its raw output and exit code
+func (c *ClientConfig) RunScript(ctx context.Context, command string) (stdout, stderr string, exitCode int, err error) {
+	return c.runCommand(ctx, command)
+}
+
+// RunScriptStreaming runs a rendered script like RunScript, copying its stdout to output while it runs
+func (c *ClientConfig) RunScriptStreaming(ctx context.Context, command string, output io.Writer) (stdout, stderr string, exitCode int, err error) {
+	return c.runCommandStreaming(ctx, command, output)
 }
 
 // RunFireAndForgetScript executes a script without waiting for or processing results
@@ -288,18 +394,15 @@ func (c *ClientConfig) RunFireAndForgetScript(ctx context.Context, script *templ
 	}
 
 	command := scriptRendered.String()
-	log.Printf("[DEBUG] Running fire and forget script:\n%s\n", command)
+	redactor := c.Redactor.WithArgs(args)
+	log.Printf("[DEBUG] Running fire and forget script:\n%s\n", redactor.String(command))
 
-	_, stderr, exitCode, err := c.runCommand(ctx, command)
+	stdout, stderr, exitCode, err := c.runCommand(ctx, command)
 	if err != nil {
-		return err
+		return redactor.Error(err)
 	}
 
-	if exitCode != 0 {
-		return fmt.Errorf("command failed with exit code %d: %s", exitCode, stderr)
-	}
-
-	return nil
+	return commandresult.CheckExitCode(redactor, exitCode, stdout, stderr)
 }
 
 // RunScriptWithResult executes a script and unmarshals JSON output into result
@@ -311,25 +414,58 @@ func (c *ClientConfig) RunScriptWithResult(ctx context.Context, script *template
 	}
 
 	command := scriptRendered.String()
-	log.Printf("[DEBUG] Running script with result:\n%s\n", command)
+	redactor := c.Redactor.WithArgs(args)
+	log.Printf("[DEBUG] Running script with result:\n%s\n", redactor.String(command))
 
 	stdout, stderr, exitCode, err := c.runCommand(ctx, command)
 	if err != nil {
-		return err
+		return redactor.Error(err)
 	}
 
-	return commandresult.DecodeJSON(exitCode, stdout, stderr, command, result)
+	return commandresult.DecodeJSON(redactor, exitCode, stdout, stderr, command, result)
+}
+
+// RunStreamingScript executes a script, passing its records to records while it runs, and unmarshals JSON output into
+// result unless result is nil
+func (c *ClientConfig) RunStreamingScript(ctx context.Context, script *template.Template, args interface{}, result interface{}, records commandresult.RecordHandler) error {
+	var scriptRendered bytes.Buffer
+	err := script.Execute(&scriptRendered, args)
+	if err != nil {
+		return fmt.Errorf("failed to render script template: %w", err)
+	}
+
+	command := scriptRendered.String()
+	redactor := c.Redactor.WithArgs(args)
+	log.Printf("[DEBUG] Running streaming script:\n%s\n", redactor.String(command))
+
+	stdout, stderr, exitCode, err := c.runCommandStreaming(ctx, command, commandresult.NewRecordWriter(redactor, records))
+	if err != nil {
+		return redactor.Error(err)
+	}
+
+	if result == nil {
+		return commandresult.CheckExitCode(redactor, exitCode, stdout, stderr)
+	}
+
+	return commandresult.DecodeJSON(redactor, exitCode, stdout, stderr, command, result)
 }
 
 // UploadFile uploads a local file to the remote system
 // Tries SFTP first, falls back to writing via PowerShell/shell commands
-func (c *ClientConfig) UploadFile(ctx context.Context, filePath string, remoteFilePath string) (string, error) {
-	client, err := c.getSSHClient()
+func (c *ClientConfig) UploadFile(ctx context.Context, filePath string, remoteFilePath string) (resolvedRemoteFilePath string, err error) {
+	err = c.withClient(ctx, func(client *ssh.Client) error {
+		resolvedRemoteFilePath, err = c.uploadFile(client, filePath, remoteFilePath)
+		return err
+	})
 	if err != nil {
 		return "", err
 	}
-	defer client.Close()
 
+	return resolvedRemoteFilePath, nil
+}
+
+// uploadFile uploads a local file over an existing SSH connection
+func (c *ClientConfig) uploadFile(client *ssh.Client, filePath string, remoteFilePath string) (string, error) {
 	log.Printf("[INFO] Uploading file %s to %s", filePath, remoteFilePath)
 
 	// Open local file for streaming (avoid reading whole file into memory)
@@ -351,6 +487,10 @@ func (c *ClientConfig) UploadFile(ctx context.Context, filePath string, remoteFi
 		return remoteFilePath, nil
 	}
 
+	if isBrokenConnectionError(err) {
+		return "", err
+	}
+
 	log.Printf("[DEBUG] SFTP upload failed: %v, trying command-based upload", err)
 
 	// Explicitly reset file handle before fallback (for clarity, though fallback reads independently)
@@ -380,6 +520,9 @@ func (c *ClientConfig) uploadViaSFTP(client *ssh.Client, in io.Reader, remoteFil
 	// Create SFTP client
 	sftpClient, err := sftp.NewClient(client)
 	if err != nil {
+		if isBrokenConnectionError(err) {
+			return &connectionOpenError{err: fmt.Errorf("failed to create SFTP client: %w", err)}
+		}
 		return fmt.Errorf("failed to create SFTP client: %w", err)
 	}
 	defer sftpClient.Close()
@@ -420,9 +563,9 @@ func (c *ClientConfig) uploadViaSFTP(client *ssh.Client, in io.Reader, remoteFil
 
 // uploadViaCommands uploads a file by writing it via shell commands
 func (c *ClientConfig) uploadViaCommands(client *ssh.Client, fileData []byte, remoteFilePath string) error {
-	session, err := client.NewSession()
+	session, err := newSession(client)
 	if err != nil {
-		return fmt.Errorf("failed to create session: %w", err)
+		return err
 	}
 	defer session.Close()
 
@@ -435,32 +578,32 @@ func (c *ClientConfig) uploadViaCommands(client *ssh.Client, fileData []byte, re
 		// Create directory first
 		remoteDir := filepath.Dir(remoteFilePath)
 		if remoteDir != "" && remoteDir != "." {
-			dirSession, err := client.NewSession()
-			if err == nil && dirSession != nil {
-				dirCmd := fmt.Sprintf("powershell -Command \"New-Item -ItemType Directory -Force -Path '%s' | Out-Null\"", remoteDir)
-				if runErr := dirSession.Run(dirCmd); runErr != nil {
-					dirSession.Close()
-					return fmt.Errorf("failed to ensure remote directory %q: %w", remoteDir, runErr)
-				}
-				dirSession.Close()
-			} else if err != nil {
+			dirSession, err := newSession(client)
+			if err != nil {
 				return fmt.Errorf("failed to create session for directory setup: %w", err)
 			}
+			dirCmd := c.prepareCommand(fmt.Sprintf("New-Item -ItemType Directory -Force -Path %s | Out-Null", powerShellLiteral(remoteDir)))
+			if runErr := dirSession.Run(dirCmd); runErr != nil {
+				dirSession.Close()
+				return fmt.Errorf("failed to ensure remote directory %q: %w", remoteDir, runErr)
+			}
+			dirSession.Close()
 		} // Write file via PowerShell
-		command = fmt.Sprintf(
-			"powershell -Command \"$bytes = [System.Convert]::FromBase64String('%s'); [System.IO.File]::WriteAllBytes('%s', $bytes)\"",
+		command = c.prepareCommand(fmt.Sprintf(
+			"$bytes = [System.Convert]::FromBase64String('%s'); [System.IO.File]::WriteAllBytes(%s, $bytes)",
 			encoded,
-			remoteFilePath,
-		)
+			powerShellLiteral(remoteFilePath),
+		))
 	} else {
 		// Use Unix commands
 		remoteDir := filepath.Dir(remoteFilePath)
 		if remoteDir != "" && remoteDir != "." {
-			dirSession, err := client.NewSession()
-			if err == nil && dirSession != nil {
-				_ = dirSession.Run(fmt.Sprintf("mkdir -p '%s'", remoteDir)) // Ignore error, directory might exist
-				dirSession.Close()
+			dirSession, err := newSession(client)
+			if err != nil {
+				return fmt.Errorf("failed to create session for directory setup: %w", err)
 			}
+			_ = dirSession.Run(fmt.Sprintf("mkdir -p '%s'", remoteDir)) // Ignore error, directory might exist
+			dirSession.Close()
 		}
 
 		// Write file via base64 decode
@@ -486,21 +629,29 @@ func (c *ClientConfig) UploadDirectory(ctx context.Context, rootPath string, exc
 		remoteRootPath = fmt.Sprintf("/tmp/hyperv-upload-%d", time.Now().Unix())
 	}
 
-	client, err := c.getSSHClient()
+	err = c.withClient(ctx, func(client *ssh.Client) error {
+		remoteAbsoluteFilePaths, err = c.uploadDirectory(client, rootPath, remoteRootPath, excludeList)
+		return err
+	})
 	if err != nil {
 		return "", nil, err
 	}
-	defer client.Close()
 
+	log.Printf("[DEBUG] Successfully uploaded directory to %s with %d files", remoteRootPath, len(remoteAbsoluteFilePaths))
+	return remoteRootPath, remoteAbsoluteFilePaths, nil
+}
+
+// uploadDirectory uploads the files below rootPath into remoteRootPath over an existing SSH connection
+func (c *ClientConfig) uploadDirectory(client *ssh.Client, rootPath string, remoteRootPath string, excludeList []string) (remoteAbsoluteFilePaths []string, err error) {
 	// Create remote directory
-	session, err := client.NewSession()
+	session, err := newSession(client)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to create session: %w", err)
+		return nil, err
 	}
 
 	var mkdirCmd string
 	if c.IsWindows {
-		mkdirCmd = fmt.Sprintf("powershell -Command \"New-Item -ItemType Directory -Force -Path '%s'\"", remoteRootPath)
+		mkdirCmd = c.prepareCommand(fmt.Sprintf("New-Item -ItemType Directory -Force -Path %s | Out-Null", powerShellLiteral(remoteRootPath)))
 	} else {
 		mkdirCmd = fmt.Sprintf("mkdir -p %s", remoteRootPath)
 	}
@@ -508,7 +659,7 @@ func (c *ClientConfig) UploadDirectory(ctx context.Context, rootPath string, exc
 	err = session.Run(mkdirCmd)
 	session.Close()
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to create remote directory: %w", err)
+		return nil, fmt.Errorf("failed to create remote directory: %w", err)
 	}
 
 	// Walk through local directory and collect files
@@ -537,13 +688,16 @@ func (c *ClientConfig) UploadDirectory(ctx context.Context, rootPath string, exc
 	})
 
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to list files: %w", err)
+		return nil, fmt.Errorf("failed to list files: %w", err)
 	}
 
 	remoteAbsoluteFilePaths = []string{}
 
 	// Try to create a single SFTP client to perform concurrent uploads
 	sftpClient, sftpErr := sftp.NewClient(client)
+	if isBrokenConnectionError(sftpErr) {
+		return nil, fmt.Errorf("failed to create SFTP client: %w", sftpErr)
+	}
 	if sftpErr != nil {
 		// If SFTP creation fails, fallback to existing per-file upload (which itself will attempt SFTP then fallback)
 		for _, path := range files {
@@ -557,15 +711,15 @@ func (c *ClientConfig) UploadDirectory(ctx context.Context, rootPath string, exc
 				remotePath = filepath.Join(remoteRootPath, relPath)
 			}
 
-			_, err := c.UploadFile(ctx, path, remotePath)
+			_, err := c.uploadFile(client, path, remotePath)
 			if err != nil {
-				return "", nil, fmt.Errorf("failed to upload file %s: %w", path, err)
+				return nil, fmt.Errorf("failed to upload file %s: %w", path, err)
 			}
 			remoteAbsoluteFilePaths = append(remoteAbsoluteFilePaths, remotePath)
 		}
 
-		log.Printf("[DEBUG] Successfully uploaded directory to %s with %d files (fallback path)", remoteRootPath, len(remoteAbsoluteFilePaths))
-		return remoteRootPath, remoteAbsoluteFilePaths, nil
+		log.Printf("[DEBUG] Uploaded directory to %s with %d files (fallback path)", remoteRootPath, len(remoteAbsoluteFilePaths))
+		return remoteAbsoluteFilePaths, nil
 	}
 	defer sftpClient.Close()
 
@@ -669,12 +823,11 @@ func (c *ClientConfig) UploadDirectory(ctx context.Context, rootPath string, exc
 	// check results
 	for err := range results {
 		if err != nil {
-			return "", nil, fmt.Errorf("failed to upload directory: %w", err)
+			return nil, fmt.Errorf("failed to upload directory: %w", err)
 		}
 	}
 
-	log.Printf("[DEBUG] Successfully uploaded directory to %s with %d files", remoteRootPath, len(remoteAbsoluteFilePaths))
-	return remoteRootPath, remoteAbsoluteFilePaths, nil
+	return remoteAbsoluteFilePaths, nil
 }
 
 // FileExists checks if a file exists on the remote system
@@ -683,7 +836,7 @@ func (c *ClientConfig) FileExists(ctx context.Context, remoteFilePath string) (b
 
 	var command string
 	if c.IsWindows {
-		command = fmt.Sprintf("powershell -Command \"Test-Path -Path '%s' -PathType Leaf\"", remoteFilePath)
+		command = fmt.Sprintf("Test-Path -LiteralPath %s -PathType Leaf", powerShellLiteral(remoteFilePath))
 	} else {
 		command = fmt.Sprintf("test -f '%s' && echo 'true' || echo 'false'", remoteFilePath)
 	}
@@ -720,7 +873,7 @@ func (c *ClientConfig) DirectoryExists(ctx context.Context, remoteDirectoryPath
 
 	var command string
 	if c.IsWindows {
-		command = fmt.Sprintf("powershell -Command \"Test-Path -Path '%s' -PathType Container\"", remoteDirectoryPath)
+		command = fmt.Sprintf("Test-Path -LiteralPath %s -PathType Container", powerShellLiteral(remoteDirectoryPath))
 	} else {
 		command = fmt.Sprintf("test -d '%s' && echo 'true' || echo 'false'", remoteDirectoryPath)
 	}
@@ -757,7 +910,7 @@ func (c *ClientConfig) DeleteFileOrDirectory(ctx context.Context, remotePath str
 
 	var command string
 	if c.IsWindows {
-		command = fmt.Sprintf("powershell -Command \"Remove-Item -Path '%s' -Recurse -Force -ErrorAction SilentlyContinue\"", remotePath)
+		command = fmt.Sprintf("Remove-Item -LiteralPath %s -Recurse -Force -ErrorAction SilentlyContinue", powerShellLiteral(remotePath))
 	} else {
 		command = fmt.Sprintf("rm -rf '%s'", remotePath)
 	}
@@ -774,28 +927,3 @@ func (c *ClientConfig) DeleteFileOrDirectory(ctx context.Context, remotePath str
 	log.Printf("[DEBUG] Successfully deleted: %s", remotePath)
 	return nil
 }
-
-// ValidatePowerShellShell verifies that PowerShell is available as the default shell
-// by executing a simple PowerShell command. Returns an error if the shell is not
-// configured to PowerShell.
-func (c *ClientConfig) ValidatePowerShellShell(ctx context.Context) error {
-	if !c.IsWindows {
-		return nil
-	}
-
-	stdout, stderr, exitCode, err := c.runCommand(ctx, "(Get-Host).Version.ToString()")
-	if err != nil {
-		return fmt.Errorf("failed to validate PowerShell shell: %w", err)
-	}
-
-	if exitCode != 0 {
-		return fmt.Errorf("PowerShell shell validation failed with exit code %d: %s", exitCode, stderr)
-	}
-
-	if strings.TrimSpace(stdout) == "" {
-		return fmt.Errorf("PowerShell shell validation failed: empty output from PowerShell command. Ensure the OpenSSH DefaultShell is set to PowerShell. See: https://learn.microsoft.com/en-us/windows-server/administration/OpenSSH/openssh-server-configuration#configuring-the-default-shell-for-openssh-in-windows")
-	}
-
-	log.Printf("[DEBUG] PowerShell shell validation successful: %s", strings.TrimSpace(stdout))
-	return nil
-}
@@ -5,10 +5,14 @@ import (
 	"encoding/base64"
 	"os"
 	"strings"
+	"sync"
 	"testing"
 	"text/template"
 	"time"
 	"unicode/utf16"
+
+	"github.com/taliesins/terraform-provider-hyperv/api/commandresult"
+	"golang.org/x/crypto/ssh"
 )
 
 // TestClientConfig_Basic tests basic SSH client configuration
@@ -215,6 +219,33 @@ func TestClientConfig_RunScriptWithResult(t *testing.T) {
 	}
 }
 
+// TestClientConfig_RunStreamingScript tests that records in the output of a script reach the handler
+func TestClientConfig_RunStreamingScript(t *testing.T) {
+	t.Parallel()
+
+	server := newTestSSHServer(t, func(command string) (string, string, int) {
+		return commandresult.RecordMarker + `{"Stream":"Progress","Activity":"Exporting VM web","Status":"Copying","PercentComplete":30}` + "\n" +
+			commandresult.ResultBeginMarker + "\n" + `{"Name":"web"}` + "\n" + commandresult.ResultEndMarker + "\n", "", 0
+	})
+
+	var records []commandresult.Record
+	var result struct{ Name string }
+	err := server.clientConfig().RunStreamingScript(context.Background(), template.Must(template.New("test").Parse("Export-VM")), nil, &result, func(record commandresult.Record) {
+		records = append(records, record)
+	})
+	if err != nil {
+		t.Fatalf("Failed to run streaming script: %v", err)
+	}
+
+	if result.Name != "web" {
+		t.Errorf("Expected name %q, got %q", "web", result.Name)
+	}
+
+	if len(records) != 1 || records[0].Activity != "Exporting VM web" || records[0].PercentComplete != 30 {
+		t.Errorf("Expected the progress record, got %+v", records)
+	}
+}
+
 // TestClientConfig_RunFireAndForgetScript tests fire-and-forget script execution
 func TestClientConfig_RunFireAndForgetScript(t *testing.T) {
 	t.Parallel()
@@ -372,7 +403,7 @@ func TestWrapPowerShellEncodedCommand(t *testing.T) {
 	t.Parallel()
 
 	command := "$ErrorActionPreference = 'Stop'\nWrite-Output '{\"ok\":true}'"
-	wrapped := wrapPowerShellEncodedCommand(command)
+	wrapped := wrapPowerShellEncodedCommand(PowerShellDesktop, command)
 
 	prefix := "powershell -NoProfile -NonInteractive -ExecutionPolicy Bypass -EncodedCommand "
 	if !strings.HasPrefix(wrapped, prefix) {
@@ -404,7 +435,7 @@ func TestWrapPowerShellEncodedCommandAlreadyHasProgressPreference(t *testing.T)
 	t.Parallel()
 
 	command := "$ProgressPreference = 'SilentlyContinue'\n$ErrorActionPreference = 'Stop'\nWrite-Output 'test'"
-	wrapped := wrapPowerShellEncodedCommand(command)
+	wrapped := wrapPowerShellEncodedCommand(PowerShellDesktop, command)
 
 	prefix := "powershell -NoProfile -NonInteractive -ExecutionPolicy Bypass -EncodedCommand "
 	if !strings.HasPrefix(wrapped, prefix) {
@@ -431,7 +462,7 @@ func TestWrapPowerShellEncodedCommandCaseInsensitiveProgressPreference(t *testin
 	t.Parallel()
 
 	command := "$progresspreference = 'SilentlyContinue'\nWrite-Output 'test'"
-	wrapped := wrapPowerShellEncodedCommand(command)
+	wrapped := wrapPowerShellEncodedCommand(PowerShellDesktop, command)
 
 	prefix := "powershell -NoProfile -NonInteractive -ExecutionPolicy Bypass -EncodedCommand "
 	if !strings.HasPrefix(wrapped, prefix) {
@@ -652,3 +683,81 @@ func TestPrepareCommandWindowsWithVars(t *testing.T) {
 		t.Fatalf("expected original command to be included in decoded command, got %q", decodedStr)
 	}
 }
+
+// decodeEncodedCommand returns the script of a command wrapped by wrapPowerShellEncodedCommand
+func decodeEncodedCommand(t *testing.T, executable string, command string) string {
+	t.Helper()
+
+	prefix := executable + " -NoProfile -NonInteractive -ExecutionPolicy Bypass -EncodedCommand "
+	if !strings.HasPrefix(command, prefix) {
+		t.Fatalf("expected command prefix %q, got %q", prefix, command)
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(command, prefix))
+	if err != nil {
+		t.Fatalf("failed to decode base64: %v", err)
+	}
+
+	utf16Data := make([]uint16, len(decoded)/2)
+	for i := 0; i < len(utf16Data); i++ {
+		utf16Data[i] = uint16(decoded[i*2]) | uint16(decoded[i*2+1])<<8
+	}
+
+	return string(utf16.Decode(utf16Data))
+}
+
+func TestFileOperationsUseConfiguredPowerShell(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var commands []string
+	server := newTestSSHServer(t, func(command string) (string, string, int) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		commands = append(commands, command)
+		return "True", "", 0
+	})
+
+	config := server.clientConfig()
+	config.IsWindows = true
+	config.PowerShell = PowerShellCore
+
+	ctx := context.Background()
+	remotePath := "C:/Temp/it's [1]/$bytes.txt"
+
+	if _, err := config.FileExists(ctx, remotePath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.DirectoryExists(ctx, remotePath); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.DeleteFileOrDirectory(ctx, remotePath); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.withClient(ctx, func(client *ssh.Client) error {
+		return config.uploadViaCommands(client, []byte("content"), remotePath)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	want := []string{
+		"Test-Path -LiteralPath 'C:/Temp/it''s [1]/$bytes.txt' -PathType Leaf",
+		"Test-Path -LiteralPath 'C:/Temp/it''s [1]/$bytes.txt' -PathType Container",
+		"Remove-Item -LiteralPath 'C:/Temp/it''s [1]/$bytes.txt' -Recurse -Force",
+		"New-Item -ItemType Directory -Force -Path 'C:/Temp/it''s [1]' | Out-Null",
+		"[System.IO.File]::WriteAllBytes('C:/Temp/it''s [1]/$bytes.txt', $bytes)",
+	}
+	if len(commands) != len(want) {
+		t.Fatalf("expected %d commands, got %d: %v", len(want), len(commands), commands)
+	}
+
+	for i, command := range commands {
+		if script := decodeEncodedCommand(t, PowerShellCore, command); !strings.Contains(script, want[i]) {
+			t.Fatalf("expected command %d to run %q, got %q", i, want[i], script)
+		}
+	}
+}
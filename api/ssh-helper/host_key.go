@@ -0,0 +1,231 @@
+package ssh_helper
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// DefaultKnownHostsPath is the known_hosts file used when no other host key source is configured.
+const DefaultKnownHostsPath = "~/.ssh/known_hosts"
+
+// knownHostsWriteLock serialises trust-on-first-use writes so concurrent connections
+// to the same host do not append duplicate entries.
+var knownHostsWriteLock sync.Mutex
+
+// HostKeyError is returned when the host key presented by the server could not be verified.
+type HostKeyError struct {
+	Host        string
+	KeyType     string
+	Fingerprint string
+	Reason      string
+}
+
+func (e *HostKeyError) Error() string {
+	return fmt.Sprintf("host key verification failed for %s (%s %s): %s", e.Host, e.KeyType, e.Fingerprint, e.Reason)
+}
+
+// expandHomePath expands a leading ~/ to the current user's home directory.
+func expandHomePath(path string) (string, error) {
+	if !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+
+	return filepath.Join(homeDir, path[2:]), nil
+}
+
+// NormalizeHostKeyFingerprint converts a fingerprint into the `SHA256:<base64>` form produced
+// by ssh.FingerprintSHA256. Fingerprints without the prefix or with base64 padding are accepted.
+func NormalizeHostKeyFingerprint(fingerprint string) (string, error) {
+	trimmed := strings.TrimSpace(fingerprint)
+	if trimmed == "" {
+		return "", fmt.Errorf("host key fingerprint must not be empty")
+	}
+
+	if idx := strings.Index(trimmed, ":"); idx != -1 {
+		if !strings.EqualFold(trimmed[:idx], "SHA256") {
+			return "", fmt.Errorf("host key fingerprint %q must be a SHA256 fingerprint", fingerprint)
+		}
+		trimmed = trimmed[idx+1:]
+	}
+
+	trimmed = strings.TrimRight(trimmed, "=")
+	if len(trimmed) != 43 {
+		return "", fmt.Errorf("host key fingerprint %q is not a valid SHA256 fingerprint", fingerprint)
+	}
+
+	return "SHA256:" + trimmed, nil
+}
+
+// hostKeyCallback builds the host key callback for a connection. A key is accepted when it matches
+// one of the pinned fingerprints or an entry in the known_hosts sources. Unknown hosts are recorded
+// to the known_hosts file when TrustOnFirstUse is enabled and no fingerprint is pinned; changed keys
+// and keys matching none of the pinned fingerprints are always rejected.
+func (c *ClientConfig) hostKeyCallback() (ssh.HostKeyCallback, error) {
+	fingerprints := make(map[string]struct{}, len(c.HostKeyFingerprints))
+	for _, fingerprint := range c.HostKeyFingerprints {
+		normalized, err := NormalizeHostKeyFingerprint(fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		fingerprints[normalized] = struct{}{}
+	}
+
+	knownHostsPath := c.KnownHostsPath
+	if knownHostsPath == "" && len(fingerprints) == 0 && c.KnownHosts == "" {
+		knownHostsPath = DefaultKnownHostsPath
+	}
+
+	knownHostsPath, err := expandHomePath(knownHostsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var knownHostsFiles []string
+	if knownHostsPath != "" {
+		if _, statErr := os.Stat(knownHostsPath); statErr == nil {
+			knownHostsFiles = append(knownHostsFiles, knownHostsPath)
+		} else if !os.IsNotExist(statErr) {
+			return nil, fmt.Errorf("failed to read known_hosts file %q: %w", knownHostsPath, statErr)
+		} else if c.KnownHostsPath != "" && !c.TrustOnFirstUse {
+			return nil, fmt.Errorf("known_hosts file %q does not exist", knownHostsPath)
+		}
+	}
+
+	var knownHostsCallback ssh.HostKeyCallback
+	if c.KnownHosts != "" {
+		inlineFile, err := writeInlineKnownHosts(c.KnownHosts)
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(inlineFile)
+		knownHostsFiles = append(knownHostsFiles, inlineFile)
+	}
+
+	if len(knownHostsFiles) > 0 {
+		knownHostsCallback, err = knownhosts.New(knownHostsFiles...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse known_hosts: %w", err)
+		}
+	}
+
+	trustOnFirstUse := c.TrustOnFirstUse
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		fingerprint := ssh.FingerprintSHA256(key)
+		newHostKeyError := func(reason string) error {
+			return &HostKeyError{
+				Host:        hostname,
+				KeyType:     key.Type(),
+				Fingerprint: fingerprint,
+				Reason:      reason,
+			}
+		}
+
+		if _, ok := fingerprints[fingerprint]; ok {
+			return nil
+		}
+
+		if knownHostsCallback != nil {
+			err := knownHostsCallback(hostname, remote, key)
+			if err == nil {
+				return nil
+			}
+
+			var keyErr *knownhosts.KeyError
+			if !errors.As(err, &keyErr) {
+				return newHostKeyError(err.Error())
+			}
+
+			if len(keyErr.Want) > 0 {
+				return newHostKeyError("the host key does not match the known_hosts entry; the host key may have changed or the connection is being intercepted")
+			}
+		}
+
+		// Pinned fingerprints are never widened by trust on first use
+		if len(fingerprints) > 0 {
+			return newHostKeyError("the host key does not match any pinned fingerprint")
+		}
+
+		if !trustOnFirstUse {
+			return newHostKeyError("the host is not listed in known_hosts; add it to known_hosts, pin its fingerprint or enable trust on first use")
+		}
+
+		if knownHostsPath == "" {
+			return newHostKeyError("trust on first use requires a known_hosts file path to record the host key")
+		}
+
+		if err := recordKnownHost(knownHostsPath, hostname, remote, key); err != nil {
+			return newHostKeyError(err.Error())
+		}
+
+		log.Printf("[WARN] Trusting SSH host key for %s on first use (%s %s), recorded in %s", hostname, key.Type(), fingerprint, knownHostsPath)
+		return nil
+	}, nil
+}
+
+func writeInlineKnownHosts(content string) (string, error) {
+	f, err := os.CreateTemp("", "hyperv-known-hosts-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary known_hosts file: %w", err)
+	}
+
+	_, err = f.WriteString(content)
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write temporary known_hosts file: %w", err)
+	}
+
+	return f.Name(), nil
+}
+
+// recordKnownHost appends the host key to the known_hosts file unless another connection
+// has already recorded it.
+func recordKnownHost(knownHostsPath string, hostname string, remote net.Addr, key ssh.PublicKey) error {
+	knownHostsWriteLock.Lock()
+	defer knownHostsWriteLock.Unlock()
+
+	if _, err := os.Stat(knownHostsPath); err == nil {
+		callback, err := knownhosts.New(knownHostsPath)
+		if err != nil {
+			return fmt.Errorf("failed to parse known_hosts file %q: %w", knownHostsPath, err)
+		}
+
+		if err := callback(hostname, remote, key); err == nil {
+			return nil
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(knownHostsPath), 0o700); err != nil {
+		return fmt.Errorf("failed to create directory for known_hosts file %q: %w", knownHostsPath, err)
+	}
+
+	f, err := os.OpenFile(knownHostsPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open known_hosts file %q: %w", knownHostsPath, err)
+	}
+	defer f.Close()
+
+	line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+	if _, err := f.WriteString(line + "\n"); err != nil {
+		return fmt.Errorf("failed to record host key in %q: %w", knownHostsPath, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,257 @@
+package ssh_helper
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	key, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatalf("failed to convert key: %v", err)
+	}
+
+	return key
+}
+
+func testRemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 22}
+}
+
+func TestNormalizeHostKeyFingerprint(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		input     string
+		expected  string
+		wantError bool
+	}{
+		{name: "prefixed", input: "SHA256:uNiVztksCsDhcc0u9e8BujQXVUpKZIDTMczCvj3tD2s", expected: "SHA256:uNiVztksCsDhcc0u9e8BujQXVUpKZIDTMczCvj3tD2s"},
+		{name: "lowercase prefix", input: "sha256:uNiVztksCsDhcc0u9e8BujQXVUpKZIDTMczCvj3tD2s", expected: "SHA256:uNiVztksCsDhcc0u9e8BujQXVUpKZIDTMczCvj3tD2s"},
+		{name: "unprefixed with padding", input: " uNiVztksCsDhcc0u9e8BujQXVUpKZIDTMczCvj3tD2s= ", expected: "SHA256:uNiVztksCsDhcc0u9e8BujQXVUpKZIDTMczCvj3tD2s"},
+		{name: "md5", input: "MD5:16:27:ac:a5:76:28:2d:36:63:1b:56:4d:eb:df:a6:48", wantError: true},
+		{name: "empty", input: "", wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := NormalizeHostKeyFingerprint(tt.input)
+			if tt.wantError {
+				if err == nil {
+					t.Fatalf("expected error for %q", tt.input)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got != tt.expected {
+				t.Fatalf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestHostKeyCallbackPinnedFingerprint(t *testing.T) {
+	t.Parallel()
+
+	key := newTestHostKey(t)
+	otherKey := newTestHostKey(t)
+
+	config := &ClientConfig{HostKeyFingerprints: []string{ssh.FingerprintSHA256(key)}}
+	callback, err := config.hostKeyCallback()
+	if err != nil {
+		t.Fatalf("failed to build callback: %v", err)
+	}
+
+	if err := callback("hyperv:22", testRemoteAddr(), key); err != nil {
+		t.Fatalf("expected pinned key to be accepted, got %v", err)
+	}
+
+	err = callback("hyperv:22", testRemoteAddr(), otherKey)
+	var hostKeyErr *HostKeyError
+	if !errors.As(err, &hostKeyErr) {
+		t.Fatalf("expected HostKeyError for unpinned key, got %v", err)
+	}
+
+	if hostKeyErr.Fingerprint != ssh.FingerprintSHA256(otherKey) {
+		t.Fatalf("expected fingerprint %q in error, got %q", ssh.FingerprintSHA256(otherKey), hostKeyErr.Fingerprint)
+	}
+}
+
+func TestHostKeyCallbackKnownHostsFile(t *testing.T) {
+	t.Parallel()
+
+	key := newTestHostKey(t)
+	otherKey := newTestHostKey(t)
+
+	knownHostsPath := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize("hyperv:22")}, key)
+	if err := os.WriteFile(knownHostsPath, []byte(line+"\n"), 0o600); err != nil {
+		t.Fatalf("failed to write known_hosts: %v", err)
+	}
+
+	config := &ClientConfig{KnownHostsPath: knownHostsPath, TrustOnFirstUse: true}
+	callback, err := config.hostKeyCallback()
+	if err != nil {
+		t.Fatalf("failed to build callback: %v", err)
+	}
+
+	if err := callback("hyperv:22", testRemoteAddr(), key); err != nil {
+		t.Fatalf("expected known key to be accepted, got %v", err)
+	}
+
+	err = callback("hyperv:22", testRemoteAddr(), otherKey)
+	var hostKeyErr *HostKeyError
+	if !errors.As(err, &hostKeyErr) {
+		t.Fatalf("expected changed key to be rejected even with trust on first use, got %v", err)
+	}
+
+	if !strings.Contains(hostKeyErr.Reason, "does not match") {
+		t.Fatalf("expected mismatch reason, got %q", hostKeyErr.Reason)
+	}
+}
+
+func TestHostKeyCallbackInlineKnownHosts(t *testing.T) {
+	t.Parallel()
+
+	key := newTestHostKey(t)
+
+	config := &ClientConfig{KnownHosts: knownhosts.Line([]string{"hyperv"}, key)}
+	callback, err := config.hostKeyCallback()
+	if err != nil {
+		t.Fatalf("failed to build callback: %v", err)
+	}
+
+	if err := callback("hyperv:22", testRemoteAddr(), key); err != nil {
+		t.Fatalf("expected inline known key to be accepted, got %v", err)
+	}
+
+	if err := callback("other:22", testRemoteAddr(), key); err == nil {
+		t.Fatal("expected unknown host to be rejected")
+	}
+}
+
+func TestHostKeyCallbackTrustOnFirstUse(t *testing.T) {
+	t.Parallel()
+
+	key := newTestHostKey(t)
+	knownHostsPath := filepath.Join(t.TempDir(), "ssh", "known_hosts")
+
+	config := &ClientConfig{KnownHostsPath: knownHostsPath, TrustOnFirstUse: true}
+	callback, err := config.hostKeyCallback()
+	if err != nil {
+		t.Fatalf("failed to build callback: %v", err)
+	}
+
+	if err := callback("hyperv:2222", testRemoteAddr(), key); err != nil {
+		t.Fatalf("expected unknown key to be trusted on first use, got %v", err)
+	}
+
+	// A second connection must not append a duplicate entry.
+	if err := callback("hyperv:2222", testRemoteAddr(), key); err != nil {
+		t.Fatalf("expected recorded key to be accepted, got %v", err)
+	}
+
+	content, err := os.ReadFile(knownHostsPath)
+	if err != nil {
+		t.Fatalf("expected known_hosts to be created: %v", err)
+	}
+
+	if lines := strings.Count(strings.TrimSpace(string(content)), "\n") + 1; lines != 1 {
+		t.Fatalf("expected exactly one recorded entry, got %d:\n%s", lines, content)
+	}
+
+	strict := &ClientConfig{KnownHostsPath: knownHostsPath}
+	strictCallback, err := strict.hostKeyCallback()
+	if err != nil {
+		t.Fatalf("failed to build strict callback: %v", err)
+	}
+
+	if err := strictCallback("hyperv:2222", testRemoteAddr(), key); err != nil {
+		t.Fatalf("expected recorded key to be accepted without trust on first use, got %v", err)
+	}
+}
+
+func TestHostKeyCallbackPinnedFingerprintWithTrustOnFirstUse(t *testing.T) {
+	t.Parallel()
+
+	key := newTestHostKey(t)
+	otherKey := newTestHostKey(t)
+	knownHostsPath := filepath.Join(t.TempDir(), "known_hosts")
+
+	config := &ClientConfig{
+		HostKeyFingerprints: []string{ssh.FingerprintSHA256(key)},
+		KnownHostsPath:      knownHostsPath,
+		TrustOnFirstUse:     true,
+	}
+	callback, err := config.hostKeyCallback()
+	if err != nil {
+		t.Fatalf("failed to build callback: %v", err)
+	}
+
+	if err := callback("hyperv:22", testRemoteAddr(), key); err != nil {
+		t.Fatalf("expected pinned key to be accepted, got %v", err)
+	}
+
+	err = callback("hyperv:22", testRemoteAddr(), otherKey)
+	var hostKeyErr *HostKeyError
+	if !errors.As(err, &hostKeyErr) {
+		t.Fatalf("expected HostKeyError for unpinned key despite trust on first use, got %v", err)
+	}
+
+	if _, err := os.Stat(knownHostsPath); !os.IsNotExist(err) {
+		t.Fatalf("expected the unpinned key not to be recorded, stat returned %v", err)
+	}
+}
+
+func TestHostKeyCallbackUnknownHostRejected(t *testing.T) {
+	t.Parallel()
+
+	knownHostsPath := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(knownHostsPath, nil, 0o600); err != nil {
+		t.Fatalf("failed to write known_hosts: %v", err)
+	}
+
+	config := &ClientConfig{KnownHostsPath: knownHostsPath}
+	callback, err := config.hostKeyCallback()
+	if err != nil {
+		t.Fatalf("failed to build callback: %v", err)
+	}
+
+	err = callback("hyperv:22", testRemoteAddr(), newTestHostKey(t))
+	var hostKeyErr *HostKeyError
+	if !errors.As(err, &hostKeyErr) {
+		t.Fatalf("expected HostKeyError for unknown host, got %v", err)
+	}
+}
+
+func TestHostKeyCallbackMissingKnownHostsFile(t *testing.T) {
+	t.Parallel()
+
+	config := &ClientConfig{KnownHostsPath: filepath.Join(t.TempDir(), "missing")}
+	if _, err := config.hostKeyCallback(); err == nil {
+		t.Fatal("expected error for missing known_hosts file")
+	}
+}
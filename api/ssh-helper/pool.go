@@ -0,0 +1,287 @@
+package ssh_helper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	pool "github.com/jolestar/go-commons-pool/v2"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// DefaultPoolMaxTotal is the maximum number of SSH connections kept open per host.
+	DefaultPoolMaxTotal = 5
+
+	// DefaultPoolMaxIdle is the maximum number of idle SSH connections kept in the pool.
+	DefaultPoolMaxIdle = 2
+
+	// DefaultKeepAlive is the interval between keepalive requests on pooled SSH connections.
+	DefaultKeepAlive = 30 * time.Second
+
+	// DefaultPoolIdleTimeout is how long an SSH connection may stay idle before it is evicted.
+	DefaultPoolIdleTimeout = 5 * time.Minute
+
+	poolEvictionInterval = 30 * time.Second
+
+	interruptGracePeriod = 2 * time.Second
+
+	keepAliveRequest = "keepalive@openssh.com"
+)
+
+// pooledClient is an SSH connection owned by the client pool. When KeepAlive is set a
+// background goroutine keeps the connection alive and closes it once the server stops answering.
+type pooledClient struct {
+	client   *ssh.Client
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func newPooledClient(client *ssh.Client, keepAlive time.Duration) *pooledClient {
+	pc := &pooledClient{
+		client: client,
+		stop:   make(chan struct{}),
+	}
+
+	if keepAlive > 0 {
+		go pc.keepAlive(keepAlive)
+	}
+
+	return pc
+}
+
+func (pc *pooledClient) keepAlive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-pc.stop:
+			return
+		case <-ticker.C:
+			if err := pc.ping(); err != nil {
+				log.Printf("[DEBUG] SSH keepalive failed, closing connection to %s: %v", pc.client.RemoteAddr(), err)
+				_ = pc.close()
+				return
+			}
+		}
+	}
+}
+
+// ping sends a keepalive request. Servers reply with a failure for unknown global requests,
+// which still proves that the connection is alive.
+func (pc *pooledClient) ping() error {
+	_, _, err := pc.client.SendRequest(keepAliveRequest, true, nil)
+	return err
+}
+
+func (pc *pooledClient) close() error {
+	pc.stopOnce.Do(func() {
+		close(pc.stop)
+	})
+
+	err := pc.client.Close()
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+
+	return err
+}
+
+// NewClientPool creates a size-limited pool of SSH connections for the given configuration.
+// Idle connections are evicted after DefaultPoolIdleTimeout and connections are validated
+// with a keepalive request before they are handed out.
+func NewClientPool(ctx context.Context, c *ClientConfig) *pool.ObjectPool {
+	factory := pool.NewPooledObjectFactory(
+		func(context.Context) (interface{}, error) {
+			client, err := c.getSSHClient()
+			if err != nil {
+				return nil, err
+			}
+
+			log.Printf("[DEBUG] Opened pooled SSH connection to %s", client.RemoteAddr())
+			return newPooledClient(client, c.KeepAlive), nil
+		},
+		func(ctx context.Context, object *pool.PooledObject) error {
+			pc, ok := object.Object.(*pooledClient)
+			if !ok {
+				return fmt.Errorf("failed to cast pooled object to *pooledClient")
+			}
+
+			log.Printf("[DEBUG] Closing pooled SSH connection to %s", pc.client.RemoteAddr())
+			return pc.close()
+		},
+		func(ctx context.Context, object *pool.PooledObject) bool {
+			pc, ok := object.Object.(*pooledClient)
+			if !ok {
+				return false
+			}
+
+			return pc.ping() == nil
+		},
+		nil,
+		nil,
+	)
+
+	config := pool.NewDefaultPoolConfig()
+	config.BlockWhenExhausted = true
+	config.MinIdle = 0
+	config.MaxIdle = DefaultPoolMaxIdle
+	config.MaxTotal = DefaultPoolMaxTotal
+	config.TestOnBorrow = true
+	config.MinEvictableIdleTime = DefaultPoolIdleTimeout
+	config.TimeBetweenEvictionRuns = poolEvictionInterval
+	config.EvictionContext = ctx
+
+	return pool.NewObjectPool(ctx, factory, config)
+}
+
+// connectionOpenError marks a failure to open a channel on an existing connection.
+// Nothing has been executed remotely at that point, so the operation is safe to retry.
+type connectionOpenError struct {
+	err error
+}
+
+func (e *connectionOpenError) Error() string {
+	return e.err.Error()
+}
+
+func (e *connectionOpenError) Unwrap() error {
+	return e.err
+}
+
+// ScriptNotStarted tells the retries of the provider that the script can be run again, see retry.IsNotStarted.
+func (e *connectionOpenError) ScriptNotStarted() bool {
+	return true
+}
+
+// newSession opens a session on the client, marking broken connections as retryable.
+func newSession(client *ssh.Client) (*ssh.Session, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		if isBrokenConnectionError(err) {
+			return nil, &connectionOpenError{err: fmt.Errorf("failed to create session: %w", err)}
+		}
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	return session, nil
+}
+
+// isBrokenConnectionError reports whether err indicates the underlying connection is unusable.
+func isBrokenConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) || errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+
+	message := strings.ToLower(err.Error())
+	return strings.Contains(message, "broken pipe") ||
+		strings.Contains(message, "connection reset") ||
+		strings.Contains(message, "use of closed network connection")
+}
+
+// Close closes the pooled SSH connections and stops the eviction of idle connections. Operations
+// started afterwards open their own connection.
+func (c *ClientConfig) Close() error {
+	if c.ClientPool != nil {
+		c.ClientPool.Close(context.Background())
+	}
+
+	return nil
+}
+
+// withClient runs fn with an SSH connection. Connections are borrowed from ClientPool when it is
+// configured, otherwise a dedicated connection is opened and closed around fn. Broken connections
+// are discarded, and fn is retried once on a fresh connection if the failure happened before
+// anything was started remotely. When ctx is done the connection is closed, which stops remote
+// processes and transfers that are still running.
+func (c *ClientConfig) withClient(ctx context.Context, fn func(client *ssh.Client) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if c.ClientPool == nil {
+		client, err := c.getSSHClient()
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		_, err = runInterruptible(ctx, client, fn)
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		object, err := c.ClientPool.BorrowObject(ctx)
+		if err != nil {
+			return err
+		}
+
+		pc, ok := object.(*pooledClient)
+		if !ok {
+			if returnErr := c.ClientPool.ReturnObject(ctx, object); returnErr != nil {
+				return fmt.Errorf("failed to cast pooled object to *pooledClient: additionally failed returning SSH client to pool: %w", returnErr)
+			}
+			return fmt.Errorf("failed to cast pooled object to *pooledClient")
+		}
+
+		interrupted, err := runInterruptible(ctx, pc.client, fn)
+
+		if interrupted || isBrokenConnectionError(err) {
+			if invalidateErr := c.ClientPool.InvalidateObject(ctx, object); invalidateErr != nil {
+				log.Printf("[DEBUG] Failed to invalidate broken SSH connection: %v", invalidateErr)
+			}
+
+			var openErr *connectionOpenError
+			if !interrupted && attempt == 1 && errors.As(err, &openErr) {
+				log.Printf("[DEBUG] Pooled SSH connection is broken, reconnecting: %v", err)
+				continue
+			}
+
+			return err
+		}
+
+		if returnErr := c.ClientPool.ReturnObject(ctx, object); returnErr != nil && err == nil {
+			return returnErr
+		}
+
+		return err
+	}
+}
+
+// runInterruptible runs fn and closes the connection if ctx is done before fn returns. In that case
+// interrupted is true and the returned error wraps ctx.Err(). Running sessions get
+// interruptGracePeriod to signal their remote process before the connection is torn down.
+func runInterruptible(ctx context.Context, client *ssh.Client, fn func(client *ssh.Client) error) (interrupted bool, err error) {
+	done := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		select {
+		case <-done:
+		case <-time.After(interruptGracePeriod):
+		}
+		_ = client.Close()
+	})
+
+	err = fn(client)
+	close(done)
+
+	if !stop() {
+		if !errors.Is(err, ctx.Err()) {
+			err = fmt.Errorf("SSH operation interrupted: %w", ctx.Err())
+		}
+		return true, err
+	}
+
+	return false, err
+}
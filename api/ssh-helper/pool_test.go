@@ -0,0 +1,84 @@
+package ssh_helper
+
+import (
+	"context"
+	"testing"
+)
+
+func TestClientPoolReusesConnection(t *testing.T) {
+	t.Parallel()
+
+	server := newTestSSHServer(t, echoHandler)
+	config := server.clientConfig()
+	config.ClientPool = NewClientPool(context.Background(), config)
+	t.Cleanup(func() { config.ClientPool.Close(context.Background()) })
+
+	for i := 0; i < 5; i++ {
+		stdout, _, exitCode, err := config.runCommand(context.Background(), "hello")
+		if err != nil {
+			t.Fatalf("run %d failed: %v", i, err)
+		}
+
+		if exitCode != 0 || stdout != "hello" {
+			t.Fatalf("run %d: unexpected result %q (exit %d)", i, stdout, exitCode)
+		}
+	}
+
+	if got := server.connections.Load(); got != 1 {
+		t.Fatalf("expected a single pooled connection, got %d", got)
+	}
+
+	if got := server.commands.Load(); got != 5 {
+		t.Fatalf("expected 5 commands, got %d", got)
+	}
+}
+
+func TestClientPoolReconnectsAfterConnectionLoss(t *testing.T) {
+	t.Parallel()
+
+	server := newTestSSHServer(t, echoHandler)
+	config := server.clientConfig()
+	config.ClientPool = NewClientPool(context.Background(), config)
+	t.Cleanup(func() { config.ClientPool.Close(context.Background()) })
+
+	if _, _, _, err := config.runCommand(context.Background(), "first"); err != nil {
+		t.Fatalf("first run failed: %v", err)
+	}
+
+	server.dropConnections()
+
+	stdout, _, _, err := config.runCommand(context.Background(), "second")
+	if err != nil {
+		t.Fatalf("expected run to reconnect after connection loss, got %v", err)
+	}
+
+	if stdout != "second" {
+		t.Fatalf("unexpected output %q", stdout)
+	}
+
+	if got := server.connections.Load(); got != 2 {
+		t.Fatalf("expected a reconnect, got %d connections", got)
+	}
+}
+
+func TestRunCommandWithoutPoolUsesDedicatedConnections(t *testing.T) {
+	t.Parallel()
+
+	server := newTestSSHServer(t, echoHandler)
+	config := server.clientConfig()
+
+	for i := 0; i < 2; i++ {
+		_, stderr, exitCode, err := config.runCommand(context.Background(), "exit 3")
+		if err != nil {
+			t.Fatalf("run %d failed: %v", i, err)
+		}
+
+		if exitCode != 3 || stderr != "failed" {
+			t.Fatalf("run %d: unexpected result %q (exit %d)", i, stderr, exitCode)
+		}
+	}
+
+	if got := server.connections.Load(); got != 2 {
+		t.Fatalf("expected one connection per command without a pool, got %d", got)
+	}
+}
@@ -0,0 +1,106 @@
+package ssh_helper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// powerShellProcess is a long-lived PowerShell process running on its own SSH connection.
+type powerShellProcess struct {
+	client    *ssh.Client
+	session   *ssh.Session
+	stdin     io.WriteCloser
+	stdout    io.Reader
+	closeOnce sync.Once
+}
+
+// StartPowerShell starts a long-lived PowerShell process running script on a dedicated SSH
+// connection. Writes go to the standard input of the process, reads come from its standard output
+// and its standard error is copied to stderr. Closing the process kills it and closes the connection.
+func (c *ClientConfig) StartPowerShell(ctx context.Context, script string, stderr io.Writer) (io.ReadWriteCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	commandToRun := wrapPowerShellEncodedCommand(c.powerShellExecutable(), script)
+	if len(commandToRun) > c.maxCommandLength() {
+		return nil, fmt.Errorf("PowerShell process script exceeds the maximum command length of %d characters", c.maxCommandLength())
+	}
+
+	client, err := c.getSSHClient()
+	if err != nil {
+		return nil, err
+	}
+
+	process, err := startPowerShellProcess(client, commandToRun, stderr)
+	if err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+
+	log.Printf("[DEBUG] Started PowerShell process on %s", client.RemoteAddr())
+	return process, nil
+}
+
+func startPowerShellProcess(client *ssh.Client, commandToRun string, stderr io.Writer) (*powerShellProcess, error) {
+	session, err := newSession(client)
+	if err != nil {
+		return nil, err
+	}
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		_ = session.Close()
+		return nil, fmt.Errorf("failed to open stdin: %w", err)
+	}
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		_ = session.Close()
+		return nil, fmt.Errorf("failed to open stdout: %w", err)
+	}
+
+	session.Stderr = stderr
+
+	if err := session.Start(commandToRun); err != nil {
+		_ = session.Close()
+		return nil, fmt.Errorf("failed to start PowerShell process: %w", err)
+	}
+
+	return &powerShellProcess{
+		client:  client,
+		session: session,
+		stdin:   stdin,
+		stdout:  stdout,
+	}, nil
+}
+
+func (p *powerShellProcess) Read(b []byte) (int, error) {
+	return p.stdout.Read(b)
+}
+
+func (p *powerShellProcess) Write(b []byte) (int, error) {
+	return p.stdin.Write(b)
+}
+
+func (p *powerShellProcess) Close() error {
+	var err error
+	p.closeOnce.Do(func() {
+		_ = p.session.Signal(ssh.SIGKILL)
+		_ = p.session.Close()
+
+		err = p.client.Close()
+		if errors.Is(err, net.ErrClosed) {
+			err = nil
+		}
+	})
+
+	return err
+}
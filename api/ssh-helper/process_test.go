@@ -0,0 +1,74 @@
+package ssh_helper
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestStartPowerShell(t *testing.T) {
+	t.Parallel()
+
+	commands := make(chan string, 1)
+	server := newTestSSHServer(t, echoHandler)
+	server.streamHandler = func(command string, stdin io.Reader, stdout io.Writer) {
+		commands <- command
+		scanner := bufio.NewScanner(stdin)
+		for scanner.Scan() {
+			_, _ = io.WriteString(stdout, strings.ToUpper(scanner.Text())+"\n")
+		}
+	}
+
+	config := server.clientConfig()
+	config.IsWindows = true
+	config.PowerShell = PowerShellCore
+
+	process, err := config.StartPowerShell(context.Background(), "while ($true) { }", io.Discard)
+	if err != nil {
+		t.Fatalf("expected PowerShell process to start, got %v", err)
+	}
+
+	if command := <-commands; !strings.HasPrefix(command, "pwsh -NoProfile -NonInteractive -ExecutionPolicy Bypass -EncodedCommand ") {
+		t.Fatalf("expected an encoded pwsh command, got %q", command)
+	}
+
+	reader := bufio.NewReader(process)
+	for _, line := range []string{"first", "second"} {
+		if _, err := io.WriteString(process, line+"\n"); err != nil {
+			t.Fatalf("failed to write to process: %v", err)
+		}
+
+		reply, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read from process: %v", err)
+		}
+
+		if reply != strings.ToUpper(line)+"\n" {
+			t.Fatalf("unexpected reply %q", reply)
+		}
+	}
+
+	if err := process.Close(); err != nil {
+		t.Fatalf("expected process to close cleanly, got %v", err)
+	}
+
+	if _, err := reader.ReadString('\n'); err == nil {
+		t.Fatal("expected reads to fail once the process is closed")
+	}
+
+	if got := server.connections.Load(); got != 1 {
+		t.Fatalf("expected the process to use one dedicated connection, got %d", got)
+	}
+}
+
+func TestStartPowerShellRejectsOversizedScript(t *testing.T) {
+	t.Parallel()
+
+	config := &ClientConfig{IsWindows: true, MaxCommandLength: 100}
+
+	if _, err := config.StartPowerShell(context.Background(), strings.Repeat("x", 100), io.Discard); err == nil || !strings.Contains(err.Error(), "maximum command length") {
+		t.Fatalf("expected oversized script to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package ssh_helper
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// DefaultMaxCommandLength keeps command lines below the 32767 character limit of CreateProcess.
+	DefaultMaxCommandLength = 32000
+
+	// CmdMaxCommandLength is the limit to use when the OpenSSH default shell is cmd, which caps
+	// command lines at 8191 characters.
+	CmdMaxCommandLength = 8000
+
+	// DefaultRemoteScriptDir is where oversized scripts are uploaded on Windows hosts.
+	DefaultRemoteScriptDir = "C:/Temp"
+
+	// scriptFileRemoveTimeout bounds the removal of an uploaded script, which outlives the context of the script.
+	scriptFileRemoveTimeout = 30 * time.Second
+)
+
+// utf8BOM makes Windows PowerShell 5.1 read uploaded scripts as UTF-8 rather than the ANSI code page.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+func (c *ClientConfig) maxCommandLength() int {
+	if c.MaxCommandLength <= 0 {
+		return DefaultMaxCommandLength
+	}
+
+	return c.MaxCommandLength
+}
+
+func (c *ClientConfig) remoteScriptDir() string {
+	if c.RemoteScriptDir == "" {
+		return DefaultRemoteScriptDir
+	}
+
+	return c.RemoteScriptDir
+}
+
+// runScriptFile uploads a PowerShell script over SFTP to a per-run file, runs it with -File and
+// deletes the file afterwards, even when the script was cancelled. Exit code and output are
+// returned, and stdout copied to output, as for an inline command.
+func (c *ClientConfig) runScriptFile(ctx context.Context, script string, output io.Writer) (stdout, stderr string, exitCode int, err error) {
+	if err := ctx.Err(); err != nil {
+		return "", "", -1, err
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", "", -1, fmt.Errorf("failed to generate script file name: %w", err)
+	}
+	remoteScriptPath := path.Join(c.remoteScriptDir(), fmt.Sprintf("terraform-hyperv-%s.ps1", hex.EncodeToString(suffix)))
+
+	commandToRun := fmt.Sprintf("%s -NoProfile -NonInteractive -ExecutionPolicy Bypass -File \"%s\"", c.powerShellExecutable(), remoteScriptPath)
+	log.Printf("[DEBUG] Script exceeds %d characters, executing SSH command: %s", c.maxCommandLength(), commandToRun)
+
+	content := append(append([]byte{}, utf8BOM...), silenceProgress(script)...)
+
+	uploaded := false
+	err = c.withClient(ctx, func(client *ssh.Client) error {
+		if err := c.uploadViaSFTP(client, bytes.NewReader(content), remoteScriptPath); err != nil {
+			return fmt.Errorf("failed to upload script to %s: %w", remoteScriptPath, err)
+		}
+		uploaded = true
+
+		stdout, stderr, exitCode, err = runSession(ctx, client, commandToRun, output)
+		return err
+	})
+	if uploaded {
+		// A cancelled script closes its connection, the file is removed over another one
+		c.removeScriptFile(ctx, remoteScriptPath)
+	}
+	if err != nil {
+		return stdout, stderr, -1, err
+	}
+
+	return stdout, stderr, exitCode, nil
+}
+
+// removeScriptFile deletes an uploaded script over SFTP, whether or not ctx is done, logging failures as the file is
+// only temporary.
+func (c *ClientConfig) removeScriptFile(ctx context.Context, remoteScriptPath string) {
+	removeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), scriptFileRemoveTimeout)
+	defer cancel()
+
+	err := c.withClient(removeCtx, func(client *ssh.Client) error {
+		sftpClient, err := sftp.NewClient(client)
+		if err != nil {
+			return fmt.Errorf("failed to create SFTP client: %w", err)
+		}
+		defer sftpClient.Close()
+
+		return sftpClient.Remove(remoteScriptPath)
+	})
+	if err != nil {
+		log.Printf("[WARN] Failed to remove %s: %v", remoteScriptPath, err)
+	}
+}
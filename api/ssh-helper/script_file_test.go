@@ -0,0 +1,200 @@
+package ssh_helper
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+)
+
+var fileCommandPattern = regexp.MustCompile(`^powershell -NoProfile -NonInteractive -ExecutionPolicy Bypass -File "([^"]+)"$`)
+
+// scriptFileRecorder emulates PowerShell -File execution. Scripts containing "exit 7" fail with
+// that exit code, other scripts echo a JSON document.
+type scriptFileRecorder struct {
+	mu       sync.Mutex
+	paths    []string
+	contents [][]byte
+	inline   int
+}
+
+func (r *scriptFileRecorder) handle(command string) (string, string, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	match := fileCommandPattern.FindStringSubmatch(command)
+	if match == nil {
+		r.inline++
+		return "{\"inline\":true}", "", 0
+	}
+
+	content, err := os.ReadFile(match[1])
+	if err != nil {
+		return "", err.Error(), 1
+	}
+
+	r.paths = append(r.paths, match[1])
+	r.contents = append(r.contents, content)
+
+	if bytes.Contains(content, []byte("exit 7")) {
+		return "partial output", "script failed", 7
+	}
+
+	return "{\"file\":true}", "", 0
+}
+
+func newScriptFileTestConfig(t *testing.T, recorder *scriptFileRecorder) *ClientConfig {
+	t.Helper()
+
+	server := newTestSSHServer(t, recorder.handle)
+
+	config := server.clientConfig()
+	config.IsWindows = true
+	config.MaxCommandLength = 2000
+	config.RemoteScriptDir = filepath.ToSlash(t.TempDir())
+
+	return config
+}
+
+func TestRunCommandUploadsOversizedScript(t *testing.T) {
+	t.Parallel()
+
+	recorder := &scriptFileRecorder{}
+	config := newScriptFileTestConfig(t, recorder)
+
+	script := "$data = '" + strings.Repeat("x", 5000) + "'\nWrite-Output 'ünïcode'"
+	stdout, _, exitCode, err := config.runCommand(context.Background(), script)
+	if err != nil {
+		t.Fatalf("expected oversized script to run, got %v", err)
+	}
+
+	if exitCode != 0 || stdout != "{\"file\":true}" {
+		t.Fatalf("unexpected result %q (exit %d)", stdout, exitCode)
+	}
+
+	if len(recorder.paths) != 1 || recorder.inline != 0 {
+		t.Fatalf("expected a single file execution, got %d files and %d inline commands", len(recorder.paths), recorder.inline)
+	}
+
+	content := recorder.contents[0]
+	if !bytes.HasPrefix(content, utf8BOM) {
+		t.Fatal("expected uploaded script to start with a UTF-8 byte order mark")
+	}
+
+	if !strings.HasSuffix(string(content), script) || !strings.Contains(string(content), "$ProgressPreference = 'SilentlyContinue'") {
+		t.Fatalf("unexpected uploaded script content %q", content)
+	}
+
+	if _, err := os.Stat(recorder.paths[0]); !os.IsNotExist(err) {
+		t.Fatalf("expected uploaded script to be deleted, stat returned %v", err)
+	}
+}
+
+func TestRunScriptWithResultUploadsOversizedScriptOnFailure(t *testing.T) {
+	t.Parallel()
+
+	recorder := &scriptFileRecorder{}
+	config := newScriptFileTestConfig(t, recorder)
+
+	script := template.Must(template.New("large").Parse("$data = '{{.Data}}'\nexit 7"))
+
+	var result map[string]interface{}
+	err := config.RunScriptWithResult(context.Background(), script, struct{ Data string }{Data: strings.Repeat("y", 5000)}, &result)
+	if err == nil || !strings.Contains(err.Error(), "script failed") {
+		t.Fatalf("expected the script failure to be reported, got %v", err)
+	}
+
+	if len(recorder.paths) != 1 {
+		t.Fatalf("expected a single file execution, got %d", len(recorder.paths))
+	}
+
+	if _, err := os.Stat(recorder.paths[0]); !os.IsNotExist(err) {
+		t.Fatalf("expected uploaded script to be deleted after a failure, stat returned %v", err)
+	}
+}
+
+func TestRunCommandKeepsSmallScriptsInline(t *testing.T) {
+	t.Parallel()
+
+	recorder := &scriptFileRecorder{}
+	config := newScriptFileTestConfig(t, recorder)
+
+	stdout, _, _, err := config.runCommand(context.Background(), "Write-Output 'small'")
+	if err != nil {
+		t.Fatalf("expected small script to run, got %v", err)
+	}
+
+	if stdout != "{\"inline\":true}" || recorder.inline != 1 || len(recorder.paths) != 0 {
+		t.Fatalf("expected small script to run inline, got %q with %d files", stdout, len(recorder.paths))
+	}
+}
+
+func TestRunCommandUsesUniqueScriptFiles(t *testing.T) {
+	t.Parallel()
+
+	recorder := &scriptFileRecorder{}
+	config := newScriptFileTestConfig(t, recorder)
+
+	script := "$data = '" + strings.Repeat("z", 5000) + "'"
+	for i := 0; i < 2; i++ {
+		if _, _, _, err := config.runCommand(context.Background(), script); err != nil {
+			t.Fatalf("run %d failed: %v", i, err)
+		}
+	}
+
+	if len(recorder.paths) != 2 || recorder.paths[0] == recorder.paths[1] {
+		t.Fatalf("expected a distinct script file per run, got %v", recorder.paths)
+	}
+}
+
+func TestRunCommandRemovesScriptFileWhenCancelled(t *testing.T) {
+	t.Parallel()
+
+	started := make(chan string, 1)
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+
+	server := newTestSSHServer(t, func(command string) (string, string, int) {
+		if match := fileCommandPattern.FindStringSubmatch(command); match != nil {
+			started <- match[1]
+			<-release
+		}
+		return "", "", 0
+	})
+
+	config := server.clientConfig()
+	config.IsWindows = true
+	config.MaxCommandLength = 2000
+	config.RemoteScriptDir = filepath.ToSlash(t.TempDir())
+	config.ClientPool = NewClientPool(context.Background(), config)
+	t.Cleanup(func() { _ = config.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, _, err := config.runCommand(ctx, "$data = '"+strings.Repeat("c", 5000)+"'")
+		done <- err
+	}()
+
+	scriptPath := <-started
+	if _, err := os.Stat(scriptPath); err != nil {
+		t.Fatalf("expected the script to be uploaded: %v", err)
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the script to be cancelled, got %v", err)
+	}
+
+	if _, err := os.Stat(scriptPath); !os.IsNotExist(err) {
+		t.Fatalf("expected the script to be removed after it was cancelled, stat returned %v", err)
+	}
+}
@@ -0,0 +1,285 @@
+package ssh_helper
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// testSSHServer is a minimal in-process SSH server used by unit tests. It accepts password
+// authentication, answers exec requests through handler, serves SFTP from the local file system,
+// forwards direct-tcpip channels and counts accepted connections.
+type testSSHServer struct {
+	t        *testing.T
+	listener net.Listener
+	config   *ssh.ServerConfig
+	hostKey  ssh.PublicKey
+	handler  func(command string) (stdout string, stderr string, exitCode int)
+
+	// streamHandler, when set, serves exec requests instead of handler with the session's
+	// standard input and output.
+	streamHandler func(command string, stdin io.Reader, stdout io.Writer)
+
+	connections atomic.Int32
+	commands    atomic.Int32
+	forwards    atomic.Int32
+	signals     atomic.Int32
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+const (
+	testSSHUser     = "tester"
+	testSSHPassword = "secret"
+)
+
+func newTestSSHServer(t *testing.T, handler func(command string) (string, string, int)) *testSSHServer {
+	t.Helper()
+
+	return newTestSSHServerWithAuth(t, handler, func(config *ssh.ServerConfig) {
+		config.PasswordCallback = func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == testSSHUser && string(password) == testSSHPassword {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("invalid credentials")
+		}
+	})
+}
+
+// newTestSSHServerWithAuth starts a test server whose authentication callbacks are set by configureAuth.
+func newTestSSHServerWithAuth(t *testing.T, handler func(command string) (string, string, int), configureAuth func(config *ssh.ServerConfig)) *testSSHServer {
+	t.Helper()
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate host key: %v", err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatalf("failed to create host key signer: %v", err)
+	}
+
+	config := &ssh.ServerConfig{}
+	configureAuth(config)
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	server := &testSSHServer{
+		t:        t,
+		listener: listener,
+		config:   config,
+		hostKey:  signer.PublicKey(),
+		handler:  handler,
+	}
+
+	go server.serve()
+	t.Cleanup(server.close)
+
+	return server
+}
+
+func (s *testSSHServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+
+		s.connections.Add(1)
+		go s.handleConn(conn)
+	}
+}
+
+func (s *testSSHServer) handleConn(conn net.Conn) {
+	_, channels, requests, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() == "direct-tcpip" {
+			go s.handleDirectTCPIP(newChannel)
+			continue
+		}
+
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+
+		go s.handleSession(channel, channelRequests)
+	}
+}
+
+func (s *testSSHServer) handleSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+
+	for request := range requests {
+		if request.Type == "subsystem" {
+			var subsystem struct{ Name string }
+			if err := ssh.Unmarshal(request.Payload, &subsystem); err != nil || subsystem.Name != "sftp" {
+				_ = request.Reply(false, nil)
+				continue
+			}
+			_ = request.Reply(true, nil)
+
+			server, err := sftp.NewServer(channel)
+			if err != nil {
+				return
+			}
+			_ = server.Serve()
+			return
+		}
+
+		if request.Type != "exec" {
+			_ = request.Reply(false, nil)
+			continue
+		}
+
+		var payload struct{ Command string }
+		if err := ssh.Unmarshal(request.Payload, &payload); err != nil {
+			_ = request.Reply(false, nil)
+			continue
+		}
+		_ = request.Reply(true, nil)
+
+		go s.recordSignals(requests)
+
+		s.commands.Add(1)
+		if s.streamHandler != nil {
+			s.streamHandler(payload.Command, channel, channel)
+			_, _ = channel.SendRequest("exit-status", false, make([]byte, 4))
+			return
+		}
+
+		stdout, stderr, exitCode := s.handler(payload.Command)
+		_, _ = channel.Write([]byte(stdout))
+		_, _ = channel.Stderr().Write([]byte(stderr))
+
+		status := make([]byte, 4)
+		binary.BigEndian.PutUint32(status, uint32(exitCode))
+		_, _ = channel.SendRequest("exit-status", false, status)
+		return
+	}
+}
+
+// recordSignals counts the signals sent to a running command.
+func (s *testSSHServer) recordSignals(requests <-chan *ssh.Request) {
+	for request := range requests {
+		if request.Type == "signal" {
+			s.signals.Add(1)
+		}
+		if request.WantReply {
+			_ = request.Reply(false, nil)
+		}
+	}
+}
+
+// handleDirectTCPIP forwards a direct-tcpip channel, allowing the server to act as a bastion.
+func (s *testSSHServer) handleDirectTCPIP(newChannel ssh.NewChannel) {
+	var target struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+		_ = newChannel.Reject(ssh.ConnectionFailed, "invalid direct-tcpip payload")
+		return
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+	if err != nil {
+		_ = newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	s.forwards.Add(1)
+
+	go func() {
+		_, _ = io.Copy(channel, conn)
+		_ = channel.CloseWrite()
+	}()
+	_, _ = io.Copy(conn, channel)
+	_ = conn.Close()
+}
+
+// dropConnections closes every accepted connection, simulating a network failure.
+func (s *testSSHServer) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *testSSHServer) close() {
+	_ = s.listener.Close()
+	s.dropConnections()
+}
+
+// clientConfig returns a ClientConfig that connects to the server with a pinned host key.
+func (s *testSSHServer) clientConfig() *ClientConfig {
+	addr, ok := s.listener.Addr().(*net.TCPAddr)
+	if !ok {
+		s.t.Fatalf("unexpected listener address %v", s.listener.Addr())
+	}
+
+	return &ClientConfig{
+		Host:                addr.IP.String(),
+		Port:                addr.Port,
+		User:                testSSHUser,
+		Password:            testSSHPassword,
+		Timeout:             5 * time.Second,
+		HostKeyFingerprints: []string{ssh.FingerprintSHA256(s.hostKey)},
+	}
+}
+
+func echoHandler(command string) (string, string, int) {
+	if strings.HasPrefix(command, "exit ") {
+		var code int
+		_, _ = fmt.Sscanf(command, "exit %d", &code)
+		return "", "failed", code
+	}
+
+	return command, "", 0
+}
@@ -0,0 +1,129 @@
+package ssh_helper
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+const (
+	// PowerShellDesktop runs scripts with Windows PowerShell 5.1.
+	PowerShellDesktop = "powershell"
+
+	// PowerShellCore runs scripts with PowerShell 7 (pwsh).
+	PowerShellCore = "pwsh"
+)
+
+// PowerShellExecutables lists the supported PowerShell executables and the interpreter they start.
+var PowerShellExecutables = map[string]string{
+	PowerShellDesktop: "Windows PowerShell 5.1",
+	PowerShellCore:    "PowerShell 7",
+}
+
+const (
+	DefaultShellCmd        = "cmd"
+	DefaultShellPowerShell = "powershell"
+	DefaultShellPwsh       = "pwsh"
+	DefaultShellUnknown    = "unknown"
+)
+
+// defaultShellProbe prints %COMSPEC% expanded under cmd and literally under PowerShell, which
+// additionally prints its edition.
+const defaultShellProbe = "echo %COMSPEC% $PSVersionTable.PSEdition"
+
+const powerShellVersionScript = "Write-Output ('{0} {1}' -f $PSVersionTable.PSEdition, $PSVersionTable.PSVersion)"
+
+// PowerShellInfo describes the PowerShell interpreter found on the remote host.
+type PowerShellInfo struct {
+	DefaultShell string // Default shell of the OpenSSH server, see the DefaultShell constants
+	Executable   string // Executable used to run scripts
+	Edition      string // PSEdition, Desktop or Core
+	Version      string // PSVersion
+}
+
+func (i *PowerShellInfo) String() string {
+	return fmt.Sprintf("%s %s (%s edition, default shell %s)", i.Executable, i.Version, i.Edition, i.DefaultShell)
+}
+
+func (c *ClientConfig) powerShellExecutable() string {
+	if c.PowerShell == "" {
+		return PowerShellDesktop
+	}
+
+	return c.PowerShell
+}
+
+// powerShellLiteral quotes value as a PowerShell string literal, nothing in it is expanded
+func powerShellLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// DetectDefaultShell reports the default shell of the remote OpenSSH server. Scripts do not depend
+// on it as PowerShell is always invoked explicitly.
+func (c *ClientConfig) DetectDefaultShell(ctx context.Context) (string, error) {
+	stdout, _, _, err := c.runRawCommand(ctx, defaultShellProbe, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to detect default shell: %w", err)
+	}
+
+	return parseDefaultShell(stdout), nil
+}
+
+func parseDefaultShell(output string) string {
+	if !strings.Contains(output, "%COMSPEC%") {
+		if strings.Contains(strings.ToLower(output), "cmd.exe") {
+			return DefaultShellCmd
+		}
+		return DefaultShellUnknown
+	}
+
+	for _, line := range strings.Fields(output) {
+		switch line {
+		case "Desktop":
+			return DefaultShellPowerShell
+		case "Core":
+			return DefaultShellPwsh
+		}
+	}
+
+	return DefaultShellUnknown
+}
+
+// ValidatePowerShell verifies that the configured PowerShell executable can be started on the remote
+// host and reports the interpreter and version that was found. It returns nil for non-Windows hosts.
+func (c *ClientConfig) ValidatePowerShell(ctx context.Context) (*PowerShellInfo, error) {
+	if !c.IsWindows {
+		return nil, nil
+	}
+
+	defaultShell, err := c.DetectDefaultShell(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &PowerShellInfo{
+		DefaultShell: defaultShell,
+		Executable:   c.powerShellExecutable(),
+	}
+
+	stdout, stderr, exitCode, err := c.runCommand(ctx, powerShellVersionScript)
+	if err != nil {
+		return nil, fmt.Errorf("failed to validate PowerShell: %w", err)
+	}
+
+	if exitCode != 0 {
+		return nil, fmt.Errorf("PowerShell interpreter %q could not be started on the remote host (default shell %s, exit code %d): %s", info.Executable, defaultShell, exitCode, strings.TrimSpace(stderr))
+	}
+
+	fields := strings.Fields(stdout)
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("PowerShell interpreter %q returned unexpected version output (default shell %s): %q", info.Executable, defaultShell, strings.TrimSpace(stdout))
+	}
+
+	info.Edition = fields[0]
+	info.Version = fields[1]
+
+	log.Printf("[DEBUG] PowerShell validation successful: %s", info)
+	return info, nil
+}
@@ -0,0 +1,135 @@
+package ssh_helper
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// windowsShellHandler emulates a Windows OpenSSH server with the given default shell. Only the
+// executables listed in installed can be started.
+func windowsShellHandler(defaultShell string, installed map[string]string) func(command string) (string, string, int) {
+	return func(command string) (string, string, int) {
+		if command == defaultShellProbe {
+			switch defaultShell {
+			case DefaultShellCmd:
+				return "C:\\WINDOWS\\system32\\cmd.exe $PSVersionTable.PSEdition\r\n", "", 0
+			case DefaultShellPowerShell:
+				return "%COMSPEC%\r\nDesktop\r\n", "", 0
+			case DefaultShellPwsh:
+				return "%COMSPEC%\nCore\n", "", 0
+			}
+			return "%COMSPEC% .PSEdition\n", "", 0
+		}
+
+		executable := strings.Fields(command)[0]
+		output, ok := installed[executable]
+		if !ok {
+			return "", "'" + executable + "' is not recognized as an internal or external command,\r\noperable program or batch file.\r\n", 1
+		}
+
+		return output, "", 0
+	}
+}
+
+func TestParseDefaultShell(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		output   string
+		expected string
+	}{
+		{name: "cmd", output: "C:\\WINDOWS\\system32\\cmd.exe $PSVersionTable.PSEdition\r\n", expected: DefaultShellCmd},
+		{name: "windows powershell", output: "%COMSPEC%\r\nDesktop\r\n", expected: DefaultShellPowerShell},
+		{name: "pwsh", output: "%COMSPEC%\nCore\n", expected: DefaultShellPwsh},
+		{name: "posix shell", output: "%COMSPEC% .PSEdition\n", expected: DefaultShellUnknown},
+		{name: "empty", output: "", expected: DefaultShellUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := parseDefaultShell(tt.output); got != tt.expected {
+				t.Fatalf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestValidatePowerShellWithCmdDefaultShell(t *testing.T) {
+	t.Parallel()
+
+	server := newTestSSHServer(t, windowsShellHandler(DefaultShellCmd, map[string]string{
+		PowerShellDesktop: "Desktop 5.1.20348.2582\r\n",
+	}))
+
+	config := server.clientConfig()
+	config.IsWindows = true
+
+	info, err := config.ValidatePowerShell(context.Background())
+	if err != nil {
+		t.Fatalf("expected validation to succeed with cmd as default shell, got %v", err)
+	}
+
+	expected := PowerShellInfo{DefaultShell: DefaultShellCmd, Executable: PowerShellDesktop, Edition: "Desktop", Version: "5.1.20348.2582"}
+	if *info != expected {
+		t.Fatalf("expected %+v, got %+v", expected, *info)
+	}
+}
+
+func TestValidatePowerShellCore(t *testing.T) {
+	t.Parallel()
+
+	server := newTestSSHServer(t, windowsShellHandler(DefaultShellPowerShell, map[string]string{
+		PowerShellDesktop: "Desktop 5.1.20348.2582\r\n",
+		PowerShellCore:    "Core 7.4.6\r\n",
+	}))
+
+	config := server.clientConfig()
+	config.IsWindows = true
+	config.PowerShell = PowerShellCore
+
+	info, err := config.ValidatePowerShell(context.Background())
+	if err != nil {
+		t.Fatalf("expected validation to succeed, got %v", err)
+	}
+
+	if info.Executable != PowerShellCore || info.Edition != "Core" || info.Version != "7.4.6" || info.DefaultShell != DefaultShellPowerShell {
+		t.Fatalf("unexpected PowerShell info %+v", *info)
+	}
+}
+
+func TestValidatePowerShellMissingInterpreter(t *testing.T) {
+	t.Parallel()
+
+	server := newTestSSHServer(t, windowsShellHandler(DefaultShellCmd, map[string]string{
+		PowerShellDesktop: "Desktop 5.1.20348.2582\r\n",
+	}))
+
+	config := server.clientConfig()
+	config.IsWindows = true
+	config.PowerShell = PowerShellCore
+
+	_, err := config.ValidatePowerShell(context.Background())
+	if err == nil {
+		t.Fatal("expected validation to fail when pwsh is not installed")
+	}
+
+	if !strings.Contains(err.Error(), `"pwsh"`) || !strings.Contains(err.Error(), "default shell cmd") {
+		t.Fatalf("expected error to name the interpreter and default shell, got %v", err)
+	}
+}
+
+func TestPrepareCommandWindowsUsesConfiguredPowerShell(t *testing.T) {
+	t.Parallel()
+
+	config := &ClientConfig{IsWindows: true, PowerShell: PowerShellCore}
+
+	prepared := config.prepareCommand("Write-Output 'ok'")
+
+	if !strings.HasPrefix(prepared, "pwsh -NoProfile -NonInteractive -ExecutionPolicy Bypass -EncodedCommand ") {
+		t.Fatalf("expected encoded pwsh command, got %q", prepared)
+	}
+}
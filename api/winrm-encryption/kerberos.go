@@ -0,0 +1,332 @@
+package winrm_encryption
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/crypto/etype"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/chksumtype"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+// KerberosConfig holds the Kerberos settings of the provider, which are the ones of winrm.ClientKerberos.
+type KerberosConfig struct {
+	Username  string
+	Password  string
+	Realm     string
+	SPN       string
+	KrbConf   string
+	KrbCCache string
+}
+
+// Token ids of the KRB5 mechanism tokens of RFC 4121 4.1 and 4.2.6.2
+var (
+	kerberosAPReqTokenID = []byte{0x01, 0x00}
+	kerberosAPRepTokenID = []byte{0x02, 0x00}
+	kerberosWrapTokenID  = []byte{0x05, 0x04}
+
+	kerberosOID = asn1.ObjectIdentifier(gssapi.OIDKRB5.OID())
+)
+
+// Flags of the wrap token of RFC 4121 4.2.2
+const (
+	kerberosSentByAcceptor byte = 0x01
+	kerberosSealed         byte = 0x02
+	kerberosAcceptorSubkey byte = 0x04
+
+	kerberosWrapHeaderLength = 16
+)
+
+// kerberos authenticates with a service ticket for the SPN of the host and seals messages with wrap tokens of
+// RFC 4121.
+type kerberos struct {
+	config *KerberosConfig
+}
+
+func (k *kerberos) authenticate(host string, exchange exchangeFunc) (sealer, error) {
+	kerberosClient, err := k.client()
+	if err != nil {
+		return nil, err
+	}
+	if err := kerberosClient.AffirmLogin(); err != nil {
+		return nil, fmt.Errorf("could not acquire client credential: %w", err)
+	}
+
+	spn := k.config.SPN
+	if spn == "" {
+		spn = hostSPN(host)
+	}
+	ticket, sessionKey, err := kerberosClient.GetServiceTicket(spn)
+	if err != nil {
+		return nil, fmt.Errorf("could not get a service ticket for %s: %w", spn, err)
+	}
+	if !kerberosWrapSupported(sessionKey.KeyType) {
+		return nil, fmt.Errorf("message encryption requires an AES session key, the ticket for %s has encryption type %d", spn, sessionKey.KeyType)
+	}
+
+	authenticator, err := types.NewAuthenticator(kerberosClient.Credentials.Domain(), kerberosClient.Credentials.CName())
+	if err != nil {
+		return nil, fmt.Errorf("error generating authenticator: %w", err)
+	}
+	sessionEtype, err := crypto.GetEtype(sessionKey.KeyType)
+	if err != nil {
+		return nil, err
+	}
+	if err := authenticator.GenerateSeqNumberAndSubKey(sessionKey.KeyType, sessionEtype.GetKeyByteSize()); err != nil {
+		return nil, err
+	}
+	authenticator.Cksum = types.Checksum{
+		CksumType: chksumtype.GSSAPI,
+		Checksum:  kerberosChecksum(gssapi.ContextFlagMutual | gssapi.ContextFlagReplay | gssapi.ContextFlagSequence | gssapi.ContextFlagConf | gssapi.ContextFlagInteg),
+	}
+
+	apReq, err := messages.NewAPReq(ticket, sessionKey, authenticator)
+	if err != nil {
+		return nil, err
+	}
+	types.SetFlag(&apReq.APOptions, flags.APOptionMutualRequired)
+	apReqBytes, err := apReq.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := kerberosMechToken(kerberosAPReqTokenID, apReqBytes)
+	if err != nil {
+		return nil, err
+	}
+	response, complete, err := exchange(token)
+	if err != nil {
+		return nil, err
+	}
+	if !complete || response == nil {
+		return nil, errors.New("server did not return a Kerberos AP-REP")
+	}
+
+	return kerberosAccept(response, sessionKey, authenticator)
+}
+
+// client returns a Kerberos client from the credential cache, or for the user and password.
+func (k *kerberos) client() (*client.Client, error) {
+	cfg, err := config.Load(k.config.KrbConf)
+	if err != nil {
+		return nil, err
+	}
+
+	if k.config.KrbCCache == "" {
+		return client.NewWithPassword(k.config.Username, k.config.Realm, k.config.Password, cfg,
+			client.DisablePAFXFAST(true), client.AssumePreAuthentication(true)), nil
+	}
+
+	b, err := os.ReadFile(k.config.KrbCCache)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read ccache file %s: %w", k.config.KrbCCache, err)
+	}
+	cc := new(credentials.CCache)
+	if err := cc.Unmarshal(b); err != nil {
+		return nil, fmt.Errorf("unable to parse ccache file %s: %w", k.config.KrbCCache, err)
+	}
+	kerberosClient, err := client.NewFromCCache(cc, cfg, client.DisablePAFXFAST(true))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create kerberos client from ccache: %w", err)
+	}
+
+	return kerberosClient, nil
+}
+
+// hostSPN returns the HTTP SPN of host, using its canonical name like the SPNEGO client of gokrb5.
+func hostSPN(host string) string {
+	host = strings.TrimSuffix(strings.Trim(host, "[]"), ".")
+	if name, err := net.LookupCNAME(host); err == nil && name != "" {
+		host = strings.TrimSuffix(strings.ToLower(name), ".")
+	}
+
+	return "HTTP/" + host
+}
+
+func kerberosWrapSupported(keyType int32) bool {
+	return keyType == etypeID.AES128_CTS_HMAC_SHA1_96 || keyType == etypeID.AES256_CTS_HMAC_SHA1_96
+}
+
+// kerberosChecksum returns the authenticator checksum of RFC 4121 4.1.1 without channel bindings.
+func kerberosChecksum(contextFlags uint32) []byte {
+	checksum := binary.LittleEndian.AppendUint32(nil, 16)
+	checksum = append(checksum, make([]byte, 16)...)
+
+	return binary.LittleEndian.AppendUint32(checksum, contextFlags)
+}
+
+// kerberosMechToken frames a Kerberos message as the initial context token of RFC 2743 3.1.
+func kerberosMechToken(tokenID []byte, message []byte) ([]byte, error) {
+	oid, err := asn1.Marshal(kerberosOID)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(asn1.RawValue{
+		Class:      asn1.ClassApplication,
+		Tag:        0,
+		IsCompound: true,
+		Bytes:      bytes.Join([][]byte{oid, tokenID, message}, nil),
+	})
+}
+
+// kerberosAccept verifies the AP-REP of the server and returns the sealer of the security context, which uses the
+// subkey of the server when it sent one.
+func kerberosAccept(token []byte, sessionKey types.EncryptionKey, authenticator types.Authenticator) (sealer, error) {
+	var framed asn1.RawValue
+	if _, err := asn1.Unmarshal(token, &framed); err != nil || framed.Class != asn1.ClassApplication || framed.Tag != 0 {
+		return nil, errors.New("invalid Kerberos token")
+	}
+	var oid asn1.ObjectIdentifier
+	rest, err := asn1.Unmarshal(framed.Bytes, &oid)
+	if err != nil || !oid.Equal(kerberosOID) {
+		return nil, errors.New("Kerberos token is not a KRB5 token")
+	}
+	if !bytes.HasPrefix(rest, kerberosAPRepTokenID) {
+		return nil, fmt.Errorf("Kerberos token has id %x instead of an AP-REP", rest[:min(len(rest), 2)])
+	}
+
+	var apRep messages.APRep
+	if err := apRep.Unmarshal(rest[len(kerberosAPRepTokenID):]); err != nil {
+		return nil, fmt.Errorf("error unmarshalling AP-REP: %w", err)
+	}
+	decrypted, err := crypto.DecryptEncPart(apRep.EncPart, sessionKey, keyusage.AP_REP_ENCPART)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting AP-REP: %w", err)
+	}
+	var part messages.EncAPRepPart
+	if err := part.Unmarshal(decrypted); err != nil {
+		return nil, fmt.Errorf("error unmarshalling AP-REP: %w", err)
+	}
+	if part.CTime.Unix() != authenticator.CTime.Unix() || part.Cusec != authenticator.Cusec {
+		return nil, errors.New("AP-REP does not answer the AP-REQ")
+	}
+
+	key := authenticator.SubKey
+	var wrapFlags byte
+	if len(part.Subkey.KeyValue) > 0 {
+		key = part.Subkey
+		wrapFlags = kerberosAcceptorSubkey
+	}
+
+	return newKerberosSealer(key, wrapFlags, uint64(authenticator.SeqNumber))
+}
+
+// kerberosSealer seals the messages of the initiator and unseals the messages of the acceptor.
+type kerberosSealer struct {
+	key     types.EncryptionKey
+	etype   etype.EType
+	flags   byte
+	sendSeq uint64
+}
+
+func newKerberosSealer(key types.EncryptionKey, flags byte, sendSeq uint64) (*kerberosSealer, error) {
+	if !kerberosWrapSupported(key.KeyType) {
+		return nil, fmt.Errorf("message encryption requires an AES key, got encryption type %d", key.KeyType)
+	}
+	keyEtype, err := crypto.GetEtype(key.KeyType)
+	if err != nil {
+		return nil, err
+	}
+
+	return &kerberosSealer{
+		key:     key,
+		etype:   keyEtype,
+		flags:   flags,
+		sendSeq: sendSeq,
+	}, nil
+}
+
+func (s *kerberosSealer) wrap(message []byte) ([]byte, []byte, error) {
+	token, err := kerberosWrap(s.key, s.etype, keyusage.GSSAPI_INITIATOR_SEAL, kerberosSealed|s.flags, s.sendSeq, message)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.sendSeq++
+
+	// The header, the rotated trailer and the confounder are the signature, the rest is the encrypted message
+	signatureLength := len(token) - len(message)
+
+	return token[:signatureLength], token[signatureLength:], nil
+}
+
+func (s *kerberosSealer) unwrap(signature []byte, sealed []byte) ([]byte, error) {
+	token := append(append([]byte{}, signature...), sealed...)
+
+	return kerberosUnwrap(s.key, s.etype, keyusage.GSSAPI_ACCEPTOR_SEAL, kerberosSentByAcceptor|kerberosSealed|s.flags, token)
+}
+
+// kerberosWrap returns the sealed wrap token of RFC 4121 4.2.4 for message, without filler and with the encrypted
+// header copy and the checksum rotated in front of the encrypted data as Windows expects.
+func kerberosWrap(key types.EncryptionKey, keyEtype etype.EType, usage uint32, wrapFlags byte, seq uint64, message []byte) ([]byte, error) {
+	header := make([]byte, kerberosWrapHeaderLength)
+	copy(header, kerberosWrapTokenID)
+	header[2] = wrapFlags
+	header[3] = 0xff
+	binary.BigEndian.PutUint64(header[8:], seq)
+
+	_, encrypted, err := keyEtype.EncryptMessage(key.KeyValue, append(append([]byte{}, message...), header...), usage)
+	if err != nil {
+		return nil, err
+	}
+
+	rotation := kerberosWrapHeaderLength + keyEtype.GetHMACBitLength()/8
+	binary.BigEndian.PutUint16(header[6:], uint16(rotation))
+
+	return append(header, rotate(encrypted, rotation)...), nil
+}
+
+// kerberosUnwrap returns the message of a sealed wrap token, after checking its flags and its header.
+func kerberosUnwrap(key types.EncryptionKey, keyEtype etype.EType, usage uint32, wrapFlags byte, token []byte) ([]byte, error) {
+	if len(token) < kerberosWrapHeaderLength || !bytes.HasPrefix(token, kerberosWrapTokenID) || token[3] != 0xff {
+		return nil, errors.New("invalid Kerberos wrap token")
+	}
+	if token[2] != wrapFlags {
+		return nil, fmt.Errorf("Kerberos wrap token has flags 0x%02x instead of 0x%02x", token[2], wrapFlags)
+	}
+	filler := int(binary.BigEndian.Uint16(token[4:]))
+	rotation := int(binary.BigEndian.Uint16(token[6:]))
+
+	encrypted := token[kerberosWrapHeaderLength:]
+	if len(encrypted) == 0 {
+		return nil, errors.New("Kerberos wrap token is empty")
+	}
+	decrypted, err := keyEtype.DecryptMessage(key.KeyValue, rotate(encrypted, len(encrypted)-rotation%len(encrypted)), usage)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting Kerberos wrap token: %w", err)
+	}
+	if len(decrypted) < filler+kerberosWrapHeaderLength {
+		return nil, errors.New("Kerberos wrap token is truncated")
+	}
+
+	// The encrypted copy of the header has no rotation
+	header := decrypted[len(decrypted)-kerberosWrapHeaderLength:]
+	if !hmac.Equal(header[:6], token[:6]) || !hmac.Equal(header[8:], token[8:kerberosWrapHeaderLength]) {
+		return nil, errors.New("Kerberos wrap token header does not match its encrypted copy")
+	}
+
+	return decrypted[:len(decrypted)-filler-kerberosWrapHeaderLength], nil
+}
+
+// rotate returns data rotated right by n bytes.
+func rotate(data []byte, n int) []byte {
+	n %= len(data)
+
+	return append(append([]byte{}, data[len(data)-n:]...), data[:len(data)-n]...)
+}
@@ -0,0 +1,221 @@
+package winrm_encryption
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/asn1tools"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/iana/asnAppTag"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/msgtype"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+func testKerberosKey(keyType int32, size int, fill byte) types.EncryptionKey {
+	return types.EncryptionKey{KeyType: keyType, KeyValue: bytes.Repeat([]byte{fill}, size)}
+}
+
+func TestKerberosSealerRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	for _, key := range []types.EncryptionKey{
+		testKerberosKey(etypeID.AES128_CTS_HMAC_SHA1_96, 16, 0x11),
+		testKerberosKey(etypeID.AES256_CTS_HMAC_SHA1_96, 32, 0x22),
+	} {
+		client, err := newKerberosSealer(key, kerberosAcceptorSubkey, 41)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i, message := range []string{"<s:Envelope/>", "a message longer than a single AES block of sixteen bytes", "x"} {
+			signature, sealed, err := client.wrap([]byte(message))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The signature holds the header, the encrypted header copy, the checksum and the confounder
+			if len(signature) != 60 || len(sealed) != len(message) {
+				t.Fatalf("expected a signature of 60 bytes and %d sealed bytes, got %d and %d", len(message), len(signature), len(sealed))
+			}
+			if got := binary.BigEndian.Uint64(signature[8:16]); got != uint64(41+i) {
+				t.Fatalf("expected sequence number %d, got %d", 41+i, got)
+			}
+			if signature[2] != kerberosSealed|kerberosAcceptorSubkey || binary.BigEndian.Uint16(signature[6:8]) != 28 {
+				t.Fatalf("expected flags 0x06 and a rotation of 28, got header %x", signature[:16])
+			}
+
+			token := append(append([]byte{}, signature...), sealed...)
+			got, err := kerberosUnwrap(key, client.etype, keyusage.GSSAPI_INITIATOR_SEAL, kerberosSealed|kerberosAcceptorSubkey, token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != message {
+				t.Fatalf("expected request %q, got %q", message, got)
+			}
+
+			response, err := kerberosWrap(key, client.etype, keyusage.GSSAPI_ACCEPTOR_SEAL, kerberosSentByAcceptor|kerberosSealed|kerberosAcceptorSubkey, uint64(i), []byte("response to "+message))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err = client.unwrap(response[:60], response[60:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != "response to "+message {
+				t.Fatalf("expected response to %q, got %q", message, got)
+			}
+		}
+
+		// Tokens sealed by the initiator are not accepted as responses
+		signature, sealed, err := client.wrap([]byte("reflected"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.unwrap(signature, sealed); err == nil {
+			t.Fatal("expected an error for a token sent by the initiator")
+		}
+	}
+}
+
+func TestKerberosSealerRejectsRC4(t *testing.T) {
+	t.Parallel()
+
+	if _, err := newKerberosSealer(testKerberosKey(etypeID.RC4_HMAC, 16, 0x33), 0, 0); err == nil {
+		t.Fatal("expected an error for an RC4 key")
+	}
+}
+
+func TestKerberosMechToken(t *testing.T) {
+	t.Parallel()
+
+	sessionKey := testKerberosKey(etypeID.AES256_CTS_HMAC_SHA1_96, 32, 0x44)
+	authenticator, err := types.NewAuthenticator("EXAMPLE.COM", types.NewPrincipalName(1, "user"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apReq, err := messages.NewAPReq(messages.Ticket{
+		TktVNO: 5,
+		Realm:  "EXAMPLE.COM",
+		SName:  types.NewPrincipalName(2, "HTTP/hv01.example.com"),
+		EncPart: types.EncryptedData{
+			EType:  etypeID.AES256_CTS_HMAC_SHA1_96,
+			Cipher: []byte("ticket"),
+		},
+	}, sessionKey, authenticator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apReqBytes, err := apReq.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := kerberosMechToken(kerberosAPReqTokenID, apReqBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var parsed spnego.KRB5Token
+	if err := parsed.Unmarshal(token); err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.IsAPReq() || parsed.APReq.Ticket.Realm != "EXAMPLE.COM" {
+		t.Fatalf("expected an AP-REQ for EXAMPLE.COM, got %+v", parsed)
+	}
+}
+
+func TestKerberosAccept(t *testing.T) {
+	t.Parallel()
+
+	sessionKey := testKerberosKey(etypeID.AES256_CTS_HMAC_SHA1_96, 32, 0x55)
+	acceptorSubkey := testKerberosKey(etypeID.AES256_CTS_HMAC_SHA1_96, 32, 0x66)
+
+	authenticator, err := types.NewAuthenticator("EXAMPLE.COM", types.NewPrincipalName(1, "user"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := authenticator.GenerateSeqNumberAndSubKey(sessionKey.KeyType, 32); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		ctime    time.Time
+		subkey   types.EncryptionKey
+		wantKey  types.EncryptionKey
+		wantFlag byte
+		wantErr  bool
+	}{
+		{name: "acceptor subkey", ctime: authenticator.CTime, subkey: acceptorSubkey, wantKey: acceptorSubkey, wantFlag: kerberosAcceptorSubkey},
+		{name: "initiator subkey", ctime: authenticator.CTime, wantKey: authenticator.SubKey},
+		{name: "other authenticator", ctime: authenticator.CTime.Add(-time.Minute), subkey: acceptorSubkey, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			token := testAPRep(t, sessionKey, messages.EncAPRepPart{
+				CTime:          tt.ctime.Truncate(time.Second),
+				Cusec:          authenticator.Cusec,
+				Subkey:         tt.subkey,
+				SequenceNumber: 7,
+			})
+
+			got, err := kerberosAccept(token, sessionKey, authenticator)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %t, got %v", tt.wantErr, err)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			sealer, ok := got.(*kerberosSealer)
+			if !ok {
+				t.Fatalf("expected a Kerberos sealer, got %T", got)
+			}
+			if !bytes.Equal(sealer.key.KeyValue, tt.wantKey.KeyValue) || sealer.flags != tt.wantFlag {
+				t.Fatalf("expected key %x with flags 0x%02x, got %x with 0x%02x", tt.wantKey.KeyValue, tt.wantFlag, sealer.key.KeyValue, sealer.flags)
+			}
+			if sealer.sendSeq != uint64(authenticator.SeqNumber) {
+				t.Fatalf("expected sequence number %d, got %d", authenticator.SeqNumber, sealer.sendSeq)
+			}
+		})
+	}
+}
+
+// testAPRep returns the KRB5 token of an AP-REP with part encrypted with sessionKey.
+func testAPRep(t *testing.T, sessionKey types.EncryptionKey, part messages.EncAPRepPart) []byte {
+	t.Helper()
+
+	partBytes, err := asn1.Marshal(part)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encPart, err := crypto.GetEncryptedData(asn1tools.AddASNAppTag(partBytes, asnAppTag.EncAPRepPart), sessionKey, keyusage.AP_REP_ENCPART, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apRep, err := asn1.Marshal(messages.APRep{
+		PVNO:    5,
+		MsgType: msgtype.KRB_AP_REP,
+		EncPart: encPart,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := kerberosMechToken(kerberosAPRepTokenID, asn1tools.AddASNAppTag(apRep, asnAppTag.APREP))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
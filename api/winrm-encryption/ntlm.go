@@ -0,0 +1,396 @@
+package winrm_encryption
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rc4"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/md4" //nolint:staticcheck // the NT hash is defined with MD4
+)
+
+// Negotiate flags of MS-NLMP 2.2.2.5
+const (
+	ntlmNegotiateUnicode                 uint32 = 0x00000001
+	ntlmRequestTarget                    uint32 = 0x00000004
+	ntlmNegotiateSign                    uint32 = 0x00000010
+	ntlmNegotiateSeal                    uint32 = 0x00000020
+	ntlmNegotiateNTLM                    uint32 = 0x00000200
+	ntlmNegotiateAlwaysSign              uint32 = 0x00008000
+	ntlmNegotiateExtendedSessionSecurity uint32 = 0x00080000
+	ntlmNegotiateTargetInfo              uint32 = 0x00800000
+	ntlmNegotiateVersion                 uint32 = 0x02000000
+	ntlmNegotiate128                     uint32 = 0x20000000
+	ntlmNegotiateKeyExchange             uint32 = 0x40000000
+	ntlmNegotiate56                      uint32 = 0x80000000
+
+	ntlmFlags = ntlmNegotiateUnicode | ntlmRequestTarget | ntlmNegotiateSign | ntlmNegotiateSeal | ntlmNegotiateNTLM |
+		ntlmNegotiateAlwaysSign | ntlmNegotiateExtendedSessionSecurity | ntlmNegotiateTargetInfo |
+		ntlmNegotiateVersion | ntlmNegotiate128 | ntlmNegotiateKeyExchange | ntlmNegotiate56
+
+	// ntlmRequiredFlags have to be accepted by the server for messages to be sealed with 128 bit keys
+	ntlmRequiredFlags = ntlmNegotiateSign | ntlmNegotiateSeal | ntlmNegotiateExtendedSessionSecurity | ntlmNegotiate128
+)
+
+// AV pair ids of MS-NLMP 2.2.2.1
+const (
+	ntlmAvEOL       uint16 = 0x0000
+	ntlmAvFlags     uint16 = 0x0006
+	ntlmAvTimestamp uint16 = 0x0007
+
+	// ntlmAvFlagMIC tells the server that the authenticate message carries a MIC
+	ntlmAvFlagMIC uint32 = 0x00000002
+)
+
+const (
+	ntlmNegotiateMessage    uint32 = 1
+	ntlmChallengeMessage    uint32 = 2
+	ntlmAuthenticateMessage uint32 = 3
+
+	// ntlmAuthenticateHeaderLength is the length of the fixed fields of the authenticate message, including the
+	// version and the MIC
+	ntlmAuthenticateHeaderLength = 88
+	ntlmMICOffset                = 72
+)
+
+var (
+	ntlmSignature = []byte("NTLMSSP\x00")
+
+	// ntlmVersion is the version of the client sent in messages, which the server only uses for debugging
+	ntlmVersion = []byte{10, 0, 0x61, 0x4a, 0, 0, 0, 15}
+)
+
+// ntlm authenticates with NTLMv2 and seals messages with the session key, see MS-NLMP.
+type ntlm struct {
+	user     string
+	domain   string
+	password string
+
+	// random and now are replaced by tests to get reproducible messages
+	random io.Reader
+	now    func() time.Time
+}
+
+// newNTLM returns the NTLM mechanism for user, which is split into a domain and a user when it has the form
+// DOMAIN\user.
+func newNTLM(user string, password string) *ntlm {
+	domain := ""
+	if i := strings.Index(user, `\`); i >= 0 {
+		domain, user = user[:i], user[i+1:]
+	}
+
+	return &ntlm{
+		user:     user,
+		domain:   domain,
+		password: password,
+		random:   rand.Reader,
+		now:      time.Now,
+	}
+}
+
+func (n *ntlm) authenticate(_ string, exchange exchangeFunc) (sealer, error) {
+	negotiate := n.negotiateMessage()
+	challenge, _, err := exchange(negotiate)
+	if err != nil {
+		return nil, err
+	}
+	if challenge == nil {
+		return nil, errors.New("server did not return an NTLM challenge")
+	}
+
+	authenticate, sessionKey, flags, err := n.authenticateMessage(negotiate, challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	_, complete, err := exchange(authenticate)
+	if err != nil {
+		return nil, err
+	}
+	if !complete {
+		return nil, errors.New("server did not accept the NTLM authentication")
+	}
+
+	return newNTLMSealer(sessionKey, flags&ntlmNegotiateKeyExchange != 0), nil
+}
+
+// negotiateMessage returns the NEGOTIATE_MESSAGE of MS-NLMP 2.2.1.1, without domain and workstation.
+func (n *ntlm) negotiateMessage() []byte {
+	message := make([]byte, 40)
+	copy(message, ntlmSignature)
+	binary.LittleEndian.PutUint32(message[8:], ntlmNegotiateMessage)
+	binary.LittleEndian.PutUint32(message[12:], ntlmFlags)
+	copy(message[32:], ntlmVersion)
+
+	return message
+}
+
+// authenticateMessage returns the AUTHENTICATE_MESSAGE of MS-NLMP 2.2.1.3 answering challenge, with the exported
+// session key and the negotiated flags.
+func (n *ntlm) authenticateMessage(negotiate []byte, challenge []byte) ([]byte, []byte, uint32, error) {
+	if len(challenge) < 48 || !bytes.Equal(challenge[:8], ntlmSignature) || binary.LittleEndian.Uint32(challenge[8:]) != ntlmChallengeMessage {
+		return nil, nil, 0, errors.New("invalid NTLM challenge message")
+	}
+
+	flags := binary.LittleEndian.Uint32(challenge[20:]) & ntlmFlags
+	if flags&ntlmRequiredFlags != ntlmRequiredFlags {
+		return nil, nil, 0, fmt.Errorf("server does not support NTLM message sealing with 128 bit keys, negotiated flags 0x%08x", flags)
+	}
+	serverChallenge := challenge[24:32]
+	targetInfo, err := ntlmField(challenge, 40)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("invalid NTLM challenge message: %w", err)
+	}
+
+	clientChallenge := make([]byte, 8)
+	if _, err := io.ReadFull(n.random, clientChallenge); err != nil {
+		return nil, nil, 0, err
+	}
+
+	// The timestamp of the server is used when it sends one, in which case the authenticate message carries a MIC
+	// and no LMv2 response
+	timestamp, hasTimestamp := ntlmAvPair(targetInfo, ntlmAvTimestamp)
+	if !hasTimestamp {
+		timestamp = ntlmFiletime(n.now())
+	} else {
+		targetInfo = ntlmWithAvFlags(targetInfo, ntlmAvFlagMIC)
+	}
+
+	responseKey := ntlmResponseKey(n.user, n.domain, n.password)
+
+	temp := make([]byte, 0, 28+len(targetInfo)+4)
+	temp = append(temp, 1, 1, 0, 0, 0, 0, 0, 0)
+	temp = append(temp, timestamp...)
+	temp = append(temp, clientChallenge...)
+	temp = append(temp, 0, 0, 0, 0)
+	temp = append(temp, targetInfo...)
+	temp = append(temp, 0, 0, 0, 0)
+
+	ntProof := hmacMD5(responseKey, serverChallenge, temp)
+	ntResponse := append(append([]byte{}, ntProof...), temp...)
+
+	lmResponse := make([]byte, 24)
+	if !hasTimestamp {
+		lmResponse = append(hmacMD5(responseKey, serverChallenge, clientChallenge), clientChallenge...)
+	}
+
+	keyExchangeKey := hmacMD5(responseKey, ntProof)
+	sessionKey := keyExchangeKey
+	var encryptedSessionKey []byte
+	if flags&ntlmNegotiateKeyExchange != 0 {
+		sessionKey = make([]byte, 16)
+		if _, err := io.ReadFull(n.random, sessionKey); err != nil {
+			return nil, nil, 0, err
+		}
+		encryptedSessionKey = rc4Crypt(keyExchangeKey, sessionKey)
+	}
+
+	message := make([]byte, ntlmAuthenticateHeaderLength)
+	copy(message, ntlmSignature)
+	binary.LittleEndian.PutUint32(message[8:], ntlmAuthenticateMessage)
+	binary.LittleEndian.PutUint32(message[60:], flags)
+	copy(message[64:], ntlmVersion)
+
+	for _, field := range []struct {
+		offset int
+		value  []byte
+	}{
+		{12, lmResponse},
+		{20, ntResponse},
+		{28, utf16le(n.domain)},
+		{36, utf16le(n.user)},
+		{44, nil},
+		{52, encryptedSessionKey},
+	} {
+		binary.LittleEndian.PutUint16(message[field.offset:], uint16(len(field.value)))
+		binary.LittleEndian.PutUint16(message[field.offset+2:], uint16(len(field.value)))
+		binary.LittleEndian.PutUint32(message[field.offset+4:], uint32(len(message)))
+		message = append(message, field.value...)
+	}
+
+	if hasTimestamp {
+		copy(message[ntlmMICOffset:], hmacMD5(sessionKey, negotiate, challenge, message))
+	}
+
+	return message, sessionKey, flags, nil
+}
+
+// ntlmField returns the payload of the field whose length and offset start at offset of message.
+func ntlmField(message []byte, offset int) ([]byte, error) {
+	length := int(binary.LittleEndian.Uint16(message[offset:]))
+	start := int(binary.LittleEndian.Uint32(message[offset+4:]))
+	if start+length > len(message) {
+		return nil, fmt.Errorf("field at %d is out of bounds", offset)
+	}
+
+	return message[start : start+length], nil
+}
+
+// ntlmAvPair returns the value of the AV pair with id in targetInfo.
+func ntlmAvPair(targetInfo []byte, id uint16) ([]byte, bool) {
+	for len(targetInfo) >= 4 {
+		pairID := binary.LittleEndian.Uint16(targetInfo)
+		length := int(binary.LittleEndian.Uint16(targetInfo[2:]))
+		if pairID == ntlmAvEOL || len(targetInfo) < 4+length {
+			break
+		}
+		if pairID == id {
+			return targetInfo[4 : 4+length], true
+		}
+		targetInfo = targetInfo[4+length:]
+	}
+
+	return nil, false
+}
+
+// ntlmWithAvFlags returns targetInfo with flags added to its MsvAvFlags pair.
+func ntlmWithAvFlags(targetInfo []byte, flags uint32) []byte {
+	result := make([]byte, 0, len(targetInfo)+8)
+	for len(targetInfo) >= 4 {
+		pairID := binary.LittleEndian.Uint16(targetInfo)
+		length := int(binary.LittleEndian.Uint16(targetInfo[2:]))
+		if pairID == ntlmAvEOL || len(targetInfo) < 4+length {
+			break
+		}
+		if pairID == ntlmAvFlags && length == 4 {
+			flags |= binary.LittleEndian.Uint32(targetInfo[4:])
+		} else {
+			result = append(result, targetInfo[:4+length]...)
+		}
+		targetInfo = targetInfo[4+length:]
+	}
+
+	result = binary.LittleEndian.AppendUint16(result, ntlmAvFlags)
+	result = binary.LittleEndian.AppendUint16(result, 4)
+	result = binary.LittleEndian.AppendUint32(result, flags)
+
+	return append(result, 0, 0, 0, 0)
+}
+
+// ntlmResponseKey returns NTOWFv2 of MS-NLMP 3.3.2.
+func ntlmResponseKey(user string, domain string, password string) []byte {
+	hash := md4.New()
+	hash.Write(utf16le(password))
+
+	return hmacMD5(hash.Sum(nil), utf16le(strings.ToUpper(user)+domain))
+}
+
+// ntlmFiletime returns t as a little endian FILETIME, in 100 nanoseconds since 1601.
+func ntlmFiletime(t time.Time) []byte {
+	const epochDifference = 11644473600
+
+	ticks := uint64(t.Unix()+epochDifference)*10000000 + uint64(t.Nanosecond()/100)
+
+	return binary.LittleEndian.AppendUint64(nil, ticks)
+}
+
+func utf16le(text string) []byte {
+	var result []byte
+	for _, unit := range utf16.Encode([]rune(text)) {
+		result = binary.LittleEndian.AppendUint16(result, unit)
+	}
+
+	return result
+}
+
+func hmacMD5(key []byte, data ...[]byte) []byte {
+	mac := hmac.New(md5.New, key)
+	for _, d := range data {
+		mac.Write(d)
+	}
+
+	return mac.Sum(nil)
+}
+
+func rc4Crypt(key []byte, data []byte) []byte {
+	cipher, _ := rc4.NewCipher(key) // only fails for keys that are empty or longer than 256 bytes
+	result := make([]byte, len(data))
+	cipher.XORKeyStream(result, data)
+
+	return result
+}
+
+// ntlmSealer seals messages with extended session security, see MS-NLMP 3.4.3. The RC4 states and the sequence
+// numbers carry over from one message to the next, so messages have to be sealed and unsealed in the order they are
+// sent and received.
+type ntlmSealer struct {
+	signingKey   []byte
+	verifyingKey []byte
+	sealing      *rc4.Cipher
+	unsealing    *rc4.Cipher
+	sendSeq      uint32
+	receiveSeq   uint32
+	keyExchange  bool
+}
+
+const ntlmSignatureLength = 16
+
+func newNTLMSealer(sessionKey []byte, keyExchange bool) *ntlmSealer {
+	key := func(magic string) []byte {
+		hash := md5.New()
+		hash.Write(sessionKey)
+		hash.Write([]byte(magic + "\x00"))
+
+		return hash.Sum(nil)
+	}
+
+	sealing, _ := rc4.NewCipher(key("session key to client-to-server sealing key magic constant"))
+	unsealing, _ := rc4.NewCipher(key("session key to server-to-client sealing key magic constant"))
+
+	return &ntlmSealer{
+		signingKey:   key("session key to client-to-server signing key magic constant"),
+		verifyingKey: key("session key to server-to-client signing key magic constant"),
+		sealing:      sealing,
+		unsealing:    unsealing,
+		keyExchange:  keyExchange,
+	}
+}
+
+func (s *ntlmSealer) wrap(message []byte) ([]byte, []byte, error) {
+	sealed := make([]byte, len(message))
+	s.sealing.XORKeyStream(sealed, message)
+
+	signature := s.signature(s.signingKey, s.sealing, s.sendSeq, message)
+	s.sendSeq++
+
+	return signature, sealed, nil
+}
+
+func (s *ntlmSealer) unwrap(signature []byte, sealed []byte) ([]byte, error) {
+	if len(signature) != ntlmSignatureLength {
+		return nil, fmt.Errorf("NTLM signature is %d bytes long instead of %d", len(signature), ntlmSignatureLength)
+	}
+
+	message := make([]byte, len(sealed))
+	s.unsealing.XORKeyStream(message, sealed)
+
+	expected := s.signature(s.verifyingKey, s.unsealing, s.receiveSeq, message)
+	s.receiveSeq++
+	if !hmac.Equal(signature, expected) {
+		return nil, errors.New("NTLM signature of the message is invalid")
+	}
+
+	return message, nil
+}
+
+// signature returns the NTLMSSP_MESSAGE_SIGNATURE of MS-NLMP 2.2.2.9.1 for message.
+func (s *ntlmSealer) signature(key []byte, cipher *rc4.Cipher, seq uint32, message []byte) []byte {
+	sequence := binary.LittleEndian.AppendUint32(nil, seq)
+	checksum := hmacMD5(key, sequence, message)[:8]
+	if s.keyExchange {
+		cipher.XORKeyStream(checksum, checksum)
+	}
+
+	signature := binary.LittleEndian.AppendUint32(nil, 1)
+	signature = append(signature, checksum...)
+
+	return append(signature, sequence...)
+}
@@ -0,0 +1,231 @@
+package winrm_encryption
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+// The values of the NTLMv2 example of MS-NLMP 4.2.4
+var (
+	ntlmExampleServerChallenge = mustHex("0123456789abcdef")
+	ntlmExampleRandom          = append(bytes.Repeat([]byte{0xaa}, 8), bytes.Repeat([]byte{0x55}, 16)...)
+	ntlmExampleSessionKey      = bytes.Repeat([]byte{0x55}, 16)
+)
+
+func mustHex(text string) []byte {
+	b, err := hex.DecodeString(text)
+	if err != nil {
+		panic(err)
+	}
+
+	return b
+}
+
+// ntlmChallenge returns a CHALLENGE_MESSAGE with flags, the challenge of the server and targetInfo.
+func ntlmChallenge(flags uint32, serverChallenge []byte, targetInfo []byte) []byte {
+	message := make([]byte, 56)
+	copy(message, ntlmSignature)
+	binary.LittleEndian.PutUint32(message[8:], ntlmChallengeMessage)
+	binary.LittleEndian.PutUint32(message[20:], flags)
+	copy(message[24:], serverChallenge)
+	binary.LittleEndian.PutUint16(message[40:], uint16(len(targetInfo)))
+	binary.LittleEndian.PutUint16(message[42:], uint16(len(targetInfo)))
+	binary.LittleEndian.PutUint32(message[44:], uint32(len(message)))
+
+	return append(message, targetInfo...)
+}
+
+func ntlmTargetInfo(pairs ...[]byte) []byte {
+	var targetInfo []byte
+	for i := 0; i+1 < len(pairs); i += 2 {
+		targetInfo = append(targetInfo, pairs[i]...)
+		targetInfo = binary.LittleEndian.AppendUint16(targetInfo, uint16(len(pairs[i+1])))
+		targetInfo = append(targetInfo, pairs[i+1]...)
+	}
+
+	return append(targetInfo, 0, 0, 0, 0)
+}
+
+func newExampleNTLM() *ntlm {
+	n := newNTLM(`Domain\User`, "Password")
+	n.random = bytes.NewReader(ntlmExampleRandom)
+	n.now = func() time.Time { return time.Date(1601, 1, 1, 0, 0, 0, 0, time.UTC) }
+
+	return n
+}
+
+func TestNTLMAuthenticateMessage(t *testing.T) {
+	t.Parallel()
+
+	n := newExampleNTLM()
+	challenge := ntlmChallenge(0xe28a8233, ntlmExampleServerChallenge, ntlmTargetInfo(
+		[]byte{2, 0}, utf16le("Domain"),
+		[]byte{1, 0}, utf16le("Server"),
+	))
+
+	message, sessionKey, flags, err := n.authenticateMessage(n.negotiateMessage(), challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(sessionKey, ntlmExampleSessionKey) {
+		t.Fatalf("expected session key %x, got %x", ntlmExampleSessionKey, sessionKey)
+	}
+	if flags&ntlmNegotiateKeyExchange == 0 {
+		t.Fatalf("expected key exchange to be negotiated, got flags 0x%08x", flags)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		offset int
+		length int
+		want   string
+	}{
+		{name: "LMv2 response", offset: 12, length: 24, want: "86c35097ac9cec102554764a57cccc19aaaaaaaaaaaaaaaa"},
+		{name: "NTProofStr", offset: 20, length: 16, want: "68cd0ab851e51c96aabc927bebef6a1c"},
+		{name: "domain", offset: 28, length: 12, want: hex.EncodeToString(utf16le("Domain"))},
+		{name: "user", offset: 36, length: 8, want: hex.EncodeToString(utf16le("User"))},
+		{name: "encrypted session key", offset: 52, length: 16, want: "c5dad2544fc9799094ce1ce90bc9d03e"},
+	} {
+		field, err := ntlmField(message, tt.offset)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := hex.EncodeToString(field[:min(len(field), tt.length)]); got != tt.want {
+			t.Errorf("expected %s %s, got %s", tt.name, tt.want, got)
+		}
+	}
+
+	if mic := message[ntlmMICOffset : ntlmMICOffset+16]; !bytes.Equal(mic, make([]byte, 16)) {
+		t.Errorf("expected no MIC without a server timestamp, got %x", mic)
+	}
+}
+
+func TestNTLMAuthenticateMessageWithTimestamp(t *testing.T) {
+	t.Parallel()
+
+	n := newExampleNTLM()
+	timestamp := ntlmFiletime(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	challenge := ntlmChallenge(0xe28a8233, ntlmExampleServerChallenge, ntlmTargetInfo(
+		[]byte{2, 0}, utf16le("Domain"),
+		[]byte{7, 0}, timestamp,
+	))
+	negotiate := n.negotiateMessage()
+
+	message, sessionKey, _, err := n.authenticateMessage(negotiate, challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lmResponse, err := ntlmField(message, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(lmResponse, make([]byte, 24)) {
+		t.Errorf("expected an empty LMv2 response, got %x", lmResponse)
+	}
+
+	ntResponse, err := ntlmField(message, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ntResponse[24:32]; !bytes.Equal(got, timestamp) {
+		t.Errorf("expected the timestamp of the server %x, got %x", timestamp, got)
+	}
+	if avFlags, ok := ntlmAvPair(ntResponse[44:], ntlmAvFlags); !ok || binary.LittleEndian.Uint32(avFlags) != ntlmAvFlagMIC {
+		t.Errorf("expected MsvAvFlags 0x%08x, got %x", ntlmAvFlagMIC, avFlags)
+	}
+
+	mic := append([]byte{}, message[ntlmMICOffset:ntlmMICOffset+16]...)
+	copy(message[ntlmMICOffset:], make([]byte, 16))
+	if want := hmacMD5(sessionKey, negotiate, challenge, message); !bytes.Equal(mic, want) {
+		t.Errorf("expected MIC %x, got %x", want, mic)
+	}
+}
+
+func TestNTLMAuthenticateMessageRequiresSealing(t *testing.T) {
+	t.Parallel()
+
+	n := newExampleNTLM()
+	challenge := ntlmChallenge(0xe28a8233&^ntlmNegotiateSeal, ntlmExampleServerChallenge, ntlmTargetInfo())
+
+	if _, _, _, err := n.authenticateMessage(n.negotiateMessage(), challenge); err == nil {
+		t.Fatal("expected an error when the server does not support sealing")
+	}
+}
+
+func TestNTLMSealer(t *testing.T) {
+	t.Parallel()
+
+	client := newNTLMSealer(ntlmExampleSessionKey, true)
+
+	signature, sealed, err := client.wrap(utf16le("Plaintext"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := hex.EncodeToString(sealed), "54e50165bf1936dc996020c1811b0f06fb5f"; got != want {
+		t.Errorf("expected sealed message %s, got %s", want, got)
+	}
+	if got, want := hex.EncodeToString(signature), "010000007fb38ec5c55d497600000000"; got != want {
+		t.Errorf("expected signature %s, got %s", want, got)
+	}
+}
+
+func TestNTLMSealerRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	client := newNTLMSealer(ntlmExampleSessionKey, true)
+	server := newNTLMServerSealer(ntlmExampleSessionKey)
+
+	for i, message := range []string{"first request", "second request", ""} {
+		signature, sealed, err := client.wrap([]byte(message))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := server.unwrap(signature, sealed)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		if string(got) != message {
+			t.Fatalf("expected request %q, got %q", message, got)
+		}
+
+		signature, sealed, err = server.wrap([]byte("response to " + message))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err = client.unwrap(signature, sealed)
+		if err != nil {
+			t.Fatalf("response %d: %v", i, err)
+		}
+		if string(got) != "response to "+message {
+			t.Fatalf("expected response to %q, got %q", message, got)
+		}
+	}
+
+	signature, sealed, err := server.wrap([]byte("tampered"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed[0] ^= 0xff
+	if _, err := client.unwrap(signature, sealed); err == nil {
+		t.Fatal("expected an error for a tampered message")
+	}
+}
+
+// newNTLMServerSealer returns the sealer of the server side of a security context.
+func newNTLMServerSealer(sessionKey []byte) *ntlmSealer {
+	client := newNTLMSealer(sessionKey, true)
+
+	return &ntlmSealer{
+		signingKey:   client.verifyingKey,
+		verifyingKey: client.signingKey,
+		sealing:      client.unsealing,
+		unsealing:    client.sealing,
+		keyExchange:  true,
+	}
+}
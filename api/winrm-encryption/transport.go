@@ -0,0 +1,368 @@
+// Package winrm_encryption provides a WinRM transport that encrypts messages with the security context of the
+// NTLM or Kerberos authentication, as Windows clients do over HTTP, see MS-WSMV 2.2.9.1.
+package winrm_encryption
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/masterzen/winrm"
+	"github.com/masterzen/winrm/soap"
+)
+
+const (
+	// ProtocolSPNEGO is the protocol of messages encrypted by a Negotiate authentication
+	ProtocolSPNEGO = "application/HTTP-SPNEGO-session-encrypted"
+	// ProtocolKerberos is the protocol of messages encrypted by a Kerberos authentication
+	ProtocolKerberos = "application/HTTP-Kerberos-session-encrypted"
+
+	boundary    = "Encrypted Boundary"
+	soapContent = "application/soap+xml;charset=UTF-8"
+)
+
+var originalLengthRegexp = regexp.MustCompile(`(?i)\bLength=(\d+)`)
+
+// exchangeFunc sends token to the server in an empty request and returns the token of its response, if any, and
+// whether the server accepted the authentication.
+type exchangeFunc func(token []byte) ([]byte, bool, error)
+
+// mechanism establishes a security context with host over exchange.
+type mechanism interface {
+	authenticate(host string, exchange exchangeFunc) (sealer, error)
+}
+
+// sealer encrypts and signs the messages of a security context. wrap returns the signature and the encrypted
+// message separately, unwrap takes them back.
+type sealer interface {
+	wrap(message []byte) ([]byte, []byte, error)
+	unwrap(signature []byte, sealed []byte) ([]byte, error)
+}
+
+// Transporter posts WinRM messages encrypted with the security context of its authentication. A security context
+// is bound to the connection it was established on, so every session of the transporter holds a single connection,
+// and the transporter authenticates again when the server has closed it.
+type Transporter struct {
+	// Dial opens the connections to the server when it is set, the proxy of the environment is then not used. Every
+	// connection holds a security context, a proxy must tunnel them rather than forward requests.
+	Dial func(network, addr string) (net.Conn, error)
+
+	scheme    string
+	protocol  string
+	mechanism mechanism
+
+	host      string
+	url       string
+	transport *http.Transport
+
+	mutex    sync.Mutex
+	sessions []*session
+}
+
+var _ winrm.Transporter = (*Transporter)(nil)
+
+// NewNTLM returns a transporter authenticating user with NTLM, in the Negotiate scheme.
+func NewNTLM(user string, password string) *Transporter {
+	return &Transporter{
+		scheme:    "Negotiate",
+		protocol:  ProtocolSPNEGO,
+		mechanism: newNTLM(user, password),
+	}
+}
+
+// NewKerberos returns a transporter authenticating with Kerberos, in the Kerberos scheme.
+func NewKerberos(config *KerberosConfig) *Transporter {
+	return &Transporter{
+		scheme:    "Kerberos",
+		protocol:  ProtocolKerberos,
+		mechanism: &kerberos{config: config},
+	}
+}
+
+// Transport configures the HTTP transport for endpoint, as the transporters of the winrm package do.
+func (t *Transporter) Transport(endpoint *winrm.Endpoint) error {
+	dial := (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).Dial
+	proxy := http.ProxyFromEnvironment
+	if t.Dial != nil {
+		dial = t.Dial
+		proxy = nil
+	}
+
+	transport := &http.Transport{
+		Proxy: proxy,
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: endpoint.Insecure,
+			ServerName:         endpoint.TLSServerName,
+		},
+		Dial:                  dial,
+		ResponseHeaderTimeout: endpoint.Timeout,
+		MaxConnsPerHost:       1,
+		MaxIdleConnsPerHost:   1,
+		IdleConnTimeout:       90 * time.Second,
+	}
+
+	if len(endpoint.CACert) > 0 {
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(endpoint.CACert) {
+			return errors.New("unable to read certificates")
+		}
+		transport.TLSClientConfig.RootCAs = certPool
+	}
+
+	scheme := "http"
+	if endpoint.HTTPS {
+		scheme = "https"
+	}
+
+	t.host = endpoint.Host
+	t.url = fmt.Sprintf("%s://%s/wsman", scheme, net.JoinHostPort(strings.Trim(endpoint.Host, "[]"), strconv.Itoa(endpoint.Port)))
+	t.transport = transport
+
+	return nil
+}
+
+// Post encrypts request, posts it and decrypts the response. Responses other than 200 are returned as errors
+// formatted like the ones of the winrm package, so that they are classified the same way.
+func (t *Transporter) Post(_ *winrm.Client, request *soap.SoapMessage) (string, error) {
+	if t.transport == nil {
+		return "", errors.New("Transport has to be called before Post")
+	}
+
+	message := []byte(request.String())
+	for {
+		session, established, err := t.session()
+		if err != nil {
+			return "", err
+		}
+
+		status, body, err := session.post(t.protocol, message)
+		if err != nil {
+			session.close()
+			return "", err
+		}
+
+		// The server forgets the security context when the connection is closed, an existing session is
+		// established again on a new connection
+		if status == http.StatusUnauthorized && !established {
+			log.Printf("[DEBUG] Security context of %s was lost, authenticating again", t.url)
+			session.close()
+			continue
+		}
+
+		t.release(session)
+
+		if status != http.StatusOK {
+			return "", fmt.Errorf("http error %d: %s", status, body)
+		}
+
+		return string(body), nil
+	}
+}
+
+// session returns an idle session or a session that was just established, in which case established is true.
+func (t *Transporter) session() (*session, bool, error) {
+	t.mutex.Lock()
+	if n := len(t.sessions); n > 0 {
+		session := t.sessions[n-1]
+		t.sessions = t.sessions[:n-1]
+		t.mutex.Unlock()
+
+		return session, false, nil
+	}
+	t.mutex.Unlock()
+
+	session := &session{
+		url:    t.url,
+		client: &http.Client{Transport: t.transport.Clone()},
+	}
+	sealer, err := t.mechanism.authenticate(t.host, func(token []byte) ([]byte, bool, error) {
+		return session.exchange(t.scheme, token)
+	})
+	if err != nil {
+		session.close()
+		return nil, false, fmt.Errorf("error authenticating to %s: %w", t.url, err)
+	}
+	session.sealer = sealer
+
+	return session, true, nil
+}
+
+func (t *Transporter) release(session *session) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.sessions = append(t.sessions, session)
+}
+
+// session is a security context established over the single connection of client.
+type session struct {
+	url    string
+	client *http.Client
+	sealer sealer
+}
+
+// exchange posts an empty request authenticated with token.
+func (s *session) exchange(scheme string, token []byte) ([]byte, bool, error) {
+	request, err := http.NewRequest(http.MethodPost, s.url, http.NoBody)
+	if err != nil {
+		return nil, false, err
+	}
+	request.Header.Set("Authorization", scheme+" "+base64.StdEncoding.EncodeToString(token))
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return nil, false, err
+	}
+	body, err := readBody(response)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var responseToken []byte
+	for _, header := range response.Header.Values("WWW-Authenticate") {
+		if value, ok := strings.CutPrefix(header, scheme+" "); ok {
+			responseToken, err = base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+			if err != nil {
+				return nil, false, fmt.Errorf("invalid %s token: %w", scheme, err)
+			}
+			break
+		}
+	}
+
+	switch {
+	case response.StatusCode == http.StatusOK:
+		return responseToken, true, nil
+	case response.StatusCode == http.StatusUnauthorized && responseToken != nil:
+		return responseToken, false, nil
+	default:
+		return nil, false, fmt.Errorf("http error %d: %s", response.StatusCode, body)
+	}
+}
+
+// post sends message encrypted and returns the status and the decrypted body of the response.
+func (s *session) post(protocol string, message []byte) (int, []byte, error) {
+	signature, sealed, err := s.sealer.wrap(message)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(encodeMultipart(protocol, len(message), signature, sealed)))
+	if err != nil {
+		return 0, nil, err
+	}
+	request.Header.Set("Content-Type", fmt.Sprintf(`multipart/encrypted;protocol="%s";boundary="%s"`, protocol, boundary))
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return 0, nil, fmt.Errorf("unknown error %w", err)
+	}
+	body, err := readBody(response)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if !strings.HasPrefix(response.Header.Get("Content-Type"), "multipart/encrypted") {
+		return response.StatusCode, body, nil
+	}
+
+	length, signature, sealed, err := decodeMultipart(protocol, body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("http response error: %d - %w", response.StatusCode, err)
+	}
+	decrypted, err := s.sealer.unwrap(signature, sealed)
+	if err != nil {
+		return 0, nil, fmt.Errorf("http response error: %d - %w", response.StatusCode, err)
+	}
+	if len(decrypted) != length {
+		return 0, nil, fmt.Errorf("http response error: %d - decrypted message is %d bytes long instead of %d", response.StatusCode, len(decrypted), length)
+	}
+
+	return response.StatusCode, decrypted, nil
+}
+
+func (s *session) close() {
+	s.client.CloseIdleConnections()
+}
+
+func readBody(response *http.Response) ([]byte, error) {
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading request body %w", err)
+	}
+
+	return body, nil
+}
+
+// encodeMultipart returns the multipart/encrypted body of MS-WSMV 2.2.9.1.1 holding a message of length bytes.
+func encodeMultipart(protocol string, length int, signature []byte, sealed []byte) []byte {
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "--%s\r\n", boundary)
+	fmt.Fprintf(&body, "\tContent-Type: %s\r\n", protocol)
+	fmt.Fprintf(&body, "\tOriginalContent: type=%s;Length=%d\r\n", soapContent, length)
+	fmt.Fprintf(&body, "--%s\r\n", boundary)
+	body.WriteString("\tContent-Type: application/octet-stream\r\n")
+	body.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(signature))))
+	body.Write(signature)
+	body.Write(sealed)
+	fmt.Fprintf(&body, "--%s--\r\n", boundary)
+
+	return body.Bytes()
+}
+
+// decodeMultipart returns the length of the original message, the signature and the encrypted message of a
+// multipart/encrypted body.
+func decodeMultipart(protocol string, body []byte) (int, []byte, []byte, error) {
+	parts := bytes.Split(body, []byte("--"+boundary+"\r\n"))
+	if len(parts) != 3 || len(parts[0]) != 0 {
+		return 0, nil, nil, errors.New("invalid multipart/encrypted body")
+	}
+
+	header := string(parts[1])
+	if !strings.Contains(header, protocol) {
+		return 0, nil, nil, fmt.Errorf("encrypted body is not %s", protocol)
+	}
+	match := originalLengthRegexp.FindStringSubmatch(header)
+	if match == nil {
+		return 0, nil, nil, errors.New("encrypted body does not have a length")
+	}
+	length, err := strconv.Atoi(match[1])
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("invalid length of encrypted body: %w", err)
+	}
+
+	payload, ok := bytes.CutPrefix(parts[2], []byte("\tContent-Type: application/octet-stream\r\n"))
+	if !ok {
+		return 0, nil, nil, errors.New("encrypted body is not application/octet-stream")
+	}
+	payload, ok = bytes.CutSuffix(payload, []byte("--"+boundary+"--\r\n"))
+	if !ok {
+		return 0, nil, nil, errors.New("encrypted body is not terminated")
+	}
+	if len(payload) < 4 {
+		return 0, nil, nil, errors.New("encrypted body is truncated")
+	}
+	signatureLength := int(binary.LittleEndian.Uint32(payload))
+	if len(payload) < 4+signatureLength {
+		return 0, nil, nil, errors.New("encrypted body is truncated")
+	}
+
+	return length, payload[4 : 4+signatureLength], payload[4+signatureLength:], nil
+}
@@ -0,0 +1,321 @@
+package winrm_encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/masterzen/winrm"
+	"github.com/masterzen/winrm/soap"
+)
+
+// ntlmServer is a WinRM endpoint that only accepts messages encrypted with NTLM. Security contexts are kept per
+// connection, as Windows does.
+type ntlmServer struct {
+	*httptest.Server
+	t        *testing.T
+	user     string
+	domain   string
+	password string
+
+	mutex           sync.Mutex
+	contexts        map[string]*ntlmSealer
+	challenges      map[string][]byte
+	authentications int
+	requests        []string
+
+	// status is the status of the responses to encrypted messages
+	status int
+}
+
+func newNTLMServer(t *testing.T) *ntlmServer {
+	t.Helper()
+
+	server := &ntlmServer{
+		t:          t,
+		user:       "Administrator",
+		domain:     "HV",
+		password:   "P@ssw0rd",
+		contexts:   map[string]*ntlmSealer{},
+		challenges: map[string][]byte{},
+		status:     http.StatusOK,
+	}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.handle))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func (s *ntlmServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		s.authenticate(w, r.RemoteAddr, authorization)
+		return
+	}
+
+	sealer := s.contexts[r.RemoteAddr]
+	if sealer == nil || !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/encrypted") {
+		w.Header().Set("WWW-Authenticate", "Negotiate")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	length, signature, sealed, err := decodeMultipart(ProtocolSPNEGO, body)
+	if err != nil {
+		s.t.Errorf("invalid encrypted request: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	request, err := sealer.unwrap(signature, sealed)
+	if err != nil || len(request) != length {
+		s.t.Errorf("invalid encrypted request of %d bytes: %v", length, err)
+		http.Error(w, "invalid encrypted request", http.StatusBadRequest)
+		return
+	}
+	s.requests = append(s.requests, string(request))
+
+	response := []byte(fmt.Sprintf("<response>%d</response>", len(s.requests)))
+	signature, sealed, err = sealer.wrap(response)
+	if err != nil {
+		s.t.Error(err)
+	}
+	w.Header().Set("Content-Type", fmt.Sprintf(`multipart/encrypted;protocol="%s";boundary="%s"`, ProtocolSPNEGO, boundary))
+	w.WriteHeader(s.status)
+	_, _ = w.Write(encodeMultipart(ProtocolSPNEGO, len(response), signature, sealed))
+}
+
+func (s *ntlmServer) authenticate(w http.ResponseWriter, connection string, authorization string) {
+	token, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(authorization, "Negotiate "))
+	if err != nil || len(token) < 12 {
+		http.Error(w, "invalid token", http.StatusBadRequest)
+		return
+	}
+
+	switch binary.LittleEndian.Uint32(token[8:]) {
+	case ntlmNegotiateMessage:
+		challenge := ntlmChallenge(binary.LittleEndian.Uint32(token[12:]), []byte("12345678"), ntlmTargetInfo(
+			[]byte{2, 0}, utf16le(s.domain),
+			[]byte{7, 0}, ntlmFiletime(time.Now()),
+		))
+		s.challenges[connection] = challenge
+		w.Header().Set("WWW-Authenticate", "Negotiate "+base64.StdEncoding.EncodeToString(challenge))
+		w.WriteHeader(http.StatusUnauthorized)
+
+	case ntlmAuthenticateMessage:
+		challenge := s.challenges[connection]
+		domain, _ := ntlmField(token, 28)
+		user, _ := ntlmField(token, 36)
+		ntResponse, _ := ntlmField(token, 20)
+		encryptedSessionKey, _ := ntlmField(token, 52)
+		if challenge == nil || len(ntResponse) < 16 || len(encryptedSessionKey) != 16 ||
+			!bytes.Equal(domain, utf16le(s.domain)) || !bytes.Equal(user, utf16le(s.user)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		responseKey := ntlmResponseKey(s.user, s.domain, s.password)
+		ntProof := hmacMD5(responseKey, challenge[24:32], ntResponse[16:])
+		if !bytes.Equal(ntProof, ntResponse[:16]) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		s.authentications++
+		s.contexts[connection] = newNTLMServerSealer(rc4Crypt(hmacMD5(responseKey, ntProof), encryptedSessionKey))
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		http.Error(w, "unexpected token", http.StatusBadRequest)
+	}
+}
+
+// forget drops the security contexts, as the server does when connections are closed.
+func (s *ntlmServer) forget() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.contexts = map[string]*ntlmSealer{}
+}
+
+func (s *ntlmServer) transporter(t *testing.T, user string, password string) *Transporter {
+	t.Helper()
+
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(s.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	portNumber, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transporter := NewNTLM(user, password)
+	if err := transporter.Transport(winrm.NewEndpoint(host, portNumber, false, false, nil, nil, nil, 10*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	return transporter
+}
+
+func testMessage(action string) *soap.SoapMessage {
+	message := soap.NewMessage()
+	message.Header().Action(action).Build()
+
+	return message
+}
+
+func TestTransporterEncryptsMessages(t *testing.T) {
+	t.Parallel()
+
+	server := newNTLMServer(t)
+	transporter := server.transporter(t, `HV\Administrator`, "P@ssw0rd")
+
+	for i, action := range []string{"first", "second", "third"} {
+		response, err := transporter.Post(nil, testMessage(action))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("<response>%d</response>", i+1); response != want {
+			t.Fatalf("expected %s, got %s", want, response)
+		}
+	}
+
+	if server.authentications != 1 {
+		t.Fatalf("expected the security context to be reused, got %d authentications", server.authentications)
+	}
+	for i, action := range []string{"first", "second", "third"} {
+		if !strings.Contains(server.requests[i], ">"+action+"<") {
+			t.Fatalf("expected request %d to be %s, got %s", i, action, server.requests[i])
+		}
+	}
+}
+
+func TestTransporterAuthenticatesAgain(t *testing.T) {
+	t.Parallel()
+
+	server := newNTLMServer(t)
+	transporter := server.transporter(t, `HV\Administrator`, "P@ssw0rd")
+
+	if _, err := transporter.Post(nil, testMessage("first")); err != nil {
+		t.Fatal(err)
+	}
+	server.forget()
+	if _, err := transporter.Post(nil, testMessage("second")); err != nil {
+		t.Fatal(err)
+	}
+
+	if server.authentications != 2 {
+		t.Fatalf("expected a second authentication, got %d", server.authentications)
+	}
+}
+
+func TestTransporterConcurrentPosts(t *testing.T) {
+	t.Parallel()
+
+	server := newNTLMServer(t)
+	transporter := server.transporter(t, `HV\Administrator`, "P@ssw0rd")
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				if _, err := transporter.Post(nil, testMessage(fmt.Sprintf("request-%d-%d", i, j))); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if len(server.requests) != 40 {
+		t.Fatalf("expected 40 requests, got %d", len(server.requests))
+	}
+}
+
+func TestTransporterErrors(t *testing.T) {
+	t.Parallel()
+
+	t.Run("wrong password", func(t *testing.T) {
+		t.Parallel()
+
+		server := newNTLMServer(t)
+		transporter := server.transporter(t, `HV\Administrator`, "wrong")
+
+		_, err := transporter.Post(nil, testMessage("first"))
+		if err == nil || !strings.Contains(err.Error(), "http error 401") {
+			t.Fatalf("expected an authentication error, got %v", err)
+		}
+	})
+
+	t.Run("server error", func(t *testing.T) {
+		t.Parallel()
+
+		server := newNTLMServer(t)
+		server.status = http.StatusInternalServerError
+		transporter := server.transporter(t, `HV\Administrator`, "P@ssw0rd")
+
+		// The response is decrypted, and formatted like the errors of the winrm package
+		_, err := transporter.Post(nil, testMessage("first"))
+		if err == nil || err.Error() != "http error 500: <response>1</response>" {
+			t.Fatalf("expected the decrypted server error, got %v", err)
+		}
+	})
+}
+
+func TestMultipart(t *testing.T) {
+	t.Parallel()
+
+	body := encodeMultipart(ProtocolSPNEGO, 5, []byte("signature"), []byte("sealed"))
+
+	want := "--Encrypted Boundary\r\n" +
+		"\tContent-Type: application/HTTP-SPNEGO-session-encrypted\r\n" +
+		"\tOriginalContent: type=application/soap+xml;charset=UTF-8;Length=5\r\n" +
+		"--Encrypted Boundary\r\n" +
+		"\tContent-Type: application/octet-stream\r\n" +
+		"\x09\x00\x00\x00signaturesealed" +
+		"--Encrypted Boundary--\r\n"
+	if string(body) != want {
+		t.Fatalf("expected %q, got %q", want, body)
+	}
+
+	length, signature, sealed, err := decodeMultipart(ProtocolSPNEGO, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if length != 5 || string(signature) != "signature" || string(sealed) != "sealed" {
+		t.Fatalf("expected 5, signature and sealed, got %d, %s and %s", length, signature, sealed)
+	}
+
+	if _, _, _, err := decodeMultipart(ProtocolKerberos, body); err == nil {
+		t.Fatal("expected an error for another protocol")
+	}
+	if _, _, _, err := decodeMultipart(ProtocolSPNEGO, body[:len(body)-10]); err == nil {
+		t.Fatal("expected an error for a truncated body")
+	}
+}
@@ -10,6 +10,7 @@ import (
 	pool "github.com/jolestar/go-commons-pool/v2"
 	"github.com/masterzen/winrm"
 	"github.com/taliesins/terraform-provider-hyperv/api/commandresult"
+	"github.com/taliesins/terraform-provider-hyperv/api/redact"
 	"github.com/taliesins/terraform-provider-hyperv/powershell"
 )
 
@@ -24,6 +25,22 @@ type ClientConfig struct {
 	ElevatedUser     string
 	ElevatedPassword string
 	Vars             string
+
+	Redactor *redact.Redactor // Optional: masks secrets in logged scripts and in errors
+}
+
+// redactor masks the secrets of Redactor, the elevated password and the sensitive fields of args.
+func (c *ClientConfig) redactor(args interface{}) *redact.Redactor {
+	return c.Redactor.With(c.ElevatedPassword).WithArgs(args)
+}
+
+// Close closes the pooled WinRM clients and stops the eviction of idle clients.
+func (c *ClientConfig) Close() error {
+	if c.WinRmClientPool != nil {
+		c.WinRmClientPool.Close(context.Background())
+	}
+
+	return nil
 }
 
 func (c *ClientConfig) RunFireAndForgetScript(ctx context.Context, script *template.Template, args interface{}) error {
@@ -42,7 +59,8 @@ func (c *ClientConfig) RunFireAndForgetScript(ctx context.Context, script *templ
 		return err
 	}
 
-	log.Printf("[DEBUG] Running fire and forget script:\n%s\n", command)
+	redactor := c.redactor(args)
+	log.Printf("[DEBUG] Running fire and forget script:\n%s\n", redactor.String(command))
 
 	client, ok := winrmClient.(*winrm.Client)
 	if !ok {
@@ -52,19 +70,50 @@ func (c *ClientConfig) RunFireAndForgetScript(ctx context.Context, script *templ
 		return fmt.Errorf("failed to cast winrmClient to *winrm.Client")
 	}
 
-	_, _, _, err = powershell.RunPowershell(client, c.ElevatedUser, c.ElevatedPassword, c.Vars, command)
+	exitStatus, stdout, stderr, err := powershell.RunPowershell(ctx, client, redactor, c.ElevatedUser, c.ElevatedPassword, c.Vars, command)
 
 	err2 := c.WinRmClientPool.ReturnObject(ctx, winrmClient)
 
 	if err != nil {
-		return err
+		return redactor.Error(err)
 	}
 
 	if err2 != nil {
 		return err2
 	}
 
-	return nil
+	return commandresult.CheckExitCode(redactor, exitStatus, stdout, stderr)
+}
+
+// RunScript runs a rendered script and returns its raw output and exit code
+func (c *ClientConfig) RunScript(ctx context.Context, command string) (stdout, stderr string, exitCode int, err error) {
+	winrmClient, err := c.WinRmClientPool.BorrowObject(ctx)
+
+	if err != nil {
+		return "", "", -1, err
+	}
+
+	client, ok := winrmClient.(*winrm.Client)
+	if !ok {
+		if returnErr := c.WinRmClientPool.ReturnObject(ctx, winrmClient); returnErr != nil {
+			return "", "", -1, fmt.Errorf("failed to cast winrmClient to *winrm.Client: additionally failed returning winrm client to pool: %w", returnErr)
+		}
+		return "", "", -1, fmt.Errorf("failed to cast winrmClient to *winrm.Client")
+	}
+
+	exitCode, stdout, stderr, err = powershell.RunPowershell(ctx, client, c.redactor(nil), c.ElevatedUser, c.ElevatedPassword, c.Vars, command)
+
+	err2 := c.WinRmClientPool.ReturnObject(ctx, winrmClient)
+
+	if err != nil {
+		return "", "", -1, c.redactor(nil).Error(err)
+	}
+
+	if err2 != nil {
+		return "", "", -1, err2
+	}
+
+	return stdout, stderr, exitCode, nil
 }
 
 func (c *ClientConfig) RunScriptWithResult(ctx context.Context, script *template.Template, args interface{}, result interface{}) (err error) {
@@ -83,7 +132,8 @@ func (c *ClientConfig) RunScriptWithResult(ctx context.Context, script *template
 		return err
 	}
 
-	log.Printf("[DEBUG] Running script with result:\n%s\n", command)
+	redactor := c.redactor(args)
+	log.Printf("[DEBUG] Running script with result:\n%s\n", redactor.String(command))
 
 	client, ok := winrmClient.(*winrm.Client)
 	if !ok {
@@ -93,19 +143,67 @@ func (c *ClientConfig) RunScriptWithResult(ctx context.Context, script *template
 		return fmt.Errorf("failed to cast winrmClient to *winrm.Client")
 	}
 
-	exitStatus, stdout, stderr, err := powershell.RunPowershell(client, c.ElevatedUser, c.ElevatedPassword, c.Vars, command)
+	exitStatus, stdout, stderr, err := powershell.RunPowershell(ctx, client, redactor, c.ElevatedUser, c.ElevatedPassword, c.Vars, command)
 
 	err2 := c.WinRmClientPool.ReturnObject(ctx, winrmClient)
 
+	if err != nil {
+		return redactor.Error(err)
+	}
+
+	if err2 != nil {
+		return err2
+	}
+
+	return commandresult.DecodeJSON(redactor, exitStatus, stdout, stderr, command, result)
+}
+
+// RunStreamingScript executes a script, passing its records to records while it runs, and unmarshals JSON output into
+// result unless result is nil
+func (c *ClientConfig) RunStreamingScript(ctx context.Context, script *template.Template, args interface{}, result interface{}, records commandresult.RecordHandler) (err error) {
+	var scriptRendered bytes.Buffer
+	err = script.Execute(&scriptRendered, args)
+
+	if err != nil {
+		return err
+	}
+
+	command := scriptRendered.String()
+
+	winrmClient, err := c.WinRmClientPool.BorrowObject(ctx)
+
 	if err != nil {
 		return err
 	}
 
+	redactor := c.redactor(args)
+	log.Printf("[DEBUG] Running streaming script:\n%s\n", redactor.String(command))
+
+	client, ok := winrmClient.(*winrm.Client)
+	if !ok {
+		if returnErr := c.WinRmClientPool.ReturnObject(ctx, winrmClient); returnErr != nil {
+			return fmt.Errorf("failed to cast winrmClient to *winrm.Client: additionally failed returning winrm client to pool: %w", returnErr)
+		}
+		return fmt.Errorf("failed to cast winrmClient to *winrm.Client")
+	}
+
+	exitStatus, stdout, stderr, err := powershell.RunPowershellStreaming(ctx, client, redactor, c.ElevatedUser, c.ElevatedPassword, c.Vars, command, commandresult.NewRecordWriter(redactor, records))
+
+	err2 := c.WinRmClientPool.ReturnObject(ctx, winrmClient)
+
+	if err != nil {
+		return redactor.Error(err)
+	}
+
 	if err2 != nil {
 		return err2
 	}
 
-	return commandresult.DecodeJSON(exitStatus, stdout, stderr, command, result)
+	if result == nil {
+		return commandresult.CheckExitCode(redactor, exitStatus, stdout, stderr)
+	}
+
+	return commandresult.DecodeJSON(redactor, exitStatus, stdout, stderr, command, result)
 }
 
 func (c *ClientConfig) UploadFile(ctx context.Context, filePath string, remoteFilePath string) (string, error) {
@@ -125,7 +223,7 @@ func (c *ClientConfig) UploadFile(ctx context.Context, filePath string, remoteFi
 		return "", fmt.Errorf("failed to cast winrmClient to *winrm.Client")
 	}
 
-	remoteFilePath, err = powershell.UploadFile(client, filePath, remoteFilePath)
+	remoteFilePath, err = powershell.UploadFile(ctx, client, c.redactor(nil), filePath, remoteFilePath)
 
 	err2 := c.WinRmClientPool.ReturnObject(ctx, winrmClient)
 
@@ -159,7 +257,7 @@ func (c *ClientConfig) UploadDirectory(ctx context.Context, rootPath string, exc
 		return "", []string{}, fmt.Errorf("failed to cast winrmClient to *winrm.Client")
 	}
 
-	remoteRootPath, remoteAbsoluteFilePaths, err = powershell.UploadDirectory(client, rootPath, excludeList)
+	remoteRootPath, remoteAbsoluteFilePaths, err = powershell.UploadDirectory(ctx, client, c.redactor(nil), rootPath, excludeList)
 
 	err2 := c.WinRmClientPool.ReturnObject(ctx, winrmClient)
 
@@ -193,7 +291,7 @@ func (c *ClientConfig) FileExists(ctx context.Context, remoteFilePath string) (e
 		return false, fmt.Errorf("failed to cast winrmClient to *winrm.Client")
 	}
 
-	result, err := powershell.FileExists(client, remoteFilePath)
+	result, err := powershell.FileExists(ctx, client, c.redactor(nil), remoteFilePath)
 
 	err2 := c.WinRmClientPool.ReturnObject(ctx, winrmClient)
 
@@ -231,7 +329,7 @@ func (c *ClientConfig) DirectoryExists(ctx context.Context, remoteDirectoryPath
 		return false, fmt.Errorf("failed to cast winrmClient to *winrm.Client")
 	}
 
-	result, err := powershell.DirectoryExists(client, remoteDirectoryPath)
+	result, err := powershell.DirectoryExists(ctx, client, c.redactor(nil), remoteDirectoryPath)
 
 	err2 := c.WinRmClientPool.ReturnObject(ctx, winrmClient)
 
@@ -269,7 +367,7 @@ func (c *ClientConfig) DeleteFileOrDirectory(ctx context.Context, remotePath str
 		return fmt.Errorf("failed to cast winrmClient to *winrm.Client")
 	}
 
-	err = powershell.DeleteFileOrDirectory(client, remotePath)
+	err = powershell.DeleteFileOrDirectory(ctx, client, c.redactor(nil), remotePath)
 
 	err2 := c.WinRmClientPool.ReturnObject(ctx, winrmClient)
 
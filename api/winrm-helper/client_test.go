@@ -0,0 +1,195 @@
+package winrm_helper
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+
+	pool "github.com/jolestar/go-commons-pool/v2"
+	"github.com/masterzen/winrm"
+	"github.com/masterzen/winrm/soap"
+	"github.com/taliesins/terraform-provider-hyperv/api"
+	"github.com/taliesins/terraform-provider-hyperv/api/commandresult"
+)
+
+const (
+	envelopeStart = `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd" xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell"><s:Body>`
+	envelopeEnd   = `</s:Body></s:Envelope>`
+)
+
+var (
+	commandPattern   = regexp.MustCompile(`(?s)<!\[CDATA\[(.*?)\]\]>`)
+	commandIdPattern = regexp.MustCompile(`CommandId="([^"]+)"`)
+)
+
+// fakeHost answers the WinRM messages of the provisioner as a Windows host would, running commands with run.
+type fakeHost struct {
+	run func(command string) (stdout string, stderr string, exitCode int)
+
+	mutex    sync.Mutex
+	commands []string
+}
+
+func (h *fakeHost) Transport(*winrm.Endpoint) error {
+	return nil
+}
+
+func (h *fakeHost) Post(_ *winrm.Client, request *soap.SoapMessage) (string, error) {
+	message := request.String()
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	switch {
+	case strings.Contains(message, "transfer/Create<"):
+		return envelopeStart + `<w:SelectorSet><w:Selector Name="ShellId">shell-1</w:Selector></w:SelectorSet>` + envelopeEnd, nil
+	case strings.Contains(message, "shell/Command<"):
+		match := commandPattern.FindStringSubmatch(message)
+		if match == nil {
+			return "", fmt.Errorf("no command in %s", message)
+		}
+		h.commands = append(h.commands, match[1])
+		return fmt.Sprintf(`%s<rsp:CommandResponse><rsp:CommandId>%d</rsp:CommandId></rsp:CommandResponse>%s`, envelopeStart, len(h.commands)-1, envelopeEnd), nil
+	case strings.Contains(message, "shell/Receive<"):
+		match := commandIdPattern.FindStringSubmatch(message)
+		if match == nil {
+			return "", fmt.Errorf("no command id in %s", message)
+		}
+		var id int
+		if _, err := fmt.Sscan(match[1], &id); err != nil || id >= len(h.commands) {
+			return "", fmt.Errorf("unknown command id %s", match[1])
+		}
+		stdout, stderr, exitCode := h.run(h.commands[id])
+		return fmt.Sprintf(`%s<rsp:ReceiveResponse><rsp:Stream Name="stdout" CommandId="%[2]d">%[3]s</rsp:Stream><rsp:Stream Name="stderr" CommandId="%[2]d">%[4]s</rsp:Stream><rsp:CommandState CommandId="%[2]d" State="http://schemas.microsoft.com/wbem/wsman/1/windows/shell/CommandState/Done"><rsp:ExitCode>%[5]d</rsp:ExitCode></rsp:CommandState></rsp:ReceiveResponse>%[6]s`,
+			envelopeStart, id, base64.StdEncoding.EncodeToString([]byte(stdout)), base64.StdEncoding.EncodeToString([]byte(stderr)), exitCode, envelopeEnd), nil
+	default:
+		// Signals and deletes of shells have no content the client reads
+		return envelopeStart + envelopeEnd, nil
+	}
+}
+
+// newFakeHostClient returns a client running its scripts on a fake host, the script sent by the provider writes stdout
+// and stderr and exits with exitCode.
+func newFakeHostClient(t *testing.T, stdout string, stderr string, exitCode int) (*ClientConfig, *fakeHost) {
+	t.Helper()
+
+	host := &fakeHost{
+		run: func(command string) (string, string, int) {
+			switch {
+			case strings.Contains(command, `;&\"`):
+				return stdout, stderr, exitCode
+			case strings.Contains(command, "GetFullPath"):
+				return `C:\Temp\terraform-script.ps1`, "", 0
+			default:
+				return "", "", 0
+			}
+		},
+	}
+
+	ctx := context.Background()
+	clientPool := pool.NewObjectPoolWithDefaultConfig(ctx, pool.NewPooledObjectFactorySimple(func(context.Context) (interface{}, error) {
+		parameters := *winrm.DefaultParameters
+		parameters.TransportDecorator = func() winrm.Transporter { return host }
+		return winrm.NewClientWithParameters(&winrm.Endpoint{Host: "hv", Port: 5985}, "Administrator", "P@ssw0rd", &parameters)
+	}))
+	t.Cleanup(func() { clientPool.Close(ctx) })
+
+	return &ClientConfig{WinRmClientPool: clientPool}, host
+}
+
+func scriptError(errorId string, category string) string {
+	return commandresult.ErrorMarker + fmt.Sprintf(`{"ExceptionType":"Microsoft.HyperV.PowerShell.VirtualizationException","FullyQualifiedErrorId":%q,"Category":%q,"Message":"Hyper-V failed"}`, errorId, category)
+}
+
+func TestRunScriptReportsScriptErrors(t *testing.T) {
+	t.Parallel()
+
+	script := template.Must(template.New("GetVhd").Parse(`Get-VHD -Path {{.Path}}`))
+
+	tests := []struct {
+		name string
+		run  func(client *ClientConfig) error
+		want error
+	}{
+		{
+			name: "fire and forget not found",
+			run: func(client *ClientConfig) error {
+				return client.RunFireAndForgetScript(context.Background(), script, map[string]string{"Path": "C:/disk.vhdx"})
+			},
+			want: api.ErrNotFound,
+		},
+		{
+			name: "with result busy",
+			run: func(client *ClientConfig) error {
+				var result map[string]interface{}
+				return client.RunScriptWithResult(context.Background(), script, map[string]string{"Path": "C:/disk.vhdx"}, &result)
+			},
+			want: api.ErrResourceBusy,
+		},
+	}
+
+	errorIds := map[error][2]string{
+		api.ErrNotFound:     {"ObjectNotFound,Microsoft.Vhd.PowerShell.Cmdlets.GetVHD", "ObjectNotFound"},
+		api.ErrResourceBusy: {"ObjectInUse,Microsoft.Vhd.PowerShell.Cmdlets.GetVHD", "ResourceBusy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			errorId := errorIds[tt.want]
+			client, host := newFakeHostClient(t, scriptError(errorId[0], errorId[1]), "Get-VHD : Hyper-V failed", 1)
+
+			err := tt.run(client)
+
+			var scriptErr *commandresult.ScriptError
+			if !errors.As(err, &scriptErr) || !errors.Is(err, tt.want) {
+				t.Fatalf("expected a script error matching %v, got %v", tt.want, err)
+			}
+			if scriptErr.ExitCode != 1 || scriptErr.Stderr != "Get-VHD : Hyper-V failed" {
+				t.Fatalf("expected the exit code and stderr of the script, got %d and %q", scriptErr.ExitCode, scriptErr.Stderr)
+			}
+
+			host.mutex.Lock()
+			defer host.mutex.Unlock()
+			last := host.commands[len(host.commands)-1]
+			if !strings.Contains(last, "Remove-Item") || !strings.Contains(last, `C:\Temp\terraform-script.ps1`) {
+				t.Fatalf("expected the script to be removed after it failed, last command was %q", last)
+			}
+		})
+	}
+}
+
+func TestRunScriptWithResultSucceedsWithStderr(t *testing.T) {
+	t.Parallel()
+
+	script := template.Must(template.New("GetVhd").Parse(`Get-VHD`))
+	client, _ := newFakeHostClient(t, commandresult.ResultBeginMarker+"\n"+`{"Path":"C:/disk.vhdx"}`+"\n"+commandresult.ResultEndMarker, "WARNING: deprecated", 0)
+
+	var result struct{ Path string }
+	if err := client.RunScriptWithResult(context.Background(), script, nil, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Path != "C:/disk.vhdx" {
+		t.Fatalf("expected the result of the script, got %q", result.Path)
+	}
+}
+
+func TestCloseClosesClientPool(t *testing.T) {
+	t.Parallel()
+
+	client, _ := newFakeHostClient(t, "", "", 0)
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !client.WinRmClientPool.IsClosed() {
+		t.Fatal("expected the pool of WinRM clients to be closed")
+	}
+}
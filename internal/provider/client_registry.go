@@ -0,0 +1,273 @@
+//nolint:forcetypeassert // Terraform schema enforces concrete types for ResourceData values.
+package provider
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/taliesins/terraform-provider-hyperv/api"
+)
+
+// hostSchema is the host of resources and data sources, which manages them on another Hyper-V host than the
+// provider's. The endpoint the object is managed on is stored, so that it stays on that host when the host of the
+// provider changes. Resources are replaced when their endpoint changes, see customizeHostDiff, as they cannot be moved
+// to another host.
+func hostSchema(forceNew bool) *schema.Schema {
+	description := "The Hyper-V host to manage this object on instead of the `host` of the provider, as `<host>` or `<host>:<port>`. The credentials and transport settings of the provider are used, and its `port`, or `ssh_port` over SSH, unless a port is given. The endpoint the object is managed on, `<host>:<port>`, is exported."
+	if forceNew {
+		description += " Changing the endpoint, including through the `host` or port of the provider when this is not set, replaces the object."
+	}
+
+	return &schema.Schema{
+		Type:             schema.TypeString,
+		Optional:         true,
+		Computed:         true,
+		ValidateDiagFunc: HostAndPort(),
+		DiffSuppressFunc: suppressEquivalentHost,
+		Description:      description,
+	}
+}
+
+// suppressEquivalentHost suppresses the difference between a host and the endpoint stored for it, such as h1 and
+// h1:5985. The port of the provider a host without a port stands for is compared by customizeHostDiff.
+func suppressEquivalentHost(_ string, oldValue string, newValue string, _ *schema.ResourceData) bool {
+	if newValue == "" {
+		return false
+	}
+
+	oldHost, oldPort, err := splitHost(oldValue)
+	if err != nil {
+		return false
+	}
+	newHost, newPort, err := splitHost(newValue)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(oldHost, newHost) && (newPort == 0 || newPort == oldPort)
+}
+
+// customizeHostDiff plans the endpoint of a resource, resolved from its host attribute and the configuration of the
+// provider, and replaces the resource when it differs from the endpoint the resource is managed on.
+func customizeHostDiff(_ context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	registry, ok := meta.(*clientRegistry)
+	if !ok || registry.config.Transport == TransportLocal {
+		return nil
+	}
+
+	configured := ""
+	if config := d.GetRawConfig(); config.IsKnown() && !config.IsNull() {
+		value := config.GetAttr("host")
+		if !value.IsKnown() {
+			// The host is only known on apply, the resource is replaced if it changes
+			if d.Id() != "" && d.HasChange("host") {
+				return d.ForceNew("host")
+			}
+			return nil
+		}
+		if !value.IsNull() {
+			configured = value.AsString()
+		}
+	}
+
+	endpoint, err := registry.endpoint(configured)
+	if err != nil {
+		return err
+	}
+
+	if d.Id() == "" {
+		if configured == "" {
+			return d.SetNew("host", endpoint)
+		}
+		return nil
+	}
+
+	current, _ := d.GetChange("host")
+	previous := current.(string)
+	if previous == "" {
+		// Objects in the state of earlier versions have no endpoint, they are on the host of the provider
+		previous = registry.config.endpoint()
+	}
+	if strings.EqualFold(previous, endpoint) {
+		return nil
+	}
+
+	// The planned value is the configured one, even when only the port of the provider changed
+	planned := endpoint
+	if configured != "" {
+		planned = configured
+	}
+	if err := d.SetNew("host", planned); err != nil {
+		return err
+	}
+
+	return d.ForceNew("host")
+}
+
+// splitHost returns the host and port of a host attribute, port is 0 when it is not given.
+func splitHost(value string) (host string, port int, err error) {
+	host = value
+	if h, p, err := net.SplitHostPort(value); err == nil {
+		host = h
+		port, err = strconv.Atoi(p)
+		if err != nil {
+			return "", 0, fmt.Errorf("couldn't convert \"%s\" to a port number", p)
+		}
+	}
+	if host == "" {
+		return "", 0, fmt.Errorf("couldn't convert \"%s\" to a host", value)
+	}
+
+	return host, port, nil
+}
+
+// clientFor returns the client of the host d is managed on: the client of the provider, unless the host attribute
+// of d names another host. The endpoint of the host is stored in the host attribute.
+func clientFor(meta interface{}, d *schema.ResourceData) (api.Client, error) {
+	value := d.Get("host").(string)
+
+	registry, ok := meta.(*clientRegistry)
+	if !ok {
+		if value != "" {
+			return nil, fmt.Errorf("the host attribute is not supported by %T", meta)
+		}
+		return meta.(api.Client), nil
+	}
+
+	if value == "" {
+		if registry.config.Transport != TransportLocal {
+			if err := d.Set("host", registry.config.endpoint()); err != nil {
+				return nil, err
+			}
+		}
+		return registry, nil
+	}
+
+	host, port, err := splitHost(value)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := registry.hostConfig(host, port)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.Set("host", config.endpoint()); err != nil {
+		return nil, err
+	}
+
+	return registry.client(config)
+}
+
+// importStateWithHost imports an object whose ID is suffixed with @<host> or @<host>:<port> on that host, and any
+// other ID on the host of the provider.
+func importStateWithHost(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	id := d.Id()
+	separator := strings.LastIndex(id, "@")
+	if separator < 0 {
+		return schema.ImportStatePassthroughContext(ctx, d, meta)
+	}
+
+	host := id[separator+1:]
+	if _, _, err := splitHost(host); err != nil || separator == 0 {
+		return nil, fmt.Errorf("import ID \"%s\" must be <id>@<host> or <id>@<host>:<port>", id)
+	}
+
+	d.SetId(id[:separator])
+	if err := d.Set("host", host); err != nil {
+		return nil, err
+	}
+
+	return []*schema.ResourceData{d}, nil
+}
+
+// clientRegistry is the client of the provider's host, which also creates and keeps a client for every other host
+// named by the host attribute of a resource or data source. The clients share the configuration of the provider, only
+// their endpoint differs.
+type clientRegistry struct {
+	api.Client
+
+	config Config
+
+	mu      sync.Mutex
+	clients map[string]api.Client
+}
+
+func newClientRegistry(config Config, client api.Client) *clientRegistry {
+	return &clientRegistry{
+		Client: client,
+		config: config,
+		clients: map[string]api.Client{
+			config.endpoint(): client,
+		},
+	}
+}
+
+// hostConfig returns the configuration of the client of host. A port of 0 is the port of the provider.
+func (r *clientRegistry) hostConfig(host string, port int) (Config, error) {
+	config := r.config
+	if config.Transport == TransportLocal {
+		return Config{}, fmt.Errorf("the host attribute of resources and data sources requires the `winrm` or `ssh` transport, got %q", config.Transport)
+	}
+
+	if config.SSH {
+		config.SSHHost = host
+		if port != 0 {
+			config.SSHPort = port
+		}
+	} else {
+		config.Host = host
+		if port != 0 {
+			config.Port = port
+		}
+	}
+
+	return config, nil
+}
+
+// endpoint returns the endpoint of a host attribute, the endpoint of the provider when it is empty.
+func (r *clientRegistry) endpoint(value string) (string, error) {
+	if value == "" {
+		return r.config.endpoint(), nil
+	}
+
+	host, port, err := splitHost(value)
+	if err != nil {
+		return "", err
+	}
+
+	config, err := r.hostConfig(host, port)
+	if err != nil {
+		return "", err
+	}
+
+	return config.endpoint(), nil
+}
+
+// client returns the client of the configuration of a host, see hostConfig, creating it on first use.
+func (r *clientRegistry) client(config Config) (api.Client, error) {
+	endpoint := config.endpoint()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if client, ok := r.clients[endpoint]; ok {
+		return client, nil
+	}
+
+	log.Printf("[INFO][hyperv] Creating HyperV API client for %s", endpoint)
+
+	client, err := config.Client()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client for %s: %w", endpoint, err)
+	}
+	r.clients[endpoint] = client
+
+	return client, nil
+}
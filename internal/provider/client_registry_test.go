@@ -0,0 +1,222 @@
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"github.com/taliesins/terraform-provider-hyperv/api/fake"
+)
+
+func TestClientRegistry(t *testing.T) {
+	t.Parallel()
+
+	providerClient := fake.New()
+	registry := newClientRegistry(Config{
+		Timeout:     "30s",
+		SSH:         true,
+		SSHHost:     "hv01",
+		SSHPort:     22,
+		SSHUser:     "Administrator",
+		SSHPassword: "secret",
+	}, providerClient)
+
+	// Clients are created without connecting, so the hosts do not have to exist
+	client := func(host string) interface{} {
+		t.Helper()
+
+		d := schema.TestResourceDataRaw(t, resourceHyperVNetworkSwitch().Schema, map[string]interface{}{
+			"name": "switch",
+			"host": host,
+		})
+
+		c, err := clientFor(registry, d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	if client("") != registry {
+		t.Fatal("expected objects without a host to use the client of the provider")
+	}
+	if client("hv01") != providerClient || client("hv01:22") != providerClient {
+		t.Fatal("expected the host of the provider to use the client of the provider")
+	}
+
+	hv02 := client("hv02")
+	if hv02 == providerClient {
+		t.Fatal("expected another host to use another client")
+	}
+	if client("hv02:22") != hv02 {
+		t.Fatal("expected the client of a host to be kept")
+	}
+	if client("hv02:2222") == hv02 {
+		t.Fatal("expected another port to use another client")
+	}
+}
+
+func TestClientForWithoutRegistry(t *testing.T) {
+	t.Parallel()
+
+	d := schema.TestResourceDataRaw(t, resourceHyperVNetworkSwitch().Schema, map[string]interface{}{
+		"name": "switch",
+		"host": "hv02",
+	})
+
+	if _, err := clientFor(fake.New(), d); err == nil {
+		t.Fatal("expected an error for a host without a client registry")
+	}
+}
+
+func TestImportStateWithHost(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		id       string
+		wantID   string
+		wantHost string
+		wantErr  bool
+	}{
+		{id: "switch", wantID: "switch"},
+		{id: "switch@hv02", wantID: "switch", wantHost: "hv02"},
+		{id: "switch@hv02:5986", wantID: "switch", wantHost: "hv02:5986"},
+		{id: "switch@[fe80::1]:22", wantID: "switch", wantHost: "[fe80::1]:22"},
+		{id: `C:\VMs\a@b.vhdx@hv02`, wantID: `C:\VMs\a@b.vhdx`, wantHost: "hv02"},
+		{id: "switch@", wantErr: true},
+		{id: "switch@hv02:winrm", wantErr: true},
+		{id: "@hv02", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			t.Parallel()
+
+			d := resourceHyperVNetworkSwitch().Data(nil)
+			d.SetId(tt.id)
+
+			_, err := importStateWithHost(context.Background(), d, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %t, got %v", tt.wantErr, err)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if host := d.Get("host").(string); d.Id() != tt.wantID || host != tt.wantHost {
+				t.Fatalf("expected %s on %q, got %s on %q", tt.wantID, tt.wantHost, d.Id(), host)
+			}
+		})
+	}
+}
+
+func TestClientForStoresEndpoint(t *testing.T) {
+	t.Parallel()
+
+	registry := newClientRegistry(Config{
+		Timeout:     "30s",
+		SSH:         true,
+		SSHHost:     "hv01",
+		SSHPort:     22,
+		SSHUser:     "Administrator",
+		SSHPassword: "secret",
+	}, fake.New())
+
+	for host, want := range map[string]string{"": "hv01:22", "hv01": "hv01:22", "hv02": "hv02:22", "hv02:2222": "hv02:2222"} {
+		d := schema.TestResourceDataRaw(t, resourceHyperVNetworkSwitch().Schema, map[string]interface{}{
+			"name": "switch",
+			"host": host,
+		})
+
+		if _, err := clientFor(registry, d); err != nil {
+			t.Fatal(err)
+		}
+		if got := d.Get("host").(string); got != want {
+			t.Fatalf("expected host %q to be stored as %q, got %q", host, want, got)
+		}
+	}
+}
+
+func TestCustomizeHostDiff(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		providerHost string
+		providerPort int
+		state        string
+		config       string
+		wantHost     string
+		wantNew      bool
+	}{
+		{name: "create on the provider host", providerHost: "hv01", config: "", wantHost: "hv01:22"},
+		{name: "provider host", providerHost: "hv01", state: "hv01:22"},
+		{name: "same host without port", providerHost: "hv01", state: "hv01:22", config: "hv01"},
+		{name: "same host with port", providerHost: "hv01", state: "hv01:22", config: "hv01:22"},
+		{name: "same host in another case", providerHost: "hv01", state: "hv01:22", config: "HV01"},
+		{name: "state without endpoint", providerHost: "hv01", state: ""},
+		{name: "provider host changed", providerHost: "hv03", state: "hv01:22", wantHost: "hv03:22", wantNew: true},
+		{name: "host changed", providerHost: "hv01", state: "hv01:22", config: "hv02", wantHost: "hv02", wantNew: true},
+		{name: "port changed", providerHost: "hv01", state: "hv01:22", config: "hv01:2222", wantHost: "hv01:2222", wantNew: true},
+		{name: "provider port changed", providerHost: "hv01", providerPort: 2222, state: "hv01:22", config: "hv01", wantHost: "hv01", wantNew: true},
+		{name: "host set on state without endpoint", providerHost: "hv01", state: "", config: "hv02", wantHost: "hv02", wantNew: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			providerPort := 22
+			if tt.providerPort != 0 {
+				providerPort = tt.providerPort
+			}
+			registry := newClientRegistry(Config{
+				Timeout:     "30s",
+				SSH:         true,
+				SSHHost:     tt.providerHost,
+				SSHPort:     providerPort,
+				SSHUser:     "Administrator",
+				SSHPassword: "secret",
+			}, fake.New())
+
+			resource := resourceHyperVNetworkSwitch()
+			block := schema.InternalMap(resource.Schema).CoreConfigSchema()
+			attributes := map[string]cty.Value{"name": cty.StringVal("switch")}
+			if tt.config != "" {
+				attributes["host"] = cty.StringVal(tt.config)
+			}
+			value, err := block.CoerceValue(cty.ObjectVal(attributes))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			state := &terraform.InstanceState{RawConfig: value}
+			if tt.name != "create on the provider host" {
+				state.ID = "switch"
+				state.Attributes = map[string]string{"id": "switch", "name": "switch", "host": tt.state}
+			}
+
+			diff, err := resource.SimpleDiff(context.Background(), state, terraform.NewResourceConfigShimmed(value, block), registry)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var host *terraform.ResourceAttrDiff
+			if diff != nil {
+				host = diff.Attributes["host"]
+			}
+			if tt.wantHost == "" {
+				if host != nil && host.Old != host.New {
+					t.Fatalf("expected no change of host, got %q to %q", host.Old, host.New)
+				}
+				return
+			}
+
+			if host == nil || host.New != tt.wantHost || host.RequiresNew != tt.wantNew {
+				t.Fatalf("expected host %q replacing the resource %t, got %+v", tt.wantHost, tt.wantNew, host)
+			}
+		})
+	}
+}
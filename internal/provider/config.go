@@ -2,20 +2,34 @@ package provider
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"net/url"
+	"os/exec"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/taliesins/terraform-provider-hyperv/api"
+	"github.com/taliesins/terraform-provider-hyperv/api/cassette"
+	"github.com/taliesins/terraform-provider-hyperv/api/cim"
+	credential_helper "github.com/taliesins/terraform-provider-hyperv/api/credential-helper"
 	hyperv "github.com/taliesins/terraform-provider-hyperv/api/hyperv"
+	local_helper "github.com/taliesins/terraform-provider-hyperv/api/local-helper"
+	"github.com/taliesins/terraform-provider-hyperv/api/proxy"
+	pssession_helper "github.com/taliesins/terraform-provider-hyperv/api/pssession-helper"
+	"github.com/taliesins/terraform-provider-hyperv/api/redact"
+	"github.com/taliesins/terraform-provider-hyperv/api/retry"
 	ssh_helper "github.com/taliesins/terraform-provider-hyperv/api/ssh-helper"
 
 	"github.com/dylanmei/iso8601"
 	pool "github.com/jolestar/go-commons-pool/v2"
 	winrm "github.com/masterzen/winrm"
+	winrm_encryption "github.com/taliesins/terraform-provider-hyperv/api/winrm-encryption"
 	winrm_helper "github.com/taliesins/terraform-provider-hyperv/api/winrm-helper"
 )
 
@@ -37,6 +51,10 @@ type Config struct {
 
 	NTLM bool
 
+	// MessageEncryption is one of the MessageEncryptions, messages are encrypted with the NTLM or Kerberos
+	// authentication
+	MessageEncryption string
+
 	TLSServerName string
 	CACert        []byte
 	Cert          []byte
@@ -53,16 +71,264 @@ type Config struct {
 	SSHPrivateKeyPath string
 	SSHHost           string
 	SSHPort           int
+
+	SSHKnownHostsPath      string
+	SSHKnownHosts          string
+	SSHHostKeyFingerprints []string
+	SSHTrustOnFirstUse     bool
+
+	SSHPrivateKeyPassphrase string
+	SSHCertificate          string
+	SSHCertificatePath      string
+	SSHUseAgent             bool
+	SSHBastions             []SSHBastionConfig
+	SSHPowerShell           string
+	SSHPersistentSession    bool
+
+	// Transport is one of the Transports, LocalPowerShell is used by the local transport
+	Transport       string
+	LocalPowerShell string
+
+	// Backend is one of the Backends, the cim backend requires the WinRM transport
+	Backend string
+
+	// CassettePath and CassetteMode record or replay HyperV api calls, see the cassette package
+	CassettePath string
+	CassetteMode string
+
+	// CredentialHelper is an executable returning the password or private key of the user, see the
+	// credential_helper package
+	CredentialHelper string
+
+	// ProxyURL is the HTTP or SOCKS5 proxy connections to the Hyper-V host go through, the proxy of the environment
+	// is used when it is empty, see the proxy package
+	ProxyURL string
+
+	// Retry is the policy for HyperV api calls failing with a transient error, calls are not retried when it
+	// allows a single attempt
+	Retry retry.Policy
+}
+
+const (
+	TransportWinRM = "winrm"
+	TransportSSH   = "ssh"
+	TransportLocal = "local"
+)
+
+// Transports lists the transports HyperV api calls can be made over
+var Transports = map[string]string{
+	TransportWinRM: "WinRM",
+	TransportSSH:   "SSH",
+	TransportLocal: "PowerShell on the local machine",
+}
+
+const (
+	BackendPowerShell = "powershell"
+	BackendCIM        = "cim"
+)
+
+// Backends lists the ways HyperV api calls are served
+var Backends = map[string]string{
+	BackendPowerShell: "PowerShell scripts using the Hyper-V module",
+	BackendCIM:        "WMI over WS-Management for reads, PowerShell scripts otherwise",
+}
+
+const (
+	MessageEncryptionAuto   = "auto"
+	MessageEncryptionAlways = "always"
+	MessageEncryptionNever  = "never"
+)
+
+// MessageEncryptions lists when WinRM messages are encrypted with the security context of the NTLM or Kerberos
+// authentication
+var MessageEncryptions = map[string]string{
+	MessageEncryptionAuto:   "over HTTP when authenticating with NTLM or Kerberos",
+	MessageEncryptionAlways: "over HTTP and HTTPS, which requires NTLM or Kerberos",
+	MessageEncryptionNever:  "never, messages over HTTP are sent in plaintext",
+}
+
+// encryptMessages reports whether WinRM messages are encrypted, it fails when they have to be but the
+// authentication cannot encrypt them
+func (c *Config) encryptMessages() (bool, error) {
+	canEncrypt := c.KrbRealm != "" || c.NTLM
+
+	switch c.MessageEncryption {
+	case MessageEncryptionAlways:
+		if !canEncrypt {
+			return false, fmt.Errorf("`message_encryption = %q` requires `use_ntlm = true` or `kerberos_realm`", MessageEncryptionAlways)
+		}
+		return true, nil
+	case MessageEncryptionNever:
+		return false, nil
+	default:
+		return canEncrypt && !c.HTTPS, nil
+	}
+}
+
+// validate checks the configuration without connecting to the Hyper-V host: the timeout, that the credentials of the
+// transport are complete, unless a credential helper returns them, and that they can encrypt WinRM messages when
+// required. The host is only connected to by the first HyperV api call, so that the provider can be configured while
+// it is unreachable or not created yet.
+func (c *Config) validate() error {
+	if _, err := time.ParseDuration(c.Timeout); err != nil {
+		return fmt.Errorf("couldn't parse timeout duration \"%s\": %w", c.Timeout, err)
+	}
+
+	if c.CassetteMode == cassette.ModeReplay {
+		return nil
+	}
+
+	if c.CredentialHelper != "" {
+		if c.Transport == TransportLocal {
+			return fmt.Errorf("`credential_helper` requires the `winrm` or `ssh` transport, got %q", c.Transport)
+		}
+		if c.Backend == BackendCIM {
+			return fmt.Errorf("`credential_helper` is not supported with `backend = %q`", BackendCIM)
+		}
+		if _, err := exec.LookPath(c.CredentialHelper); err != nil {
+			return fmt.Errorf("credential helper not found (check `credential_helper`): %w", err)
+		}
+	}
+	hasHelper := c.CredentialHelper != ""
+
+	if c.ProxyURL != "" {
+		if c.Transport == TransportLocal {
+			return fmt.Errorf("`proxy_url` requires the `winrm` or `ssh` transport, got %q", c.Transport)
+		}
+		if _, err := proxy.Parse(c.ProxyURL); err != nil {
+			return fmt.Errorf("%w (check `proxy_url`)", err)
+		}
+	}
+
+	switch {
+	case c.Transport == TransportLocal:
+		if _, err := exec.LookPath(c.LocalPowerShell); err != nil {
+			return fmt.Errorf("PowerShell executable for the local transport not found (check `local_powershell`): %w", err)
+		}
+	case c.SSH:
+		hasKey := c.SSHPrivateKey != "" || c.SSHPrivateKeyPath != "" || c.SSHUseAgent || hasHelper
+		if c.SSHPassword == "" && !hasKey {
+			return fmt.Errorf("no SSH credentials configured, set `ssh_password` or `password`, `ssh_private_key`, `ssh_private_key_path`, `ssh_use_agent` or `credential_helper`")
+		}
+		if (c.SSHCertificate != "" || c.SSHCertificatePath != "") && !hasKey {
+			return fmt.Errorf("an SSH certificate requires `ssh_private_key`, `ssh_private_key_path` or `ssh_use_agent` to sign with")
+		}
+	default:
+		if (c.Cert == nil) != (c.Key == nil) {
+			return fmt.Errorf("`cert_path` and `key_path` must be set together")
+		}
+		if c.Password == "" && c.Cert == nil && c.KrbCCache == "" && !hasHelper {
+			return fmt.Errorf("no WinRM credentials configured, set `password`, `cert_path` and `key_path`, `kerberos_credential_cache` or `credential_helper`")
+		}
+		encrypt, err := c.encryptMessages()
+		if err != nil {
+			return err
+		}
+		if c.ProxyURL != "" && c.KrbRealm != "" && !encrypt {
+			return fmt.Errorf("`proxy_url` with Kerberos requires encrypted messages, set `message_encryption = %q`, or set the proxy with the HTTPS_PROXY or HTTP_PROXY environment variable instead", MessageEncryptionAlways)
+		}
+	}
+
+	return nil
 }
 
 // Client() returns a new client for configuring hyperv.
 func (c *Config) Client() (comm api.Client, err error) {
+	if c.CassetteMode == cassette.ModeReplay {
+		return c.getReplayClient()
+	}
+	if c.Transport == TransportLocal {
+		return c.getLocalClient()
+	}
 	if c.SSH {
 		return c.getSSHClient()
 	}
 	return c.getWinRMClient()
 }
 
+// endpoint returns the host and port the configuration connects to.
+func (c *Config) endpoint() string {
+	if c.SSH {
+		return net.JoinHostPort(c.SSHHost, strconv.Itoa(c.SSHPort))
+	}
+
+	return net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+}
+
+// redactor masks the passwords and key material of the configuration in logged scripts and commands and in errors.
+func (c *Config) redactor() *redact.Redactor {
+	secrets := []string{c.Password, string(c.Key), c.SSHPassword, c.SSHPrivateKey, c.SSHPrivateKeyPassphrase}
+	for _, bastion := range c.SSHBastions {
+		secrets = append(secrets, bastion.Password, bastion.PrivateKey, bastion.PrivateKeyPassphrase)
+	}
+
+	if proxyURL, err := url.Parse(c.ProxyURL); err == nil && proxyURL.User != nil {
+		if password, ok := proxyURL.User.Password(); ok {
+			secrets = append(secrets, password)
+		}
+	}
+
+	return redact.New(secrets...)
+}
+
+// proxyName describes the proxy connections go through in logs, without its password
+func (c *Config) proxyName() string {
+	if c.ProxyURL == "" {
+		return "from the environment"
+	}
+
+	proxyURL, err := proxy.Parse(c.ProxyURL)
+	if err != nil {
+		return "invalid"
+	}
+
+	return proxyURL.Redacted()
+}
+
+// SSHBastionConfig describes a jump host used to reach the SSH host
+type SSHBastionConfig struct {
+	Host                 string
+	Port                 int
+	User                 string
+	Password             string
+	PrivateKey           string
+	PrivateKeyPath       string
+	PrivateKeyPassphrase string
+	Certificate          string
+	CertificatePath      string
+	UseAgent             bool
+	HostKeyFingerprints  []string
+}
+
+// clientConfig builds the ssh_helper configuration of the bastion. The user defaults to the user of
+// the target connection, and host key verification follows the target connection's known hosts settings.
+func (b SSHBastionConfig) clientConfig(target *ssh_helper.ClientConfig) *ssh_helper.ClientConfig {
+	user := b.User
+	if user == "" {
+		user = target.User
+	}
+
+	return &ssh_helper.ClientConfig{
+		Host:                 b.Host,
+		Port:                 b.Port,
+		User:                 user,
+		Password:             b.Password,
+		PrivateKey:           b.PrivateKey,
+		PrivateKeyPath:       b.PrivateKeyPath,
+		PrivateKeyPassphrase: b.PrivateKeyPassphrase,
+		Certificate:          b.Certificate,
+		CertificatePath:      b.CertificatePath,
+		UseAgent:             b.UseAgent,
+		Timeout:              target.Timeout,
+		Dial:                 target.Dial,
+
+		KnownHostsPath:      target.KnownHostsPath,
+		KnownHosts:          target.KnownHosts,
+		HostKeyFingerprints: b.HostKeyFingerprints,
+		TrustOnFirstUse:     target.TrustOnFirstUse,
+	}
+}
+
 // getSSHClient creates an SSH-based client
 func (c *Config) getSSHClient() (api.Client, error) {
 	log.Printf("[INFO][hyperv] HyperV SSH Client configured for HyperV API operations using:\n"+
@@ -72,6 +338,19 @@ func (c *Config) getSSHClient() (api.Client, error) {
 		"  SSH Password: %t\n"+
 		"  SSH PrivateKey: %t\n"+
 		"  SSH PrivateKeyPath: %s\n"+
+		"  SSH KnownHostsPath: %s\n"+
+		"  SSH KnownHosts: %t\n"+
+		"  SSH HostKeyFingerprints: %d\n"+
+		"  SSH TrustOnFirstUse: %t\n"+
+		"  SSH PrivateKeyPassphrase: %t\n"+
+		"  SSH Certificate: %t\n"+
+		"  SSH CertificatePath: %s\n"+
+		"  SSH UseAgent: %t\n"+
+		"  SSH Bastions: %d\n"+
+		"  SSH PowerShell: %s\n"+
+		"  SSH PersistentSession: %t\n"+
+		"  CredentialHelper: %s\n"+
+		"  Proxy: %s\n"+
 		"  Timeout: %s",
 		c.SSHHost,
 		c.SSHPort,
@@ -79,14 +358,68 @@ func (c *Config) getSSHClient() (api.Client, error) {
 		c.SSHPassword != "",
 		c.SSHPrivateKey != "",
 		c.SSHPrivateKeyPath,
+		c.SSHKnownHostsPath,
+		c.SSHKnownHosts != "",
+		len(c.SSHHostKeyFingerprints),
+		c.SSHTrustOnFirstUse,
+		c.SSHPrivateKeyPassphrase != "",
+		c.SSHCertificate != "",
+		c.SSHCertificatePath,
+		c.SSHUseAgent,
+		len(c.SSHBastions),
+		c.SSHPowerShell,
+		c.SSHPersistentSession,
+		c.CredentialHelper,
+		c.proxyName(),
 		c.Timeout,
 	)
 
+	// The SSH host is connected to by the first HyperV api call, it may not exist yet when the provider is configured
+	helper := c.credentialHelper()
+	scriptRunner := c.retryScriptRunner(hyperv.NewLazyScriptRunner(func(ctx context.Context) (hyperv.ScriptRunner, time.Time, error) {
+		config, expiresAt, err := c.withCredentials(ctx, helper)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		sshConfig, err := config.sshClientConfig()
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		scriptRunner, err := config.connectSSH(ctx, sshConfig)
+		if err != nil {
+			// The connections opened to validate the host are not used
+			_ = sshConfig.Close()
+			return nil, time.Time{}, err
+		}
+
+		return scriptRunner, expiresAt, nil
+	}))
+
+	// Use the SSH client with the hyperv API layer
+	hyperVProvider, err := hyperv.New(&hyperv.ClientConfig{
+		ScriptRunner: scriptRunner,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return hyperVProvider.Client, nil
+}
+
+// sshClientConfig returns the ssh_helper configuration of the SSH host, with a pool of connections to it
+func (c *Config) sshClientConfig() (*ssh_helper.ClientConfig, error) {
 	timeoutDuration, err := time.ParseDuration(c.Timeout)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't parse timeout duration \"%s\": %w", c.Timeout, err)
 	}
 
+	connectProxy, err := proxy.New(c.ProxyURL)
+	if err != nil {
+		return nil, err
+	}
+
 	sshConfig := &ssh_helper.ClientConfig{
 		Host:           c.SSHHost,
 		Port:           c.SSHPort,
@@ -95,12 +428,58 @@ func (c *Config) getSSHClient() (api.Client, error) {
 		PrivateKey:     c.SSHPrivateKey,
 		PrivateKeyPath: c.SSHPrivateKeyPath,
 		Timeout:        timeoutDuration,
+		KeepAlive:      ssh_helper.DefaultKeepAlive,
 		Vars:           "",
 		IsWindows:      true, // Hyper-V hosts are always Windows
+		PowerShell:     c.SSHPowerShell,
+
+		KnownHostsPath:      c.SSHKnownHostsPath,
+		KnownHosts:          c.SSHKnownHosts,
+		HostKeyFingerprints: c.SSHHostKeyFingerprints,
+		TrustOnFirstUse:     c.SSHTrustOnFirstUse,
+
+		PrivateKeyPassphrase: c.SSHPrivateKeyPassphrase,
+		Certificate:          c.SSHCertificate,
+		CertificatePath:      c.SSHCertificatePath,
+		UseAgent:             c.SSHUseAgent,
+
+		Dial:     connectProxy.DialContext,
+		Redactor: c.redactor(),
 	}
 
-	if err := sshConfig.ValidatePowerShellShell(context.Background()); err != nil {
-		return nil, fmt.Errorf("PowerShell shell validation for OpenSSH failed (ensure PowerShell is configured as the default shell on the target host): %w", err)
+	for _, bastion := range c.SSHBastions {
+		bastionConfig := bastion.clientConfig(sshConfig)
+		bastionConfig.Bastion = sshConfig.Bastion
+		sshConfig.Bastion = bastionConfig
+	}
+	sshConfig.ClientPool = ssh_helper.NewClientPool(context.Background(), sshConfig)
+
+	return sshConfig, nil
+}
+
+// connectSSH validates the PowerShell of the SSH host and returns the script runner of the SSH transport, wrapped to
+// record its interactions when recording is enabled.
+func (c *Config) connectSSH(ctx context.Context, sshConfig *ssh_helper.ClientConfig) (hyperv.ScriptRunner, error) {
+	powerShellInfo, err := sshConfig.ValidatePowerShell(ctx)
+	if err != nil {
+		var hostKeyErr *ssh_helper.HostKeyError
+		if errors.As(err, &hostKeyErr) {
+			return nil, fmt.Errorf("SSH host key verification failed for %s: %s. The server presented a %s key with fingerprint %s; add it to `ssh_known_hosts_path` or `ssh_known_hosts`, pin it with `ssh_host_key_fingerprints`, or enable `ssh_trust_on_first_use`: %w", hostKeyErr.Host, hostKeyErr.Reason, hostKeyErr.KeyType, hostKeyErr.Fingerprint, err)
+		}
+
+		var authErr *ssh_helper.AuthenticationError
+		if errors.As(err, &authErr) {
+			return nil, fmt.Errorf("%w. Check `ssh_user` and the configured credentials: `ssh_password`, `ssh_private_key` or `ssh_private_key_path` (with `ssh_private_key_passphrase` for encrypted keys), `ssh_certificate` or `ssh_certificate_path`, or `ssh_use_agent`", err)
+		}
+
+		return nil, fmt.Errorf("PowerShell validation for OpenSSH failed (ensure `ssh_powershell` names a PowerShell installed on the target host): %w", err)
+	}
+
+	log.Printf("[INFO][hyperv] HyperV SSH host runs scripts with %s", powerShellInfo)
+
+	if powerShellInfo.DefaultShell == ssh_helper.DefaultShellCmd {
+		// cmd limits command lines to 8191 characters, larger scripts are uploaded and run from a file
+		sshConfig.MaxCommandLength = ssh_helper.CmdMaxCommandLength
 	}
 
 	sshProvider, err := ssh_helper.New(sshConfig)
@@ -108,9 +487,56 @@ func (c *Config) getSSHClient() (api.Client, error) {
 		return nil, fmt.Errorf("failed to create SSH client: %w", err)
 	}
 
-	// Use the SSH client with the hyperv API layer
+	var scriptRunner hyperv.ScriptRunner = sshProvider.Client
+	if c.SSHPersistentSession {
+		// Scripts run in long-lived PowerShell processes, files are still transferred over SFTP
+		sessionProvider, err := pssession_helper.New(&pssession_helper.ClientConfig{
+			Start:       sshConfig.StartPowerShell,
+			Files:       sshProvider.Client,
+			MaxSessions: ssh_helper.DefaultPoolMaxTotal,
+			Redactor:    sshConfig.Redactor,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create persistent PowerShell session client: %w", err)
+		}
+		scriptRunner = sessionProvider.Client
+	}
+
+	recordedScriptRunner, err := c.recordScriptRunner(scriptRunner)
+	if err != nil {
+		if closer, ok := scriptRunner.(io.Closer); ok {
+			_ = closer.Close()
+		}
+		return nil, err
+	}
+
+	return recordedScriptRunner, nil
+}
+
+// getLocalClient creates a client running PowerShell on the local machine
+func (c *Config) getLocalClient() (api.Client, error) {
+	log.Printf("[INFO][hyperv] HyperV Local Client configured for HyperV API operations using:\n"+
+		"  PowerShell: %s",
+		c.LocalPowerShell,
+	)
+
+	localProvider, err := local_helper.New(&local_helper.ClientConfig{
+		PowerShell: c.LocalPowerShell,
+		Vars:       "",
+		Redactor:   c.redactor(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create local client: %w", err)
+	}
+
+	scriptRunner, err := c.recordScriptRunner(localProvider.Client)
+	if err != nil {
+		return nil, err
+	}
+	scriptRunner = c.retryScriptRunner(scriptRunner)
+
 	hyperVProvider, err := hyperv.New(&hyperv.ClientConfig{
-		ScriptRunner: sshProvider.Client,
+		ScriptRunner: scriptRunner,
 	})
 	if err != nil {
 		return nil, err
@@ -129,6 +555,7 @@ func (c *Config) getWinRMClient() (api.Client, error) {
 		"  HTTPS: %t\n"+
 		"  Insecure: %t\n"+
 		"  NTLM: %t\n"+
+		"  MessageEncryption: %s\n"+
 		"  KrbRealm: %s\n"+
 		"  KrbSpn: %s\n"+
 		"  KrbConfig: %s\n"+
@@ -138,6 +565,8 @@ func (c *Config) getWinRMClient() (api.Client, error) {
 		"  Cert: %t\n"+
 		"  Key: %t\n"+
 		"  ScriptPath: %s\n"+
+		"  CredentialHelper: %s\n"+
+		"  Proxy: %s\n"+
 		"  Timeout: %s",
 		c.Host,
 		c.Port,
@@ -146,6 +575,7 @@ func (c *Config) getWinRMClient() (api.Client, error) {
 		c.HTTPS,
 		c.Insecure,
 		c.NTLM,
+		c.MessageEncryption,
 		c.KrbRealm,
 		c.KrbSpn,
 		c.KrbConfig,
@@ -155,6 +585,8 @@ func (c *Config) getWinRMClient() (api.Client, error) {
 		c.Cert != nil,
 		c.Key != nil,
 		c.ScriptPath,
+		c.CredentialHelper,
+		c.proxyName(),
 		c.Timeout,
 	)
 
@@ -163,20 +595,97 @@ func (c *Config) getWinRMClient() (api.Client, error) {
 		return nil, err
 	}
 
+	if c.Backend == BackendCIM {
+		return c.getCIMClient(hyperVProvider.Client)
+	}
+
 	return hyperVProvider.Client, nil
 }
 
+// getCIMClient creates a client reading from the WMI namespace of Hyper-V over WS-Management, which passes the
+// operations it does not implement to client
+func (c *Config) getCIMClient(client api.Client) (api.Client, error) {
+	log.Printf("[INFO][hyperv] Reading HyperV API objects over WS-Management")
+
+	endpoint, params, err := getWinrmEndpoint(c)
+	if err != nil {
+		return nil, err
+	}
+
+	wsman, err := cim.NewWSMan(endpoint, c.User, c.Password, params)
+	if err != nil {
+		return nil, err
+	}
+
+	cimProvider, err := cim.New(&cim.ClientConfig{
+		Client: client,
+		WSMan:  wsman,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return cimProvider.Client, nil
+}
+
 // New creates a new communicator implementation over WinRM.
 func GetWinrmClient(config *Config) (winrmClient *winrm.Client, err error) {
+	endpoint, params, err := getWinrmEndpoint(config)
+	if err != nil {
+		return nil, err
+	}
+
+	winrmClient, err = winrm.NewClientWithParameters(
+		endpoint, config.User, config.Password, params)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return winrmClient, nil
+}
+
+// getWinrmEndpoint returns the WinRM endpoint of the configuration and the parameters authenticating to it.
+func getWinrmEndpoint(config *Config) (*winrm.Endpoint, *winrm.Parameters, error) {
 	addr := fmt.Sprintf("%s:%d", config.Host, config.Port)
 	endpoint, err := parseEndpoint(addr, config.HTTPS, config.Insecure, config.TLSServerName, config.CACert, config.Cert, config.Key, config.Timeout)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	encrypt, err := config.encryptMessages()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	connectProxy, err := proxy.New(config.ProxyURL)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	params := winrm.DefaultParameters
+	// The default parameters are shared, each configuration sets its own transport
+	params := *winrm.DefaultParameters
 
-	if config.KrbRealm != "" {
+	if encrypt && config.KrbRealm != "" {
+		params.TransportDecorator = func() winrm.Transporter {
+			transporter := winrm_encryption.NewKerberos(&winrm_encryption.KerberosConfig{
+				Username:  config.User,
+				Password:  config.Password,
+				Realm:     config.KrbRealm,
+				SPN:       config.KrbSpn,
+				KrbConf:   config.KrbConfig,
+				KrbCCache: config.KrbCCache,
+			})
+			transporter.Dial = connectProxy.Dial
+			return transporter
+		}
+	} else if encrypt {
+		params.TransportDecorator = func() winrm.Transporter {
+			transporter := winrm_encryption.NewNTLM(config.User, config.Password)
+			transporter.Dial = connectProxy.Dial
+			return transporter
+		}
+	} else if config.KrbRealm != "" {
 		proto := "http"
 		if config.HTTPS {
 			proto = "https"
@@ -196,21 +705,16 @@ func GetWinrmClient(config *Config) (winrmClient *winrm.Client, err error) {
 			}
 		}
 	} else if config.NTLM {
-		params.TransportDecorator = func() winrm.Transporter { return &winrm.ClientNTLM{} }
+		params.TransportDecorator = func() winrm.Transporter { return winrm.NewClientNTLMWithProxyFunc(connectProxy.HTTPProxy) }
+	} else {
+		params.TransportDecorator = func() winrm.Transporter { return winrm.NewClientWithProxyFunc(connectProxy.HTTPProxy) }
 	}
 
 	if endpoint.Timeout.Seconds() > 0 {
 		params.Timeout = iso8601.FormatDuration(endpoint.Timeout)
 	}
 
-	winrmClient, err = winrm.NewClientWithParameters(
-		endpoint, config.User, config.Password, params)
-
-	if err != nil {
-		return nil, err
-	}
-
-	return winrmClient, nil
+	return endpoint, &params, nil
 }
 
 func parseEndpoint(addr string, https bool, insecure bool, tlsServerName string, caCert []byte, cert []byte, key []byte, timeout string) (*winrm.Endpoint, error) {
@@ -267,10 +771,29 @@ func ipFormat(ip string) string {
 }
 
 func getHypervProvider(config *Config) (hypervProvider *api.Provider, err error) {
+	helper := config.credentialHelper()
+	scriptRunner := config.retryScriptRunner(hyperv.NewLazyScriptRunner(func(ctx context.Context) (hyperv.ScriptRunner, time.Time, error) {
+		config, expiresAt, err := config.withCredentials(ctx, helper)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		scriptRunner, err := config.winrmScriptRunner()
+		return scriptRunner, expiresAt, err
+	}))
+
+	return hyperv.New(&hyperv.ClientConfig{
+		ScriptRunner: scriptRunner,
+	})
+}
+
+// winrmScriptRunner returns the script runner of the WinRM transport, with a pool of WinRM clients, wrapped to record
+// its interactions when recording is enabled.
+func (c *Config) winrmScriptRunner() (hyperv.ScriptRunner, error) {
 	ctx := context.Background()
 	factory := pool.NewPooledObjectFactorySimple(
 		func(context.Context) (interface{}, error) {
-			winrmClient, err := GetWinrmClient(config)
+			winrmClient, err := GetWinrmClient(c)
 
 			if err != nil {
 				return nil, err
@@ -289,15 +812,155 @@ func getHypervProvider(config *Config) (hypervProvider *api.Provider, err error)
 	winrmHelperProvider, err := winrm_helper.New(&winrm_helper.ClientConfig{
 		WinRmClientPool:  winRmClientPool,
 		Vars:             "",
-		ElevatedUser:     config.User,
-		ElevatedPassword: config.Password,
+		ElevatedUser:     c.User,
+		ElevatedPassword: c.Password,
+		Redactor:         c.redactor(),
 	})
 
 	if err != nil {
+		winRmClientPool.Close(ctx)
 		return nil, err
 	}
 
-	return hyperv.New(&hyperv.ClientConfig{
-		ScriptRunner: winrmHelperProvider.Client,
+	scriptRunner, err := c.recordScriptRunner(winrmHelperProvider.Client)
+	if err != nil {
+		winRmClientPool.Close(ctx)
+		return nil, err
+	}
+
+	return scriptRunner, nil
+}
+
+// credentialHelper returns the credential helper of the configuration, nil without one
+func (c *Config) credentialHelper() *credential_helper.Helper {
+	if c.CredentialHelper == "" {
+		return nil
+	}
+
+	request := credential_helper.Request{Host: c.Host, User: c.User, Transport: TransportWinRM}
+	if c.SSH {
+		request = credential_helper.Request{Host: c.SSHHost, User: c.SSHUser, Transport: TransportSSH}
+	}
+
+	return credential_helper.New(c.CredentialHelper, request)
+}
+
+// withCredentials returns the configuration with the credentials returned by helper, and when they have to be
+// requested again. The configuration is returned as is without a helper, with credentials that never expire.
+func (c *Config) withCredentials(ctx context.Context, helper *credential_helper.Helper) (Config, time.Time, error) {
+	config := *c
+	if helper == nil {
+		return config, time.Time{}, nil
+	}
+
+	credentials, err := helper.Get(ctx)
+	if err != nil {
+		return config, time.Time{}, err
+	}
+
+	if c.SSH {
+		config.SSHUser = valueOrDefault(credentials.Username, config.SSHUser)
+		config.SSHPassword = valueOrDefault(credentials.Password, config.SSHPassword)
+		config.SSHPrivateKey = valueOrDefault(credentials.PrivateKey, config.SSHPrivateKey)
+		config.SSHPrivateKeyPassphrase = valueOrDefault(credentials.PrivateKeyPassphrase, config.SSHPrivateKeyPassphrase)
+	} else {
+		config.User = valueOrDefault(credentials.Username, config.User)
+		config.Password = valueOrDefault(credentials.Password, config.Password)
+	}
+
+	if credentials.ExpiresAt.IsZero() {
+		return config, time.Time{}, nil
+	}
+
+	return config, credentials.ExpiresAt.Add(-credential_helper.RefreshBefore), nil
+}
+
+func valueOrDefault(value string, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+
+	return value
+}
+
+// cassettes holds the cassettes opened by this process. Terraform configures the provider for every
+// command, so a recording has to continue and a replay has to resume across configurations.
+var cassettes = struct {
+	sync.Mutex
+	files     map[string]*cassette.File
+	replayers map[string]*cassette.Replayer
+}{
+	files:     map[string]*cassette.File{},
+	replayers: map[string]*cassette.Replayer{},
+}
+
+// recordScriptRunner wraps the script runner of a transport to record its interactions when
+// recording is enabled.
+func (c *Config) recordScriptRunner(scriptRunner hyperv.ScriptRunner) (hyperv.ScriptRunner, error) {
+	if c.CassetteMode != cassette.ModeRecord {
+		return scriptRunner, nil
+	}
+
+	runner, ok := scriptRunner.(cassette.Runner)
+	if !ok {
+		return nil, fmt.Errorf("transport %T does not support recording", scriptRunner)
+	}
+
+	cassettes.Lock()
+	defer cassettes.Unlock()
+
+	file, ok := cassettes.files[c.CassettePath]
+	if !ok {
+		var err error
+		file, err = cassette.NewFile(c.CassettePath)
+		if err != nil {
+			return nil, err
+		}
+		cassettes.files[c.CassettePath] = file
+	}
+
+	log.Printf("[INFO][hyperv] Recording HyperV API operations to %s", c.CassettePath)
+
+	return cassette.NewRecorder(file, runner, c.redactor()), nil
+}
+
+// retryScriptRunner wraps the script runner of a transport to retry calls failing with a transient error. It
+// wraps the recorder, so that every attempt is recorded. A single attempt disables retries other than the waits for
+// objects in use, no limit on the attempts leaves the budget as the only limit.
+func (c *Config) retryScriptRunner(scriptRunner hyperv.ScriptRunner) hyperv.ScriptRunner {
+	if c.Retry.MaxAttempts == 1 && c.Retry.BusyInterval <= 0 {
+		return scriptRunner
+	}
+
+	log.Printf("[INFO][hyperv] Retrying HyperV API operations failing with a transient error up to %d attempts within %s", c.Retry.MaxAttempts, c.Retry.Budget)
+
+	return retry.NewRunner(scriptRunner, c.Retry)
+}
+
+// getReplayClient creates a client serving HyperV API operations from a recorded cassette
+func (c *Config) getReplayClient() (api.Client, error) {
+	log.Printf("[INFO][hyperv] Replaying HyperV API operations from %s", c.CassettePath)
+
+	cassettes.Lock()
+	defer cassettes.Unlock()
+
+	replayer, ok := cassettes.replayers[c.CassettePath]
+	if !ok {
+		var err error
+		replayer, err = cassette.NewReplayer(c.CassettePath)
+		if err != nil {
+			return nil, err
+		}
+		cassettes.replayers[c.CassettePath] = replayer
+	}
+
+	// Attempts that failed while recording were recorded too, so they are retried the same way
+	hyperVProvider, err := hyperv.New(&hyperv.ClientConfig{
+		ScriptRunner: c.retryScriptRunner(replayer),
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	return hyperVProvider.Client, nil
 }
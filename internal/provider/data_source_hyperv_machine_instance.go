@@ -19,6 +19,8 @@ func dataSourceHyperVMachineInstance() *schema.Resource {
 		},
 		ReadContext: datasourceHyperVMachineInstanceRead,
 		Schema: map[string]*schema.Schema{
+			"host": hostSchema(false),
+
 			"name": {
 				Type:        schema.TypeString,
 				Required:    true,
@@ -868,7 +870,10 @@ func dataSourceHyperVMachineInstance() *schema.Resource {
 
 func datasourceHyperVMachineInstanceRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	log.Printf("[INFO][hyperv][read] reading hyperv machine: %#v", d)
-	client := meta.(api.Client)
+	client, err := clientFor(meta, d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
 
 	var name string
 	if v, ok := d.GetOk("name"); ok {
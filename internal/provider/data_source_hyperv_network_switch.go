@@ -18,6 +18,8 @@ func dataSourceHyperVNetworkSwitch() *schema.Resource {
 		},
 		ReadContext: datasourceHyperVNetworkSwitchRead,
 		Schema: map[string]*schema.Schema{
+			"host": hostSchema(false),
+
 			"name": {
 				Type:        schema.TypeString,
 				Required:    true,
@@ -127,7 +129,10 @@ func dataSourceHyperVNetworkSwitch() *schema.Resource {
 
 func datasourceHyperVNetworkSwitchRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	log.Printf("[INFO][hyperv][read] reading hyperv switch: %#v", d)
-	c := meta.(api.Client)
+	c, err := clientFor(meta, d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
 
 	var switchName string
 
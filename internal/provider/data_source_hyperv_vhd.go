@@ -18,6 +18,8 @@ func dataSourceHyperVVhd() *schema.Resource {
 		},
 		ReadContext: datasourceHyperVVhdRead,
 		Schema: map[string]*schema.Schema{
+			"host": hostSchema(false),
+
 			"path": {
 				Type:        schema.TypeString,
 				Required:    true,
@@ -133,7 +135,10 @@ func dataSourceHyperVVhd() *schema.Resource {
 
 func datasourceHyperVVhdRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	log.Printf("[INFO][hyperv][read] reading hyperv vhd: %#v", d)
-	c := meta.(api.Client)
+	c, err := clientFor(meta, d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
 
 	path := ""
 
@@ -5,7 +5,6 @@ package provider
 
 import (
 	"fmt"
-	"path/filepath"
 	"strings"
 	"testing"
 
@@ -20,9 +19,7 @@ func TestHyperVDataSourceVhd(t *testing.T) {
 		t.Skip("skipping test in short mode.")
 	}
 
-	//tempDirectory := os.TempDir() uses short name ;<
-	tempDirectory, _ := filepath.Abs(".")
-	path, _ := filepath.Abs(filepath.Join(tempDirectory, fmt.Sprintf("testhypervdatasourcevhd_%d.vhdx", randInt())))
+	path := testAccPath(fmt.Sprintf("testhypervdatasourcevhd_%d.vhdx", randInt(t)))
 
 	resource.UnitTest(t, resource.TestCase{
 		PreCheck:          func() { testAccPreCheck(t) },
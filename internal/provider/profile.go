@@ -0,0 +1,278 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"gopkg.in/yaml.v3"
+)
+
+// ProfileInherits is the field of a profile naming the profile it inherits its values from.
+const ProfileInherits = "inherits"
+
+// DefaultProfilesFiles are looked for in the .hyperv directory of the home directory when `profiles_file` is not set,
+// the first one found is used.
+var DefaultProfilesFiles = []string{"config.json", "config.yaml", "config.yml"}
+
+// profileEnvironmentVariables lists the environment variables of the attributes that are not only sourced from
+// HYPERV_ followed by their name in upper case.
+var profileEnvironmentVariables = map[string][]string{
+	"kerberos_config":           {"HYPERV_KERBEROS_CONFIG", "KRB5_CONFIG"},
+	"kerberos_credential_cache": {"HYPERV_KERBEROS_CREDENTIAL_CACHE", "KRB5CCNAME"},
+}
+
+// profilesFile is the content of a profiles file, named profiles holding provider attributes.
+type profilesFile struct {
+	Profiles map[string]map[string]interface{} `json:"profiles" yaml:"profiles"`
+}
+
+// profile holds the provider attributes of a named profile, including the ones it inherits.
+type profile struct {
+	name   string
+	values map[string]interface{}
+}
+
+// get returns the value of a provider attribute. An attribute set in the provider block or by its environment variable
+// is taken first, then the value of the profile and finally the default of the attribute.
+func (p profile) get(resourceData *schema.ResourceData, key string) interface{} {
+	if value, ok := p.values[key]; ok && !setInConfig(resourceData, key) && !setInEnvironment(key) {
+		return value
+	}
+
+	return resourceData.Get(key)
+}
+
+func setInConfig(resourceData *schema.ResourceData, key string) bool {
+	config := resourceData.GetRawConfig()
+	if config.IsNull() || !config.Type().IsObjectType() || !config.Type().HasAttribute(key) {
+		return false
+	}
+
+	return !config.GetAttr(key).IsNull()
+}
+
+func setInEnvironment(key string) bool {
+	names, ok := profileEnvironmentVariables[key]
+	if !ok {
+		names = []string{"HYPERV_" + strings.ToUpper(key)}
+	}
+
+	for _, name := range names {
+		if os.Getenv(name) != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// profilesFilePath returns the profiles file to read, path when it is set or otherwise the first of the default
+// profiles files found.
+func profilesFilePath(path string) (string, error) {
+	if path != "" {
+		return path, nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory to look for the profiles file: %w", err)
+	}
+
+	dir := filepath.Join(homeDir, ".hyperv")
+	for _, name := range DefaultProfilesFiles {
+		candidate := filepath.Join(dir, name)
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+
+	return "", fmt.Errorf("no profiles file found in %s, create one of %s or set `profiles_file`", dir, strings.Join(DefaultProfilesFiles, ", "))
+}
+
+// loadProfile reads the profile called name from the profiles file at path, merged with the profiles it inherits. Every
+// value is checked against the provider attribute of the same name in attributes.
+func loadProfile(path string, name string, attributes map[string]*schema.Schema) (profile, error) {
+	path, err := profilesFilePath(path)
+	if err != nil {
+		return profile{}, fmt.Errorf("profile %q: %w", name, err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return profile{}, fmt.Errorf("profile %q: failed to read profiles file: %w", name, err)
+	}
+
+	var file profilesFile
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &file)
+	default:
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.UseNumber()
+		err = decoder.Decode(&file)
+	}
+	if err != nil {
+		return profile{}, fmt.Errorf("profile %q: failed to parse profiles file %s: %w", name, path, err)
+	}
+
+	values, err := file.resolve(name, nil, attributes)
+	if err != nil {
+		return profile{}, fmt.Errorf("profiles file %s: %w", path, err)
+	}
+
+	log.Printf("[INFO][hyperv] Using profile %q from %s", name, path)
+
+	return profile{name: name, values: values}, nil
+}
+
+// resolve returns the values of the profile called name over the values of the profiles it inherits. Inheriting lists
+// the profiles inheriting from it, to detect cycles.
+func (f profilesFile) resolve(name string, inheriting []string, attributes map[string]*schema.Schema) (map[string]interface{}, error) {
+	for _, child := range inheriting {
+		if child == name {
+			return nil, fmt.Errorf("profile %q: profiles inherit from each other: %s", inheriting[0], strings.Join(append(inheriting, name), " -> "))
+		}
+	}
+
+	fields, ok := f.Profiles[name]
+	if !ok {
+		if len(inheriting) > 0 {
+			return nil, fmt.Errorf("profile %q: field %q: profile %q not found", inheriting[len(inheriting)-1], ProfileInherits, name)
+		}
+		return nil, fmt.Errorf("profile %q not found", name)
+	}
+
+	values := map[string]interface{}{}
+	if parent, ok := fields[ProfileInherits]; ok {
+		parentName, ok := parent.(string)
+		if !ok {
+			return nil, fmt.Errorf("profile %q: field %q: expected the name of a profile", name, ProfileInherits)
+		}
+
+		inherited, err := f.resolve(parentName, append(inheriting, name), attributes)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range inherited {
+			values[key] = value
+		}
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		if key != ProfileInherits {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		converted, err := profileValue(attributes, key, fields[key])
+		if err != nil {
+			return nil, fmt.Errorf("profile %q: field %q: %w", name, key, err)
+		}
+		values[key] = converted
+	}
+
+	return values, nil
+}
+
+// profileValue checks value against the provider attribute key, and returns it with the type the attribute is read
+// with.
+func profileValue(attributes map[string]*schema.Schema, key string, value interface{}) (interface{}, error) {
+	attribute, ok := attributes[key]
+	if !ok {
+		return nil, fmt.Errorf("not a provider attribute")
+	}
+	if key == "profile" || key == "profiles_file" {
+		return nil, fmt.Errorf("cannot be set in a profile")
+	}
+
+	var converted interface{}
+	switch attribute.Type {
+	case schema.TypeString:
+		if _, ok := value.(string); !ok {
+			return nil, fmt.Errorf("expected a string")
+		}
+		converted = value
+	case schema.TypeBool:
+		if _, ok := value.(bool); !ok {
+			return nil, fmt.Errorf("expected true or false")
+		}
+		converted = value
+	case schema.TypeInt:
+		number, err := profileInt(value)
+		if err != nil {
+			return nil, err
+		}
+		converted = number
+	case schema.TypeList:
+		elem, ok := attribute.Elem.(*schema.Schema)
+		if !ok || elem.Type != schema.TypeString {
+			return nil, fmt.Errorf("blocks cannot be set in a profile")
+		}
+		items, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected a list of strings")
+		}
+		for i, item := range items {
+			if _, ok := item.(string); !ok {
+				return nil, fmt.Errorf("expected a list of strings, item %d is not a string", i)
+			}
+			if err := validationError(elem.ValidateDiagFunc, item, key); err != nil {
+				return nil, err
+			}
+		}
+		converted = items
+	default:
+		return nil, fmt.Errorf("cannot be set in a profile")
+	}
+
+	if err := validationError(attribute.ValidateDiagFunc, converted, key); err != nil {
+		return nil, err
+	}
+
+	return converted, nil
+}
+
+func profileInt(value interface{}) (int, error) {
+	switch number := value.(type) {
+	case int:
+		return number, nil
+	case json.Number:
+		parsed, err := number.Int64()
+		if err == nil {
+			return int(parsed), nil
+		}
+	}
+
+	return 0, fmt.Errorf("expected a whole number")
+}
+
+// validationError returns the errors of the validation of value as one error.
+func validationError(validate schema.SchemaValidateDiagFunc, value interface{}, key string) error {
+	if validate == nil {
+		return nil
+	}
+
+	var summaries []string
+	for _, diagnostic := range validate(value, cty.GetAttrPath(key)) {
+		if diagnostic.Severity == diag.Error {
+			summaries = append(summaries, diagnostic.Summary)
+		}
+	}
+	if len(summaries) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("%s", strings.Join(summaries, ", "))
+}
@@ -0,0 +1,154 @@
+package provider
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+const jsonProfiles = `{
+  "profiles": {
+    "lab": {
+      "port": 5985,
+      "https": false,
+      "timeout": "1m",
+      "ssh_host_key_fingerprints": ["SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8"]
+    },
+    "lab-east": {
+      "inherits": "lab",
+      "host": "hv-east.example.com",
+      "timeout": "2m"
+    }
+  }
+}`
+
+const yamlProfiles = `profiles:
+  lab:
+    port: 5985
+    https: false
+    timeout: 1m
+    ssh_host_key_fingerprints:
+      - SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8
+  lab-east:
+    inherits: lab
+    host: hv-east.example.com
+    timeout: 2m
+`
+
+func writeProfiles(t *testing.T, name string, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadProfile(t *testing.T) {
+	t.Parallel()
+
+	want := map[string]interface{}{
+		"host":                      "hv-east.example.com",
+		"port":                      5985,
+		"https":                     false,
+		"timeout":                   "2m",
+		"ssh_host_key_fingerprints": []interface{}{"SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8"},
+	}
+
+	for name, content := range map[string]string{"config.json": jsonProfiles, "config.yaml": yamlProfiles} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			settings, err := loadProfile(writeProfiles(t, name, content), "lab-east", New("test", "")().Schema)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(settings.values, want) {
+				t.Fatalf("expected %v, got %v", want, settings.values)
+			}
+		})
+	}
+}
+
+func TestLoadProfileErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		profiles string
+		wantErr  string
+	}{
+		{name: "missing profile", profiles: `{"profiles": {"lab": {}}}`, wantErr: `profile "lab-east" not found`},
+		{name: "unknown field", profiles: `{"profiles": {"lab-east": {"prot": 5985}}}`, wantErr: `profile "lab-east": field "prot": not a provider attribute`},
+		{name: "wrong type", profiles: `{"profiles": {"lab-east": {"port": "5985"}}}`, wantErr: `profile "lab-east": field "port": expected a whole number`},
+		{name: "invalid value", profiles: `{"profiles": {"lab-east": {"transport": "telnet"}}}`, wantErr: `profile "lab-east": field "transport": expected telnet to be one of`},
+		{name: "block", profiles: `{"profiles": {"lab-east": {"retry": []}}}`, wantErr: `profile "lab-east": field "retry": blocks cannot be set in a profile`},
+		{name: "profile field", profiles: `{"profiles": {"lab-east": {"profile": "lab"}}}`, wantErr: `profile "lab-east": field "profile": cannot be set in a profile`},
+		{name: "inherited error", profiles: `{"profiles": {"lab": {"https": "yes"}, "lab-east": {"inherits": "lab"}}}`, wantErr: `profile "lab": field "https": expected true or false`},
+		{name: "missing parent", profiles: `{"profiles": {"lab-east": {"inherits": "lab"}}}`, wantErr: `profile "lab-east": field "inherits": profile "lab" not found`},
+		{name: "cycle", profiles: `{"profiles": {"lab": {"inherits": "lab-east"}, "lab-east": {"inherits": "lab"}}}`, wantErr: "lab-east -> lab -> lab-east"},
+		{name: "invalid json", profiles: `{"profiles":`, wantErr: "failed to parse profiles file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := loadProfile(writeProfiles(t, "config.json", tt.profiles), "lab-east", New("test", "")().Schema)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestConfigureWithProfile(t *testing.T) {
+	t.Parallel()
+
+	path := writeProfiles(t, "config.json", `{
+  "profiles": {
+    "lab": {"timeout": "1m", "script_path": "C:/Temp/lab_%RAND%.cmd"},
+    "lab-east": {"inherits": "lab", "tls_server_name": "hv-east.example.com"}
+  }
+}`)
+
+	provider := New("test", "")()
+	block := schema.InternalMap(provider.Schema).CoreConfigSchema()
+	value, err := block.CoerceValue(cty.ObjectVal(map[string]cty.Value{
+		"profile":       cty.StringVal("lab-east"),
+		"profiles_file": cty.StringVal(path),
+		"password":      cty.StringVal("secret"),
+		"script_path":   cty.StringVal("C:/Temp/terraform_%RAND%.cmd"),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Terraform sends the configuration as it was written as well, telling the attributes that were set apart
+	config := terraform.NewResourceConfigShimmed(value, block)
+	config.CtyValue = value
+
+	if diags := provider.Configure(context.Background(), config); diags.HasError() {
+		t.Fatalf("failed to configure the provider: %v", diags)
+	}
+
+	registry, ok := provider.Meta().(*clientRegistry)
+	if !ok {
+		t.Fatalf("expected a client registry, got %T", provider.Meta())
+	}
+	if registry.config.TLSServerName != "hv-east.example.com" || registry.config.Timeout != "1m" {
+		t.Fatalf("expected the values of the profile, got %q and %q", registry.config.TLSServerName, registry.config.Timeout)
+	}
+	if registry.config.ScriptPath != "C:/Temp/terraform_%RAND%.cmd" {
+		t.Fatalf("expected the provider block to take precedence over the profile, got %q", registry.config.ScriptPath)
+	}
+}
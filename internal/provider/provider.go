@@ -4,11 +4,16 @@ package provider
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"context"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/taliesins/terraform-provider-hyperv/api/cassette"
+	local_helper "github.com/taliesins/terraform-provider-hyperv/api/local-helper"
+	"github.com/taliesins/terraform-provider-hyperv/api/retry"
+	ssh_helper "github.com/taliesins/terraform-provider-hyperv/api/ssh-helper"
 )
 
 const (
@@ -20,6 +25,8 @@ const (
 
 	DefaultAllowNTLM = true
 
+	DefaultMessageEncryption = MessageEncryptionAuto
+
 	DefaultKerberosRealm = ""
 
 	DefaultKerberosServicePrincipalName = ""
@@ -53,6 +60,12 @@ const (
 	DefaultSSHPort = 22
 
 	DefaultSSHPrivateKeyPath = ""
+
+	DefaultSSHKnownHostsPath = ""
+
+	DefaultSSHPowerShell = ssh_helper.PowerShellDesktop
+
+	DefaultLocalPowerShell = local_helper.DefaultPowerShell
 )
 
 func init() {
@@ -76,6 +89,20 @@ func New(version string, commit string) func() *schema.Provider {
 	return func() *schema.Provider {
 		provider := &schema.Provider{
 			Schema: map[string]*schema.Schema{
+				"profile": {
+					Type:        schema.TypeString,
+					Optional:    true,
+					DefaultFunc: schema.EnvDefaultFunc("HYPERV_PROFILE", ""),
+					Description: "The name of a profile of the profiles file to take the other attributes from. Attributes set in the provider block or by their environment variable take precedence over the profile, which takes precedence over the defaults. It can also be sourced from the `HYPERV_PROFILE` environment variable.",
+				},
+
+				"profiles_file": {
+					Type:        schema.TypeString,
+					Optional:    true,
+					DefaultFunc: schema.EnvDefaultFunc("HYPERV_PROFILES_FILE", ""),
+					Description: "The JSON or YAML file holding the profiles, read when `profile` is set. Each profile holds provider attributes by name and may inherit the attributes of another profile with `inherits`, blocks cannot be set in profiles. It can also be sourced from the `HYPERV_PROFILES_FILE` environment variable otherwise the first of `config.json`, `config.yaml` and `config.yml` found in the `.hyperv` directory of the home directory is used.",
+				},
+
 				"user": {
 					Type:        schema.TypeString,
 					Optional:    true,
@@ -90,6 +117,22 @@ func New(version string, commit string) func() *schema.Provider {
 					Description: "The password associated with the username to use for HyperV api calls. It can also be sourced from the `HYPERV_PASSWORD` environment variable`.",
 				},
 
+				"credential_helper": {
+					Type:        schema.TypeString,
+					Optional:    true,
+					DefaultFunc: schema.EnvDefaultFunc("HYPERV_CREDENTIAL_HELPER", ""),
+					Description: "An executable returning the credentials of the user, instead of `password`, `ssh_password` or `ssh_private_key`. It is run with the argument `get` before connecting, reads the `host`, `user` and `transport` of the connection as a JSON object from stdin and writes a JSON object with `password`, or `private_key` and optionally `private_key_passphrase` for SSH, to stdout. It may also return `username` to replace the user, and `expires_at` as an RFC 3339 time to have the credentials requested again before they expire, otherwise they are kept for the lifetime of the provider. It can also be sourced from the `HYPERV_CREDENTIAL_HELPER` environment variable.",
+				},
+
+				"proxy_url": {
+					Type:             schema.TypeString,
+					Optional:         true,
+					Sensitive:        true,
+					DefaultFunc:      schema.EnvDefaultFunc("HYPERV_PROXY_URL", ""),
+					ValidateDiagFunc: ProxyURL(),
+					Description:      "The proxy SSH connections and WinRM requests go through, as `socks5://`, `socks5h://`, `http://` or `https://` followed by the host and port of the proxy, with `user:password@` to authenticate to it. SSH connections and encrypted WinRM requests are tunnelled with CONNECT through HTTP proxies. It can also be sourced from the `HYPERV_PROXY_URL` environment variable otherwise the proxy of the `ALL_PROXY`, `HTTPS_PROXY` or `HTTP_PROXY` environment variables is used, except for the hosts of `NO_PROXY`.",
+				},
+
 				"host": {
 					Type:        schema.TypeString,
 					Optional:    true,
@@ -125,6 +168,14 @@ func New(version string, commit string) func() *schema.Provider {
 					Description: "Use NTLM for authentication for HyperV api calls. Can also be set via setting the `HYPERV_USE_NTLM` environment variable to `true` otherwise defaults to `true`.",
 				},
 
+				"message_encryption": {
+					Type:             schema.TypeString,
+					Optional:         true,
+					DefaultFunc:      schema.EnvDefaultFunc("HYPERV_MESSAGE_ENCRYPTION", DefaultMessageEncryption),
+					ValidateDiagFunc: StringKeyInMap(MessageEncryptions, false),
+					Description:      "When to encrypt WinRM messages with the NTLM or Kerberos authentication, as Windows clients do: `auto` encrypts them over HTTP, `always` over HTTP and HTTPS, and `never` sends them in plaintext over HTTP, which requires `AllowUnencrypted` on the WinRM service. `always` requires `use_ntlm` or `kerberos_realm`. Can also be set via setting the `HYPERV_MESSAGE_ENCRYPTION` environment variable otherwise defaults to `auto`.",
+				},
+
 				"kerberos_realm": {
 					Type:        schema.TypeString,
 					Optional:    true,
@@ -195,6 +246,29 @@ func New(version string, commit string) func() *schema.Provider {
 					Description: "The timeout to wait for the connection to become available for HyperV api calls. Should be provided as a string like 30s or 5m. Can also be sourced from the `HYPERV_TIMEOUT` environment variable otherwise defaults to `30s`.",
 				},
 
+				"transport": {
+					Type:             schema.TypeString,
+					Optional:         true,
+					DefaultFunc:      schema.EnvDefaultFunc("HYPERV_TRANSPORT", nil),
+					ValidateDiagFunc: StringKeyInMap(Transports, false),
+					Description:      "The transport used for HyperV api calls: `winrm`, `ssh`, or `local` to run PowerShell directly on the machine running Terraform when it is itself the Hyper-V host. Setting `ssh` to `true` is the same as `ssh`. Can also be sourced from the `HYPERV_TRANSPORT` environment variable otherwise defaults to `winrm`.",
+				},
+
+				"local_powershell": {
+					Type:        schema.TypeString,
+					Optional:    true,
+					DefaultFunc: schema.EnvDefaultFunc("HYPERV_LOCAL_POWERSHELL", DefaultLocalPowerShell),
+					Description: "The PowerShell executable used by the `local` transport, `powershell`, `pwsh` or the path to an executable. Can also be sourced from the `HYPERV_LOCAL_POWERSHELL` environment variable otherwise defaults to `powershell`.",
+				},
+
+				"backend": {
+					Type:             schema.TypeString,
+					Optional:         true,
+					DefaultFunc:      schema.EnvDefaultFunc("HYPERV_BACKEND", BackendPowerShell),
+					ValidateDiagFunc: StringKeyInMap(Backends, false),
+					Description:      "How HyperV api calls are served: `powershell` runs PowerShell scripts using the Hyper-V module for every call, `cim` reads VMs, VM status, switches and VHDs from the WMI namespace of Hyper-V over WS-Management without starting PowerShell, and runs PowerShell scripts for everything else. `cim` requires the `winrm` transport. Can also be sourced from the `HYPERV_BACKEND` environment variable otherwise defaults to `powershell`.",
+				},
+
 				"ssh": {
 					Type:        schema.TypeBool,
 					Optional:    true,
@@ -232,6 +306,37 @@ func New(version string, commit string) func() *schema.Provider {
 					Description: "The path to the private key file for SSH authentication. Can also be sourced from the `HYPERV_SSH_PRIVATE_KEY_PATH` environment variable.",
 				},
 
+				"ssh_private_key_passphrase": {
+					Type:        schema.TypeString,
+					Optional:    true,
+					Sensitive:   true,
+					DefaultFunc: schema.EnvDefaultFunc("HYPERV_SSH_PRIVATE_KEY_PASSPHRASE", ""),
+					Description: "The passphrase used to decrypt `ssh_private_key` or `ssh_private_key_path`. Can also be sourced from the `HYPERV_SSH_PRIVATE_KEY_PASSPHRASE` environment variable.",
+				},
+
+				"ssh_certificate": {
+					Type:          schema.TypeString,
+					Optional:      true,
+					DefaultFunc:   schema.EnvDefaultFunc("HYPERV_SSH_CERTIFICATE", ""),
+					ConflictsWith: []string{"ssh_certificate_path"},
+					Description:   "The OpenSSH user certificate content (e.g. the contents of `id_ed25519-cert.pub`) presented together with the private key or the matching ssh-agent key. Can also be sourced from the `HYPERV_SSH_CERTIFICATE` environment variable.",
+				},
+
+				"ssh_certificate_path": {
+					Type:          schema.TypeString,
+					Optional:      true,
+					DefaultFunc:   schema.EnvDefaultFunc("HYPERV_SSH_CERTIFICATE_PATH", ""),
+					ConflictsWith: []string{"ssh_certificate"},
+					Description:   "The path to an OpenSSH user certificate presented together with the private key or the matching ssh-agent key. Can also be sourced from the `HYPERV_SSH_CERTIFICATE_PATH` environment variable.",
+				},
+
+				"ssh_use_agent": {
+					Type:        schema.TypeBool,
+					Optional:    true,
+					DefaultFunc: schema.EnvDefaultFunc("HYPERV_SSH_USE_AGENT", false),
+					Description: "Authenticate with the keys held by the ssh-agent listening on `SSH_AUTH_SOCK`. Can also be sourced from the `HYPERV_SSH_USE_AGENT` environment variable otherwise defaults to `false`.",
+				},
+
 				"ssh_host": {
 					Type:        schema.TypeString,
 					Optional:    true,
@@ -245,6 +350,177 @@ func New(version string, commit string) func() *schema.Provider {
 					DefaultFunc: schema.EnvDefaultFunc("HYPERV_SSH_PORT", DefaultSSHPort),
 					Description: "The port for SSH connections. Can also be sourced from the `HYPERV_SSH_PORT` environment variable otherwise defaults to `22`.",
 				},
+
+				"ssh_known_hosts_path": {
+					Type:        schema.TypeString,
+					Optional:    true,
+					DefaultFunc: schema.EnvDefaultFunc("HYPERV_SSH_KNOWN_HOSTS_PATH", DefaultSSHKnownHostsPath),
+					Description: "The path to a known_hosts file used to verify the SSH host key. If no host key source is configured `~/.ssh/known_hosts` is used. Can also be sourced from the `HYPERV_SSH_KNOWN_HOSTS_PATH` environment variable.",
+				},
+
+				"ssh_known_hosts": {
+					Type:        schema.TypeString,
+					Optional:    true,
+					DefaultFunc: schema.EnvDefaultFunc("HYPERV_SSH_KNOWN_HOSTS", ""),
+					Description: "Inline known_hosts content used to verify the SSH host key. Can also be sourced from the `HYPERV_SSH_KNOWN_HOSTS` environment variable.",
+				},
+
+				"ssh_host_key_fingerprints": {
+					Type:     schema.TypeList,
+					Optional: true,
+					Elem: &schema.Schema{
+						Type:             schema.TypeString,
+						ValidateDiagFunc: SSHHostKeyFingerprint(),
+					},
+					Description: "Pinned SHA256 fingerprints of the SSH host key, e.g. `SHA256:uNiVztksCsDhcc0u9e8BujQXVUpKZIDTMczCvj3tD2s`. The connection is accepted if the host key matches any of them.",
+				},
+
+				"ssh_trust_on_first_use": {
+					Type:        schema.TypeBool,
+					Optional:    true,
+					DefaultFunc: schema.EnvDefaultFunc("HYPERV_SSH_TRUST_ON_FIRST_USE", false),
+					Description: "Trust the SSH host key of a host that is not yet known and record it in the known_hosts file. Changed host keys, and keys matching none of the `ssh_host_key_fingerprints` when they are set, are always rejected. Can also be sourced from the `HYPERV_SSH_TRUST_ON_FIRST_USE` environment variable otherwise defaults to `false`.",
+				},
+
+				"ssh_powershell": {
+					Type:             schema.TypeString,
+					Optional:         true,
+					DefaultFunc:      schema.EnvDefaultFunc("HYPERV_SSH_POWERSHELL", DefaultSSHPowerShell),
+					ValidateDiagFunc: StringKeyInMap(ssh_helper.PowerShellExecutables, false),
+					Description:      "The PowerShell used to run scripts over SSH, `powershell` for Windows PowerShell 5.1 or `pwsh` for PowerShell 7. PowerShell is invoked explicitly, so the OpenSSH default shell of the host does not need to be changed. Can also be sourced from the `HYPERV_SSH_POWERSHELL` environment variable otherwise defaults to `powershell`.",
+				},
+
+				"ssh_persistent_session": {
+					Type:        schema.TypeBool,
+					Optional:    true,
+					DefaultFunc: schema.EnvDefaultFunc("HYPERV_SSH_PERSISTENT_SESSION", false),
+					Description: "Run scripts in long-lived PowerShell processes instead of starting PowerShell for every script, which avoids the interpreter startup and Hyper-V module import on each call. Each process runs on its own SSH connection and is restarted if it dies. Can also be sourced from the `HYPERV_SSH_PERSISTENT_SESSION` environment variable otherwise defaults to `false`.",
+				},
+
+				"ssh_bastion": {
+					Type:        schema.TypeList,
+					Optional:    true,
+					Description: "Jump hosts used to reach the SSH host. Connections are tunnelled through each bastion in the order they are listed, the first bastion being dialled directly. Known hosts settings and `ssh_trust_on_first_use` also apply to bastions.",
+					Elem: &schema.Resource{
+						Schema: map[string]*schema.Schema{
+							"host": {
+								Type:        schema.TypeString,
+								Required:    true,
+								Description: "The host of the bastion.",
+							},
+
+							"port": {
+								Type:        schema.TypeInt,
+								Optional:    true,
+								Default:     DefaultSSHPort,
+								Description: "The SSH port of the bastion. Defaults to `22`.",
+							},
+
+							"user": {
+								Type:        schema.TypeString,
+								Optional:    true,
+								Description: "The username on the bastion. Defaults to the user of the SSH connection.",
+							},
+
+							"password": {
+								Type:        schema.TypeString,
+								Optional:    true,
+								Sensitive:   true,
+								Description: "The password for the bastion.",
+							},
+
+							"private_key": {
+								Type:        schema.TypeString,
+								Optional:    true,
+								Sensitive:   true,
+								Description: "The private key content for the bastion (PEM format).",
+							},
+
+							"private_key_path": {
+								Type:        schema.TypeString,
+								Optional:    true,
+								Description: "The path to the private key file for the bastion.",
+							},
+
+							"private_key_passphrase": {
+								Type:        schema.TypeString,
+								Optional:    true,
+								Sensitive:   true,
+								Description: "The passphrase used to decrypt the bastion private key.",
+							},
+
+							"certificate": {
+								Type:        schema.TypeString,
+								Optional:    true,
+								Description: "The OpenSSH user certificate content for the bastion.",
+							},
+
+							"certificate_path": {
+								Type:        schema.TypeString,
+								Optional:    true,
+								Description: "The path to an OpenSSH user certificate for the bastion.",
+							},
+
+							"use_agent": {
+								Type:        schema.TypeBool,
+								Optional:    true,
+								Default:     false,
+								Description: "Authenticate to the bastion with the keys held by ssh-agent. Defaults to `false`.",
+							},
+
+							"host_key_fingerprints": {
+								Type:     schema.TypeList,
+								Optional: true,
+								Elem: &schema.Schema{
+									Type:             schema.TypeString,
+									ValidateDiagFunc: SSHHostKeyFingerprint(),
+								},
+								Description: "Pinned SHA256 fingerprints of the bastion host key.",
+							},
+						},
+					},
+				},
+				"retry": {
+					Type:        schema.TypeList,
+					Optional:    true,
+					MaxItems:    1,
+					Description: "Retries of HyperV api calls that fail with a transient error: a dropped SSH or WinRM connection, a WinRM server error or a VM that is changing state. Calls that change objects are only retried when they failed before they started, as they could otherwise apply their changes twice. Files and VHDs that are in use, for example by a merge or another process, are waited for every 10 seconds for up to 5 minutes, and these retries are not counted against `max_attempts`. Other errors fail immediately. When the block is omitted calls are retried with its defaults.",
+					Elem: &schema.Resource{
+						Schema: map[string]*schema.Schema{
+							"max_attempts": {
+								Type:             schema.TypeInt,
+								Optional:         true,
+								Default:          retry.DefaultMaxAttempts,
+								ValidateDiagFunc: IntBetween(0, 100),
+								Description:      "The number of attempts of a call, including the first one. Set to `1` to disable retries other than the waits for objects in use, or to `0` to only limit retries by `budget`. Defaults to `3`.",
+							},
+
+							"initial_interval": {
+								Type:             schema.TypeString,
+								Optional:         true,
+								Default:          retry.DefaultInitialInterval.String(),
+								ValidateDiagFunc: Duration(),
+								Description:      "The wait before the first retry. It doubles with every retry up to `max_interval`, and a random part of up to half of it is taken off so that parallel calls do not retry together. Defaults to `1s`.",
+							},
+
+							"max_interval": {
+								Type:             schema.TypeString,
+								Optional:         true,
+								Default:          retry.DefaultMaxInterval.String(),
+								ValidateDiagFunc: Duration(),
+								Description:      "The longest wait between two attempts. Defaults to `30s`.",
+							},
+
+							"budget": {
+								Type:             schema.TypeString,
+								Optional:         true,
+								Default:          retry.DefaultBudget.String(),
+								ValidateDiagFunc: Duration(),
+								Description:      "The total time a call may take including its retries, no retry is started past it. `0s` removes the limit. Defaults to `2m`.",
+							},
+						},
+					},
+				},
 			},
 
 			ResourcesMap: map[string]*schema.Resource{
@@ -270,8 +546,16 @@ func configure(version string, commit string, provider *schema.Provider) func(co
 	return func(context context.Context, resourceData *schema.ResourceData) (interface{}, diag.Diagnostics) {
 		var diags diag.Diagnostics
 		var err error
+		var settings profile
+		if name := resourceData.Get("profile").(string); name != "" {
+			settings, err = loadProfile(resourceData.Get("profiles_file").(string), name, provider.Schema)
+			if err != nil {
+				return nil, diag.FromErr(err)
+			}
+		}
+
 		var cacert []byte = nil
-		cacertPath := resourceData.Get("cacert_path").(string)
+		cacertPath := settings.get(resourceData, "cacert_path").(string)
 		if cacertPath != "" {
 			if _, err := os.Stat(cacertPath); os.IsNotExist(err) {
 				return nil, diag.FromErr(fmt.Errorf("cacertPath does not exist - %s", cacertPath))
@@ -284,7 +568,7 @@ func configure(version string, commit string, provider *schema.Provider) func(co
 		}
 
 		var cert []byte = nil
-		certPath := resourceData.Get("cert_path").(string)
+		certPath := settings.get(resourceData, "cert_path").(string)
 		if certPath != "" {
 			if _, err := os.Stat(certPath); os.IsNotExist(err) {
 				return nil, diag.FromErr(fmt.Errorf("certPath does not exist - %s", certPath))
@@ -297,7 +581,7 @@ func configure(version string, commit string, provider *schema.Provider) func(co
 		}
 
 		var key []byte = nil
-		keyPath := resourceData.Get("key_path").(string)
+		keyPath := settings.get(resourceData, "key_path").(string)
 		if keyPath != "" {
 			if _, err := os.Stat(keyPath); os.IsNotExist(err) {
 				return nil, diag.FromErr(fmt.Errorf("keyPath does not exist - %s", keyPath))
@@ -317,47 +601,114 @@ func configure(version string, commit string, provider *schema.Provider) func(co
 		}
 
 		// Determine SSH configuration
-		useSSH := resourceData.Get("ssh").(bool)
-		sshUser := resourceData.Get("ssh_user").(string)
-		sshPassword := resourceData.Get("ssh_password").(string)
-		sshPrivateKey := resourceData.Get("ssh_private_key").(string)
-		sshPrivateKeyPath := resourceData.Get("ssh_private_key_path").(string)
-		sshHost := resourceData.Get("ssh_host").(string)
-		sshPort := resourceData.Get("ssh_port").(int)
+		useSSH := settings.get(resourceData, "ssh").(bool)
+		transport := settings.get(resourceData, "transport").(string)
+		switch {
+		case transport == "" && useSSH:
+			transport = TransportSSH
+		case transport == "":
+			transport = TransportWinRM
+		case useSSH && transport != TransportSSH:
+			return nil, diag.Errorf("`ssh = true` conflicts with `transport = %q`, remove `ssh` or set `transport = %q`", transport, TransportSSH)
+		}
+		useSSH = transport == TransportSSH
+
+		backend := settings.get(resourceData, "backend").(string)
+		if backend == BackendCIM && transport != TransportWinRM {
+			return nil, diag.Errorf("`backend = %q` requires `transport = %q`, got %q", BackendCIM, TransportWinRM, transport)
+		}
+
+		cassettePath := os.Getenv(cassette.EnvPath)
+		cassetteMode := os.Getenv(cassette.EnvMode)
+		switch cassetteMode {
+		case "":
+		case cassette.ModeRecord, cassette.ModeReplay:
+			if cassettePath == "" {
+				return nil, diag.Errorf("%s must be set when %s is %q", cassette.EnvPath, cassette.EnvMode, cassetteMode)
+			}
+		default:
+			return nil, diag.Errorf("%s must be %q or %q, got %q", cassette.EnvMode, cassette.ModeRecord, cassette.ModeReplay, cassetteMode)
+		}
+		if cassetteMode != "" && backend == BackendCIM {
+			return nil, diag.Errorf("%s is not supported with `backend = %q`, WS-Management requests are not recorded", cassette.EnvMode, BackendCIM)
+		}
+
+		sshUser := settings.get(resourceData, "ssh_user").(string)
+		sshPassword := settings.get(resourceData, "ssh_password").(string)
+		sshPrivateKey := settings.get(resourceData, "ssh_private_key").(string)
+		sshPrivateKeyPath := settings.get(resourceData, "ssh_private_key_path").(string)
+		sshUseAgent := settings.get(resourceData, "ssh_use_agent").(bool)
+		sshHost := settings.get(resourceData, "ssh_host").(string)
+		sshPort := settings.get(resourceData, "ssh_port").(int)
+
+		var sshHostKeyFingerprints []string
+		for _, fingerprint := range settings.get(resourceData, "ssh_host_key_fingerprints").([]interface{}) {
+			sshHostKeyFingerprints = append(sshHostKeyFingerprints, fingerprint.(string))
+		}
+
+		var sshBastions []SSHBastionConfig
+		for _, value := range resourceData.Get("ssh_bastion").([]interface{}) {
+			bastion := value.(map[string]interface{})
+
+			var fingerprints []string
+			for _, fingerprint := range bastion["host_key_fingerprints"].([]interface{}) {
+				fingerprints = append(fingerprints, fingerprint.(string))
+			}
+
+			sshBastions = append(sshBastions, SSHBastionConfig{
+				Host:                 bastion["host"].(string),
+				Port:                 bastion["port"].(int),
+				User:                 bastion["user"].(string),
+				Password:             bastion["password"].(string),
+				PrivateKey:           bastion["private_key"].(string),
+				PrivateKeyPath:       bastion["private_key_path"].(string),
+				PrivateKeyPassphrase: bastion["private_key_passphrase"].(string),
+				Certificate:          bastion["certificate"].(string),
+				CertificatePath:      bastion["certificate_path"].(string),
+				UseAgent:             bastion["use_agent"].(bool),
+				HostKeyFingerprints:  fingerprints,
+			})
+		}
+
+		retryPolicy, err := expandRetryPolicy(resourceData.Get("retry").([]interface{}))
+		if err != nil {
+			return nil, diag.FromErr(err)
+		}
 
 		// Use fallback values if SSH-specific fields are not set
 		if sshUser == "" {
-			sshUser = resourceData.Get("user").(string)
+			sshUser = settings.get(resourceData, "user").(string)
 		}
 		if sshHost == "" {
-			sshHost = resourceData.Get("host").(string)
+			sshHost = settings.get(resourceData, "host").(string)
 		}
-		if sshPassword == "" && sshPrivateKey == "" && sshPrivateKeyPath == "" {
+		if sshPassword == "" && sshPrivateKey == "" && sshPrivateKeyPath == "" && !sshUseAgent {
 			// If no SSH-specific auth is provided, try password from general config
-			sshPassword = resourceData.Get("password").(string)
+			sshPassword = settings.get(resourceData, "password").(string)
 		}
 
 		config := Config{
 			Version:           version,
 			Commit:            commit,
 			TerraformVersion:  terraformVersion,
-			User:              resourceData.Get("user").(string),
-			Password:          resourceData.Get("password").(string),
-			Host:              resourceData.Get("host").(string),
-			Port:              resourceData.Get("port").(int),
-			HTTPS:             resourceData.Get("https").(bool),
+			User:              settings.get(resourceData, "user").(string),
+			Password:          settings.get(resourceData, "password").(string),
+			Host:              settings.get(resourceData, "host").(string),
+			Port:              settings.get(resourceData, "port").(int),
+			HTTPS:             settings.get(resourceData, "https").(bool),
 			CACert:            cacert,
 			Cert:              cert,
 			Key:               key,
-			Insecure:          resourceData.Get("insecure").(bool),
-			NTLM:              resourceData.Get("use_ntlm").(bool),
-			KrbRealm:          resourceData.Get("kerberos_realm").(string),
-			KrbSpn:            resourceData.Get("kerberos_service_principal_name").(string),
-			KrbConfig:         resourceData.Get("kerberos_config").(string),
-			KrbCCache:         resourceData.Get("kerberos_credential_cache").(string),
-			TLSServerName:     resourceData.Get("tls_server_name").(string),
-			ScriptPath:        resourceData.Get("script_path").(string),
-			Timeout:           resourceData.Get("timeout").(string),
+			Insecure:          settings.get(resourceData, "insecure").(bool),
+			NTLM:              settings.get(resourceData, "use_ntlm").(bool),
+			MessageEncryption: settings.get(resourceData, "message_encryption").(string),
+			KrbRealm:          settings.get(resourceData, "kerberos_realm").(string),
+			KrbSpn:            settings.get(resourceData, "kerberos_service_principal_name").(string),
+			KrbConfig:         settings.get(resourceData, "kerberos_config").(string),
+			KrbCCache:         settings.get(resourceData, "kerberos_credential_cache").(string),
+			TLSServerName:     settings.get(resourceData, "tls_server_name").(string),
+			ScriptPath:        settings.get(resourceData, "script_path").(string),
+			Timeout:           settings.get(resourceData, "timeout").(string),
 			SSH:               useSSH,
 			SSHUser:           sshUser,
 			SSHPassword:       sshPassword,
@@ -365,6 +716,37 @@ func configure(version string, commit string, provider *schema.Provider) func(co
 			SSHPrivateKeyPath: sshPrivateKeyPath,
 			SSHHost:           sshHost,
 			SSHPort:           sshPort,
+
+			SSHKnownHostsPath:      settings.get(resourceData, "ssh_known_hosts_path").(string),
+			SSHKnownHosts:          settings.get(resourceData, "ssh_known_hosts").(string),
+			SSHHostKeyFingerprints: sshHostKeyFingerprints,
+			SSHTrustOnFirstUse:     settings.get(resourceData, "ssh_trust_on_first_use").(bool),
+
+			SSHPrivateKeyPassphrase: settings.get(resourceData, "ssh_private_key_passphrase").(string),
+			SSHCertificate:          settings.get(resourceData, "ssh_certificate").(string),
+			SSHCertificatePath:      settings.get(resourceData, "ssh_certificate_path").(string),
+			SSHUseAgent:             sshUseAgent,
+			SSHBastions:             sshBastions,
+			SSHPowerShell:           settings.get(resourceData, "ssh_powershell").(string),
+			SSHPersistentSession:    settings.get(resourceData, "ssh_persistent_session").(bool),
+
+			Transport:       transport,
+			LocalPowerShell: settings.get(resourceData, "local_powershell").(string),
+
+			Backend: backend,
+
+			CassettePath: cassettePath,
+			CassetteMode: cassetteMode,
+
+			CredentialHelper: settings.get(resourceData, "credential_helper").(string),
+
+			ProxyURL: settings.get(resourceData, "proxy_url").(string),
+
+			Retry: retryPolicy,
+		}
+
+		if err := config.validate(); err != nil {
+			return nil, diag.FromErr(err)
 		}
 
 		client, err := config.Client()
@@ -372,6 +754,36 @@ func configure(version string, commit string, provider *schema.Provider) func(co
 			return nil, diag.FromErr(err)
 		}
 
-		return client, diags
+		return newClientRegistry(config, client), diags
+	}
+}
+
+// expandRetryPolicy returns the retry policy configured by the retry block, or the default policy without one.
+func expandRetryPolicy(values []interface{}) (retry.Policy, error) {
+	policy := retry.DefaultPolicy()
+	if len(values) == 0 || values[0] == nil {
+		return policy, nil
+	}
+
+	value := values[0].(map[string]interface{})
+	policy.MaxAttempts = value["max_attempts"].(int)
+
+	durations := map[string]*time.Duration{
+		"initial_interval": &policy.InitialInterval,
+		"max_interval":     &policy.MaxInterval,
+		"budget":           &policy.Budget,
+	}
+	for key, duration := range durations {
+		var err error
+		*duration, err = time.ParseDuration(value[key].(string))
+		if err != nil {
+			return policy, fmt.Errorf("couldn't parse retry %s \"%s\": %w", key, value[key], err)
+		}
+	}
+
+	if policy.MaxAttempts == 0 && policy.Budget == 0 {
+		return policy, fmt.Errorf("retries need a limit, set `max_attempts` or `budget` of the `retry` block")
 	}
+
+	return policy, nil
 }
@@ -4,6 +4,7 @@
 package provider
 
 import (
+	"hash/fnv"
 	"math/rand"
 	"os"
 	"path/filepath"
@@ -12,6 +13,7 @@ import (
 	"time"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/taliesins/terraform-provider-hyperv/api/cassette"
 )
 
 var (
@@ -45,6 +47,32 @@ func testAccPreCheck(t *testing.T) {
 		t.Fatalf("TF_ACC must be set for acceptance tests")
 	}
 
+	if mode := os.Getenv(cassette.EnvMode); mode != "" && os.Getenv(cassette.EnvPath) == "" {
+		// Each test records to and replays from its own cassette when no cassette is set
+		path := testAccCassettePath(t)
+		switch mode {
+		case cassette.ModeRecord:
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatalf("failed to create the cassette directory: %v", err)
+			}
+		case cassette.ModeReplay:
+			if _, err := os.Stat(path); os.IsNotExist(err) {
+				t.Skipf("no cassette recorded for %s at %s", t.Name(), path)
+			}
+		}
+		t.Setenv(cassette.EnvPath, path)
+	}
+
+	if os.Getenv(cassette.EnvMode) == cassette.ModeReplay {
+		// Replayed operations are served from the cassette, no Hyper-V host is needed
+		return
+	}
+
+	if strings.EqualFold(os.Getenv("HYPERV_TRANSPORT"), "local") {
+		// The local transport runs PowerShell on the Hyper-V host running the tests, no credentials are needed
+		return
+	}
+
 	if strings.EqualFold(os.Getenv("HYPERV_SSH"), "true") {
 		if !hasAnyEnv("HYPERV_SSH_HOST", "HYPERV_HOST") {
 			t.Fatalf("acceptance tests require HYPERV_SSH_HOST or HYPERV_HOST when HYPERV_SSH=true")
@@ -95,9 +123,37 @@ func escapeForHcl(value string) string {
 	return strings.ReplaceAll(value, "\\", "\\\\")
 }
 
-func randInt() int {
-	rand.Seed(time.Now().UnixNano())
+// testAccCassettePath returns the cassette of the test under testdata/cassettes, used when HYPERV_CASSETTE is not set.
+func testAccCassettePath(t *testing.T) string {
+	t.Helper()
+
+	return filepath.Join("testdata", "cassettes", strings.ReplaceAll(t.Name(), "/", "_")+".json")
+}
+
+// testAccPath returns the path of name on the Hyper-V host. While a cassette is in use the path is the same on every
+// machine, so that the scripts rendered when replaying on Linux match the scripts recorded from Windows.
+func testAccPath(name string) string {
+	if os.Getenv(cassette.EnvMode) != "" {
+		return `C:\Temp\terraform-provider-hyperv\` + name
+	}
+
+	//tempDirectory := os.TempDir() uses short name ;<
+	tempDirectory, _ := filepath.Abs(".")
+	path, _ := filepath.Abs(filepath.Join(tempDirectory, name))
+	return path
+}
+
+// randInt generates a number for the names of test resources. While a cassette is in use the numbers of each test are
+// seeded with its name, so that the rendered scripts match whichever tests are run.
+func randInt(t *testing.T) int {
 	min := 100
 	max := 999
+	if os.Getenv(cassette.EnvMode) != "" {
+		seed := fnv.New64a()
+		_, _ = seed.Write([]byte(t.Name()))
+		return rand.New(rand.NewSource(int64(seed.Sum64()))).Intn(max-min+1) + min
+	}
+
+	rand.Seed(time.Now().UnixNano())
 	return rand.Intn(max-min+1) + min
 }
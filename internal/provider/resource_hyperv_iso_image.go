@@ -15,6 +15,7 @@ import (
 	"time"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/customdiff"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/taliesins/terraform-provider-hyperv/api"
 )
@@ -31,7 +32,7 @@ func resourceHyperVIsoImage() *schema.Resource {
 		// Compute hashes for local source files during diff so changes to the
 		// local file contents are detected (when the corresponding hash
 		// attribute has not been provided by the user).
-		CustomizeDiff: func(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+		CustomizeDiff: customdiff.All(customizeHostDiff, func(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
 			computeIfMissing := func(sourceKey, hashKey string) error {
 				v, ok := d.GetOk(sourceKey)
 				if !ok {
@@ -106,7 +107,7 @@ func resourceHyperVIsoImage() *schema.Resource {
 			}
 
 			return nil
-		},
+		}),
 		Description: "This resource allows you to manage ISOs.",
 		Timeouts: &schema.ResourceTimeout{
 			Read:   schema.DefaultTimeout(ReadIsoImageTimeout),
@@ -119,9 +120,11 @@ func resourceHyperVIsoImage() *schema.Resource {
 		UpdateContext: resourceHyperVIsoImageUpdate,
 		DeleteContext: resourceHyperVIsoImageDelete,
 		Importer: &schema.ResourceImporter{
-			StateContext: schema.ImportStatePassthroughContext,
+			StateContext: importStateWithHost,
 		},
 		Schema: map[string]*schema.Schema{
+			"host": hostSchema(true),
+
 			"source_iso_file_path": {
 				Type:        schema.TypeString,
 				Optional:    true,
@@ -399,7 +402,10 @@ func ensureFileStateCreate(ctx context.Context, d *schema.ResourceData, c api.Cl
 
 func resourceHyperVIsoImageCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	log.Printf("[INFO][iso-image][create] creating remote iso: %#v", d)
-	c := meta.(api.Client)
+	c, err := clientFor(meta, d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
 
 	sourceIsoFilePath := (d.Get("source_iso_file_path")).(string)
 	sourceIsoFilePathHash := (d.Get("source_iso_file_path_hash")).(string)
@@ -456,7 +462,10 @@ func resourceHyperVIsoImageCreate(ctx context.Context, d *schema.ResourceData, m
 
 func resourceHyperVIsoImageRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	log.Printf("[INFO][iso-image][read] reading remote iso: %#v", d)
-	c := meta.(api.Client)
+	c, err := clientFor(meta, d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
 
 	destinationIsoFilePath := d.Id()
 
@@ -655,7 +664,10 @@ func ensureFileStateUpdate(ctx context.Context, d *schema.ResourceData, c api.Cl
 func resourceHyperVIsoImageUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	var err error
 	log.Printf("[INFO][iso-image][update] updating remote iso: %#v", d)
-	c := meta.(api.Client)
+	c, err := clientFor(meta, d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
 
 	destinationIsoFilePath := d.Id()
 
@@ -722,7 +734,10 @@ func resourceHyperVIsoImageUpdate(ctx context.Context, d *schema.ResourceData, m
 }
 
 func resourceHyperVIsoImageDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
-	c := meta.(api.Client)
+	c, err := clientFor(meta, d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
 
 	if d.Get("keep_on_destroy").(bool) {
 		log.Printf("[INFO][iso-image][delete] keep_on_destroy is true - preserving remote files")
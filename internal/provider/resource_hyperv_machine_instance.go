@@ -38,9 +38,12 @@ func resourceHyperVMachineInstance() *schema.Resource {
 		UpdateContext: resourceHyperVMachineInstanceUpdate,
 		DeleteContext: resourceHyperVMachineInstanceDelete,
 		Importer: &schema.ResourceImporter{
-			StateContext: schema.ImportStatePassthroughContext,
+			StateContext: importStateWithHost,
 		},
+		CustomizeDiff: customizeHostDiff,
 		Schema: map[string]*schema.Schema{
+			"host": hostSchema(true),
+
 			"name": {
 				Type:        schema.TypeString,
 				Required:    true,
@@ -912,7 +915,10 @@ func resourceHyperVMachineInstance() *schema.Resource {
 
 func resourceHyperVMachineInstanceCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	log.Printf("[INFO][hyperv][create] creating hyperv machine: %#v", d)
-	client := meta.(api.Client)
+	client, err := clientFor(meta, d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
 
 	name := ""
 
@@ -1052,7 +1058,10 @@ func resourceHyperVMachineInstanceCreate(ctx context.Context, d *schema.Resource
 
 func resourceHyperVMachineInstanceRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	log.Printf("[INFO][hyperv][read] reading hyperv machine: %#v", d)
-	client := meta.(api.Client)
+	client, err := clientFor(meta, d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
 
 	name := d.Id()
 
@@ -1255,7 +1264,10 @@ func resourceHyperVMachineInstanceRead(ctx context.Context, d *schema.ResourceDa
 
 func resourceHyperVMachineInstanceUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	log.Printf("[INFO][hyperv][update] updating hyperv machine: %#v", d)
-	client := meta.(api.Client)
+	client, err := clientFor(meta, d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
 
 	name := d.Id()
 
@@ -1442,7 +1454,10 @@ func resourceHyperVMachineInstanceUpdate(ctx context.Context, d *schema.Resource
 func resourceHyperVMachineInstanceDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	log.Printf("[INFO][hyperv][delete] deleting hyperv machine: %#v", d)
 
-	client := meta.(api.Client)
+	client, err := clientFor(meta, d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
 
 	name := d.Id()
 
@@ -0,0 +1,53 @@
+//go:build integration
+// +build integration
+
+package provider
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+)
+
+func TestAccHyperVMachineInstance_basic(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	name := fmt.Sprintf("acc-vm-%d", randInt(t))
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:          func() { testAccPreCheck(t) },
+		ProviderFactories: providerFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccHyperVMachineInstanceConfigBasic(name, 1),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("hyperv_machine_instance.this", "name", name),
+					resource.TestCheckResourceAttr("hyperv_machine_instance.this", "state", "Off"),
+					resource.TestCheckResourceAttr("hyperv_machine_instance.this", "processor_count", "1"),
+				),
+			},
+			{
+				Config: testAccHyperVMachineInstanceConfigBasic(name, 2),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("hyperv_machine_instance.this", "processor_count", "2"),
+				),
+			},
+		},
+	})
+}
+
+func testAccHyperVMachineInstanceConfigBasic(name string, processorCount int) string {
+	return fmt.Sprintf(`
+resource "hyperv_machine_instance" "this" {
+  name                 = "%s"
+  generation           = 2
+  processor_count      = %d
+  static_memory        = true
+  memory_startup_bytes = 536870912
+  state                = "Off"
+}
+`, escapeForHcl(name), processorCount)
+}
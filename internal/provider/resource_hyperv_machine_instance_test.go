@@ -1,7 +1,15 @@
 package provider
 
 import (
+	"context"
+	"errors"
+	"strings"
 	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"github.com/taliesins/terraform-provider-hyperv/api"
+	"github.com/taliesins/terraform-provider-hyperv/api/fake"
 )
 
 // TestResourceHyperVMachineInstanceSchema_PathFieldsDiffSuppressFunc verifies that
@@ -182,3 +190,137 @@ func TestResourceHyperVMachineInstanceSchema_PathFieldsAlsoHaveStateFunc(t *test
 		})
 	}
 }
+
+func newFakeHost(t *testing.T) *fake.Client {
+	t.Helper()
+
+	client := fake.New()
+	err := client.CreateVMSwitch(context.Background(), "lan", "", true, false, false, false, api.VMSwitchBandwidthMode_None, api.VMSwitchType_Internal, nil, 0, 0, false, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.CreateOrUpdateVhd(context.Background(), `C:\vhds\web.vhdx`, "", "", 0, api.VhdType_Dynamic, "", 10737418240, 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client
+}
+
+func fakeMachineInstanceConfig(processorCount int) map[string]interface{} {
+	return map[string]interface{}{
+		"name":            "web",
+		"generation":      2,
+		"static_memory":   true,
+		"processor_count": processorCount,
+		"network_adaptors": []interface{}{
+			map[string]interface{}{
+				"name":        "lan",
+				"switch_name": "lan",
+			},
+		},
+		"hard_disk_drives": []interface{}{
+			map[string]interface{}{
+				"controller_type":     "Scsi",
+				"controller_number":   0,
+				"controller_location": 0,
+				"path":                `C:\vhds\web.vhdx`,
+			},
+		},
+	}
+}
+
+func fakeMachineInstanceData(t *testing.T) *schema.ResourceData {
+	t.Helper()
+
+	d := schema.TestResourceDataRaw(t, resourceHyperVMachineInstance().Schema, fakeMachineInstanceConfig(1))
+	d.MarkNewResource()
+
+	return d
+}
+
+func TestResourceHyperVMachineInstance_FakeLifecycle(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	client := newFakeHost(t)
+
+	d := fakeMachineInstanceData(t)
+	if diags := resourceHyperVMachineInstanceCreate(ctx, d, client); diags.HasError() {
+		t.Fatalf("create failed: %v", diags)
+	}
+
+	if d.Id() != "web" {
+		t.Fatalf("expected the resource id to be the VM name, got %q", d.Id())
+	}
+	if state := d.Get("state").(string); state != api.VmState_name[api.VmState_Running] {
+		t.Fatalf("expected the VM to be running, got %s", state)
+	}
+	if ips := d.Get("network_adaptors.0.ip_addresses").([]interface{}); len(ips) != 1 {
+		t.Fatalf("expected the running VM to have an ip address, got %v", ips)
+	}
+
+	// Processor changes need the VM to be off, so update stops it, applies them and starts it again
+	resource := resourceHyperVMachineInstance()
+	state := d.State()
+	diff, err := resource.Diff(ctx, state, terraform.NewResourceConfigRaw(fakeMachineInstanceConfig(2)), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err = schema.InternalMap(resource.Schema).Data(state, diff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diags := resourceHyperVMachineInstanceUpdate(ctx, d, client); diags.HasError() {
+		t.Fatalf("update failed: %v", diags)
+	}
+
+	vm, err := client.GetVm(ctx, "web")
+	if err != nil || vm.ProcessorCount != 2 {
+		t.Fatalf("expected 2 processors, got %+v: %v", vm, err)
+	}
+	if status, err := client.GetVmStatus(ctx, "web"); err != nil || status.State != api.VmState_Running {
+		t.Fatalf("expected the VM to be running again, got %v: %v", status.State, err)
+	}
+
+	d = resourceHyperVMachineInstance().Data(d.State())
+	if diags := resourceHyperVMachineInstanceDelete(ctx, d, client); diags.HasError() {
+		t.Fatalf("delete failed: %v", diags)
+	}
+
+	if names := client.VmNames(); len(names) != 0 {
+		t.Fatalf("expected the VM to be deleted, got %v", names)
+	}
+	if exists, err := client.VhdExists(ctx, `C:\vhds\web.vhdx`); err != nil || !exists.Exists {
+		t.Fatalf("expected deleting the VM to leave its VHD: %v", err)
+	}
+}
+
+func TestResourceHyperVMachineInstance_FakePartialCreate(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	client := newFakeHost(t)
+	client.FailNext("CreateVmHardDiskDrive", errors.New("disk controller unavailable"))
+
+	d := fakeMachineInstanceData(t)
+	diags := resourceHyperVMachineInstanceCreate(ctx, d, client)
+	if !diags.HasError() || !strings.Contains(diags[0].Summary, "disk controller unavailable") {
+		t.Fatalf("expected the injected failure, got %v", diags)
+	}
+
+	// The VM was created before attaching its disk failed, so it is left behind without being in state
+	if d.Id() != "" {
+		t.Fatalf("expected no resource id after a failed create, got %q", d.Id())
+	}
+	if exists, err := client.VmExists(ctx, "web"); err != nil || !exists.Exists {
+		t.Fatalf("expected the partially created VM to be orphaned: %v", err)
+	}
+
+	d = fakeMachineInstanceData(t)
+	diags = resourceHyperVMachineInstanceCreate(ctx, d, client)
+	if !diags.HasError() || !strings.Contains(diags[0].Summary, "terraform import hyperv_machine_instance") {
+		t.Fatalf("expected retrying the create to ask for the orphan to be imported, got %v", diags)
+	}
+}
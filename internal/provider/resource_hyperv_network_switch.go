@@ -34,9 +34,12 @@ func resourceHyperVNetworkSwitch() *schema.Resource {
 		UpdateContext: resourceHyperVNetworkSwitchUpdate,
 		DeleteContext: resourceHyperVNetworkSwitchDelete,
 		Importer: &schema.ResourceImporter{
-			StateContext: schema.ImportStatePassthroughContext,
+			StateContext: importStateWithHost,
 		},
+		CustomizeDiff: customizeHostDiff,
 		Schema: map[string]*schema.Schema{
+			"host": hostSchema(true),
+
 			"name": {
 				Type:        schema.TypeString,
 				Required:    true,
@@ -146,7 +149,10 @@ func resourceHyperVNetworkSwitch() *schema.Resource {
 
 func resourceHyperVNetworkSwitchCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	log.Printf("[INFO][hyperv][create] creating hyperv switch: %#v", d)
-	c := meta.(api.Client)
+	c, err := clientFor(meta, d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
 
 	switchName := ""
 
@@ -237,7 +243,7 @@ func resourceHyperVNetworkSwitchCreate(ctx context.Context, d *schema.ResourceDa
 		return diag.Errorf("[ERROR][hyperv][create] defaultQueueVmmqQueuePairs must be greater then 0")
 	}
 
-	err := c.CreateVMSwitch(ctx, switchName, notes, allowManagementOS, embeddedTeamingEnabled, iovEnabled, packetDirectEnabled, bandwidthReservationMode, switchType, netAdapterNames, defaultFlowMinimumBandwidthAbsolute, defaultFlowMinimumBandwidthWeight, defaultQueueVmmqEnabled, defaultQueueVmmqQueuePairs, defaultQueueVrssEnabled)
+	err = c.CreateVMSwitch(ctx, switchName, notes, allowManagementOS, embeddedTeamingEnabled, iovEnabled, packetDirectEnabled, bandwidthReservationMode, switchType, netAdapterNames, defaultFlowMinimumBandwidthAbsolute, defaultFlowMinimumBandwidthWeight, defaultQueueVmmqEnabled, defaultQueueVmmqQueuePairs, defaultQueueVrssEnabled)
 
 	if err != nil {
 		return diag.FromErr(err)
@@ -251,7 +257,10 @@ func resourceHyperVNetworkSwitchCreate(ctx context.Context, d *schema.ResourceDa
 
 func resourceHyperVNetworkSwitchRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	log.Printf("[INFO][hyperv][read] reading hyperv switch: %#v", d)
-	c := meta.(api.Client)
+	c, err := clientFor(meta, d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
 
 	name := d.Id()
 
@@ -372,7 +381,10 @@ func resourceHyperVNetworkSwitchRead(ctx context.Context, d *schema.ResourceData
 
 func resourceHyperVNetworkSwitchUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	log.Printf("[INFO][hyperv][update] updating hyperv switch: %#v", d)
-	c := meta.(api.Client)
+	c, err := clientFor(meta, d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
 
 	id := d.Id()
 	newName := d.Get("name").(string)
@@ -447,7 +459,7 @@ func resourceHyperVNetworkSwitchUpdate(ctx context.Context, d *schema.ResourceDa
 		return diag.Errorf("[ERROR][hyperv][update] defaultQueueVmmqQueuePairs must be greater then 0")
 	}
 
-	err := c.UpdateVMSwitch(ctx, id, newName, notes, allowManagementOS, switchType, netAdapterNames, defaultFlowMinimumBandwidthAbsolute, defaultFlowMinimumBandwidthWeight, defaultQueueVmmqEnabled, defaultQueueVmmqQueuePairs, defaultQueueVrssEnabled)
+	err = c.UpdateVMSwitch(ctx, id, newName, notes, allowManagementOS, switchType, netAdapterNames, defaultFlowMinimumBandwidthAbsolute, defaultFlowMinimumBandwidthWeight, defaultQueueVmmqEnabled, defaultQueueVmmqQueuePairs, defaultQueueVrssEnabled)
 
 	if err != nil {
 		return diag.FromErr(err)
@@ -463,10 +475,13 @@ func resourceHyperVNetworkSwitchUpdate(ctx context.Context, d *schema.ResourceDa
 func resourceHyperVNetworkSwitchDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	log.Printf("[INFO][hyperv][delete] deleting hyperv switch: %#v", d)
 
-	c := meta.(api.Client)
+	c, err := clientFor(meta, d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
 
 	switchName := d.Id()
-	err := c.DeleteVMSwitch(ctx, switchName)
+	err = c.DeleteVMSwitch(ctx, switchName)
 
 	if err != nil {
 		return diag.FromErr(err)
@@ -15,7 +15,7 @@ func TestAccHyperVNetworkSwitch_basic(t *testing.T) {
 		t.Skip("skipping test in short mode")
 	}
 
-	name := fmt.Sprintf("acc-switch-%d", randInt())
+	name := fmt.Sprintf("acc-switch-%d", randInt(t))
 
 	resource.Test(t, resource.TestCase{
 		PreCheck:          func() { testAccPreCheck(t) },
@@ -35,9 +35,12 @@ func resourceHyperVVhd() *schema.Resource {
 		UpdateContext: resourceHyperVVhdUpdate,
 		DeleteContext: resourceHyperVVhdDelete,
 		Importer: &schema.ResourceImporter{
-			StateContext: schema.ImportStatePassthroughContext,
+			StateContext: importStateWithHost,
 		},
+		CustomizeDiff: customizeHostDiff,
 		Schema: map[string]*schema.Schema{
+			"host": hostSchema(true),
+
 			"path": {
 				Type:     schema.TypeString,
 				Required: true,
@@ -199,7 +202,10 @@ func resourceHyperVVhd() *schema.Resource {
 
 func resourceHyperVVhdCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	log.Printf("[INFO][hyperv][create] creating hyperv vhd: %#v", d)
-	c := meta.(api.Client)
+	c, err := clientFor(meta, d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
 
 	path := ""
 
@@ -230,7 +236,7 @@ func resourceHyperVVhdCreate(ctx context.Context, d *schema.ResourceData, meta i
 	logicalSectorSize := uint32((d.Get("logical_sector_size")).(int))
 	physicalSectorSize := uint32((d.Get("physical_sector_size")).(int))
 
-	err := c.CreateOrUpdateVhd(ctx, path, source, sourceVm, sourceDisk, vhdType, parentPath, size, blockSize, logicalSectorSize, physicalSectorSize)
+	err = c.CreateOrUpdateVhd(ctx, path, source, sourceVm, sourceDisk, vhdType, parentPath, size, blockSize, logicalSectorSize, physicalSectorSize)
 
 	if err != nil {
 		return diag.FromErr(err)
@@ -253,7 +259,10 @@ func resourceHyperVVhdCreate(ctx context.Context, d *schema.ResourceData, meta i
 
 func resourceHyperVVhdRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	log.Printf("[INFO][hyperv][read] reading hyperv vhd: %#v", d)
-	c := meta.(api.Client)
+	c, err := clientFor(meta, d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
 
 	path := d.Id()
 
@@ -313,7 +322,10 @@ func resourceHyperVVhdRead(ctx context.Context, d *schema.ResourceData, meta int
 
 func resourceHyperVVhdUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	log.Printf("[INFO][hyperv][update] updating hyperv vhd: %#v", d)
-	c := meta.(api.Client)
+	c, err := clientFor(meta, d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
 
 	path := d.Id()
 
@@ -357,11 +369,14 @@ func resourceHyperVVhdUpdate(ctx context.Context, d *schema.ResourceData, meta i
 func resourceHyperVVhdDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	log.Printf("[INFO][hyperv][delete] deleting hyperv vhd: %#v", d)
 
-	c := meta.(api.Client)
+	c, err := clientFor(meta, d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
 
 	path := d.Id()
 
-	err := c.DeleteVhd(ctx, path)
+	err = c.DeleteVhd(ctx, path)
 
 	if err != nil {
 		return diag.FromErr(err)
@@ -5,7 +5,6 @@ package provider
 
 import (
 	"fmt"
-	"path/filepath"
 	"testing"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
@@ -16,8 +15,7 @@ func TestAccHyperVVhd_lifecycle(t *testing.T) {
 		t.Skip("skipping test in short mode")
 	}
 
-	tempDirectory, _ := filepath.Abs(".")
-	vhdPath, _ := filepath.Abs(filepath.Join(tempDirectory, fmt.Sprintf("testacchypervvhd_%d.vhdx", randInt())))
+	vhdPath := testAccPath(fmt.Sprintf("testacchypervvhd_%d.vhdx", randInt(t)))
 
 	resource.Test(t, resource.TestCase{
 		PreCheck:          func() { testAccPreCheck(t) },
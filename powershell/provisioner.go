@@ -8,14 +8,24 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/masterzen/winrm"
 	"github.com/segmentio/ksuid"
+	"github.com/taliesins/terraform-provider-hyperv/api/redact"
+)
+
+const (
+	defaultTaskExecutionTimeLimit = "PT2H"
+
+	interruptedRunCleanupTimeout = 30 * time.Second
 )
 
 func TimeOrderedUUID() string {
@@ -35,13 +45,13 @@ func winPath(path string) string {
 	return strings.ReplaceAll(path, "/", "\\")
 }
 
-func doCopy(client *winrm.Client, maxChunks int, in io.Reader, toPath string) (remoteAbsolutePath string, err error) {
+func doCopy(ctx context.Context, client *winrm.Client, redactor *redact.Redactor, maxChunks int, in io.Reader, toPath string) (remoteAbsolutePath string, err error) {
 	tempFile := fmt.Sprintf("terraform-%s", TimeOrderedUUID())
 	tempPath := fmt.Sprintf(`%s\%s`, `$env:TEMP`, tempFile)
 	if os.Getenv("WINRMCP_DEBUG") != "" {
 		log.Printf("[DEBUG] Resolving remote temp path of [%s]", tempPath)
 	}
-	tempPath, err = ResolvePath(client, tempPath)
+	tempPath, err = ResolvePath(ctx, client, redactor, tempPath)
 	if err != nil {
 		return "", err
 	}
@@ -52,7 +62,7 @@ func doCopy(client *winrm.Client, maxChunks int, in io.Reader, toPath string) (r
 	if os.Getenv("WINRMCP_DEBUG") != "" {
 		log.Printf("[DEBUG] Resolving remote to path of [%s]", toPath)
 	}
-	toPath, err = ResolvePath(client, toPath)
+	toPath, err = ResolvePath(ctx, client, redactor, toPath)
 	if err != nil {
 		return "", err
 	}
@@ -63,7 +73,7 @@ func doCopy(client *winrm.Client, maxChunks int, in io.Reader, toPath string) (r
 	if os.Getenv("WINRMCP_DEBUG") != "" {
 		log.Printf("[DEBUG] Uploading file to %s", tempPath)
 	}
-	err = uploadContent(client, maxChunks, in, tempPath)
+	err = uploadContent(ctx, client, redactor, maxChunks, in, tempPath)
 	if err != nil {
 		return "", fmt.Errorf("error uploading file to %s: %v", tempPath, err)
 	}
@@ -71,7 +81,7 @@ func doCopy(client *winrm.Client, maxChunks int, in io.Reader, toPath string) (r
 	if os.Getenv("WINRMCP_DEBUG") != "" {
 		log.Printf("[DEBUG] Moving file from %s to %s", tempPath, toPath)
 	}
-	remoteAbsolutePath, err = restoreContent(client, tempPath, toPath)
+	remoteAbsolutePath, err = restoreContent(ctx, client, redactor, tempPath, toPath)
 	if err != nil {
 		return "", fmt.Errorf("error restoring file from %s to %s: %v", tempPath, toPath, err)
 	}
@@ -79,7 +89,7 @@ func doCopy(client *winrm.Client, maxChunks int, in io.Reader, toPath string) (r
 	if os.Getenv("WINRMCP_DEBUG") != "" {
 		log.Printf("[DEBUG] Removing temporary file %s", tempPath)
 	}
-	err = DeleteFileOrDirectory(client, tempPath)
+	err = DeleteFileOrDirectory(ctx, client, redactor, tempPath)
 	if err != nil {
 		return "", fmt.Errorf("error removing temporary file %s: %v", tempPath, err)
 	}
@@ -87,11 +97,11 @@ func doCopy(client *winrm.Client, maxChunks int, in io.Reader, toPath string) (r
 	return remoteAbsolutePath, nil
 }
 
-func uploadContent(client *winrm.Client, maxChunks int, in io.Reader, toPath string) error {
+func uploadContent(ctx context.Context, client *winrm.Client, redactor *redact.Redactor, maxChunks int, in io.Reader, toPath string) error {
 	var err error
 	done := false
 	for !done {
-		done, err = uploadChunks(client, maxChunks, in, toPath)
+		done, err = uploadChunks(ctx, client, redactor, maxChunks, in, toPath)
 		if err != nil {
 			return err
 		}
@@ -100,7 +110,7 @@ func uploadContent(client *winrm.Client, maxChunks int, in io.Reader, toPath str
 	return nil
 }
 
-func uploadChunks(client *winrm.Client, maxChunks int, in io.Reader, toPath string) (bool, error) {
+func uploadChunks(ctx context.Context, client *winrm.Client, redactor *redact.Redactor, maxChunks int, in io.Reader, toPath string) (bool, error) {
 	shell, err := client.CreateShell()
 	if err != nil {
 		return false, fmt.Errorf("couldn't create shell: %v", err)
@@ -136,7 +146,7 @@ func uploadChunks(client *winrm.Client, maxChunks int, in io.Reader, toPath stri
 		}
 
 		content := base64.StdEncoding.EncodeToString(chunk[:n])
-		if err = appendContent(shell, toPath, content); err != nil {
+		if err = appendContent(ctx, shell, redactor, toPath, content); err != nil {
 			return false, err
 		}
 	}
@@ -144,7 +154,7 @@ func uploadChunks(client *winrm.Client, maxChunks int, in io.Reader, toPath stri
 	return false, nil
 }
 
-func restoreContent(client *winrm.Client, fromPath, toPath string) (string, error) {
+func restoreContent(ctx context.Context, client *winrm.Client, redactor *redact.Redactor, fromPath, toPath string) (string, error) {
 	shell, err := client.CreateShell()
 	if err != nil {
 		return "", err
@@ -174,7 +184,7 @@ func restoreContent(client *winrm.Client, fromPath, toPath string) (string, erro
 
 	script = executePowershellFromCommandLineTemplateRendered.String()
 
-	commandExitCode, stdOutPut, errorOutPut, err := shellExecute(shell, script)
+	commandExitCode, stdOutPut, errorOutPut, err := shellExecute(ctx, shell, redactor, script)
 
 	if err != nil {
 		return "", err
@@ -191,7 +201,7 @@ func restoreContent(client *winrm.Client, fromPath, toPath string) (string, erro
 	return stdOutPut, nil
 }
 
-func ResolvePath(client *winrm.Client, filePath string) (string, error) {
+func ResolvePath(ctx context.Context, client *winrm.Client, redactor *redact.Redactor, filePath string) (string, error) {
 	shell, err := client.CreateShell()
 	if err != nil {
 		return "", err
@@ -220,7 +230,7 @@ func ResolvePath(client *winrm.Client, filePath string) (string, error) {
 
 	script = executePowershellFromCommandLineTemplateRendered.String()
 
-	commandExitCode, stdOutPut, errorOutPut, err := shellExecute(shell, script)
+	commandExitCode, stdOutPut, errorOutPut, err := shellExecute(ctx, shell, redactor, script)
 
 	if err != nil {
 		return "", err
@@ -237,7 +247,7 @@ func ResolvePath(client *winrm.Client, filePath string) (string, error) {
 	return stdOutPut, nil
 }
 
-func FileExists(client *winrm.Client, filePath string) (bool, error) {
+func FileExists(ctx context.Context, client *winrm.Client, redactor *redact.Redactor, filePath string) (bool, error) {
 	shell, err := client.CreateShell()
 	if err != nil {
 		return false, err
@@ -266,7 +276,7 @@ func FileExists(client *winrm.Client, filePath string) (bool, error) {
 
 	script = executePowershellFromCommandLineTemplateRendered.String()
 
-	commandExitCode, stdOutPut, errorOutPut, err := shellExecute(shell, script)
+	commandExitCode, stdOutPut, errorOutPut, err := shellExecute(ctx, shell, redactor, script)
 
 	if err != nil {
 		return false, err
@@ -288,7 +298,7 @@ func FileExists(client *winrm.Client, filePath string) (bool, error) {
 	return result, nil
 }
 
-func DirectoryExists(client *winrm.Client, directoryPath string) (bool, error) {
+func DirectoryExists(ctx context.Context, client *winrm.Client, redactor *redact.Redactor, directoryPath string) (bool, error) {
 	shell, err := client.CreateShell()
 	if err != nil {
 		return false, err
@@ -317,7 +327,7 @@ func DirectoryExists(client *winrm.Client, directoryPath string) (bool, error) {
 
 	script = executePowershellFromCommandLineTemplateRendered.String()
 
-	commandExitCode, stdOutPut, errorOutPut, err := shellExecute(shell, script)
+	commandExitCode, stdOutPut, errorOutPut, err := shellExecute(ctx, shell, redactor, script)
 
 	if err != nil {
 		return false, err
@@ -339,7 +349,7 @@ func DirectoryExists(client *winrm.Client, directoryPath string) (bool, error) {
 	return result, nil
 }
 
-func DeleteFileOrDirectory(client *winrm.Client, filePath string) error {
+func DeleteFileOrDirectory(ctx context.Context, client *winrm.Client, redactor *redact.Redactor, filePath string) error {
 	shell, err := client.CreateShell()
 	if err != nil {
 		return err
@@ -368,7 +378,7 @@ func DeleteFileOrDirectory(client *winrm.Client, filePath string) error {
 
 	script = executePowershellFromCommandLineTemplateRendered.String()
 
-	commandExitCode, stdOutPut, errorOutPut, err := shellExecute(shell, script)
+	commandExitCode, stdOutPut, errorOutPut, err := shellExecute(ctx, shell, redactor, script)
 
 	if err != nil {
 		return err
@@ -385,7 +395,7 @@ func DeleteFileOrDirectory(client *winrm.Client, filePath string) error {
 	return nil
 }
 
-func appendContent(shell *winrm.Shell, filePath, content string) error {
+func appendContent(ctx context.Context, shell *winrm.Shell, redactor *redact.Redactor, filePath, content string) error {
 	var appendFileTemplateRendered bytes.Buffer
 	err := appendFileTemplate.Execute(&appendFileTemplateRendered, appendFileTemplateOptions{
 		FilePath: filePath,
@@ -398,7 +408,8 @@ func appendContent(shell *winrm.Shell, filePath, content string) error {
 
 	script := appendFileTemplateRendered.String()
 
-	commandExitCode, stdOutPut, errorOutPut, err := shellExecute(shell, script)
+	// The content is encoded in chunks, the secrets it holds are masked as a whole
+	commandExitCode, stdOutPut, errorOutPut, err := shellExecute(ctx, shell, redactor.With(content), script)
 
 	if err != nil {
 		return err
@@ -415,10 +426,24 @@ func appendContent(shell *winrm.Shell, filePath, content string) error {
 	return nil
 }
 
-func shellExecute(shell *winrm.Shell, command string) (int, string, string, error) {
+func shellExecute(ctx context.Context, shell *winrm.Shell, redactor *redact.Redactor, command string) (int, string, string, error) {
+	return shellExecuteStreaming(ctx, shell, redactor, command, nil)
+}
+
+// shellExecuteStreaming runs a command like shellExecute, copying its stdout to output, if any, while it runs. The
+// command and its output are masked with redactor before they are logged or echoed.
+func shellExecuteStreaming(ctx context.Context, shell *winrm.Shell, redactor *redact.Redactor, command string, output io.Writer) (int, string, string, error) {
 	stdOutBytes := new(bytes.Buffer)
 	stdErrBytes := new(bytes.Buffer)
 
+	stdOutEcho := newRedactingWriter(redactor, os.Stdout)
+	stdErrEcho := newRedactingWriter(redactor, os.Stderr)
+
+	var stdOutWriter io.Writer = stdOutEcho
+	if output != nil {
+		stdOutWriter = io.MultiWriter(stdOutEcho, output)
+	}
+
 	stdOutFunc := func(bytesStdOutWriter io.Writer, osStdOutWriter io.Writer, commandStdOut io.Reader) {
 		stdOutReader := io.TeeReader(commandStdOut, bytesStdOutWriter)
 		_, _ = io.Copy(osStdOutWriter, stdOutReader)
@@ -430,10 +455,14 @@ func shellExecute(shell *winrm.Shell, command string) (int, string, string, erro
 	}
 
 	if os.Getenv("WINRMCP_DEBUG") != "" {
-		log.Printf("[DEBUG] Shell execute: %s", command)
+		log.Printf("[DEBUG] Shell execute: %s", redactor.String(command))
 	}
 
-	cmd, err := shell.ExecuteWithContext(context.Background(), command)
+	if err := ctx.Err(); err != nil {
+		return 0, "", "", err
+	}
+
+	cmd, err := shell.ExecuteWithContext(ctx, command)
 
 	if err != nil {
 		return 0, "", "", err
@@ -447,10 +476,29 @@ func shellExecute(shell *winrm.Shell, command string) (int, string, string, erro
 		}
 	}()
 
-	go stdOutFunc(stdOutBytes, os.Stdout, cmd.Stdout)
-	go stdErrFunc(stdErrBytes, os.Stderr, cmd.Stderr)
+	// The output is read until the streams are closed, which happens before the command is done
+	var copied sync.WaitGroup
+	copied.Add(2)
+	go func() {
+		defer copied.Done()
+		stdOutFunc(stdOutBytes, stdOutWriter, cmd.Stdout)
+	}()
+	go func() {
+		defer copied.Done()
+		stdErrFunc(stdErrBytes, stdErrEcho, cmd.Stderr)
+	}()
 
 	cmd.Wait()
+	copied.Wait()
+	stdOutEcho.Flush()
+	stdErrEcho.Flush()
+
+	if err := ctx.Err(); err != nil {
+		// The command was already terminated with a signal when the context was done
+		closed = true
+		return 0, "", "", fmt.Errorf("command interrupted: %w", err)
+	}
+
 	exitCode := cmd.ExitCode()
 
 	err = cmd.Close()
@@ -472,13 +520,45 @@ func shellExecute(shell *winrm.Shell, command string) (int, string, string, erro
 	stdErrString := strings.TrimSpace(stdErrBytes.String())
 
 	if os.Getenv("WINRMCP_DEBUG") != "" {
-		log.Printf("[DEBUG] Shell execute result: exitCode=%d stdOut=%s stdErr=%s", exitCode, stdOutString, stdErrString)
+		log.Printf("[DEBUG] Shell execute result: exitCode=%d stdOut=%s stdErr=%s", exitCode, redactor.String(stdOutString), redactor.String(stdErrString))
 	}
 
 	return exitCode, stdOutString, stdErrString, nil
 }
 
-func uploadScript(client *winrm.Client, fileName string, command string) (remoteAbsolutePath string, err error) {
+// redactingWriter masks the secrets of the lines written to it before passing them on, so that a secret is never split
+// between two writes. Flush passes on the last line when it has no line break.
+type redactingWriter struct {
+	redactor *redact.Redactor
+	out      io.Writer
+	line     []byte
+}
+
+func newRedactingWriter(redactor *redact.Redactor, out io.Writer) *redactingWriter {
+	return &redactingWriter{
+		redactor: redactor,
+		out:      out,
+	}
+}
+
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	w.line = append(w.line, p...)
+	if i := bytes.LastIndexByte(w.line, '\n'); i >= 0 {
+		_, _ = io.WriteString(w.out, w.redactor.String(string(w.line[:i+1])))
+		w.line = append(w.line[:0], w.line[i+1:]...)
+	}
+
+	return len(p), nil
+}
+
+func (w *redactingWriter) Flush() {
+	if len(w.line) > 0 {
+		_, _ = io.WriteString(w.out, w.redactor.String(string(w.line)))
+		w.line = nil
+	}
+}
+
+func uploadScript(ctx context.Context, client *winrm.Client, redactor *redact.Redactor, fileName string, command string) (remoteAbsolutePath string, err error) {
 	tmpFile, err := os.CreateTemp(os.TempDir(), fileName)
 	if err != nil {
 		return "", fmt.Errorf("error creating temp file: %s", err)
@@ -503,7 +583,7 @@ func uploadScript(client *winrm.Client, fileName string, command string) (remote
 
 	log.Printf("[DEBUG] Uploading shell wrapper for command from [%s] to [%s] ", tmpFile.Name(), remotePath)
 
-	remoteAbsolutePath, err = doCopy(client, 15, f, winPath(remotePath))
+	remoteAbsolutePath, err = doCopy(ctx, client, redactor, 15, f, winPath(remotePath))
 	if err != nil {
 		return "", fmt.Errorf("error uploading shell script: %s", err)
 	}
@@ -529,18 +609,34 @@ func createCommand(vars string, remotePath string) (commandText string, err erro
 	return commandText, err
 }
 
-func createElevatedCommand(client *winrm.Client, elevatedUser string, elevatedPassword string, vars string, remotePath string) (commandText string, elevatedRemotePath string, err error) {
-	elevatedRemotePath, err = generateElevatedRunner(client, elevatedUser, elevatedPassword, remotePath)
+func createElevatedCommand(ctx context.Context, client *winrm.Client, redactor *redact.Redactor, elevatedUser string, elevatedPassword string, vars string, remotePath string) (commandText string, elevatedRemotePath string, taskName string, err error) {
+	elevatedRemotePath, taskName, err = generateElevatedRunner(ctx, client, redactor, elevatedUser, elevatedPassword, remotePath)
 	if err != nil {
-		return "", "", fmt.Errorf("error generating elevated runner: %s", err)
+		return "", "", "", fmt.Errorf("error generating elevated runner: %w", err)
 	}
 
 	commandText, err = createCommand(vars, elevatedRemotePath)
 
-	return commandText, elevatedRemotePath, err
+	return commandText, elevatedRemotePath, taskName, err
 }
 
-func generateElevatedRunner(client *winrm.Client, elevatedUser string, elevatedPassword string, remotePath string) (elevatedRemotePath string, err error) {
+// taskExecutionTimeLimit returns the scheduled task execution time limit, bounded by the context
+// deadline so an elevated task never outlives the operation that started it.
+func taskExecutionTimeLimit(ctx context.Context) string {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return defaultTaskExecutionTimeLimit
+	}
+
+	remaining := int(math.Ceil(time.Until(deadline).Seconds()))
+	if remaining < 1 {
+		remaining = 1
+	}
+
+	return fmt.Sprintf("PT%dS", remaining)
+}
+
+func generateElevatedRunner(ctx context.Context, client *winrm.Client, redactor *redact.Redactor, elevatedUser string, elevatedPassword string, remotePath string) (elevatedRemotePath string, taskName string, err error) {
 	log.Printf("[DEBUG] Building elevated command wrapper for: %s", remotePath)
 
 	name := fmt.Sprintf("terraform-%s", TimeOrderedUUID())
@@ -552,45 +648,107 @@ func generateElevatedRunner(client *winrm.Client, elevatedUser string, elevatedP
 		Password:               elevatedPassword,
 		TaskDescription:        "Terraform elevated task",
 		TaskName:               name,
-		TaskExecutionTimeLimit: "PT2H",
+		TaskExecutionTimeLimit: taskExecutionTimeLimit(ctx),
 		ScriptPath:             remotePath,
 	})
 
 	if err != nil {
 		log.Printf("[ERROR] creating elevated command template: %v", err)
-		return "", err
+		return "", "", err
 	}
 
 	elevatedCommand := elevatedCommandTemplateRendered.String()
 
-	elevatedRemotePath, err = uploadScript(client, fileName, elevatedCommand)
+	elevatedRemotePath, err = uploadScript(ctx, client, redactor.With(elevatedPassword), fileName, elevatedCommand)
 	if err != nil {
-		return "", err
+		return "", "", err
+	}
+
+	return elevatedRemotePath, name, nil
+}
+
+// cleanupInterruptedRun stops the elevated task of an interrupted run and removes its script. It uses
+// its own context as the context of the run is already done.
+func cleanupInterruptedRun(client *winrm.Client, redactor *redact.Redactor, taskName string, path string) {
+	ctx, cancel := context.WithTimeout(context.Background(), interruptedRunCleanupTimeout)
+	defer cancel()
+
+	if taskName != "" {
+		var stopElevatedTaskTemplateRendered bytes.Buffer
+		err := stopElevatedTaskTemplate.Execute(&stopElevatedTaskTemplateRendered, stopElevatedTaskTemplateOptions{
+			TaskName: taskName,
+		})
+		if err == nil {
+			var executePowershellFromCommandLineTemplateRendered bytes.Buffer
+			err = executePowershellFromCommandLineTemplate.Execute(&executePowershellFromCommandLineTemplateRendered, executePowershellFromCommandLineTemplateOptions{
+				Powershell: stopElevatedTaskTemplateRendered.String(),
+			})
+			if err == nil {
+				var shell *winrm.Shell
+				shell, err = client.CreateShell()
+				if err == nil {
+					_, _, _, err = shellExecute(ctx, shell, redactor, executePowershellFromCommandLineTemplateRendered.String())
+					shell.Close()
+				}
+			}
+		}
+
+		if err != nil {
+			log.Printf("[WARN] Failed to stop elevated task %s of interrupted run: %v", taskName, err)
+		}
+	}
+
+	if err := DeleteFileOrDirectory(ctx, client, redactor, path); err != nil {
+		log.Printf("[WARN] Failed to remove script %s of interrupted run: %v", path, err)
 	}
+}
+
+// notStartedError is a failure of a run before its script was started, such as a failure to upload the script. The
+// run can be repeated without running the script twice.
+type notStartedError struct {
+	err error
+}
 
-	return elevatedRemotePath, nil
+func (e *notStartedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *notStartedError) Unwrap() error {
+	return e.err
+}
+
+// ScriptNotStarted tells the retries of the provider that the script can be run again, see retry.IsNotStarted.
+func (e *notStartedError) ScriptNotStarted() bool {
+	return true
 }
 
 // Run powershell
-func RunPowershell(client *winrm.Client, elevatedUser string, elevatedPassword string, vars string, commandText string) (exitStatus int, stdout string, stderr string, err error) {
+func RunPowershell(ctx context.Context, client *winrm.Client, redactor *redact.Redactor, elevatedUser string, elevatedPassword string, vars string, commandText string) (exitStatus int, stdout string, stderr string, err error) {
+	return RunPowershellStreaming(ctx, client, redactor, elevatedUser, elevatedPassword, vars, commandText, nil)
+}
+
+// RunPowershellStreaming runs powershell like RunPowershell, copying stdout to output, if any, while it runs. A script
+// that ran returns its exit code, stdout and stderr without an error, whatever its exit code.
+func RunPowershellStreaming(ctx context.Context, client *winrm.Client, redactor *redact.Redactor, elevatedUser string, elevatedPassword string, vars string, commandText string, output io.Writer) (exitStatus int, stdout string, stderr string, err error) {
 	name := fmt.Sprintf("terraform-%s", TimeOrderedUUID())
 	fileName := fmt.Sprintf(`shell-%s.ps1`, name)
 
-	path, err := uploadScript(client, fileName, commandText)
+	path, err := uploadScript(ctx, client, redactor, fileName, commandText)
 	if err != nil {
-		return 0, "", "", err
+		return 0, "", "", &notStartedError{err: err}
 	}
 
 	var command string
+	var taskName string
 
 	if elevatedUser == "" {
 		command, err = createCommand(vars, path)
 	} else {
-		command, path, err = createElevatedCommand(client, elevatedUser, elevatedPassword, vars, path)
+		command, path, taskName, err = createElevatedCommand(ctx, client, redactor, elevatedUser, elevatedPassword, vars, path)
 	}
 
 	if err != nil {
-		return 0, "", "", err
+		return 0, "", "", &notStartedError{err: err}
 	}
 
 	var executePowershellFromCommandLineTemplateRendered bytes.Buffer
@@ -606,25 +764,22 @@ func RunPowershell(client *winrm.Client, elevatedUser string, elevatedPassword s
 
 	shell, err := client.CreateShell()
 	if err != nil {
-		return 0, "", "", err
+		return 0, "", "", &notStartedError{err: err}
 	}
 	defer shell.Close()
 
-	commandExitCode, stdOutPut, errorOutPut, err := shellExecute(shell, command)
+	commandExitCode, stdOutPut, errorOutPut, err := shellExecuteStreaming(ctx, shell, redactor, command, output)
 
 	if err != nil {
+		if ctx.Err() != nil {
+			cleanupInterruptedRun(client, redactor, taskName, path)
+		}
 		return 0, "", "", err
 	}
 
-	if commandExitCode != 0 {
-		return 0, "", "", fmt.Errorf("run command operation returned code=%d\nstderr:\n%s\nstdOut:\n%s", commandExitCode, errorOutPut, stdOutPut)
-	}
-
-	if len(errorOutPut) > 0 {
-		return 0, "", "", fmt.Errorf("run command operation returned \nstderr:\n%s\nstdOut:\n%s", errorOutPut, stdOutPut)
-	}
-
-	err = DeleteFileOrDirectory(client, path)
+	// The exit code, stdout and stderr are returned as they are, the caller tells failures apart, such as the errors
+	// reported by wrapped scripts
+	err = DeleteFileOrDirectory(ctx, client, redactor, path)
 	if err != nil {
 		return 0, "", "", fmt.Errorf("error removing temporary file %s: %v", path, err)
 	}
@@ -632,7 +787,7 @@ func RunPowershell(client *winrm.Client, elevatedUser string, elevatedPassword s
 	return commandExitCode, stdOutPut, errorOutPut, nil
 }
 
-func UploadFile(client *winrm.Client, filePath string, remoteFilePath string) (string, error) {
+func UploadFile(ctx context.Context, client *winrm.Client, redactor *redact.Redactor, filePath string, remoteFilePath string) (string, error) {
 	if remoteFilePath == "" {
 		remoteFilePath = winPath(filepath.Join(`$env:TEMP`, filepath.Base(filePath)))
 	}
@@ -642,7 +797,7 @@ func UploadFile(client *winrm.Client, filePath string, remoteFilePath string) (s
 		return "", fmt.Errorf("error opening file: %s", err)
 	}
 
-	remoteFilePath, err = doCopy(client, 15, f, remoteFilePath)
+	remoteFilePath, err = doCopy(ctx, client, redactor, 15, f, remoteFilePath)
 
 	err2 := f.Close()
 
@@ -683,7 +838,7 @@ func getFilesInDirectory(rootPath string, excludeList []string) ([]string, error
 	return fileList, nil
 }
 
-func UploadDirectory(client *winrm.Client, rootPath string, excludeList []string) (remoteRootPath string, remoteAbsolutePaths []string, err error) {
+func UploadDirectory(ctx context.Context, client *winrm.Client, redactor *redact.Redactor, rootPath string, excludeList []string) (remoteRootPath string, remoteAbsolutePaths []string, err error) {
 	sourceFilePaths, err := getFilesInDirectory(rootPath, excludeList)
 	if err != nil {
 		return "", []string{}, err
@@ -705,7 +860,7 @@ func UploadDirectory(client *winrm.Client, rootPath string, excludeList []string
 			return "", []string{}, fmt.Errorf("error opening file: %s", err)
 		}
 
-		remoteFilePath, err = doCopy(client, 15, f, winPath(remoteFilePath))
+		remoteFilePath, err = doCopy(ctx, client, redactor, 15, f, winPath(remoteFilePath))
 
 		err2 := f.Close()
 
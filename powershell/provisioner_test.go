@@ -1,10 +1,16 @@
 package powershell
 
 import (
+	"bytes"
+	"context"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/taliesins/terraform-provider-hyperv/api/redact"
 )
 
 func TestWinPath(t *testing.T) {
@@ -143,3 +149,50 @@ func TestGetFilesInDirectory(t *testing.T) {
 		}
 	})
 }
+
+func TestTaskExecutionTimeLimit(t *testing.T) {
+	t.Parallel()
+
+	if got := taskExecutionTimeLimit(context.Background()); got != defaultTaskExecutionTimeLimit {
+		t.Fatalf("expected default limit %q without a deadline, got %q", defaultTaskExecutionTimeLimit, got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 90*time.Second)
+	defer cancel()
+
+	if got := taskExecutionTimeLimit(ctx); got != "PT90S" && got != "PT89S" {
+		t.Fatalf("expected limit bounded by the deadline, got %q", got)
+	}
+
+	expired, cancelExpired := context.WithDeadline(context.Background(), time.Now().Add(-time.Minute))
+	defer cancelExpired()
+
+	if got := taskExecutionTimeLimit(expired); got != "PT1S" {
+		t.Fatalf("expected minimal limit for an expired deadline, got %q", got)
+	}
+}
+
+func TestRedactingWriter(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	writer := newRedactingWriter(redact.New("P@ssw0rd"), &out)
+
+	// The secret is split between writes, as output streamed from a host can be
+	for _, chunk := range []string{"user Administrator\npassword P@s", "sw0rd\n", "again P@ssw0rd"} {
+		if _, err := writer.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if strings.Contains(out.String(), "again") {
+		t.Fatalf("expected the last line to be held until it is flushed, got %q", out.String())
+	}
+
+	writer.Flush()
+
+	want := "user Administrator\npassword ***\nagain ***"
+	if out.String() != want {
+		t.Fatalf("expected %q, got %q", want, out.String())
+	}
+}
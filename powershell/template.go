@@ -247,3 +247,13 @@ type appendFileTemplateOptions struct {
 
 // This is not a Powershell script
 var appendFileTemplate = template.Must(template.New("AppendFile").Parse(`echo {{.Content}} >> "{{.FilePath}}"`))
+
+type stopElevatedTaskTemplateOptions struct {
+	TaskName string
+}
+
+var stopElevatedTaskTemplate = template.Must(template.New("StopElevatedTask").Funcs(template.FuncMap{
+	"escapeSingleQuotes": func(textToEscape string) string {
+		return strings.ReplaceAll(textToEscape, `'`, `''`)
+	},
+}).Parse(`if (Test-Path variable:global:ProgressPreference){$ProgressPreference='SilentlyContinue';};$schedule = New-Object -ComObject "Schedule.Service";$schedule.Connect();$folder = $schedule.GetFolder('\');try {$task = $folder.GetTask('\{{escapeSingleQuotes .TaskName}}');$task.Stop(0);$folder.DeleteTask('{{escapeSingleQuotes .TaskName}}', 0) | Out-Null;} catch {};[System.Runtime.Interopservices.Marshal]::ReleaseComObject($schedule) | Out-Null;exit 0;`))